@@ -1,13 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/types"
 	"github.com/gorilla/mux"
@@ -42,14 +45,34 @@ const (
 	urlEpID   = "endpoint-id"
 	urlEpPID  = "endpoint-partial-id"
 	urlCnID   = "container-id"
+	urlForce  = "force"
 
 	// BridgeNetworkDriver is the built-in default for Network Driver
 	BridgeNetworkDriver = "bridge"
 )
 
+// Authenticator identifies the caller of a request. The identity it returns
+// is recorded as the actor of the operations made by the request, in the
+// audit log of the networks. An error fails the request as unauthorized.
+type Authenticator func(req *http.Request) (string, error)
+
+// HandlerOption configures the HTTP handler
+type HandlerOption func(h *httpHandler)
+
+// WithAuthenticator makes the HTTP handler authenticate every request
+// before serving it
+func WithAuthenticator(auth Authenticator) HandlerOption {
+	return func(h *httpHandler) {
+		h.auth = auth
+	}
+}
+
 // NewHTTPHandler creates and initialize the HTTP handler to serve the requests for libnetwork
-func NewHTTPHandler(c libnetwork.NetworkController) func(w http.ResponseWriter, req *http.Request) {
+func NewHTTPHandler(c libnetwork.NetworkController, opts ...HandlerOption) func(w http.ResponseWriter, req *http.Request) {
 	h := &httpHandler{c: c}
+	for _, opt := range opts {
+		opt(h)
+	}
 	h.initRouter()
 	return h.handleRequest
 }
@@ -63,14 +86,27 @@ func (r *responseStatus) isOK() bool {
 	return r.StatusCode == http.StatusOK || r.StatusCode == http.StatusCreated
 }
 
-type processor func(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus)
+// processor handles a request; ctx is the context of the request, carrying
+// the identity of the caller when it was authenticated
+type processor func(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus)
 
 type httpHandler struct {
-	c libnetwork.NetworkController
-	r *mux.Router
+	c    libnetwork.NetworkController
+	r    *mux.Router
+	auth Authenticator
 }
 
 func (h *httpHandler) handleRequest(w http.ResponseWriter, req *http.Request) {
+	// Nothing is revealed to the callers which are not authenticated
+	if h.auth != nil {
+		actor, err := h.auth(req)
+		if err != nil {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		req = req.WithContext(libnetwork.WithActor(req.Context(), actor))
+	}
+
 	// Make sure the service is there
 	if h.c == nil {
 		http.Error(w, "NetworkController is not available", http.StatusServiceUnavailable)
@@ -93,6 +129,9 @@ func (h *httpHandler) initRouter() {
 			{"/networks", []string{"partial-id", nwPID}, procGetNetworks},
 			{"/networks", nil, procGetNetworks},
 			{"/networks/" + nwID, nil, procGetNetwork},
+			{"/networks/" + nwID + "/audit", nil, procGetNetworkAudit},
+			{"/networks/" + nwID + "/state", nil, procGetNetworkState},
+			{"/networks/" + nwID + "/drift", nil, procGetNetworkDrift},
 			{"/networks/" + nwID + "/endpoints", []string{"name", epName}, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints", []string{"partial-id", epPID}, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints", nil, procGetEndpoints},
@@ -103,20 +142,31 @@ func (h *httpHandler) initRouter() {
 			{"/services", nil, procGetServices},
 			{"/services/" + epID, nil, procGetService},
 			{"/services/" + epID + "/backend", nil, procGetContainers},
+			{"/sandboxes", nil, procGetSandboxes},
+			{"/sandboxes/" + cnID, nil, procGetSandbox},
+			{"/audit", nil, procGetAuditLogs},
+			{"/snapshot", nil, procGetSnapshot},
 		},
 		"POST": {
 			{"/networks", nil, procCreateNetwork},
 			{"/networks/" + nwID + "/endpoints", nil, procCreateEndpoint},
 			{"/networks/" + nwID + "/endpoints/" + epID + "/containers", nil, procJoinEndpoint},
+			{"/networks/" + nwID + "/endpoints/" + epID + "/ports", nil, procActivateEndpointPorts},
+			{"/networks/" + nwID + "/endpoints/" + epID + "/activation", nil, procActivateEndpoint},
 			{"/services", nil, procPublishService},
 			{"/services/" + epID + "/backend", nil, procAttachBackend},
+			{"/sandboxes/" + cnID + "/connectivity", nil, procSetSandboxConnectivity},
 		},
 		"DELETE": {
 			{"/networks/" + nwID, nil, procDeleteNetwork},
+			// Order matters
+			{"/networks/" + nwID + "/endpoints/" + epID, []string{"force", "{" + urlForce + "}"}, procDeleteEndpoint},
 			{"/networks/" + nwID + "/endpoints/" + epID, nil, procDeleteEndpoint},
 			{"/networks/" + nwID + "/endpoints/" + epID + "/containers/" + cnID, nil, procLeaveEndpoint},
+			{"/networks/" + nwID + "/endpoints/" + epID + "/activation", nil, procDeactivateEndpoint},
 			{"/services/" + epID, nil, procUnpublishService},
 			{"/services/" + epID + "/backend/" + cnID, nil, procDetachBackend},
+			{"/sandboxes/" + cnID, nil, procDeleteSandbox},
 		},
 	}
 
@@ -151,6 +201,9 @@ func makeHandler(ctrl libnetwork.NetworkController, fct processor) http.HandlerF
 		}
 
 		mvars := mux.Vars(req)
+		if mvars == nil {
+			mvars = map[string]string{}
+		}
 		rvars := req.URL.Query()
 		// workaround a mux issue which filters out valid queries with empty value
 		for k := range rvars {
@@ -161,7 +214,7 @@ func makeHandler(ctrl libnetwork.NetworkController, fct processor) http.HandlerF
 			}
 		}
 
-		res, rsp := fct(ctrl, mvars, body)
+		res, rsp := fct(req.Context(), ctrl, mvars, body)
 		if !rsp.isOK() {
 			http.Error(w, rsp.Status, rsp.StatusCode)
 			return
@@ -182,6 +235,8 @@ func buildNetworkResource(nw libnetwork.Network) *networkResource {
 		r.Name = nw.Name()
 		r.ID = nw.ID()
 		r.Type = nw.Type()
+		r.ConfigOnly = nw.ConfigOnly()
+		r.ConfigFrom = nw.ConfigFrom()
 		epl := nw.Endpoints()
 		r.Endpoints = make([]*endpointResource, 0, len(epl))
 		for _, e := range epl {
@@ -210,6 +265,31 @@ func buildContainerResource(ci libnetwork.ContainerInfo) *containerResource {
 	return r
 }
 
+// buildSandboxResources returns the sandboxes of the containers which
+// joined endpoints, with their endpoints
+func buildSandboxResources(c libnetwork.NetworkController) []*sandboxResource {
+	var (
+		list       = []*sandboxResource{}
+		containers = map[string]*sandboxResource{}
+	)
+	for _, nw := range c.Networks() {
+		for _, ep := range nw.Endpoints() {
+			ci := ep.ContainerInfo()
+			if ci == nil {
+				continue
+			}
+			sb, ok := containers[ci.ID()]
+			if !ok {
+				sb = &sandboxResource{ContainerID: ci.ID(), Key: ep.Info().SandboxKey()}
+				containers[ci.ID()] = sb
+				list = append(list, sb)
+			}
+			sb.Endpoints = append(sb.Endpoints, buildEndpointResource(ep))
+		}
+	}
+	return list
+}
+
 /****************
  Options Parsers
 *****************/
@@ -220,6 +300,18 @@ func (nc *networkCreate) parseOptions() []libnetwork.NetworkOption {
 	if nc.Options != nil {
 		setFctList = append(setFctList, libnetwork.NetworkOptionGeneric(nc.Options))
 	}
+	if nc.GlobalScope {
+		setFctList = append(setFctList, libnetwork.NetworkOptionGlobalScope())
+	}
+	if nc.ConfigOnly {
+		setFctList = append(setFctList, libnetwork.NetworkOptionConfigOnly())
+	}
+	if nc.ConfigFrom != "" {
+		setFctList = append(setFctList, libnetwork.NetworkOptionConfigFrom(nc.ConfigFrom))
+	}
+	if nc.MaxEndpoints != 0 {
+		setFctList = append(setFctList, libnetwork.NetworkOptionMaxEndpoints(nc.MaxEndpoints))
+	}
 
 	return setFctList
 }
@@ -290,7 +382,7 @@ func processCreateDefaults(c libnetwork.NetworkController, nc *networkCreate) {
 /***************************
  NetworkController interface
 ****************************/
-func procCreateNetwork(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procCreateNetwork(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var create networkCreate
 
 	err := json.Unmarshal(body, &create)
@@ -299,7 +391,7 @@ func procCreateNetwork(c libnetwork.NetworkController, vars map[string]string, b
 	}
 	processCreateDefaults(c, &create)
 
-	nw, err := c.NewNetwork(create.NetworkType, create.Name, create.parseOptions()...)
+	nw, err := c.NewNetwork(ctx, create.NetworkType, create.Name, create.parseOptions()...)
 	if err != nil {
 		return "", convertNetworkError(err)
 	}
@@ -307,7 +399,7 @@ func procCreateNetwork(c libnetwork.NetworkController, vars map[string]string, b
 	return nw.ID(), &createdResponse
 }
 
-func procGetNetwork(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetNetwork(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	t, by := detectNetworkTarget(vars)
 	nw, errRsp := findNetwork(c, t, by)
 	if !errRsp.isOK() {
@@ -316,7 +408,66 @@ func procGetNetwork(c libnetwork.NetworkController, vars map[string]string, body
 	return buildNetworkResource(nw), &successResponse
 }
 
-func procGetNetworks(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetNetworkAudit(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	t, by := detectNetworkTarget(vars)
+	nw, errRsp := findNetwork(c, t, by)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	records, err := nw.AuditLog()
+	if err != nil {
+		return nil, &responseStatus{Status: err.Error(), StatusCode: http.StatusInternalServerError}
+	}
+	return records, &successResponse
+}
+
+func procGetAuditLogs(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	logs, err := c.AuditLogs()
+	if err != nil {
+		return nil, &responseStatus{Status: err.Error(), StatusCode: http.StatusInternalServerError}
+	}
+	return logs, &successResponse
+}
+
+func procGetSnapshot(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	b, err := c.Snapshot()
+	if err != nil {
+		return nil, &responseStatus{Status: err.Error(), StatusCode: http.StatusInternalServerError}
+	}
+	snap := json.RawMessage(b)
+	return &snap, &successResponse
+}
+
+func procGetNetworkState(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	t, by := detectNetworkTarget(vars)
+	nw, errRsp := findNetwork(c, t, by)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	st, err := nw.ProgrammedState()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return st, &successResponse
+}
+
+func procGetNetworkDrift(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	t, by := detectNetworkTarget(vars)
+	nw, errRsp := findNetwork(c, t, by)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	drifts, err := nw.VerifyState()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+	if drifts == nil {
+		drifts = []driverapi.Drift{}
+	}
+	return drifts, &successResponse
+}
+
+func procGetNetworks(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var list []*networkResource
 
 	// Look for query filters and validate
@@ -351,7 +502,7 @@ func procGetNetworks(c libnetwork.NetworkController, vars map[string]string, bod
 /******************
  Network interface
 *******************/
-func procCreateEndpoint(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procCreateEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var ec endpointCreate
 
 	err := json.Unmarshal(body, &ec)
@@ -372,8 +523,17 @@ func procCreateEndpoint(c libnetwork.NetworkController, vars map[string]string,
 	if ec.PortMapping != nil {
 		setFctList = append(setFctList, libnetwork.CreateOptionPortMapping(ec.PortMapping))
 	}
+	if ec.DeferPortBinding {
+		setFctList = append(setFctList, libnetwork.CreateOptionDeferPortBinding())
+	}
+	if ec.ServiceRegistration != "" {
+		setFctList = append(setFctList, libnetwork.CreateOptionServiceRegistration(libnetwork.ServiceRegistration(ec.ServiceRegistration)))
+	}
+	if ec.PerfProfile != "" {
+		setFctList = append(setFctList, libnetwork.CreateOptionPerfProfile(ec.PerfProfile, ec.PerfCPUs))
+	}
 
-	ep, err := n.CreateEndpoint(ec.Name, setFctList...)
+	ep, err := n.CreateEndpoint(ctx, ec.Name, setFctList...)
 	if err != nil {
 		return "", convertNetworkError(err)
 	}
@@ -381,7 +541,7 @@ func procCreateEndpoint(c libnetwork.NetworkController, vars map[string]string,
 	return ep.ID(), &createdResponse
 }
 
-func procGetEndpoint(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	nwT, nwBy := detectNetworkTarget(vars)
 	epT, epBy := detectEndpointTarget(vars)
 
@@ -393,7 +553,7 @@ func procGetEndpoint(c libnetwork.NetworkController, vars map[string]string, bod
 	return buildEndpointResource(ep), &successResponse
 }
 
-func procGetEndpoints(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetEndpoints(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	// Look for query filters and validate
 	name, queryByName := vars[urlEpName]
 	shortID, queryByPid := vars[urlEpPID]
@@ -433,7 +593,7 @@ func procGetEndpoints(c libnetwork.NetworkController, vars map[string]string, bo
 	return list, &successResponse
 }
 
-func procDeleteNetwork(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procDeleteNetwork(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	target, by := detectNetworkTarget(vars)
 
 	nw, errRsp := findNetwork(c, target, by)
@@ -441,7 +601,7 @@ func procDeleteNetwork(c libnetwork.NetworkController, vars map[string]string, b
 		return nil, errRsp
 	}
 
-	err := nw.Delete()
+	err := nw.Delete(ctx)
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
@@ -452,7 +612,7 @@ func procDeleteNetwork(c libnetwork.NetworkController, vars map[string]string, b
 /******************
  Endpoint interface
 *******************/
-func procJoinEndpoint(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procJoinEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var ej endpointJoin
 	err := json.Unmarshal(body, &ej)
 	if err != nil {
@@ -467,14 +627,59 @@ func procJoinEndpoint(c libnetwork.NetworkController, vars map[string]string, bo
 		return nil, errRsp
 	}
 
-	err = ep.Join(ej.ContainerID, ej.parseOptions()...)
+	err = ep.Join(ctx, ej.ContainerID, ej.parseOptions()...)
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
 	return ep.Info().SandboxKey(), &successResponse
 }
 
-func procLeaveEndpoint(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procActivateEndpointPorts(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nwT, nwBy := detectNetworkTarget(vars)
+	epT, epBy := detectEndpointTarget(vars)
+
+	ep, errRsp := findEndpoint(c, nwT, epT, nwBy, epBy)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	if err := ep.ActivatePorts(ctx); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return nil, &successResponse
+}
+
+func procActivateEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nwT, nwBy := detectNetworkTarget(vars)
+	epT, epBy := detectEndpointTarget(vars)
+
+	ep, errRsp := findEndpoint(c, nwT, epT, nwBy, epBy)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	if err := ep.Activate(ctx); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return nil, &successResponse
+}
+
+func procDeactivateEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nwT, nwBy := detectNetworkTarget(vars)
+	epT, epBy := detectEndpointTarget(vars)
+
+	ep, errRsp := findEndpoint(c, nwT, epT, nwBy, epBy)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	if err := ep.Deactivate(ctx); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return nil, &successResponse
+}
+
+func procLeaveEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	nwT, nwBy := detectNetworkTarget(vars)
 	epT, epBy := detectEndpointTarget(vars)
 
@@ -483,7 +688,7 @@ func procLeaveEndpoint(c libnetwork.NetworkController, vars map[string]string, b
 		return nil, errRsp
 	}
 
-	err := ep.Leave(vars[urlCnID])
+	err := ep.Leave(ctx, vars[urlCnID])
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
@@ -491,7 +696,7 @@ func procLeaveEndpoint(c libnetwork.NetworkController, vars map[string]string, b
 	return nil, &successResponse
 }
 
-func procDeleteEndpoint(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procDeleteEndpoint(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	nwT, nwBy := detectNetworkTarget(vars)
 	epT, epBy := detectEndpointTarget(vars)
 
@@ -500,8 +705,23 @@ func procDeleteEndpoint(c libnetwork.NetworkController, vars map[string]string,
 		return nil, errRsp
 	}
 
-	err := ep.Delete()
+	var options []libnetwork.DeleteOption
+	if v, ok := vars[urlForce]; ok {
+		force, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &badQueryResponse
+		}
+		if force {
+			options = append(options, libnetwork.DeleteOptionForce())
+		}
+	}
+
+	err := ep.Delete(ctx, options...)
 	if err != nil {
+		// The forced deletion succeeded, the skipped cleanups are returned
+		if ide, ok := err.(*libnetwork.IncompleteDeleteError); ok {
+			return ide.Skipped, &successResponse
+		}
 		return nil, convertNetworkError(err)
 	}
 
@@ -511,7 +731,7 @@ func procDeleteEndpoint(c libnetwork.NetworkController, vars map[string]string,
 /******************
  Service interface
 *******************/
-func procGetServices(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetServices(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	// Look for query filters and validate
 	nwName, filterByNwName := vars[urlNwName]
 	svName, queryBySvName := vars[urlEpName]
@@ -569,7 +789,7 @@ func procGetServices(c libnetwork.NetworkController, vars map[string]string, bod
 	return list, &successResponse
 }
 
-func procGetService(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetService(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	epT, epBy := detectEndpointTarget(vars)
 	sv, errRsp := findService(c, epT, epBy)
 	if !errRsp.isOK() {
@@ -578,7 +798,7 @@ func procGetService(c libnetwork.NetworkController, vars map[string]string, body
 	return buildEndpointResource(sv), &successResponse
 }
 
-func procGetContainers(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procGetContainers(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	epT, epBy := detectEndpointTarget(vars)
 	sv, errRsp := findService(c, epT, epBy)
 	if !errRsp.isOK() {
@@ -591,7 +811,7 @@ func procGetContainers(c libnetwork.NetworkController, vars map[string]string, b
 	return list, &successResponse
 }
 
-func procPublishService(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procPublishService(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var sp servicePublish
 
 	err := json.Unmarshal(body, &sp)
@@ -612,7 +832,7 @@ func procPublishService(c libnetwork.NetworkController, vars map[string]string,
 		setFctList = append(setFctList, libnetwork.CreateOptionPortMapping(sp.PortMapping))
 	}
 
-	ep, err := n.CreateEndpoint(sp.Name, setFctList...)
+	ep, err := n.CreateEndpoint(ctx, sp.Name, setFctList...)
 	if err != nil {
 		return "", endpointToService(convertNetworkError(err))
 	}
@@ -620,20 +840,20 @@ func procPublishService(c libnetwork.NetworkController, vars map[string]string,
 	return ep.ID(), &createdResponse
 }
 
-func procUnpublishService(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procUnpublishService(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	epT, epBy := detectEndpointTarget(vars)
 	sv, errRsp := findService(c, epT, epBy)
 	if !errRsp.isOK() {
 		return nil, errRsp
 	}
-	err := sv.Delete()
+	err := sv.Delete(ctx)
 	if err != nil {
 		return nil, endpointToService(convertNetworkError(err))
 	}
 	return nil, &successResponse
 }
 
-func procAttachBackend(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procAttachBackend(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var bk endpointJoin
 	err := json.Unmarshal(body, &bk)
 	if err != nil {
@@ -646,21 +866,21 @@ func procAttachBackend(c libnetwork.NetworkController, vars map[string]string, b
 		return nil, errRsp
 	}
 
-	err = sv.Join(bk.ContainerID, bk.parseOptions()...)
+	err = sv.Join(ctx, bk.ContainerID, bk.parseOptions()...)
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
 	return sv.Info().SandboxKey(), &successResponse
 }
 
-func procDetachBackend(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+func procDetachBackend(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	epT, epBy := detectEndpointTarget(vars)
 	sv, errRsp := findService(c, epT, epBy)
 	if !errRsp.isOK() {
 		return nil, errRsp
 	}
 
-	err := sv.Leave(vars[urlCnID])
+	err := sv.Leave(ctx, vars[urlCnID])
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
@@ -668,9 +888,58 @@ func procDetachBackend(c libnetwork.NetworkController, vars map[string]string, b
 	return nil, &successResponse
 }
 
+func procGetSandboxes(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	return buildSandboxResources(c), &successResponse
+}
+
+func procGetSandbox(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	sb, errRsp := findSandbox(c, vars[urlCnID])
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return sb, &successResponse
+}
+
+func procDeleteSandbox(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	sb, errRsp := findSandbox(c, vars[urlCnID])
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	if err := c.LeaveAll(ctx, sb.ContainerID); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return nil, &successResponse
+}
+
+func procSetSandboxConnectivity(ctx context.Context, c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	var sc sandboxConnectivity
+	if err := json.Unmarshal(body, &sc); err != nil {
+		return nil, &responseStatus{Status: "Invalid body: " + err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	sb, errRsp := findSandbox(c, vars[urlCnID])
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	if err := c.SetExternalConnectivity(ctx, sb.ContainerID, sc.Enable); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return nil, &successResponse
+}
+
 /***********
   Utilities
 ************/
+
+func findSandbox(c libnetwork.NetworkController, containerID string) (*sandboxResource, *responseStatus) {
+	for _, sb := range buildSandboxResources(c) {
+		if sb.ContainerID == containerID {
+			return sb, &successResponse
+		}
+	}
+	return nil, &responseStatus{Status: "Resource not found: Sandbox", StatusCode: http.StatusNotFound}
+}
+
 const (
 	byID = iota
 	byName
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -84,7 +85,7 @@ func createTestNetwork(t *testing.T, network string) (libnetwork.NetworkControll
 		t.Fatal(err)
 	}
 
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -96,7 +97,7 @@ func createTestNetwork(t *testing.T, network string) (libnetwork.NetworkControll
 		},
 	}
 	netGeneric := libnetwork.NetworkOptionGeneric(netOption)
-	nw, err := c.NewNetwork(bridgeNetType, network, netGeneric)
+	nw, err := c.NewNetwork(context.Background(), bridgeNetType, network, netGeneric)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,7 +179,7 @@ func TestCreateDeleteNetwork(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -189,7 +190,7 @@ func TestCreateDeleteNetwork(t *testing.T) {
 	}
 
 	vars := make(map[string]string)
-	_, errRsp := procCreateNetwork(c, nil, badBody)
+	_, errRsp := procCreateNetwork(context.Background(), c, nil, badBody)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -202,7 +203,7 @@ func TestCreateDeleteNetwork(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, errRsp = procCreateNetwork(c, vars, incompleteBody)
+	_, errRsp = procCreateNetwork(context.Background(), c, vars, incompleteBody)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -225,25 +226,25 @@ func TestCreateDeleteNetwork(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, errRsp = procCreateNetwork(c, vars, goodBody)
+	_, errRsp = procCreateNetwork(context.Background(), c, vars, goodBody)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 
 	vars[urlNwName] = ""
-	_, errRsp = procDeleteNetwork(c, vars, nil)
+	_, errRsp = procDeleteNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
 
 	vars[urlNwName] = "abc"
-	_, errRsp = procDeleteNetwork(c, vars, nil)
+	_, errRsp = procDeleteNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
 
 	vars[urlNwName] = "network_1"
-	_, errRsp = procDeleteNetwork(c, vars, nil)
+	_, errRsp = procDeleteNetwork(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -256,7 +257,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -275,7 +276,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	}
 
 	vars := make(map[string]string)
-	inid, errRsp := procCreateNetwork(c, vars, body)
+	inid, errRsp := procCreateNetwork(context.Background(), c, vars, body)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -309,13 +310,13 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 
 	vars[urlNwName] = "sh"
 	vars[urlEpName] = "ep1"
-	ieid1, errRsp := procCreateEndpoint(c, vars, b1)
+	ieid1, errRsp := procCreateEndpoint(context.Background(), c, vars, b1)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	eid1 := i2s(ieid1)
 	vars[urlEpName] = "ep2"
-	ieid2, errRsp := procCreateEndpoint(c, vars, b2)
+	ieid2, errRsp := procCreateEndpoint(context.Background(), c, vars, b2)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -323,7 +324,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 
 	vars[urlNwName] = ""
 	vars[urlEpName] = "ep1"
-	_, errRsp = procGetEndpoint(c, vars, nil)
+	_, errRsp = procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure but succeeded: %v", errRsp)
 	}
@@ -334,7 +335,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	vars = make(map[string]string)
 	vars[urlNwName] = "sh"
 	vars[urlEpID] = ""
-	_, errRsp = procGetEndpoint(c, vars, nil)
+	_, errRsp = procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure but succeeded: %v", errRsp)
 	}
@@ -345,7 +346,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	vars = make(map[string]string)
 	vars[urlNwID] = ""
 	vars[urlEpID] = eid1
-	_, errRsp = procGetEndpoint(c, vars, nil)
+	_, errRsp = procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure but succeeded: %v", errRsp)
 	}
@@ -355,28 +356,28 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 
 	// nw by name and ep by id
 	vars[urlNwName] = "sh"
-	i1, errRsp := procGetEndpoint(c, vars, nil)
+	i1, errRsp := procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	// nw by name and ep by name
 	delete(vars, urlEpID)
 	vars[urlEpName] = "ep1"
-	i2, errRsp := procGetEndpoint(c, vars, nil)
+	i2, errRsp := procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	// nw by id and ep by name
 	delete(vars, urlNwName)
 	vars[urlNwID] = nid
-	i3, errRsp := procGetEndpoint(c, vars, nil)
+	i3, errRsp := procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	// nw by id and ep by id
 	delete(vars, urlEpName)
 	vars[urlEpID] = eid1
-	i4, errRsp := procGetEndpoint(c, vars, nil)
+	i4, errRsp := procGetEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -387,26 +388,26 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	}
 
 	vars[urlNwName] = ""
-	_, errRsp = procGetEndpoints(c, vars, nil)
+	_, errRsp = procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	delete(vars, urlNwName)
 	vars[urlNwID] = "fakeID"
-	_, errRsp = procGetEndpoints(c, vars, nil)
+	_, errRsp = procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlNwID] = nid
-	_, errRsp = procGetEndpoints(c, vars, nil)
+	_, errRsp = procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 
 	vars[urlNwName] = "sh"
-	iepList, errRsp := procGetEndpoints(c, vars, nil)
+	iepList, errRsp := procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -420,17 +421,17 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 
 	vars = make(map[string]string)
 	vars[urlNwName] = ""
-	_, errRsp = procGetNetwork(c, vars, nil)
+	_, errRsp = procGetNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Exepected failure, got: %v", errRsp)
 	}
 	vars[urlNwName] = "shhhhh"
-	_, errRsp = procGetNetwork(c, vars, nil)
+	_, errRsp = procGetNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Exepected failure, got: %v", errRsp)
 	}
 	vars[urlNwName] = "sh"
-	inr1, errRsp := procGetNetwork(c, vars, nil)
+	inr1, errRsp := procGetNetwork(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -438,12 +439,12 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 
 	delete(vars, urlNwName)
 	vars[urlNwID] = "cacca"
-	_, errRsp = procGetNetwork(c, vars, nil)
+	_, errRsp = procGetNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	vars[urlNwID] = nid
-	inr2, errRsp := procGetNetwork(c, vars, nil)
+	inr2, errRsp := procGetNetwork(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("procgetNetworkByName() != procgetNetworkById(), %v vs %v", inr1, inr2)
 	}
@@ -461,7 +462,7 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 		}
 	}
 
-	iList, errRsp := procGetNetworks(c, nil, nil)
+	iList, errRsp := procGetNetworks(context.Background(), c, nil, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -473,18 +474,18 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 		t.Fatalf("Did not find expected network %s: %v", nid, netList)
 	}
 
-	_, errRsp = procDeleteNetwork(c, vars, nil)
+	_, errRsp = procDeleteNetwork(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Exepected failure, got: %v", errRsp)
 	}
 
 	vars[urlEpName] = "ep1"
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 	delete(vars, urlEpName)
-	iepList, errRsp = procGetEndpoints(c, vars, nil)
+	iepList, errRsp = procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -494,11 +495,11 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 	}
 
 	vars[urlEpName] = "ep2"
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
-	iepList, errRsp = procGetEndpoints(c, vars, nil)
+	iepList, errRsp = procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -507,12 +508,12 @@ func TestGetNetworksAndEndpoints(t *testing.T) {
 		t.Fatalf("Did not return the expected number (0) of endpoint resources: %d", len(epList))
 	}
 
-	_, errRsp = procDeleteNetwork(c, vars, nil)
+	_, errRsp = procDeleteNetwork(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 
-	iList, errRsp = procGetNetworks(c, nil, nil)
+	iList, errRsp = procGetNetworks(context.Background(), c, nil, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -530,7 +531,7 @@ func TestProcGetServices(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -543,7 +544,7 @@ func TestProcGetServices(t *testing.T) {
 			"AllowNonDefaultBridge": true,
 		},
 	}
-	nw1, err := c.NewNetwork(bridgeNetType, netName1, libnetwork.NetworkOptionGeneric(netOption))
+	nw1, err := c.NewNetwork(context.Background(), bridgeNetType, netName1, libnetwork.NetworkOptionGeneric(netOption))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -555,13 +556,13 @@ func TestProcGetServices(t *testing.T) {
 			"AllowNonDefaultBridge": true,
 		},
 	}
-	nw2, err := c.NewNetwork(bridgeNetType, netName2, libnetwork.NetworkOptionGeneric(netOption))
+	nw2, err := c.NewNetwork(context.Background(), bridgeNetType, netName2, libnetwork.NetworkOptionGeneric(netOption))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	vars := make(map[string]string)
-	li, errRsp := procGetServices(c, vars, nil)
+	li, errRsp := procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -571,20 +572,20 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	// Add a couple of services on one network and one on the other network
-	ep11, err := nw1.CreateEndpoint("db-prod")
+	ep11, err := nw1.CreateEndpoint(context.Background(), "db-prod")
 	if err != nil {
 		t.Fatal(err)
 	}
-	ep12, err := nw1.CreateEndpoint("web-prod")
+	ep12, err := nw1.CreateEndpoint(context.Background(), "web-prod")
 	if err != nil {
 		t.Fatal(err)
 	}
-	ep21, err := nw2.CreateEndpoint("db-dev")
+	ep21, err := nw2.CreateEndpoint(context.Background(), "db-dev")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -595,7 +596,7 @@ func TestProcGetServices(t *testing.T) {
 
 	// Filter by network
 	vars[urlNwName] = netName1
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -605,7 +606,7 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	vars[urlNwName] = netName2
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -615,7 +616,7 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	vars[urlNwName] = "unknown-network"
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -627,7 +628,7 @@ func TestProcGetServices(t *testing.T) {
 	// Query by name
 	delete(vars, urlNwName)
 	vars[urlEpName] = "db-prod"
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -637,7 +638,7 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	vars[urlEpName] = "no-service"
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -649,7 +650,7 @@ func TestProcGetServices(t *testing.T) {
 	// Query by id or partial id
 	delete(vars, urlEpName)
 	vars[urlEpPID] = ep12.ID()
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -662,7 +663,7 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	vars[urlEpPID] = "non-id"
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -672,20 +673,20 @@ func TestProcGetServices(t *testing.T) {
 	}
 
 	delete(vars, urlEpPID)
-	err = ep11.Delete()
+	err = ep11.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ep12.Delete()
+	err = ep12.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ep21.Delete()
+	err = ep21.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	li, errRsp = procGetServices(c, vars, nil)
+	li, errRsp = procGetServices(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -699,17 +700,17 @@ func TestProcGetService(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 
 	c, nw := createTestNetwork(t, "network")
-	ep1, err := nw.CreateEndpoint("db")
+	ep1, err := nw.CreateEndpoint(context.Background(), "db")
 	if err != nil {
 		t.Fatal(err)
 	}
-	ep2, err := nw.CreateEndpoint("web")
+	ep2, err := nw.CreateEndpoint(context.Background(), "web")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	vars := map[string]string{urlEpID: ""}
-	_, errRsp := procGetService(c, vars, nil)
+	_, errRsp := procGetService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure, but suceeded")
 	}
@@ -718,7 +719,7 @@ func TestProcGetService(t *testing.T) {
 	}
 
 	vars[urlEpID] = "unknown-service-id"
-	_, errRsp = procGetService(c, vars, nil)
+	_, errRsp = procGetService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure, but suceeded")
 	}
@@ -727,7 +728,7 @@ func TestProcGetService(t *testing.T) {
 	}
 
 	vars[urlEpID] = ep1.ID()
-	si, errRsp := procGetService(c, vars, nil)
+	si, errRsp := procGetService(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -737,7 +738,7 @@ func TestProcGetService(t *testing.T) {
 	}
 
 	vars[urlEpID] = ep2.ID()
-	si, errRsp = procGetService(c, vars, nil)
+	si, errRsp = procGetService(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -757,7 +758,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp := procPublishService(c, vars, vbad)
+	_, errRsp := procPublishService(context.Background(), c, vars, vbad)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -769,7 +770,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procPublishService(c, vars, b)
+	_, errRsp = procPublishService(context.Background(), c, vars, b)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -781,7 +782,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procPublishService(c, vars, b)
+	_, errRsp = procPublishService(context.Background(), c, vars, b)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -793,7 +794,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procPublishService(c, vars, b)
+	_, errRsp = procPublishService(context.Background(), c, vars, b)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -805,7 +806,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procPublishService(c, vars, b)
+	_, errRsp = procPublishService(context.Background(), c, vars, b)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -817,7 +818,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procPublishService(c, vars, b)
+	_, errRsp = procPublishService(context.Background(), c, vars, b)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
@@ -840,14 +841,14 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	si, errRsp := procPublishService(c, vars, b)
+	si, errRsp := procPublishService(context.Background(), c, vars, b)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
 	sid := i2s(si)
 
 	vars[urlEpID] = ""
-	_, errRsp = procUnpublishService(c, vars, nil)
+	_, errRsp = procUnpublishService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure but succeeded")
 	}
@@ -856,7 +857,7 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	}
 
 	vars[urlEpID] = "unknown-service-id"
-	_, errRsp = procUnpublishService(c, vars, nil)
+	_, errRsp = procUnpublishService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure but succeeded")
 	}
@@ -865,12 +866,12 @@ func TestProcPublishUnpublishService(t *testing.T) {
 	}
 
 	vars[urlEpID] = sid
-	_, errRsp = procUnpublishService(c, vars, nil)
+	_, errRsp = procUnpublishService(context.Background(), c, vars, nil)
 	if !errRsp.isOK() {
 		t.Fatalf("Unexpected failure: %v", errRsp)
 	}
 
-	_, errRsp = procGetService(c, vars, nil)
+	_, errRsp = procGetService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure, but suceeded")
 	}
@@ -883,7 +884,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 
 	c, nw := createTestNetwork(t, "network")
-	ep1, err := nw.CreateEndpoint("db")
+	ep1, err := nw.CreateEndpoint(context.Background(), "db")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -894,7 +895,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp := procAttachBackend(c, vars, vbad)
+	_, errRsp := procAttachBackend(context.Background(), c, vars, vbad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -904,7 +905,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procAttachBackend(c, vars, bad)
+	_, errRsp = procAttachBackend(context.Background(), c, vars, bad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -912,7 +913,7 @@ func TestAttachDetachBackend(t *testing.T) {
 		t.Fatalf("Expected %d. Got: %v", http.StatusNotFound, errRsp)
 	}
 
-	_, errRsp = procGetContainers(c, vars, nil)
+	_, errRsp = procGetContainers(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure. Got %v", errRsp)
 	}
@@ -921,7 +922,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	}
 
 	vars[urlEpName] = "db"
-	_, errRsp = procAttachBackend(c, vars, bad)
+	_, errRsp = procAttachBackend(context.Background(), c, vars, bad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -936,12 +937,12 @@ func TestAttachDetachBackend(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, errRsp = procAttachBackend(c, vars, jlb)
+	_, errRsp = procAttachBackend(context.Background(), c, vars, jlb)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexpected failure, got: %v", errRsp)
 	}
 
-	cli, errRsp := procGetContainers(c, vars, nil)
+	cli, errRsp := procGetContainers(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexpected failure, got: %v", errRsp)
 	}
@@ -953,7 +954,7 @@ func TestAttachDetachBackend(t *testing.T) {
 		t.Fatalf("Did not find expected container attached to the service: %v", cl[0])
 	}
 
-	_, errRsp = procUnpublishService(c, vars, nil)
+	_, errRsp = procUnpublishService(context.Background(), c, vars, nil)
 	if errRsp.isOK() {
 		t.Fatalf("Expected failure but succeeded")
 	}
@@ -962,7 +963,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	}
 
 	vars[urlEpName] = "endpoint"
-	_, errRsp = procDetachBackend(c, vars, nil)
+	_, errRsp = procDetachBackend(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -971,7 +972,7 @@ func TestAttachDetachBackend(t *testing.T) {
 	}
 
 	vars[urlEpName] = "db"
-	_, errRsp = procDetachBackend(c, vars, nil)
+	_, errRsp = procDetachBackend(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -980,12 +981,12 @@ func TestAttachDetachBackend(t *testing.T) {
 	}
 
 	vars[urlCnID] = cid
-	_, errRsp = procDetachBackend(c, vars, nil)
+	_, errRsp = procDetachBackend(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexpected failure, got: %v", errRsp)
 	}
 
-	cli, errRsp = procGetContainers(c, vars, nil)
+	cli, errRsp = procGetContainers(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexpected failure, got: %v", errRsp)
 	}
@@ -994,7 +995,7 @@ func TestAttachDetachBackend(t *testing.T) {
 		t.Fatalf("Did not find expected number of containers attached to the service: %d", len(cl))
 	}
 
-	err = ep1.Delete()
+	err = ep1.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1007,7 +1008,7 @@ func TestDetectGetNetworksInvalidQueryComposition(t *testing.T) {
 	}
 
 	vars := map[string]string{urlNwName: "x", urlNwPID: "y"}
-	_, errRsp := procGetNetworks(c, vars, nil)
+	_, errRsp := procGetNetworks(context.Background(), c, vars, nil)
 	if errRsp.StatusCode != http.StatusBadRequest {
 		t.Fatalf("Expected %d. Got: %v", http.StatusBadRequest, errRsp)
 	}
@@ -1019,7 +1020,7 @@ func TestDetectGetEndpointsInvalidQueryComposition(t *testing.T) {
 	c, _ := createTestNetwork(t, "network")
 
 	vars := map[string]string{urlNwName: "network", urlEpName: "x", urlEpPID: "y"}
-	_, errRsp := procGetEndpoints(c, vars, nil)
+	_, errRsp := procGetEndpoints(context.Background(), c, vars, nil)
 	if errRsp.StatusCode != http.StatusBadRequest {
 		t.Fatalf("Expected %d. Got: %v", http.StatusBadRequest, errRsp)
 	}
@@ -1031,7 +1032,7 @@ func TestDetectGetServicesInvalidQueryComposition(t *testing.T) {
 	c, _ := createTestNetwork(t, "network")
 
 	vars := map[string]string{urlNwName: "network", urlEpName: "x", urlEpPID: "y"}
-	_, errRsp := procGetServices(c, vars, nil)
+	_, errRsp := procGetServices(context.Background(), c, vars, nil)
 	if errRsp.StatusCode != http.StatusBadRequest {
 		t.Fatalf("Expected %d. Got: %v", http.StatusBadRequest, errRsp)
 	}
@@ -1084,7 +1085,7 @@ func TestFindNetworkUtil(t *testing.T) {
 		t.Fatalf("Incorrect libnetwork.Network resource. It has different name: %v", n)
 	}
 
-	if err := n.Delete(); err != nil {
+	if err := n.Delete(context.Background()); err != nil {
 		t.Fatalf("Failed to delete the network: %s", err.Error())
 	}
 
@@ -1112,7 +1113,7 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1124,7 +1125,7 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 	}
 
 	vars := make(map[string]string)
-	i, errRsp := procCreateNetwork(c, vars, body)
+	i, errRsp := procCreateNetwork(context.Background(), c, vars, body)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1136,7 +1137,7 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 	}
 
 	vars[urlNwName] = "firstNet"
-	_, errRsp = procCreateEndpoint(c, vars, vbad)
+	_, errRsp = procCreateEndpoint(context.Background(), c, vars, vbad)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
@@ -1147,13 +1148,13 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 	}
 
 	vars[urlNwName] = "secondNet"
-	_, errRsp = procCreateEndpoint(c, vars, b)
+	_, errRsp = procCreateEndpoint(context.Background(), c, vars, b)
 	if errRsp == &createdResponse {
 		t.Fatalf("Expected to fail but succeeded")
 	}
 
 	vars[urlNwName] = "firstNet"
-	_, errRsp = procCreateEndpoint(c, vars, b)
+	_, errRsp = procCreateEndpoint(context.Background(), c, vars, b)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure but succeeded: %v", errRsp)
 	}
@@ -1163,7 +1164,7 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	i, errRsp = procCreateEndpoint(c, vars, b)
+	i, errRsp = procCreateEndpoint(context.Background(), c, vars, b)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1201,26 +1202,26 @@ func TestCreateDeleteEndpoints(t *testing.T) {
 	vars = make(map[string]string)
 	vars[urlNwName] = ""
 	vars[urlEpName] = "ep1"
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlNwName] = "firstNet"
 	vars[urlEpName] = ""
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlEpName] = "ep2"
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlEpName] = "firstEp"
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1238,7 +1239,7 @@ func TestJoinLeave(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = c.ConfigureNetworkDriver(bridgeNetType, nil)
+	err = c.ConfigureNetworkDriver(context.Background(), bridgeNetType, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1248,7 +1249,7 @@ func TestJoinLeave(t *testing.T) {
 		t.Fatal(err)
 	}
 	vars := make(map[string]string)
-	_, errRsp := procCreateNetwork(c, vars, nb)
+	_, errRsp := procCreateNetwork(context.Background(), c, vars, nb)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1258,7 +1259,7 @@ func TestJoinLeave(t *testing.T) {
 		t.Fatal(err)
 	}
 	vars[urlNwName] = "network"
-	_, errRsp = procCreateEndpoint(c, vars, eb)
+	_, errRsp = procCreateEndpoint(context.Background(), c, vars, eb)
 	if errRsp != &createdResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1267,7 +1268,7 @@ func TestJoinLeave(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procJoinEndpoint(c, vars, vbad)
+	_, errRsp = procJoinEndpoint(context.Background(), c, vars, vbad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -1277,7 +1278,7 @@ func TestJoinLeave(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, errRsp = procJoinEndpoint(c, vars, bad)
+	_, errRsp = procJoinEndpoint(context.Background(), c, vars, bad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -1292,26 +1293,26 @@ func TestJoinLeave(t *testing.T) {
 	vars = make(map[string]string)
 	vars[urlNwName] = ""
 	vars[urlEpName] = ""
-	_, errRsp = procJoinEndpoint(c, vars, jlb)
+	_, errRsp = procJoinEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlNwName] = "network"
 	vars[urlEpName] = ""
-	_, errRsp = procJoinEndpoint(c, vars, jlb)
+	_, errRsp = procJoinEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlEpName] = "epoint"
-	_, errRsp = procJoinEndpoint(c, vars, jlb)
+	_, errRsp = procJoinEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlEpName] = "endpoint"
-	key, errRsp := procJoinEndpoint(c, vars, jlb)
+	key, errRsp := procJoinEndpoint(context.Background(), c, vars, jlb)
 	if errRsp != &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
@@ -1320,68 +1321,68 @@ func TestJoinLeave(t *testing.T) {
 	if keyStr == "" {
 		t.Fatalf("Empty sandbox key")
 	}
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlNwName] = "network2"
-	_, errRsp = procLeaveEndpoint(c, vars, vbad)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, vbad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
-	_, errRsp = procLeaveEndpoint(c, vars, bad)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, bad)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 	vars = make(map[string]string)
 	vars[urlNwName] = ""
 	vars[urlEpName] = ""
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 	vars[urlNwName] = "network"
 	vars[urlEpName] = ""
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 	vars[urlEpName] = "2epoint"
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 	vars[urlEpName] = "epoint"
 	vars[urlCnID] = "who"
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	delete(vars, urlCnID)
 	vars[urlEpName] = "endpoint"
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
 	vars[urlCnID] = cid
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
 
-	_, errRsp = procLeaveEndpoint(c, vars, jlb)
+	_, errRsp = procLeaveEndpoint(context.Background(), c, vars, jlb)
 	if errRsp == &successResponse {
 		t.Fatalf("Expected failure, got: %v", errRsp)
 	}
 
-	_, errRsp = procDeleteEndpoint(c, vars, nil)
+	_, errRsp = procDeleteEndpoint(context.Background(), c, vars, nil)
 	if errRsp != &successResponse {
 		t.Fatalf("Unexepected failure: %v", errRsp)
 	}
@@ -1408,7 +1409,7 @@ func TestFindEndpointUtil(t *testing.T) {
 	c, nw := createTestNetwork(t, "network")
 	nid := nw.ID()
 
-	ep, err := nw.CreateEndpoint("secondEp", nil)
+	ep, err := nw.CreateEndpoint(context.Background(), "secondEp", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1456,7 +1457,7 @@ func TestFindEndpointUtil(t *testing.T) {
 		t.Fatalf("Diffenrent queries returned different endpoints")
 	}
 
-	ep.Delete()
+	ep.Delete(context.Background())
 
 	_, errRsp = findEndpoint(c, nid, "secondEp", byID, byName)
 	if errRsp == &successResponse {
@@ -1713,7 +1714,7 @@ func TestHttpHandlerUninit(t *testing.T) {
 		t.Fatalf("Expected empty list. Got %v", list)
 	}
 
-	n, err := c.NewNetwork(bridgeNetType, "didietro", nil)
+	n, err := c.NewNetwork(context.Background(), bridgeNetType, "didietro", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2128,3 +2129,144 @@ func TestErrorConversion(t *testing.T) {
 		t.Fatalf("Failed to recognize not classified error as Internal error")
 	}
 }
+
+func TestAuthenticator(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleRequest := NewHTTPHandler(c, WithAuthenticator(func(req *http.Request) (string, error) {
+		if token := req.Header.Get("Authorization"); token != "Bearer secret" {
+			return "", fmt.Errorf("invalid token %q", token)
+		}
+		return "alice", nil
+	}))
+
+	nc := networkCreate{Name: "authnet", NetworkType: bridgeNetType, Options: map[string]interface{}{
+		netlabel.GenericData: map[string]interface{}{"EnableIPTables": "false"},
+	}}
+	body, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := newWriter()
+	req, err := http.NewRequest("POST", "/v1.19/networks", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleRequest(rsp, req)
+	if rsp.statusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusUnauthorized, rsp.statusCode, rsp.body)
+	}
+	if _, err := c.NetworkByName("authnet"); err == nil {
+		t.Fatal("Network was created by an unauthenticated request")
+	}
+
+	rsp = newWriter()
+	req, err = http.NewRequest("POST", "/v1.19/networks", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	handleRequest(rsp, req)
+	if rsp.statusCode != http.StatusCreated {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusCreated, rsp.statusCode, rsp.body)
+	}
+
+	nw, err := c.NetworkByName("authnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete(context.Background())
+	records, err := nw.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Actor != "alice" {
+		t.Fatalf("Unexpected audit log %+v", records)
+	}
+}
+
+func TestSandboxes(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	c, _ := createTestNetwork(t, "sbnet")
+	handleRequest := NewHTTPHandler(c)
+
+	rsp := newWriter()
+	req, err := http.NewRequest("GET", "/v1.19/sandboxes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleRequest(rsp, req)
+	if rsp.statusCode != http.StatusOK {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusOK, rsp.statusCode, rsp.body)
+	}
+	var list []*sandboxResource
+	if err := json.Unmarshal(rsp.body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("Expected empty list. Got %v", list)
+	}
+
+	for _, r := range []struct {
+		method, url string
+		body        []byte
+	}{
+		{"GET", "/v1.19/sandboxes/c1", nil},
+		{"DELETE", "/v1.19/sandboxes/c1", nil},
+		{"POST", "/v1.19/sandboxes/c1/connectivity", []byte(`{"enable": false}`)},
+	} {
+		rsp := newWriter()
+		req, err := http.NewRequest(r.method, r.url, bytes.NewReader(r.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handleRequest(rsp, req)
+		if rsp.statusCode != http.StatusNotFound {
+			t.Fatalf("%s %s: expected (%d). Got (%d): %s", r.method, r.url, http.StatusNotFound, rsp.statusCode, rsp.body)
+		}
+	}
+
+	if _, errRsp := procSetSandboxConnectivity(context.Background(), c, map[string]string{urlCnID: "c1"}, []byte("{")); errRsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected (%d). Got (%d)", http.StatusBadRequest, errRsp.StatusCode)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	c, nw := createTestNetwork(t, "snapnet")
+	handleRequest := NewHTTPHandler(c)
+
+	rsp := newWriter()
+	req, err := http.NewRequest("GET", "/v1.19/snapshot", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleRequest(rsp, req)
+	if rsp.statusCode != http.StatusOK {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusOK, rsp.statusCode, rsp.body)
+	}
+
+	snap := &libnetwork.Snapshot{}
+	if err := json.Unmarshal(rsp.body, snap); err != nil {
+		t.Fatalf("Invalid snapshot %s: %v", rsp.body, err)
+	}
+	if len(snap.Networks) != 1 {
+		t.Fatalf("Expected one network in the snapshot. Got %d", len(snap.Networks))
+	}
+	var n struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(snap.Networks[0].Network, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != nw.ID() {
+		t.Fatalf("Expected network %s in the snapshot. Got %s", nw.ID(), n.ID)
+	}
+}
@@ -8,10 +8,12 @@ import "github.com/docker/libnetwork/types"
 
 // networkResource is the body of the "get network" http response message
 type networkResource struct {
-	Name      string              `json:"name"`
-	ID        string              `json:"id"`
-	Type      string              `json:"type"`
-	Endpoints []*endpointResource `json:"endpoints"`
+	Name       string              `json:"name"`
+	ID         string              `json:"id"`
+	Type       string              `json:"type"`
+	Endpoints  []*endpointResource `json:"endpoints"`
+	ConfigOnly bool                `json:"config_only,omitempty"`
+	ConfigFrom string              `json:"config_from,omitempty"`
 }
 
 // endpointResource is the body of the "get endpoint" http response message
@@ -27,6 +29,13 @@ type containerResource struct {
 	// will add more fields once labels change is in
 }
 
+// sandboxResource is the body of the "get sandbox" http response message
+type sandboxResource struct {
+	ContainerID string              `json:"container_id"`
+	Key         string              `json:"key"`
+	Endpoints   []*endpointResource `json:"endpoints"`
+}
+
 /***********
   Body types
   ************/
@@ -36,6 +45,11 @@ type networkCreate struct {
 	Name        string                 `json:"name"`
 	NetworkType string                 `json:"network_type"`
 	Options     map[string]interface{} `json:"options"`
+	GlobalScope bool                   `json:"global_scope,omitempty"`
+	ConfigOnly  bool                   `json:"config_only,omitempty"`
+	ConfigFrom  string                 `json:"config_from,omitempty"`
+	// MaxEndpoints caps the number of endpoints of the network
+	MaxEndpoints int `json:"max_endpoints,omitempty"`
 }
 
 // endpointCreate represents the body of the "create endpoint" http request message
@@ -43,6 +57,17 @@ type endpointCreate struct {
 	Name         string                `json:"name"`
 	ExposedPorts []types.TransportPort `json:"exposed_ports"`
 	PortMapping  []types.PortBinding   `json:"port_mapping"`
+	// DeferPortBinding binds the mapped ports only once a container joins
+	// the endpoint, or once they are activated
+	DeferPortBinding bool `json:"defer_port_binding,omitempty"`
+	// ServiceRegistration is when the endpoint is added to the service
+	// records: "create", the default, "join", "activate" or "never"
+	ServiceRegistration string `json:"service_registration,omitempty"`
+	// PerfProfile is the performance profile the endpoint interfaces are
+	// tuned for, "latency" or "throughput", PerfCPUs the CPU mask their
+	// queues are steered to
+	PerfProfile string `json:"perf_profile,omitempty"`
+	PerfCPUs    string `json:"perf_cpus,omitempty"`
 }
 
 // endpointJoin represents the expected body of the "join endpoint" or "leave endpoint" http request messages
@@ -79,3 +104,9 @@ type endpointParentUpdate struct {
 	Name       string `json:"name"`
 	Address    string `json:"address"`
 }
+
+// sandboxConnectivity is the body of the "set sandbox connectivity" http
+// request message
+type sandboxConnectivity struct {
+	Enable bool `json:"enable"`
+}
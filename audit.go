@@ -0,0 +1,252 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+// The operations recorded in the audit log of a network
+const (
+	AuditNetworkCreate   = "network.create"
+	AuditNetworkDelete   = "network.delete"
+	AuditEndpointCreate  = "endpoint.create"
+	AuditEndpointDelete  = "endpoint.delete"
+	AuditEndpointJoin    = "endpoint.join"
+	AuditEndpointLeave   = "endpoint.leave"
+	AuditEndpointChange  = "endpoint.change-address"
+	AuditEndpointMigrate = "endpoint.migrate"
+)
+
+// maxAuditAttempts bounds the retries of an append racing with the other
+// hosts sharing the store
+const maxAuditAttempts = 5
+
+// AuditRecord is an entry of the log of the operations which changed the
+// state of a network
+type AuditRecord struct {
+	Time      time.Time
+	Actor     string `json:",omitempty"`
+	Operation string
+	Target    string
+	Params    map[string]string `json:",omitempty"`
+	Error     string            `json:",omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording the actor of the operations it is
+// passed to in the audit log
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// auditLog is the audit log of a network as kept in the datastore
+type auditLog struct {
+	nid      types.UUID
+	records  []AuditRecord
+	dbIndex  uint64
+	dbExists bool
+}
+
+func (l *auditLog) Key() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.AuditKeyPrefix, string(l.nid))
+}
+
+func (l *auditLog) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.AuditKeyPrefix)
+}
+
+func (l *auditLog) Value() []byte {
+	b, err := json.Marshal(l.records)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (l *auditLog) SetValue(value []byte) error {
+	return json.Unmarshal(value, &l.records)
+}
+
+func (l *auditLog) Index() uint64 {
+	return l.dbIndex
+}
+
+func (l *auditLog) SetIndex(index uint64) {
+	l.dbIndex = index
+	l.dbExists = true
+}
+
+func (l *auditLog) Exists() bool {
+	return l.dbExists
+}
+
+// appendAudit adds rec to records, dropping the oldest ones beyond retention
+func appendAudit(records []AuditRecord, rec AuditRecord, retention int) []AuditRecord {
+	records = append(records, rec)
+	if len(records) > retention {
+		records = append([]AuditRecord(nil), records[len(records)-retention:]...)
+	}
+	return records
+}
+
+func (c *controller) auditRetention() int {
+	if c.cfg == nil || c.cfg.Daemon.AuditRetention <= 0 {
+		return config.DefaultAuditRetention
+	}
+	return c.cfg.Daemon.AuditRetention
+}
+
+// audit records the outcome of an operation on the network. The log is kept
+// in the datastore if there is one, so that it is shared by the hosts of
+// global networks, and in memory otherwise. It outlives the network, so that
+// its deletion, or its failed creation, can still be traced. Failing to
+// record an operation does not fail it.
+func (c *controller) audit(ctx context.Context, n *network, op, target string, params map[string]string, err error) {
+	if c.isReadOnly() {
+		return
+	}
+
+	rec := AuditRecord{
+		Time:      time.Now().UTC(),
+		Actor:     actorFrom(ctx),
+		Operation: op,
+		Target:    target,
+		Params:    params,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	n.Lock()
+	nid := n.id
+	name := n.name
+	n.Unlock()
+
+	c.Lock()
+	cs := c.store
+	retention := c.auditRetention()
+	c.Unlock()
+
+	c.auditMu.Lock()
+	defer c.auditMu.Unlock()
+	if cs == nil {
+		if c.auditLogs == nil {
+			c.auditLogs = make(map[types.UUID][]AuditRecord)
+		}
+		c.auditLogs[nid] = appendAudit(c.auditLogs[nid], rec, retention)
+		return
+	}
+
+	// The record is written even if the operation was abandoned with ctx
+	for i := 0; i < maxAuditAttempts; i++ {
+		l := &auditLog{nid: nid}
+		if err = cs.GetObject(l.Key().String(), l); err != nil && err != datastore.ErrKeyNotFound {
+			break
+		}
+		l.records = appendAudit(l.records, rec, retention)
+		if err = cs.PutObjectAtomic(l); err != datastore.ErrKeyModified {
+			break
+		}
+	}
+	if err != nil {
+		log.Warnf("failed to record %s of %s in the audit log of network %s: %v", op, target, name, err)
+	}
+}
+
+// auditLog returns the audit log of the network with the given id
+func (c *controller) auditLog(nid types.UUID) ([]AuditRecord, error) {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	if cs == nil {
+		c.auditMu.Lock()
+		defer c.auditMu.Unlock()
+		return append([]AuditRecord(nil), c.auditLogs[nid]...), nil
+	}
+
+	l := &auditLog{nid: nid}
+	if err := cs.GetObject(l.Key().String(), l); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l.records, nil
+}
+
+func (c *controller) AuditLogs() (map[string][]AuditRecord, error) {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	logs := make(map[string][]AuditRecord)
+	if cs == nil {
+		c.auditMu.Lock()
+		defer c.auditMu.Unlock()
+		for nid, records := range c.auditLogs {
+			logs[string(nid)] = append([]AuditRecord(nil), records...)
+		}
+		return logs, nil
+	}
+
+	kvs, err := cs.KVStore().List(datastore.NewKeyPath(datastore.AuditKeyPrefix).String())
+	if err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return logs, nil
+		}
+		return nil, err
+	}
+	for _, kve := range kvs {
+		key, err := datastore.ParseKeyPath(kve.Key)
+		if err != nil || len(key) != 2 {
+			continue
+		}
+		l := &auditLog{nid: types.UUID(key[1])}
+		if err := l.SetValue(kve.Value); err != nil {
+			log.Warnf("failed to decode the audit log of network %s: %v", key[1], err)
+			continue
+		}
+		logs[key[1]] = l.records
+	}
+	return logs, nil
+}
+
+func (n *network) AuditLog() ([]AuditRecord, error) {
+	n.Lock()
+	ctrlr := n.ctrlr
+	nid := n.id
+	n.Unlock()
+
+	return ctrlr.auditLog(nid)
+}
+
+// audit records the outcome of an operation on the endpoint in the audit
+// log of its network
+func (ep *endpoint) audit(ctx context.Context, op string, params map[string]string, err error) {
+	ep.Lock()
+	n := ep.network
+	name := ep.name
+	ep.Unlock()
+
+	n.Lock()
+	ctrlr := n.ctrlr
+	n.Unlock()
+
+	ctrlr.audit(ctx, n, op, name, params, err)
+}
@@ -0,0 +1,103 @@
+package libnetwork
+
+import (
+	"context"
+)
+
+// The operations authorized besides the ones recorded in the audit log
+const (
+	AuthzClusterManage         = "cluster.manage"
+	AuthzDriverConfigure       = "driver.configure"
+	AuthzDriverReload          = "driver.reload"
+	AuthzEndpointActivatePorts = "endpoint.activate-ports"
+	AuthzEndpointActivate      = "endpoint.activate"
+	AuthzEndpointDeactivate    = "endpoint.deactivate"
+	AuthzEndpointAnnounce      = "endpoint.announce"
+	AuthzEndpointCapture       = "endpoint.capture"
+	AuthzNetworkServices       = "network.update-services"
+	AuthzSandboxConnectivity   = "sandbox.set-connectivity"
+	AuthzStoreRepair           = "store.repair"
+)
+
+// AuthzRequest describes a state changing operation of the controller, for
+// the authorizer to allow or deny
+type AuthzRequest struct {
+	// Actor is the caller, as recorded in the context passed to the
+	// operation with WithActor
+	Actor string
+	// Operation is one of the Audit operations or of the Authz ones
+	Operation string
+	// NetworkType is the type of the network or of the driver operated on,
+	// Network and Tenant are the name and the tenant of the network
+	NetworkType string
+	Network     string
+	Tenant      string
+	// Endpoint is the name of the endpoint of the endpoint operations
+	Endpoint string
+	// Params are the parameters of the operation, like the container of a
+	// join or the service of a service record update
+	Params map[string]string `json:",omitempty"`
+}
+
+// Authorizer is invoked by the controller before every operation changing
+// its state, for the multi-user platforms embedding libnetwork to enforce
+// their permissions. An error denies the operation, which fails with a
+// NotAuthorizedError. The setup of the controller by the embedder, like the
+// authorizer itself, the hooks and the port publisher, is not authorized.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AuthzRequest) error
+}
+
+func (c *controller) SetAuthorizer(a Authorizer) {
+	c.Lock()
+	defer c.Unlock()
+	c.authz = a
+}
+
+// authorize asks the authorizer of the controller, if any, whether the
+// operation is allowed
+func (c *controller) authorize(ctx context.Context, req AuthzRequest) error {
+	c.Lock()
+	a := c.authz
+	c.Unlock()
+	if a == nil {
+		return nil
+	}
+
+	req.Actor = actorFrom(ctx)
+	if err := a.Authorize(ctx, req); err != nil {
+		return &NotAuthorizedError{Operation: req.Operation, Actor: req.Actor, Reason: err}
+	}
+	return nil
+}
+
+// authzRequest returns the request of an operation on the network
+func (n *network) authzRequest(op string, params map[string]string) AuthzRequest {
+	n.Lock()
+	defer n.Unlock()
+	return AuthzRequest{
+		Operation:   op,
+		NetworkType: n.networkType,
+		Network:     n.name,
+		Tenant:      n.tenant,
+		Params:      params,
+	}
+}
+
+func (n *network) authorize(ctx context.Context, op string, params map[string]string) error {
+	n.Lock()
+	ctrlr := n.ctrlr
+	n.Unlock()
+	return ctrlr.authorize(ctx, n.authzRequest(op, params))
+}
+
+func (ep *endpoint) authorize(ctx context.Context, op string, params map[string]string) error {
+	ep.Lock()
+	n := ep.network
+	name := ep.name
+	ep.Unlock()
+
+	req := n.authzRequest(op, params)
+	req.Endpoint = name
+	return ep.controller().authorize(ctx, req)
+}
@@ -0,0 +1,130 @@
+// Package benchmarks drives libnetwork at scale, creating and joining the
+// endpoints of many networks with the fake driver, which programs nothing,
+// so that the cost measured is the one of libnetwork itself: the store, the
+// address allocation, the sandboxes and the service records. The benchmarks
+// and the soak test run with go test -bench.
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/fake"
+)
+
+// NetworkType is the network type of the driver registered by NewController
+const NetworkType = fake.NetworkType
+
+// NewDriver returns an in-memory driver recording no calls, whose endpoints
+// get no interface, so that they can join the default sandbox without
+// touching the host
+func NewDriver() *fake.Driver {
+	d := fake.New()
+	d.SetRecording(false)
+	d.SetInterfaces(false)
+	return d
+}
+
+// Endpoints returns the number of endpoints of the driver, all networks
+// together
+func Endpoints(d *fake.Driver) int {
+	n := 0
+	for _, nid := range d.Networks() {
+		n += len(d.Endpoints(nid))
+	}
+	return n
+}
+
+// NewController returns a controller with the driver registered for the
+// network type NetworkType
+func NewController(d *fake.Driver) (libnetwork.NetworkController, error) {
+	c, err := libnetwork.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Register(c.(driverapi.DriverCallback), NetworkType, driverapi.LocalScope); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Scale is the size of a population, Endpoints endpoints on each of the
+// Networks networks
+type Scale struct {
+	Networks  int
+	Endpoints int
+}
+
+func (s Scale) String() string {
+	return fmt.Sprintf("%dx%d", s.Networks, s.Endpoints)
+}
+
+// Total returns the number of endpoints of the population
+func (s Scale) Total() int {
+	return s.Networks * s.Endpoints
+}
+
+// Populate creates the networks of the scale, with their endpoints each
+// joined by its own container to the default sandbox. The hosts and
+// resolv.conf files of the containers are written under dir. The names are
+// prefixed, so that the populations of a controller do not clash.
+func Populate(ctx context.Context, c libnetwork.NetworkController, prefix string, s Scale, dir string) ([]libnetwork.Network, error) {
+	var networks []libnetwork.Network
+	for i := 0; i < s.Networks; i++ {
+		n, err := c.NewNetwork(ctx, NetworkType, fmt.Sprintf("%s-net%d", prefix, i))
+		if err != nil {
+			return networks, err
+		}
+		networks = append(networks, n)
+
+		for j := 0; j < s.Endpoints; j++ {
+			name := fmt.Sprintf("%s-ep%d-%d", prefix, i, j)
+			ep, err := n.CreateEndpoint(ctx, name)
+			if err != nil {
+				return networks, err
+			}
+			if err := Join(ctx, ep, name, dir); err != nil {
+				return networks, err
+			}
+		}
+	}
+	return networks, nil
+}
+
+// Join joins the endpoint to the default sandbox for the container, its
+// hosts and resolv.conf files written under dir
+func Join(ctx context.Context, ep libnetwork.Endpoint, containerID, dir string) error {
+	files := filepath.Join(dir, containerID)
+	if err := os.MkdirAll(files, 0755); err != nil {
+		return err
+	}
+	return ep.Join(ctx, containerID,
+		libnetwork.JoinOptionUseDefaultSandbox(),
+		libnetwork.JoinOptionHostsPath(filepath.Join(files, "hosts")),
+		libnetwork.JoinOptionResolvConfPath(filepath.Join(files, "resolv.conf")))
+}
+
+// Teardown leaves and deletes the endpoints of the networks, then deletes
+// the networks. It goes on past the failures and returns the first one.
+func Teardown(ctx context.Context, networks []libnetwork.Network) error {
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, n := range networks {
+		for _, ep := range n.Endpoints() {
+			if info := ep.ContainerInfo(); info != nil {
+				keep(ep.Leave(ctx, info.ID()))
+			}
+			keep(ep.Delete(ctx))
+		}
+		keep(n.Delete(ctx))
+	}
+	return first
+}
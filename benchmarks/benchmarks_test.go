@@ -0,0 +1,298 @@
+package benchmarks
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/iptables"
+)
+
+var soak = flag.Duration("soak", 0, "run the soak test for the given duration")
+
+var scales = []Scale{{1, 10}, {10, 10}, {10, 100}, {100, 10}}
+
+func TestMain(m *testing.M) {
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func tempDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "libnetwork-bench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return dir
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse
+}
+
+// reportPerEndpoint logs the time, and the heap if measured, per endpoint
+// of the population, the benchmarks logging whatever the verbosity
+func reportPerEndpoint(b *testing.B, s Scale, elapsed time.Duration, held uint64) {
+	n := int64(b.N * s.Total())
+	if held == 0 {
+		b.Logf("%d ns/endpoint", elapsed.Nanoseconds()/n)
+		return
+	}
+	b.Logf("%d ns/endpoint\t%d heap-B/endpoint", elapsed.Nanoseconds()/n, int64(held)/n)
+}
+
+// BenchmarkPopulate measures the creation and the join of the endpoints of
+// the networks, and the memory they hold once joined
+func BenchmarkPopulate(b *testing.B) {
+	for _, s := range scales {
+		b.Run(s.String(), func(b *testing.B) {
+			dir := tempDir(b)
+			defer os.RemoveAll(dir)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			var (
+				elapsed time.Duration
+				held    uint64
+			)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				d := NewDriver()
+				c, err := NewController(d)
+				if err != nil {
+					b.Fatal(err)
+				}
+				before := heapInUse()
+				b.StartTimer()
+
+				start := time.Now()
+				networks, err := Populate(ctx, c, fmt.Sprintf("p%d", i), s, dir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				elapsed += time.Since(start)
+
+				b.StopTimer()
+				if after := heapInUse(); after > before {
+					held += after - before
+				}
+				if n := Endpoints(d); n != s.Total() {
+					b.Fatalf("Expected %d endpoints, the driver has %d", s.Total(), n)
+				}
+				if err := Teardown(ctx, networks); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+			reportPerEndpoint(b, s, elapsed, held)
+		})
+	}
+}
+
+// BenchmarkTeardown measures the leave and the deletion of the endpoints
+// of the networks
+func BenchmarkTeardown(b *testing.B) {
+	for _, s := range scales {
+		b.Run(s.String(), func(b *testing.B) {
+			dir := tempDir(b)
+			defer os.RemoveAll(dir)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c, err := NewController(NewDriver())
+				if err != nil {
+					b.Fatal(err)
+				}
+				networks, err := Populate(ctx, c, fmt.Sprintf("t%d", i), s, dir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				start := time.Now()
+				if err := Teardown(ctx, networks); err != nil {
+					b.Fatal(err)
+				}
+				elapsed += time.Since(start)
+			}
+			reportPerEndpoint(b, s, elapsed, 0)
+		})
+	}
+}
+
+// record is a store object the size of an endpoint
+type record struct {
+	ID       string
+	Name     string
+	Network  string
+	Address  string
+	MAC      string
+	Labels   map[string]string
+	dbIndex  uint64
+	dbExists bool
+}
+
+func (r *record) Key() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.EndpointKeyPrefix, r.Network, r.ID)
+}
+
+func (r *record) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.EndpointKeyPrefix, r.Network)
+}
+
+func (r *record) Value() []byte {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *record) SetValue(value []byte) error {
+	return json.Unmarshal(value, r)
+}
+
+func (r *record) Index() uint64 {
+	return r.dbIndex
+}
+
+func (r *record) SetIndex(index uint64) {
+	r.dbIndex = index
+	r.dbExists = true
+}
+
+func (r *record) Exists() bool {
+	return r.dbExists
+}
+
+func newRecord(i int) *record {
+	return &record{
+		ID:      fmt.Sprintf("%064x", i),
+		Name:    fmt.Sprintf("ep%d", i),
+		Network: "net0",
+		Address: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).String() + "/8",
+		MAC:     fmt.Sprintf("02:42:0a:%02x:%02x:%02x", byte(i>>16), byte(i>>8), byte(i)),
+		Labels:  map[string]string{"com.example.service": "web", "com.example.tier": "frontend"},
+	}
+}
+
+// BenchmarkStore measures the atomic write, the read and the deletion of an
+// object of the store, with and without compression
+func BenchmarkStore(b *testing.B) {
+	for _, threshold := range []int{0, 64} {
+		b.Run(fmt.Sprintf("compression=%d", threshold), func(b *testing.B) {
+			ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+			if threshold != 0 {
+				ds = datastore.WithCompression(ds, threshold)
+			}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := newRecord(i)
+				if err := ds.PutObjectAtomic(r); err != nil {
+					b.Fatal(err)
+				}
+				got := &record{}
+				if err := ds.GetObject(r.Key().String(), got); err != nil {
+					b.Fatal(err)
+				}
+				if err := ds.DeleteObjectAtomic(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkIptables measures the programming of the port forwarding rules
+// of the endpoints. It needs the iptables binary and root.
+func BenchmarkIptables(b *testing.B) {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		b.Skip("iptables not found")
+	}
+	if os.Getuid() != 0 {
+		b.Skip("iptables needs root")
+	}
+
+	chain, err := iptables.NewChain("LIBNETWORK-BENCH", "benchbr0", iptables.Nat, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer chain.Remove()
+
+	ip := net.ParseIP("192.168.200.1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		port := 10000 + i%50000
+		dest := net.IPv4(172, 30, byte(i>>8), byte(i)).String()
+		if err := chain.Forward(iptables.Append, ip, port, "tcp", dest, 80); err != nil {
+			b.Fatal(err)
+		}
+		if err := chain.Forward(iptables.Delete, ip, port, "tcp", dest, 80); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestSoak creates and tears down populations until the duration given
+// with -soak is over, failing if the memory held keeps growing
+func TestSoak(t *testing.T) {
+	if *soak == 0 {
+		t.Skip("soak test not requested, run with -soak <duration>")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	d := NewDriver()
+	c, err := NewController(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Scale{Networks: 10, Endpoints: 50}
+	var baseline uint64
+	end := time.Now().Add(*soak)
+	for round := 0; time.Now().Before(end); round++ {
+		networks, err := Populate(ctx, c, fmt.Sprintf("s%d", round), s, dir)
+		if err != nil {
+			t.Fatalf("Round %d: %v", round, err)
+		}
+		if err := Teardown(ctx, networks); err != nil {
+			t.Fatalf("Round %d: %v", round, err)
+		}
+		if n := Endpoints(d); n != 0 {
+			t.Fatalf("Round %d: %d endpoints left in the driver", round, n)
+		}
+		if n := len(c.Networks()); n != 0 {
+			t.Fatalf("Round %d: %d networks left", round, n)
+		}
+
+		heap := heapInUse()
+		switch {
+		case round == 1:
+			// The first round warms up the caches and pools
+			baseline = heap
+		case round > 1 && heap > 2*baseline:
+			t.Fatalf("Round %d: %d bytes of heap in use, %d after the warm up", round, heap, baseline)
+		}
+		t.Logf("Round %d: %d bytes of heap in use", round, heap)
+	}
+}
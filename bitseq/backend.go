@@ -0,0 +1,112 @@
+package bitseq
+
+import "fmt"
+
+// Backend identifies the representation of the bitmask of a Handle
+type Backend string
+
+const (
+	// RunLength represents the bitmask as a run-length encoded sequence of
+	// 32 bits blocks. It suits the masks whose bits are set in long runs,
+	// like the ones allocated in order.
+	RunLength Backend = "runlength"
+	// Roaring represents the bitmask as a roaring bitmap. It suits the
+	// large masks, like the ones of the IPv6 subnets or of the port ranges,
+	// whose set bits are scattered.
+	Roaring Backend = "roaring"
+)
+
+// bitmask is implemented by the representations of the bits of a Handle
+type bitmask interface {
+	firstAvailable() (int, int, error)
+	nthAvailable(n uint32) (int, int, error)
+	checkIfAvailable(ordinal int) (int, int, error)
+	// pushReservation sets or clears the bit and returns the updated mask,
+	// the passed one may be modified
+	pushReservation(bytePos, bitPos int, release bool) bitmask
+	// forEachSet calls f on the ordinals of the set bits among the first
+	// numBits bits, in ascending order
+	forEachSet(numBits uint32, f func(ordinal uint32))
+	freeRanges(numBits uint32) (uint32, uint32)
+	getCopy() bitmask
+	backend() Backend
+	toByteArray() ([]byte, error)
+	String() string
+}
+
+// newBitmask returns an empty mask of numElements bits of the backend
+func newBitmask(b Backend, numElements uint32) (bitmask, error) {
+	switch b {
+	case RunLength, "":
+		return NewSequence(numElements), nil
+	case Roaring:
+		return newRoaringBitmap(numElements), nil
+	}
+	return nil, fmt.Errorf("unknown bitseq backend %q", b)
+}
+
+// convert returns a mask of the backend with the same set bits as the passed
+// one
+func convert(m bitmask, b Backend, numElements uint32) (bitmask, error) {
+	nm, err := newBitmask(b, numElements)
+	if err != nil {
+		return nil, err
+	}
+	m.forEachSet(numElements, func(ordinal uint32) {
+		nm = nm.pushReservation(int(ordinal/8), int(ordinal%8), false)
+	})
+	return nm, nil
+}
+
+// The run-length encoded Sequence is the RunLength bitmask
+
+func (s *Sequence) firstAvailable() (int, int, error) {
+	return GetFirstAvailable(s)
+}
+
+func (s *Sequence) nthAvailable(n uint32) (int, int, error) {
+	return GetNthAvailable(s, n)
+}
+
+func (s *Sequence) checkIfAvailable(ordinal int) (int, int, error) {
+	return CheckIfAvailable(s, ordinal)
+}
+
+func (s *Sequence) pushReservation(bytePos, bitPos int, release bool) bitmask {
+	return PushReservation(bytePos, bitPos, s, release)
+}
+
+func (s *Sequence) forEachSet(numBits uint32, f func(ordinal uint32)) {
+	ordinal := uint32(0)
+	for p := s; p != nil && ordinal < numBits; p = p.Next {
+		// Whole free runs are skipped at once
+		if p.Block == 0x0 {
+			ordinal += p.Count * blockLen
+			continue
+		}
+		for c := uint32(0); c < p.Count && ordinal < numBits; c++ {
+			for bitSel := uint32(blockFirstBit); bitSel > 0 && ordinal < numBits; bitSel >>= 1 {
+				if p.Block&bitSel != 0 {
+					f(ordinal)
+				}
+				ordinal++
+			}
+		}
+	}
+}
+
+func (s *Sequence) freeRanges(numBits uint32) (uint32, uint32) {
+	return FreeRanges(s, numBits)
+}
+
+func (s *Sequence) getCopy() bitmask {
+	return s.GetCopy()
+}
+
+func (s *Sequence) backend() Backend {
+	return RunLength
+}
+
+func (s *Sequence) toByteArray() ([]byte, error) {
+	return s.ToByteArray()
+}
@@ -0,0 +1,371 @@
+package bitseq
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/docker/libnetwork/netutils"
+)
+
+// Roaring bitmap constants
+const (
+	// containerBits is the number of bits of a container, selected by the
+	// 16 low bits of the ordinal
+	containerBits = 1 << 16
+	// arrayMaxSize is the largest cardinality of an array container, beyond
+	// it the bitmap container is smaller
+	arrayMaxSize = 4096
+	bitmapWords  = containerBits / 64
+
+	arrayContainer  = 0
+	bitmapContainer = 1
+)
+
+// roaringMagic prefixes the serialized roaring bitmaps. Their length, unlike
+// the one of the serialized sequences, is not a multiple of 8.
+var roaringMagic = []byte("RBM1")
+
+// container holds the set bits of a roaring bitmap sharing the 16 high bits
+// of their ordinal, as a sorted array of the low bits while there are few of
+// them, as a plain bitmap otherwise
+type container struct {
+	key    uint16
+	card   int
+	array  []uint16
+	bitmap []uint64
+}
+
+func (c *container) contains(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/64]&(1<<(low%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+func (c *container) add(low uint16) {
+	if c.contains(low) {
+		return
+	}
+	c.card++
+	if c.bitmap != nil {
+		c.bitmap[low/64] |= 1 << (low % 64)
+		return
+	}
+	if c.card > arrayMaxSize {
+		c.bitmap = make([]uint64, bitmapWords)
+		for _, v := range c.array {
+			c.bitmap[v/64] |= 1 << (v % 64)
+		}
+		c.bitmap[low/64] |= 1 << (low % 64)
+		c.array = nil
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+}
+
+func (c *container) remove(low uint16) {
+	if !c.contains(low) {
+		return
+	}
+	c.card--
+	if c.bitmap == nil {
+		i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+		c.array = append(c.array[:i], c.array[i+1:]...)
+		return
+	}
+	c.bitmap[low/64] &^= 1 << (low % 64)
+	if c.card <= arrayMaxSize {
+		c.array = make([]uint16, 0, c.card)
+		c.forEach(func(v uint16) {
+			c.array = append(c.array, v)
+		})
+		c.bitmap = nil
+	}
+}
+
+// forEach calls f on the set bits of the container in ascending order
+func (c *container) forEach(f func(low uint16)) {
+	if c.bitmap == nil {
+		for _, v := range c.array {
+			f(v)
+		}
+		return
+	}
+	for i, w := range c.bitmap {
+		for w != 0 {
+			t := onesCount64(w&-w - 1)
+			f(uint16(i*64 + t))
+			w &= w - 1
+		}
+	}
+}
+
+// nthUnset returns the low bits of the n-th unset bit of the container,
+// counting from zero. The caller makes sure there are enough unset bits.
+func (c *container) nthUnset(n int) int {
+	if c.bitmap == nil {
+		// The values preceding the n-th unset bit shift it up by one
+		for i, v := range c.array {
+			if int(v)-i > n {
+				return n + i
+			}
+		}
+		return n + len(c.array)
+	}
+	for i, w := range c.bitmap {
+		free := 64 - onesCount64(w)
+		if n < free {
+			for b := 0; ; b++ {
+				if w&(1<<uint(b)) == 0 {
+					if n == 0 {
+						return i*64 + b
+					}
+					n--
+				}
+			}
+		}
+		n -= free
+	}
+	return -1
+}
+
+func (c *container) getCopy() *container {
+	n := &container{key: c.key, card: c.card}
+	if c.bitmap != nil {
+		n.bitmap = append([]uint64(nil), c.bitmap...)
+	} else {
+		n.array = append([]uint16(nil), c.array...)
+	}
+	return n
+}
+
+// roaringBitmap represents the bitmask as a roaring bitmap: the set bits are
+// split in containers by the 16 high bits of their ordinal, the containers
+// with no set bit are left out. It is far smaller than the run-length
+// encoded sequence for the large masks whose set bits are scattered.
+type roaringBitmap struct {
+	bits       uint32
+	containers []*container
+}
+
+func newRoaringBitmap(numElements uint32) *roaringBitmap {
+	return &roaringBitmap{bits: numElements}
+}
+
+// find returns the index of the container of the key, or of the position it
+// would be inserted at
+func (r *roaringBitmap) find(key uint16) (int, bool) {
+	i := sort.Search(len(r.containers), func(i int) bool { return r.containers[i].key >= key })
+	return i, i < len(r.containers) && r.containers[i].key == key
+}
+
+func (r *roaringBitmap) isSet(ordinal uint32) bool {
+	i, ok := r.find(uint16(ordinal >> 16))
+	return ok && r.containers[i].contains(uint16(ordinal))
+}
+
+// containerSize returns the number of bits of the mask covered by the
+// container of the key
+func (r *roaringBitmap) containerSize(key int) int {
+	if rest := int64(r.bits) - int64(key)*containerBits; rest < containerBits {
+		return int(rest)
+	}
+	return containerBits
+}
+
+func (r *roaringBitmap) firstAvailable() (int, int, error) {
+	return r.nthAvailable(0)
+}
+
+func (r *roaringBitmap) nthAvailable(n uint32) (int, int, error) {
+	left := int64(n)
+	next := 0
+	for key := 0; int64(key)*containerBits < int64(r.bits); key++ {
+		card := 0
+		var c *container
+		if next < len(r.containers) && int(r.containers[next].key) == key {
+			c = r.containers[next]
+			card = c.card
+			next++
+		}
+		free := int64(r.containerSize(key) - card)
+		if left >= free {
+			left -= free
+			continue
+		}
+		low := int(left)
+		if c != nil {
+			low = c.nthUnset(int(left))
+		}
+		ordinal := key*containerBits + low
+		return ordinal / 8, ordinal % 8, nil
+	}
+	return -1, -1, fmt.Errorf("no bit available")
+}
+
+func (r *roaringBitmap) checkIfAvailable(ordinal int) (int, int, error) {
+	if ordinal < 0 || int64(ordinal) >= int64(r.bits) || r.isSet(uint32(ordinal)) {
+		return -1, -1, fmt.Errorf("requested bit is not available")
+	}
+	return ordinal / 8, ordinal % 8, nil
+}
+
+// pushReservation sets or clears the bit in place. The bits beyond the end
+// of the mask are ignored.
+func (r *roaringBitmap) pushReservation(bytePos, bitPos int, release bool) bitmask {
+	ordinal := bytePos*8 + bitPos
+	if ordinal < 0 || int64(ordinal) >= int64(r.bits) {
+		return r
+	}
+	key, low := uint16(ordinal>>16), uint16(ordinal)
+	i, ok := r.find(key)
+	if release {
+		if ok {
+			r.containers[i].remove(low)
+			if r.containers[i].card == 0 {
+				r.containers = append(r.containers[:i], r.containers[i+1:]...)
+			}
+		}
+		return r
+	}
+	if !ok {
+		r.containers = append(r.containers, nil)
+		copy(r.containers[i+1:], r.containers[i:])
+		r.containers[i] = &container{key: key}
+	}
+	r.containers[i].add(low)
+	return r
+}
+
+func (r *roaringBitmap) forEachSet(numBits uint32, f func(ordinal uint32)) {
+	for _, c := range r.containers {
+		base := uint32(c.key) << 16
+		c.forEach(func(low uint16) {
+			if o := base + uint32(low); o < numBits {
+				f(o)
+			}
+		})
+	}
+}
+
+func (r *roaringBitmap) freeRanges(numBits uint32) (uint32, uint32) {
+	var ranges, longest uint32
+	next := uint32(0)
+	gap := func(end uint32) {
+		if end > next {
+			ranges++
+			if end-next > longest {
+				longest = end - next
+			}
+		}
+	}
+	r.forEachSet(numBits, func(o uint32) {
+		gap(o)
+		next = o + 1
+	})
+	gap(numBits)
+	return ranges, longest
+}
+
+func (r *roaringBitmap) getCopy() bitmask {
+	n := &roaringBitmap{bits: r.bits, containers: make([]*container, len(r.containers))}
+	for i, c := range r.containers {
+		n.containers[i] = c.getCopy()
+	}
+	return n
+}
+
+func (r *roaringBitmap) backend() Backend {
+	return Roaring
+}
+
+// toByteArray serializes the containers after the magic. Each one is made of
+// its key, its kind and its cardinality, followed by the array values padded
+// to 8 bytes or by the bitmap words.
+func (r *roaringBitmap) toByteArray() ([]byte, error) {
+	bb := append([]byte(nil), roaringMagic...)
+	for _, c := range r.containers {
+		bb = append(bb, netutils.U16ToA(c.key)...)
+		if c.bitmap != nil {
+			bb = append(bb, netutils.U16ToA(bitmapContainer)...)
+			bb = append(bb, netutils.U32ToA(uint32(c.card))...)
+			for _, w := range c.bitmap {
+				bb = append(bb, netutils.U32ToA(uint32(w>>32))...)
+				bb = append(bb, netutils.U32ToA(uint32(w))...)
+			}
+			continue
+		}
+		bb = append(bb, netutils.U16ToA(arrayContainer)...)
+		bb = append(bb, netutils.U32ToA(uint32(c.card))...)
+		for _, v := range c.array {
+			bb = append(bb, netutils.U16ToA(v)...)
+		}
+		for i := len(c.array); i%4 != 0; i++ {
+			bb = append(bb, 0, 0)
+		}
+	}
+	return bb, nil
+}
+
+// fromByteArray reads the containers serialized by toByteArray
+func (r *roaringBitmap) fromByteArray(data []byte) error {
+	if !bytes.HasPrefix(data, roaringMagic) {
+		return fmt.Errorf("missing roaring bitmap magic")
+	}
+	r.containers = nil
+	for i := len(roaringMagic); i < len(data); {
+		if len(data)-i < 8 {
+			return fmt.Errorf("truncated roaring container header at %d", i)
+		}
+		c := &container{key: netutils.ATo16(data[i : i+2]), card: int(netutils.ATo32(data[i+4 : i+8]))}
+		kind := netutils.ATo16(data[i+2 : i+4])
+		i += 8
+		switch {
+		case kind == bitmapContainer:
+			if len(data)-i < bitmapWords*8 {
+				return fmt.Errorf("truncated roaring bitmap container %d", c.key)
+			}
+			c.bitmap = make([]uint64, bitmapWords)
+			for w := range c.bitmap {
+				c.bitmap[w] = uint64(netutils.ATo32(data[i:i+4]))<<32 | uint64(netutils.ATo32(data[i+4:i+8]))
+				i += 8
+			}
+		case kind == arrayContainer && c.card <= arrayMaxSize:
+			size := (c.card*2 + 7) &^ 7
+			if len(data)-i < size {
+				return fmt.Errorf("truncated roaring array container %d", c.key)
+			}
+			c.array = make([]uint16, c.card)
+			for v := range c.array {
+				c.array[v] = netutils.ATo16(data[i+2*v : i+2*v+2])
+			}
+			i += size
+		default:
+			return fmt.Errorf("invalid roaring container %d of kind %d and cardinality %d", c.key, kind, c.card)
+		}
+		r.containers = append(r.containers, c)
+	}
+	return nil
+}
+
+// String returns a summary of the containers of the bitmap
+func (r *roaringBitmap) String() string {
+	set := 0
+	for _, c := range r.containers {
+		set += c.card
+	}
+	return fmt.Sprintf("roaring(%d containers, %d bits set)", len(r.containers), set)
+}
+
+// onesCount64 returns the number of set bits of w
+func onesCount64(w uint64) int {
+	w -= w >> 1 & 0x5555555555555555
+	w = w&0x3333333333333333 + w>>2&0x3333333333333333
+	w = (w + w>>4) & 0x0f0f0f0f0f0f0f0f
+	return int(w * 0x0101010101010101 >> 56)
+}
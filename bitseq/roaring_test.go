@@ -0,0 +1,168 @@
+package bitseq
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+)
+
+// The roaring bitmap must behave like the sequence it stands for
+func TestRoaringMatchesSequence(t *testing.T) {
+	const numBits = 3*containerBits + 100
+	var (
+		r bitmask = newRoaringBitmap(numBits)
+		s bitmask = NewSequence(numBits)
+	)
+	rnd := rand.New(rand.NewSource(1))
+
+	// Fill the second container past the array limit, so that it turns
+	// into a bitmap, and scatter bits over the others
+	for i := 0; i < arrayMaxSize+10; i++ {
+		o := containerBits + i*3
+		r = r.pushReservation(o/8, o%8, false)
+		s = s.pushReservation(o/8, o%8, false)
+	}
+	for i := 0; i < 2000; i++ {
+		o := rnd.Intn(numBits)
+		release := rnd.Intn(3) == 0
+		r = r.pushReservation(o/8, o%8, release)
+		s = s.pushReservation(o/8, o%8, release)
+	}
+	// Back to an array container
+	for i := 0; i < 20; i++ {
+		o := containerBits + i*3
+		r = r.pushReservation(o/8, o%8, true)
+		s = s.pushReservation(o/8, o%8, true)
+	}
+
+	for o := 0; o < numBits; o++ {
+		_, _, rerr := r.checkIfAvailable(o)
+		_, _, serr := s.checkIfAvailable(o)
+		if (rerr == nil) != (serr == nil) {
+			t.Fatalf("Availability of bit %d differs: roaring %v, sequence %v", o, rerr, serr)
+		}
+	}
+	if _, _, err := r.checkIfAvailable(numBits); err == nil {
+		t.Fatal("Bit beyond the end of the mask reported as available")
+	}
+
+	rb, rp, _ := r.firstAvailable()
+	sb, sp, _ := s.firstAvailable()
+	if rb != sb || rp != sp {
+		t.Fatalf("First available bit differs: roaring (%d, %d), sequence (%d, %d)", rb, rp, sb, sp)
+	}
+	for _, n := range []uint32{0, 1, 500, containerBits, 2*containerBits + 7} {
+		rb, rp, rerr := r.nthAvailable(n)
+		sb, sp, serr := s.nthAvailable(n)
+		if rerr != nil || serr != nil || rb != sb || rp != sp {
+			t.Fatalf("Available bit %d differs: roaring (%d, %d, %v), sequence (%d, %d, %v)", n, rb, rp, rerr, sb, sp, serr)
+		}
+	}
+
+	rr, rl := r.freeRanges(numBits)
+	sr, sl := s.freeRanges(numBits)
+	if rr != sr || rl != sl {
+		t.Fatalf("Free ranges differ: roaring (%d, %d), sequence (%d, %d)", rr, rl, sr, sl)
+	}
+
+	data, err := r.toByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data)%8 != 4 {
+		t.Fatalf("Serialized roaring bitmap of length %d could be taken for a sequence", len(data))
+	}
+	d := newRoaringBitmap(numBits)
+	if err := d.fromByteArray(data); err != nil {
+		t.Fatal(err)
+	}
+	if d.String() != r.String() {
+		t.Fatalf("Deserialized bitmap %s differs from %s", d, r)
+	}
+	if err := d.fromByteArray(data[:len(data)-2]); err == nil {
+		t.Fatal("Truncated roaring bitmap was accepted")
+	}
+}
+
+func TestRoaringExhaust(t *testing.T) {
+	h, err := NewHandleWithBackend("test", nil, "roaring", 100, Roaring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		bytePos, bitPos, err := h.GetFirstAvailable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytePos*8+bitPos != i {
+			t.Fatalf("Expected bit %d, got (%d, %d)", i, bytePos, bitPos)
+		}
+		if err := h.PushReservation(bytePos, bitPos, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := h.GetFirstAvailable(); err == nil {
+		t.Fatal("Full bitmask has an available bit")
+	}
+	if h.Unselected() != 0 {
+		t.Fatalf("Unexpected unselected count %d", h.Unselected())
+	}
+
+	if _, err := NewHandleWithBackend("test", nil, "unknown", 100, Backend("bloom")); err == nil {
+		t.Fatal("Unknown backend was accepted")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+
+	h, err := NewHandle("test", ds, "migrate", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []int{0, 1, 2, 77, 1 << 16, 1<<20 - 1} {
+		if err := h.PushReservation(o/8, o%8, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The stored sequence is loaded as is, whatever the requested backend
+	h, err = NewHandleWithBackend("test", ds, "migrate", 1<<20, Roaring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Backend() != RunLength {
+		t.Fatalf("Stored bitmask loaded with backend %s", h.Backend())
+	}
+
+	if err := h.Migrate(Roaring); err != nil {
+		t.Fatal(err)
+	}
+	h, err = NewHandle("test", ds, "migrate", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Backend() != Roaring {
+		t.Fatalf("Migrated bitmask loaded with backend %s", h.Backend())
+	}
+	if h.Unselected() != 1<<20-6 {
+		t.Fatalf("Unexpected unselected count %d after migration", h.Unselected())
+	}
+	for _, o := range []int{0, 77, 1 << 16, 1<<20 - 1} {
+		if _, _, err := h.CheckIfAvailable(o); err == nil {
+			t.Fatalf("Bit %d was lost in the migration", o)
+		}
+	}
+	if bytePos, bitPos, _ := h.GetFirstAvailable(); bytePos != 0 || bitPos != 3 {
+		t.Fatalf("Unexpected first available bit (%d, %d)", bytePos, bitPos)
+	}
+
+	// And back
+	if err := h.Migrate(RunLength); err != nil {
+		t.Fatal(err)
+	}
+	if ranges, longest := h.FreeRanges(); ranges != 3 || longest != 1<<20-2-1<<16 {
+		t.Fatalf("Unexpected free ranges (%d, %d) after migrating back", ranges, longest)
+	}
+}
@@ -4,6 +4,8 @@
 package bitseq
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"sync"
 
@@ -24,7 +26,7 @@ const (
 type Handle struct {
 	bits       uint32
 	unselected uint32
-	head       *Sequence
+	head       bitmask
 	app        string
 	id         string
 	dbIndex    uint64
@@ -35,16 +37,24 @@ type Handle struct {
 
 // NewHandle returns a thread-safe instance of the bitmask handler
 func NewHandle(app string, ds datastore.DataStore, id string, numElements uint32) (*Handle, error) {
+	return NewHandleWithBackend(app, ds, id, numElements, RunLength)
+}
+
+// NewHandleWithBackend returns a thread-safe instance of the bitmask handler
+// representing the bitmask with the backend. A bitmask found in the datastore
+// keeps the backend it was stored with, see Migrate.
+func NewHandleWithBackend(app string, ds datastore.DataStore, id string, numElements uint32, backend Backend) (*Handle, error) {
+	head, err := newBitmask(backend, numElements)
+	if err != nil {
+		return nil, err
+	}
 	h := &Handle{
 		app:        app,
 		id:         id,
 		store:      ds,
 		bits:       numElements,
 		unselected: numElements,
-		head: &Sequence{
-			Block: 0x0,
-			Count: getNumBlocks(numElements),
-		},
+		head:       head,
 	}
 
 	if h.store == nil {
@@ -55,12 +65,12 @@ func NewHandle(app string, ds datastore.DataStore, id string, numElements uint32
 	h.watchForChanges()
 
 	// Get the initial status from the ds if present.
-	err := h.store.GetObject(datastore.Key(h.Key()...), h)
-	if err != datastore.ErrKeyNotFound {
+	err = h.store.GetObject(h.Key().String(), h)
+	if err != nil && err != datastore.ErrKeyNotFound {
 		return nil, err
 	}
 
-	return h, err
+	return h, nil
 }
 
 // Sequence reresents a recurring sequence of 32 bits long bitmasks
@@ -177,7 +187,18 @@ func (s *Sequence) FromByteArray(data []byte) error {
 func (h *Handle) GetFirstAvailable() (int, int, error) {
 	h.Lock()
 	defer h.Unlock()
-	return GetFirstAvailable(h.head)
+	return h.head.firstAvailable()
+}
+
+// GetNthAvailable returns the byte and bit position of the n-th unset bit,
+// counting from zero
+func (h *Handle) GetNthAvailable(n uint32) (int, int, error) {
+	h.Lock()
+	defer h.Unlock()
+	if n >= h.unselected {
+		return -1, -1, fmt.Errorf("no bit available")
+	}
+	return h.head.nthAvailable(n)
 }
 
 // CheckIfAvailable checks if the bit correspondent to the specified ordinal is unset
@@ -185,24 +206,37 @@ func (h *Handle) GetFirstAvailable() (int, int, error) {
 func (h *Handle) CheckIfAvailable(ordinal int) (int, int, error) {
 	h.Lock()
 	defer h.Unlock()
-	return CheckIfAvailable(h.head, ordinal)
+	return h.head.checkIfAvailable(ordinal)
 }
 
 // PushReservation pushes the bit reservation inside the bitmask.
 func (h *Handle) PushReservation(bytePos, bitPos int, release bool) error {
+	return h.PushReservationContext(context.Background(), bytePos, bitPos, release)
+}
+
+// PushReservationContext pushes the bit reservation inside the bitmask, the
+// datastore write giving up once ctx is done.
+func (h *Handle) PushReservationContext(ctx context.Context, bytePos, bitPos int, release bool) error {
 	// Create a copy of the current handler
 	h.Lock()
 	nh := &Handle{
-		app:      h.app,
-		id:       h.id,
-		store:    h.store,
-		dbIndex:  h.dbIndex,
-		head:     h.head.GetCopy(),
-		dbExists: h.dbExists,
+		app:        h.app,
+		id:         h.id,
+		store:      datastore.WithContext(ctx, h.store),
+		dbIndex:    h.dbIndex,
+		head:       h.head.getCopy(),
+		bits:       h.bits,
+		unselected: h.unselected,
+		dbExists:   h.dbExists,
 	}
 	h.Unlock()
 
-	nh.head = PushReservation(bytePos, bitPos, nh.head, release)
+	nh.head = nh.head.pushReservation(bytePos, bitPos, release)
+	if release {
+		nh.unselected++
+	} else {
+		nh.unselected--
+	}
 
 	err := nh.writeToStore()
 	if err == nil {
@@ -236,7 +270,7 @@ func (h *Handle) ToByteArray() ([]byte, error) {
 	defer h.Unlock()
 	copy(ba[0:4], netutils.U32ToA(h.bits))
 	copy(ba[4:8], netutils.U32ToA(h.unselected))
-	bm, err := h.head.ToByteArray()
+	bm, err := h.head.toByteArray()
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize head: %s", err.Error())
 	}
@@ -251,15 +285,31 @@ func (h *Handle) FromByteArray(ba []byte) error {
 		return fmt.Errorf("nil byte array")
 	}
 
-	nh := &Sequence{}
-	err := nh.FromByteArray(ba[8:])
-	if err != nil {
-		return fmt.Errorf("failed to deserialize head: %s", err.Error())
+	if len(ba) < 8 {
+		return fmt.Errorf("cannot deserialize handle of length %d", len(ba))
+	}
+	bits := netutils.ATo32(ba[0:4])
+
+	// The roaring bitmaps are told apart from the sequences stored before
+	// them by their magic
+	var nh bitmask
+	if bytes.HasPrefix(ba[8:], roaringMagic) {
+		rb := newRoaringBitmap(bits)
+		if err := rb.fromByteArray(ba[8:]); err != nil {
+			return fmt.Errorf("failed to deserialize head: %s", err.Error())
+		}
+		nh = rb
+	} else {
+		s := &Sequence{}
+		if err := s.FromByteArray(ba[8:]); err != nil {
+			return fmt.Errorf("failed to deserialize head: %s", err.Error())
+		}
+		nh = s
 	}
 
 	h.Lock()
 	h.head = nh
-	h.bits = netutils.ATo32(ba[0:4])
+	h.bits = bits
 	h.unselected = netutils.ATo32(ba[4:8])
 	h.Unlock()
 
@@ -278,6 +328,109 @@ func (h *Handle) Unselected() uint32 {
 	return h.unselected
 }
 
+// FreeRanges returns the number of runs of consecutive unset bits in the
+// bitmask and the length of the longest one
+func (h *Handle) FreeRanges() (uint32, uint32) {
+	h.Lock()
+	defer h.Unlock()
+	return h.head.freeRanges(h.bits)
+}
+
+// Backend returns the representation of the bitmask
+func (h *Handle) Backend() Backend {
+	h.Lock()
+	defer h.Unlock()
+	return h.head.backend()
+}
+
+// Migrate converts the bitmask to the backend, keeping its set bits, and
+// stores it in the new representation. It is how the bitmasks stored before a
+// backend was picked move to it.
+func (h *Handle) Migrate(backend Backend) error {
+	h.Lock()
+	if h.head.backend() == backend {
+		h.Unlock()
+		return nil
+	}
+	head, err := convert(h.head, backend, h.bits)
+	if err != nil {
+		h.Unlock()
+		return err
+	}
+	nh := &Handle{
+		app:        h.app,
+		id:         h.id,
+		store:      h.store,
+		dbIndex:    h.dbIndex,
+		head:       head,
+		bits:       h.bits,
+		unselected: h.unselected,
+		dbExists:   h.dbExists,
+	}
+	h.Unlock()
+
+	if err := nh.writeToStore(); err != nil {
+		return err
+	}
+
+	h.Lock()
+	h.head = nh.head
+	h.dbIndex = nh.Index()
+	h.dbExists = true
+	h.Unlock()
+
+	return nil
+}
+
+// FreeRanges returns the number of runs of consecutive unset bits among the
+// first numBits bits of the passed mask and the length of the longest one
+func FreeRanges(head *Sequence, numBits uint32) (uint32, uint32) {
+	var ranges, longest, current, ordinal uint32
+
+	free := func(n uint32) {
+		if current == 0 {
+			ranges++
+		}
+		current += n
+	}
+	taken := func() {
+		if current > longest {
+			longest = current
+		}
+		current = 0
+	}
+
+	for p := head; p != nil && ordinal < numBits; p = p.Next {
+		// Whole free or taken runs are accounted at once
+		if p.Block == 0x0 || p.Block == blockMAX {
+			n := numBits - ordinal
+			if run := uint64(p.Count) * blockLen; run < uint64(n) {
+				n = uint32(run)
+			}
+			if p.Block == 0x0 {
+				free(n)
+			} else {
+				taken()
+			}
+			ordinal += n
+			continue
+		}
+		for c := uint32(0); c < p.Count && ordinal < numBits; c++ {
+			for bitSel := uint32(blockFirstBit); bitSel > 0 && ordinal < numBits; bitSel >>= 1 {
+				if p.Block&bitSel == 0 {
+					free(1)
+				} else {
+					taken()
+				}
+				ordinal++
+			}
+		}
+	}
+	taken()
+
+	return ranges, longest
+}
+
 // GetFirstAvailable looks for the first unset bit in passed mask
 func GetFirstAvailable(head *Sequence) (int, int, error) {
 	byteIndex := 0
@@ -293,6 +446,44 @@ func GetFirstAvailable(head *Sequence) (int, int, error) {
 	return -1, -1, fmt.Errorf("no bit available")
 }
 
+// GetNthAvailable looks for the n-th unset bit in passed mask, counting
+// from zero
+func GetNthAvailable(head *Sequence, n uint32) (int, int, error) {
+	byteIndex := 0
+	for current := head; current != nil; current = current.Next {
+		free := uint64(blockLen - countSet(current.Block))
+		if uint64(n) < free*uint64(current.Count) {
+			// Skip the preceding blocks of the sequence, then the
+			// preceding unset bits of the block
+			byteIndex += int(uint64(n)/free) * blockBytes
+			n = uint32(uint64(n) % free)
+			bits := 0
+			for bitSel := uint32(blockFirstBit); ; bitSel >>= 1 {
+				if current.Block&bitSel == 0 {
+					if n == 0 {
+						break
+					}
+					n--
+				}
+				bits++
+			}
+			return byteIndex + bits/8, bits % 8, nil
+		}
+		n -= uint32(free * uint64(current.Count))
+		byteIndex += int(current.Count * blockBytes)
+	}
+	return -1, -1, fmt.Errorf("no bit available")
+}
+
+// countSet returns the number of set bits in the block
+func countSet(block uint32) int {
+	n := 0
+	for ; block != 0; block &= block - 1 {
+		n++
+	}
+	return n
+}
+
 // CheckIfAvailable checks if the bit correspondent to the specified ordinal is unset
 // If the ordinal is beyond the Sequence limits, a negative response is returned
 func CheckIfAvailable(head *Sequence, ordinal int) (int, int, error) {
@@ -399,7 +590,7 @@ func PushReservation(bytePos, bitPos int, head *Sequence, release bool) *Sequenc
 		}
 		removeCurrentIfEmpty(&newHead, newSequence, current)
 		mergeSequences(previous)
-	} else if precBlocks == current.Count-2 { // Last in sequence (B)
+	} else if precBlocks == current.Count { // Last in sequence (B)
 		newSequence.Next = current.Next
 		current.Next = newSequence
 		mergeSequences(current)
@@ -186,6 +186,36 @@ func TestGetFirstAvailable(t *testing.T) {
 	}
 }
 
+func TestGetNthAvailable(t *testing.T) {
+	input := []struct {
+		mask    *Sequence
+		n       uint32
+		bytePos int
+		bitPos  int
+	}{
+		{&Sequence{Block: 0xffffffff, Count: 2048}, 0, -1, -1},
+		{&Sequence{Block: 0x0, Count: 8}, 0, 0, 0},
+		{&Sequence{Block: 0x0, Count: 8}, 9, 1, 1},
+		{&Sequence{Block: 0x0, Count: 8}, 255, 31, 7},
+		{&Sequence{Block: 0x0, Count: 8}, 256, -1, -1},
+		{&Sequence{Block: 0x80000000, Count: 8}, 0, 0, 1},
+		{&Sequence{Block: 0x80000000, Count: 8}, 31, 4, 1},
+		{&Sequence{Block: 0xAAAAAAAA, Count: 2}, 3, 0, 7},
+		{&Sequence{Block: 0xAAAAAAAA, Count: 2}, 16, 4, 1},
+		{&Sequence{Block: 0xffffffff, Count: 1, Next: &Sequence{Block: 0xF0F00000, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 6}}}, 0, 4, 4},
+		{&Sequence{Block: 0xffffffff, Count: 1, Next: &Sequence{Block: 0xF0F00000, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 6}}}, 4, 5, 4},
+		{&Sequence{Block: 0xffffffff, Count: 1, Next: &Sequence{Block: 0xF0F00000, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 6}}}, 23, 7, 7},
+		{&Sequence{Block: 0xffffffff, Count: 1, Next: &Sequence{Block: 0xF0F00000, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 6}}}, 24, -1, -1},
+	}
+
+	for n, i := range input {
+		bytePos, bitPos, _ := GetNthAvailable(i.mask, i.n)
+		if bytePos != i.bytePos || bitPos != i.bitPos {
+			t.Fatalf("Error in (%d) GetNthAvailable(%d). Expected (%d, %d). Got (%d, %d)", n, i.n, i.bytePos, i.bitPos, bytePos, bitPos)
+		}
+	}
+}
+
 func TestFindSequence(t *testing.T) {
 	input := []struct {
 		head           *Sequence
@@ -369,6 +399,10 @@ func TestPushReservation(t *testing.T) {
 		{&Sequence{Block: 0xffffffff, Count: 7, Next: &Sequence{Block: 0xfffffffe, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 1}}}, 31, 7,
 			&Sequence{Block: 0xffffffff, Count: 9}},
 
+		// Block in the middle or last of its Sequence
+		{&Sequence{Block: 0x0, Count: 5}, 8, 0, &Sequence{Block: 0x0, Count: 2, Next: &Sequence{Block: 0x80000000, Count: 1, Next: &Sequence{Block: 0x0, Count: 2}}}},
+		{&Sequence{Block: 0x0, Count: 5}, 16, 0, &Sequence{Block: 0x0, Count: 4, Next: &Sequence{Block: 0x80000000, Count: 1}}},
+
 		// Redundant push: No change
 		{&Sequence{Block: 0xffff0000, Count: 1}, 0, 0, &Sequence{Block: 0xffff0000, Count: 1}},
 		{&Sequence{Block: 0xffff0000, Count: 7}, 25, 7, &Sequence{Block: 0xffff0000, Count: 7}},
@@ -425,3 +459,27 @@ func TestSerializeDeserialize(t *testing.T) {
 		t.Fatalf("Sequences are different: \n%v\n%v", s, r)
 	}
 }
+
+func TestFreeRanges(t *testing.T) {
+	input := []struct {
+		head    *Sequence
+		bits    uint32
+		ranges  uint32
+		longest uint32
+	}{
+		{&Sequence{Block: 0x0, Count: 8}, 256, 1, 256},
+		{&Sequence{Block: 0x0, Count: 8}, 250, 1, 250},
+		{&Sequence{Block: 0xffffffff, Count: 8}, 256, 0, 0},
+		{&Sequence{Block: 0x80000000, Count: 1, Next: &Sequence{Block: 0x0, Count: 7}}, 256, 1, 255},
+		{&Sequence{Block: 0x80000001, Count: 1, Next: &Sequence{Block: 0x0, Count: 7}}, 256, 2, 224},
+		{&Sequence{Block: 0xffffffff, Count: 1, Next: &Sequence{Block: 0xF0F00000, Count: 1, Next: &Sequence{Block: 0xffffffff, Count: 6}}}, 256, 2, 20},
+		{&Sequence{Block: 0x0, Count: 1 << 27}, 1<<32 - 1, 1, 1<<32 - 1},
+	}
+
+	for n, i := range input {
+		ranges, longest := FreeRanges(i.head, i.bits)
+		if ranges != i.ranges || longest != i.longest {
+			t.Fatalf("Error in (%d) FreeRanges(%s): expected (%d, %d), got (%d, %d)", n, i.head, i.ranges, i.longest, ranges, longest)
+		}
+	}
+}
@@ -3,24 +3,27 @@ package bitseq
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/types"
 )
 
-// Key provides the Key to be used in KV Store
-func (h *Handle) Key() []string {
+// Key provides the Key to be used in KV Store. The id of the handle is a
+// path of its own, such as the address space and subnet of the ipam, whose
+// elements are kept as elements of the key.
+func (h *Handle) Key() datastore.KeyPath {
 	h.Lock()
 	defer h.Unlock()
-	return []string{h.app, h.id}
+	return datastore.NewKeyPath(h.app, strings.Split(h.id, "/")...)
 }
 
 // KeyPrefix returns the immediate parent key that can be used for tree walk
-func (h *Handle) KeyPrefix() []string {
+func (h *Handle) KeyPrefix() datastore.KeyPath {
 	h.Lock()
 	defer h.Unlock()
-	return []string{h.app}
+	return datastore.NewKeyPath(h.app)
 }
 
 // Value marshals the data to be stored in the KV store
@@ -40,7 +43,7 @@ func (h *Handle) Value() []byte {
 
 // SetValue unmarshals the data from the KV store
 func (h *Handle) SetValue(value []byte) error {
-	return h.FromByteArray(value)
+	return h.fromDsValue(value)
 }
 
 // Index returns the latest DB Index as seen by this object
@@ -74,7 +77,7 @@ func (h *Handle) watchForChanges() error {
 		return nil
 	}
 
-	kvpChan, err := store.KVStore().Watch(datastore.Key(h.Key()...), nil)
+	kvpChan, err := store.KVStore().Watch(h.Key().String(), nil)
 	if err != nil {
 		return err
 	}
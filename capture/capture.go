@@ -0,0 +1,278 @@
+// Package capture records the traffic of a network interface in pcap format
+// into a bounded buffer, so that the packets of an endpoint can be inspected
+// without installing capture tools on the host.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// DefaultSnaplen is the number of bytes captured per packet if not specified
+	DefaultSnaplen = 65535
+	// MaxSnaplen is the largest number of bytes which can be captured per packet
+	MaxSnaplen = 262144
+	// DefaultBufferSize is the capture buffer size if not specified
+	DefaultBufferSize = 1 << 20
+	// MaxBufferSize is the largest capture buffer size
+	MaxBufferSize = 64 << 20
+	// DefaultDuration is the capture duration if not specified
+	DefaultDuration = time.Minute
+	// MaxDuration is the longest capture duration
+	MaxDuration = time.Hour
+	// MaxFilterLen is the largest number of filter instructions accepted by the kernel
+	MaxFilterLen = 4096
+
+	linkTypeEthernet = 1
+	pcapHeaderLen    = 24
+	recordHeaderLen  = 16
+)
+
+// Instruction is a classic BPF instruction
+type Instruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+// Options bounds a capture and selects the captured packets
+type Options struct {
+	// Snaplen is the maximum number of bytes captured per packet
+	Snaplen int
+	// BufferSize bounds the captured data held until it is read. The oldest
+	// unread packets are dropped when it is exceeded.
+	BufferSize int
+	// Duration after which the capture stops
+	Duration time.Duration
+	// Filter is the BPF program selecting the captured packets
+	Filter []Instruction
+}
+
+// Validate checks the options are within bounds
+func (o *Options) Validate() error {
+	if o.Snaplen < 0 || o.Snaplen > MaxSnaplen {
+		return types.BadRequestErrorf("snaplen must be between 0 and %d: %d", MaxSnaplen, o.Snaplen)
+	}
+	if o.BufferSize < 0 || o.BufferSize > MaxBufferSize {
+		return types.BadRequestErrorf("capture buffer size must be between 0 and %d: %d", MaxBufferSize, o.BufferSize)
+	}
+	if o.Duration < 0 || o.Duration > MaxDuration {
+		return types.BadRequestErrorf("capture duration must be between 0 and %s: %s", MaxDuration, o.Duration)
+	}
+	if len(o.Filter) > MaxFilterLen {
+		return types.BadRequestErrorf("capture filter is longer than %d instructions", MaxFilterLen)
+	}
+	return nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.Snaplen == 0 {
+		o.Snaplen = DefaultSnaplen
+	}
+	if o.BufferSize == 0 {
+		o.BufferSize = DefaultBufferSize
+	}
+	if o.Duration == 0 {
+		o.Duration = DefaultDuration
+	}
+	return o
+}
+
+// ParseFilter parses a BPF program in the format printed by tcpdump -ddd:
+// the number of instructions followed by one "op jt jf k" line each.
+func ParseFilter(s string) ([]Instruction, error) {
+	sc := bufio.NewScanner(strings.NewReader(s))
+	var (
+		filter []Instruction
+		count  = -1
+	)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if count < 0 {
+			n, err := strconv.Atoi(line)
+			if err != nil || n <= 0 || n > MaxFilterLen {
+				return nil, types.BadRequestErrorf("invalid capture filter length: %s", line)
+			}
+			count = n
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, types.BadRequestErrorf("invalid capture filter instruction: %s", line)
+		}
+		var v [4]uint64
+		for i, f := range fields {
+			bits := 8
+			switch i {
+			case 0:
+				bits = 16
+			case 3:
+				bits = 32
+			}
+			n, err := strconv.ParseUint(f, 10, bits)
+			if err != nil {
+				return nil, types.BadRequestErrorf("invalid capture filter instruction: %s", line)
+			}
+			v[i] = n
+		}
+		filter = append(filter, Instruction{Op: uint16(v[0]), Jt: uint8(v[1]), Jf: uint8(v[2]), K: uint32(v[3])})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if count < 0 || len(filter) != count {
+		return nil, types.BadRequestErrorf("capture filter has %d instructions, expected %d", len(filter), count)
+	}
+	return filter, nil
+}
+
+// Capture streams the captured packets in pcap format. Reading blocks until
+// packets are captured and returns io.EOF once the capture has stopped and
+// the buffered packets have been read.
+type Capture struct {
+	header  []byte
+	records [][]byte
+	size    int
+	limit   int
+	off     int
+	dropped uint64
+	done    bool
+	err     error
+	cond    *sync.Cond
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	sync.Mutex
+}
+
+func newCapture(opts Options) *Capture {
+	c := &Capture{
+		header:  pcapHeader(opts.Snaplen),
+		limit:   opts.BufferSize,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.Mutex)
+	return c
+}
+
+// Read implements io.Reader
+func (c *Capture) Read(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	for len(c.header) == 0 && len(c.records) == 0 && !c.done {
+		c.cond.Wait()
+	}
+
+	if len(c.header) > 0 {
+		n := copy(p, c.header)
+		c.header = c.header[n:]
+		return n, nil
+	}
+
+	if len(c.records) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.records[0][c.off:])
+	c.off += n
+	if c.off == len(c.records[0]) {
+		c.size -= len(c.records[0])
+		c.records = c.records[1:]
+		c.off = 0
+	}
+	return n, nil
+}
+
+// Stop ends the capture. The packets captured so far can still be read.
+func (c *Capture) Stop() {
+	c.once.Do(func() { close(c.stop) })
+	<-c.stopped
+}
+
+// Close ends the capture and discards the packets not yet read
+func (c *Capture) Close() error {
+	c.Stop()
+	c.Lock()
+	c.header = nil
+	c.records = nil
+	c.size = 0
+	c.off = 0
+	c.Unlock()
+	return nil
+}
+
+// Dropped returns the number of packets dropped because the buffer was full
+func (c *Capture) Dropped() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.dropped
+}
+
+// push queues a packet, dropping the oldest unread ones if the buffer
+// would overflow. A partially read packet is never dropped.
+func (c *Capture) push(ts time.Time, data []byte, origLen int) {
+	rec := make([]byte, recordHeaderLen+len(data))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(origLen))
+	copy(rec[recordHeaderLen:], data)
+
+	c.Lock()
+	defer c.Unlock()
+
+	first := 0
+	if c.off > 0 {
+		first = 1
+	}
+	for c.size+len(rec) > c.limit {
+		if first >= len(c.records) {
+			c.dropped++
+			return
+		}
+		c.size -= len(c.records[first])
+		c.records = append(c.records[:first], c.records[first+1:]...)
+		c.dropped++
+	}
+
+	c.records = append(c.records, rec)
+	c.size += len(rec)
+	c.cond.Broadcast()
+}
+
+// finish marks the end of the capture, recording the error which ended it
+func (c *Capture) finish(err error) {
+	c.Lock()
+	c.done = true
+	c.err = err
+	c.cond.Broadcast()
+	c.Unlock()
+	close(c.stopped)
+}
+
+func pcapHeader(snaplen int) []byte {
+	h := make([]byte, pcapHeaderLen)
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], uint32(snaplen))
+	binary.LittleEndian.PutUint32(h[20:], linkTypeEthernet)
+	return h
+}
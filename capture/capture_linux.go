@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// pollInterval bounds how long the capture takes to notice it was stopped
+const pollInterval = 100 * time.Millisecond
+
+// Start captures the packets sent and received on the named interface until
+// the capture is stopped, its duration expires or the interface is removed.
+func Start(ifName string, opts Options) (*Capture, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %v", ifName, err)
+	}
+
+	// The socket does not receive anything until it is bound, so that no
+	// packet escapes the filter
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture socket: %v", err)
+	}
+
+	if err := setupSocket(fd, iface.Index, opts.Filter); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	c := newCapture(opts)
+	go c.loop(fd, iface.Index, opts.Snaplen, time.Now().Add(opts.Duration))
+
+	return c, nil
+}
+
+func setupSocket(fd, index int, filter []Instruction) error {
+	if len(filter) > 0 {
+		prog := make([]syscall.SockFilter, len(filter))
+		for i, ins := range filter {
+			prog[i] = syscall.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+		}
+		if err := syscall.AttachLsf(fd, prog); err != nil {
+			return fmt.Errorf("failed to attach capture filter: %v", err)
+		}
+	}
+
+	tv := syscall.NsecToTimeval(int64(pollInterval))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("failed to set capture socket timeout: %v", err)
+	}
+
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: index}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return fmt.Errorf("failed to bind capture socket: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Capture) loop(fd, index, snaplen int, deadline time.Time) {
+	defer syscall.Close(fd)
+
+	buf := make([]byte, snaplen)
+	for {
+		select {
+		case <-c.stop:
+			c.finish(nil)
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			c.finish(nil)
+			return
+		}
+
+		// With MSG_TRUNC the original packet length is returned
+		n, _, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			// The removal of an interface which is down is not
+			// reported on the socket, so it is checked for on timeouts
+			if err == syscall.EAGAIN || err == syscall.EINTR || err == syscall.ENETDOWN {
+				if !isBound(fd, index) {
+					c.finish(fmt.Errorf("capture ended: interface was removed"))
+					return
+				}
+				continue
+			}
+			c.finish(fmt.Errorf("capture ended: %v", err))
+			return
+		}
+
+		captured := n
+		if captured > snaplen {
+			captured = snaplen
+		}
+		c.push(time.Now(), buf[:captured], n)
+	}
+}
+
+// isBound tells whether the socket is still bound to the interface, as the
+// kernel unbinds it when the interface is removed
+func isBound(fd, index int) bool {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return false
+	}
+	ll, ok := sa.(*syscall.SockaddrLinklayer)
+	return ok && ll.Ifindex == index
+}
+
+// htons converts v to network byte order
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return nl.NativeEndian().Uint16(b)
+}
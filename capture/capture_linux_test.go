@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func captureUDP(t *testing.T, opts Options) []byte {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Start("lo", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * pollInterval)
+	c.Stop()
+
+	out, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) < pcapHeaderLen || binary.LittleEndian.Uint32(out) != 0xa1b2c3d4 {
+		t.Fatalf("Invalid pcap stream %v", out)
+	}
+	return out[pcapHeaderLen:]
+}
+
+func TestStart(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	if _, err := Start("lo", Options{Duration: -1}); err == nil {
+		t.Fatal("Expected failure for invalid options")
+	}
+	if _, err := Start("nonexistent0", Options{}); err == nil {
+		t.Fatal("Expected failure for a missing interface")
+	}
+
+	records := captureUDP(t, Options{Snaplen: 34})
+	if len(records) < recordHeaderLen+34 {
+		t.Fatalf("No packet captured")
+	}
+	if binary.LittleEndian.Uint32(records[8:]) != 34 || binary.LittleEndian.Uint32(records[12:]) <= 34 {
+		t.Fatalf("Packet was not truncated to the snaplen: %v", records[:recordHeaderLen])
+	}
+
+	// Reject all packets
+	records = captureUDP(t, Options{Filter: []Instruction{{Op: 6}}})
+	if len(records) != 0 {
+		t.Fatalf("Filtered packets were captured: %v", records)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	c, err := Start("lo", Options{Duration: pollInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(c)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * pollInterval):
+		t.Fatal("Capture did not stop after its duration")
+	}
+}
@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseFilter(t *testing.T) {
+	// tcpdump -ddd ip
+	filter, err := ParseFilter("4\n40 0 0 12\n21 0 1 2048\n6 0 0 262144\n6 0 0 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != 4 {
+		t.Fatalf("Expected 4 instructions, got %d", len(filter))
+	}
+	if filter[1] != (Instruction{Op: 21, Jt: 0, Jf: 1, K: 2048}) {
+		t.Fatalf("Unexpected instruction %v", filter[1])
+	}
+
+	for _, s := range []string{
+		"",
+		"2\n6 0 0 0\n",
+		"1\n6 0 0\n",
+		"1\n6 0 256 0\n",
+		"1\n65536 0 0 0\n",
+		"x\n6 0 0 0\n",
+	} {
+		if _, err := ParseFilter(s); err == nil {
+			t.Fatalf("Expected failure parsing %q", s)
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := []Options{
+		{},
+		{Snaplen: 128, BufferSize: 4096, Duration: time.Second},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Fatalf("Unexpected failure for %v: %v", o, err)
+		}
+	}
+
+	invalid := []Options{
+		{Snaplen: -1},
+		{Snaplen: MaxSnaplen + 1},
+		{BufferSize: MaxBufferSize + 1},
+		{Duration: -time.Second},
+		{Duration: MaxDuration + time.Second},
+		{Filter: make([]Instruction, MaxFilterLen+1)},
+	}
+	for _, o := range invalid {
+		err := o.Validate()
+		if err == nil {
+			t.Fatalf("Expected failure for %v", o)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for %v: %v", o, err)
+		}
+	}
+}
+
+func TestCaptureBuffer(t *testing.T) {
+	// Room for two 10 bytes packets
+	c := newCapture(Options{Snaplen: 64, BufferSize: 2 * (recordHeaderLen + 10)})
+	ts := time.Unix(1000, 5000)
+
+	header := make([]byte, pcapHeaderLen)
+	if _, err := io.ReadFull(c, header); err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(header) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(header[16:]) != 64 {
+		t.Fatalf("Unexpected pcap header %v", header)
+	}
+
+	for i := byte(0); i < 3; i++ {
+		data := make([]byte, 10)
+		data[0] = i
+		c.push(ts, data, 100)
+	}
+	if c.Dropped() != 1 {
+		t.Fatalf("Expected the oldest packet to be dropped, dropped %d", c.Dropped())
+	}
+
+	// A partially read packet is kept
+	rec := make([]byte, recordHeaderLen+10)
+	if _, err := io.ReadFull(c, rec[:4]); err != nil {
+		t.Fatal(err)
+	}
+	c.push(ts, make([]byte, 10), 10)
+	if c.Dropped() != 2 {
+		t.Fatalf("Expected the unread packet to be dropped, dropped %d", c.Dropped())
+	}
+	if _, err := io.ReadFull(c, rec[4:]); err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(rec) != 1000 || binary.LittleEndian.Uint32(rec[4:]) != 5 ||
+		binary.LittleEndian.Uint32(rec[8:]) != 10 || binary.LittleEndian.Uint32(rec[12:]) != 100 || rec[recordHeaderLen] != 1 {
+		t.Fatalf("Unexpected record %v", rec)
+	}
+
+	c.finish(nil)
+	rest, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != recordHeaderLen+10 {
+		t.Fatalf("Expected one buffered packet after the end of the capture, got %d bytes", len(rest))
+	}
+}
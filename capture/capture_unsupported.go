@@ -0,0 +1,10 @@
+// +build !linux
+
+package capture
+
+import "github.com/docker/libnetwork/types"
+
+// Start is not supported on this platform
+func Start(ifName string, opts Options) (*Capture, error) {
+	return nil, types.NotImplementedErrorf("packet capture is not supported on this platform")
+}
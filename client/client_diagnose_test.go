@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	_ "github.com/docker/libnetwork/netutils"
+)
+
+// diagnoseDaemon fakes the snapshot, state and drift resources of a daemon
+type diagnoseDaemon struct {
+	endpoints []namedResource
+	state     driverapi.ProgrammedState
+	drift     []driverapi.Drift
+}
+
+func (d *diagnoseDaemon) call(method, path string, data interface{}, headers map[string][]string) (io.ReadCloser, http.Header, int, error) {
+	var rsp interface{}
+	switch path {
+	case "/snapshot":
+		rsp = map[string]interface{}{
+			"networks": []interface{}{
+				map[string]interface{}{"network": namedResource{Name: "net1", ID: "n1"}, "endpoints": d.endpoints},
+				map[string]interface{}{"network": namedResource{Name: "host", ID: "n2"}, "endpoints": []namedResource{}},
+			},
+		}
+	case "/networks/n1/state":
+		rsp = d.state
+	case "/networks/n1/drift":
+		rsp = d.drift
+	default:
+		return nil, nil, http.StatusNotImplemented, fmt.Errorf("error : not implemented")
+	}
+	b, err := json.Marshal(rsp)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return nopCloser{bytes.NewBuffer(b)}, http.Header{}, http.StatusOK, nil
+}
+
+func TestClientDiagnose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagnose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &diagnoseDaemon{
+		endpoints: []namedResource{{Name: "ep1", ID: "e1"}},
+		state: driverapi.ProgrammedState{
+			Devices: []driverapi.ProgrammedDevice{{Name: "br0", Kind: "bridge"}},
+			Rules:   []driverapi.ProgrammedRule{{Table: "nat", Chain: "POSTROUTING", Args: []string{"-j", "MASQUERADE"}}},
+		},
+		drift: []driverapi.Drift{},
+	}
+	var out, errOut bytes.Buffer
+	cli := NewNetworkCli(&out, &errOut, d.call)
+
+	before := filepath.Join(dir, "before.json")
+	if err := cli.Cmd("dnet", "diagnose", "snapshot", "-o", before); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := readDiagnoseSnapshot(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd := snap.Networks["n1"]; nd == nil || nd.State == nil || len(nd.State.Rules) != 1 {
+		t.Fatalf("Unexpected diagnosis of network net1 %+v", nd)
+	}
+	if nd := snap.Networks["n2"]; nd == nil || nd.Error == "" {
+		t.Fatalf("Expected the state of network host to fail. Got %+v", nd)
+	}
+
+	if err := cli.Cmd("dnet", "diagnose", "compare", before, before); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "No drift\n" {
+		t.Fatalf("Expected no drift between identical snapshots. Got %q", out.String())
+	}
+
+	d.endpoints = append(d.endpoints, namedResource{Name: "ep2", ID: "e2"})
+	d.state.Rules = append(d.state.Rules, driverapi.ProgrammedRule{Table: "nat", Chain: "DOCKER", Args: []string{"-p", "tcp", "--dport", "8080", "-j", "DNAT"}})
+	d.drift = append(d.drift, driverapi.Drift{Kind: driverapi.DriftDevice, Object: "veth1", Problem: "missing"})
+
+	out.Reset()
+	if err := cli.Cmd("dnet", "diagnose", "compare", before); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"+ endpoint ep2 (e2) in network net1",
+		"+ rule iptables -t nat -A DOCKER -p tcp --dport 8080 -j DNAT in network net1",
+		"! drift device veth1: missing in network net1",
+	}
+	if strings.TrimSpace(out.String()) != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%s\nGot\n%s", strings.Join(expected, "\n"), out.String())
+	}
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+var (
+	diagnoseCommands = []command{
+		{"snapshot", "Dump the state of the daemon"},
+		{"compare", "Show the drift between two snapshots"},
+	}
+)
+
+// CmdDiagnose handles the root Diagnose UI
+func (cli *NetworkCli) CmdDiagnose(chain string, args ...string) error {
+	cmd := cli.Subcmd(chain, "diagnose", "COMMAND [OPTIONS] [arg...]", diagnoseUsage(chain), false)
+	cmd.Require(flag.Min, 1)
+	err := cmd.ParseFlags(args, true)
+	if err == nil {
+		cmd.Usage()
+		return fmt.Errorf("invalid command : %v", args)
+	}
+	return err
+}
+
+// CmdDiagnoseSnapshot handles Diagnose Snapshot UI
+func (cli *NetworkCli) CmdDiagnoseSnapshot(chain string, args ...string) error {
+	cmd := cli.Subcmd(chain, "snapshot", "", "Dumps the networks, endpoints and sandboxes of the daemon, with the devices, routes and iptables rules programmed for each network and their drift from the host", false)
+	flOutput := cmd.String([]string{"o", "-output"}, "", "Write the snapshot to a file instead of the standard output")
+	cmd.Require(flag.Exact, 0)
+	err := cmd.ParseFlags(args, true)
+	if err != nil {
+		return err
+	}
+
+	snap, err := cli.diagnose()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *flOutput != "" {
+		return ioutil.WriteFile(*flOutput, b, 0600)
+	}
+	fmt.Fprintf(cli.out, "%s\n", b)
+	return nil
+}
+
+// CmdDiagnoseCompare handles Diagnose Compare UI
+func (cli *NetworkCli) CmdDiagnoseCompare(chain string, args ...string) error {
+	cmd := cli.Subcmd(chain, "compare", "SNAPSHOT [SNAPSHOT]", "Compares two snapshots, or a snapshot with the current state of the daemon", false)
+	cmd.Require(flag.Min, 1)
+	cmd.Require(flag.Max, 2)
+	err := cmd.ParseFlags(args, true)
+	if err != nil {
+		return err
+	}
+
+	from, err := readDiagnoseSnapshot(cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+	var to *diagnoseSnapshot
+	if cmd.NArg() == 2 {
+		to, err = readDiagnoseSnapshot(cmd.Arg(1))
+	} else {
+		to, err = cli.diagnose()
+	}
+	if err != nil {
+		return err
+	}
+
+	diffs, err := compareSnapshots(from, to)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintln(cli.out, "No drift")
+		return nil
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(cli.out, d)
+	}
+	return nil
+}
+
+// diagnose takes a snapshot of the daemon and, for each of its networks,
+// retrieves the programmed state and the drift its driver reports. The
+// networks whose driver does not report them get the error instead.
+func (cli *NetworkCli) diagnose() (*diagnoseSnapshot, error) {
+	obj, _, err := readBody(cli.call("GET", "/snapshot", nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	snap := &diagnoseSnapshot{Controller: obj, Networks: map[string]*networkDiagnosis{}}
+	nws, err := snap.networks()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, nw := range nws {
+		nd := &networkDiagnosis{}
+		snap.Networks[id] = nd
+
+		obj, _, err := readBody(cli.call("GET", "/networks/"+id+"/state", nil, nil))
+		if err == nil {
+			nd.State = &driverapi.ProgrammedState{}
+			err = json.Unmarshal(obj, nd.State)
+		}
+		if err == nil {
+			obj, _, err = readBody(cli.call("GET", "/networks/"+id+"/drift", nil, nil))
+		}
+		if err == nil {
+			err = json.Unmarshal(obj, &nd.Drift)
+		}
+		if err != nil {
+			nd.State, nd.Drift = nil, nil
+			nd.Error = fmt.Sprintf("state of network %s: %v", nw.Network.Name, err)
+		}
+	}
+
+	return snap, nil
+}
+
+func readDiagnoseSnapshot(path string) (*diagnoseSnapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &diagnoseSnapshot{}
+	if err := json.Unmarshal(b, snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	return snap, nil
+}
+
+// networks returns the networks of the controller snapshot by id
+func (s *diagnoseSnapshot) networks() (map[string]*snapshotNetwork, error) {
+	cs := &snapshotResource{}
+	if err := json.Unmarshal(s.Controller, cs); err != nil {
+		return nil, fmt.Errorf("invalid controller snapshot: %v", err)
+	}
+	nws := make(map[string]*snapshotNetwork, len(cs.Networks))
+	for _, nw := range cs.Networks {
+		nws[nw.Network.ID] = nw
+	}
+	return nws, nil
+}
+
+// compareSnapshots returns the networks and endpoints added and removed
+// from one snapshot to the other, the devices, routes and rules their
+// drivers programmed or removed, and the drift from the host the second
+// snapshot shows and the first did not
+func compareSnapshots(from, to *diagnoseSnapshot) ([]string, error) {
+	fromNws, err := from.networks()
+	if err != nil {
+		return nil, err
+	}
+	toNws, err := to.networks()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id := range fromNws {
+		ids = append(ids, id)
+	}
+	for id := range toNws {
+		if _, ok := fromNws[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var diffs []string
+	for _, id := range ids {
+		fnw, tnw := fromNws[id], toNws[id]
+		switch {
+		case fnw == nil:
+			diffs = append(diffs, fmt.Sprintf("+ network %s (%s)", tnw.Network.Name, id))
+			continue
+		case tnw == nil:
+			diffs = append(diffs, fmt.Sprintf("- network %s (%s)", fnw.Network.Name, id))
+			continue
+		}
+
+		name := tnw.Network.Name
+		var fromEps, toEps []string
+		for _, ep := range fnw.Endpoints {
+			fromEps = append(fromEps, fmt.Sprintf("endpoint %s (%s)", ep.Name, ep.ID))
+		}
+		for _, ep := range tnw.Endpoints {
+			toEps = append(toEps, fmt.Sprintf("endpoint %s (%s)", ep.Name, ep.ID))
+		}
+		diffs = append(diffs, diffLines(name, fromEps, toEps)...)
+
+		fnd, tnd := from.Networks[id], to.Networks[id]
+		diffs = append(diffs, diffLines(name, fnd.stateLines(), tnd.stateLines())...)
+
+		drifted := make(map[string]bool)
+		for _, l := range fnd.driftLines() {
+			drifted[l] = true
+		}
+		for _, l := range tnd.driftLines() {
+			if !drifted[l] {
+				diffs = append(diffs, fmt.Sprintf("! %s in network %s", l, name))
+			}
+		}
+	}
+
+	return diffs, nil
+}
+
+// diffLines returns the lines added and removed from one list to the other
+func diffLines(network string, from, to []string) []string {
+	in := func(l string, ls []string) bool {
+		for _, o := range ls {
+			if o == l {
+				return true
+			}
+		}
+		return false
+	}
+
+	var diffs []string
+	for _, l := range from {
+		if !in(l, to) {
+			diffs = append(diffs, fmt.Sprintf("- %s in network %s", l, network))
+		}
+	}
+	for _, l := range to {
+		if !in(l, from) {
+			diffs = append(diffs, fmt.Sprintf("+ %s in network %s", l, network))
+		}
+	}
+	return diffs
+}
+
+// stateLines returns the devices, routes and rules of the programmed state
+func (nd *networkDiagnosis) stateLines() []string {
+	if nd == nil || nd.State == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, dev := range nd.State.Devices {
+		l := fmt.Sprintf("device %s (%s)", dev.Name, dev.Kind)
+		if dev.Master != "" {
+			l += " master " + dev.Master
+		}
+		for _, addr := range dev.Addresses {
+			l += " " + addr.String()
+		}
+		lines = append(lines, l)
+	}
+	for _, rt := range nd.State.Routes {
+		l := fmt.Sprintf("route %s dev %s", rt.Destination, rt.Device)
+		if rt.Gateway != nil {
+			l += " via " + rt.Gateway.String()
+		}
+		if rt.Table != 0 {
+			l += fmt.Sprintf(" table %d", rt.Table)
+		}
+		lines = append(lines, l)
+	}
+	for _, r := range nd.State.Rules {
+		cmd := "iptables"
+		if r.IPv6 {
+			cmd = "ip6tables"
+		}
+		lines = append(lines, fmt.Sprintf("rule %s -t %s -A %s %s", cmd, r.Table, r.Chain, strings.Join(r.Args, " ")))
+	}
+	return lines
+}
+
+func (nd *networkDiagnosis) driftLines() []string {
+	if nd == nil {
+		return nil
+	}
+	var lines []string
+	for _, d := range nd.Drift {
+		lines = append(lines, "drift "+d.String())
+	}
+	if nd.Error != "" {
+		lines = append(lines, "error "+nd.Error)
+	}
+	return lines
+}
+
+func diagnoseUsage(chain string) string {
+	help := "Commands:\n"
+
+	for _, cmd := range diagnoseCommands {
+		help += fmt.Sprintf("    %-25.25s%s\n", cmd.name, cmd.description)
+	}
+
+	help += fmt.Sprintf("\nRun '%s diagnose COMMAND --help' for more information on a command.", chain)
+	return help
+}
@@ -41,6 +41,7 @@ func (cli *NetworkCli) CmdNetwork(chain string, args ...string) error {
 func (cli *NetworkCli) CmdNetworkCreate(chain string, args ...string) error {
 	cmd := cli.Subcmd(chain, "create", "NETWORK-NAME", "Creates a new network with a name specified by the user", false)
 	flDriver := cmd.String([]string{"d", "-driver"}, "", "Driver to manage the Network")
+	flGlobal := cmd.Bool([]string{"-global"}, false, "Define the network on all the hosts sharing the datastore")
 	cmd.Require(flag.Exact, 1)
 	err := cmd.ParseFlags(args, true)
 	if err != nil {
@@ -49,7 +50,7 @@ func (cli *NetworkCli) CmdNetworkCreate(chain string, args ...string) error {
 
 	// Construct network create request body
 	ops := make(map[string]interface{})
-	nc := networkCreate{Name: cmd.Arg(0), NetworkType: *flDriver, Options: ops}
+	nc := networkCreate{Name: cmd.Arg(0), NetworkType: *flDriver, Options: ops, GlobalScope: *flGlobal}
 	obj, _, err := readBody(cli.call("POST", "/networks", nc, nil))
 	if err != nil {
 		return err
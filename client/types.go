@@ -1,6 +1,11 @@
 package client
 
-import "github.com/docker/libnetwork/types"
+import (
+	"encoding/json"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
 
 /***********
  Resources
@@ -26,6 +31,40 @@ type backendResource struct {
 	ID string `json:"id"`
 }
 
+// snapshotResource is the part of the body of the "get snapshot" http response
+// message diagnose compares
+type snapshotResource struct {
+	Networks []*snapshotNetwork `json:"networks"`
+}
+
+// snapshotNetwork is a network of the snapshot with its endpoints
+type snapshotNetwork struct {
+	Network   namedResource   `json:"network"`
+	Endpoints []namedResource `json:"endpoints"`
+}
+
+// namedResource is the name and id of a resource of the snapshot
+type namedResource struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// diagnoseSnapshot is the document written by diagnose snapshot: the
+// snapshot of the daemon and, by network id, what the drivers programmed
+type diagnoseSnapshot struct {
+	Controller json.RawMessage              `json:"controller"`
+	Networks   map[string]*networkDiagnosis `json:"networks"`
+}
+
+// networkDiagnosis is the state the driver of a network programmed on the
+// host and how the host drifted from it
+type networkDiagnosis struct {
+	State *driverapi.ProgrammedState `json:"state,omitempty"`
+	Drift []driverapi.Drift          `json:"drift,omitempty"`
+	// Error is why the driver did not report them
+	Error string `json:"error,omitempty"`
+}
+
 /***********
   Body types
   ************/
@@ -35,6 +74,7 @@ type networkCreate struct {
 	Name        string                 `json:"name"`
 	NetworkType string                 `json:"network_type"`
 	Options     map[string]interface{} `json:"options"`
+	GlobalScope bool                   `json:"global_scope,omitempty"`
 }
 
 // serviceCreate represents the body of the "publish service" http request message
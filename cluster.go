@@ -0,0 +1,62 @@
+package libnetwork
+
+import (
+	"context"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// clusterManager returns the driver of the network type managing the
+// members of its cluster
+func (c *controller) clusterManager(networkType string) (driverapi.ClusterManager, error) {
+	c.Lock()
+	dd, ok := c.drivers[networkType]
+	c.Unlock()
+	if !ok {
+		return nil, NetworkTypeError(networkType)
+	}
+	cm, ok := dd.driver.(driverapi.ClusterManager)
+	if !ok {
+		return nil, types.NotImplementedErrorf("%s driver has no cluster", networkType)
+	}
+	return cm, nil
+}
+
+func (c *controller) ClusterMembers(networkType string) ([]driverapi.ClusterMember, error) {
+	cm, err := c.clusterManager(networkType)
+	if err != nil {
+		return nil, err
+	}
+	return cm.ClusterMembers()
+}
+
+func (c *controller) RemoveClusterMember(ctx context.Context, networkType, name string) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	cm, err := c.clusterManager(networkType)
+	if err != nil {
+		return err
+	}
+	req := AuthzRequest{Operation: AuthzClusterManage, NetworkType: networkType, Params: map[string]string{"member": name}}
+	if err := c.authorize(ctx, req); err != nil {
+		return err
+	}
+	return cm.RemoveClusterMember(name)
+}
+
+func (c *controller) SetNodeAvailability(ctx context.Context, networkType, name, availability string) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	cm, err := c.clusterManager(networkType)
+	if err != nil {
+		return err
+	}
+	req := AuthzRequest{Operation: AuthzClusterManage, NetworkType: networkType, Params: map[string]string{"member": name, "availability": availability}}
+	if err := c.authorize(ctx, req); err != nil {
+		return err
+	}
+	return cm.SetNodeAvailability(name, availability)
+}
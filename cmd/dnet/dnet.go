@@ -2,13 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/pkg/parsers"
@@ -22,6 +25,7 @@ import (
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/spec"
 	"github.com/gorilla/mux"
 )
 
@@ -86,6 +90,48 @@ func processConfig(cfg *config.Config) []config.Option {
 	if strings.TrimSpace(cfg.Datastore.Client.Address) != "" {
 		options = append(options, config.OptionKVProviderURL(cfg.Datastore.Client.Address))
 	}
+	if cfg.Datastore.CompressionThreshold != 0 {
+		options = append(options, config.OptionKVCompression(cfg.Datastore.CompressionThreshold))
+	}
+	if cfg.Datastore.Exclusive {
+		options = append(options, config.OptionKVExclusive(cfg.Datastore.Takeover))
+	}
+	if cfg.Daemon.ReadOnly {
+		options = append(options, config.OptionReadOnly())
+	}
+	if cfg.Daemon.AuditRetention != 0 {
+		options = append(options, config.OptionAuditRetention(cfg.Daemon.AuditRetention))
+	}
+	if cfg.Daemon.ResolvConfPollInterval != 0 {
+		options = append(options, config.OptionResolvConfReload(cfg.Daemon.ResolvConfPollInterval))
+	}
+	if strings.TrimSpace(cfg.Daemon.SandboxStateDir) != "" {
+		options = append(options, config.OptionSandboxStateDir(cfg.Daemon.SandboxStateDir))
+	}
+	if len(cfg.Plugins.SocketDirs) != 0 {
+		options = append(options, config.OptionPluginSocketDirs(cfg.Plugins.SocketDirs...))
+	}
+	if len(cfg.Plugins.UnitDirs) != 0 {
+		options = append(options, config.OptionPluginUnitDirs(cfg.Plugins.UnitDirs...))
+	}
+	if cfg.Plugins.Timeout != 0 {
+		options = append(options, config.OptionPluginTimeout(cfg.Plugins.Timeout))
+	}
+	for name, timeout := range cfg.Plugins.Timeouts {
+		options = append(options, config.OptionPluginTimeoutFor(name, timeout))
+	}
+	if cfg.Daemon.SysctlPollInterval != 0 {
+		options = append(options, config.OptionSysctlWatch(cfg.Daemon.SysctlPollInterval))
+	}
+	if cfg.Daemon.SysctlRestore {
+		options = append(options, config.OptionSysctlRestore())
+	}
+	if cfg.Quotas.Default != (config.Quota{}) {
+		options = append(options, config.OptionDefaultQuota(cfg.Quotas.Default))
+	}
+	for tenant, quota := range cfg.Quotas.Tenants {
+		options = append(options, config.OptionTenantQuota(tenant, quota))
+	}
 	return options
 }
 
@@ -163,13 +209,55 @@ func createDefaultNetwork(c libnetwork.NetworkController) {
 			networkOption := libnetwork.NetworkOptionGeneric(genericOption)
 			createOptions = append(createOptions, networkOption)
 		}
-		_, err := c.NewNetwork(d, nw, createOptions...)
+		_, err := c.NewNetwork(context.Background(), d, nw, createOptions...)
 		if err != nil {
 			logrus.Errorf("Error creating default network : %s : %v", nw, err)
 		}
 	}
 }
 
+// watchNetworkSpec reconciles the networks with the spec file now and
+// every time the daemon receives SIGHUP.
+func watchNetworkSpec(c libnetwork.NetworkController, path string) {
+	r := spec.NewReconciler(c)
+	reconcile := func() {
+		s, err := spec.Load(path)
+		if err != nil {
+			logrus.Errorf("Error loading network spec %s: %v", path, err)
+			return
+		}
+		if err := r.Reconcile(s); err != nil {
+			logrus.Errorf("Error reconciling network spec %s: %v", path, err)
+		}
+	}
+
+	reconcile()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			logrus.Infof("Reloading network spec %s", path)
+			reconcile()
+		}
+	}()
+}
+
+// stopOnSignal stops the controller, restoring the kernel parameters if
+// configured, when the daemon is interrupted or terminated
+func stopOnSignal(c libnetwork.NetworkController) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logrus.Infof("Received %s, stopping", sig)
+		if err := c.Stop(); err != nil {
+			logrus.Errorf("Error stopping the network controller: %v", err)
+		}
+		os.Exit(0)
+	}()
+}
+
 type dnetConnection struct {
 	// proto holds the client protocol i.e. unix.
 	proto string
@@ -188,7 +276,11 @@ func (d *dnetConnection) dnetDaemon() error {
 		fmt.Println("Error starting dnetDaemon :", err)
 		return err
 	}
+	stopOnSignal(controller)
 	createDefaultNetwork(controller)
+	if cfg != nil && cfg.Daemon.NetworkSpec != "" {
+		watchNetworkSpec(controller, cfg.Daemon.NetworkSpec)
+	}
 	httpHandler := api.NewHTTPHandler(controller)
 	r := mux.NewRouter().StrictSlash(false)
 	post := r.PathPrefix("/{.*}/networks").Subrouter()
@@ -199,6 +291,10 @@ func (d *dnetConnection) dnetDaemon() error {
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
 	post = r.PathPrefix("/services").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/{.*}/snapshot").Subrouter()
+	post.Methods("GET").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/snapshot").Subrouter()
+	post.Methods("GET").HandlerFunc(httpHandler)
 	return http.ListenAndServe(d.addr, r)
 }
 
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/netutils"
 )
 
@@ -130,3 +131,48 @@ func executeDnetCommand(t *testing.T, args []string, shouldSucced bool) {
 	}
 	os.Stdout = origStdOut
 }
+
+func TestProcessConfig(t *testing.T) {
+	cfgFile, err := ioutil.TempFile("", "libnetwork.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(cfgFile.Name())
+	cfgFile.WriteString(`
+[daemon]
+  ReadOnly = true
+  AuditRetention = 10
+  ResolvConfPollInterval = 5000000000
+  SandboxStateDir = "/var/lib/dnet/sandboxes"
+[datastore]
+  CompressionThreshold = 1024
+  Exclusive = true
+  Takeover = true
+[quotas.default]
+  Networks = 2
+[quotas.tenants.acme]
+  Networks = 5
+  EndpointsPerNetwork = 10
+`)
+	cfgFile.Close()
+
+	cfg, err := parseConfig(cfgFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{}
+	c.ProcessOptions(processConfig(cfg)...)
+
+	if !c.Daemon.ReadOnly || c.Daemon.AuditRetention != 10 || c.Daemon.ResolvConfPollInterval != 5*time.Second || c.Daemon.SandboxStateDir != "/var/lib/dnet/sandboxes" {
+		t.Fatalf("Daemon configuration not forwarded: %+v", c.Daemon)
+	}
+	if c.Datastore.CompressionThreshold != 1024 || !c.Datastore.Exclusive || !c.Datastore.Takeover {
+		t.Fatalf("Datastore configuration not forwarded: %+v", c.Datastore)
+	}
+	if c.Quotas.Default.Networks != 2 {
+		t.Fatalf("Default quota not forwarded: %+v", c.Quotas.Default)
+	}
+	if q := c.Quotas.TenantQuota("acme"); q.Networks != 5 || q.EndpointsPerNetwork != 10 {
+		t.Fatalf("Tenant quota not forwarded: %+v", q)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -76,6 +77,10 @@ func (ep *endpoint) SetResolvConfPath(string) error {
 	return nil
 }
 
+func (ep *endpoint) AddBoundSocket(binding types.PortBinding, f *os.File) error {
+	return f.Close()
+}
+
 func main() {
 	if reexec.Init() {
 		return
@@ -103,20 +108,20 @@ func main() {
 
 	r.d.Config(opt)
 
-	if err := r.d.CreateNetwork(types.UUID("testnetwork"),
+	if err := r.d.CreateNetwork(context.Background(), types.UUID("testnetwork"),
 		map[string]interface{}{}); err != nil {
 		fmt.Printf("Failed to create network in the driver: %v\n", err)
 		os.Exit(1)
 	}
 
 	ep := &endpoint{}
-	if err := r.d.CreateEndpoint(types.UUID("testnetwork"), types.UUID("testep"),
+	if err := r.d.CreateEndpoint(context.Background(), types.UUID("testnetwork"), types.UUID("testep"),
 		ep, map[string]interface{}{}); err != nil {
 		fmt.Printf("Failed to create endpoint in the driver: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err := r.d.Join(types.UUID("testnetwork"), types.UUID("testep"),
+	if err := r.d.Join(context.Background(), types.UUID("testnetwork"), types.UUID("testep"),
 		"", ep, map[string]interface{}{}); err != nil {
 		fmt.Printf("Failed to join an endpoint in the driver: %v\n", err)
 		os.Exit(1)
@@ -141,7 +146,7 @@ func main() {
 	for {
 		select {
 		case <-sigCh:
-			r.d.Leave(types.UUID("testnetwork"), types.UUID("testep"))
+			r.d.Leave(context.Background(), types.UUID("testnetwork"), types.UUID("testep"))
 			overlay.Fini(r.d)
 			os.Exit(0)
 		}
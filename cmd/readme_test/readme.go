@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/docker/libnetwork"
@@ -22,14 +23,16 @@ func main() {
 	driverOptions := options.Generic{}
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = driverOptions
-	err = controller.ConfigureNetworkDriver(networkType, genericOption)
+	err = controller.ConfigureNetworkDriver(context.Background(), networkType, genericOption)
 	if err != nil {
 		return
 	}
 
 	// Create a network for containers to join.
 	// NewNetwork accepts Variadic optional arguments that libnetwork and Drivers can make of
-	network, err := controller.NewNetwork(networkType, "network1")
+	// The operations reaching the drivers and the datastore give up once their context is done.
+	ctx := context.Background()
+	network, err := controller.NewNetwork(ctx, networkType, "network1")
 	if err != nil {
 		return
 	}
@@ -38,7 +41,7 @@ func main() {
 	// settings will be used for container infos (inspect and such), as well as
 	// iptables rules for port publishing. This info is contained or accessible
 	// from the returned endpoint.
-	ep, err := network.CreateEndpoint("Endpoint1")
+	ep, err := network.CreateEndpoint(ctx, "Endpoint1")
 	if err != nil {
 		return
 	}
@@ -46,7 +49,7 @@ func main() {
 	// A container can join the endpoint by providing the container ID to the join
 	// api.
 	// Join acceps Variadic arguments which will be made use of by libnetwork and Drivers
-	err = ep.Join("container1",
+	err = ep.Join(ctx, "container1",
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"))
 	if err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -23,9 +24,9 @@ func main() {
 	net.IP = ip
 	options := options.Generic{"AddressIPv4": net}
 
-	err = controller.ConfigureNetworkDriver(netType, options)
+	err = controller.ConfigureNetworkDriver(context.Background(), netType, options)
 	for i := 0; i < 10; i++ {
-		netw, err := controller.NewNetwork(netType, fmt.Sprintf("Gordon-%d", i))
+		netw, err := controller.NewNetwork(context.Background(), netType, fmt.Sprintf("Gordon-%d", i))
 		if err != nil {
 			if _, ok := err.(libnetwork.NetworkNameError); !ok {
 				log.Fatal(err)
@@ -34,12 +35,12 @@ func main() {
 			fmt.Println("Network Created Successfully :", netw)
 		}
 		netw, _ = controller.NetworkByName(fmt.Sprintf("Gordon-%d", i))
-		_, err = netw.CreateEndpoint(fmt.Sprintf("Gordon-Ep-%d", i), nil)
+		_, err = netw.CreateEndpoint(context.Background(), fmt.Sprintf("Gordon-Ep-%d", i), nil)
 		if err != nil {
 			log.Fatalf("Error creating endpoint 1 %v", err)
 		}
 
-		_, err = netw.CreateEndpoint(fmt.Sprintf("Gordon-Ep2-%d", i), nil)
+		_, err = netw.CreateEndpoint(context.Background(), fmt.Sprintf("Gordon-Ep2-%d", i), nil)
 		if err != nil {
 			log.Fatalf("Error creating endpoint 2 %v", err)
 		}
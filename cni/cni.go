@@ -0,0 +1,405 @@
+// Package cni provides a shim which translates the Container Network Interface
+// plugin operations (ADD, DEL, CHECK and VERSION) onto libnetwork controller
+// operations, so that runtimes which only speak CNI can drive the networks
+// and drivers managed by libnetwork.
+//
+// The network named in the CNI configuration must already exist in the
+// controller. On ADD an endpoint is created on that network for the container
+// and joined to the network namespace supplied by the runtime. DEL reverses
+// these steps and CHECK verifies that they are still in place. VERSION
+// reports the CNI versions the shim speaks; the result of ADD is laid out as
+// the version of the configuration asks.
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// CmdAdd is the CNI command to attach a container to a network
+	CmdAdd = "ADD"
+	// CmdDel is the CNI command to detach a container from a network
+	CmdDel = "DEL"
+	// CmdCheck is the CNI command to verify a container's attachment
+	CmdCheck = "CHECK"
+	// CmdVersion is the CNI command to report the supported versions
+	CmdVersion = "VERSION"
+
+	// defaultVersion is the version of the configurations which do not
+	// name one
+	defaultVersion = "0.1.0"
+
+	// codeIncompatibleVersion is the CNI error code of a configuration
+	// version the shim does not speak, codeGeneric the one of the other
+	// failures
+	codeIncompatibleVersion = 1
+	codeGeneric             = 100
+
+	// endpointPrefix is prepended to the container id to name the endpoint
+	// created on behalf of the container.
+	endpointPrefix = "cni-"
+)
+
+// supportedVersions are the CNI versions the shim speaks, oldest first
+var supportedVersions = []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0"}
+
+// NetConf is the subset of the CNI network configuration which is
+// understood by the shim.
+type NetConf struct {
+	CNIVersion string `json:"cniVersion"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+}
+
+// Args carries the runtime supplied parameters of a CNI invocation.
+type Args struct {
+	Command     string
+	ContainerID string
+	Netns       string
+	IfName      string
+	Path        string
+}
+
+// IPConfig describes an address assigned to the container interface, in the
+// results of the versions 0.1.0 and 0.2.0.
+type IPConfig struct {
+	IP      string `json:"ip"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Interface describes the container interface, in the results of the
+// versions 0.3.0 onwards.
+type Interface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// IPAddress describes an address assigned to an interface of the result, by
+// its index, in the results of the versions 0.3.0 onwards.
+type IPAddress struct {
+	Version   string `json:"version"`
+	Interface *int   `json:"interface,omitempty"`
+	Address   string `json:"address"`
+	Gateway   string `json:"gateway,omitempty"`
+}
+
+// Result is the CNI result returned to the runtime on a successful ADD. The
+// versions 0.1.0 and 0.2.0 report the addresses in IP4 and IP6, the later
+// ones in Interfaces and IPs.
+type Result struct {
+	CNIVersion string       `json:"cniVersion,omitempty"`
+	IP4        *IPConfig    `json:"ip4,omitempty"`
+	IP6        *IPConfig    `json:"ip6,omitempty"`
+	Interfaces []*Interface `json:"interfaces,omitempty"`
+	IPs        []*IPAddress `json:"ips,omitempty"`
+}
+
+// VersionResult is the CNI result returned to the runtime on VERSION.
+type VersionResult struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+// Error is the CNI error result returned to the runtime on failure.
+type Error struct {
+	Code uint   `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// ArgsFromEnv builds the invocation arguments from the CNI_* environment
+// variables set by the runtime.
+func ArgsFromEnv() (*Args, error) {
+	args := &Args{
+		Command:     os.Getenv("CNI_COMMAND"),
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+		Path:        os.Getenv("CNI_PATH"),
+	}
+
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+func (a *Args) validate() error {
+	switch a.Command {
+	case CmdAdd, CmdCheck:
+		if a.Netns == "" {
+			return types.BadRequestErrorf("CNI_NETNS is required for %s", a.Command)
+		}
+	case CmdDel:
+	case CmdVersion:
+		return nil
+	case "":
+		return types.BadRequestErrorf("CNI_COMMAND is not set")
+	default:
+		return types.BadRequestErrorf("unknown CNI_COMMAND %q", a.Command)
+	}
+
+	if a.ContainerID == "" {
+		return types.BadRequestErrorf("CNI_CONTAINERID is required")
+	}
+
+	return nil
+}
+
+// ParseNetConf decodes the network configuration passed on the plugin stdin.
+func ParseNetConf(b []byte) (*NetConf, error) {
+	conf := &NetConf{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, types.BadRequestErrorf("failed to decode network configuration: %v", err)
+	}
+
+	if conf.Name == "" {
+		return nil, types.BadRequestErrorf("network name is missing from the configuration")
+	}
+
+	return conf, nil
+}
+
+// Shim executes CNI commands against a libnetwork controller.
+type Shim struct {
+	c libnetwork.NetworkController
+}
+
+// New returns a shim which drives the passed controller.
+func New(c libnetwork.NetworkController) *Shim {
+	return &Shim{c: c}
+}
+
+// Exec decodes the configuration and dispatches the command in args. The
+// returned result is a *Result for ADD, a *VersionResult for VERSION and nil
+// otherwise. A configuration of a version the shim does not speak fails with
+// a CNI error of code 1.
+func (s *Shim) Exec(args *Args, stdin []byte) (interface{}, error) {
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+
+	// The configuration passed with VERSION only holds the version
+	if args.Command == CmdVersion {
+		return Version(stdin)
+	}
+
+	conf, err := ParseNetConf(stdin)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(conf.CNIVersion); err != nil {
+		return nil, err
+	}
+
+	switch args.Command {
+	case CmdAdd:
+		return s.Add(args, conf)
+	case CmdDel:
+		return nil, s.Del(args, conf)
+	default:
+		return nil, s.Check(args, conf)
+	}
+}
+
+// Add creates an endpoint for the container on the configured network and
+// joins it to the network namespace passed by the runtime. The interface
+// inside the namespace is given the name asked by the runtime, or the one
+// chosen by the network driver when there is none.
+func (s *Shim) Add(args *Args, conf *NetConf) (res *Result, err error) {
+	n, err := s.c.NetworkByName(conf.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := n.CreateEndpoint(context.Background(), endpointName(args.ContainerID))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if e := ep.Delete(context.Background()); e != nil {
+				err = fmt.Errorf("%v (cleanup failed: %v)", err, e)
+			}
+		}
+	}()
+
+	joinOptions := []libnetwork.EndpointOption{libnetwork.JoinOptionSandboxKey(args.Netns)}
+	if args.IfName != "" {
+		joinOptions = append(joinOptions, libnetwork.JoinOptionInterfaceName(args.IfName))
+	}
+	if err = ep.Join(context.Background(), args.ContainerID, joinOptions...); err != nil {
+		return nil, err
+	}
+
+	return buildResult(conf, args, ep.Info()), nil
+}
+
+// Del leaves and deletes the endpoint created for the container. Deleting an
+// attachment which does not exist is not an error.
+func (s *Shim) Del(args *Args, conf *NetConf) error {
+	n, err := s.c.NetworkByName(conf.Name)
+	if err != nil {
+		if _, ok := err.(libnetwork.ErrNoSuchNetwork); ok {
+			return nil
+		}
+		return err
+	}
+
+	ep, err := n.EndpointByName(endpointName(args.ContainerID))
+	if err != nil {
+		if _, ok := err.(libnetwork.ErrNoSuchEndpoint); ok {
+			return nil
+		}
+		return err
+	}
+
+	if ci := ep.ContainerInfo(); ci != nil && ci.ID() == args.ContainerID {
+		if err := ep.Leave(context.Background(), args.ContainerID); err != nil {
+			return err
+		}
+	}
+
+	return ep.Delete(context.Background())
+}
+
+// Check verifies the container is still attached to the configured network
+// through the namespace passed by the runtime.
+func (s *Shim) Check(args *Args, conf *NetConf) error {
+	n, err := s.c.NetworkByName(conf.Name)
+	if err != nil {
+		return err
+	}
+
+	ep, err := n.EndpointByName(endpointName(args.ContainerID))
+	if err != nil {
+		return err
+	}
+
+	ci := ep.ContainerInfo()
+	if ci == nil || ci.ID() != args.ContainerID {
+		return types.NotFoundErrorf("container %s is not attached to network %s", args.ContainerID, conf.Name)
+	}
+
+	if key := ep.Info().SandboxKey(); key != args.Netns {
+		return types.BadRequestErrorf("container %s is attached through namespace %s, not %s", args.ContainerID, key, args.Netns)
+	}
+
+	return nil
+}
+
+func endpointName(containerID string) string {
+	return endpointPrefix + containerID
+}
+
+// Version returns the versions the shim speaks, as the result of VERSION. The
+// result is of the version of the configuration, the latest one if it does
+// not name one the shim speaks.
+func Version(stdin []byte) (*VersionResult, error) {
+	var conf struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if len(stdin) != 0 {
+		if err := json.Unmarshal(stdin, &conf); err != nil {
+			return nil, types.BadRequestErrorf("failed to decode network configuration: %v", err)
+		}
+	}
+
+	res := &VersionResult{CNIVersion: conf.CNIVersion, SupportedVersions: supportedVersions}
+	if checkVersion(res.CNIVersion) != nil || res.CNIVersion == "" {
+		res.CNIVersion = supportedVersions[len(supportedVersions)-1]
+	}
+	return res, nil
+}
+
+// checkVersion fails with a CNI error of code 1 if the shim does not speak
+// the version of a configuration
+func checkVersion(version string) error {
+	if version == "" {
+		version = defaultVersion
+	}
+	for _, v := range supportedVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return &Error{Code: codeIncompatibleVersion, Msg: fmt.Sprintf("incompatible CNI version %s, the supported versions are %s", version, strings.Join(supportedVersions, ", "))}
+}
+
+// legacyLayout tells whether the result of the version reports the addresses
+// in ip4 and ip6 rather than in interfaces and ips
+func legacyLayout(version string) bool {
+	return version == "" || version == "0.1.0" || version == "0.2.0"
+}
+
+func buildResult(conf *NetConf, args *Args, info libnetwork.EndpointInfo) *Result {
+	res := &Result{CNIVersion: conf.CNIVersion}
+
+	ifaces := info.InterfaceList()
+	if len(ifaces) == 0 {
+		return res
+	}
+
+	if !legacyLayout(conf.CNIVersion) {
+		iface := &Interface{Name: args.IfName, Sandbox: args.Netns}
+		if mac := ifaces[0].MacAddress(); mac != nil {
+			iface.Mac = mac.String()
+		}
+		res.Interfaces = []*Interface{iface}
+
+		index := 0
+		if addr := ifaces[0].Address(); addr.IP != nil {
+			ip := &IPAddress{Version: "4", Interface: &index, Address: addr.String()}
+			if gw := info.Gateway(); gw != nil {
+				ip.Gateway = gw.String()
+			}
+			res.IPs = append(res.IPs, ip)
+		}
+		if addr := ifaces[0].AddressIPv6(); addr.IP != nil {
+			ip := &IPAddress{Version: "6", Interface: &index, Address: addr.String()}
+			if gw := info.GatewayIPv6(); gw != nil {
+				ip.Gateway = gw.String()
+			}
+			res.IPs = append(res.IPs, ip)
+		}
+		return res
+	}
+
+	if addr := ifaces[0].Address(); addr.IP != nil {
+		res.IP4 = &IPConfig{IP: addr.String()}
+		if gw := info.Gateway(); gw != nil {
+			res.IP4.Gateway = gw.String()
+		}
+	}
+
+	if addr := ifaces[0].AddressIPv6(); addr.IP != nil {
+		res.IP6 = &IPConfig{IP: addr.String()}
+		if gw := info.GatewayIPv6(); gw != nil {
+			res.IP6.Gateway = gw.String()
+		}
+	}
+
+	return res
+}
+
+// NewError converts err into a CNI error result. Errors are mapped onto the
+// generic CNI error code as libnetwork errors carry no CNI specific meaning,
+// but for the CNI errors of the shim itself.
+func NewError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Code: codeGeneric, Msg: err.Error()}
+}
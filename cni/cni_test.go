@@ -0,0 +1,273 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/types"
+)
+
+const testConf = `{"cniVersion": "0.1.0", "name": "net1", "type": "libnetwork"}`
+
+// newShim returns a shim driving a controller with the null network net1,
+// and a file standing for the network namespace of the container
+func newShim(t *testing.T) (*Shim, libnetwork.NetworkController, string) {
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), "null", "net1"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "cni-netns-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	return New(c), c, f.Name()
+}
+
+func setEnv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestArgsFromEnv(t *testing.T) {
+	setEnv(t, map[string]string{
+		"CNI_COMMAND":     CmdAdd,
+		"CNI_CONTAINERID": "c1",
+		"CNI_NETNS":       "/var/run/netns/c1",
+		"CNI_IFNAME":      "eth0",
+		"CNI_PATH":        "/opt/cni/bin",
+	})
+	defer setEnv(t, map[string]string{"CNI_COMMAND": "", "CNI_CONTAINERID": "", "CNI_NETNS": ""})
+
+	args, err := ArgsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args.ContainerID != "c1" || args.Netns != "/var/run/netns/c1" || args.IfName != "eth0" {
+		t.Fatalf("Unexpected args: %+v", args)
+	}
+}
+
+func TestArgsValidate(t *testing.T) {
+	invalid := []*Args{
+		{},
+		{Command: "GET", ContainerID: "c1"},
+		{Command: CmdAdd, ContainerID: "c1"},
+		{Command: CmdCheck, ContainerID: "c1"},
+		{Command: CmdDel},
+	}
+
+	for _, a := range invalid {
+		err := a.validate()
+		if err == nil {
+			t.Fatalf("Expected failure for %+v", a)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for %+v: %v", a, err)
+		}
+	}
+
+	// DEL does not need a namespace, VERSION nothing
+	if err := (&Args{Command: CmdDel, ContainerID: "c1"}).validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Args{Command: CmdVersion}).validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseNetConf(t *testing.T) {
+	conf, err := ParseNetConf([]byte(`{"cniVersion": "0.1.0", "name": "net1", "type": "libnetwork"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "net1" || conf.CNIVersion != "0.1.0" {
+		t.Fatalf("Unexpected configuration: %+v", conf)
+	}
+
+	if _, err := ParseNetConf([]byte(`{"type": "libnetwork"}`)); err == nil {
+		t.Fatal("Expected failure for configuration without name")
+	}
+
+	if _, err := ParseNetConf([]byte(`{`)); err == nil {
+		t.Fatal("Expected failure for malformed configuration")
+	}
+}
+
+func TestAddCheckDel(t *testing.T) {
+	s, _, netns := newShim(t)
+	defer os.Remove(netns)
+
+	add := &Args{Command: CmdAdd, ContainerID: "c1", Netns: netns, IfName: "eth0"}
+	r, err := s.Exec(add, []byte(testConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := r.(*Result); !ok || res.CNIVersion != "0.1.0" || res.Interfaces != nil {
+		t.Fatalf("Unexpected result: %+v", r)
+	}
+
+	if _, err := s.Exec(&Args{Command: CmdCheck, ContainerID: "c1", Netns: netns}, []byte(testConf)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Exec(&Args{Command: CmdCheck, ContainerID: "c1", Netns: netns + "-other"}, []byte(testConf))
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error checking another namespace, got %v", err)
+	}
+
+	del := &Args{Command: CmdDel, ContainerID: "c1", Netns: netns}
+	if _, err := s.Exec(del, []byte(testConf)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Exec(&Args{Command: CmdCheck, ContainerID: "c1", Netns: netns}, []byte(testConf))
+	if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Expected a not found error checking a deleted attachment, got %v", err)
+	}
+
+	// Deleting again is not an error
+	if _, err := s.Exec(del, []byte(testConf)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The namespace is owned by the runtime
+	if _, err := os.Stat(netns); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddLeaveAll(t *testing.T) {
+	s, c, netns := newShim(t)
+	defer os.Remove(netns)
+
+	if _, err := s.Exec(&Args{Command: CmdAdd, ContainerID: "c1", Netns: netns}, []byte(testConf)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sandbox is found from the container id, although it is keyed by
+	// the namespace path
+	if err := c.LeaveAll(context.Background(), "c1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Exec(&Args{Command: CmdCheck, ContainerID: "c1", Netns: netns}, []byte(testConf))
+	if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Expected a not found error checking a left attachment, got %v", err)
+	}
+
+	if _, err := s.Exec(&Args{Command: CmdDel, ContainerID: "c1", Netns: netns}, []byte(testConf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(netns); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s, _, netns := newShim(t)
+	defer os.Remove(netns)
+
+	r, err := s.Exec(&Args{Command: CmdVersion}, []byte(`{"cniVersion": "0.3.1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ok := r.(*VersionResult)
+	if !ok || res.CNIVersion != "0.3.1" || len(res.SupportedVersions) != len(supportedVersions) {
+		t.Fatalf("Unexpected version result: %+v", r)
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"supportedVersions":["0.1.0","0.2.0","0.3.0","0.3.1","0.4.0"]`) {
+		t.Fatalf("Unexpected version result: %s", b)
+	}
+
+	// The configurations of the versions not spoken fail with code 1
+	_, err = s.Exec(&Args{Command: CmdAdd, ContainerID: "c1", Netns: netns}, []byte(`{"cniVersion": "9.9.9", "name": "net1"}`))
+	if e := NewError(err); e.Code != 1 {
+		t.Fatalf("Unexpected error for an unsupported version: %+v", e)
+	}
+	n, err := s.c.NetworkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eps := n.Endpoints(); len(eps) != 0 {
+		t.Fatalf("Endpoint created for an unsupported version: %v", eps)
+	}
+}
+
+// testInterface is an interface assigned an IPv4 and an IPv6 address
+type testInterface struct{}
+
+func (i *testInterface) MacAddress() net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}
+}
+
+func (i *testInterface) Address() net.IPNet {
+	return net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)}
+}
+
+func (i *testInterface) AddressIPv6() net.IPNet {
+	return net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}
+}
+
+func (i *testInterface) SecondaryAddresses() []net.IPNet {
+	return nil
+}
+
+type testEndpointInfo struct{}
+
+func (e *testEndpointInfo) InterfaceList() []libnetwork.InterfaceInfo {
+	return []libnetwork.InterfaceInfo{&testInterface{}}
+}
+
+func (e *testEndpointInfo) Gateway() net.IP {
+	return net.ParseIP("172.17.0.1")
+}
+
+func (e *testEndpointInfo) GatewayIPv6() net.IP {
+	return nil
+}
+
+func (e *testEndpointInfo) SandboxKey() string {
+	return ""
+}
+
+func (e *testEndpointInfo) FwMark() uint32 {
+	return 0
+}
+
+func TestResultLayout(t *testing.T) {
+	args := &Args{Command: CmdAdd, ContainerID: "c1", Netns: "/var/run/netns/c1", IfName: "eth0"}
+
+	res := buildResult(&NetConf{CNIVersion: "0.2.0"}, args, &testEndpointInfo{})
+	if res.IP4 == nil || res.IP4.IP != "172.17.0.2/16" || res.IP4.Gateway != "172.17.0.1" || res.IP6 == nil || res.Interfaces != nil || res.IPs != nil {
+		t.Fatalf("Unexpected 0.2.0 result: %+v", res)
+	}
+
+	for _, v := range []string{"0.3.0", "0.3.1", "0.4.0"} {
+		b, err := json.Marshal(buildResult(&NetConf{CNIVersion: v}, args, &testEndpointInfo{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"cniVersion":"` + v + `","interfaces":[{"name":"eth0","mac":"02:42:ac:11:00:02","sandbox":"/var/run/netns/c1"}],` +
+			`"ips":[{"version":"4","interface":0,"address":"172.17.0.2/16","gateway":"172.17.0.1"},{"version":"6","interface":0,"address":"fd00::2/64"}]}`
+		if string(b) != expected {
+			t.Fatalf("Unexpected %s result:\n%s\nexpected:\n%s", v, b, expected)
+		}
+	}
+}
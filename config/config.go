@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	log "github.com/Sirupsen/logrus"
@@ -13,6 +14,8 @@ type Config struct {
 	Daemon    DaemonCfg
 	Cluster   ClusterCfg
 	Datastore DatastoreCfg
+	Plugins   PluginCfg
+	Quotas    QuotaCfg
 }
 
 // DaemonCfg represents libnetwork core configuration
@@ -21,6 +24,27 @@ type DaemonCfg struct {
 	DefaultNetwork string
 	DefaultDriver  string
 	Labels         []string
+	// NetworkSpec is the path of the declarative network definitions
+	NetworkSpec string
+	// ReadOnly opens the datastore state for inspection only, nothing is
+	// written to the store nor created in the drivers
+	ReadOnly bool
+	// AuditRetention bounds the number of records kept in the audit log
+	// of each network, DefaultAuditRetention if zero
+	AuditRetention int
+	// ResolvConfPollInterval is the period the host resolv.conf is checked
+	// for changes to push to the containers, never if zero
+	ResolvConfPollInterval time.Duration
+	// SandboxStateDir is the directory the composition of the sandboxes
+	// is kept in, for them to be restored when the daemon restarts
+	// without having left them. It is not kept if empty.
+	SandboxStateDir string
+	// SysctlPollInterval is the period the kernel parameters set for the
+	// drivers are checked, to set them again when reverted, never if zero
+	SysctlPollInterval time.Duration
+	// SysctlRestore sets the kernel parameters set for the drivers back to
+	// their original values when the controller is stopped
+	SysctlRestore bool
 }
 
 // ClusterCfg represents cluster configuration
@@ -34,6 +58,15 @@ type ClusterCfg struct {
 type DatastoreCfg struct {
 	Embedded bool
 	Client   DatastoreClientCfg
+	// CompressionThreshold is the size from which the values are written
+	// compressed. The compression is disabled when it is 0, the compressed
+	// values can only be read by the daemons which support it.
+	CompressionThreshold int
+	// Exclusive makes the controller lock the store for itself on start,
+	// failing if another daemon holds it. Takeover removes the lock of
+	// the other daemon, for one which crashed.
+	Exclusive bool
+	Takeover  bool
 }
 
 // DatastoreClientCfg represents Datastore Client-only mode configuration
@@ -42,6 +75,68 @@ type DatastoreClientCfg struct {
 	Address  string
 }
 
+// DefaultAuditRetention is the number of records kept in the audit log of
+// each network if none is configured
+const DefaultAuditRetention = 1000
+
+// DefaultPluginTimeout bounds the activation of the plugins without a
+// timeout of their own
+const DefaultPluginTimeout = 30 * time.Second
+
+// PluginCfg represents the discovery of the remote driver plugins which are
+// not found in the docker plugin directory
+type PluginCfg struct {
+	// SocketDirs are searched for the plugin sockets, including the ones
+	// published by managed plugin containers in a subdirectory of their own
+	SocketDirs []string
+	// UnitDirs are searched for the systemd socket units named after the
+	// plugins, which start the plugin on first connection
+	UnitDirs []string
+	// Timeout bounds the activation of the plugins
+	Timeout time.Duration
+	// Timeouts overrides Timeout for the named plugins
+	Timeouts map[string]time.Duration
+}
+
+// Quota bounds the resources of a tenant, a zero limit meaning no limit
+type Quota struct {
+	// Networks is the number of networks of the tenant
+	Networks int
+	// EndpointsPerNetwork is the number of endpoints of each network of the
+	// tenant
+	EndpointsPerNetwork int
+	// Addresses is the number of addresses held by the endpoints of the
+	// networks of the tenant
+	Addresses int
+}
+
+// QuotaCfg represents the quotas of the tenants the networks are created for
+type QuotaCfg struct {
+	// Default applies to the tenants without a quota of their own
+	Default Quota
+	// Tenants holds the quotas of the named tenants
+	Tenants map[string]Quota
+}
+
+// TenantQuota returns the quota of the named tenant
+func (q *QuotaCfg) TenantQuota(tenant string) Quota {
+	if quota, ok := q.Tenants[tenant]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// ActivationTimeout returns the activation timeout of the named plugin
+func (p *PluginCfg) ActivationTimeout(name string) time.Duration {
+	if t, ok := p.Timeouts[name]; ok && t > 0 {
+		return t
+	}
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultPluginTimeout
+}
+
 // ParseConfig parses the libnetwork configuration file
 func ParseConfig(tomlCfgFile string) (*Config, error) {
 	var cfg Config
@@ -98,6 +193,126 @@ func OptionKVProviderURL(url string) Option {
 	}
 }
 
+// OptionKVCompression function returns an option setter for the size from
+// which the values written to the kvstore are compressed
+func OptionKVCompression(threshold int) Option {
+	return func(c *Config) {
+		log.Infof("Option OptionKVCompression: %d", threshold)
+		c.Datastore.CompressionThreshold = threshold
+	}
+}
+
+// OptionKVExclusive function returns an option setter for the exclusive
+// ownership of the kvstore, taken over from its current owner if takeover
+// is set
+func OptionKVExclusive(takeover bool) Option {
+	return func(c *Config) {
+		log.Infof("Option OptionKVExclusive: takeover %t", takeover)
+		c.Datastore.Exclusive = true
+		c.Datastore.Takeover = takeover
+	}
+}
+
+// OptionReadOnly function returns an option setter for the read-only mode
+func OptionReadOnly() Option {
+	return func(c *Config) {
+		log.Infof("Option ReadOnly")
+		c.Daemon.ReadOnly = true
+	}
+}
+
+// OptionAuditRetention function returns an option setter for the number of
+// records kept in the audit log of each network
+func OptionAuditRetention(n int) Option {
+	return func(c *Config) {
+		log.Infof("Option AuditRetention: %d", n)
+		if n > 0 {
+			c.Daemon.AuditRetention = n
+		}
+	}
+}
+
+// OptionResolvConfReload function returns an option setter for pushing the
+// changes of the host resolv.conf, checked every interval, to the containers
+func OptionResolvConfReload(interval time.Duration) Option {
+	return func(c *Config) {
+		log.Infof("Option ResolvConfReload: %s", interval)
+		if interval > 0 {
+			c.Daemon.ResolvConfPollInterval = interval
+		}
+	}
+}
+
+// OptionSandboxStateDir function returns an option setter for the
+// directory the sandboxes are kept in, to restore them on restart
+func OptionSandboxStateDir(dir string) Option {
+	return func(c *Config) {
+		log.Infof("Option SandboxStateDir: %s", dir)
+		c.Daemon.SandboxStateDir = dir
+	}
+}
+
+// OptionSysctlWatch function returns an option setter for setting the
+// kernel parameters the drivers need again when they are reverted, checked
+// every interval
+func OptionSysctlWatch(interval time.Duration) Option {
+	return func(c *Config) {
+		log.Infof("Option SysctlWatch: %s", interval)
+		if interval > 0 {
+			c.Daemon.SysctlPollInterval = interval
+		}
+	}
+}
+
+// OptionSysctlRestore function returns an option setter for restoring the
+// original values of the kernel parameters the drivers set when the
+// controller is stopped
+func OptionSysctlRestore() Option {
+	return func(c *Config) {
+		log.Infof("Option SysctlRestore")
+		c.Daemon.SysctlRestore = true
+	}
+}
+
+// OptionPluginSocketDirs function returns an option setter for the
+// directories searched for plugin sockets
+func OptionPluginSocketDirs(dirs ...string) Option {
+	return func(c *Config) {
+		log.Infof("Option PluginSocketDirs: %v", dirs)
+		c.Plugins.SocketDirs = append(c.Plugins.SocketDirs, dirs...)
+	}
+}
+
+// OptionPluginUnitDirs function returns an option setter for the directories
+// searched for systemd plugin socket units
+func OptionPluginUnitDirs(dirs ...string) Option {
+	return func(c *Config) {
+		log.Infof("Option PluginUnitDirs: %v", dirs)
+		c.Plugins.UnitDirs = append(c.Plugins.UnitDirs, dirs...)
+	}
+}
+
+// OptionPluginTimeout function returns an option setter for the plugin
+// activation timeout
+func OptionPluginTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		log.Infof("Option PluginTimeout: %s", timeout)
+		c.Plugins.Timeout = timeout
+	}
+}
+
+// OptionPluginTimeoutFor function returns an option setter for the
+// activation timeout of the named plugin
+func OptionPluginTimeoutFor(name string, timeout time.Duration) Option {
+	return func(c *Config) {
+		log.Infof("Option PluginTimeoutFor %s: %s", name, timeout)
+		if c.Plugins.Timeouts == nil {
+			c.Plugins.Timeouts = make(map[string]time.Duration)
+		}
+		c.Plugins.Timeouts[name] = timeout
+	}
+}
+
 // ProcessOptions processes options and stores it in config
 func (c *Config) ProcessOptions(options ...Option) {
 	for _, opt := range options {
@@ -107,6 +322,27 @@ func (c *Config) ProcessOptions(options ...Option) {
 	}
 }
 
+// OptionDefaultQuota function returns an option setter for the quota of the
+// tenants without a quota of their own
+func OptionDefaultQuota(quota Quota) Option {
+	return func(c *Config) {
+		log.Infof("Option DefaultQuota: %+v", quota)
+		c.Quotas.Default = quota
+	}
+}
+
+// OptionTenantQuota function returns an option setter for the quota of the
+// named tenant
+func OptionTenantQuota(tenant string, quota Quota) Option {
+	return func(c *Config) {
+		log.Infof("Option TenantQuota: %s %+v", tenant, quota)
+		if c.Quotas.Tenants == nil {
+			c.Quotas.Tenants = make(map[string]Quota)
+		}
+		c.Quotas.Tenants[tenant] = quota
+	}
+}
+
 // IsValidName validates configuration objects supported by libnetwork
 func IsValidName(name string) bool {
 	if name == "" || strings.Contains(name, ".") {
@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/netlabel"
 	_ "github.com/docker/libnetwork/netutils"
@@ -53,3 +54,33 @@ func TestValidName(t *testing.T) {
 		t.Fatal("Name validation succeeds for a case when it is expected to fail")
 	}
 }
+
+func TestPluginActivationTimeout(t *testing.T) {
+	c := &Config{}
+	if to := c.Plugins.ActivationTimeout("p1"); to != DefaultPluginTimeout {
+		t.Fatalf("Expected the default timeout, got %s", to)
+	}
+
+	c.ProcessOptions(OptionPluginTimeout(5*time.Second), OptionPluginTimeoutFor("p2", time.Minute))
+	if to := c.Plugins.ActivationTimeout("p1"); to != 5*time.Second {
+		t.Fatalf("Expected the configured timeout, got %s", to)
+	}
+	if to := c.Plugins.ActivationTimeout("p2"); to != time.Minute {
+		t.Fatalf("Expected the plugin timeout, got %s", to)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	c := &Config{}
+	if q := c.Quotas.TenantQuota("t1"); q != (Quota{}) {
+		t.Fatalf("Expected no quota, got %+v", q)
+	}
+
+	c.ProcessOptions(OptionDefaultQuota(Quota{Networks: 2}), OptionTenantQuota("t2", Quota{Networks: 5, Addresses: 10}))
+	if q := c.Quotas.TenantQuota("t1"); q.Networks != 2 {
+		t.Fatalf("Expected the default quota, got %+v", q)
+	}
+	if q := c.Quotas.TenantQuota("t2"); q.Networks != 5 || q.Addresses != 10 {
+		t.Fatalf("Expected the tenant quota, got %+v", q)
+	}
+}
@@ -5,20 +5,23 @@ create network namespaces and allocate interfaces for containers to use.
 	// Create a new controller instance
 	controller, _err := libnetwork.New(nil)
 
+	// The operations reaching the drivers and the datastore give up once their context is done.
+	ctx := context.Background()
+
 	// Select and configure the network driver
 	networkType := "bridge"
 
 	driverOptions := options.Generic{}
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = driverOptions
-	err := controller.ConfigureNetworkDriver(networkType, genericOption)
+	err := controller.ConfigureNetworkDriver(ctx, networkType, genericOption)
 	if err != nil {
 		return
 	}
 
 	// Create a network for containers to join.
 	// NewNetwork accepts Variadic optional arguments that libnetwork and Drivers can make of
-	network, err := controller.NewNetwork(networkType, "network1")
+	network, err := controller.NewNetwork(ctx, networkType, "network1")
 	if err != nil {
 		return
 	}
@@ -27,7 +30,7 @@ create network namespaces and allocate interfaces for containers to use.
 	// settings will be used for container infos (inspect and such), as well as
 	// iptables rules for port publishing. This info is contained or accessible
 	// from the returned endpoint.
-	ep, err := network.CreateEndpoint("Endpoint1")
+	ep, err := network.CreateEndpoint(ctx, "Endpoint1")
 	if err != nil {
 		return
 	}
@@ -35,7 +38,7 @@ create network namespaces and allocate interfaces for containers to use.
 	// A container can join the endpoint by providing the container ID to the join
 	// api.
 	// Join acceps Variadic arguments which will be made use of by libnetwork and Drivers
-	err = ep.Join("container1",
+	err = ep.Join(ctx, "container1",
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"))
 	if err != nil {
@@ -45,6 +48,7 @@ create network namespaces and allocate interfaces for containers to use.
 package libnetwork
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -56,9 +60,12 @@ import (
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote"
 	"github.com/docker/libnetwork/hostdiscovery"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/plugindiscovery"
 	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/sysctl"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -66,14 +73,15 @@ import (
 // networks.
 type NetworkController interface {
 	// ConfigureNetworkDriver applies the passed options to the driver instance for the specified network type
-	ConfigureNetworkDriver(networkType string, options map[string]interface{}) error
+	ConfigureNetworkDriver(ctx context.Context, networkType string, options map[string]interface{}) error
 
 	// Config method returns the bootup configuration for the controller
 	Config() config.Config
 
-	// Create a new network. The options parameter carries network specific options.
-	// Labels support will be added in the near future.
-	NewNetwork(networkType, name string, options ...NetworkOption) (Network, error)
+	// Create a new network. The options parameter carries network specific options,
+	// the labels of the network among them. The creation is abandoned once ctx
+	// is done.
+	NewNetwork(ctx context.Context, networkType, name string, options ...NetworkOption) (Network, error)
 
 	// Networks returns the list of Network(s) managed by this controller.
 	Networks() []Network
@@ -84,14 +92,93 @@ type NetworkController interface {
 	// NetworkByName returns the Network which has the passed name. If not found, the error ErrNoSuchNetwork is returned.
 	NetworkByName(name string) (Network, error)
 
+	// NetworksByLabel returns the networks labeled with the key and the
+	// value, looked up in an index of the labels rather than by walking the
+	// networks.
+	NetworksByLabel(key, value string) []Network
+
 	// NetworkByID returns the Network which has the passed id. If not found, the error ErrNoSuchNetwork is returned.
 	NetworkByID(id string) (Network, error)
 
 	// LeaveAll accepts a container id and attempts to leave all endpoints that the container has joined
-	LeaveAll(id string) error
+	LeaveAll(ctx context.Context, id string) error
+
+	// SetExternalConnectivity accepts a container id and removes, or restores, the default
+	// routes and the external access, like port mappings, of all the endpoints it has joined.
+	// New endpoints cannot be joined while the external connectivity is disabled.
+	SetExternalConnectivity(ctx context.Context, id string, enable bool) error
 
 	// GC triggers immediate garbage collection of resources which are garbage collected.
 	GC()
+
+	// VerifyStore cross-checks the datastore content and the sandbox references, reporting
+	// the inconsistencies found and repairing them where possible if repair is set.
+	VerifyStore(ctx context.Context, repair bool) ([]StoreInconsistency, error)
+
+	// AddressUsage reports the usage and fragmentation of the address pools of the networks,
+	// with the endpoints holding the most addresses, together with the totals across networks.
+	AddressUsage() (*AddressUsage, error)
+
+	// RegisterHook registers a function to be run at the hook point of the network and endpoint
+	// operations. The hooks of a point run in their registration order.
+	RegisterHook(point HookPoint, hook Hook)
+
+	// SetPortPublisher makes the publisher notified of the host ports the endpoints created on
+	// this host publish and unpublish. If external is set, the ports are only published by the
+	// publisher: the drivers are not passed the port bindings and do not map the ports locally.
+	SetPortPublisher(p PortPublisher, external bool)
+
+	// AuditLogs returns the audit logs of the networks by network id, oldest record first. The
+	// logs of the deleted networks and of the networks which failed to be created are kept.
+	AuditLogs() (map[string][]AuditRecord, error)
+
+	// QuotaUsage returns the number of networks and of endpoint addresses accounted to the tenant.
+	QuotaUsage(tenant string) (networks, addresses int, err error)
+
+	// Snapshot returns a JSON document of the networks, endpoints, sandboxes, address
+	// pools and service records of the controller, for backups and support bundles.
+	Snapshot() ([]byte, error)
+
+	// ServeZoneTransfer serves the zones of the networks, named <network>.<domain>, to the
+	// secondary DNS servers transferring them with AXFR over the listener, until it fails.
+	ServeZoneTransfer(l net.Listener, domain string) error
+
+	// SetAuthorizer makes the authorizer invoked before every operation changing the state of
+	// the controller, with the actor of the operation, the operation and the network or the
+	// endpoint it changes. A nil authorizer allows all the operations.
+	SetAuthorizer(a Authorizer)
+
+	// UnregisterDriver unregisters the driver of the network type. Its
+	// networks are quiesced: the operations on them fail with
+	// ErrDriverUnloaded until a driver of the type is registered again,
+	// which is handed them back.
+	UnregisterDriver(ctx context.Context, networkType string) error
+
+	// ReloadDriver unregisters the driver of the network type and registers
+	// it again, a new instance of a builtin driver or a new connection to a
+	// plugin, handing it the networks of the type back. If the new driver
+	// fails to initialize, the type stays unregistered and the reload can
+	// be tried again.
+	ReloadDriver(ctx context.Context, networkType string) error
+
+	// ClusterMembers returns the members of the cluster the driver of the
+	// network type gossips with, with their health and availability.
+	ClusterMembers(networkType string) ([]driverapi.ClusterMember, error)
+
+	// RemoveClusterMember removes a failed member from the cluster of the
+	// driver of the network type, rather than waiting for the other members
+	// to give up on it.
+	RemoveClusterMember(ctx context.Context, networkType, name string) error
+
+	// SetNodeAvailability sets the availability of a member of the cluster
+	// of the driver of the network type, driverapi.NodeActive or
+	// driverapi.NodeDrain. A drained member takes no new endpoints.
+	SetNodeAvailability(ctx context.Context, networkType, name, availability string) error
+
+	// Stop releases the ownership of the datastore, stops watching the kernel parameters the
+	// drivers set and, if configured, sets them back to their original values. It is called
+	// when the daemon shuts down.
+	Stop() error
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
@@ -109,11 +196,36 @@ type endpointTable map[types.UUID]*endpoint
 type sandboxTable map[string]*sandboxData
 
 type controller struct {
-	networks  networkTable
-	drivers   driverTable
+	networks networkTable
+	// networkLabels indexes the networks by their labels
+	networkLabels labelIndex
+	drivers       driverTable
+	// unloaded are the drivers unregistered with UnregisterDriver, whose
+	// capability the quiesced networks keep
+	unloaded  driverTable
 	sandboxes sandboxTable
 	cfg       *config.Config
 	store     datastore.DataStore
+	hooks     hookTable
+	ports     portRegistry
+	pub       portPublication
+	// quotaUsage is the usage of the tenants when there is no datastore
+	quotaUsage map[string]*tenantUsage
+	// auditLogs are the audit logs of the networks by network id when
+	// there is no datastore, auditMu serializes the appends to the logs
+	// wherever they are kept
+	auditLogs map[types.UUID][]AuditRecord
+	auditMu   sync.Mutex
+	// pluginMu serializes the activation of the discovered plugins
+	pluginMu sync.Mutex
+	// ownership is the exclusive ownership of the store, if configured
+	ownership *datastore.Ownership
+	// authz is the authorizer of the operations, if any
+	authz Authorizer
+	// stopSysctlWatch stops watching the kernel parameters, if watched
+	stopSysctlWatch func()
+	// stopResolvConfWatch stops watching the host resolv.conf, if watched
+	stopResolvConfWatch func()
 	sync.Mutex
 }
 
@@ -128,13 +240,27 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		cfg:       cfg,
 		networks:  networkTable{},
 		sandboxes: sandboxTable{},
-		drivers:   driverTable{}}
+		drivers:   driverTable{},
+		unloaded:  driverTable{}}
 	if err := initDrivers(c); err != nil {
 		return nil, err
 	}
 
+	if c.isReadOnly() {
+		// The state to inspect is in the store, and nothing about this
+		// process must be advertised to the cluster
+		if err := c.initDataStore(); err != nil {
+			return nil, fmt.Errorf("failed to open the datastore read-only: %v", err)
+		}
+		return c, nil
+	}
+
 	if cfg != nil {
 		if err := c.initDataStore(); err != nil {
+			// A store this controller must own is not shared
+			if cfg.Datastore.Exclusive {
+				return nil, fmt.Errorf("failed to own the datastore: %v", err)
+			}
 			// Failing to initalize datastore is a bad situation to be in.
 			// But it cannot fail creating the Controller
 			log.Debugf("Failed to Initialize Datastore due to %v. Operating in non-clustered mode", err)
@@ -144,6 +270,17 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 			// But it cannot fail creating the Controller
 			log.Debugf("Failed to Initialize Discovery : %v", err)
 		}
+
+		if cfg.Daemon.ResolvConfPollInterval > 0 {
+			c.stopResolvConfWatch = c.watchResolvConf(cfg.Daemon.ResolvConfPollInterval)
+		}
+
+		if cfg.Daemon.SysctlPollInterval > 0 {
+			c.stopSysctlWatch = sysctl.Default().Watch(cfg.Daemon.SysctlPollInterval)
+		}
+
+		// The sandboxes left by a daemon which did not shut down
+		c.restoreSandboxes()
 	}
 
 	return c, nil
@@ -165,6 +302,16 @@ func (c *controller) initDiscovery() error {
 	return hostDiscovery.StartDiscovery(&c.cfg.Cluster, c.hostJoinCallback, c.hostLeaveCallback)
 }
 
+// isReadOnly tells whether the controller only inspects the state in the store
+func (c *controller) isReadOnly() bool {
+	return c != nil && c.cfg != nil && c.cfg.Daemon.ReadOnly
+}
+
+// IsReadOnly is part of the driverapi.ReadOnlyChecker interface
+func (c *controller) IsReadOnly() bool {
+	return c.isReadOnly()
+}
+
 func (c *controller) hostJoinCallback(hosts []net.IP) {
 }
 
@@ -180,13 +327,20 @@ func (c *controller) Config() config.Config {
 	return *c.cfg
 }
 
-func (c *controller) ConfigureNetworkDriver(networkType string, options map[string]interface{}) error {
+func (c *controller) ConfigureNetworkDriver(ctx context.Context, networkType string, options map[string]interface{}) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
 	c.Lock()
 	dd, ok := c.drivers[networkType]
 	c.Unlock()
 	if !ok {
 		return NetworkTypeError(networkType)
 	}
+	if err := c.authorize(ctx, AuthzRequest{Operation: AuthzDriverConfigure, NetworkType: networkType}); err != nil {
+		return err
+	}
 	return dd.driver.Config(options)
 }
 
@@ -201,22 +355,28 @@ func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver,
 		return driverapi.ErrActiveRegistration(networkType)
 	}
 	c.drivers[networkType] = &driverData{driver, capability}
+	delete(c.unloaded, networkType)
 
-	if c.cfg == nil {
+	// The drivers of a read-only controller are neither configured nor
+	// restored, as they would set up the host or the store on behalf of
+	// the daemon
+	if c.cfg != nil && c.cfg.Daemon.ReadOnly {
 		c.Unlock()
 		return nil
 	}
 
 	opt := make(map[string]interface{})
-	for _, label := range c.cfg.Daemon.Labels {
-		if strings.HasPrefix(label, netlabel.DriverPrefix+"."+networkType) {
-			opt[netlabel.Key(label)] = netlabel.Value(label)
+	if c.cfg != nil {
+		for _, label := range c.cfg.Daemon.Labels {
+			if strings.HasPrefix(label, netlabel.DriverPrefix+"."+networkType) {
+				opt[netlabel.Key(label)] = netlabel.Value(label)
+			}
 		}
-	}
 
-	if capability.Scope == driverapi.GlobalScope && c.validateDatastoreConfig() {
-		opt[netlabel.KVProvider] = c.cfg.Datastore.Client.Provider
-		opt[netlabel.KVProviderURL] = c.cfg.Datastore.Client.Address
+		if capability.Scope == driverapi.GlobalScope && c.validateDatastoreConfig() {
+			opt[netlabel.KVProvider] = c.cfg.Datastore.Client.Provider
+			opt[netlabel.KVProviderURL] = c.cfg.Datastore.Client.Address
+		}
 	}
 
 	c.Unlock()
@@ -227,24 +387,47 @@ func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver,
 		}
 	}
 
+	c.resumeNetworks(networkType, driver)
+	c.restoreEndpoints(networkType, driver, capability.Scope)
+
 	return nil
 }
 
 // NewNetwork creates a new network of the specified network type. The options
 // are network specific and modeled in a generic way.
-func (c *controller) NewNetwork(networkType, name string, options ...NetworkOption) (Network, error) {
-	if !config.IsValidName(name) {
-		return nil, ErrInvalidName(name)
+func (c *controller) NewNetwork(ctx context.Context, networkType, name string, options ...NetworkOption) (Network, error) {
+	ev := HookEvent{Point: HookPostCreateNetwork, NetworkType: networkType, Name: name}
+	network, err := c.newNetwork(ctx, networkType, name, options...)
+	err, aborted := abortedByHook(err)
+	if network != nil && network.dryRun != nil {
+		if !aborted {
+			ev.DryRun = true
+			c.runPostHooks(ctx, ev, err)
+		}
+		return nil, err
 	}
-	// Check if a network already exists with the specified network name
-	c.Lock()
-	for _, n := range c.networks {
-		if n.name == name {
-			c.Unlock()
-			return nil, NetworkNameError(name)
+	// A network which failed to be created is recorded under the id it
+	// was given, unless it failed before getting one
+	if network != nil {
+		c.audit(ctx, network, AuditNetworkCreate, name, map[string]string{"type": networkType, "id": string(network.id)}, err)
+	}
+	if err != nil {
+		if !aborted {
+			c.runPostHooks(ctx, ev, err)
 		}
+		return nil, err
+	}
+	ev.Network = network
+	c.runPostHooks(ctx, ev, nil)
+	return network, nil
+}
+
+// newNetwork returns the network on success or on a dry run, and on the
+// failures once the network was given an id, for them to be audited
+func (c *controller) newNetwork(ctx context.Context, networkType, name string, options ...NetworkOption) (*network, error) {
+	if c.isReadOnly() {
+		return nil, ErrReadOnly{}
 	}
-	c.Unlock()
 
 	// Construct the network object
 	network := &network{
@@ -255,24 +438,97 @@ func (c *controller) NewNetwork(networkType, name string, options ...NetworkOpti
 		endpoints:   endpointTable{},
 	}
 
+	// The options tell whether the creation is only validated
 	network.processOptions(options...)
 
-	if err := c.addNetwork(network); err != nil {
-		return nil, err
+	if !config.IsValidName(name) {
+		return network, ErrInvalidName(name)
 	}
+	// Check if a network already exists with the specified network name
+	c.Lock()
+	for _, n := range c.networks {
+		if n.name == name {
+			c.Unlock()
+			return network, NetworkNameError(name)
+		}
+	}
+	c.Unlock()
 
-	if err := c.updateNetworkToStore(network); err != nil {
+	if err := c.inheritConfig(network); err != nil {
+		return network, err
+	}
+	if network.maxEndpoints < 0 {
+		return network, types.BadRequestErrorf("invalid maximum number of endpoints %d for network %s", network.maxEndpoints, name)
+	}
+	if err := network.validateVIPPool(ctx); err != nil {
+		return network, err
+	}
+
+	// The network is authorized once its options, its tenant among them,
+	// are known
+	if err := c.authorize(ctx, network.authzRequest(AuditNetworkCreate, nil)); err != nil {
+		return network, err
+	}
+
+	// The pre hooks run once the network is authorized, and are told
+	// whether its creation is only validated
+	ev := HookEvent{Point: HookPreCreateNetwork, NetworkType: networkType, Name: name, DryRun: network.dryRun != nil}
+	if err := c.runPreHooks(ctx, ev); err != nil {
+		return network, &hookAbortError{err}
+	}
+
+	if network.globalScope {
+		if err := network.normalizeGeneric(); err != nil {
+			return network, types.BadRequestErrorf("network %s options cannot be stored: %v", name, err)
+		}
+	}
+
+	if network.dryRun != nil {
+		return network, c.planNetwork(network)
+	}
+
+	if err := c.chargeQuota(ctx, network.tenant, 1, 0); err != nil {
+		return network, err
+	}
+
+	if err := c.addNetwork(ctx, network); err != nil {
+		c.releaseQuota(network.tenant, 1, 0)
+		return network, err
+	}
+
+	if network.globalScope {
+		if global, _ := c.isDriverGlobalScoped(networkType); global {
+			// The whole network is already global
+			network.globalScope = false
+		}
+	}
+
+	// The pools of the endpoint addresses are only known once the driver
+	// created the network
+	if err := network.checkVIPPoolOverlap(); err != nil {
+		if e := network.delete(context.Background()); e != nil {
+			log.Warnf("couldnt cleanup network %s: %v", network.name, e)
+		}
+		return network, err
+	}
+
+	if err := c.updateNetworkToStore(ctx, network); err != nil {
 		log.Warnf("couldnt create network %s: %v", network.name, err)
-		if e := network.Delete(); e != nil {
+		// The cleanup must not be abandoned with the request
+		if e := network.delete(context.Background()); e != nil {
 			log.Warnf("couldnt cleanup network %s: %v", network.name, err)
 		}
-		return nil, err
+		return network, err
+	}
+
+	if err := network.recordExternalEndpoints(ctx); err != nil {
+		log.Warnf("couldnt record the external endpoints of network %s: %v", network.name, err)
 	}
 
 	return network, nil
 }
 
-func (c *controller) addNetwork(n *network) error {
+func (c *controller) addNetwork(ctx context.Context, n *network) error {
 
 	c.Lock()
 	// Check if a driver for the specified network type is available
@@ -290,18 +546,22 @@ func (c *controller) addNetwork(n *network) error {
 	n.Lock()
 	n.svcRecords = svcMap{}
 	n.driver = dd.driver
-	d := n.driver
 	n.Unlock()
 
-	// Create the network
-	if err := d.CreateNetwork(n.id, n.generic); err != nil {
-		return err
+	// Create the network. Networks defined at global scope which are read
+	// from the store are only created in the driver when first used here,
+	// and a read-only controller never creates them. A configuration
+	// network is never created.
+	if !c.isReadOnly() && !n.configOnly && (!n.globalScope || !n.Exists()) {
+		if err := n.materialize(ctx); err != nil {
+			return err
+		}
 	}
 	if err := n.watchEndpoints(); err != nil {
 		return err
 	}
 	c.Lock()
-	c.networks[n.id] = n
+	c.setNetwork(n)
 	c.Unlock()
 
 	return nil
@@ -366,8 +626,11 @@ func (c *controller) loadDriver(networkType string) (*driverData, error) {
 	// Plugins pkg performs lazy loading of plugins that acts as remote drivers.
 	// As per the design, this Get call will result in remote driver discovery if there is a corresponding plugin available.
 	_, err := plugins.Get(networkType, driverapi.NetworkPluginEndpointType)
+	if err == plugins.ErrNotFound {
+		err = c.activatePlugin(networkType)
+	}
 	if err != nil {
-		if err == plugins.ErrNotFound {
+		if err == plugins.ErrNotFound || err == plugindiscovery.ErrNotFound {
 			return nil, types.NotFoundErrorf(err.Error())
 		}
 		return nil, err
@@ -381,19 +644,89 @@ func (c *controller) loadDriver(networkType string) (*driverData, error) {
 	return dd, nil
 }
 
-func (c *controller) isDriverGlobalScoped(networkType string) (bool, error) {
+// activatePlugin looks up the plugins not known to the docker plugin registry,
+// like the ones of managed plugin containers or systemd socket units, and
+// registers them as remote drivers on first use.
+func (c *controller) activatePlugin(name string) error {
+	c.pluginMu.Lock()
+	defer c.pluginMu.Unlock()
+
 	c.Lock()
-	dd, ok := c.drivers[networkType]
+	_, ok := c.drivers[name]
+	var cfg config.PluginCfg
+	if c.cfg != nil {
+		cfg = c.cfg.Plugins
+	}
 	c.Unlock()
+
+	// Activated while waiting
+	if ok {
+		return nil
+	}
+
+	addr, err := plugindiscovery.New(cfg).Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Activating plugin %s discovered at %s", name, addr)
+	return remote.Activate(c, name, addr, cfg.ActivationTimeout(name))
+}
+
+// driverCapability returns the capability of the driver of the network type,
+// or of the driver unregistered, which its networks keep while quiesced
+func (c *controller) driverCapability(networkType string) (driverapi.Capability, bool) {
+	c.Lock()
+	defer c.Unlock()
+	dd, ok := c.drivers[networkType]
+	if !ok {
+		dd, ok = c.unloaded[networkType]
+	}
+	if !ok {
+		return driverapi.Capability{}, false
+	}
+	return dd.capability, true
+}
+
+func (c *controller) isDriverGlobalScoped(networkType string) (bool, error) {
+	capability, ok := c.driverCapability(networkType)
 	if !ok {
 		return false, types.NotFoundErrorf("driver not found for %s", networkType)
 	}
-	if dd.capability.Scope == driverapi.GlobalScope {
+	if capability.Scope == driverapi.GlobalScope {
 		return true, nil
 	}
 	return false, nil
 }
 
+// driverAssignsAddress tells whether the driver of the network type reports
+// the endpoint addresses on join
+func (c *controller) driverAssignsAddress(networkType string) bool {
+	capability, ok := c.driverCapability(networkType)
+	return ok && capability.DriverAssignsAddress
+}
+
 func (c *controller) GC() {
 	sandbox.GC()
 }
+
+func (c *controller) Stop() error {
+	c.Lock()
+	stop := c.stopSysctlWatch
+	c.stopSysctlWatch = nil
+	stopResolvConf := c.stopResolvConfWatch
+	c.stopResolvConfWatch = nil
+	c.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	if stopResolvConf != nil {
+		stopResolvConf()
+	}
+	c.releaseStoreOwnership()
+	if c.cfg != nil && c.cfg.Daemon.SysctlRestore {
+		return sysctl.Default().Restore()
+	}
+	return nil
+}
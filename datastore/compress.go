@@ -0,0 +1,172 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libkv/store"
+)
+
+// gzipHeader prefixes the values stored compressed with gzip. The values
+// stored as they are, all JSON, cannot start with it.
+const gzipHeader byte = 0x01
+
+// compressingStore compresses the values written to the wrapped store which
+// are at least threshold bytes long, and decompresses the values read from
+// it, whether they were compressed or not. A zero threshold disables the
+// compression, for the stores shared with the daemons which cannot read the
+// compressed values.
+type compressingStore struct {
+	store.Store
+	threshold int
+}
+
+func newCompressingStore(s store.Store, threshold int) *compressingStore {
+	if cs, ok := s.(*compressingStore); ok {
+		s = cs.Store
+	}
+	return &compressingStore{Store: s, threshold: threshold}
+}
+
+func (s *compressingStore) compress(value []byte) ([]byte, error) {
+	if s.threshold <= 0 || len(value) < s.threshold {
+		return value, nil
+	}
+	var b bytes.Buffer
+	b.WriteByte(gzipHeader)
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != gzipHeader {
+		return value, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(value[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress value: %v", err)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decompressPair returns a copy of the pair with its value decompressed
+func decompressPair(pair *store.KVPair) (*store.KVPair, error) {
+	if pair == nil {
+		return nil, nil
+	}
+	value, err := decompress(pair.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pair.Key, err)
+	}
+	return &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}, nil
+}
+
+func (s *compressingStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	value, err := s.compress(value)
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(key, value, options)
+}
+
+func (s *compressingStore) Get(key string) (*store.KVPair, error) {
+	pair, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decompressPair(pair)
+}
+
+func (s *compressingStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := s.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*store.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		p, err := decompressPair(pair)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// Watch relays the changes of the key with their value decompressed. A
+// value which cannot be decompressed is not relayed.
+func (s *compressingStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	in, err := s.Store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for pair := range in {
+			p, err := decompressPair(pair)
+			if err != nil {
+				log.Warnf("Dropping watched value: %v", err)
+				continue
+			}
+			select {
+			case out <- p:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree relays the changes under the directory with their values
+// decompressed. The values which cannot be decompressed are left out.
+func (s *compressingStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	in, err := s.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range in {
+			list := make([]*store.KVPair, 0, len(pairs))
+			for _, pair := range pairs {
+				p, err := decompressPair(pair)
+				if err != nil {
+					log.Warnf("Dropping watched value: %v", err)
+					continue
+				}
+				list = append(list, p)
+			}
+			select {
+			case out <- list:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *compressingStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	stored, err := s.compress(value)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, pair, err := s.Store.AtomicPut(key, stored, previous, options)
+	if err != nil || pair == nil {
+		return ok, pair, err
+	}
+	return ok, &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}, nil
+}
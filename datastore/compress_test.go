@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	ms := NewMockStore()
+	ds := WithCompression(NewCustomDataStore(ms), 512)
+
+	large := dummyKVObject("large", true)
+	large.Name = strings.Repeat("compressible", 100)
+	if err := ds.PutObjectAtomic(large); err != nil {
+		t.Fatal(err)
+	}
+	small := dummyKVObject("small", true)
+	small.Generic = nil
+	small.Rec = nil
+	if err := ds.PutObject(small); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ms.Get(large.Key().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Value[0] != gzipHeader || len(raw.Value) >= len(large.Value()) {
+		t.Fatalf("Large value was not stored compressed: %d bytes out of %d", len(raw.Value), len(large.Value()))
+	}
+	if raw, _ := ms.Get(small.Key().String()); raw.Value[0] == gzipHeader {
+		t.Fatal("Value below the threshold was stored compressed")
+	}
+
+	// Readable through any datastore, compressing or not
+	for _, rds := range []DataStore{ds, NewCustomDataStore(ms), ReadOnly(ds)} {
+		n := &dummyObject{}
+		if err := rds.GetObject(large.Key().String(), n); err != nil {
+			t.Fatal(err)
+		}
+		if n.Name != large.Name {
+			t.Fatalf("Unexpected name of %d bytes read back", len(n.Name))
+		}
+		pairs, err := rds.KVStore().List(Key(dummyKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pairs {
+			if p.Value[0] == gzipHeader {
+				t.Fatalf("Listed value of %s was not decompressed", p.Key)
+			}
+		}
+	}
+
+	// Without threshold the values are written as they are
+	plain := NewCustomDataStore(ms)
+	other := dummyKVObject("other", true)
+	other.Name = large.Name
+	if err := plain.PutObject(other); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := ms.Get(other.Key().String()); raw.Value[0] == gzipHeader {
+		t.Fatal("Value was compressed without threshold")
+	}
+
+	if err := ms.Put(Key(dummyKey, "corrupt"), []byte{gzipHeader, 'x'}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.GetObject(Key(dummyKey, "corrupt"), &dummyObject{}); err == nil {
+		t.Fatal("Corrupt compressed value was read")
+	}
+}
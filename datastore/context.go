@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"context"
+
+	"github.com/docker/libkv/store"
+)
+
+// contextStore bounds the operations of the wrapped store by a context.
+// The KV store clients cannot abort an operation once it was issued, so an
+// operation given up on may still complete on the store afterwards, and its
+// goroutine only ends once the client returns. No operation is issued once
+// the context is done.
+type contextStore struct {
+	store.Store
+	ctx context.Context
+}
+
+// WithContext returns a view of ds whose operations fail with the context
+// error, instead of blocking, once ctx is canceled or its deadline expires
+func WithContext(ctx context.Context, ds DataStore) DataStore {
+	if ds == nil || ctx == nil || ctx.Done() == nil {
+		return ds
+	}
+	return &datastore{store: &contextStore{Store: ds.KVStore(), ctx: ctx}}
+}
+
+func (s *contextStore) do(f func() error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *contextStore) Get(key string) (*store.KVPair, error) {
+	var pair *store.KVPair
+	err := s.do(func() error {
+		var err error
+		pair, err = s.Store.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *contextStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return s.do(func() error {
+		return s.Store.Put(key, value, options)
+	})
+}
+
+func (s *contextStore) Delete(key string) error {
+	return s.do(func() error {
+		return s.Store.Delete(key)
+	})
+}
+
+func (s *contextStore) Exists(key string) (bool, error) {
+	var ok bool
+	err := s.do(func() error {
+		var err error
+		ok, err = s.Store.Exists(key)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+func (s *contextStore) List(directory string) ([]*store.KVPair, error) {
+	var pairs []*store.KVPair
+	err := s.do(func() error {
+		var err error
+		pairs, err = s.Store.List(directory)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+func (s *contextStore) DeleteTree(directory string) error {
+	return s.do(func() error {
+		return s.Store.DeleteTree(directory)
+	})
+}
+
+func (s *contextStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	var (
+		ok   bool
+		pair *store.KVPair
+	)
+	err := s.do(func() error {
+		var err error
+		ok, pair, err = s.Store.AtomicPut(key, value, previous, options)
+		return err
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return ok, pair, nil
+}
+
+func (s *contextStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	var ok bool
+	err := s.do(func() error {
+		var err error
+		ok, err = s.Store.AtomicDelete(key, previous)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
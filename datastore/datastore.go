@@ -41,9 +41,9 @@ type datastore struct {
 //KV Key Value interface used by objects to be part of the DataStore
 type KV interface {
 	// Key method lets an object to provide the Key to be used in KV Store
-	Key() []string
+	Key() KeyPath
 	// KeyPrefix method lets an object to return immediate parent key that can be used for tree walk
-	KeyPrefix() []string
+	KeyPrefix() KeyPath
 	// Value method lets an object to marshal its content to be stored in the KV store
 	Value() []byte
 	// SetValue is used by the datastore to set the object's value when loaded from the data store.
@@ -62,6 +62,10 @@ const (
 	NetworkKeyPrefix = "network"
 	// EndpointKeyPrefix is the prefix for endpoint key in the kv store
 	EndpointKeyPrefix = "endpoint"
+	// AuditKeyPrefix is the prefix for the network audit logs in the kv store
+	AuditKeyPrefix = "audit"
+	// QuotaKeyPrefix is the prefix for the tenant quota usages in the kv store
+	QuotaKeyPrefix = "quota"
 )
 
 var rootChain = []string{"docker", "libnetwork"}
@@ -85,12 +89,12 @@ func ParseKey(key string) ([]string, error) {
 }
 
 // newClient used to connect to KV Store
-func newClient(kv string, addrs string) (DataStore, error) {
+func newClient(kv string, addrs string, compressionThreshold int) (DataStore, error) {
 	store, err := libkv.NewStore(store.Backend(kv), []string{addrs}, &store.Config{})
 	if err != nil {
 		return nil, err
 	}
-	ds := &datastore{store: store}
+	ds := &datastore{store: newCompressingStore(store, compressionThreshold)}
 	return ds, nil
 }
 
@@ -100,12 +104,19 @@ func NewDataStore(cfg *config.DatastoreCfg) (DataStore, error) {
 		return nil, types.BadRequestErrorf("invalid configuration passed to datastore")
 	}
 	// TODO : cfg.Embedded case
-	return newClient(cfg.Client.Provider, cfg.Client.Address)
+	return newClient(cfg.Client.Provider, cfg.Client.Address, cfg.CompressionThreshold)
 }
 
 // NewCustomDataStore can be used by clients to plugin cusom datatore that adhers to store.Store
 func NewCustomDataStore(customStore store.Store) DataStore {
-	return &datastore{store: customStore}
+	return &datastore{store: newCompressingStore(customStore, 0)}
+}
+
+// WithCompression returns a view of ds compressing the values of at least
+// threshold bytes it writes. The values are decompressed when read whatever
+// the threshold, so that the stores written with compression stay readable.
+func WithCompression(ds DataStore, threshold int) DataStore {
+	return &datastore{store: newCompressingStore(ds.KVStore(), threshold)}
 }
 
 func (ds *datastore) KVStore() store.Store {
@@ -117,19 +128,22 @@ func (ds *datastore) PutObjectAtomic(kvObject KV) error {
 	if kvObject == nil {
 		return types.BadRequestErrorf("invalid KV Object : nil")
 	}
+	if err := kvObject.Key().Validate(); err != nil {
+		return err
+	}
 	kvObjValue := kvObject.Value()
 
 	if kvObjValue == nil {
-		return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", Key(kvObject.Key()...))
+		return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", kvObject.Key().String())
 	}
 
 	var previous *store.KVPair
 	if kvObject.Exists() {
-		previous = &store.KVPair{Key: Key(kvObject.Key()...), LastIndex: kvObject.Index()}
+		previous = &store.KVPair{Key: kvObject.Key().String(), LastIndex: kvObject.Index()}
 	} else {
 		previous = nil
 	}
-	_, pair, err := ds.store.AtomicPut(Key(kvObject.Key()...), kvObjValue, previous, nil)
+	_, pair, err := ds.store.AtomicPut(kvObject.Key().String(), kvObjValue, previous, nil)
 	if err != nil {
 		return err
 	}
@@ -143,16 +157,19 @@ func (ds *datastore) PutObject(kvObject KV) error {
 	if kvObject == nil {
 		return types.BadRequestErrorf("invalid KV Object : nil")
 	}
-	return ds.putObjectWithKey(kvObject, kvObject.Key()...)
+	return ds.putObjectWithKey(kvObject, kvObject.Key())
 }
 
-func (ds *datastore) putObjectWithKey(kvObject KV, key ...string) error {
+func (ds *datastore) putObjectWithKey(kvObject KV, key KeyPath) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
 	kvObjValue := kvObject.Value()
 
 	if kvObjValue == nil {
-		return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", Key(kvObject.Key()...))
+		return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", kvObject.Key().String())
 	}
-	return ds.store.Put(Key(key...), kvObjValue, nil)
+	return ds.store.Put(key.String(), kvObjValue, nil)
 }
 
 // GetObject returns a record matching the key
@@ -174,7 +191,10 @@ func (ds *datastore) GetObject(key string, o KV) error {
 
 // DeleteObject unconditionally deletes a record from the store
 func (ds *datastore) DeleteObject(kvObject KV) error {
-	return ds.store.Delete(Key(kvObject.Key()...))
+	if err := kvObject.Key().Validate(); err != nil {
+		return err
+	}
+	return ds.store.Delete(kvObject.Key().String())
 }
 
 // DeleteObjectAtomic performs atomic delete on a record
@@ -182,13 +202,19 @@ func (ds *datastore) DeleteObjectAtomic(kvObject KV) error {
 	if kvObject == nil {
 		return types.BadRequestErrorf("invalid KV Object : nil")
 	}
+	if err := kvObject.Key().Validate(); err != nil {
+		return err
+	}
 
-	previous := &store.KVPair{Key: Key(kvObject.Key()...), LastIndex: kvObject.Index()}
-	_, err := ds.store.AtomicDelete(Key(kvObject.Key()...), previous)
+	previous := &store.KVPair{Key: kvObject.Key().String(), LastIndex: kvObject.Index()}
+	_, err := ds.store.AtomicDelete(kvObject.Key().String(), previous)
 	return err
 }
 
 // DeleteTree unconditionally deletes a record from the store
 func (ds *datastore) DeleteTree(kvObject KV) error {
-	return ds.store.DeleteTree(Key(kvObject.KeyPrefix()...))
+	if err := kvObject.KeyPrefix().Validate(); err != nil {
+		return err
+	}
+	return ds.store.DeleteTree(kvObject.KeyPrefix().String())
 }
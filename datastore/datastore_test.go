@@ -1,10 +1,13 @@
 package datastore
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/config"
 	_ "github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/options"
@@ -86,7 +89,7 @@ func TestAtomicKVObjectFlatKey(t *testing.T) {
 
 	// Get the latest index and try PutObjectAtomic again for the same Key
 	// This must succeed as well
-	data, err := store.KVStore().Get(Key(expected.Key()...))
+	data, err := store.KVStore().Get(expected.Key().String())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -102,7 +105,7 @@ func TestAtomicKVObjectFlatKey(t *testing.T) {
 
 	// Get the Object using GetObject, then set again.
 	newObj := dummyObject{}
-	err = store.GetObject(Key(expected.Key()...), &newObj)
+	err = store.GetObject(expected.Key().String(), &newObj)
 	assert.True(t, newObj.Exists())
 	err = store.PutObjectAtomic(&n)
 	if err != nil {
@@ -125,12 +128,12 @@ type dummyObject struct {
 	ReturnValue bool
 }
 
-func (n *dummyObject) Key() []string {
-	return []string{dummyKey, n.ID}
+func (n *dummyObject) Key() KeyPath {
+	return NewKeyPath(dummyKey, n.ID)
 }
 
-func (n *dummyObject) KeyPrefix() []string {
-	return []string{dummyKey}
+func (n *dummyObject) KeyPrefix() KeyPath {
+	return NewKeyPath(dummyKey)
 }
 
 func (n *dummyObject) Value() []byte {
@@ -192,8 +195,8 @@ type recStruct struct {
 	DBExists bool
 }
 
-func (r *recStruct) Key() []string {
-	return []string{"recStruct"}
+func (r *recStruct) Key() KeyPath {
+	return NewKeyPath("recStruct")
 }
 func (r *recStruct) Value() []byte {
 	b, err := json.Marshal(r)
@@ -239,3 +242,77 @@ func dummyKVObject(id string, retValue bool) *dummyObject {
 	n.Generic = generic
 	return &n
 }
+
+func TestReadOnlyDataStore(t *testing.T) {
+	ds := NewTestDataStore()
+	expected := dummyKVObject("2222", true)
+	if err := ds.PutObjectAtomic(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	ro := ReadOnly(ds)
+	n := dummyObject{}
+	if err := ro.GetObject(expected.Key().String(), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != expected.Name {
+		t.Fatalf("Dummy object doesnt match the expected object")
+	}
+
+	if err := ro.PutObjectAtomic(expected); err != ErrReadOnly {
+		t.Fatalf("Expected ErrReadOnly on update, got %v", err)
+	}
+	if err := ro.PutObject(dummyKVObject("3333", true)); err != ErrReadOnly {
+		t.Fatalf("Expected ErrReadOnly on put, got %v", err)
+	}
+	if err := ro.DeleteObjectAtomic(expected); err != ErrReadOnly {
+		t.Fatalf("Expected ErrReadOnly on delete, got %v", err)
+	}
+	if err := ro.DeleteTree(expected); err != ErrReadOnly {
+		t.Fatalf("Expected ErrReadOnly on tree delete, got %v", err)
+	}
+	if _, err := ds.KVStore().Get(expected.Key().String()); err != nil {
+		t.Fatalf("Object was removed through the read-only store: %v", err)
+	}
+}
+
+// blockingStore blocks the reads until released
+type blockingStore struct {
+	*MockStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Get(key string) (*store.KVPair, error) {
+	<-s.release
+	return s.MockStore.Get(key)
+}
+
+func TestContextDataStore(t *testing.T) {
+	bs := &blockingStore{MockStore: NewMockStore(), release: make(chan struct{})}
+	ds := NewCustomDataStore(bs)
+	expected := dummyKVObject("4444", true)
+	if err := ds.PutObjectAtomic(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n := dummyObject{}
+	if err := WithContext(ctx, ds).GetObject(expected.Key().String(), &n); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the read to time out, got %v", err)
+	}
+	if err := WithContext(ctx, ds).PutObject(dummyKVObject("5555", true)); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the write to fail once the context expired, got %v", err)
+	}
+	if ok, _ := bs.Exists(Key(dummyKey, "5555")); ok {
+		t.Fatal("Object was written after the context expired")
+	}
+
+	close(bs.release)
+	if err := WithContext(context.Background(), ds).GetObject(expected.Key().String(), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != expected.Name {
+		t.Fatalf("Dummy object doesnt match the expected object")
+	}
+}
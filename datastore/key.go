@@ -0,0 +1,129 @@
+package datastore
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// KeyPath is the key of an object in the kv store, below the libnetwork
+// root: a prefix naming the kind of the object, followed by the identifiers
+// of the object and of its parents. The identifiers are escaped.
+type KeyPath []string
+
+// NewKeyPath returns the key of the object of the ids under the prefix
+func NewKeyPath(prefix string, ids ...string) KeyPath {
+	return KeyPath{prefix}.Append(ids...)
+}
+
+// ParseKeyPath returns the key path of a key read from the kv store
+func ParseKeyPath(key string) (KeyPath, error) {
+	chain, err := ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return KeyPath(chain), nil
+}
+
+// Append returns the key of the ids below k. Each id is escaped, so that an
+// id holding a separator, or naming the current or parent element, stays a
+// single element of the key and cannot reach the key of another object.
+func (k KeyPath) Append(ids ...string) KeyPath {
+	path := make(KeyPath, len(k), len(k)+len(ids))
+	copy(path, k)
+	for _, id := range ids {
+		path = append(path, escapeKeyElement(id))
+	}
+	return path
+}
+
+// Prefix returns the prefix of the key
+func (k KeyPath) Prefix() string {
+	if len(k) == 0 {
+		return ""
+	}
+	return k[0]
+}
+
+// IDs returns the unescaped identifiers of the key, following its prefix
+func (k KeyPath) IDs() ([]string, error) {
+	if len(k) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(k)-1)
+	for _, e := range k[1:] {
+		id, err := unescapeKeyElement(e)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid element %q of key %s", e, k)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Validate checks that the key can be stored: it must have a prefix, and
+// no empty element nor one that a store would take as a path of its own.
+func (k KeyPath) Validate() error {
+	if len(k) == 0 {
+		return types.BadRequestErrorf("invalid empty key")
+	}
+	for _, e := range k {
+		if e == "" || e == "." || e == ".." || strings.Contains(e, "/") {
+			return types.BadRequestErrorf("invalid element %q of key %s", e, k)
+		}
+	}
+	return nil
+}
+
+// String returns the key the object is stored under
+func (k KeyPath) String() string {
+	return Key(k...)
+}
+
+// escapeKeyElement percent-encodes the bytes of id but the letters, digits
+// and the '-', '_', '.' and ':' of the ids and addresses libnetwork uses.
+// The dots are encoded as well when they make up the whole id.
+func escapeKeyElement(id string) string {
+	dots := id == "." || id == ".."
+	var b bytes.Buffer
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if isKeyChar(c) && (c != '.' || !dots) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte("0123456789ABCDEF"[c>>4])
+		b.WriteByte("0123456789ABCDEF"[c&15])
+	}
+	return b.String()
+}
+
+// unescapeKeyElement decodes the percent-encoded bytes of an element
+// escaped by escapeKeyElement
+func unescapeKeyElement(e string) (string, error) {
+	id := make([]byte, 0, len(e))
+	for i := 0; i < len(e); i++ {
+		if e[i] != '%' {
+			id = append(id, e[i])
+			continue
+		}
+		if i+2 >= len(e) {
+			return "", strconv.ErrSyntax
+		}
+		c, err := strconv.ParseUint(e[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", err
+		}
+		id = append(id, byte(c))
+		i += 2
+	}
+	return string(id), nil
+}
+
+func isKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == ':'
+}
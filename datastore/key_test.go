@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyPath(t *testing.T) {
+	key := NewKeyPath(EndpointKeyPrefix, "net1", "ep1")
+	if key.String() != "docker/libnetwork/endpoint/net1/ep1/" {
+		t.Fatalf("unexpected key : %s", key)
+	}
+
+	// An id cannot reach the key of another object
+	for id, escaped := range map[string]string{
+		"net1/ep1":        "net1%2Fep1",
+		"..":              "%2E%2E",
+		".":               "%2E",
+		"a%2Fb":           "a%252Fb",
+		"fd00::1":         "fd00::1",
+		"10.0.0.0":        "10.0.0.0",
+		"tenant name\x00": "tenant%20name%00",
+	} {
+		key := NewKeyPath(QuotaKeyPrefix, id)
+		if key[1] != escaped {
+			t.Fatalf("id %q escaped as %q, expected %q", id, key[1], escaped)
+		}
+		if err := key.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := key.IDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != id {
+			t.Fatalf("unexpected ids %q of key %s", ids, key)
+		}
+	}
+	if NewKeyPath(EndpointKeyPrefix, "a/b", "c").String() == NewKeyPath(EndpointKeyPrefix, "a", "b/c").String() {
+		t.Fatal("keys of different ids collide")
+	}
+
+	for _, key := range []KeyPath{{}, NewKeyPath(""), NewKeyPath(QuotaKeyPrefix, ""), {"a/b"}, {"network", ".."}} {
+		if err := key.Validate(); err == nil {
+			t.Fatalf("invalid key %q was accepted", []string(key))
+		}
+	}
+
+	for _, e := range []string{"a%2", "a%", "%zz", "%+1"} {
+		if _, err := (KeyPath{QuotaKeyPrefix, e}).IDs(); err == nil {
+			t.Fatalf("invalid escaped element %q was accepted", e)
+		}
+	}
+
+	parsed, err := ParseKeyPath(key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, key) || parsed.Prefix() != EndpointKeyPrefix {
+		t.Fatalf("unexpected parsed key %q", []string(parsed))
+	}
+}
+
+func TestPutObjectInvalidKey(t *testing.T) {
+	ds := NewTestDataStore()
+	if err := ds.PutObject(dummyKVObject("", true)); err == nil {
+		t.Fatal("object with an empty id was stored")
+	}
+	if err := ds.PutObjectAtomic(dummyKVObject("", true)); err == nil {
+		t.Fatal("object with an empty id was stored")
+	}
+
+	o := dummyKVObject("../network", true)
+	if err := ds.PutObject(o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.KVStore().Get(Key(dummyKey, "..%2Fnetwork")); err != nil {
+		t.Fatalf("object was not stored under its escaped key: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/types"
@@ -34,7 +35,7 @@ func NewMockStore() *MockStore {
 func (s *MockStore) Get(key string) (*store.KVPair, error) {
 	mData := s.db[key]
 	if mData == nil {
-		return nil, nil
+		return nil, store.ErrKeyNotFound
 	}
 	return &store.KVPair{Value: mData.Data, LastIndex: mData.Index}, nil
 
@@ -46,6 +47,7 @@ func (s *MockStore) Put(key string, value []byte, options *store.WriteOptions) e
 	if mData == nil {
 		mData = &MockData{value, 0}
 	}
+	mData.Data = value
 	mData.Index = mData.Index + 1
 	s.db[key] = mData
 	return nil
@@ -65,7 +67,16 @@ func (s *MockStore) Exists(key string) (bool, error) {
 
 // List gets a range of values at "directory"
 func (s *MockStore) List(prefix string) ([]*store.KVPair, error) {
-	return nil, ErrNotImplmented
+	var kvs []*store.KVPair
+	for key, mData := range s.db {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, &store.KVPair{Key: key, Value: mData.Data, LastIndex: mData.Index})
+		}
+	}
+	if len(kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return kvs, nil
 }
 
 // DeleteTree deletes a range of values at "directory"
@@ -84,9 +95,43 @@ func (s *MockStore) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*
 	return nil, ErrNotImplmented
 }
 
-// NewLock exposed
+// NewLock returns a lock of the key, which fails to be acquired at once
+// rather than waiting if the key exists
 func (s *MockStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
-	return nil, ErrNotImplmented
+	l := &mockLock{store: s, key: key}
+	if options != nil {
+		l.value = options.Value
+	}
+	return l, nil
+}
+
+type mockLock struct {
+	store *MockStore
+	key   string
+	value []byte
+	lost  chan struct{}
+}
+
+// Lock writes the value of the lock at its key
+func (l *mockLock) Lock() (<-chan struct{}, error) {
+	if _, ok := l.store.db[l.key]; ok {
+		return nil, store.ErrCannotLock
+	}
+	if err := l.store.Put(l.key, l.value, nil); err != nil {
+		return nil, err
+	}
+	l.lost = make(chan struct{})
+	return l.lost, nil
+}
+
+// Unlock removes the key of the lock
+func (l *mockLock) Unlock() error {
+	if l.lost == nil {
+		return store.ErrCannotLock
+	}
+	close(l.lost)
+	l.lost = nil
+	return l.store.Delete(l.key)
 }
 
 // AtomicPut put a value at "key" if the key has not been
@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// OwnerKeyPrefix is the key of the lock held by the exclusive owner of the
+// kv store
+const OwnerKeyPrefix = "owner"
+
+// DefaultOwnershipTTL is how long the lock of an owner which stopped
+// renewing it, like a crashed daemon, lasts
+const DefaultOwnershipTTL = 30 * time.Second
+
+// ErrStoreOwned is returned when the kv store is owned by another controller
+type ErrStoreOwned string
+
+func (owner ErrStoreOwned) Error() string {
+	return fmt.Sprintf("datastore is owned by %s: another daemon uses it, or one which crashed still holds it, in which case take it over", string(owner))
+}
+
+// Forbidden denotes the type of this error
+func (owner ErrStoreOwned) Forbidden() {}
+
+// Ownership is the exclusive ownership of a kv store
+type Ownership struct {
+	locker store.Locker
+	lost   <-chan struct{}
+}
+
+// AcquireOwnership locks the kv store for the owner. The lock of another
+// owner is waited for, as the one of a crashed owner expires after
+// DefaultOwnershipTTL, unless takeover is set, in which case it is removed
+// first. The lock is given up if it is not acquired within timeout, with
+// ErrStoreOwned if another owner still holds it.
+func AcquireOwnership(ds DataStore, owner string, takeover bool, timeout time.Duration) (*Ownership, error) {
+	kv := ds.KVStore()
+	key := NewKeyPath(OwnerKeyPrefix).String()
+
+	if takeover {
+		if pair, err := kv.Get(key); err == nil && len(pair.Value) != 0 {
+			if err := kv.Delete(key); err != nil {
+				return nil, fmt.Errorf("failed to take the datastore over from %s: %v", pair.Value, err)
+			}
+		} else if err != nil && err != store.ErrKeyNotFound {
+			return nil, err
+		}
+	}
+
+	locker, err := kv.NewLock(key, &store.LockOptions{Value: []byte(owner), TTL: DefaultOwnershipTTL})
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		lost <-chan struct{}
+		err  error
+	}
+	var (
+		mu        sync.Mutex
+		abandoned bool
+		done      = make(chan result, 1)
+	)
+	go func() {
+		lost, err := locker.Lock()
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			// The owner gave up waiting, the lock is not kept
+			if err == nil {
+				locker.Unlock()
+			}
+			return
+		}
+		done <- result{lost, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			if pair, err := kv.Get(key); err == nil && len(pair.Value) != 0 {
+				return nil, ErrStoreOwned(pair.Value)
+			}
+			return nil, r.err
+		}
+		return &Ownership{locker: locker, lost: r.lost}, nil
+	case <-time.After(timeout):
+		mu.Lock()
+		abandoned = true
+		// The lock may have been acquired meanwhile
+		select {
+		case r := <-done:
+			if r.err == nil {
+				locker.Unlock()
+			}
+		default:
+		}
+		mu.Unlock()
+		if pair, err := kv.Get(key); err == nil && len(pair.Value) != 0 {
+			return nil, ErrStoreOwned(pair.Value)
+		}
+		return nil, fmt.Errorf("timed out acquiring the ownership of the datastore")
+	}
+}
+
+// Lost returns a channel closed when the ownership is lost, for instance
+// when it is taken over
+func (o *Ownership) Lost() <-chan struct{} {
+	return o.lost
+}
+
+// Release gives the ownership up
+func (o *Ownership) Release() error {
+	return o.locker.Unlock()
+}
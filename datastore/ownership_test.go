@@ -0,0 +1,43 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOwnership(t *testing.T) {
+	ds := NewTestDataStore()
+
+	o, err := AcquireOwnership(ds, "host1/1", false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = AcquireOwnership(ds, "host2/2", false, time.Second)
+	if owner, ok := err.(ErrStoreOwned); !ok || owner != "host1/1" {
+		t.Fatalf("Expected the store to be owned by host1/1, got %v", err)
+	}
+
+	if err := o.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-o.Lost():
+	default:
+		t.Fatal("Released ownership is not lost")
+	}
+
+	if _, err := AcquireOwnership(ds, "host2/2", false, time.Second); err != nil {
+		t.Fatalf("Released store could not be owned: %v", err)
+	}
+
+	// A crashed owner leaves its lock behind
+	_, err = AcquireOwnership(ds, "host1/1", true, time.Second)
+	if err != nil {
+		t.Fatalf("Store could not be taken over: %v", err)
+	}
+	pair, err := ds.KVStore().Get(NewKeyPath(OwnerKeyPrefix).String())
+	if err != nil || string(pair.Value) != "host1/1" {
+		t.Fatalf("Store was not taken over: %v", err)
+	}
+}
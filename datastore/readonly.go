@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// ErrReadOnly is returned by the write operations of a read-only datastore
+var ErrReadOnly = types.ForbiddenErrorf("datastore is read-only")
+
+// readOnlyStore lets the read and watch operations through to the wrapped
+// store and fails all the others
+type readOnlyStore struct {
+	store.Store
+}
+
+// ReadOnly returns a view of ds which cannot modify its content, so that
+// the state of a running daemon can be inspected by another process
+func ReadOnly(ds DataStore) DataStore {
+	if _, ok := ds.KVStore().(*readOnlyStore); ok {
+		return ds
+	}
+	return &datastore{store: &readOnlyStore{ds.KVStore()}}
+}
+
+func (s *readOnlyStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return ErrReadOnly
+}
+
+func (s *readOnlyStore) Delete(key string) error {
+	return ErrReadOnly
+}
+
+func (s *readOnlyStore) DeleteTree(directory string) error {
+	return ErrReadOnly
+}
+
+func (s *readOnlyStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *readOnlyStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, ErrReadOnly
+}
+
+func (s *readOnlyStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, ErrReadOnly
+}
@@ -0,0 +1,136 @@
+package libnetwork
+
+import (
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/resolvconf"
+)
+
+// sandboxNetworkDNS returns the DNS search domains and options of the
+// networks the container of the endpoint is attached to in the sandbox, the
+// endpoint network included. The networks are taken in the order of the
+// sandbox endpoints, by priority then by network name, so that the result
+// does not depend on the order the networks were joined in.
+func (ep *endpoint) sandboxNetworkDNS(sboxKey string) (search, options []string) {
+	ep.Lock()
+	cid := ep.container.id
+	ctrlr := ep.network.ctrlr
+	ep.Unlock()
+
+	var others []*endpoint
+	ctrlr.Lock()
+	sData, ok := ctrlr.sandboxes[sboxKey]
+	ctrlr.Unlock()
+	if ok {
+		sData.Lock()
+		others = append(others, sData.endpoints...)
+		sData.Unlock()
+	}
+
+	eps := epHeap{ep}
+	for _, e := range others {
+		if e == ep {
+			continue
+		}
+		e.Lock()
+		sameContainer := e.container != nil && e.container.id == cid
+		e.Unlock()
+		if sameContainer {
+			eps = append(eps, e)
+		}
+	}
+	sort.Sort(eps)
+
+	for _, e := range eps {
+		e.Lock()
+		n := e.network
+		e.Unlock()
+
+		n.Lock()
+		search = append(search, n.dnsSearch...)
+		options = append(options, n.dnsOptions...)
+		n.Unlock()
+	}
+
+	return search, options
+}
+
+// rewriteSandboxDNS rewrites the resolv.conf of the container from one of
+// the endpoints it is still joined to in the sandbox, once an endpoint left
+// it, so that the search domains and options of the network left are
+// dropped. A file the user changed is left alone.
+func (c *controller) rewriteSandboxDNS(sboxKey, containerID string) {
+	c.Lock()
+	sData, ok := c.sandboxes[sboxKey]
+	c.Unlock()
+	if !ok {
+		return
+	}
+
+	sData.Lock()
+	others := append([]*endpoint(nil), sData.endpoints...)
+	sData.Unlock()
+
+	for _, ep := range others {
+		ep.Lock()
+		joined := ep.container != nil && ep.container.id == containerID
+		ep.Unlock()
+		if !joined {
+			continue
+		}
+
+		resolvConf, err := resolvconf.Get()
+		if err == nil {
+			err = ep.writeDNS(sboxKey, resolvConf, true)
+		}
+		if err != nil {
+			log.Warnf("Failed to rewrite the resolv.conf of container %s: %v", containerID, err)
+		}
+		return
+	}
+}
+
+// mergeDNSSearch returns the search domains of the container followed by
+// the ones of its networks, without duplicates
+func mergeDNSSearch(container, networks []string) []string {
+	var merged []string
+	seen := map[string]bool{}
+	for _, l := range [][]string{container, networks} {
+		for _, d := range l {
+			if !seen[d] {
+				seen[d] = true
+				merged = append(merged, d)
+			}
+		}
+	}
+	return merged
+}
+
+// mergeDNSOptions returns the resolver options of the container followed by
+// the ones of its networks. An option set more than once, like "ndots:n",
+// takes its first value: the container one, else the one of the network
+// coming first.
+func mergeDNSOptions(container, networks []string) []string {
+	var merged []string
+	seen := map[string]bool{}
+	for _, l := range [][]string{container, networks} {
+		for _, o := range l {
+			name := strings.SplitN(o, ":", 2)[0]
+			if !seen[name] {
+				seen[name] = true
+				merged = append(merged, o)
+			}
+		}
+	}
+	return merged
+}
+
+func stringList(l []interface{}) []string {
+	s := make([]string, 0, len(l))
+	for _, v := range l {
+		s = append(s, v.(string))
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package libnetwork
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/resolvconf"
+)
+
+// watchResolvConf checks the host resolv.conf every interval and pushes
+// its changes, such as the name servers of a VPN being connected, to the
+// containers, until the returned function is called
+func (c *controller) watchResolvConf(interval time.Duration) (stop func()) {
+	// The first check records the contents the containers were set up with
+	if _, _, err := resolvconf.GetIfChanged(); err != nil {
+		log.Debugf("Failed to read the host resolv.conf: %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				resolvConf, _, err := resolvconf.GetIfChanged()
+				if err != nil {
+					log.Debugf("Failed to read the host resolv.conf: %v", err)
+					continue
+				}
+				if resolvConf != nil {
+					c.reloadResolvConf(resolvConf)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stopCh) }) }
+}
+
+// reloadResolvConf rewrites the resolv.conf of the containers from the
+// contents of the host one, once per sandbox. The containers with name
+// servers of their own and the files changed by the user are left alone.
+func (c *controller) reloadResolvConf(resolvConf []byte) {
+	done := make(map[string]bool)
+	for _, nw := range c.Networks() {
+		for _, e := range nw.Endpoints() {
+			ep := e.(*endpoint)
+
+			// The join and the leave of the endpoint change its container
+			ep.joinLeaveStart()
+			ep.Lock()
+			var id, sboxKey string
+			var ownDNS bool
+			if ep.container != nil {
+				id = ep.container.id
+				sboxKey = ep.container.data.SandboxKey
+				ownDNS = len(ep.container.config.dnsList) > 0
+			}
+			ep.Unlock()
+
+			// The resolv.conf of a sandbox is written from any of its
+			// endpoints, with the search domains of all its networks
+			if sboxKey == "" || done[sboxKey] || ownDNS {
+				ep.joinLeaveEnd()
+				continue
+			}
+			done[sboxKey] = true
+			if err := ep.writeDNS(sboxKey, resolvConf, true); err != nil {
+				log.Warnf("Failed to reload the resolv.conf of container %s: %v", id, err)
+			}
+			ep.joinLeaveEnd()
+		}
+	}
+}
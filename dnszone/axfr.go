@@ -0,0 +1,243 @@
+package dnszone
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DNS wire format constants of RFC 1035 and RFC 5936
+const (
+	typeA    = 1
+	typeNS   = 2
+	typeSOA  = 6
+	typeAAAA = 28
+	typeAXFR = 252
+	classIN  = 1
+
+	rcodeFormErr  = 1
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+	flagResponse  = 1 << 15
+	flagAuthority = 1 << 10
+
+	headerLen = 12
+	// maxMessageLen bounds the messages of a transfer, the records beyond
+	// it go in the next message
+	maxMessageLen = 16384
+)
+
+var errMalformed = errors.New("malformed DNS query")
+
+// ZoneLookup returns the zone of the origin, a fully qualified lower case
+// domain name, nil if the server is not authoritative for it
+type ZoneLookup func(origin string) *Zone
+
+// ServeAXFR answers the zone transfer and SOA queries of the secondary DNS
+// servers connecting to the listener, over TCP. It returns when the
+// listener fails, once closed for instance.
+func ServeAXFR(l net.Listener, lookup ZoneLookup) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveConn(conn, lookup); err != nil && err != io.EOF {
+				logrus.Debugf("Zone transfer connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveConn answers the queries of a connection until it is closed
+func serveConn(rw io.ReadWriter, lookup ZoneLookup) error {
+	for {
+		var l uint16
+		if err := binary.Read(rw, binary.BigEndian, &l); err != nil {
+			return err
+		}
+		query := make([]byte, l)
+		if _, err := io.ReadFull(rw, query); err != nil {
+			return err
+		}
+		for _, msg := range answer(query, lookup) {
+			out := make([]byte, 2, 2+len(msg))
+			binary.BigEndian.PutUint16(out, uint16(len(msg)))
+			if _, err := rw.Write(append(out, msg...)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type question struct {
+	name  string
+	qtype uint16
+	raw   []byte
+}
+
+// answer returns the messages answering the query
+func answer(query []byte, lookup ZoneLookup) [][]byte {
+	if len(query) < headerLen {
+		return nil
+	}
+	id := binary.BigEndian.Uint16(query)
+	flags := binary.BigEndian.Uint16(query[2:])
+	if flags&flagResponse != 0 {
+		return nil
+	}
+	q, err := parseQuestion(query)
+	if err != nil {
+		return [][]byte{errorMessage(id, nil, rcodeFormErr)}
+	}
+	if opcode := (flags >> 11) & 0xF; opcode != 0 {
+		return [][]byte{errorMessage(id, q, rcodeNotImp)}
+	}
+	z := lookup(q.name)
+	if z == nil {
+		return [][]byte{errorMessage(id, q, rcodeRefused)}
+	}
+
+	switch q.qtype {
+	case typeSOA:
+		return [][]byte{message(id, q, [][]byte{z.soa()})}
+	case typeAXFR:
+		return z.transfer(id, q)
+	}
+	return [][]byte{errorMessage(id, q, rcodeNotImp)}
+}
+
+func parseQuestion(query []byte) (*question, error) {
+	if binary.BigEndian.Uint16(query[4:]) != 1 {
+		return nil, errMalformed
+	}
+	var labels []string
+	off := headerLen
+	for {
+		if off >= len(query) {
+			return nil, errMalformed
+		}
+		l := int(query[off])
+		off++
+		if l == 0 {
+			break
+		}
+		// The name of a query is never compressed
+		if l > 63 || off+l > len(query) {
+			return nil, errMalformed
+		}
+		labels = append(labels, strings.ToLower(string(query[off:off+l])))
+		off += l
+	}
+	if off+4 > len(query) {
+		return nil, errMalformed
+	}
+	return &question{
+		name:  strings.Join(labels, ".") + ".",
+		qtype: binary.BigEndian.Uint16(query[off:]),
+		raw:   query[headerLen : off+4],
+	}, nil
+}
+
+// transfer returns the messages of the transfer of the zone, the records
+// between two copies of the SOA record
+func (z *Zone) transfer(id uint16, q *question) [][]byte {
+	rrs := [][]byte{z.soa(), rr(z.Origin, typeNS, z.TTL, encodeName(z.nameserver()))}
+	for _, r := range z.Records {
+		name := strings.ToLower(r.Name) + "." + z.Origin
+		if ip4 := r.IP.To4(); ip4 != nil {
+			rrs = append(rrs, rr(name, typeA, z.TTL, ip4))
+		} else {
+			rrs = append(rrs, rr(name, typeAAAA, z.TTL, r.IP.To16()))
+		}
+	}
+	rrs = append(rrs, z.soa())
+
+	var (
+		msgs  [][]byte
+		batch [][]byte
+		size  int
+	)
+	for _, r := range rrs {
+		if len(batch) != 0 && size+len(r) > maxMessageLen {
+			msgs = append(msgs, message(id, q, batch))
+			batch, size = nil, 0
+			// The question is only echoed in the first message
+			q = nil
+		}
+		batch = append(batch, r)
+		size += len(r)
+	}
+	return append(msgs, message(id, q, batch))
+}
+
+func (z *Zone) soa() []byte {
+	rdata := append(encodeName(z.nameserver()), encodeName(z.hostmaster())...)
+	for _, v := range []uint32{z.Serial, soaRefresh, soaRetry, soaExpire, z.TTL} {
+		rdata = appendUint32(rdata, v)
+	}
+	return rr(z.Origin, typeSOA, z.TTL, rdata)
+}
+
+// message returns an authoritative response holding the records
+func message(id uint16, q *question, rrs [][]byte) []byte {
+	msg := header(id, q, 0, len(rrs))
+	for _, r := range rrs {
+		msg = append(msg, r...)
+	}
+	return msg
+}
+
+func errorMessage(id uint16, q *question, rcode uint16) []byte {
+	return header(id, q, rcode, 0)
+}
+
+func header(id uint16, q *question, rcode uint16, ancount int) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], flagResponse|flagAuthority|rcode)
+	if q != nil {
+		binary.BigEndian.PutUint16(msg[4:], 1)
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(ancount))
+	if q != nil {
+		msg = append(msg, q.raw...)
+	}
+	return msg
+}
+
+func rr(name string, rtype uint16, ttl uint32, rdata []byte) []byte {
+	b := encodeName(name)
+	b = appendUint16(b, rtype)
+	b = appendUint16(b, classIN)
+	b = appendUint32(b, ttl)
+	b = appendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// encodeName returns the uncompressed wire form of the domain name
+func encodeName(name string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if l == "" {
+			continue
+		}
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
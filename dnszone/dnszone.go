@@ -0,0 +1,112 @@
+// Package dnszone exports the service records of a network as a DNS zone,
+// written as a standard zone file or transferred to a secondary DNS server
+// with AXFR, so that the DNS of the site can mirror the container records.
+package dnszone
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Timers of the SOA record, the defaults of most secondaries
+const (
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 86400
+	// DefaultTTL is the time to live of the records of a zone without one
+	DefaultTTL = 60
+)
+
+// Record is an address record of a zone, its name relative to the origin
+type Record struct {
+	Name string
+	IP   net.IP
+}
+
+// Zone is a DNS zone holding the address records of a network
+type Zone struct {
+	// Origin is the domain of the zone, like "net1.example.com."
+	Origin string
+	// Serial is increased whenever the records change
+	Serial uint32
+	TTL    uint32
+	// Records are the address records, the ones whose name is not a valid
+	// domain name are left out
+	Records []Record
+}
+
+// NewZone returns the zone of the given origin holding the records, sorted
+// by name then address
+func NewZone(origin string, serial uint32, records []Record) *Zone {
+	z := &Zone{Origin: fqdn(origin), Serial: serial, TTL: DefaultTTL}
+	for _, r := range records {
+		if validName(r.Name) && r.IP != nil {
+			z.Records = append(z.Records, r)
+		}
+	}
+	sort.Sort(byName(z.Records))
+	return z
+}
+
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// validName tells whether the relative name is made of valid labels
+func validName(name string) bool {
+	if name == "" || len(name) > 200 {
+		return false
+	}
+	for _, l := range strings.Split(name, ".") {
+		if l == "" || len(l) > 63 || strings.ContainsAny(l, " \t\\;()\"@$") {
+			return false
+		}
+	}
+	return true
+}
+
+// nameserver returns the name of the primary server of the zone
+func (z *Zone) nameserver() string {
+	return "ns." + z.Origin
+}
+
+func (z *Zone) hostmaster() string {
+	return "hostmaster." + z.Origin
+}
+
+// WriteTo writes the zone in the zone file format of RFC 1035
+func (z *Zone) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "$ORIGIN %s\n", z.Origin)
+	fmt.Fprintf(&buf, "$TTL %d\n", z.TTL)
+	fmt.Fprintf(&buf, "@\tIN\tSOA\t%s %s %d %d %d %d %d\n", z.nameserver(), z.hostmaster(), z.Serial, soaRefresh, soaRetry, soaExpire, z.TTL)
+	fmt.Fprintf(&buf, "@\tIN\tNS\t%s\n", z.nameserver())
+	for _, r := range z.Records {
+		rtype := "A"
+		if r.IP.To4() == nil {
+			rtype = "AAAA"
+		}
+		fmt.Fprintf(&buf, "%s\tIN\t%s\t%s\n", strings.ToLower(r.Name), rtype, r.IP)
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+type byName []Record
+
+func (s byName) Len() int      { return len(s) }
+func (s byName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].IP.String() < s[j].IP.String()
+}
@@ -0,0 +1,120 @@
+package dnszone
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func testZone() *Zone {
+	return NewZone("net1.example.com", 7, []Record{
+		{Name: "web", IP: net.ParseIP("10.0.0.3")},
+		{Name: "db", IP: net.ParseIP("10.0.0.2")},
+		{Name: "db", IP: net.ParseIP("fd00::2")},
+		{Name: "bad name", IP: net.ParseIP("10.0.0.4")},
+	})
+}
+
+func TestWriteZoneFile(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := testZone().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `$ORIGIN net1.example.com.
+$TTL 60
+@	IN	SOA	ns.net1.example.com. hostmaster.net1.example.com. 7 3600 600 86400 60
+@	IN	NS	ns.net1.example.com.
+db	IN	A	10.0.0.2
+db	IN	AAAA	fd00::2
+web	IN	A	10.0.0.3
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected zone file:\n%s", buf.String())
+	}
+}
+
+func query(name string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg, 0x1234)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = append(msg, encodeName(name)...)
+	msg = appendUint16(msg, qtype)
+	return appendUint16(msg, classIN)
+}
+
+// readAnswer returns the rcode and the number of records of a message
+func readAnswer(t *testing.T, r io.Reader) (int, int) {
+	var l uint16
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(msg) != 0x1234 {
+		t.Fatal("Answer does not match the query id")
+	}
+	return int(binary.BigEndian.Uint16(msg[2:]) & 0xF), int(binary.BigEndian.Uint16(msg[6:]))
+}
+
+func TestZoneTransfer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveConn(server, func(origin string) *Zone {
+		if origin == "net1.example.com." {
+			return testZone()
+		}
+		return nil
+	})
+
+	for _, c := range []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		records int
+	}{
+		// SOA, NS, the three addresses and the closing SOA
+		{"NET1.example.com.", typeAXFR, 0, 6},
+		{"net1.example.com.", typeSOA, 0, 1},
+		{"net2.example.com.", typeAXFR, rcodeRefused, 0},
+		{"net1.example.com.", typeA, rcodeNotImp, 0},
+	} {
+		q := query(c.name, c.qtype)
+		if _, err := client.Write(append([]byte{0, byte(len(q))}, q...)); err != nil {
+			t.Fatal(err)
+		}
+		if rcode, records := readAnswer(t, client); rcode != c.rcode || records != c.records {
+			t.Fatalf("Query of type %d for %s answered with rcode %d and %d records", c.qtype, c.name, rcode, records)
+		}
+	}
+}
+
+func TestLargeZoneTransfer(t *testing.T) {
+	var recs []Record
+	for i := 0; i < 2000; i++ {
+		recs = append(recs, Record{Name: "endpoint-with-a-long-name-" + string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676)), IP: net.IPv4(10, 0, byte(i>>8), byte(i))})
+	}
+	z := NewZone("net1.example.com", 1, recs)
+	q, _ := parseQuestion(query("net1.example.com.", typeAXFR))
+
+	msgs := z.transfer(1, q)
+	if len(msgs) < 2 {
+		t.Fatalf("Expected the transfer to be split, got %d messages", len(msgs))
+	}
+	total := 0
+	for i, m := range msgs {
+		if len(m) > maxMessageLen+headerLen+len(q.raw) {
+			t.Fatalf("Message %d of %d bytes exceeds the limit", i, len(m))
+		}
+		if qd := binary.BigEndian.Uint16(m[4:]); (i == 0) != (qd == 1) {
+			t.Fatalf("Message %d has %d questions", i, qd)
+		}
+		total += int(binary.BigEndian.Uint16(m[6:]))
+	}
+	if total != len(recs)+3 {
+		t.Fatalf("Expected %d records transferred, got %d", len(recs)+3, total)
+	}
+}
@@ -0,0 +1,185 @@
+package libnetwork
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+type builtinDriver struct {
+	networkType string
+	init        func(driverapi.DriverCallback) error
+}
+
+// unloadedDriver stands in for the driver of the networks of a type while
+// it is unregistered, failing the operations on them
+type unloadedDriver struct {
+	networkType string
+}
+
+func (d *unloadedDriver) Config(options map[string]interface{}) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) CreateNetwork(ctx context.Context, nid types.UUID, options map[string]interface{}) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
+	return nil, ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) Leave(ctx context.Context, nid, eid types.UUID) error {
+	return ErrDriverUnloaded(d.networkType)
+}
+
+func (d *unloadedDriver) Type() string {
+	return d.networkType
+}
+
+// networksOfType returns the networks of the network type
+func (c *controller) networksOfType(networkType string) []*network {
+	var nws []*network
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		n.Lock()
+		if n.networkType == networkType {
+			nws = append(nws, n)
+		}
+		n.Unlock()
+	}
+	return nws
+}
+
+func (c *controller) UnregisterDriver(ctx context.Context, networkType string) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	if err := c.authorize(ctx, AuthzRequest{Operation: AuthzDriverReload, NetworkType: networkType}); err != nil {
+		return err
+	}
+	return c.unregisterDriver(networkType)
+}
+
+// unregisterDriver removes the driver of the network type, quiescing its
+// networks, and has it release its resources
+func (c *controller) unregisterDriver(networkType string) error {
+	c.Lock()
+	dd, ok := c.drivers[networkType]
+	if !ok {
+		c.Unlock()
+		return NetworkTypeError(networkType)
+	}
+	delete(c.drivers, networkType)
+	c.unloaded[networkType] = dd
+	c.Unlock()
+
+	stand := &unloadedDriver{networkType: networkType}
+	for _, n := range c.networksOfType(networkType) {
+		n.Lock()
+		n.driver = stand
+		n.Unlock()
+	}
+
+	if u, ok := dd.driver.(driverapi.Unloader); ok {
+		u.Unload()
+	}
+	log.Infof("Driver %s unregistered", networkType)
+	return nil
+}
+
+// resumeNetworks hands the networks of the type quiesced by the unregistration
+// of their driver over to the driver registered for it. The networks which
+// were created in the driver unregistered are created again in this one; a
+// failure is logged, and the creation is tried again with the next endpoint
+// of the network.
+func (c *controller) resumeNetworks(networkType string, d driverapi.Driver) {
+	for _, n := range c.networksOfType(networkType) {
+		n.Lock()
+		if _, ok := n.driver.(*unloadedDriver); !ok {
+			n.Unlock()
+			continue
+		}
+		n.driver = d
+		recreate := n.materialized
+		n.materialized = false
+		n.Unlock()
+
+		if !recreate {
+			continue
+		}
+		if err := n.materialize(context.Background()); err != nil {
+			log.Warnf("Failed to create network %s again in driver %s: %v", n.Name(), networkType, err)
+		}
+	}
+}
+
+func (c *controller) ReloadDriver(ctx context.Context, networkType string) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	if err := c.authorize(ctx, AuthzRequest{Operation: AuthzDriverReload, NetworkType: networkType}); err != nil {
+		return err
+	}
+
+	// A driver whose reload failed stays unregistered, its networks
+	// quiesced, and is reloaded from there
+	c.Lock()
+	dd, registered := c.drivers[networkType]
+	if !registered {
+		var ok bool
+		if dd, ok = c.unloaded[networkType]; !ok {
+			c.Unlock()
+			return NetworkTypeError(networkType)
+		}
+	}
+	c.Unlock()
+
+	// A driver not passed the endpoints back on registration would start
+	// over without them, and clean up what it programmed for them, as the
+	// bridge driver does with its iptables chains
+	if _, ok := dd.driver.(driverapi.Restorer); !ok && len(c.localEndpointRecords(networkType)) != 0 {
+		return ErrDriverNotReloadable(networkType)
+	}
+
+	if registered {
+		if err := c.unregisterDriver(networkType); err != nil {
+			return err
+		}
+	}
+
+	err := c.initDriver(networkType)
+	if err != nil {
+		log.Warnf("Failed to reload driver %s, its networks stay quiesced until it is reloaded: %v", networkType, err)
+	}
+	return err
+}
+
+// initDriver initializes the builtin driver of the network type, or
+// connects to the plugin serving it again
+func (c *controller) initDriver(networkType string) error {
+	for _, b := range builtinDrivers {
+		if b.networkType == networkType {
+			return b.init(c)
+		}
+	}
+	return c.activatePlugin(networkType)
+}
@@ -1,8 +1,13 @@
 package driverapi
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"os"
 
+	"github.com/docker/libnetwork/capture"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -10,6 +15,9 @@ import (
 const NetworkPluginEndpointType = "NetworkDriver"
 
 // Driver is an interface that every plugin driver needs to implement.
+// The methods changing the state of the driver are passed the context of
+// the request, which drivers doing blocking or remote work should honor
+// to give up once it is canceled or its deadline expires.
 type Driver interface {
 	// Push driver specific config to the driver
 	Config(options map[string]interface{}) error
@@ -17,36 +25,305 @@ type Driver interface {
 	// CreateNetwork invokes the driver method to create a network passing
 	// the network id and network specific config. The config mechanism will
 	// eventually be replaced with labels which are yet to be introduced.
-	CreateNetwork(nid types.UUID, options map[string]interface{}) error
+	CreateNetwork(ctx context.Context, nid types.UUID, options map[string]interface{}) error
 
 	// DeleteNetwork invokes the driver method to delete network passing
 	// the network id.
-	DeleteNetwork(nid types.UUID) error
+	DeleteNetwork(ctx context.Context, nid types.UUID) error
 
 	// CreateEndpoint invokes the driver method to create an endpoint
 	// passing the network id, endpoint id endpoint information and driver
 	// specific config. The endpoint information can be either consumed by
 	// the driver or populated by the driver. The config mechanism will
 	// eventually be replaced with labels which are yet to be introduced.
-	CreateEndpoint(nid, eid types.UUID, epInfo EndpointInfo, options map[string]interface{}) error
+	CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo EndpointInfo, options map[string]interface{}) error
 
 	// DeleteEndpoint invokes the driver method to delete an endpoint
 	// passing the network id and endpoint id.
-	DeleteEndpoint(nid, eid types.UUID) error
+	DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error
 
 	// EndpointOperInfo retrieves from the driver the operational data related to the specified endpoint
 	EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error)
 
 	// Join method is invoked when a Sandbox is attached to an endpoint.
-	Join(nid, eid types.UUID, sboxKey string, jinfo JoinInfo, options map[string]interface{}) error
+	Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo JoinInfo, options map[string]interface{}) error
 
 	// Leave method is invoked when a Sandbox detaches from an endpoint.
-	Leave(nid, eid types.UUID) error
+	Leave(ctx context.Context, nid, eid types.UUID) error
 
 	// Type returns the the type of this driver, the network type this driver manages
 	Type() string
 }
 
+// AddressChanger is an optional interface implemented by the drivers which
+// can move an existing endpoint to a different IPv4 address without
+// recreating it.
+type AddressChanger interface {
+	// ChangeEndpointAddress releases the IPv4 address of the endpoint and
+	// assigns the requested one, or a newly allocated one if ip is nil.
+	// Any host side state referring to the old address, like port mappings,
+	// is updated. The new address is returned.
+	ChangeEndpointAddress(nid, eid types.UUID, ip net.IP) (*net.IPNet, error)
+}
+
+// Migrator is an optional interface implemented by the drivers whose
+// endpoints can move between the hosts sharing a network while keeping
+// their addresses.
+type Migrator interface {
+	// MigrateEndpoint joins the sandbox to the endpoint like Join, taking
+	// over the endpoint joined on another host. The addresses of the
+	// endpoint stay allocated and the other hosts are pointed to this one.
+	MigrateEndpoint(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo JoinInfo, options map[string]interface{}) error
+
+	// ReleaseEndpoint detaches the endpoint from its sandbox on the host it
+	// moved away from. Unlike Leave, it neither releases the addresses of
+	// the endpoint nor withdraws it from the other hosts.
+	ReleaseEndpoint(ctx context.Context, nid, eid types.UUID) error
+}
+
+// Exporter is an optional interface implemented by the drivers whose state
+// can be carried over to another host, to recover from the loss of a host
+// or to replace it.
+type Exporter interface {
+	// Export returns the networks and endpoints of the driver, with their
+	// addresses and port allocations, as a portable JSON bundle.
+	Export() ([]byte, error)
+
+	// Import recreates the networks and endpoints of a bundle returned by
+	// Export. Either all of them are restored or none is.
+	Import(ctx context.Context, bundle []byte) error
+}
+
+// RestoredInterface is an interface of a restored endpoint
+type RestoredInterface struct {
+	ID          int
+	MacAddress  net.HardwareAddr
+	Address     net.IPNet
+	AddressIPv6 net.IPNet
+}
+
+// RestoredEndpoint is the record libnetwork keeps of an endpoint of a
+// network of the driver.
+type RestoredEndpoint struct {
+	NetworkID  types.UUID
+	EndpointID types.UUID
+	Interfaces []RestoredInterface
+	// Options are the driver options the endpoint was created with
+	Options map[string]interface{}
+	// ContainerID is the container the endpoint is joined to, if any
+	ContainerID string
+}
+
+// Restorer is an optional interface implemented by the drivers which do not
+// keep their state across a restart of the daemon, like the plugins.
+type Restorer interface {
+	// RestoreEndpoints is passed the endpoints of the networks of the driver
+	// when it is registered, for it to reconcile its own state with them.
+	RestoreEndpoints(ctx context.Context, endpoints []RestoredEndpoint) error
+}
+
+// Unloader is an optional interface implemented by the drivers holding
+// resources beyond their networks, like background routines, to release
+// when they are unregistered from the controller.
+type Unloader interface {
+	// Unload releases the resources of the driver, which is not used after.
+	Unload()
+}
+
+// The availabilities of a cluster member
+const (
+	// NodeActive is the availability of a member taking new endpoints
+	NodeActive = "active"
+	// NodeDrain is the availability of a member keeping its endpoints but
+	// taking no new ones
+	NodeDrain = "drain"
+)
+
+// ClusterMember is a host of the cluster a driver gossips with
+type ClusterMember struct {
+	Name string
+	Addr net.IP
+	Port uint16
+	// Status is the health of the member as the host sees it: "alive",
+	// "leaving", "left" or "failed"
+	Status string
+	// Availability is the availability the member announces, NodeActive
+	// or NodeDrain
+	Availability string
+	// Local is set on the member of the host
+	Local bool
+}
+
+// ClusterManager is an optional interface implemented by the drivers which
+// gossip with the other hosts of a cluster.
+type ClusterManager interface {
+	// ClusterMembers returns the members of the cluster known to the host,
+	// the host included.
+	ClusterMembers() ([]ClusterMember, error)
+	// RemoveClusterMember removes a failed member from the cluster at once,
+	// rather than once the other members give up on it.
+	RemoveClusterMember(name string) error
+	// SetNodeAvailability sets the availability of the member, which
+	// announces it to the cluster.
+	SetNodeAvailability(name, availability string) error
+}
+
+// ExternalConnectivitySetter is an optional interface implemented by the
+// drivers which program host side state giving endpoints access from and to
+// the outside, like port mappings.
+type ExternalConnectivitySetter interface {
+	// SetExternalConnectivity removes the external access of the endpoint
+	// if enable is false, and restores it as it was if enable is true.
+	SetExternalConnectivity(nid, eid types.UUID, enable bool) error
+}
+
+// PoolStatusReporter is an optional interface implemented by the drivers
+// which allocate the endpoint addresses from pools they manage.
+type PoolStatusReporter interface {
+	// PoolStatus returns the usage of the address pools of the network.
+	// The pool consumers are reported by endpoint id.
+	PoolStatus(nid types.UUID) ([]*ipam.PoolStatus, error)
+}
+
+// AddressAuditor is an optional interface implemented by the drivers which
+// allocate the endpoint addresses from pools they manage, so that their
+// allocations can be cross-checked with the endpoints.
+type AddressAuditor interface {
+	// AllocatedAddresses returns the endpoint addresses allocated on the
+	// network, mapped to the id of the endpoint holding them, empty if no
+	// endpoint does. The addresses of the network itself, like its
+	// gateway, are left out.
+	AllocatedAddresses(nid types.UUID) (map[string]types.UUID, error)
+	// ReleaseAddress gives the address back to the pools of the network,
+	// unless an endpoint holds it.
+	ReleaseAddress(nid types.UUID, ip net.IP) error
+}
+
+// ExternalEndpoint is an interface attached to a network outside of its
+// driver, which the driver tracks without managing it
+type ExternalEndpoint struct {
+	ID types.UUID
+	// Name is the name of the interface
+	Name       string
+	MacAddress net.HardwareAddr
+	// Addresses are the addresses of the interface in the network
+	Addresses []*net.IPNet
+}
+
+// ExternalEndpointReporter is an optional interface implemented by the
+// drivers which adopt the interfaces attached to their networks outside of
+// them, for the controller to record them as external endpoints.
+type ExternalEndpointReporter interface {
+	// ExternalEndpoints returns the interfaces the driver adopted on the
+	// network.
+	ExternalEndpoints(nid types.UUID) ([]ExternalEndpoint, error)
+}
+
+// PacketCapturer is an optional interface implemented by the drivers which
+// can capture the traffic of an endpoint from the host.
+type PacketCapturer interface {
+	// StartCapture starts capturing the packets sent and received by the
+	// endpoint, within the bounds set by the options.
+	StartCapture(nid, eid types.UUID, opts capture.Options) (*capture.Capture, error)
+}
+
+// Plan describes what a driver would program on the host for a network or
+// an endpoint.
+type Plan struct {
+	// Devices are the network devices which would be created
+	Devices []string
+	// Addresses are the addresses which would be assigned
+	Addresses []*net.IPNet
+	// Rules are the firewall rules which would be installed, in the
+	// iptables command line syntax. The IPv6 rules are full ip6tables
+	// command lines.
+	Rules []string
+}
+
+// Planner is an optional interface implemented by the drivers which can
+// validate a network or an endpoint configuration and report what they
+// would program for it, without changing anything on the host.
+type Planner interface {
+	// PlanNetwork validates the network options and returns what
+	// CreateNetwork would program for them.
+	PlanNetwork(nid types.UUID, options map[string]interface{}) (*Plan, error)
+
+	// PlanEndpoint validates the endpoint options and returns what
+	// CreateEndpoint would program for them in the existing network.
+	PlanEndpoint(nid, eid types.UUID, options map[string]interface{}) (*Plan, error)
+}
+
+// ProgrammedDevice is a network device a driver programmed
+type ProgrammedDevice struct {
+	Name string
+	Kind string
+	// Master is the device the device is attached to, if any
+	Master    string       `json:",omitempty"`
+	Addresses []*net.IPNet `json:",omitempty"`
+}
+
+// ProgrammedRoute is a route a driver programmed
+type ProgrammedRoute struct {
+	Destination *net.IPNet
+	Gateway     net.IP `json:",omitempty"`
+	Device      string
+	// Table is the routing table of the route, the main one if 0
+	Table int `json:",omitempty"`
+}
+
+// ProgrammedRule is a firewall rule a driver programmed
+type ProgrammedRule struct {
+	Table string
+	Chain string
+	Args  []string
+	// IPv6 rules are programmed with ip6tables
+	IPv6 bool `json:",omitempty"`
+}
+
+// ProgrammedState is the full set of devices, routes and rules a driver
+// believes it programmed on the host for a network and its endpoints.
+type ProgrammedState struct {
+	Devices []ProgrammedDevice `json:",omitempty"`
+	Routes  []ProgrammedRoute  `json:",omitempty"`
+	Rules   []ProgrammedRule   `json:",omitempty"`
+}
+
+// The kinds of the objects of a programmed state
+const (
+	DriftDevice  = "device"
+	DriftAddress = "address"
+	DriftRoute   = "route"
+	DriftRule    = "rule"
+)
+
+// Drift is a difference between the programmed state of a network and the
+// state of the host
+type Drift struct {
+	// Kind is the kind of the drifted object, one of the Drift constants
+	Kind string
+	// Object identifies the drifted object
+	Object string
+	// Problem tells how the object drifted, like it missing
+	Problem string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Kind, d.Object, d.Problem)
+}
+
+// StateReporter is an optional interface implemented by the drivers which
+// can report what they programmed on the host for a network, and find what
+// changed since outside of them.
+type StateReporter interface {
+	// ProgrammedState returns what the driver believes it programmed for
+	// the network and its endpoints.
+	ProgrammedState(nid types.UUID) (*ProgrammedState, error)
+
+	// VerifyState compares the programmed state of the network with the
+	// kernel state and returns the differences, none if they match.
+	VerifyState(nid types.UUID) ([]Drift, error)
+}
+
 // EndpointInfo provides a go interface to fetch or populate endpoint assigned network resources.
 type EndpointInfo interface {
 	// Interfaces returns a list of interfaces bound to the endpoint.
@@ -63,6 +340,15 @@ type EndpointInfo interface {
 	AddInterface(ID int, mac net.HardwareAddr, ipv4 net.IPNet, ipv6 net.IPNet) error
 }
 
+// SecondaryAddressAdder is an optional interface implemented by the
+// EndpointInfo passed to CreateEndpoint, for the drivers to record the
+// addresses they assigned to an interface besides its primary ones.
+type SecondaryAddressAdder interface {
+	// AddSecondaryAddress adds an IPv4 or IPv6 address to the interface
+	// previously added with AddInterface under the same ID.
+	AddSecondaryAddress(ID int, addr net.IPNet) error
+}
+
 // InterfaceInfo provides a go interface for drivers to retrive
 // network information to interface resources.
 type InterfaceInfo interface {
@@ -113,6 +399,20 @@ type JoinInfo interface {
 
 	// SetResolvConfPath sets the overriding /etc/resolv.conf path to use for the container.
 	SetResolvConfPath(string) error
+
+	// AddBoundSocket hands over a host socket bound for the port binding of
+	// the endpoint, to be passed to the container runtime. The ownership of
+	// the file is transferred.
+	AddBoundSocket(binding types.PortBinding, f *os.File) error
+}
+
+// InterfaceAddressSetter is an optional interface implemented by the
+// JoinInfo passed to Join, for the drivers whose capability says they assign
+// the addresses of the interfaces to report them once they know them.
+type InterfaceAddressSetter interface {
+	// SetInterfaceAddress sets the IPv4 and IPv6 addresses of the interface
+	// added by CreateEndpoint under the same ID. Either may be empty.
+	SetInterfaceAddress(ID int, ipv4 net.IPNet, ipv6 net.IPNet) error
 }
 
 // DriverCallback provides a Callback interface for Drivers into LibNetwork
@@ -121,6 +421,24 @@ type DriverCallback interface {
 	RegisterDriver(name string, driver Driver, capability Capability) error
 }
 
+// NetworkEventNotifier is an optional interface implemented by the
+// DriverCallback, for the drivers to report the events of their networks
+// which happen outside of the calls made to them, like the takeover of a
+// shared gateway.
+type NetworkEventNotifier interface {
+	// NotifyNetworkEvent reports the event of the network, described by
+	// its attributes
+	NotifyNetworkEvent(nid types.UUID, event string, attributes map[string]string)
+}
+
+// ReadOnlyChecker is an optional interface implemented by the
+// DriverCallback, for the drivers to find out that the controller only
+// inspects the state, in which case they leave the host untouched.
+type ReadOnlyChecker interface {
+	// IsReadOnly tells whether the controller only inspects the state
+	IsReadOnly() bool
+}
+
 // Scope indicates the drivers scope capability
 type Scope int
 
@@ -134,4 +452,9 @@ const (
 // Capability represents the high level capabilities of the drivers which libnetwork can make use of
 type Capability struct {
 	Scope Scope
+	// DriverAssignsAddress is set by the drivers which only learn the
+	// addresses of the endpoint interfaces once the interfaces are created,
+	// like the DHCP backed ones. They may add the interfaces without
+	// address in CreateEndpoint and report the addresses on Join.
+	DriverAssignsAddress bool
 }
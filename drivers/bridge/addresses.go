@@ -0,0 +1,106 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/types"
+)
+
+// AllocatedAddresses returns the addresses allocated from the pools of the
+// network, mapped to the endpoint holding them. The bridge and gateway
+// addresses, and the ones of the interfaces attached to the bridge outside
+// of the driver, are left out.
+func (d *driver) AllocatedAddresses(nid types.UUID) (map[string]types.UUID, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	config := n.config
+	pools := []*net.IPNet{n.bridge.bridgeIPv4}
+	if config.EnableIPv6 {
+		v6 := n.bridge.bridgeIPv6
+		if config.FixedCIDRv6 != nil {
+			v6 = config.FixedCIDRv6
+		}
+		pools = append(pools, v6)
+	}
+	own := []net.IP{n.bridge.bridgeIPv4.IP, n.bridge.gatewayIPv4, n.bridge.gatewayIPv6}
+	n.Unlock()
+
+	addrs := make(map[string]types.UUID)
+	for _, pool := range pools {
+		ips, err := ipAllocator.AllocatedIPs(pool)
+		if err == ipallocator.ErrNetworkNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addrs[ip.String()] = ""
+		}
+	}
+	for _, ip := range own {
+		if ip != nil {
+			delete(addrs, ip.String())
+		}
+	}
+
+	for eid, ep := range n.endpoints.snapshot() {
+		for ip := range endpointAddresses(n, ep) {
+			if _, ok := addrs[ip]; !ok {
+				continue
+			}
+			if ep.external {
+				delete(addrs, ip)
+			} else {
+				addrs[ip] = eid
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+// ReleaseAddress gives the address back to the pool of the network it was
+// allocated from, unless an endpoint holds it.
+func (d *driver) ReleaseAddress(nid types.UUID, ip net.IP) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	for eid, ep := range n.endpoints.snapshot() {
+		if _, ok := endpointAddresses(n, ep)[ip.String()]; ok {
+			return types.ForbiddenErrorf("address %s is held by endpoint %s", ip, eid)
+		}
+	}
+
+	n.Lock()
+	config := n.config
+	i := n.bridge
+	n.Unlock()
+
+	pool, err := secondaryPool(config, i, ip)
+	if err != nil {
+		return err
+	}
+	return ipAllocator.ReleaseIP(pool, ip)
+}
+
+// endpointAddresses returns the set of the addresses of the endpoint
+func endpointAddresses(n *bridgeNetwork, ep *bridgeEndpoint) map[string]struct{} {
+	n.Lock()
+	defer n.Unlock()
+
+	addrs := make(map[string]struct{})
+	for _, addr := range append([]*net.IPNet{ep.addr, ep.addrv6}, ep.secondary...) {
+		if addr != nil && addr.IP != nil {
+			addrs[addr.IP.String()] = struct{}{}
+		}
+	}
+	return addrs
+}
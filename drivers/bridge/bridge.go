@@ -1,21 +1,32 @@
 package bridge
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"os/exec"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/capture"
+	libnetconfig "github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/macallocator"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/netwatch"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/types"
+	"github.com/docker/libnetwork/vrrp"
 	"github.com/vishvananda/netlink"
 )
 
@@ -35,6 +46,9 @@ var (
 // configuration info for the "bridge" driver.
 type configuration struct {
 	EnableIPForwarding bool
+	// MacRanges is a comma separated list of the MAC ranges the endpoint
+	// addresses are allocated from, instead of being derived from their IP
+	MacRanges string
 }
 
 // networkConfiguration for network specific configuration
@@ -53,6 +67,50 @@ type networkConfiguration struct {
 	DefaultBindingIP      net.IP
 	AllowNonDefaultBridge bool
 	EnableUserlandProxy   bool
+	ChainPrefix           string
+	// ICMPPolicy and ICMPv6Policy accept or drop the ICMP traffic between
+	// the interfaces of the bridge regardless of EnableICC. The traffic
+	// follows EnableICC when they are empty.
+	ICMPPolicy   string
+	ICMPv6Policy string
+	// NDPProxyInterface is the host interface on which the neighbor
+	// solicitations for the endpoint IPv6 addresses are answered, for a
+	// router on that link to reach the containers through the host
+	NDPProxyInterface string
+	// VethNamePattern names the host side veth interfaces of the endpoints.
+	// It is a prefix completed with random characters, or contains {id}
+	// which is replaced by the endpoint ID.
+	VethNamePattern string
+	// SNATPool lists the host addresses the outbound traffic of the
+	// endpoints can be translated to, in place of the address masquerading
+	// picks. SNATAddress is the one of the pool used for the endpoints
+	// which do not pick one, they are masqueraded without it.
+	SNATPool    []net.IP
+	SNATAddress net.IP
+	// RouteTable is the kernel routing table the routes of the network are
+	// installed in, looked up by the traffic coming from the network, so
+	// that it follows its own egress policy. RouteRulePriority is the
+	// priority of the rule selecting the table, picked by the kernel when 0.
+	RouteTable        int
+	RouteRulePriority int
+	// VRRPRouterID makes the host take part in the VRRP virtual router of
+	// that id on the bridge, for the hosts sharing the link of the bridge
+	// to elect the one holding DefaultGatewayIPv4. VRRPPriority is the
+	// priority of the host in the election, vrrp.DefaultPriority when 0.
+	VRRPRouterID int
+	VRRPPriority int
+	// EnableSTP turns the spanning tree protocol on for the bridge, whose
+	// ports then wait ForwardDelay before forwarding. AgeingTime is how
+	// long the bridge keeps the addresses it learnt. The kernel defaults
+	// apply when they are 0. DisableMulticastSnooping makes the bridge
+	// flood the multicast traffic to all its ports.
+	EnableSTP                bool
+	ForwardDelay             time.Duration
+	AgeingTime               time.Duration
+	DisableMulticastSnooping bool
+	// DSCP marks the traffic the network forwards out of the bridge with
+	// that differentiated services code point, none when 0
+	DSCP int
 }
 
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
@@ -60,6 +118,34 @@ type endpointConfiguration struct {
 	MacAddress   net.HardwareAddr
 	PortBindings []types.PortBinding
 	ExposedPorts []types.TransportPort
+	Mirror       *types.MirrorConfig
+	// VethName is the name of the host side veth interface, and Mtu the
+	// MTU of the endpoint interfaces overriding the one of the network
+	VethName string
+	Mtu      int
+	// SNATAddress is the address of the SNAT pool of the network the
+	// outbound traffic of the endpoint is translated to
+	SNATAddress net.IP
+	// ConnectionLimit bounds the concurrent connections to each published
+	// TCP port, SYNRateLimit the new ones per second. Zero is no limit.
+	ConnectionLimit int
+	SYNRateLimit    int
+	// DSCP marks the outbound traffic of the endpoint, overriding the mark
+	// of the network, none when 0
+	DSCP int
+	// SecondaryAddresses are the IPv4 and IPv6 addresses of the subnets of
+	// the network the endpoint gets besides its primary ones
+	SecondaryAddresses []net.IP
+	// DeferPortBinding leaves the port bindings withdrawn on creation, they
+	// are bound when the external connectivity is enabled
+	DeferPortBinding bool
+	// PerfProfile is the performance profile the veth pair is tuned for,
+	// PerfCPUs the CPU mask its queues are steered to
+	PerfProfile string
+	PerfCPUs    string
+	// ipv6Address is the IPv6 address an imported endpoint had on the old
+	// host, requested instead of the one derived from its MAC address
+	ipv6Address net.IP
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -71,20 +157,41 @@ type containerConfiguration struct {
 type bridgeEndpoint struct {
 	id              types.UUID
 	srcName         string
+	hostName        string // Name of the host side veth interface
 	addr            *net.IPNet
 	addrv6          *net.IPNet
+	secondary       []*net.IPNet
 	macAddress      net.HardwareAddr
 	config          *endpointConfiguration // User specified parameters
 	containerConfig *containerConfiguration
 	portMapping     []types.PortBinding // Operation port bindings
+	// withdrawnPortMapping holds the operational port bindings removed
+	// while the endpoint external connectivity is disabled
+	withdrawnPortMapping []types.PortBinding
+	// external is set on the interfaces attached to the bridge outside of
+	// the driver, which are tracked but never managed
+	external bool
 }
 
 type bridgeNetwork struct {
 	id         types.UUID
 	bridge     *bridgeInterface // The bridge's L3 interface
 	config     *networkConfiguration
-	endpoints  map[types.UUID]*bridgeEndpoint // key: endpoint id
+	endpoints  *endpointRegistry
 	portMapper *portmapper.PortMapper
+	// degraded is the reason the bridge device can no longer be used,
+	// after it was removed or altered outside of the driver
+	degraded  string
+	stopWatch func()
+	// ifaceWatches cancel the watches of the host interfaces the port
+	// bindings are published on, by interface name
+	ifaceWatches map[string]func()
+	// speaker holds the gateway address of the network for the hosts
+	// sharing it, when VRRP is configured
+	speaker *vrrp.Speaker
+	// external is set when the bridge was created outside of the driver,
+	// which then never deletes it
+	external bool
 	sync.Mutex
 }
 
@@ -92,6 +199,12 @@ type driver struct {
 	config   *configuration
 	network  *bridgeNetwork
 	networks map[types.UUID]*bridgeNetwork
+	watcher  *netwatch.Watcher
+	// notifier reports the network events to the controller, when it
+	// supports them
+	notifier driverapi.NetworkEventNotifier
+	// macAllocator is set when MAC ranges are configured
+	macAllocator *macallocator.Allocator
 	sync.Mutex
 }
 
@@ -104,21 +217,63 @@ func newDriver() driverapi.Driver {
 	return &driver{networks: map[types.UUID]*bridgeNetwork{}}
 }
 
-// Init registers a new instance of bridge driver
+// Init registers a new instance of bridge driver. The host is left as is
+// for a read-only controller, whose daemon may run next to the one owning
+// the DOCKER chain.
 func Init(dc driverapi.DriverCallback) error {
-	// try to modprobe bridge first
-	// see gh#12177
-	if out, err := exec.Command("modprobe", "-va", "bridge", "nf_nat", "br_netfilter").CombinedOutput(); err != nil {
-		logrus.Warnf("Running modprobe bridge nf_nat br_netfilter failed with message: %s, error: %v", out, err)
-	}
-	if err := iptables.RemoveExistingChain(DockerChain, iptables.Nat); err != nil {
-		logrus.Warnf("Failed to remove existing iptables entries in %s : %v", DockerChain, err)
+	if ro, ok := dc.(driverapi.ReadOnlyChecker); !ok || !ro.IsReadOnly() {
+		// try to modprobe bridge first
+		// see gh#12177
+		if out, err := exec.Command("modprobe", "-va", "bridge", "nf_nat", "br_netfilter").CombinedOutput(); err != nil {
+			logrus.Warnf("Running modprobe bridge nf_nat br_netfilter failed with message: %s, error: %v", out, err)
+		}
+		if err := iptables.RemoveExistingChain(DockerChain, iptables.Nat); err != nil {
+			logrus.Warnf("Failed to remove existing iptables entries in %s : %v", DockerChain, err)
+		}
 	}
 
 	c := driverapi.Capability{
 		Scope: driverapi.LocalScope,
 	}
-	return dc.RegisterDriver(networkType, newDriver(), c)
+	d := newDriver().(*driver)
+	d.notifier, _ = dc.(driverapi.NetworkEventNotifier)
+	return dc.RegisterDriver(networkType, d, c)
+}
+
+// fromMap retrieves the driver configuration from the map form.
+func (c *configuration) fromMap(data map[string]interface{}) error {
+	if i, ok := data["EnableIPForwarding"]; ok && i != nil {
+		switch v := i.(type) {
+		case bool:
+			c.EnableIPForwarding = v
+		case string:
+			var err error
+			if c.EnableIPForwarding, err = strconv.ParseBool(v); err != nil {
+				return types.BadRequestErrorf("failed to parse EnableIPForwarding value: %s", err.Error())
+			}
+		default:
+			return types.BadRequestErrorf("invalid type for EnableIPForwarding value")
+		}
+	}
+
+	if i, ok := data["MacRanges"]; ok && i != nil {
+		var ok bool
+		if c.MacRanges, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for MacRanges value")
+		}
+	}
+
+	return nil
+}
+
+// Validate performs a static validation on the driver configuration parameters.
+func (c *configuration) Validate() error {
+	if c.MacRanges != "" {
+		if _, err := macallocator.ParseRanges(c.MacRanges); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Validate performs a static validation on the network configuration parameters.
@@ -158,6 +313,51 @@ func (c *networkConfiguration) Validate() error {
 		}
 	}
 
+	if c.ChainPrefix != "" {
+		if err := validateChainPrefix(c.ChainPrefix); err != nil {
+			return err
+		}
+	}
+
+	if c.VethNamePattern != "" {
+		if err := validateVethNamePattern(c.VethNamePattern); err != nil {
+			return err
+		}
+	}
+
+	if c.NDPProxyInterface != "" && !c.EnableIPv6 {
+		return types.BadRequestErrorf("NDP proxy interface %s requires IPv6 to be enabled", c.NDPProxyInterface)
+	}
+
+	for _, policy := range []string{c.ICMPPolicy, c.ICMPv6Policy} {
+		if err := validateICMPPolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateSNAT(); err != nil {
+		return err
+	}
+
+	if err := validateRouteTable(c); err != nil {
+		return err
+	}
+
+	if err := validateVRRP(c); err != nil {
+		return err
+	}
+
+	if err := validateDeviceOptions(c); err != nil {
+		return err
+	}
+
+	if err := validateDSCP(c.DSCP); err != nil {
+		return err
+	}
+	if c.DSCP != 0 && !c.EnableIPTables {
+		return types.BadRequestErrorf("DSCP marking requires iptables to be enabled")
+	}
+
 	return nil
 }
 
@@ -172,6 +372,11 @@ func (c *networkConfiguration) Conflicts(o *networkConfiguration) bool {
 		return true
 	}
 
+	// Custom iptables chains are dedicated to a single network
+	if (c.ChainPrefix != "" || o.ChainPrefix != "") && c.chainName() == o.chainName() {
+		return true
+	}
+
 	// They must be in different subnets
 	if (c.AddressIPv4 != nil && o.AddressIPv4 != nil) &&
 		(c.AddressIPv4.Contains(o.AddressIPv4.IP) || o.AddressIPv4.Contains(c.AddressIPv4.IP)) {
@@ -191,6 +396,36 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["ChainPrefix"]; ok && i != nil {
+		if c.ChainPrefix, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for ChainPrefix value")
+		}
+	}
+
+	if i, ok := data["ICMPPolicy"]; ok && i != nil {
+		if c.ICMPPolicy, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for ICMPPolicy value")
+		}
+	}
+
+	if i, ok := data["ICMPv6Policy"]; ok && i != nil {
+		if c.ICMPv6Policy, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for ICMPv6Policy value")
+		}
+	}
+
+	if i, ok := data["NDPProxyInterface"]; ok && i != nil {
+		if c.NDPProxyInterface, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for NDPProxyInterface value")
+		}
+	}
+
+	if i, ok := data["VethNamePattern"]; ok && i != nil {
+		if c.VethNamePattern, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for VethNamePattern value")
+		}
+	}
+
 	if i, ok := data["Mtu"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if c.Mtu, err = strconv.Atoi(s); err != nil {
@@ -201,6 +436,96 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["RouteTable"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.RouteTable, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse RouteTable value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for RouteTable value")
+		}
+	}
+
+	if i, ok := data["RouteRulePriority"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.RouteRulePriority, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse RouteRulePriority value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for RouteRulePriority value")
+		}
+	}
+
+	if i, ok := data["VRRPRouterID"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.VRRPRouterID, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse VRRPRouterID value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for VRRPRouterID value")
+		}
+	}
+
+	if i, ok := data["VRRPPriority"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.VRRPPriority, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse VRRPPriority value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for VRRPPriority value")
+		}
+	}
+
+	if i, ok := data["DSCP"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.DSCP, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse DSCP value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for DSCP value")
+		}
+	}
+
+	if i, ok := data["EnableSTP"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.EnableSTP, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse EnableSTP value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for EnableSTP value")
+		}
+	}
+
+	if i, ok := data["ForwardDelay"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.ForwardDelay, err = time.ParseDuration(s); err != nil {
+				return types.BadRequestErrorf("failed to parse ForwardDelay value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for ForwardDelay value")
+		}
+	}
+
+	if i, ok := data["AgeingTime"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.AgeingTime, err = time.ParseDuration(s); err != nil {
+				return types.BadRequestErrorf("failed to parse AgeingTime value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for AgeingTime value")
+		}
+	}
+
+	if i, ok := data["DisableMulticastSnooping"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.DisableMulticastSnooping, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse DisableMulticastSnooping value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for DisableMulticastSnooping value")
+		}
+	}
+
 	if i, ok := data["EnableIPv6"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if c.EnableIPv6, err = strconv.ParseBool(s); err != nil {
@@ -319,18 +644,38 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 			return types.BadRequestErrorf("invalid type for DefaultBindingIP value")
 		}
 	}
+
+	if i, ok := data["SNATPool"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.SNATPool, err = parseSNATPool(s); err != nil {
+				return err
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for SNATPool value")
+		}
+	}
+
+	if i, ok := data["SNATAddress"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.SNATAddress = net.ParseIP(s); c.SNATAddress == nil {
+				return types.BadRequestErrorf("failed to parse SNATAddress value")
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for SNATAddress value")
+		}
+	}
 	return nil
 }
 
 func (n *bridgeNetwork) getEndpoint(eid types.UUID) (*bridgeEndpoint, error) {
-	n.Lock()
-	defer n.Unlock()
-
 	if eid == "" {
 		return nil, InvalidEndpointIDError(eid)
 	}
 
-	if ep, ok := n.endpoints[eid]; ok {
+	if ep := n.endpoints.get(eid); ep != nil {
+		if ep.external {
+			return nil, types.ForbiddenErrorf("endpoint %s is an interface attached to the bridge outside of the driver", eid)
+		}
 		return ep, nil
 	}
 
@@ -425,17 +770,70 @@ func (d *driver) Config(option map[string]interface{}) error {
 			config = opaqueConfig.(*configuration)
 		case *configuration:
 			config = opt
+		case map[string]interface{}:
+			config = &configuration{}
+			if err := config.fromMap(opt); err != nil {
+				return err
+			}
 		default:
 			return &ErrInvalidDriverConfig{}
 		}
 
+		if err := config.Validate(); err != nil {
+			return err
+		}
+
 		d.config = config
 	} else {
 		config = &configuration{}
 	}
 
 	if config.EnableIPForwarding {
-		return setupIPForwarding(config)
+		if err := setupIPForwarding(config); err != nil {
+			return err
+		}
+	}
+
+	return d.setupMacAllocator(option, config)
+}
+
+// setupMacAllocator creates the allocator of the endpoint MAC addresses if
+// MAC ranges are configured. The allocations are kept in the datastore when
+// one is passed, so that bridges sharing a physical network across hosts do
+// not hand out the same addresses.
+func (d *driver) setupMacAllocator(option map[string]interface{}, config *configuration) error {
+	spec := config.MacRanges
+	if v, ok := option[netlabel.BridgeMacRanges]; ok {
+		if spec, ok = v.(string); !ok {
+			return &ErrInvalidDriverConfig{}
+		}
+	}
+	if spec == "" {
+		return nil
+	}
+
+	ranges, err := macallocator.ParseRanges(spec)
+	if err != nil {
+		return err
+	}
+
+	var store datastore.DataStore
+	provider, provOk := option[netlabel.KVProvider].(string)
+	provURL, urlOk := option[netlabel.KVProviderURL].(string)
+	if provOk && urlOk {
+		cfg := &libnetconfig.DatastoreCfg{
+			Client: libnetconfig.DatastoreClientCfg{
+				Provider: provider,
+				Address:  provURL,
+			},
+		}
+		if store, err = datastore.NewDataStore(cfg); err != nil {
+			return fmt.Errorf("failed to initialize data store: %v", err)
+		}
+	}
+
+	if d.macAllocator, err = macallocator.New(store, ranges); err != nil {
+		return fmt.Errorf("failed to initialize MAC allocator: %v", err)
 	}
 
 	return nil
@@ -496,8 +894,42 @@ func parseNetworkOptions(option options.Generic) (*networkConfiguration, error)
 	}
 
 	// Process well-known labels next
-	if _, ok := option[netlabel.EnableIPv6]; ok {
-		config.EnableIPv6 = option[netlabel.EnableIPv6].(bool)
+	if v, ok := option[netlabel.EnableIPv6]; ok {
+		if config.EnableIPv6, ok = v.(bool); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.EnableIPv6)
+		}
+	}
+
+	if v, ok := option[netlabel.BridgeSTP]; ok {
+		if config.EnableSTP, ok = v.(bool); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.BridgeSTP)
+		}
+	}
+
+	if v, ok := option[netlabel.BridgeForwardDelay]; ok {
+		if config.ForwardDelay, ok = v.(time.Duration); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.BridgeForwardDelay)
+		}
+	}
+
+	if v, ok := option[netlabel.BridgeAgeingTime]; ok {
+		if config.AgeingTime, ok = v.(time.Duration); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.BridgeAgeingTime)
+		}
+	}
+
+	if v, ok := option[netlabel.BridgeMulticastSnooping]; ok {
+		snooping, ok := v.(bool)
+		if !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.BridgeMulticastSnooping)
+		}
+		config.DisableMulticastSnooping = !snooping
+	}
+
+	if v, ok := option[netlabel.DSCP]; ok {
+		if config.DSCP, ok = v.(int); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for %s value", v, netlabel.DSCP)
+		}
 	}
 
 	// Finally validate the configuration
@@ -534,7 +966,7 @@ func (d *driver) getNetworks() []*bridgeNetwork {
 }
 
 // Create a new network using bridge plugin
-func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) error {
+func (d *driver) CreateNetwork(ctx context.Context, id types.UUID, option map[string]interface{}) error {
 	var err error
 
 	// Sanity checks
@@ -563,7 +995,7 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 	// Create and set network handler in driver
 	network := &bridgeNetwork{
 		id:         id,
-		endpoints:  make(map[types.UUID]*bridgeEndpoint),
+		endpoints:  newEndpointRegistry(),
 		config:     config,
 		portMapper: portmapper.New(),
 	}
@@ -615,6 +1047,7 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 	if !bridgeAlreadyExists {
 		bridgeSetup.queueStep(setupDevice)
 	}
+	network.external = bridgeAlreadyExists
 
 	// Even if a bridge exists try to setup IPv4.
 	bridgeSetup.queueStep(setupBridgeIPv4)
@@ -639,6 +1072,10 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 		// the case of a previously existing device.
 		{bridgeAlreadyExists, setupVerifyAndReconcile},
 
+		// Make sure the container addresses of an existing bridge are
+		// allocated from its network.
+		{bridgeAlreadyExists, setupVerifyExternalPool},
+
 		// Setup the bridge to allocate containers IPv4 addresses in the
 		// specified subnet.
 		{config.FixedCIDR != nil, setupFixedCIDRv4},
@@ -650,12 +1087,18 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 		// Enable IPv6 Forwarding
 		{enableIPv6Forwarding, setupIPv6Forwarding},
 
+		// Answer the neighbor solicitations for the containers
+		{config.NDPProxyInterface != "", setupNDPProxy},
+
 		// Setup Loopback Adresses Routing
 		{!config.EnableUserlandProxy, setupLoopbackAdressesRouting},
 
 		// Setup IPTables.
 		{config.EnableIPTables, network.setupIPTables},
 
+		// Filter the bridged traffic with iptables
+		{config.EnableIPTables, setupBridgeNetFiltering},
+
 		// Setup DefaultGatewayIPv4
 		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
 
@@ -670,18 +1113,42 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 		}
 	}
 
+	// Set the STP, ageing and multicast snooping knobs of the bridge
+	if config.hasDeviceOptions() {
+		bridgeSetup.queueStep(setupDeviceOptions)
+	}
+
 	// Block bridge IP from being allocated.
 	bridgeSetup.queueStep(allocateBridgeIP)
 	// Apply the prepared list of steps, and abort at the first error.
 	bridgeSetup.queueStep(setupDeviceUp)
+	// The routes of the table go through the bridge, which must be up
+	if config.RouteTable != 0 {
+		bridgeSetup.queueStep(setupRouteTable)
+	}
 	if err = bridgeSetup.apply(); err != nil {
 		return err
 	}
 
+	// The gateway is elected once the bridge has its address to speak from
+	if config.VRRPRouterID != 0 {
+		if err = d.startVRRP(network); err != nil {
+			return err
+		}
+	}
+
+	if bridgeAlreadyExists {
+		if err := network.adoptExternalEndpoints(); err != nil {
+			logrus.Warnf("Failed to adopt the interfaces attached to bridge %s: %v", config.BridgeName, err)
+		}
+	}
+
+	d.watchBridge(network)
+
 	return nil
 }
 
-func (d *driver) DeleteNetwork(nid types.UUID) error {
+func (d *driver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
 	var err error
 
 	// Get network handler and remove it from driver
@@ -724,7 +1191,7 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 	}
 
 	// Cannot remove network if endpoints are still present
-	if len(n.endpoints) != 0 {
+	if n.activeEndpoints() != 0 {
 		err = ActiveEndpointsError(n.id)
 		return err
 	}
@@ -745,13 +1212,44 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 		return err
 	}
 
-	// Programming
-	err = netlink.LinkDel(n.bridge.Link)
+	if config.RouteTable != 0 {
+		teardownRouteTable(config, n.bridge)
+	}
+
+	// The backups take over the gateway before the bridge goes away
+	n.stopVRRP()
+
+	// Programming. A bridge created outside of the driver is left in place,
+	// along with the interfaces attached to it.
+	if n.external {
+		n.releaseExternalEndpoints()
+	} else if err = netlink.LinkDel(n.bridge.Link); err != nil {
+		return err
+	}
+
+	if n.stopWatch != nil {
+		n.stopWatch()
+	}
+	n.stopPortIfaceWatches()
+
+	if config.EnableIPTables {
+		if err := programICMPRules(config, false); err != nil {
+			logrus.Warnf("Failed to remove the ICMP rules of network %s: %v", nid, err)
+		}
+		if err := programNetworkDSCP(config, false); err != nil {
+			logrus.Warnf("Failed to remove the DSCP rules of network %s: %v", nid, err)
+		}
+	}
+
+	// A custom chain is owned by this network only
+	if config.ChainPrefix != "" && config.EnableIPTables {
+		removeNetworkChains(config.chainName(), config.BridgeName)
+	}
 
-	return err
+	return nil
 }
 
-func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointInfo, epOptions map[string]interface{}) error {
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, epOptions map[string]interface{}) error {
 	var (
 		ipv6Addr *net.IPNet
 		err      error
@@ -783,8 +1281,16 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		n.Unlock()
 		return InvalidNetworkIDError(nid)
 	}
+	degraded := n.degraded
 	n.Unlock()
 
+	if degraded != "" {
+		degraded = n.checkRecovered()
+	}
+	if degraded != "" {
+		return types.ForbiddenErrorf("network %s is degraded: %s", nid, degraded)
+	}
+
 	// Check if endpoint id is good and retrieve correspondent endpoint
 	ep, err := n.getEndpoint(eid)
 	if err != nil {
@@ -802,23 +1308,51 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		return err
 	}
 
-	// Create and add the endpoint
-	n.Lock()
+	// Create and add the endpoint, unless another thread just did
 	endpoint := &bridgeEndpoint{id: eid, config: epConfig}
-	n.endpoints[eid] = endpoint
-	n.Unlock()
+	if !n.endpoints.add(endpoint) {
+		return driverapi.ErrEndpointExists(eid)
+	}
 
 	// On failure make sure to remove the endpoint
 	defer func() {
 		if err != nil {
-			n.Lock()
-			delete(n.endpoints, eid)
-			n.Unlock()
+			n.endpoints.remove(eid)
 		}
 	}()
 
-	// Generate a name for what will be the host side pipe interface
-	name1, err := netutils.GenerateIfaceName(vethPrefix, vethLen)
+	n.Lock()
+	config := n.config
+	n.Unlock()
+
+	// The SNAT address of the endpoint must be one of the pool of the network
+	if epConfig != nil && epConfig.SNATAddress != nil && !config.inSNATPool(epConfig.SNATAddress) {
+		return InvalidSNATAddressError(epConfig.SNATAddress.String())
+	}
+
+	// The connection limits are iptables rules
+	if epConfig.hasConnLimits() && !config.EnableIPTables {
+		return types.ForbiddenErrorf("connection limits of endpoint %s require iptables", eid)
+	}
+	if epConfig != nil && epConfig.DSCP != 0 && !config.EnableIPTables {
+		return types.ForbiddenErrorf("DSCP marking of endpoint %s requires iptables", eid)
+	}
+	// The IPv6 only bindings are served by the userland proxy alone, the NAT
+	// rules are IPv4 only
+	if epConfig != nil && !config.EnableUserlandProxy {
+		for _, b := range epConfig.PortBindings {
+			if b.HostIPv6Only && !b.PassSocket {
+				return types.ForbiddenErrorf("IPv6 only port binding %d/%s of endpoint %s requires the userland proxy", b.HostPort, b.Proto, eid)
+			}
+		}
+	}
+
+	// Name what will be the host side pipe interface
+	var requested string
+	if epConfig != nil {
+		requested = epConfig.VethName
+	}
+	name1, err := hostVethName(config.VethNamePattern, requested, eid)
 	if err != nil {
 		return err
 	}
@@ -859,22 +1393,28 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		}
 	}()
 
-	n.Lock()
-	config := n.config
-	n.Unlock()
-
-	// Add bridge inherited attributes to pipe interfaces
-	if config.Mtu != 0 {
-		err = netlink.LinkSetMTU(host, config.Mtu)
+	// Add bridge inherited attributes to pipe interfaces, unless the
+	// endpoint overrides them
+	mtu := config.Mtu
+	if epConfig != nil && epConfig.Mtu != 0 {
+		mtu = epConfig.Mtu
+	}
+	if mtu != 0 {
+		err = netlink.LinkSetMTU(host, mtu)
 		if err != nil {
 			return err
 		}
-		err = netlink.LinkSetMTU(sbox, config.Mtu)
+		err = netlink.LinkSetMTU(sbox, mtu)
 		if err != nil {
 			return err
 		}
 	}
 
+	// Tune the pipe interfaces for the workload of the endpoint
+	if err = applyPerfProfile(epConfig, name1, name2); err != nil {
+		return err
+	}
+
 	// Attach host side pipe interface into the bridge
 	if err = netlink.LinkSetMaster(host,
 		&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: config.BridgeName}}); err != nil {
@@ -888,6 +1428,18 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		}
 	}
 
+	// Mirror the endpoint traffic if requested
+	if epConfig != nil && epConfig.Mirror != nil {
+		if err = setupMirror(eid, name1, epConfig.Mirror); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				teardownMirror(eid, epConfig.Mirror)
+			}
+		}()
+	}
+
 	// v4 address for the sandbox side pipe interface
 	ip4, err := ipAllocator.RequestIP(n.bridge.bridgeIPv4, nil)
 	if err != nil {
@@ -895,8 +1447,17 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 	}
 	ipv4Addr := &net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
 
-	// Set the sbox's MAC. If specified, use the one configured by user, otherwise generate one based on IP.
-	mac := electMacAddress(epConfig, ip4)
+	// Set the sbox's MAC. If specified, use the one configured by user,
+	// otherwise allocate one from the MAC ranges or generate one based on IP.
+	mac, err := d.electMacAddress(epConfig, ip4)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.releaseMacAddress(mac)
+		}
+	}()
 	err = netlink.LinkSetHardwareAddr(sbox, mac)
 	if err != nil {
 		return err
@@ -914,7 +1475,9 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		}
 
 		ones, _ := network.Mask.Size()
-		if ones <= 80 {
+		if epConfig != nil && epConfig.ipv6Address != nil {
+			ip6 = epConfig.ipv6Address
+		} else if ones <= 80 {
 			ip6 = make(net.IP, len(network.IP))
 			copy(ip6, network.IP)
 			for i, h := range mac {
@@ -930,12 +1493,25 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		ipv6Addr = &net.IPNet{IP: ip6, Mask: network.Mask}
 	}
 
-	// Create the sandbox side pipe interface
-	endpoint.srcName = name2
-	endpoint.addr = ipv4Addr
-
-	if config.EnableIPv6 {
-		endpoint.addrv6 = ipv6Addr
+	// Secondary addresses requested by the user
+	if epConfig != nil && len(epConfig.SecondaryAddresses) != 0 {
+		if endpoint.secondary, err = allocateSecondaryAddresses(config, n.bridge, epConfig.SecondaryAddresses); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				releaseSecondaryAddresses(config, n.bridge, endpoint.secondary)
+			}
+		}()
+	}
+
+	// Create the sandbox side pipe interface
+	endpoint.srcName = name2
+	endpoint.hostName = name1
+	endpoint.addr = ipv4Addr
+
+	if config.EnableIPv6 {
+		endpoint.addrv6 = ipv6Addr
 	}
 
 	err = epInfo.AddInterface(ifaceID, endpoint.macAddress, *ipv4Addr, *ipv6Addr)
@@ -943,16 +1519,83 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		return err
 	}
 
+	if len(endpoint.secondary) != 0 {
+		sa, ok := epInfo.(driverapi.SecondaryAddressAdder)
+		if !ok {
+			err = types.NotImplementedErrorf("secondary addresses of endpoint %s cannot be recorded", eid)
+			return err
+		}
+		for _, addr := range endpoint.secondary {
+			if err = sa.AddSecondaryAddress(ifaceID, *addr); err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.EnableIPv6 && config.NDPProxyInterface != "" {
+		if err = programNDPProxy(config.NDPProxyInterface, ipv6Addr.IP, true); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				programNDPProxy(config.NDPProxyInterface, ipv6Addr.IP, false)
+			}
+		}()
+	}
+
+	// Translate the outbound traffic to the SNAT address of the endpoint
+	if err = programEndpointSNAT(config, epConfig, ipv4Addr, true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			programEndpointSNAT(config, epConfig, ipv4Addr, false)
+		}
+	}()
+
+	// Mark the outbound traffic of the endpoint addresses
+	for _, ip := range []net.IP{ipv4Addr.IP, ipv6Addr.IP} {
+		if err = programEndpointDSCP(config, epConfig, ip, true); err != nil {
+			return err
+		}
+		defer func(ip net.IP) {
+			if err != nil {
+				programEndpointDSCP(config, epConfig, ip, false)
+			}
+		}(ip)
+	}
+
+	// The bindings published on a host interface follow its address
+	d.watchPortIfaces(n, epConfig)
+
+	// The deferred bindings are withdrawn until the external connectivity
+	// of the endpoint is enabled, no host port is taken meanwhile
+	if epConfig != nil && epConfig.DeferPortBinding {
+		n.Lock()
+		endpoint.withdrawnPortMapping = make([]types.PortBinding, 0, len(epConfig.PortBindings))
+		for _, b := range epConfig.PortBindings {
+			endpoint.withdrawnPortMapping = append(endpoint.withdrawnPortMapping, b.GetCopy())
+		}
+		n.Unlock()
+		return nil
+	}
+
 	// Program any required port mapping and store them in the endpoint
 	endpoint.portMapping, err = n.allocatePorts(epConfig, endpoint, config.DefaultBindingIP, config.EnableUserlandProxy)
 	if err != nil {
 		return err
 	}
 
+	// Limit the connections to the published ports
+	if err = programConnLimits(eid, epConfig, endpoint.portMapping, ipv4Addr.IP, true); err != nil {
+		n.releasePorts(endpoint)
+		return err
+	}
+
 	return nil
 }
 
-func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+func (d *driver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
 	var err error
 
 	// Get the network handler and make sure it exists
@@ -985,19 +1628,13 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	}
 
 	// Remove it
-	n.Lock()
-	delete(n.endpoints, eid)
-	n.Unlock()
+	n.endpoints.remove(eid)
 
 	// On failure make sure to set back ep in n.endpoints, but only
 	// if it hasn't been taken over already by some other thread.
 	defer func() {
 		if err != nil {
-			n.Lock()
-			if _, ok := n.endpoints[eid]; !ok {
-				n.endpoints[eid] = ep
-			}
-			n.Unlock()
+			n.endpoints.add(ep)
 		}
 	}()
 
@@ -1022,6 +1659,42 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 		}
 	}
 
+	// Release the secondary addresses
+	releaseSecondaryAddresses(config, n.bridge, ep.secondary)
+
+	// Stop answering for the v6 address. Do not stop endpoint delete on failure
+	if config.EnableIPv6 && config.NDPProxyInterface != "" && ep.addrv6 != nil {
+		if err := programNDPProxy(config.NDPProxyInterface, ep.addrv6.IP, false); err != nil {
+			logrus.Warnf("Failed to remove the NDP proxy entry of endpoint %s: %v", eid, err)
+		}
+	}
+
+	// Remove the connection limits. Failures are only logged
+	programConnLimits(eid, ep.config, ep.portMapping, ep.addr.IP, false)
+
+	// Remove the SNAT rule. Do not stop endpoint delete on failure
+	if err := programEndpointSNAT(config, ep.config, ep.addr, false); err != nil {
+		logrus.Warnf("Failed to remove the SNAT rule of endpoint %s: %v", eid, err)
+	}
+
+	// Remove the DSCP marking rules. Do not stop endpoint delete on failure
+	ips := []net.IP{ep.addr.IP}
+	if ep.addrv6 != nil {
+		ips = append(ips, ep.addrv6.IP)
+	}
+	for _, ip := range ips {
+		if err := programEndpointDSCP(config, ep.config, ip, false); err != nil {
+			logrus.Warnf("Failed to remove the DSCP rule of address %s of endpoint %s: %v", ip, eid, err)
+		}
+	}
+
+	// Remove the mirror tunnel, if any
+	if ep.config != nil && ep.config.Mirror != nil {
+		teardownMirror(eid, ep.config.Mirror)
+	}
+
+	d.releaseMacAddress(ep.macAddress)
+
 	// Try removal of link. Discard error: link pair might have
 	// already been deleted by sandbox delete.
 	link, err := netlink.LinkByName(ep.srcName)
@@ -1032,6 +1705,275 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	return nil
 }
 
+// ChangeEndpointAddress moves the endpoint to a different IPv4 address. The
+// port mappings are reprogrammed toward the new address keeping their host
+// ports. Endpoints taking part in links are refused, as the link rules of
+// both ends are bound to the endpoint address.
+func (d *driver) ChangeEndpointAddress(nid, eid types.UUID, ip net.IP) (*net.IPNet, error) {
+	var err error
+
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := network.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, EndpointNotFoundError(eid)
+	}
+
+	network.Lock()
+	config := network.config
+	bridgeIPv4 := network.bridge.bridgeIPv4
+	linked := network.isLinked(eid)
+	network.Unlock()
+
+	if linked {
+		return nil, types.ForbiddenErrorf("cannot change the address of endpoint %s while it is linked to other endpoints", eid)
+	}
+
+	if ip != nil && ip.Equal(ep.addr.IP) {
+		return types.GetIPNetCopy(ep.addr), nil
+	}
+
+	ip4, err := ipAllocator.RequestIP(bridgeIPv4, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleaseIP(bridgeIPv4, ip4)
+		}
+	}()
+
+	newAddr := &net.IPNet{IP: ip4, Mask: bridgeIPv4.Mask}
+
+	// Reprogram the port mappings toward the new address. The operational
+	// bindings carry the host ports in use, so they are preserved.
+	oldMapping := ep.portMapping
+	if err = network.releasePortsInternal(oldMapping); err != nil {
+		return nil, err
+	}
+	newMapping, err := network.allocatePortsInternal(oldMapping, ip4, defaultBindingIP, config.EnableUserlandProxy)
+	if err != nil {
+		if bs, rbErr := network.allocatePortsInternal(oldMapping, ep.addr.IP, defaultBindingIP, config.EnableUserlandProxy); rbErr != nil {
+			logrus.Warnf("Failed to restore port mappings of endpoint %s: %v", eid, rbErr)
+		} else {
+			ep.portMapping = bs
+		}
+		return nil, err
+	}
+
+	// The SNAT rule matches the endpoint address
+	if err = programEndpointSNAT(config, ep.config, newAddr, true); err != nil {
+		network.releasePortsInternal(newMapping)
+		if bs, rbErr := network.allocatePortsInternal(oldMapping, ep.addr.IP, defaultBindingIP, config.EnableUserlandProxy); rbErr != nil {
+			logrus.Warnf("Failed to restore port mappings of endpoint %s: %v", eid, rbErr)
+		} else {
+			ep.portMapping = bs
+		}
+		return nil, err
+	}
+	if rErr := programEndpointSNAT(config, ep.config, ep.addr, false); rErr != nil {
+		logrus.Warnf("Failed to remove the SNAT rule of address %s of endpoint %s: %v", ep.addr.IP, eid, rErr)
+	}
+
+	// So do the connection limits. The limits of the new address only
+	// count the connections from the move on.
+	programConnLimits(eid, ep.config, oldMapping, ep.addr.IP, false)
+	if rErr := programConnLimits(eid, ep.config, newMapping, ip4, true); rErr != nil {
+		logrus.Warnf("Failed to limit the connections to address %s of endpoint %s: %v", ip4, eid, rErr)
+	}
+
+	// And the DSCP marking rule
+	programEndpointDSCP(config, ep.config, ep.addr.IP, false)
+	if rErr := programEndpointDSCP(config, ep.config, ip4, true); rErr != nil {
+		logrus.Warnf("Failed to mark the traffic of address %s of endpoint %s: %v", ip4, eid, rErr)
+	}
+
+	if rErr := ipAllocator.ReleaseIP(bridgeIPv4, ep.addr.IP); rErr != nil {
+		logrus.Warnf("Failed to release address %s of endpoint %s: %v", ep.addr.IP, eid, rErr)
+	}
+
+	network.Lock()
+	ep.addr = newAddr
+	ep.portMapping = newMapping
+	network.Unlock()
+
+	return types.GetIPNetCopy(newAddr), nil
+}
+
+// SetExternalConnectivity withdraws the port mappings of the endpoint, or
+// restores them on the same host ports. The deferred bindings of an endpoint
+// are bound the first time it is enabled, along with their connection
+// limits, which are removed with them.
+func (d *driver) SetExternalConnectivity(nid, eid types.UUID, enable bool) error {
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	ep, err := network.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	network.Lock()
+	config := network.config
+	active := ep.portMapping
+	withdrawn := ep.withdrawnPortMapping
+	epConfig := ep.config
+	network.Unlock()
+
+	deferred := epConfig != nil && epConfig.DeferPortBinding
+
+	if !enable {
+		if withdrawn != nil {
+			return nil
+		}
+		if deferred {
+			programConnLimits(eid, epConfig, active, ep.addr.IP, false)
+		}
+		if err := network.releasePortsInternal(active); err != nil {
+			return err
+		}
+		network.Lock()
+		ep.withdrawnPortMapping = active
+		if ep.withdrawnPortMapping == nil {
+			ep.withdrawnPortMapping = []types.PortBinding{}
+		}
+		ep.portMapping = nil
+		network.Unlock()
+		return nil
+	}
+
+	if withdrawn == nil {
+		return nil
+	}
+	defHostIP := defaultBindingIP
+	if config.DefaultBindingIP != nil {
+		defHostIP = config.DefaultBindingIP
+	}
+	bs, err := network.allocatePortsInternal(withdrawn, ep.addr.IP, defHostIP, config.EnableUserlandProxy)
+	if err != nil {
+		return err
+	}
+	if deferred {
+		if err := programConnLimits(eid, epConfig, bs, ep.addr.IP, true); err != nil {
+			network.releasePortsInternal(bs)
+			return err
+		}
+	}
+	network.Lock()
+	ep.portMapping = bs
+	ep.withdrawnPortMapping = nil
+	network.Unlock()
+
+	return nil
+}
+
+// PoolStatus reports the usage of the IPv4 pool of the network and, when
+// IPv6 is enabled, of its IPv6 pool.
+func (d *driver) PoolStatus(nid types.UUID) ([]*ipam.PoolStatus, error) {
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	network.Lock()
+	config := network.config
+	pools := []*net.IPNet{network.bridge.bridgeIPv4}
+	counts := []map[string]uint64{make(map[string]uint64)}
+	if config.EnableIPv6 {
+		v6 := network.bridge.bridgeIPv6
+		if config.FixedCIDRv6 != nil {
+			v6 = config.FixedCIDRv6
+		}
+		pools = append(pools, v6)
+		counts = append(counts, make(map[string]uint64))
+	}
+	for eid, ep := range network.endpoints.snapshot() {
+		if ep.addr != nil {
+			counts[0][string(eid)]++
+		}
+		if len(counts) > 1 && ep.addrv6 != nil && ep.addrv6.IP != nil {
+			counts[1][string(eid)]++
+		}
+		for _, addr := range ep.secondary {
+			if addr.IP.To4() != nil {
+				counts[0][string(eid)]++
+			} else if len(counts) > 1 {
+				counts[1][string(eid)]++
+			}
+		}
+	}
+	network.Unlock()
+
+	status := make([]*ipam.PoolStatus, 0, len(pools))
+	for i, pool := range pools {
+		ps, err := ipAllocator.PoolStatus(pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the status of pool %s: %v", pool, err)
+		}
+		ps.TopConsumers = ipam.TopConsumers(counts[i], ipam.MaxTopConsumers)
+		status = append(status, ps)
+	}
+
+	return status, nil
+}
+
+// StartCapture captures the traffic of the endpoint on the host side of its
+// veth pair.
+func (d *driver) StartCapture(nid, eid types.UUID, opts capture.Options) (*capture.Capture, error) {
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := network.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, EndpointNotFoundError(eid)
+	}
+
+	network.Lock()
+	srcName := ep.srcName
+	network.Unlock()
+
+	return capture.Start(srcName, opts)
+}
+
+// isLinked tells whether the endpoint is a parent or a child in a link. It
+// walks a snapshot of the endpoint registry, which takes the registry lock
+// itself, so the caller needs no lock held.
+func (n *bridgeNetwork) isLinked(eid types.UUID) bool {
+	for id, ep := range n.endpoints.snapshot() {
+		cc := ep.containerConfig
+		if cc == nil {
+			continue
+		}
+		if id == eid && (len(cc.ParentEndpoints) != 0 || len(cc.ChildEndpoints) != 0) {
+			return true
+		}
+		for _, l := range [][]string{cc.ParentEndpoints, cc.ChildEndpoints} {
+			for _, p := range l {
+				if types.UUID(p) == eid {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (d *driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
 	// Get the network handler and make sure it exists
 	d.Lock()
@@ -1085,11 +2027,32 @@ func (d *driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{},
 		m[netlabel.MacAddress] = ep.macAddress
 	}
 
+	if ep.hostName != "" {
+		m[netlabel.VethName] = ep.hostName
+	}
+
+	if ep.config.hasConnLimits() {
+		m[netlabel.ConnectionLimit] = ep.config.ConnectionLimit
+		m[netlabel.SYNRateLimit] = ep.config.SYNRateLimit
+	}
+
+	if ep.config != nil && ep.config.DSCP != 0 {
+		m[netlabel.EndpointDSCP] = ep.config.DSCP
+	}
+
+	if ep.config != nil && ep.config.DeferPortBinding {
+		m[netlabel.DeferPortBinding] = true
+	}
+
+	if ep.config != nil && ep.config.PerfProfile != "" {
+		m[netlabel.PerfProfile] = ep.config.PerfProfile
+	}
+
 	return m, nil
 }
 
 // Join method is invoked when a Sandbox is attached to an endpoint.
-func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+func (d *driver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
 	network, err := d.getNetwork(nid)
 	if err != nil {
 		return err
@@ -1124,6 +2087,10 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 		return err
 	}
 
+	if err = network.passSockets(endpoint, jinfo); err != nil {
+		return err
+	}
+
 	if !network.config.EnableICC {
 		return d.link(network, endpoint, options, true)
 	}
@@ -1132,7 +2099,7 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 }
 
 // Leave method is invoked when a Sandbox detaches from an endpoint.
-func (d *driver) Leave(nid, eid types.UUID) error {
+func (d *driver) Leave(ctx context.Context, nid, eid types.UUID) error {
 	network, err := d.getNetwork(nid)
 	if err != nil {
 		return err
@@ -1187,7 +2154,7 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, options
 
 			l := newLink(parentEndpoint.addr.IP.String(),
 				endpoint.addr.IP.String(),
-				endpoint.config.ExposedPorts, network.config.BridgeName)
+				endpoint.config.ExposedPorts, network.config.BridgeName, network.config.chainName())
 			if enable {
 				err = l.Enable()
 				if err != nil {
@@ -1220,7 +2187,7 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, options
 
 		l := newLink(endpoint.addr.IP.String(),
 			childEndpoint.addr.IP.String(),
-			childEndpoint.config.ExposedPorts, network.config.BridgeName)
+			childEndpoint.config.ExposedPorts, network.config.BridgeName, network.config.chainName())
 		if enable {
 			err = l.Enable()
 			if err != nil {
@@ -1264,6 +2231,11 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 
 	if opt, ok := epOptions[netlabel.PortMap]; ok {
 		if bs, ok := opt.([]types.PortBinding); ok {
+			for i := range bs {
+				if err := bs[i].Validate(); err != nil {
+					return nil, err
+				}
+			}
 			ec.PortBindings = bs
 		} else {
 			return nil, &ErrInvalidEndpointConfig{}
@@ -1278,6 +2250,131 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.Mirror]; ok {
+		if m, ok := opt.(types.MirrorConfig); ok {
+			if err := m.Validate(); err != nil {
+				return nil, err
+			}
+			ec.Mirror = &m
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.VethName]; ok {
+		if name, ok := opt.(string); ok {
+			if err := validateVethName(name); err != nil {
+				return nil, err
+			}
+			ec.VethName = name
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.MTU]; ok {
+		if mtu, ok := opt.(int); ok {
+			if mtu < 0 {
+				return nil, ErrInvalidMtu(mtu)
+			}
+			ec.Mtu = mtu
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.SNATAddress]; ok {
+		if ip, ok := opt.(net.IP); ok {
+			ec.SNATAddress = ip
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.SecondaryAddresses]; ok {
+		if ips, ok := opt.([]net.IP); ok {
+			seen := map[string]bool{}
+			for _, ip := range ips {
+				if ip == nil || ip.IsUnspecified() || seen[ip.String()] {
+					return nil, InvalidSecondaryAddressError(ip.String())
+				}
+				seen[ip.String()] = true
+			}
+			ec.SecondaryAddresses = ips
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.ConnectionLimit]; ok {
+		if limit, ok := opt.(int); ok {
+			if limit < 0 {
+				return nil, types.BadRequestErrorf("invalid connection limit %d", limit)
+			}
+			ec.ConnectionLimit = limit
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.SYNRateLimit]; ok {
+		if rate, ok := opt.(int); ok {
+			if rate < 0 {
+				return nil, types.BadRequestErrorf("invalid SYN rate limit %d", rate)
+			}
+			ec.SYNRateLimit = rate
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.EndpointDSCP]; ok {
+		if dscp, ok := opt.(int); ok {
+			if err := validateDSCP(dscp); err != nil {
+				return nil, err
+			}
+			ec.DSCP = dscp
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.DeferPortBinding]; ok {
+		if deferred, ok := opt.(bool); ok {
+			ec.DeferPortBinding = deferred
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[importIPv6Option]; ok {
+		if ip, ok := opt.(net.IP); ok {
+			ec.ipv6Address = ip
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.PerfProfile]; ok {
+		if profile, ok := opt.(string); ok {
+			ec.PerfProfile = profile
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.PerfCPUs]; ok {
+		if cpus, ok := opt.(string); ok {
+			ec.PerfCPUs = cpus
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if err := validatePerfProfile(ec); err != nil {
+		return nil, err
+	}
+
 	return ec, nil
 }
 
@@ -1328,9 +2425,129 @@ func generateMacAddr(ip net.IP) net.HardwareAddr {
 	return hw
 }
 
-func electMacAddress(epConfig *endpointConfiguration, ip net.IP) net.HardwareAddr {
+// electMacAddress returns the MAC address requested by the user, reserving
+// it if it belongs to the configured MAC ranges, or a newly allocated one
+// from the ranges. Without MAC ranges the address is derived from the IP.
+func (d *driver) electMacAddress(epConfig *endpointConfiguration, ip net.IP) (net.HardwareAddr, error) {
+	d.Lock()
+	ma := d.macAllocator
+	d.Unlock()
+
+	var requested net.HardwareAddr
 	if epConfig != nil && epConfig.MacAddress != nil {
-		return epConfig.MacAddress
+		requested = epConfig.MacAddress
+	}
+
+	if ma == nil {
+		if requested != nil {
+			return requested, nil
+		}
+		return generateMacAddr(ip), nil
+	}
+
+	mac, err := ma.Request(requested)
+	if err != nil {
+		return nil, types.ForbiddenErrorf("failed to allocate MAC address %s: %v", requested, err)
 	}
-	return generateMacAddr(ip)
+	return mac, nil
+}
+
+func (d *driver) releaseMacAddress(mac net.HardwareAddr) {
+	d.Lock()
+	ma := d.macAllocator
+	d.Unlock()
+
+	if ma != nil {
+		ma.Release(mac)
+	}
+}
+
+func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
+	epMap := make(map[string]interface{})
+	epMap["id"] = string(ep.id)
+	epMap["SrcName"] = ep.srcName
+	if ep.hostName != "" {
+		epMap["HostName"] = ep.hostName
+	}
+	if ep.addr != nil {
+		epMap["Addr"] = ep.addr.String()
+	}
+	if ep.addrv6 != nil {
+		epMap["Addrv6"] = ep.addrv6.String()
+	}
+	if len(ep.macAddress) != 0 {
+		epMap["MacAddress"] = ep.macAddress.String()
+	}
+	epMap["Config"] = ep.config
+	epMap["ContainerConfig"] = ep.containerConfig
+	epMap["PortMapping"] = ep.portMapping
+	if len(ep.secondary) != 0 {
+		var secondary []string
+		for _, addr := range ep.secondary {
+			secondary = append(secondary, addr.String())
+		}
+		epMap["Secondary"] = secondary
+	}
+
+	return json.Marshal(epMap)
+}
+
+func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
+	var (
+		err   error
+		epMap map[string]interface{}
+	)
+
+	if err = json.Unmarshal(b, &epMap); err != nil {
+		return fmt.Errorf("failed to unmarshal to bridge endpoint: %v", err)
+	}
+
+	if v, ok := epMap["id"]; ok {
+		ep.id = types.UUID(v.(string))
+	}
+	if v, ok := epMap["SrcName"]; ok {
+		ep.srcName = v.(string)
+	}
+	if v, ok := epMap["HostName"]; ok {
+		ep.hostName = v.(string)
+	}
+	if v, ok := epMap["Addr"]; ok {
+		if ep.addr, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint IPv4 address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["Addrv6"]; ok {
+		if ep.addrv6, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint IPv6 address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["MacAddress"]; ok {
+		if ep.macAddress, err = net.ParseMAC(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint MAC address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+
+	d, _ := json.Marshal(epMap["Config"])
+	if err := json.Unmarshal(d, &ep.config); err != nil {
+		logrus.Warnf("Failed to decode endpoint config %v", err)
+	}
+	d, _ = json.Marshal(epMap["ContainerConfig"])
+	if err := json.Unmarshal(d, &ep.containerConfig); err != nil {
+		logrus.Warnf("Failed to decode endpoint container config %v", err)
+	}
+	d, _ = json.Marshal(epMap["PortMapping"])
+	if err := json.Unmarshal(d, &ep.portMapping); err != nil {
+		logrus.Warnf("Failed to decode endpoint port mapping %v", err)
+	}
+	if v, ok := epMap["Secondary"]; ok {
+		for _, s := range v.([]interface{}) {
+			addr, err := types.ParseCIDR(s.(string))
+			if err != nil {
+				return types.InternalErrorf("failed to decode bridge endpoint secondary address (%s) after json unmarshal: %v", s, err)
+			}
+			ep.secondary = append(ep.secondary, addr)
+		}
+	}
+
+	return nil
 }
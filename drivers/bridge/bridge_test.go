@@ -2,15 +2,22 @@ package bridge
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"regexp"
 	"testing"
+	"time"
 
+	"github.com/docker/libnetwork/capture"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 )
@@ -40,7 +47,7 @@ func TestCreateFullOptions(t *testing.T) {
 	netOption := make(map[string]interface{})
 	netOption[netlabel.GenericData] = netConfig
 
-	err := d.CreateNetwork("dummy", netOption)
+	err := d.CreateNetwork(context.Background(), "dummy", netOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
@@ -54,11 +61,11 @@ func TestCreate(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	if err := d.CreateNetwork("dummy", genericOption); err != nil {
+	if err := d.CreateNetwork(context.Background(), "dummy", genericOption); err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err == nil {
 		t.Fatalf("Expected bridge driver to refuse creation of second network with default name")
 	}
@@ -66,7 +73,7 @@ func TestCreate(t *testing.T) {
 		t.Fatalf("Creation of second network with default name failed with unexpected error type")
 	}
 
-	err = d.DeleteNetwork("dummy")
+	err = d.DeleteNetwork(context.Background(), "dummy")
 	if err == nil {
 		t.Fatalf("deletion of network with default name should fail on this driver")
 	}
@@ -83,7 +90,7 @@ func TestCreateFail(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	if err := d.CreateNetwork("dummy", genericOption); err == nil {
+	if err := d.CreateNetwork(context.Background(), "dummy", genericOption); err == nil {
 		t.Fatal("Bridge creation was expected to fail")
 	}
 }
@@ -96,19 +103,19 @@ func TestCreateMultipleNetworks(t *testing.T) {
 	config1 := &networkConfiguration{BridgeName: "net_test_1", AllowNonDefaultBridge: true, EnableIPTables: true}
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config1
-	if err := d.CreateNetwork("1", genericOption); err != nil {
+	if err := d.CreateNetwork(context.Background(), "1", genericOption); err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	config2 := &networkConfiguration{BridgeName: "net_test_2", AllowNonDefaultBridge: true, EnableIPTables: true}
 	genericOption[netlabel.GenericData] = config2
-	if err := d.CreateNetwork("2", genericOption); err != nil {
+	if err := d.CreateNetwork(context.Background(), "2", genericOption); err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	config3 := &networkConfiguration{BridgeName: "net_test_3", AllowNonDefaultBridge: true, EnableIPTables: true}
 	genericOption[netlabel.GenericData] = config3
-	if err := d.CreateNetwork("3", genericOption); err != nil {
+	if err := d.CreateNetwork(context.Background(), "3", genericOption); err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
@@ -117,23 +124,23 @@ func TestCreateMultipleNetworks(t *testing.T) {
 
 	config4 := &networkConfiguration{BridgeName: "net_test_4", AllowNonDefaultBridge: true, EnableIPTables: true}
 	genericOption[netlabel.GenericData] = config4
-	if err := d.CreateNetwork("4", genericOption); err != nil {
+	if err := d.CreateNetwork(context.Background(), "4", genericOption); err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	// Now 6 times
 	verifyV4INCEntries(dd.networks, 6, t)
 
-	d.DeleteNetwork("1")
+	d.DeleteNetwork(context.Background(), "1")
 	verifyV4INCEntries(dd.networks, 4, t)
 
-	d.DeleteNetwork("2")
+	d.DeleteNetwork(context.Background(), "2")
 	verifyV4INCEntries(dd.networks, 2, t)
 
-	d.DeleteNetwork("3")
+	d.DeleteNetwork(context.Background(), "3")
 	verifyV4INCEntries(dd.networks, 0, t)
 
-	d.DeleteNetwork("4")
+	d.DeleteNetwork(context.Background(), "4")
 	verifyV4INCEntries(dd.networks, 0, t)
 }
 
@@ -154,12 +161,13 @@ func verifyV4INCEntries(networks map[types.UUID]*bridgeNetwork, numEntries int,
 }
 
 type testInterface struct {
-	id      int
-	mac     net.HardwareAddr
-	addr    net.IPNet
-	addrv6  net.IPNet
-	srcName string
-	dstName string
+	id        int
+	mac       net.HardwareAddr
+	addr      net.IPNet
+	addrv6    net.IPNet
+	secondary []net.IPNet
+	srcName   string
+	dstName   string
 }
 
 type testEndpoint struct {
@@ -169,6 +177,7 @@ type testEndpoint struct {
 	hostsPath      string
 	resolvConfPath string
 	routes         []types.StaticRoute
+	sockets        []types.PortBinding
 }
 
 func (te *testEndpoint) Interfaces() []driverapi.InterfaceInfo {
@@ -182,11 +191,21 @@ func (te *testEndpoint) Interfaces() []driverapi.InterfaceInfo {
 }
 
 func (te *testEndpoint) AddInterface(id int, mac net.HardwareAddr, ipv4 net.IPNet, ipv6 net.IPNet) error {
-	iface := &testInterface{id: id, addr: ipv4, addrv6: ipv6}
+	iface := &testInterface{id: id, mac: mac, addr: ipv4, addrv6: ipv6}
 	te.ifaces = append(te.ifaces, iface)
 	return nil
 }
 
+func (te *testEndpoint) AddSecondaryAddress(id int, addr net.IPNet) error {
+	for _, iface := range te.ifaces {
+		if iface.id == id {
+			iface.secondary = append(iface.secondary, addr)
+			return nil
+		}
+	}
+	return fmt.Errorf("no interface %d", id)
+}
+
 func (i *testInterface) ID() int {
 	return i.id
 }
@@ -239,6 +258,11 @@ func (te *testEndpoint) SetResolvConfPath(path string) error {
 	return nil
 }
 
+func (te *testEndpoint) AddBoundSocket(binding types.PortBinding, f *os.File) error {
+	te.sockets = append(te.sockets, binding)
+	return f.Close()
+}
+
 func (te *testEndpoint) AddStaticRoute(destination *net.IPNet, routeType int, nextHop net.IP, interfaceID int) error {
 	te.routes = append(te.routes, types.StaticRoute{Destination: destination, RouteType: routeType, NextHop: nextHop, InterfaceID: interfaceID})
 	return nil
@@ -266,7 +290,7 @@ func testQueryEndpointInfo(t *testing.T, ulPxyEnabled bool) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("net1", genericOption)
+	err := d.CreateNetwork(context.Background(), "net1", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
@@ -276,7 +300,7 @@ func testQueryEndpointInfo(t *testing.T, ulPxyEnabled bool) {
 	epOptions[netlabel.PortMap] = portMappings
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("net1", "ep1", te, epOptions)
+	err = d.CreateEndpoint(context.Background(), "net1", "ep1", te, epOptions)
 	if err != nil {
 		t.Fatalf("Failed to create an endpoint : %s", err.Error())
 	}
@@ -285,7 +309,7 @@ func testQueryEndpointInfo(t *testing.T, ulPxyEnabled bool) {
 	if !ok {
 		t.Fatalf("Cannot find network %s inside driver", "net1")
 	}
-	ep, _ := network.endpoints["ep1"]
+	ep := network.endpoints.get("ep1")
 	data, err := d.EndpointOperInfo(network.id, ep.id)
 	if err != nil {
 		t.Fatalf("Failed to ask for endpoint operational data:  %v", err)
@@ -314,6 +338,348 @@ func testQueryEndpointInfo(t *testing.T, ulPxyEnabled bool) {
 	}
 }
 
+func TestChangeEndpointAddress(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		EnableUserlandProxy: true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = getPortMapping()
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+
+	network := dd.networks["net1"]
+	ep := network.endpoints.get("ep1")
+	oldIP := ep.addr.IP
+	oldMapping := ep.portMapping
+
+	addr, err := dd.ChangeEndpointAddress("net1", "ep1", nil)
+	if err != nil {
+		t.Fatalf("Failed to change the endpoint address: %v", err)
+	}
+	if addr.IP.Equal(oldIP) || !addr.IP.Equal(ep.addr.IP) {
+		t.Fatalf("Unexpected address after change: %s (was %s)", addr, oldIP)
+	}
+	if len(ep.portMapping) != len(oldMapping) {
+		t.Fatalf("Port mappings were lost on address change")
+	}
+	for i, pb := range ep.portMapping {
+		if !pb.IP.Equal(addr.IP) {
+			t.Fatalf("Port mapping %v still points to the old address", pb)
+		}
+		if pb.HostPort != oldMapping[i].HostPort {
+			t.Fatalf("Host port changed from %d to %d", oldMapping[i].HostPort, pb.HostPort)
+		}
+	}
+
+	// Moving back to the released address must succeed
+	if _, err := dd.ChangeEndpointAddress("net1", "ep1", oldIP); err != nil {
+		t.Fatalf("Failed to change back to the released address: %v", err)
+	}
+	if !ep.addr.IP.Equal(oldIP) {
+		t.Fatalf("Expected address %s, got %s", oldIP, ep.addr.IP)
+	}
+
+	if err := network.releasePorts(ep); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:            "export0",
+		AllowNonDefaultBridge: true,
+		EnableUserlandProxy:   true,
+		EnableIPv6:            true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = getPortMapping()
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+	te = &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep2", te, epOptions); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+	// Leave a hole in the pool for the import not to allocate the same address
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep1"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	old := dd.networks["net1"].endpoints.get("ep2")
+	oldAddr := old.addr.IP
+	oldMac := old.macAddress
+	oldHostName := old.hostName
+	oldMapping := old.portMapping
+
+	bundle, err := dd.Export()
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	// The IPv6 address of the bundle is kept, rather than derived from the
+	// MAC address again, as it is not in the prefixes longer than /80
+	b := &exportBundle{}
+	if err := json.Unmarshal(bundle, b); err != nil {
+		t.Fatal(err)
+	}
+	oldAddrv6 := net.ParseIP("fe80::1234")
+	b.Networks[0].Endpoints[0].Endpoint.addrv6.IP = oldAddrv6
+	if bundle, err = json.Marshal(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep2"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+	if err := d.DeleteNetwork(context.Background(), "net1"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+
+	if err := dd.Import(context.Background(), []byte(`{"Version":0}`)); err == nil {
+		t.Fatalf("Expected an unsupported bundle to be refused")
+	}
+
+	if err := dd.Import(context.Background(), bundle); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	network, err := dd.getNetwork("net1")
+	if err != nil {
+		t.Fatalf("Imported network not found: %v", err)
+	}
+	if network.config.BridgeName != "export0" {
+		t.Fatalf("Unexpected bridge name %s", network.config.BridgeName)
+	}
+	ep := network.endpoints.get("ep2")
+	if ep == nil {
+		t.Fatalf("Imported endpoint not found")
+	}
+	if network.endpoints.get("ep1") != nil {
+		t.Fatalf("Deleted endpoint was imported")
+	}
+	if !ep.addr.IP.Equal(oldAddr) {
+		t.Fatalf("Expected address %s, got %s", oldAddr, ep.addr.IP)
+	}
+	if ep.addrv6 == nil || !ep.addrv6.IP.Equal(oldAddrv6) {
+		t.Fatalf("Expected IPv6 address %s, got %v", oldAddrv6, ep.addrv6)
+	}
+	if ep.macAddress.String() != oldMac.String() {
+		t.Fatalf("Expected MAC address %s, got %s", oldMac, ep.macAddress)
+	}
+	if ep.hostName != oldHostName {
+		t.Fatalf("Expected veth name %s, got %s", oldHostName, ep.hostName)
+	}
+	if len(ep.portMapping) != len(oldMapping) {
+		t.Fatalf("Port mappings were lost on import")
+	}
+	for i, pb := range ep.portMapping {
+		if pb.HostPort != oldMapping[i].HostPort || !pb.IP.Equal(oldAddr) {
+			t.Fatalf("Unexpected port mapping %v, expected %v", pb, oldMapping[i])
+		}
+	}
+
+	// Importing again conflicts with the restored network and changes nothing
+	if err := dd.Import(context.Background(), bundle); err == nil {
+		t.Fatalf("Expected the import of existing networks to fail")
+	}
+	if _, err := dd.getNetwork("net1"); err != nil {
+		t.Fatalf("Failed import removed the existing network: %v", err)
+	}
+
+	if err := network.releasePorts(ep); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestRestoreEndpoints(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:            "restore0",
+		AllowNonDefaultBridge: true,
+		EnableUserlandProxy:   true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = getPortMapping()
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+	te = &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep2", te, epOptions); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+
+	network, err := dd.getNetwork("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := network.endpoints.get("ep1")
+	old := network.endpoints.get("ep2")
+	rec := driverapi.RestoredEndpoint{
+		NetworkID:  "net1",
+		EndpointID: "ep2",
+		Interfaces: []driverapi.RestoredInterface{{MacAddress: old.macAddress, Address: *old.addr}},
+		Options:    epOptions,
+	}
+	oldMapping := old.portMapping
+
+	// The driver loses the endpoint, its port mappings with it
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep2"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	unknown := driverapi.RestoredEndpoint{NetworkID: "net2", EndpointID: "ep3"}
+	ep1 := driverapi.RestoredEndpoint{NetworkID: "net1", EndpointID: "ep1"}
+	if err := dd.RestoreEndpoints(context.Background(), []driverapi.RestoredEndpoint{ep1, rec, unknown}); err != nil {
+		t.Fatalf("Failed to restore endpoints: %v", err)
+	}
+
+	if network.endpoints.get("ep1") != known {
+		t.Fatalf("Known endpoint was created again")
+	}
+	ep := network.endpoints.get("ep2")
+	if ep == nil {
+		t.Fatalf("Restored endpoint not found")
+	}
+	if !ep.addr.IP.Equal(rec.Interfaces[0].Address.IP) {
+		t.Fatalf("Expected address %s, got %s", rec.Interfaces[0].Address.IP, ep.addr.IP)
+	}
+	if ep.macAddress.String() != rec.Interfaces[0].MacAddress.String() {
+		t.Fatalf("Expected MAC address %s, got %s", rec.Interfaces[0].MacAddress, ep.macAddress)
+	}
+	if len(ep.portMapping) != len(oldMapping) {
+		t.Fatalf("Port mappings were not restored")
+	}
+	for i, pb := range ep.portMapping {
+		if pb.HostPort != oldMapping[i].HostPort || !pb.IP.Equal(ep.addr.IP) {
+			t.Fatalf("Unexpected port mapping %v, expected %v", pb, oldMapping[i])
+		}
+	}
+
+	if err := network.releasePorts(ep); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestSetExternalConnectivity(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		EnableUserlandProxy: true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = getPortMapping()
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+
+	network := dd.networks["net1"]
+	ep := network.endpoints.get("ep1")
+	mapping := ep.portMapping
+
+	if err := dd.SetExternalConnectivity("net1", "ep1", false); err != nil {
+		t.Fatalf("Failed to disable external connectivity: %v", err)
+	}
+	if len(ep.portMapping) != 0 {
+		t.Fatalf("Port mappings were not withdrawn: %v", ep.portMapping)
+	}
+	// Disabling twice keeps the withdrawn mappings
+	if err := dd.SetExternalConnectivity("net1", "ep1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host ports are free while withdrawn
+	bs, err := network.allocatePortsInternal(mapping, ep.addr.IP, defaultBindingIP, true)
+	if err != nil {
+		t.Fatalf("Host ports were not released: %v", err)
+	}
+	if err := network.releasePortsInternal(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dd.SetExternalConnectivity("net1", "ep1", true); err != nil {
+		t.Fatalf("Failed to enable external connectivity: %v", err)
+	}
+	if len(ep.portMapping) != len(mapping) {
+		t.Fatalf("Port mappings were not restored: %v", ep.portMapping)
+	}
+	for i, pb := range ep.portMapping {
+		if !pb.Equal(&mapping[i]) {
+			t.Fatalf("Port mapping %v restored as %v", mapping[i], pb)
+		}
+	}
+
+	if err := network.releasePorts(ep); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestIsLinked(t *testing.T) {
+	n := &bridgeNetwork{endpoints: &endpointRegistry{endpoints: map[types.UUID]*bridgeEndpoint{
+		"ep1": {id: "ep1", containerConfig: &containerConfiguration{ChildEndpoints: []string{"ep2"}}},
+		"ep2": {id: "ep2"},
+		"ep3": {id: "ep3", containerConfig: &containerConfiguration{}},
+	}}}
+
+	if !n.isLinked("ep1") || !n.isLinked("ep2") {
+		t.Fatal("Expected ep1 and ep2 to be linked")
+	}
+	if n.isLinked("ep3") {
+		t.Fatal("Unexpected link for ep3")
+	}
+}
+
 func TestCreateLinkWithOptions(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	d := newDriver()
@@ -322,7 +688,7 @@ func TestCreateLinkWithOptions(t *testing.T) {
 	netOptions := make(map[string]interface{})
 	netOptions[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("net1", netOptions)
+	err := d.CreateNetwork(context.Background(), "net1", netOptions)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
@@ -332,12 +698,12 @@ func TestCreateLinkWithOptions(t *testing.T) {
 	epOptions[netlabel.MacAddress] = mac
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("net1", "ep", te, epOptions)
+	err = d.CreateEndpoint(context.Background(), "net1", "ep", te, epOptions)
 	if err != nil {
 		t.Fatalf("Failed to create an endpoint: %s", err.Error())
 	}
 
-	err = d.Join("net1", "ep", "sbox", te, nil)
+	err = d.Join(context.Background(), "net1", "ep", "sbox", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to join the endpoint: %v", err)
 	}
@@ -382,7 +748,7 @@ func TestLinkContainers(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("net1", genericOption)
+	err := d.CreateNetwork(context.Background(), "net1", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
@@ -392,7 +758,7 @@ func TestLinkContainers(t *testing.T) {
 	epOptions[netlabel.ExposedPorts] = exposedPorts
 
 	te1 := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("net1", "ep1", te1, epOptions)
+	err = d.CreateEndpoint(context.Background(), "net1", "ep1", te1, epOptions)
 	if err != nil {
 		t.Fatalf("Failed to create an endpoint : %s", err.Error())
 	}
@@ -403,7 +769,7 @@ func TestLinkContainers(t *testing.T) {
 	}
 
 	te2 := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("net1", "ep2", te2, nil)
+	err = d.CreateEndpoint(context.Background(), "net1", "ep2", te2, nil)
 	if err != nil {
 		t.Fatalf("Failed to create an endpoint : %s", err.Error())
 	}
@@ -418,7 +784,7 @@ func TestLinkContainers(t *testing.T) {
 	genericOption = make(map[string]interface{})
 	genericOption[netlabel.GenericData] = cConfig
 
-	err = d.Join("net1", "ep2", "", te2, genericOption)
+	err = d.Join(context.Background(), "net1", "ep2", "", te2, genericOption)
 	if err != nil {
 		t.Fatalf("Failed to link ep1 and ep2")
 	}
@@ -439,7 +805,7 @@ func TestLinkContainers(t *testing.T) {
 		}
 	}
 
-	err = d.Leave("net1", "ep2")
+	err = d.Leave(context.Background(), "net1", "ep2")
 	if err != nil {
 		t.Fatalf("Failed to unlink ep1 and ep2")
 	}
@@ -466,7 +832,7 @@ func TestLinkContainers(t *testing.T) {
 	genericOption = make(map[string]interface{})
 	genericOption[netlabel.GenericData] = cConfig
 
-	err = d.Join("net1", "ep2", "", te2, genericOption)
+	err = d.Join(context.Background(), "net1", "ep2", "", te2, genericOption)
 	if err != nil {
 		out, err = iptables.Raw("-L", DockerChain)
 		for _, pm := range exposedPorts {
@@ -575,6 +941,35 @@ func TestValidateConfig(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Failed to detect invalid v6 default gateway")
 	}
+
+	// Test custom chain prefix
+	for _, prefix := range []string{"FORWARD", DockerChain, "A-LONG-PREFIX", "MY CHAIN", "-j"} {
+		c = networkConfiguration{ChainPrefix: prefix}
+		if err = c.Validate(); err == nil {
+			t.Fatalf("Failed to detect invalid chain prefix %q", prefix)
+		}
+	}
+
+	c = networkConfiguration{BridgeName: "a-br0", ChainPrefix: "TENANT"}
+	if err = c.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error on chain prefix: %v", err)
+	}
+	if name := c.chainName(); name != "TENANT-a-br0" {
+		t.Fatalf("Unexpected chain name %q", name)
+	}
+
+	o := networkConfiguration{BridgeName: "br0", ChainPrefix: "TENANT-a"}
+	if !c.Conflicts(&o) {
+		t.Fatalf("Failed to detect chain name collision")
+	}
+	o.ChainPrefix = "TENANT"
+	if c.Conflicts(&o) {
+		t.Fatalf("Unexpected chain name collision")
+	}
+	o.ChainPrefix = ""
+	if c.Conflicts(&o) {
+		t.Fatalf("Unexpected chain name collision")
+	}
 }
 
 func TestSetDefaultGw(t *testing.T) {
@@ -597,18 +992,18 @@ func TestSetDefaultGw(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep", te, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to create endpoint: %v", err)
 	}
 
-	err = d.Join("dummy", "ep", "sbox", te, nil)
+	err = d.Join(context.Background(), "dummy", "ep", "sbox", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to join endpoint: %v", err)
 	}
@@ -621,3 +1016,187 @@ func TestSetDefaultGw(t *testing.T) {
 		t.Fatalf("Failed to configure default gateway. Expected %v. Found %v", gw6, te.gw6)
 	}
 }
+
+func TestPoolStatus(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	ip, nw, _ := net.ParseCIDR("192.168.136.1/24")
+	nw.IP = ip
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		AddressIPv4:         nw,
+		EnableUserlandProxy: true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	for _, eid := range []types.UUID{"ep1", "ep2"} {
+		te := &testEndpoint{ifaces: []*testInterface{}}
+		if err := d.CreateEndpoint(context.Background(), "net1", eid, te, nil); err != nil {
+			t.Fatalf("Failed to create endpoint %s: %v", eid, err)
+		}
+	}
+	// Leave a hole between the bridge address and the second endpoint
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := dd.PoolStatus("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 {
+		t.Fatalf("Expected the IPv4 pool only, got %d pools", len(pools))
+	}
+
+	ps := pools[0]
+	if ps.Subnet.String() != "192.168.136.0/24" {
+		t.Fatalf("Unexpected subnet %s", ps.Subnet)
+	}
+	// The bridge address and ep2
+	if ps.Total != 254 || ps.Used != 2 || ps.Free != 252 {
+		t.Fatalf("Unexpected usage: %d total, %d used, %d free", ps.Total, ps.Used, ps.Free)
+	}
+	if ps.FreeRanges != 2 || ps.LargestFreeRange != 251 {
+		t.Fatalf("Unexpected free ranges: %d, largest %d", ps.FreeRanges, ps.LargestFreeRange)
+	}
+	if len(ps.TopConsumers) != 1 || ps.TopConsumers[0].Name != "ep2" || ps.TopConsumers[0].Addresses != 1 {
+		t.Fatalf("Unexpected top consumers: %v", ps.TopConsumers)
+	}
+
+	if _, err := dd.PoolStatus("net2"); err == nil {
+		t.Fatal("Expected failure for an unknown network")
+	}
+}
+
+func TestStartCapture(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		EnableUserlandProxy: true,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+
+	if _, err := dd.StartCapture("net1", "ep2", capture.Options{}); err == nil {
+		t.Fatal("Expected failure capturing on an unknown endpoint")
+	}
+
+	c, err := dd.StartCapture("net1", "ep1", capture.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the endpoint veth ends the capture
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(c)
+		done <- err
+	}()
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Expected the capture to report the endpoint removal")
+		}
+	case <-time.After(5 * time.Second):
+		c.Stop()
+		t.Fatal("Capture did not end after the endpoint removal")
+	}
+}
+
+func TestMacRanges(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &configuration{MacRanges: "02:42:ac:00:00:fe-02:42:ac:00:00:ff"}
+	if err := d.Config(genericOption); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		EnableUserlandProxy: true,
+	}
+	netOption := make(map[string]interface{})
+	netOption[netlabel.GenericData] = config
+	if err := d.CreateNetwork(context.Background(), "net1", netOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	for i, expected := range []string{"02:42:ac:00:00:fe", "02:42:ac:00:00:ff"} {
+		te := &testEndpoint{ifaces: []*testInterface{}}
+		if err := d.CreateEndpoint(context.Background(), "net1", types.UUID(fmt.Sprintf("ep%d", i)), te, nil); err != nil {
+			t.Fatalf("Failed to create an endpoint : %s", err.Error())
+		}
+		if mac := te.Interfaces()[0].MacAddress(); mac.String() != expected {
+			t.Fatalf("Expected MAC address %s, got %s", expected, mac)
+		}
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	err := d.CreateEndpoint(context.Background(), "net1", "ep2", te, nil)
+	if err == nil {
+		t.Fatal("Expected failure with the MAC range exhausted")
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep0"); err != nil {
+		t.Fatal(err)
+	}
+	te = &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep2", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint with a released MAC address: %v", err)
+	}
+	if mac := te.Interfaces()[0].MacAddress(); mac.String() != "02:42:ac:00:00:fe" {
+		t.Fatalf("Expected the released MAC address, got %s", mac)
+	}
+}
+
+func TestDriverConfigFromMap(t *testing.T) {
+	for _, data := range []map[string]interface{}{
+		{"EnableIPForwarding": "maybe"},
+		{"MacRanges": 42},
+		{"MacRanges": "02:42"},
+	} {
+		err := newDriver().Config(map[string]interface{}{netlabel.GenericData: data})
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error for driver options %v: %v", data, err)
+		}
+	}
+
+	d := newDriver().(*driver)
+	if err := d.Config(map[string]interface{}{netlabel.GenericData: map[string]interface{}{"MacRanges": "02:42:ac"}}); err != nil {
+		t.Fatal(err)
+	}
+	if d.macAllocator == nil {
+		t.Fatal("MAC allocator was not configured from the options map")
+	}
+
+	if _, err := parseNetworkOptions(options.Generic{netlabel.EnableIPv6: "true"}); err == nil {
+		t.Fatal("Network options of an invalid type were accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// hashlimitNameLen is the longest hashlimit table name older kernels accept
+const hashlimitNameLen = 15
+
+// hasConnLimits tells whether the endpoint limits the connections to its
+// published ports
+func (ec *endpointConfiguration) hasConnLimits() bool {
+	return ec != nil && (ec.ConnectionLimit != 0 || ec.SYNRateLimit != 0)
+}
+
+// limitedPorts returns the TCP container ports published by the bindings,
+// each once
+func limitedPorts(bindings []types.PortBinding) []uint16 {
+	var (
+		ports []uint16
+		seen  = map[uint16]bool{}
+	)
+	for _, b := range bindings {
+		if b.Proto != types.TCP || seen[b.Port] {
+			continue
+		}
+		seen[b.Port] = true
+		ports = append(ports, b.Port)
+	}
+	return ports
+}
+
+// connLimitRules returns the rules bounding the connections to the TCP ports
+// the endpoint publishes. The new connections beyond the limit of concurrent
+// connections are reset, the SYN packets beyond the rate are dropped. The
+// rules are inserted at the head of the FORWARD chain, ahead of the ones
+// accepting the published traffic.
+func connLimitRules(eid types.UUID, epConfig *endpointConfiguration, bindings []types.PortBinding, ip net.IP) []iptRule {
+	if !epConfig.hasConnLimits() || ip == nil {
+		return nil
+	}
+
+	var rules []iptRule
+	for _, port := range limitedPorts(bindings) {
+		match := []string{"-d", ip.String(), "-p", "tcp", "--dport", strconv.Itoa(int(port)), "--syn"}
+		if epConfig.ConnectionLimit != 0 {
+			args := append(append([]string{}, match...),
+				"-m", "connlimit", "--connlimit-above", strconv.Itoa(epConfig.ConnectionLimit), "--connlimit-mask", "0",
+				"-j", "REJECT", "--reject-with", "tcp-reset")
+			rules = append(rules, iptRule{table: iptables.Filter, chain: "FORWARD", args: args})
+		}
+		if epConfig.SYNRateLimit != 0 {
+			name := hashlimitName(eid, port)
+			args := append(append([]string{}, match...),
+				"-m", "hashlimit", "--hashlimit-above", fmt.Sprintf("%d/sec", epConfig.SYNRateLimit),
+				"--hashlimit-burst", strconv.Itoa(epConfig.SYNRateLimit), "--hashlimit-mode", "dstip,dstport",
+				"--hashlimit-name", name, "-j", "DROP")
+			rules = append(rules, iptRule{table: iptables.Filter, chain: "FORWARD", args: args})
+		}
+	}
+	return rules
+}
+
+// hashlimitName names the hashlimit table of the published port of the
+// endpoint, within the length the kernel accepts
+func hashlimitName(eid types.UUID, port uint16) string {
+	suffix := "-" + strconv.Itoa(int(port))
+	id := string(eid)
+	if max := hashlimitNameLen - len(suffix); len(id) > max {
+		id = id[:max]
+	}
+	return id + suffix
+}
+
+// programConnLimits adds or removes the connection limiting rules of the
+// endpoint. A failed addition removes the rules already added.
+func programConnLimits(eid types.UUID, epConfig *endpointConfiguration, bindings []types.PortBinding, ip net.IP, enable bool) error {
+	rules := connLimitRules(eid, epConfig, bindings, ip)
+	for i, rule := range rules {
+		if err := programChainRule(rule, "CONNECTION LIMIT", enable); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the connection limits of endpoint %s: %v", eid, err)
+				continue
+			}
+			for _, r := range rules[:i] {
+				programChainRule(r, "CONNECTION LIMIT", false)
+			}
+			return err
+		}
+	}
+	return nil
+}
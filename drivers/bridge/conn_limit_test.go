@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestConnLimitRules(t *testing.T) {
+	eid := types.UUID("4f1b3c0e9a7d2b6c5e8f0a1d3c5b7e9f")
+	ip := net.ParseIP("172.17.0.2")
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, Port: 80, HostPort: 8080},
+		{Proto: types.TCP, Port: 80, HostPort: 8081},
+		{Proto: types.UDP, Port: 53, HostPort: 5353},
+		{Proto: types.TCP, Port: 443, HostPort: 8443},
+	}
+
+	if rules := connLimitRules(eid, &endpointConfiguration{}, bindings, ip); rules != nil {
+		t.Fatalf("Unexpected rules without limits: %v", rules)
+	}
+
+	rules := connLimitRules(eid, &endpointConfiguration{ConnectionLimit: 100, SYNRateLimit: 20}, bindings, ip)
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules for the 2 TCP ports, got %d", len(rules))
+	}
+	conn := strings.Join(rules[0].args, " ")
+	if !strings.Contains(conn, "-d 172.17.0.2 -p tcp --dport 80 --syn") || !strings.Contains(conn, "--connlimit-above 100") {
+		t.Fatalf("Unexpected connection limit rule: %s", conn)
+	}
+	syn := strings.Join(rules[3].args, " ")
+	if !strings.Contains(syn, "--dport 443") || !strings.Contains(syn, "--hashlimit-above 20/sec") || !strings.Contains(syn, "-j DROP") {
+		t.Fatalf("Unexpected SYN rate limit rule: %s", syn)
+	}
+
+	for _, port := range []uint16{1, 80, 65535} {
+		if name := hashlimitName(eid, port); len(name) > hashlimitNameLen {
+			t.Fatalf("Hashlimit name %q is too long", name)
+		}
+	}
+	if hashlimitName(eid, 80) == hashlimitName(eid, 443) {
+		t.Fatal("Ports of the endpoint share their hashlimit name")
+	}
+}
+
+func TestConnLimitOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.ConnectionLimit: 10, netlabel.SYNRateLimit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.ConnectionLimit != 10 || ec.SYNRateLimit != 5 || !ec.hasConnLimits() {
+		t.Fatalf("Unexpected limits %d, %d", ec.ConnectionLimit, ec.SYNRateLimit)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.ConnectionLimit: -1}); err == nil {
+		t.Fatal("Negative connection limit was accepted")
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.SYNRateLimit: "5"}); err == nil {
+		t.Fatal("SYN rate limit of the wrong type was accepted")
+	}
+}
@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The bridge attributes of the link info data, from linux/if_link.h, which
+// the netlink package lacks
+const (
+	iflaBrForwardDelay  = 1
+	iflaBrAgeingTime    = 4
+	iflaBrStpState      = 5
+	iflaBrMcastSnooping = 23
+)
+
+// The forward delay range the kernel accepts with the spanning tree protocol
+const (
+	minForwardDelay = 2 * time.Second
+	maxForwardDelay = 30 * time.Second
+)
+
+func validateDeviceOptions(c *networkConfiguration) error {
+	if c.ForwardDelay != 0 {
+		if !c.EnableSTP {
+			return types.BadRequestErrorf("forward delay %s requires the spanning tree protocol", c.ForwardDelay)
+		}
+		if c.ForwardDelay < minForwardDelay || c.ForwardDelay > maxForwardDelay {
+			return types.BadRequestErrorf("forward delay %s is not between %s and %s", c.ForwardDelay, minForwardDelay, maxForwardDelay)
+		}
+	}
+	if c.AgeingTime < 0 {
+		return types.BadRequestErrorf("invalid ageing time %s", c.AgeingTime)
+	}
+	return nil
+}
+
+// hasDeviceOptions tells whether the network sets any of the bridge knobs
+// left to the kernel defaults otherwise
+func (c *networkConfiguration) hasDeviceOptions() bool {
+	return c.EnableSTP || c.ForwardDelay != 0 || c.AgeingTime != 0 || c.DisableMulticastSnooping
+}
+
+// setupDeviceOptions programs the spanning tree protocol, forward delay,
+// ageing time and multicast snooping of the bridge, the equivalent of
+// `ip link set <bridge> type bridge stp_state 1 forward_delay <delay> ...`.
+// The netlink package does not set the bridge attributes, hence the request
+// is built here.
+func setupDeviceOptions(config *networkConfiguration, i *bridgeInterface) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(i.Link.Attrs().Index)
+	req.AddData(msg)
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bridge"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	if config.EnableSTP {
+		nl.NewRtAttrChild(data, iflaBrStpState, nl.Uint32Attr(1))
+	}
+	if config.ForwardDelay != 0 {
+		nl.NewRtAttrChild(data, iflaBrForwardDelay, nl.Uint32Attr(clockTicks(config.ForwardDelay)))
+	}
+	if config.AgeingTime != 0 {
+		nl.NewRtAttrChild(data, iflaBrAgeingTime, nl.Uint32Attr(clockTicks(config.AgeingTime)))
+	}
+	if config.DisableMulticastSnooping {
+		nl.NewRtAttrChild(data, iflaBrMcastSnooping, nl.Uint8Attr(0))
+	}
+	req.AddData(linkInfo)
+
+	if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("could not set the options of bridge %s: %v", config.BridgeName, err)
+	}
+	return nil
+}
+
+// clockTicks converts the duration to the hundredths of second the kernel
+// takes the bridge timers in
+func clockTicks(d time.Duration) uint32 {
+	return uint32(d / (10 * time.Millisecond))
+}
@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/options"
+)
+
+func TestDeviceOptionsConfig(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromMap(map[string]interface{}{
+		"EnableSTP":                "true",
+		"ForwardDelay":             "4s",
+		"AgeingTime":               "2m",
+		"DisableMulticastSnooping": "true",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.EnableSTP || c.ForwardDelay != 4*time.Second || c.AgeingTime != 2*time.Minute || !c.DisableMulticastSnooping {
+		t.Fatalf("Unexpected bridge options: %+v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.fromMap(map[string]interface{}{"AgeingTime": "forever"}); err == nil {
+		t.Fatal("Invalid ageing time was parsed")
+	}
+
+	config, err := parseNetworkOptions(options.Generic{
+		netlabel.BridgeSTP:               true,
+		netlabel.BridgeForwardDelay:      10 * time.Second,
+		netlabel.BridgeAgeingTime:        time.Minute,
+		netlabel.BridgeMulticastSnooping: false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.EnableSTP || config.ForwardDelay != 10*time.Second || config.AgeingTime != time.Minute || !config.DisableMulticastSnooping {
+		t.Fatalf("Unexpected bridge options from labels: %+v", config)
+	}
+	if _, err := parseNetworkOptions(options.Generic{netlabel.BridgeAgeingTime: 60}); err == nil {
+		t.Fatal("Ageing time of invalid type was accepted")
+	}
+
+	for _, c := range []*networkConfiguration{
+		{ForwardDelay: 4 * time.Second},
+		{EnableSTP: true, ForwardDelay: time.Second},
+		{EnableSTP: true, ForwardDelay: time.Minute},
+		{AgeingTime: -time.Second},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Bridge options %+v were accepted", c)
+		}
+	}
+}
+
+func TestDeviceOptions(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	config := &networkConfiguration{
+		BridgeName:               "stpbr0",
+		AllowNonDefaultBridge:    true,
+		EnableSTP:                true,
+		ForwardDelay:             4 * time.Second,
+		AgeingTime:               2 * time.Minute,
+		DisableMulticastSnooping: true,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "stp", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork(context.Background(), "stp")
+
+	out, err := exec.Command("ip", "-d", "link", "show", "stpbr0").Output()
+	if err != nil {
+		t.Skipf("Cannot show the bridge details: %v", err)
+	}
+	for _, attr := range []string{"forward_delay 400 ", "ageing_time 12000 ", "stp_state 1 ", "mcast_snooping 0 "} {
+		if !strings.Contains(string(out), attr) {
+			t.Fatalf("Missing %q in the bridge details:\n%s", attr, out)
+		}
+	}
+}
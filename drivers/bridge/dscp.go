@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// maxDSCP is the largest differentiated services code point, a 6 bits field
+const maxDSCP = 63
+
+// validateDSCP checks the DSCP value fits the field, 0 being no marking
+func validateDSCP(dscp int) error {
+	if dscp < 0 || dscp > maxDSCP {
+		return types.BadRequestErrorf("invalid DSCP value %d, not in range 0-%d", dscp, maxDSCP)
+	}
+	return nil
+}
+
+// dscpRule returns the rule marking the matched traffic with the DSCP value
+// in the mangle table
+func dscpRule(chain string, match []string, dscp int, ipv6 bool) iptRule {
+	args := append(append([]string{}, match...), "-j", "DSCP", "--set-dscp", strconv.Itoa(dscp))
+	return iptRule{table: iptables.Mangle, chain: chain, preArgs: []string{"-t", "mangle"}, args: args, ipv6: ipv6}
+}
+
+// networkDSCPRules returns the rules marking the traffic the network
+// forwards out of the bridge, once per IP version
+func networkDSCPRules(config *networkConfiguration) []iptRule {
+	if config.DSCP == 0 {
+		return nil
+	}
+
+	match := []string{"-i", config.BridgeName, "!", "-o", config.BridgeName}
+	rules := []iptRule{dscpRule("FORWARD", match, config.DSCP, false)}
+	if config.EnableIPv6 {
+		rules = append(rules, dscpRule("FORWARD", match, config.DSCP, true))
+	}
+	return rules
+}
+
+// programNetworkDSCP adds or removes the DSCP marking rules of the network
+func programNetworkDSCP(config *networkConfiguration, enable bool) error {
+	for _, rule := range networkDSCPRules(config) {
+		if err := programChainRule(rule, "DSCP", enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endpointDSCPRule returns the rule marking the outbound traffic of an
+// endpoint address with the DSCP value of the endpoint. It is in the
+// POSTROUTING chain, traversed after FORWARD, so the endpoint mark wins over
+// the one of the network.
+func endpointDSCPRule(config *networkConfiguration, epConfig *endpointConfiguration, ip net.IP) (iptRule, bool) {
+	if epConfig == nil || epConfig.DSCP == 0 || ip == nil {
+		return iptRule{}, false
+	}
+	match := []string{"-s", ip.String(), "!", "-o", config.BridgeName}
+	return dscpRule("POSTROUTING", match, epConfig.DSCP, ip.To4() == nil), true
+}
+
+// programEndpointDSCP adds or removes the DSCP marking rule of the endpoint
+// address, if the endpoint sets one
+func programEndpointDSCP(config *networkConfiguration, epConfig *endpointConfiguration, ip net.IP, enable bool) error {
+	rule, ok := endpointDSCPRule(config, epConfig, ip)
+	if !ok {
+		return nil
+	}
+	return programChainRule(rule, "DSCP", enable)
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestDSCPConfig(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromMap(map[string]interface{}{"DSCP": "46"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.DSCP != 46 {
+		t.Fatalf("Unexpected DSCP value %d", c.DSCP)
+	}
+	if err := (&networkConfiguration{}).fromMap(map[string]interface{}{"DSCP": "ef"}); err == nil {
+		t.Fatal("Non numeric DSCP value was accepted")
+	}
+
+	// The marking is done by iptables
+	if err := c.Validate(); err == nil {
+		t.Fatal("DSCP marking was accepted without iptables")
+	}
+	c.EnableIPTables = true
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.DSCP = 64
+	if err := c.Validate(); err == nil {
+		t.Fatal("DSCP value out of range was accepted")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type %T: %v", err, err)
+	}
+
+	if _, err := parseNetworkOptions(map[string]interface{}{netlabel.DSCP: "46"}); err == nil {
+		t.Fatal("DSCP label of the wrong type was accepted")
+	}
+}
+
+func TestDSCPOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.EndpointDSCP: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.DSCP != 10 {
+		t.Fatalf("Unexpected DSCP value %d", ec.DSCP)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.EndpointDSCP: -1}); err == nil {
+		t.Fatal("Negative DSCP value was accepted")
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.EndpointDSCP: "10"}); err == nil {
+		t.Fatal("DSCP value of the wrong type was accepted")
+	}
+}
+
+func TestDSCPRules(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "docker0"}
+	if rules := networkDSCPRules(config); rules != nil {
+		t.Fatalf("Unexpected rules without DSCP value: %v", rules)
+	}
+
+	config.DSCP = 46
+	config.EnableIPv6 = true
+	rules := networkDSCPRules(config)
+	if len(rules) != 2 || rules[0].ipv6 || !rules[1].ipv6 {
+		t.Fatalf("Expected an IPv4 and an IPv6 rule, got %v", rules)
+	}
+	if got := strings.Join(rules[0].command(iptables.Insert), " "); got != "-t mangle -I FORWARD -i docker0 ! -o docker0 -j DSCP --set-dscp 46" {
+		t.Fatalf("Unexpected network rule: %s", got)
+	}
+
+	if _, ok := endpointDSCPRule(config, &endpointConfiguration{}, net.ParseIP("172.17.0.2")); ok {
+		t.Fatal("Unexpected endpoint rule without DSCP value")
+	}
+	epConfig := &endpointConfiguration{DSCP: 10}
+	rule, ok := endpointDSCPRule(config, epConfig, net.ParseIP("172.17.0.2"))
+	if !ok {
+		t.Fatal("Missing endpoint rule")
+	}
+	if got := strings.Join(rule.command(iptables.Insert), " "); got != "-t mangle -I POSTROUTING -s 172.17.0.2 ! -o docker0 -j DSCP --set-dscp 10" {
+		t.Fatalf("Unexpected endpoint rule: %s", got)
+	}
+	if rule, _ := endpointDSCPRule(config, epConfig, net.ParseIP("2001:db8::2")); !rule.ipv6 {
+		t.Fatal("Endpoint rule of an IPv6 address is not programmed with ip6tables")
+	}
+	if _, ok := endpointDSCPRule(config, epConfig, nil); ok {
+		t.Fatal("Unexpected endpoint rule without address")
+	}
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// endpointRegistry holds the endpoints of a bridge network. It has its own
+// lock, so that the lookups on the hot paths neither contend with each other
+// nor with the network configuration changes.
+type endpointRegistry struct {
+	sync.RWMutex
+	endpoints map[types.UUID]*bridgeEndpoint // key: endpoint id
+}
+
+func newEndpointRegistry() *endpointRegistry {
+	return &endpointRegistry{endpoints: make(map[types.UUID]*bridgeEndpoint)}
+}
+
+// get returns the endpoint with the given id, nil if there is none
+func (r *endpointRegistry) get(eid types.UUID) *bridgeEndpoint {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.endpoints[eid]
+}
+
+// add registers the endpoint, unless one with the same id already is
+func (r *endpointRegistry) add(ep *bridgeEndpoint) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.endpoints[ep.id]; ok {
+		return false
+	}
+	r.endpoints[ep.id] = ep
+	return true
+}
+
+// remove unregisters the endpoint with the given id, returning it
+func (r *endpointRegistry) remove(eid types.UUID) *bridgeEndpoint {
+	r.Lock()
+	defer r.Unlock()
+
+	ep := r.endpoints[eid]
+	delete(r.endpoints, eid)
+	return ep
+}
+
+func (r *endpointRegistry) len() int {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.endpoints)
+}
+
+// snapshot returns a copy of the registered endpoints, which the caller
+// can walk without holding the registry lock
+func (r *endpointRegistry) snapshot() map[types.UUID]*bridgeEndpoint {
+	r.RLock()
+	defer r.RUnlock()
+
+	m := make(map[types.UUID]*bridgeEndpoint, len(r.endpoints))
+	for eid, ep := range r.endpoints {
+		m[eid] = ep
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestEndpointRegistry(t *testing.T) {
+	r := newEndpointRegistry()
+
+	ep := &bridgeEndpoint{id: "ep1"}
+	if !r.add(ep) {
+		t.Fatalf("Failed to add the endpoint")
+	}
+	if r.add(&bridgeEndpoint{id: "ep1"}) {
+		t.Fatalf("Expected an endpoint with the same id to be refused")
+	}
+	if r.get("ep1") != ep || r.get("ep2") != nil || r.len() != 1 {
+		t.Fatalf("Unexpected registry content")
+	}
+
+	snap := r.snapshot()
+	r.add(&bridgeEndpoint{id: "ep2"})
+	if len(snap) != 1 || snap["ep1"] != ep {
+		t.Fatalf("Expected the snapshot not to change with the registry, got %v", snap)
+	}
+
+	if r.remove("ep1") != ep || r.get("ep1") != nil || r.len() != 1 {
+		t.Fatalf("Failed to remove the endpoint")
+	}
+	if r.remove("ep1") != nil {
+		t.Fatalf("Expected nothing to remove")
+	}
+}
+
+func newBenchRegistry(n int) (*endpointRegistry, []types.UUID) {
+	r := newEndpointRegistry()
+	ids := make([]types.UUID, n)
+	for i := range ids {
+		ids[i] = types.UUID(fmt.Sprintf("ep%d", i))
+		r.add(&bridgeEndpoint{id: ids[i]})
+	}
+	return r, ids
+}
+
+func BenchmarkEndpointRegistryGet(b *testing.B) {
+	r, ids := newBenchRegistry(1000)
+	var i uint32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.get(ids[atomic.AddUint32(&i, 1)%uint32(len(ids))])
+		}
+	})
+}
+
+func BenchmarkEndpointRegistryGetAddRemove(b *testing.B) {
+	r, ids := newBenchRegistry(1000)
+	var i uint32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := atomic.AddUint32(&i, 1)
+			if n%10 == 0 {
+				id := types.UUID(fmt.Sprintf("tmp%d", n))
+				r.add(&bridgeEndpoint{id: id})
+				r.remove(id)
+				continue
+			}
+			r.get(ids[n%uint32(len(ids))])
+		}
+	})
+}
+
+func BenchmarkEndpointRegistrySnapshot(b *testing.B) {
+	r, _ := newBenchRegistry(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.snapshot()
+	}
+}
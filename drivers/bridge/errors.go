@@ -271,6 +271,66 @@ func (action InvalidIPTablesCfgError) Error() string {
 // BadRequest denotes the type of this error
 func (action InvalidIPTablesCfgError) BadRequest() {}
 
+// InvalidChainPrefixError is returned when the custom iptables chain name prefix of a network is not valid
+type InvalidChainPrefixError string
+
+func (prefix InvalidChainPrefixError) Error() string {
+	return fmt.Sprintf("invalid iptables chain name prefix %q", string(prefix))
+}
+
+// BadRequest denotes the type of this error
+func (prefix InvalidChainPrefixError) BadRequest() {}
+
+// InvalidVethNameError is returned when the host side veth name, or name pattern, of an endpoint is not valid
+type InvalidVethNameError string
+
+func (name InvalidVethNameError) Error() string {
+	return fmt.Sprintf("invalid veth name %q", string(name))
+}
+
+// BadRequest denotes the type of this error
+func (name InvalidVethNameError) BadRequest() {}
+
+// InvalidRouteTableError is returned when the route table of a network is one reserved by the kernel
+type InvalidRouteTableError int
+
+func (table InvalidRouteTableError) Error() string {
+	return fmt.Sprintf("invalid route table %d", int(table))
+}
+
+// BadRequest denotes the type of this error
+func (table InvalidRouteTableError) BadRequest() {}
+
+// InvalidSNATAddressError is returned when the outbound NAT address of a network or an endpoint is not an IPv4 address of the SNAT pool of the network
+type InvalidSNATAddressError string
+
+func (addr InvalidSNATAddressError) Error() string {
+	return fmt.Sprintf("invalid SNAT address %s, not in the SNAT pool of the network", string(addr))
+}
+
+// BadRequest denotes the type of this error
+func (addr InvalidSNATAddressError) BadRequest() {}
+
+// InvalidSecondaryAddressError is returned when a secondary address of an endpoint is not in the subnets of the network
+type InvalidSecondaryAddressError string
+
+func (addr InvalidSecondaryAddressError) Error() string {
+	return fmt.Sprintf("invalid secondary address %s, not in the subnets of the network", string(addr))
+}
+
+// BadRequest denotes the type of this error
+func (addr InvalidSecondaryAddressError) BadRequest() {}
+
+// InvalidICMPPolicyError is returned when the ICMP policy of a network is neither allow nor deny
+type InvalidICMPPolicyError string
+
+func (policy InvalidICMPPolicyError) Error() string {
+	return fmt.Sprintf("invalid ICMP policy %q", string(policy))
+}
+
+// BadRequest denotes the type of this error
+func (policy InvalidICMPPolicyError) BadRequest() {}
+
 // IPv4AddrRangeError is returned when a valid IP address range couldn't be found.
 type IPv4AddrRangeError string
 
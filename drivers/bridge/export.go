@@ -0,0 +1,248 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// exportVersion is the version of the bundle format written by Export
+const exportVersion = 1
+
+// importIPv6Option passes the exported IPv6 address of an endpoint to
+// CreateEndpoint on import, there is no way to move it afterwards as
+// ChangeEndpointAddress does for the IPv4 one
+const importIPv6Option = netlabel.DriverPrefix + ".bridge.import_ipv6"
+
+// exportBundle is the portable form of the state of the driver
+type exportBundle struct {
+	Version  int
+	Networks []*exportedNetwork
+}
+
+type exportedNetwork struct {
+	ID        types.UUID
+	Config    *networkConfiguration
+	Endpoints []*exportedEndpoint
+}
+
+type exportedEndpoint struct {
+	Endpoint *bridgeEndpoint
+	// Disconnected is set when the external connectivity of the endpoint
+	// is disabled, its port bindings are then the withdrawn ones
+	Disconnected         bool                `json:",omitempty"`
+	WithdrawnPortMapping []types.PortBinding `json:",omitempty"`
+}
+
+// importInfo stands for the endpoint info of the imported endpoints, whose
+// interface is the exported one
+type importInfo struct{}
+
+func (ii *importInfo) Interfaces() []driverapi.InterfaceInfo {
+	return nil
+}
+
+func (ii *importInfo) AddInterface(id int, mac net.HardwareAddr, ipv4 net.IPNet, ipv6 net.IPNet) error {
+	return nil
+}
+
+// Export returns the networks and endpoints created by the driver. The
+// bridges and interfaces attached outside of the driver are left out.
+func (d *driver) Export() ([]byte, error) {
+	b := &exportBundle{Version: exportVersion}
+
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		if n.external {
+			n.Unlock()
+			continue
+		}
+		en := &exportedNetwork{ID: n.id, Config: n.config}
+		for _, ep := range n.endpoints.snapshot() {
+			if ep.external {
+				continue
+			}
+			// The endpoint is copied, its fields change under the network lock
+			cp := *ep
+			ee := &exportedEndpoint{Endpoint: &cp}
+			if ep.withdrawnPortMapping != nil {
+				ee.Disconnected = true
+				ee.WithdrawnPortMapping = ep.withdrawnPortMapping
+			}
+			en.Endpoints = append(en.Endpoints, ee)
+		}
+		n.Unlock()
+
+		sort.Sort(byEndpointID(en.Endpoints))
+		b.Networks = append(b.Networks, en)
+	}
+	sort.Sort(byNetworkID(b.Networks))
+
+	return json.Marshal(b)
+}
+
+// Import recreates the networks and endpoints of the bundle with their
+// IPv4 and IPv6 addresses, MAC addresses, veth names and host ports. The
+// links between endpoints are not restored, they are set up again when the
+// containers join. A failure removes whatever was already restored.
+func (d *driver) Import(ctx context.Context, bundle []byte) (err error) {
+	b := &exportBundle{}
+	if err = json.Unmarshal(bundle, b); err != nil {
+		return types.BadRequestErrorf("invalid bridge bundle: %v", err)
+	}
+	if b.Version != exportVersion {
+		return types.BadRequestErrorf("unsupported bridge bundle version %d", b.Version)
+	}
+
+	var (
+		networks  []types.UUID
+		endpoints [][2]types.UUID
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(endpoints) - 1; i >= 0; i-- {
+			if e := d.DeleteEndpoint(ctx, endpoints[i][0], endpoints[i][1]); e != nil {
+				logrus.Warnf("Failed to remove imported endpoint %s: %v", endpoints[i][1], e)
+			}
+		}
+		for i := len(networks) - 1; i >= 0; i-- {
+			if e := d.DeleteNetwork(ctx, networks[i]); e != nil {
+				logrus.Warnf("Failed to remove imported network %s: %v", networks[i], e)
+			}
+		}
+	}()
+
+	for _, en := range b.Networks {
+		if en.Config == nil {
+			return types.BadRequestErrorf("network %s has no configuration in the bundle", en.ID)
+		}
+		if err = d.CreateNetwork(ctx, en.ID, map[string]interface{}{netlabel.GenericData: en.Config}); err != nil {
+			return err
+		}
+		networks = append(networks, en.ID)
+
+		for _, ee := range en.Endpoints {
+			if ee.Endpoint == nil {
+				return types.BadRequestErrorf("invalid endpoint in network %s of the bundle", en.ID)
+			}
+			if err = d.importEndpoint(ctx, en.ID, ee); err != nil {
+				return err
+			}
+			endpoints = append(endpoints, [2]types.UUID{en.ID, ee.Endpoint.id})
+		}
+	}
+
+	return nil
+}
+
+// importEndpoint creates the endpoint and moves it to its exported address
+func (d *driver) importEndpoint(ctx context.Context, nid types.UUID, ee *exportedEndpoint) error {
+	ep := ee.Endpoint
+
+	// The operational bindings pin the host ports in use on the old host
+	bindings := ep.portMapping
+	if ee.Disconnected {
+		bindings = ee.WithdrawnPortMapping
+	}
+
+	epOptions := map[string]interface{}{}
+	if len(ep.macAddress) != 0 {
+		epOptions[netlabel.MacAddress] = ep.macAddress
+	}
+	if len(bindings) != 0 {
+		epOptions[netlabel.PortMap] = bindings
+	}
+	if ep.addrv6 != nil {
+		epOptions[importIPv6Option] = ep.addrv6.IP
+	}
+	// The host side veth interface keeps its name, for the monitoring and
+	// the udev rules which match it
+	if ep.hostName != "" {
+		epOptions[netlabel.VethName] = ep.hostName
+	}
+	if ep.config != nil {
+		if ep.config.Mtu != 0 {
+			epOptions[netlabel.MTU] = ep.config.Mtu
+		}
+		if ep.config.ExposedPorts != nil {
+			epOptions[netlabel.ExposedPorts] = ep.config.ExposedPorts
+		}
+		if ep.config.Mirror != nil {
+			epOptions[netlabel.Mirror] = *ep.config.Mirror
+		}
+		if ep.config.SNATAddress != nil {
+			epOptions[netlabel.SNATAddress] = ep.config.SNATAddress
+		}
+		if len(ep.config.SecondaryAddresses) != 0 {
+			epOptions[netlabel.SecondaryAddresses] = ep.config.SecondaryAddresses
+		}
+		if ep.config.ConnectionLimit != 0 {
+			epOptions[netlabel.ConnectionLimit] = ep.config.ConnectionLimit
+		}
+		if ep.config.SYNRateLimit != 0 {
+			epOptions[netlabel.SYNRateLimit] = ep.config.SYNRateLimit
+		}
+		if ep.config.DSCP != 0 {
+			epOptions[netlabel.EndpointDSCP] = ep.config.DSCP
+		}
+		if ep.config.DeferPortBinding {
+			epOptions[netlabel.DeferPortBinding] = true
+		}
+	}
+
+	if err := d.CreateEndpoint(ctx, nid, ep.id, &importInfo{}, epOptions); err != nil {
+		return err
+	}
+
+	if ep.addr != nil {
+		if _, err := d.ChangeEndpointAddress(nid, ep.id, ep.addr.IP); err != nil {
+			d.DeleteEndpoint(ctx, nid, ep.id)
+			return err
+		}
+	}
+	// The deferred bindings are created withdrawn, they are bound again if
+	// they were on the old host
+	enable := !ee.Disconnected && ep.config != nil && ep.config.DeferPortBinding
+	if ee.Disconnected || enable {
+		if err := d.SetExternalConnectivity(nid, ep.id, enable); err != nil {
+			d.DeleteEndpoint(ctx, nid, ep.id)
+			return err
+		}
+	}
+
+	// The user specified configuration is kept as it was on the old host
+	if ep.config != nil {
+		n, err := d.getNetwork(nid)
+		if err != nil {
+			return err
+		}
+		n.Lock()
+		if nep := n.endpoints.get(ep.id); nep != nil && nep.config != nil {
+			nep.config.PortBindings = ep.config.PortBindings
+			nep.config.VethName = ep.config.VethName
+		}
+		n.Unlock()
+	}
+
+	return nil
+}
+
+type byNetworkID []*exportedNetwork
+
+func (s byNetworkID) Len() int           { return len(s) }
+func (s byNetworkID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byNetworkID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+type byEndpointID []*exportedEndpoint
+
+func (s byEndpointID) Len() int           { return len(s) }
+func (s byEndpointID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byEndpointID) Less(i, j int) bool { return s[i].Endpoint.id < s[j].Endpoint.id }
@@ -0,0 +1,202 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// externalEndpointPrefix prefixes the ids of the endpoints adopted from the
+// interfaces attached to a bridge outside of the driver
+const externalEndpointPrefix = "external-"
+
+// setupVerifyExternalPool makes sure the container addresses of a network
+// attached to an existing bridge are allocated from the bridge network, as
+// the static validation could only check them against the configured
+// AddressIPv4, if any.
+func setupVerifyExternalPool(config *networkConfiguration, i *bridgeInterface) error {
+	if i.bridgeIPv4 == nil {
+		return &ErrNoIPAddr{}
+	}
+
+	if config.FixedCIDR != nil {
+		brNetLen, _ := i.bridgeIPv4.Mask.Size()
+		cnNetLen, _ := config.FixedCIDR.Mask.Size()
+		if !i.bridgeIPv4.Contains(config.FixedCIDR.IP) || brNetLen > cnNetLen {
+			return &ErrInvalidContainerSubnet{}
+		}
+	}
+
+	if config.DefaultGatewayIPv4 != nil && !i.bridgeIPv4.Contains(config.DefaultGatewayIPv4) {
+		return &ErrInvalidGateway{}
+	}
+
+	return nil
+}
+
+// externalPool returns the pool of the network the addresses of the external
+// endpoints are reserved in, so that they are not handed out to the
+// containers: the container subnet when one is configured, the bridge
+// network otherwise
+func externalPool(config *networkConfiguration, bridgeIPv4 *net.IPNet) *net.IPNet {
+	if config.FixedCIDR != nil {
+		return config.FixedCIDR
+	}
+	return bridgeIPv4
+}
+
+// reserveExternalAddresses validates the addresses of an interface attached
+// to the bridge against the pool of the network. The addresses in the bridge
+// network are returned, and the ones in the pool reserved. Either all of them
+// are reserved or none is, an address already allocated to the gateway or to
+// another endpoint failing the validation.
+func reserveExternalAddresses(config *networkConfiguration, bridgeIPv4 *net.IPNet, addrs []netlink.Addr) ([]*net.IPNet, error) {
+	pool := externalPool(config, bridgeIPv4)
+	var ips []*net.IPNet
+	for _, a := range addrs {
+		if !bridgeIPv4.Contains(a.IP) {
+			continue
+		}
+		if pool.Contains(a.IP) {
+			if _, err := ipAllocator.RequestIP(bridgeIPv4, a.IP); err != nil {
+				releaseExternalAddresses(config, bridgeIPv4, ips)
+				return nil, fmt.Errorf("address %s conflicts with the pool of the network: %v", a.IP, err)
+			}
+		}
+		ips = append(ips, &net.IPNet{IP: a.IP, Mask: bridgeIPv4.Mask})
+	}
+	return ips, nil
+}
+
+// releaseExternalAddresses gives the addresses of an external endpoint
+// reserved in the pool of the network back
+func releaseExternalAddresses(config *networkConfiguration, bridgeIPv4 *net.IPNet, ips []*net.IPNet) {
+	pool := externalPool(config, bridgeIPv4)
+	for _, ip := range ips {
+		if !pool.Contains(ip.IP) {
+			continue
+		}
+		if err := ipAllocator.ReleaseIP(bridgeIPv4, ip.IP); err != nil {
+			logrus.Warnf("Failed to release external address %s: %v", ip.IP, err)
+		}
+	}
+}
+
+// adoptExternalEndpoints registers the interfaces attached to an existing
+// bridge as external endpoints, so that their addresses in the bridge
+// network are not handed out to the containers. An interface whose
+// addresses fail the validation against the pool is not adopted.
+func (n *bridgeNetwork) adoptExternalEndpoints() error {
+	n.Lock()
+	config := n.config
+	bridgeIndex := n.bridge.Link.Attrs().Index
+	bridgeIPv4 := n.bridge.bridgeIPv4
+	n.Unlock()
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		attrs := l.Attrs()
+		if attrs.MasterIndex != bridgeIndex {
+			continue
+		}
+
+		addrs, err := netlink.AddrList(l, netlink.FAMILY_V4)
+		if err != nil {
+			logrus.Warnf("Failed to retrieve the addresses of interface %s attached to bridge: %v", attrs.Name, err)
+		}
+		ips, err := reserveExternalAddresses(config, bridgeIPv4, addrs)
+		if err != nil {
+			logrus.Warnf("Not adopting interface %s attached to bridge of network %s: %v", attrs.Name, n.id, err)
+			continue
+		}
+
+		ep := &bridgeEndpoint{
+			id:         types.UUID(externalEndpointPrefix + attrs.Name),
+			srcName:    attrs.Name,
+			macAddress: attrs.HardwareAddr,
+			config:     &endpointConfiguration{},
+			external:   true,
+		}
+		if len(ips) > 0 {
+			ep.addr = ips[0]
+			ep.secondary = ips[1:]
+		}
+
+		if n.endpoints.add(ep) {
+			logrus.Infof("Adopted interface %s attached to bridge of network %s as external endpoint", attrs.Name, n.id)
+		} else {
+			releaseExternalAddresses(config, bridgeIPv4, ips)
+		}
+	}
+
+	return nil
+}
+
+// releaseExternalEndpoints removes the external endpoints of the network and
+// releases their addresses, leaving their interfaces untouched
+func (n *bridgeNetwork) releaseExternalEndpoints() {
+	n.Lock()
+	config := n.config
+	bridgeIPv4 := n.bridge.bridgeIPv4
+	n.Unlock()
+
+	for eid, ep := range n.endpoints.snapshot() {
+		if !ep.external {
+			continue
+		}
+		releaseExternalAddresses(config, bridgeIPv4, externalAddresses(ep))
+		n.endpoints.remove(eid)
+	}
+}
+
+// externalAddresses returns the addresses of the external endpoint
+func externalAddresses(ep *bridgeEndpoint) []*net.IPNet {
+	if ep.addr == nil {
+		return nil
+	}
+	return append([]*net.IPNet{ep.addr}, ep.secondary...)
+}
+
+// ExternalEndpoints returns the interfaces attached to the bridge of the
+// network outside of the driver, which it adopted as external endpoints
+func (d *driver) ExternalEndpoints(nid types.UUID) ([]driverapi.ExternalEndpoint, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	eps := n.endpoints.snapshot()
+	var ees []driverapi.ExternalEndpoint
+	for _, eid := range sortedEndpointIDs(eps) {
+		ep := eps[eid]
+		if !ep.external {
+			continue
+		}
+		ees = append(ees, driverapi.ExternalEndpoint{
+			ID:         eid,
+			Name:       ep.srcName,
+			MacAddress: ep.macAddress,
+			Addresses:  externalAddresses(ep),
+		})
+	}
+	return ees, nil
+}
+
+// activeEndpoints returns the number of endpoints created by the driver
+func (n *bridgeNetwork) activeEndpoints() int {
+	count := 0
+	for _, ep := range n.endpoints.snapshot() {
+		if !ep.external {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,159 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func setupExternalBridge(t *testing.T, name, cidr string) netlink.Link {
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	ip, nw, _ := net.ParseCIDR(cidr)
+	nw.IP = ip
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: nw}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestExternalBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dr := d.(*driver)
+
+	br := setupExternalBridge(t, "extbr0", "172.28.0.1/24")
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "extveth0", MasterIndex: br.Attrs().Index},
+		PeerName:  "extveth1",
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.AddrAdd(veth, &netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("172.28.0.2"), Mask: net.CIDRMask(24, 32)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: "extbr0"}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "ext", genericOption); err != nil {
+		t.Fatalf("Failed to create network on existing bridge: %v", err)
+	}
+
+	n := dr.networks["ext"]
+	if !n.external {
+		t.Fatal("Network was not marked as attached to an external bridge")
+	}
+	ep := n.endpoints.get(externalEndpointPrefix + "extveth0")
+	if ep == nil || !ep.external {
+		t.Fatalf("Attached interface was not adopted: %v", ep)
+	}
+	if ep.addr == nil || !ep.addr.IP.Equal(net.ParseIP("172.28.0.2")) {
+		t.Fatalf("Unexpected address of adopted interface: %v", ep.addr)
+	}
+	if _, err := n.getEndpoint(ep.id); err == nil {
+		t.Fatal("External endpoint was expected not to be managed")
+	}
+
+	// The address of the adopted interface is not handed out
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "ext", "ep1", te, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ip := te.ifaces[0].addr.IP; !ip.Equal(net.ParseIP("172.28.0.3")) {
+		t.Fatalf("Unexpected endpoint address %s", ip)
+	}
+	if err := d.DeleteEndpoint(context.Background(), "ext", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteNetwork(context.Background(), "ext"); err != nil {
+		t.Fatalf("Failed to delete network with external endpoints: %v", err)
+	}
+	if _, err := netlink.LinkByName("extbr0"); err != nil {
+		t.Fatalf("External bridge was deleted along with the network: %v", err)
+	}
+	link, err := netlink.LinkByName("extveth0")
+	if err != nil || link.Attrs().MasterIndex != br.Attrs().Index {
+		t.Fatalf("Adopted interface was altered: %v", err)
+	}
+}
+
+func TestExternalBridgeAddressConflict(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dr := d.(*driver)
+
+	br := setupExternalBridge(t, "extbr0", "172.28.0.1/24")
+	for _, name := range []string{"extveth0", "extveth2"} {
+		veth := &netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: name, MasterIndex: br.Attrs().Index},
+			PeerName:  name + "p",
+		}
+		if err := netlink.LinkAdd(veth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	veth0, _ := netlink.LinkByName("extveth0")
+	veth2, _ := netlink.LinkByName("extveth2")
+	// The second address of extveth0 is the gateway one
+	for _, a := range []struct {
+		link netlink.Link
+		ip   string
+	}{{veth0, "172.28.0.2"}, {veth0, "172.28.0.1"}, {veth2, "172.28.0.3"}, {veth2, "172.28.0.4"}} {
+		if err := netlink.AddrAdd(a.link, &netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP(a.ip), Mask: net.CIDRMask(24, 32)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &networkConfiguration{BridgeName: "extbr0"}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "ext", genericOption); err != nil {
+		t.Fatalf("Failed to create network on existing bridge: %v", err)
+	}
+	defer d.DeleteNetwork(context.Background(), "ext")
+
+	ees, err := dr.ExternalEndpoints("ext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ees) != 1 || ees[0].Name != "extveth2" {
+		t.Fatalf("Unexpected external endpoints %v", ees)
+	}
+	if len(ees[0].Addresses) != 2 || ees[0].Addresses[1].String() != "172.28.0.4/24" {
+		t.Fatalf("Unexpected addresses of the external endpoint %v", ees[0].Addresses)
+	}
+
+	// The address of the interface which was not adopted is released
+	bridgeIPv4 := dr.networks["ext"].bridge.bridgeIPv4
+	if _, err := ipAllocator.RequestIP(bridgeIPv4, net.ParseIP("172.28.0.2")); err != nil {
+		t.Fatalf("Address of the interface not adopted was kept reserved: %v", err)
+	}
+	ipAllocator.ReleaseIP(bridgeIPv4, net.ParseIP("172.28.0.2"))
+}
+
+func TestExternalBridgeInvalidPool(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	setupExternalBridge(t, "extbr0", "172.28.0.1/24")
+
+	_, fixed, _ := net.ParseCIDR("172.29.0.0/25")
+	config := &networkConfiguration{BridgeName: "extbr0", FixedCIDR: fixed}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	err := d.CreateNetwork(context.Background(), "ext", genericOption)
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error for a pool outside of the bridge network, got %v", err)
+	}
+}
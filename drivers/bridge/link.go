@@ -14,30 +14,32 @@ type link struct {
 	childIP  string
 	ports    []types.TransportPort
 	bridge   string
+	chain    string
 }
 
 func (l *link) String() string {
 	return fmt.Sprintf("%s <-> %s [%v] on %s", l.parentIP, l.childIP, l.ports, l.bridge)
 }
 
-func newLink(parentIP, childIP string, ports []types.TransportPort, bridge string) *link {
+func newLink(parentIP, childIP string, ports []types.TransportPort, bridge, chain string) *link {
 	return &link{
 		childIP:  childIP,
 		parentIP: parentIP,
 		ports:    ports,
 		bridge:   bridge,
+		chain:    chain,
 	}
 
 }
 
 func (l *link) Enable() error {
 	// -A == iptables append flag
-	return linkContainers("-A", l.parentIP, l.childIP, l.ports, l.bridge, false)
+	return linkContainers("-A", l.parentIP, l.childIP, l.ports, l.bridge, l.chain, false)
 }
 
 func (l *link) Disable() {
 	// -D == iptables delete flag
-	err := linkContainers("-D", l.parentIP, l.childIP, l.ports, l.bridge, true)
+	err := linkContainers("-D", l.parentIP, l.childIP, l.ports, l.bridge, l.chain, true)
 	if err != nil {
 		log.Errorf("Error removing IPTables rules for a link %s due to %s", l.String(), err.Error())
 	}
@@ -45,7 +47,7 @@ func (l *link) Disable() {
 	// that returns typed errors
 }
 
-func linkContainers(action, parentIP, childIP string, ports []types.TransportPort, bridge string,
+func linkContainers(action, parentIP, childIP string, ports []types.TransportPort, bridge, chainName string,
 	ignoreErrors bool) error {
 	var nfAction iptables.Action
 
@@ -69,7 +71,7 @@ func linkContainers(action, parentIP, childIP string, ports []types.TransportPor
 		return InvalidLinkIPAddrError(childIP)
 	}
 
-	chain := iptables.Chain{Name: DockerChain, Bridge: bridge}
+	chain := iptables.Chain{Name: chainName, Bridge: bridge}
 	for _, port := range ports {
 		err := chain.Link(nfAction, ip1, ip2, int(port.Port), port.Proto.String())
 		if !ignoreErrors && err != nil {
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// Mirror devices are named after the endpoint they serve, so that they can
+// be found again on endpoint delete.
+const mirrorDevPrefix = "brm-"
+
+func mirrorDevName(eid types.UUID) string {
+	id := string(eid)
+	if len(id) > 11 {
+		id = id[:11]
+	}
+	return mirrorDevPrefix + id
+}
+
+// erspanArgs returns the ip command arguments creating the ERSPAN tunnel
+// toward the remote collector.
+func erspanArgs(name string, m *types.MirrorConfig) []string {
+	kind := "erspan"
+	if m.Remote.To4() == nil {
+		kind = "ip6erspan"
+	}
+
+	args := []string{"link", "add", name, "type", kind, "remote", m.Remote.String()}
+	if len(m.Local) != 0 {
+		args = append(args, "local", m.Local.String())
+	}
+	sid := strconv.Itoa(int(m.SessionID))
+	return append(args, "seq", "key", sid, "erspan_ver", "1", "erspan", sid)
+}
+
+// mirredArgs returns the tc command arguments copying the traffic entering
+// and leaving hostIface to the target interface. The traffic entering the
+// host side of the veth pair is the traffic sent by the container.
+func mirredArgs(hostIface, target string) [][]string {
+	action := []string{"protocol", "all", "u32", "match", "u32", "0", "0", "action", "mirred", "egress", "mirror", "dev", target}
+	return [][]string{
+		{"qdisc", "add", "dev", hostIface, "ingress"},
+		append([]string{"filter", "add", "dev", hostIface, "parent", "ffff:"}, action...),
+		{"qdisc", "add", "dev", hostIface, "handle", "1:", "root", "prio"},
+		append([]string{"filter", "add", "dev", hostIface, "parent", "1:"}, action...),
+	}
+}
+
+func runCmd(name string, args ...string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("%s is required for traffic mirroring: %v", name, err)
+	}
+	if out, err := exec.Command(path, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %v failed: %s (%v)", name, args, out, err)
+	}
+	return nil
+}
+
+// setupMirror copies the traffic of the endpoint whose host side veth is
+// hostIface to the configured mirror target.
+func setupMirror(eid types.UUID, hostIface string, m *types.MirrorConfig) (err error) {
+	target := m.Interface
+	if len(m.Remote) != 0 {
+		target = mirrorDevName(eid)
+		if err = runCmd("ip", erspanArgs(target, m)...); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				teardownMirror(eid, m)
+			}
+		}()
+
+		var link netlink.Link
+		if link, err = netlink.LinkByName(target); err != nil {
+			return err
+		}
+		if err = netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+	} else if _, err = netlink.LinkByName(target); err != nil {
+		return types.BadRequestErrorf("mirror target interface %s not found: %v", target, err)
+	}
+
+	// The qdiscs go away together with the veth pair, so there is nothing
+	// to roll back on the host interface.
+	for _, args := range mirredArgs(hostIface, target) {
+		if err = runCmd("tc", args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// teardownMirror removes the ERSPAN tunnel created for the endpoint, if any.
+func teardownMirror(eid types.UUID, m *types.MirrorConfig) {
+	if len(m.Remote) == 0 {
+		return
+	}
+
+	link, err := netlink.LinkByName(mirrorDevName(eid))
+	if err != nil {
+		return
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		logrus.Warnf("Failed to remove mirror device of endpoint %s: %v", eid, err)
+	}
+}
@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestMirrorDevName(t *testing.T) {
+	if name := mirrorDevName("ep1"); name != "brm-ep1" {
+		t.Fatalf("Unexpected mirror device name %s", name)
+	}
+	if name := mirrorDevName("0123456789abcdef0123"); len(name) > 15 {
+		t.Fatalf("Mirror device name %s exceeds the interface name length", name)
+	}
+}
+
+func TestErspanArgs(t *testing.T) {
+	m := &types.MirrorConfig{Remote: net.ParseIP("10.0.0.2"), Local: net.ParseIP("10.0.0.1"), SessionID: 7}
+	expected := []string{"link", "add", "brm-ep1", "type", "erspan", "remote", "10.0.0.2", "local", "10.0.0.1",
+		"seq", "key", "7", "erspan_ver", "1", "erspan", "7"}
+	if args := erspanArgs("brm-ep1", m); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Unexpected arguments %v", args)
+	}
+
+	m = &types.MirrorConfig{Remote: net.ParseIP("2001:db8::2")}
+	if args := erspanArgs("brm-ep1", m); args[4] != "ip6erspan" {
+		t.Fatalf("Expected an IPv6 tunnel, got %v", args)
+	}
+}
+
+func TestMirredArgs(t *testing.T) {
+	cmds := mirredArgs("veth0", "eth1")
+	if len(cmds) != 4 {
+		t.Fatalf("Expected 4 tc commands, got %d", len(cmds))
+	}
+	for _, i := range []int{1, 3} {
+		if last := cmds[i][len(cmds[i])-1]; last != "eth1" {
+			t.Fatalf("Filter %v does not mirror to the target", cmds[i])
+		}
+	}
+}
+
+func TestParseMirrorOption(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.Mirror: types.MirrorConfig{Interface: "eth1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.Mirror == nil || ec.Mirror.Interface != "eth1" {
+		t.Fatalf("Unexpected mirror configuration %v", ec.Mirror)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.Mirror: types.MirrorConfig{}}); err == nil {
+		t.Fatal("Expected failure for a mirror without target")
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.Mirror: "eth1"}); err == nil {
+		t.Fatal("Expected failure for a malformed mirror option")
+	}
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// setupNDPProxy enables the NDP proxy on the interface through which the
+// network is reached, so that the router on that link resolves the endpoint
+// IPv6 addresses to the host. The setting is left in place when the network
+// is removed, as other networks may rely on it.
+func setupNDPProxy(config *networkConfiguration, i *bridgeInterface) error {
+	if _, err := netlink.LinkByName(config.NDPProxyInterface); err != nil {
+		return fmt.Errorf("could not find NDP proxy interface %s: %v", config.NDPProxyInterface, err)
+	}
+
+	procFile := "/proc/sys/net/ipv6/conf/" + config.NDPProxyInterface + "/proxy_ndp"
+	if err := ioutil.WriteFile(procFile, []byte{'1', '\n'}, ipv6ForwardConfPerm); err != nil {
+		return fmt.Errorf("Unable to enable the NDP proxy on %s: %v", config.NDPProxyInterface, err)
+	}
+
+	return nil
+}
+
+// programNDPProxy adds or removes the proxy neighbor entry answering the
+// neighbor solicitations for ip on the interface, the equivalent of
+// `ip -6 neigh add proxy <ip> dev <iface>`. The netlink package always
+// sends a link layer address, which the kernel rejects for proxy entries,
+// hence the request is built here.
+func programNDPProxy(iface string, ip net.IP, add bool) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return fmt.Errorf("could not find NDP proxy interface %s: %v", iface, err)
+	}
+
+	proto, flags := syscall.RTM_DELNEIGH, syscall.NLM_F_ACK
+	if add {
+		proto, flags = syscall.RTM_NEWNEIGH, flags|syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE
+	}
+
+	req := nl.NewNetlinkRequest(proto, flags)
+	req.AddData(&netlink.Ndmsg{
+		Family: syscall.AF_INET6,
+		Index:  uint32(link.Attrs().Index),
+		Flags:  netlink.NTF_PROXY,
+	})
+	req.AddData(nl.NewRtAttr(netlink.NDA_DST, ip.To16()))
+
+	if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("could not program the NDP proxy entry for %s on %s: %v", ip, iface, err)
+	}
+	return nil
+}
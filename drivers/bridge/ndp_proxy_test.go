@@ -0,0 +1,28 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+)
+
+func TestNDPProxy(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	ip := net.ParseIP("2001:db8::2")
+	if err := programNDPProxy("lo", ip, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := programNDPProxy("lo", ip, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := programNDPProxy("lo", ip, false); err == nil {
+		t.Fatal("Expected the removal of a missing proxy entry to fail")
+	}
+
+	config := &networkConfiguration{BridgeName: "br-ndp", NDPProxyInterface: "eth0"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected the NDP proxy to require IPv6")
+	}
+}
@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"context"
 	"testing"
 
 	"github.com/docker/libnetwork/driverapi"
@@ -23,13 +24,13 @@ func TestLinkCreate(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "", te, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "", te, nil)
 	if err != nil {
 		if _, ok := err.(InvalidEndpointIDError); !ok {
 			t.Fatalf("Failed with a wrong error :%s", err.Error())
@@ -39,12 +40,12 @@ func TestLinkCreate(t *testing.T) {
 	}
 
 	// Good endpoint creation
-	err = d.CreateEndpoint("dummy", "ep", te, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a link: %s", err.Error())
 	}
 
-	err = d.Join("dummy", "ep", "sbox", te, nil)
+	err = d.Join(context.Background(), "dummy", "ep", "sbox", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a link: %s", err.Error())
 	}
@@ -61,7 +62,7 @@ func TestLinkCreate(t *testing.T) {
 	// then we could check the MTU on hostLnk as well.
 
 	te1 := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep", te1, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te1, nil)
 	if err == nil {
 		t.Fatalf("Failed to detect duplicate endpoint id on same network")
 	}
@@ -114,19 +115,19 @@ func TestLinkCreateTwo(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te1 := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep", te1, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te1, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a link: %s", err.Error())
 	}
 
 	te2 := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep", te2, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te2, nil)
 	if err != nil {
 		if _, ok := err.(driverapi.ErrEndpointExists); !ok {
 			t.Fatalf("Failed with a wrong error: %s", err.Error())
@@ -145,13 +146,13 @@ func TestLinkCreateNoEnableIPv6(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep", te, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a link: %s", err.Error())
 	}
@@ -176,18 +177,18 @@ func TestLinkDelete(t *testing.T) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = config
 
-	err := d.CreateNetwork("dummy", genericOption)
+	err := d.CreateNetwork(context.Background(), "dummy", genericOption)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep1", te, nil)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep1", te, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a link: %s", err.Error())
 	}
 
-	err = d.DeleteEndpoint("dummy", "")
+	err = d.DeleteEndpoint(context.Background(), "dummy", "")
 	if err != nil {
 		if _, ok := err.(InvalidEndpointIDError); !ok {
 			t.Fatalf("Failed with a wrong error :%s", err.Error())
@@ -196,7 +197,7 @@ func TestLinkDelete(t *testing.T) {
 		t.Fatalf("Failed to detect invalid config")
 	}
 
-	err = d.DeleteEndpoint("dummy", "ep1")
+	err = d.DeleteEndpoint(context.Background(), "dummy", "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,186 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sysctl"
+	"github.com/docker/libnetwork/types"
+)
+
+// The performance profiles an endpoint can select
+const (
+	// PerfProfileLatency favors the latency of each packet over the
+	// throughput, for request-response workloads
+	PerfProfileLatency = "latency"
+	// PerfProfileThroughput favors the throughput over the latency, for bulk
+	// transfers
+	PerfProfileThroughput = "throughput"
+)
+
+// The host wide busy polling parameters, in microseconds
+const (
+	busyPollParam = "net/core/busy_poll"
+	busyReadParam = "net/core/busy_read"
+)
+
+// perfProfile is the tuning of the veth pair of an endpoint for a kind of
+// workload
+type perfProfile struct {
+	// gro and tso enable the generic receive and TCP segmentation offloads
+	gro bool
+	tso bool
+	// txQueueLen is the transmit queue length of the interfaces
+	txQueueLen int
+	// spread steers the receive processing (RPS) and the transmit queue
+	// selection (XPS) of the interfaces to all the CPUs, unless the
+	// endpoint gives its own CPUs
+	spread bool
+	// busyPoll is the time the sockets busy poll the device queues for
+	busyPoll int
+}
+
+var perfProfiles = map[string]perfProfile{
+	PerfProfileLatency:    {txQueueLen: 100, busyPoll: 50},
+	PerfProfileThroughput: {gro: true, tso: true, txQueueLen: 10000, spread: true},
+}
+
+func validatePerfProfile(ec *endpointConfiguration) error {
+	if ec.PerfProfile != "" {
+		if _, ok := perfProfiles[ec.PerfProfile]; !ok {
+			return types.BadRequestErrorf("unknown performance profile %q", ec.PerfProfile)
+		}
+	}
+	if ec.PerfCPUs == "" {
+		return nil
+	}
+	if ec.PerfProfile == "" {
+		return types.BadRequestErrorf("CPU mask %s requires a performance profile", ec.PerfCPUs)
+	}
+	for _, w := range strings.Split(ec.PerfCPUs, ",") {
+		if _, err := strconv.ParseUint(w, 16, 32); err != nil {
+			return types.BadRequestErrorf("invalid CPU mask %s", ec.PerfCPUs)
+		}
+	}
+	return nil
+}
+
+// cpuMask returns the mask of the first n CPUs in the format of the queue
+// attributes of sysfs: hexadecimal 32 bits words separated by commas, the
+// most significant first
+func cpuMask(n int) string {
+	var words []string
+	for ; n > 0; n -= 32 {
+		w := uint32(0xffffffff)
+		if n < 32 {
+			w = 1<<uint(n) - 1
+		}
+		words = append([]string{strconv.FormatUint(uint64(w), 16)}, words...)
+	}
+	return strings.Join(words, ",")
+}
+
+// applyPerfProfile tunes the host and sandbox side interfaces of the endpoint
+// for its performance profile. The sandbox side interface is tuned before it
+// is moved into the sandbox, the settings go along with it.
+func applyPerfProfile(ec *endpointConfiguration, names ...string) error {
+	if ec == nil || ec.PerfProfile == "" {
+		return nil
+	}
+	p := perfProfiles[ec.PerfProfile]
+
+	mask := ec.PerfCPUs
+	if mask == "" && p.spread {
+		mask = cpuMask(runtime.NumCPU())
+	}
+	for _, name := range names {
+		if err := writeLinkAttr(name, "tx_queue_len", strconv.Itoa(p.txQueueLen)); err != nil {
+			return err
+		}
+		if err := setOffload(name, netutils.EthtoolSGRO, p.gro); err != nil {
+			return err
+		}
+		if err := setOffload(name, netutils.EthtoolSTSO, p.tso); err != nil {
+			return err
+		}
+		if mask == "" {
+			continue
+		}
+		if err := writeQueueAttr(name, "rx-*", "rps_cpus", mask); err != nil {
+			return err
+		}
+		if err := writeQueueAttr(name, "tx-*", "xps_cpus", mask); err != nil {
+			return err
+		}
+	}
+
+	if p.busyPoll != 0 {
+		raiseBusyPoll(p.busyPoll)
+	}
+	return nil
+}
+
+func writeLinkAttr(name, attr, value string) error {
+	path := filepath.Join("/sys/class/net", name, attr)
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("could not set %s of %s to %s: %v", attr, name, value, err)
+	}
+	return nil
+}
+
+// writeQueueAttr sets the attribute of each queue of the interface matching
+// the pattern, rx-* or tx-*
+func writeQueueAttr(name, queues, attr, value string) error {
+	paths, err := filepath.Glob(filepath.Join("/sys/class/net", name, "queues", queues, attr))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("could not set %s of %s: the kernel does not support it", attr, name)
+	}
+	for _, path := range paths {
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			return fmt.Errorf("could not set %s of %s to %s: %v", attr, name, value, err)
+		}
+	}
+	return nil
+}
+
+// setOffload turns the offload of the interface set by the ethtool command
+// on or off, the equivalent of `ethtool -K <name> gro on`
+func setOffload(name string, cmd uint32, on bool) error {
+	var value uint32
+	if on {
+		value = 1
+	}
+	if err := netutils.EthtoolSet(name, cmd, value); err != nil {
+		return fmt.Errorf("could not set the offload %#x of %s: %v", cmd, name, err)
+	}
+	return nil
+}
+
+// raiseBusyPoll raises the host busy polling times to at least usecs. The
+// kernel only has host wide parameters, which are never lowered, as other
+// workloads may rely on them.
+func raiseBusyPoll(usecs int) {
+	m := sysctl.Default()
+	for _, name := range []string{busyPollParam, busyReadParam} {
+		cur, err := m.Get(name)
+		if err != nil {
+			logrus.Debugf("Could not read %s: %v", name, err)
+			continue
+		}
+		if v, err := strconv.Atoi(cur); err == nil && v >= usecs {
+			continue
+		}
+		if err := m.Set(name, strconv.Itoa(usecs)); err != nil {
+			logrus.Warnf("Could not raise %s to %d: %v", name, usecs, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestPerfProfileConfig(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.PerfProfile: PerfProfileThroughput,
+		netlabel.PerfCPUs:    "f,ffffffff",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.PerfProfile != PerfProfileThroughput || ec.PerfCPUs != "f,ffffffff" {
+		t.Fatalf("Unexpected profile %q CPUs %q", ec.PerfProfile, ec.PerfCPUs)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.PerfProfile: "fast"},
+		{netlabel.PerfProfile: PerfProfileLatency, netlabel.PerfCPUs: "0xg"},
+		{netlabel.PerfCPUs: "3"},
+	} {
+		_, err := parseEndpointOptions(opts)
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected options %v to be refused, got %v", opts, err)
+		}
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.PerfProfile: 1}); err == nil {
+		t.Fatal("Non string profile was accepted")
+	}
+}
+
+func TestCPUMask(t *testing.T) {
+	for n, expected := range map[int]string{
+		1:  "1",
+		4:  "f",
+		32: "ffffffff",
+		36: "f,ffffffff",
+		64: "ffffffff,ffffffff",
+	} {
+		if m := cpuMask(n); m != expected {
+			t.Fatalf("Expected the mask of %d CPUs to be %s, got %s", n, expected, m)
+		}
+	}
+}
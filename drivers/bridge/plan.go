@@ -0,0 +1,274 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// PlanNetwork validates the network options against the host and the other
+// networks, and reports the bridge, the addresses and the iptables rules
+// CreateNetwork would program for them.
+func (d *driver) PlanNetwork(nid types.UUID, option map[string]interface{}) (*driverapi.Plan, error) {
+	d.Lock()
+	_, ok := d.networks[nid]
+	d.Unlock()
+	if ok {
+		return nil, types.ForbiddenErrorf("network %s exists", nid)
+	}
+
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return nil, err
+	}
+	networkList := d.getNetworks()
+	for _, nw := range networkList {
+		nw.Lock()
+		nwConfig := nw.config
+		nw.Unlock()
+		if nwConfig.Conflicts(config) {
+			return nil, types.ForbiddenErrorf("conflicts with network %s (%s)", nw.id, nw.config.BridgeName)
+		}
+	}
+
+	// The bridge is only looked up here
+	bridgeIface := newInterface(config)
+	if err := config.conflictsWithNetworks(nid, networkList); err != nil {
+		return nil, err
+	}
+
+	plan := &driverapi.Plan{}
+	if !bridgeIface.exists() {
+		if config.BridgeName != DefaultBridgeName && !config.AllowNonDefaultBridge {
+			return nil, NonDefaultBridgeExistError(config.BridgeName)
+		}
+		plan.Devices = append(plan.Devices, config.BridgeName)
+	} else {
+		addrv4, _, err := bridgeIface.addresses()
+		if err != nil {
+			return nil, err
+		}
+		if addrv4.IPNet != nil {
+			if config.AddressIPv4 != nil && !addrv4.IP.Equal(config.AddressIPv4.IP) {
+				return nil, &IPv4AddrNoMatchError{IP: addrv4.IP, CfgIP: config.AddressIPv4.IP}
+			}
+			bridgeIface.bridgeIPv4 = addrv4.IPNet
+		} else if config.BridgeName != DefaultBridgeName && !config.AllowNonDefaultBridge {
+			return nil, NonDefaultBridgeExistError(config.BridgeName)
+		}
+	}
+
+	if bridgeIface.bridgeIPv4 == nil {
+		if bridgeIface.bridgeIPv4, err = electBridgeIPv4(config); err != nil {
+			return nil, err
+		}
+		plan.Addresses = append(plan.Addresses, bridgeIface.bridgeIPv4)
+	}
+	if err := setupVerifyExternalPool(config, bridgeIface); err != nil {
+		return nil, err
+	}
+	if config.EnableIPv6 {
+		plan.Addresses = append(plan.Addresses, bridgeIPv6)
+	}
+
+	if config.EnableIPTables {
+		plan.Rules = networkRules(config, bridgeIface.bridgeIPv4)
+	}
+
+	return plan, nil
+}
+
+// networkRules returns the iptables rules programmed for the network
+func networkRules(config *networkConfiguration, bridgeIPv4 *net.IPNet) []string {
+	var rules [][]string
+	for _, r := range desiredNetworkRules(config, bridgeIPv4) {
+		rules = append(rules, planCommand(r.rule, r.op))
+	}
+
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		c := &iptables.Chain{Name: config.chainName(), Bridge: config.BridgeName, Table: table, HairpinMode: !config.EnableUserlandProxy}
+		rules = append(rules, c.SetupRules()...)
+	}
+
+	return joinRules(rules)
+}
+
+// PlanEndpoint validates the endpoint options and reports the interfaces,
+// the addresses and the port mapping rules CreateEndpoint would program for
+// them. The host ports which are not requested are allocated on creation,
+// they are reported as port 0.
+func (d *driver) PlanEndpoint(nid, eid types.UUID, epOptions map[string]interface{}) (*driverapi.Plan, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	degraded := n.degraded
+	config := n.config
+	bridgeIPv4 := n.bridge.bridgeIPv4
+	bridgeIPv6 := n.bridge.bridgeIPv6
+	n.Unlock()
+
+	if degraded != "" {
+		degraded = n.checkRecovered()
+	}
+	if degraded != "" {
+		return nil, types.ForbiddenErrorf("network %s is degraded: %s", nid, degraded)
+	}
+
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep != nil {
+		return nil, driverapi.ErrEndpointExists(eid)
+	}
+
+	epConfig, err := parseEndpointOptions(epOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &driverapi.Plan{
+		Devices: []string{fmt.Sprintf("veth pair attached to bridge %s", config.BridgeName)},
+	}
+
+	ip4, err := ipAllocator.PeekIP(bridgeIPv4)
+	if err != nil {
+		return nil, err
+	}
+	plan.Addresses = append(plan.Addresses, &net.IPNet{IP: ip4, Mask: bridgeIPv4.Mask})
+
+	if config.EnableIPv6 {
+		network := bridgeIPv6
+		if config.FixedCIDRv6 != nil {
+			network = config.FixedCIDRv6
+		}
+		// The MAC address based IPv6 address is only known in advance
+		// when the MAC address is not allocated from the ranges
+		var ip6 net.IP
+		d.Lock()
+		ma := d.macAllocator
+		d.Unlock()
+		if ones, _ := network.Mask.Size(); ones <= 80 && ma == nil {
+			mac := generateMacAddr(ip4)
+			if epConfig != nil && epConfig.MacAddress != nil {
+				mac = epConfig.MacAddress
+			}
+			ip6 = make(net.IP, len(network.IP))
+			copy(ip6, network.IP)
+			for i, h := range mac {
+				ip6[i+10] = h
+			}
+		} else if ip6, err = ipAllocator.PeekIP(network); err != nil {
+			return nil, err
+		}
+		plan.Addresses = append(plan.Addresses, &net.IPNet{IP: ip6, Mask: network.Mask})
+	}
+
+	if epConfig != nil {
+		for _, ip := range epConfig.SecondaryAddresses {
+			pool, err := secondaryPool(config, n.bridge, ip)
+			if err != nil {
+				return nil, err
+			}
+			plan.Addresses = append(plan.Addresses, &net.IPNet{IP: ip, Mask: pool.Mask})
+		}
+	}
+
+	if config.EnableIPTables && epConfig != nil {
+		if epConfig.SNATAddress != nil && !config.inSNATPool(epConfig.SNATAddress) {
+			return nil, InvalidSNATAddressError(epConfig.SNATAddress.String())
+		}
+		rules, err := portMappingRules(config, epConfig.PortBindings, ip4)
+		if err != nil {
+			return nil, err
+		}
+		if rule, ok := endpointSNATRule(config, epConfig, &net.IPNet{IP: ip4, Mask: bridgeIPv4.Mask}); ok {
+			rules = append(rules, joinRules([][]string{rule.command(iptables.Insert)})...)
+		}
+		for _, rule := range connLimitRules(eid, epConfig, epConfig.PortBindings, ip4) {
+			rules = append(rules, joinRules([][]string{rule.command(iptables.Insert)})...)
+		}
+		if rule, ok := endpointDSCPRule(config, epConfig, ip4); ok {
+			rules = append(rules, joinRules([][]string{rule.command(iptables.Insert)})...)
+		}
+		plan.Rules = rules
+	}
+
+	return plan, nil
+}
+
+// portMappingRules returns the iptables rules forwarding the IPv4 host
+// ports of the bindings to the container address
+func portMappingRules(config *networkConfiguration, bindings []types.PortBinding, containerIP net.IP) ([]string, error) {
+	rules, err := portMappingCommands(config, bindings, containerIP)
+	if err != nil {
+		return nil, err
+	}
+	return joinRules(rules), nil
+}
+
+// portMappingCommands returns the iptables commands programming the rules
+// returned by portMappingRules
+func portMappingCommands(config *networkConfiguration, bindings []types.PortBinding, containerIP net.IP) ([][]string, error) {
+	var rules [][]string
+
+	chain := &iptables.Chain{Name: config.chainName(), Bridge: config.BridgeName, Table: iptables.Nat, HairpinMode: !config.EnableUserlandProxy}
+	for _, b := range bindings {
+		if b.PassSocket || b.HostIPv6Only {
+			continue
+		}
+
+		hostIPs := b.HostIPs
+		if len(hostIPs) == 0 {
+			hostIP := b.HostIP
+			if b.HostIface != "" {
+				var err error
+				if hostIP, err = hostIfaceAddr(b.HostIface, false); err != nil {
+					return nil, err
+				}
+			}
+			if len(hostIP) == 0 {
+				hostIP = defaultBindingIP
+				if config.DefaultBindingIP != nil {
+					hostIP = config.DefaultBindingIP
+				}
+			}
+			hostIPs = []net.IP{hostIP}
+		}
+
+		for _, hostIP := range hostIPs {
+			if hostIP.To4() == nil {
+				continue
+			}
+			rules = append(rules, chain.ForwardRules(iptables.Append, hostIP, int(b.HostPort), b.Proto.String(), containerIP.String(), int(b.Port))...)
+		}
+	}
+
+	return rules, nil
+}
+
+// planCommand returns the rule in the command line syntax for the plan.
+// The -6 marker of the IPv6 rules is no iptables option, they are reported
+// as ip6tables command lines instead.
+func planCommand(r iptRule, op iptables.Action) []string {
+	cmd := r.command(op)
+	if r.ipv6 {
+		cmd[0] = "ip6tables"
+	}
+	return cmd
+}
+
+func joinRules(rules [][]string) []string {
+	ls := make([]string, 0, len(rules))
+	for _, r := range rules {
+		ls = append(ls, strings.Join(r, " "))
+	}
+	return ls
+}
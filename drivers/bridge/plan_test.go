@@ -0,0 +1,159 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestPlanNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver().(*driver)
+
+	_, addr, _ := net.ParseCIDR("172.30.0.1/16")
+	addr.IP = net.ParseIP("172.30.0.1")
+	config := &networkConfiguration{
+		BridgeName:            "plannedbr0",
+		AddressIPv4:           addr,
+		EnableIPTables:        true,
+		EnableIPMasquerade:    true,
+		EnableICC:             true,
+		AllowNonDefaultBridge: true,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+
+	plan, err := d.PlanNetwork("planned", genericOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Devices) != 1 || plan.Devices[0] != "plannedbr0" {
+		t.Fatalf("Unexpected planned devices: %v", plan.Devices)
+	}
+	if len(plan.Addresses) != 1 || plan.Addresses[0].String() != "172.30.0.1/16" {
+		t.Fatalf("Unexpected planned addresses: %v", plan.Addresses)
+	}
+	expected := "-t nat -I POSTROUTING -s 172.30.0.1/16 ! -o plannedbr0 -j MASQUERADE"
+	if len(plan.Rules) == 0 || plan.Rules[0] != expected {
+		t.Fatalf("Expected first rule %q, got %v", expected, plan.Rules)
+	}
+	if !containsRule(plan.Rules, "-A FORWARD -i plannedbr0 -o plannedbr0 -j ACCEPT") {
+		t.Fatalf("Missing ICC rule in %v", plan.Rules)
+	}
+
+	config.EnableIPv6 = true
+	config.ICMPv6Policy = ICMPDeny
+	if plan, err = d.PlanNetwork("planned", genericOption); err != nil {
+		t.Fatal(err)
+	}
+	if !containsRule(plan.Rules, "ip6tables -I FORWARD -i plannedbr0 -o plannedbr0 -p ipv6-icmp -j DROP") {
+		t.Fatalf("Missing ICMPv6 rule in %v", plan.Rules)
+	}
+	for _, r := range plan.Rules {
+		if strings.HasPrefix(r, "-6") {
+			t.Fatalf("Unexpected -6 marker in rule %q", r)
+		}
+	}
+	config.EnableIPv6 = false
+	config.ICMPv6Policy = ""
+
+	if _, err := netlink.LinkByName("plannedbr0"); err == nil {
+		t.Fatal("Bridge was created by the dry run")
+	}
+	if len(d.networks) != 0 {
+		t.Fatal("Network was recorded by the dry run")
+	}
+
+	_, fixed, _ := net.ParseCIDR("10.10.0.0/24")
+	config.FixedCIDR = fixed
+	if _, err := d.PlanNetwork("planned", genericOption); err == nil {
+		t.Fatal("Invalid container subnet was not detected")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type for invalid container subnet: %v", err)
+	}
+}
+
+func TestPlanEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver().(*driver)
+
+	config := &networkConfiguration{BridgeName: DefaultBridgeName}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := d.PlanEndpoint("net1", "ep1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Addresses) != 1 {
+		t.Fatalf("Unexpected planned addresses: %v", plan.Addresses)
+	}
+	ip := plan.Addresses[0].IP.String()
+
+	after, err := netlink.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(links) {
+		t.Fatal("Interfaces were created by the dry run")
+	}
+
+	// The planned address is the one the endpoint gets
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, nil); err != nil {
+		t.Fatal(err)
+	}
+	if te.ifaces[0].addr.IP.String() != ip {
+		t.Fatalf("Endpoint got address %s, dry run planned %s", te.ifaces[0].addr.IP, ip)
+	}
+
+	if _, err := d.PlanEndpoint("net1", "ep1", nil); err == nil {
+		t.Fatal("Existing endpoint was not detected")
+	}
+}
+
+func TestPortMappingRules(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "br0", EnableUserlandProxy: true}
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, Port: 80, HostPort: 8080},
+		{Proto: types.UDP, Port: 53, HostPort: 5353, HostIP: net.ParseIP("192.168.1.1")},
+		{Proto: types.TCP, Port: 443, HostPort: 443, HostIPv6Only: true},
+	}
+
+	rules, err := portMappingRules(config, bindings, net.ParseIP("172.17.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The IPv6 only binding is served by the proxy alone
+	if len(rules) != 6 {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+	expected := "-t nat -A DOCKER -p tcp -d 0/0 --dport 8080 -j DNAT --to-destination 172.17.0.2:80 ! -i br0"
+	if rules[0] != expected {
+		t.Fatalf("Expected rule %q, got %q", expected, rules[0])
+	}
+	if !containsRule(rules, "-p udp -d 192.168.1.1 --dport 5353 -j DNAT --to-destination 172.17.0.2:53") {
+		t.Fatalf("Missing host ip mapping rule in %v", rules)
+	}
+}
+
+func containsRule(rules []string, rule string) bool {
+	for _, r := range rules {
+		if strings.Contains(r, rule) {
+			return true
+		}
+	}
+	return false
+}
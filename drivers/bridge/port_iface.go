@@ -0,0 +1,184 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netwatch"
+	"github.com/docker/libnetwork/types"
+)
+
+// portRebindEvent is the network event reporting that a port binding
+// published on a host interface moved to the new address of the interface
+const portRebindEvent = "port-rebind"
+
+// watchPortIfaces watches the host interfaces the port bindings of the
+// endpoint are published on, so that the bindings follow the address of
+// the interface when it changes, as it does with DHCP. Each interface is
+// watched by name once per network, so that the bindings follow an
+// interface deleted and created again, as a VPN or a PPP link is.
+func (d *driver) watchPortIfaces(n *bridgeNetwork, epConfig *endpointConfiguration) {
+	if epConfig == nil {
+		return
+	}
+
+	for _, b := range epConfig.PortBindings {
+		if b.HostIface == "" || b.PassSocket {
+			continue
+		}
+		name := b.HostIface
+
+		n.Lock()
+		_, ok := n.ifaceWatches[name]
+		n.Unlock()
+		if ok {
+			continue
+		}
+
+		w, err := d.getWatcher()
+		if err != nil {
+			logrus.Warnf("Not watching host interface %s for address changes: %v", name, err)
+			return
+		}
+		stop, err := w.WatchName(name, func(ev netwatch.Event) {
+			d.handlePortIfaceEvent(n, name, ev)
+		})
+		if err != nil {
+			logrus.Warnf("Not watching host interface %s for address changes: %v", name, err)
+			continue
+		}
+
+		n.Lock()
+		if _, ok := n.ifaceWatches[name]; ok {
+			// Watched by a concurrent endpoint creation meanwhile
+			n.Unlock()
+			stop()
+			continue
+		}
+		if n.ifaceWatches == nil {
+			n.ifaceWatches = make(map[string]func())
+		}
+		n.ifaceWatches[name] = stop
+		n.Unlock()
+	}
+}
+
+// stopPortIfaceWatches cancels the watches of the host interfaces of the
+// network port bindings
+func (n *bridgeNetwork) stopPortIfaceWatches() {
+	n.Lock()
+	watches := n.ifaceWatches
+	n.ifaceWatches = nil
+	n.Unlock()
+
+	for _, stop := range watches {
+		stop()
+	}
+}
+
+func (d *driver) handlePortIfaceEvent(n *bridgeNetwork, name string, ev netwatch.Event) {
+	switch ev.Type {
+	case netwatch.AddrAdded, netwatch.AddrDeleted, netwatch.LinkDeleted, netwatch.LinkAdded:
+	default:
+		return
+	}
+
+	// Events which race with the network removal are ignored
+	d.Lock()
+	cur, ok := d.networks[n.id]
+	d.Unlock()
+	if !ok || cur != n {
+		return
+	}
+
+	for eid, ep := range n.endpoints.snapshot() {
+		for _, r := range n.rebindIfacePorts(ep, name, n.config.EnableUserlandProxy) {
+			if d.notifier == nil {
+				continue
+			}
+			d.notifier.NotifyNetworkEvent(n.id, portRebindEvent, map[string]string{
+				"endpoint":  string(eid),
+				"interface": name,
+				"proto":     r.binding.Proto.String(),
+				"port":      strconv.Itoa(int(r.binding.HostPort)),
+				"address":   ipString(r.binding.HostIP),
+				"previous":  ipString(r.previous),
+			})
+		}
+	}
+}
+
+// portRebind is a port binding moved to a new host address
+type portRebind struct {
+	binding  types.PortBinding
+	previous net.IP
+}
+
+// rebindIfacePorts moves the active port bindings of the endpoint published
+// on the host interface to the current address of the interface, keeping
+// their host port. The bindings of an interface left without address are
+// unpublished until it gets one. It returns the bindings moved.
+func (n *bridgeNetwork) rebindIfacePorts(ep *bridgeEndpoint, name string, ulPxyEnabled bool) []portRebind {
+	n.Lock()
+	bindings := make([]types.PortBinding, len(ep.portMapping))
+	copy(bindings, ep.portMapping)
+	containerIP := ep.addr.IP
+	n.Unlock()
+
+	var moved []portRebind
+	for i, b := range bindings {
+		if b.HostIface != name || b.PassSocket {
+			continue
+		}
+		addr, err := hostIfaceAddr(name, b.HostIPv6Only)
+		if err == nil && addr.Equal(b.HostIP) {
+			continue
+		}
+
+		previous := b.HostIP
+		if err := n.releasePort(b); err != nil {
+			logrus.Warnf("Failed to release port %d/%s from the previous address %s of %s: %v", b.HostPort, b.Proto, previous, name, err)
+		}
+		b.HostIP = nil
+		if err != nil {
+			logrus.Warnf("Port %d/%s is unpublished until %s gets an address: %v", b.HostPort, b.Proto, name, err)
+		} else if err = n.allocatePort(&b, containerIP, nil, ulPxyEnabled); err != nil {
+			logrus.Warnf("Failed to publish port %d/%s on the new address %s of %s: %v", b.HostPort, b.Proto, addr, name, err)
+			b.HostIP = nil
+		}
+		bindings[i] = b
+		moved = append(moved, portRebind{binding: b, previous: previous})
+	}
+	if moved == nil {
+		return nil
+	}
+
+	n.Lock()
+	if ep.withdrawnPortMapping != nil {
+		// The bindings were withdrawn meanwhile, the moved ones under their
+		// previous address
+		n.Unlock()
+		for _, r := range moved {
+			n.releasePort(r.binding)
+		}
+		return nil
+	}
+	ep.portMapping = bindings
+	n.Unlock()
+
+	return moved
+}
+
+// isPendingBinding returns whether the binding waits for its host interface
+// to get an address, and holds no host port meanwhile
+func isPendingBinding(b types.PortBinding) bool {
+	return b.HostIface != "" && len(b.HostIP) == 0
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
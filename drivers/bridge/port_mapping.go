@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -31,6 +32,17 @@ func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, cont
 	bs := make([]types.PortBinding, 0, len(bindings))
 	for _, c := range bindings {
 		b := c.GetCopy()
+		if len(b.HostIPs) != 0 {
+			mbs, err := n.allocatePortMultiple(&b, containerIP, ulPxyEnabled)
+			if err != nil {
+				if cuErr := n.releasePortsInternal(bs); cuErr != nil {
+					logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
+				}
+				return nil, err
+			}
+			bs = append(bs, mbs...)
+			continue
+		}
 		if err := n.allocatePort(&b, containerIP, defHostIP, ulPxyEnabled); err != nil {
 			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
 			if cuErr := n.releasePortsInternal(bs); cuErr != nil {
@@ -53,8 +65,16 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 	bnd.IP = containerIP
 
 	// Adjust the host address in the operational binding
+	if bnd.HostIface != "" {
+		if bnd.HostIP, err = hostIfaceAddr(bnd.HostIface, bnd.HostIPv6Only); err != nil {
+			return err
+		}
+	}
 	if len(bnd.HostIP) == 0 {
 		bnd.HostIP = defHostIP
+		if bnd.HostIPv6Only {
+			bnd.HostIP = net.IPv6unspecified
+		}
 	}
 
 	// Construct the container side transport address
@@ -65,7 +85,14 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 
 	// Try up to maxAllocatePortAttempts times to get a port that's not already allocated.
 	for i := 0; i < maxAllocatePortAttempts; i++ {
-		if host, err = n.portMapper.Map(container, bnd.HostIP, int(bnd.HostPort), ulPxyEnabled); err == nil {
+		if bnd.PassSocket {
+			host, err = n.portMapper.MapSocket(bnd.Proto.String(), bnd.HostIP, int(bnd.HostPort), bnd.HostIPv6Only)
+		} else if bnd.HostIPv6Only {
+			host, err = n.portMapper.MapIPv6Only(container, bnd.HostIP, int(bnd.HostPort), ulPxyEnabled)
+		} else {
+			host, err = n.portMapper.Map(container, bnd.HostIP, int(bnd.HostPort), ulPxyEnabled)
+		}
+		if err == nil {
 			break
 		}
 		// There is no point in immediately retrying to map an explicitly chosen port.
@@ -93,6 +120,106 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 	}
 }
 
+// allocatePortMultiple publishes the binding on each of its host addresses.
+// One operational binding is returned per host address, so that they can be
+// released independently like any other binding.
+func (n *bridgeNetwork) allocatePortMultiple(bnd *types.PortBinding, containerIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+	var (
+		hosts []net.Addr
+		err   error
+	)
+
+	bnd.IP = containerIP
+	container, err := bnd.ContainerAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < maxAllocatePortAttempts; i++ {
+		hosts, err = n.portMapper.MapMultiple(container, bnd.HostIPs, int(bnd.HostPort), ulPxyEnabled)
+		if err == nil {
+			break
+		}
+		if bnd.HostPort != 0 {
+			logrus.Warnf("Failed to allocate and map port %d: %s", bnd.HostPort, err)
+			break
+		}
+		logrus.Warnf("Failed to allocate and map port: %s, retry: %d", err, i+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bs := make([]types.PortBinding, 0, len(hosts))
+	for _, host := range hosts {
+		b := bnd.GetCopy()
+		b.HostIPs = nil
+		switch netAddr := host.(type) {
+		case *net.TCPAddr:
+			b.HostIP, b.HostPort = netAddr.IP, uint16(netAddr.Port)
+		case *net.UDPAddr:
+			b.HostIP, b.HostPort = netAddr.IP, uint16(netAddr.Port)
+		default:
+			return nil, ErrUnsupportedAddressType(fmt.Sprintf("%T", netAddr))
+		}
+		bs = append(bs, b)
+	}
+
+	return bs, nil
+}
+
+// hostIfaceAddr returns the address of the named host interface a port binding
+// listens on. The first global IPv6 address is returned if ipv6 is set.
+func hostIfaceAddr(name string, ipv6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find host interface %s for port binding: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of host interface %s for port binding: %v", name, err)
+	}
+
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if isV4 := ipNet.IP.To4() != nil; isV4 != ipv6 && !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("host interface %s has no %s address for port binding", name, family)
+}
+
+// passSockets hands the sockets bound for the endpoint bindings which asked
+// for it over to the join info
+func (n *bridgeNetwork) passSockets(ep *bridgeEndpoint, jinfo driverapi.JoinInfo) error {
+	for _, bnd := range ep.portMapping {
+		if !bnd.PassSocket {
+			continue
+		}
+		host, err := bnd.HostAddr()
+		if err != nil {
+			return err
+		}
+		f, err := n.portMapper.SocketFile(host)
+		if err != nil {
+			return err
+		}
+		if err := jinfo.AddBoundSocket(bnd, f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return nil
+}
+
 func (n *bridgeNetwork) releasePorts(ep *bridgeEndpoint) error {
 	return n.releasePortsInternal(ep.portMapping)
 }
@@ -114,6 +241,12 @@ func (n *bridgeNetwork) releasePortsInternal(bindings []types.PortBinding) error
 }
 
 func (n *bridgeNetwork) releasePort(bnd types.PortBinding) error {
+	// A binding waiting for its host interface to get an address holds
+	// no host port
+	if isPendingBinding(bnd) {
+		return nil
+	}
+
 	// Construct the host side transport address
 	host, err := bnd.HostAddr()
 	if err != nil {
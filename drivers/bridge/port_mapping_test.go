@@ -1,13 +1,19 @@
 package bridge
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
 	"os"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
 )
 
 func TestMain(m *testing.M) {
@@ -35,13 +41,13 @@ func TestPortMappingConfig(t *testing.T) {
 	netOptions := make(map[string]interface{})
 	netOptions[netlabel.GenericData] = netConfig
 
-	err := d.CreateNetwork("dummy", netOptions)
+	err := d.CreateNetwork(context.Background(), "dummy", netOptions)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
 	te := &testEndpoint{ifaces: []*testInterface{}}
-	err = d.CreateEndpoint("dummy", "ep1", te, epOptions)
+	err = d.CreateEndpoint(context.Background(), "dummy", "ep1", te, epOptions)
 	if err != nil {
 		t.Fatalf("Failed to create the endpoint: %s", err.Error())
 	}
@@ -51,7 +57,7 @@ func TestPortMappingConfig(t *testing.T) {
 	if !ok {
 		t.Fatalf("Cannot find network %s inside driver", "dummy")
 	}
-	ep, _ := network.endpoints["ep1"]
+	ep := network.endpoints.get("ep1")
 	if len(ep.portMapping) != 2 {
 		t.Fatalf("Failed to store the port bindings into the sandbox info. Found: %v", ep.portMapping)
 	}
@@ -69,3 +75,227 @@ func TestPortMappingConfig(t *testing.T) {
 		t.Fatalf("Failed to release mapped ports: %v", err)
 	}
 }
+
+func TestDeferredPortBinding(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver().(*driver)
+
+	netOptions := map[string]interface{}{netlabel.GenericData: &networkConfiguration{BridgeName: DefaultBridgeName}}
+	if err := d.CreateNetwork(context.Background(), "dummy", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := map[string]interface{}{
+		netlabel.PortMap:          []types.PortBinding{{Proto: types.UDP, Port: uint16(400), HostPort: uint16(54001)}},
+		netlabel.DeferPortBinding: true,
+	}
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "dummy", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	ep := d.networks["dummy"].endpoints.get("ep1")
+	if ep.portMapping != nil || len(ep.withdrawnPortMapping) != 1 {
+		t.Fatalf("The deferred port bindings were bound: %v", ep.portMapping)
+	}
+
+	if err := d.SetExternalConnectivity("dummy", "ep1", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(ep.portMapping) != 1 || ep.portMapping[0].HostPort != 54001 || ep.portMapping[0].HostIP == nil || ep.withdrawnPortMapping != nil {
+		t.Fatalf("The deferred port bindings were not bound: %v", ep.portMapping)
+	}
+
+	if err := d.SetExternalConnectivity("dummy", "ep1", false); err != nil {
+		t.Fatal(err)
+	}
+	if ep.portMapping != nil || len(ep.withdrawnPortMapping) != 1 {
+		t.Fatalf("The port bindings were not withdrawn: %v", ep.portMapping)
+	}
+
+	if err := d.DeleteEndpoint(context.Background(), "dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortMappingMultipleHostIPs(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	// The listeners are bound to loopback addresses
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatal(err)
+	}
+
+	hostIPs := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}
+	binding := types.PortBinding{Proto: types.TCP, Port: uint16(80), HostPort: uint16(58000), HostIPs: hostIPs}
+
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		EnableUserlandProxy: true,
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = []types.PortBinding{binding}
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "dummy", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	network := d.(*driver).networks["dummy"]
+	ep := network.endpoints.get("ep1")
+	if len(ep.portMapping) != len(hostIPs) {
+		t.Fatalf("Expected one operational binding per host ip, found: %v", ep.portMapping)
+	}
+	for i, pb := range ep.portMapping {
+		if !pb.HostIP.Equal(hostIPs[i]) || pb.HostPort != binding.HostPort || len(pb.HostIPs) != 0 {
+			t.Fatalf("Unexpected operational binding %v", pb)
+		}
+	}
+
+	// The port is still free on the other host addresses
+	other := types.PortBinding{Proto: types.TCP, Port: uint16(80), HostPort: uint16(58000), HostIP: net.ParseIP("127.0.0.3")}
+	if _, err := network.allocatePortsInternal([]types.PortBinding{other}, ep.addr.IP, defaultBindingIP, true); err != nil {
+		t.Fatalf("Failed to map the port on another host ip: %v", err)
+	}
+
+	if err := network.releasePorts(ep); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestBridgeEndpointMarshalling(t *testing.T) {
+	ip, nw, _ := net.ParseCIDR("172.17.0.2/16")
+	nw.IP = ip
+	ep := &bridgeEndpoint{
+		id:         "d2c015a1fe5930650cbcd50493efba0500bcebd8ee1f4401a16319f8a567de33",
+		srcName:    "veth123456",
+		addr:       nw,
+		macAddress: []byte{0xc2, 0xce, 0x02, 0x11, 0xad, 0x0f},
+		config: &endpointConfiguration{
+			MacAddress: []byte{0xc2, 0xce, 0x02, 0x11, 0xad, 0x0f},
+			PortBindings: []types.PortBinding{
+				{Proto: types.TCP, Port: 80, HostIface: "eth0", HostIPv6Only: true},
+			},
+		},
+		portMapping: []types.PortBinding{
+			{Proto: types.TCP, Port: 80, HostPort: 8080, HostIP: net.IPv6unspecified, IP: ip, HostIPv6Only: true},
+		},
+	}
+
+	b, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ee := &bridgeEndpoint{}
+	if err := json.Unmarshal(b, ee); err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.id != ee.id || ep.srcName != ee.srcName || !types.CompareIPNet(ep.addr, ee.addr) ||
+		!bytes.Equal(ep.macAddress, ee.macAddress) || ee.addrv6 != nil {
+		t.Fatalf("JSON marshalling of bridge endpoint failed.\nOriginal: %v\nDecoded: %v", ep, ee)
+	}
+
+	if len(ee.config.PortBindings) != 1 || !ep.config.PortBindings[0].Equal(&ee.config.PortBindings[0]) {
+		t.Fatalf("Port bindings were not preserved: %v", ee.config.PortBindings)
+	}
+
+	if len(ee.portMapping) != 1 || !ep.portMapping[0].Equal(&ee.portMapping[0]) {
+		t.Fatalf("Port mapping was not preserved: %v", ee.portMapping)
+	}
+}
+
+func TestPortMappingPassSocket(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatal(err)
+	}
+
+	binding := types.PortBinding{Proto: types.TCP, Port: uint16(80), HostIP: net.ParseIP("127.0.0.1"), HostPort: uint16(58100), PassSocket: true}
+
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = &networkConfiguration{
+		BridgeName: DefaultBridgeName,
+		EnableICC:  true,
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = []types.PortBinding{binding}
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "dummy", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	// The socket is bound on the host port, no proxy forwards its traffic
+	if _, err := net.Listen("tcp", "127.0.0.1:58100"); err == nil {
+		t.Fatalf("Expected the host port to be bound")
+	}
+
+	if err := d.Join(context.Background(), "dummy", "ep1", "sbox", te, nil); err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	if len(te.sockets) != 1 || te.sockets[0].HostPort != binding.HostPort || !te.sockets[0].PassSocket {
+		t.Fatalf("Unexpected bound sockets handed over at join: %v", te.sockets)
+	}
+
+	network := d.(*driver).networks["dummy"]
+	if err := network.releasePorts(network.endpoints.get("ep1")); err != nil {
+		t.Fatalf("Failed to release mapped ports: %v", err)
+	}
+}
+
+func TestRebindIfacePorts(t *testing.T) {
+	n := &bridgeNetwork{portMapper: portmapper.New()}
+	ep := &bridgeEndpoint{
+		addr: &net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)},
+		portMapping: []types.PortBinding{
+			// Published on the previous address of the interface
+			{Proto: types.TCP, Port: 80, HostIface: "lo", HostIP: net.ParseIP("127.0.0.2"), HostPort: 48080},
+			{Proto: types.TCP, Port: 81, HostPort: 48081},
+		},
+	}
+
+	moved := n.rebindIfacePorts(ep, "lo", false)
+	if len(moved) != 1 || !moved[0].previous.Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatalf("Unexpected moved bindings %v", moved)
+	}
+	b := ep.portMapping[0]
+	if !b.HostIP.Equal(net.ParseIP("127.0.0.1")) || b.HostPort != 48080 {
+		t.Fatalf("Binding did not follow the address of the interface: %v", b)
+	}
+	if len(ep.portMapping[1].HostIP) != 0 {
+		t.Fatalf("Binding not published on the interface was moved: %v", ep.portMapping[1])
+	}
+
+	// The address did not change
+	if moved := n.rebindIfacePorts(ep, "lo", false); moved != nil {
+		t.Fatalf("Unexpected moved bindings %v", moved)
+	}
+
+	if err := n.releasePort(b); err != nil {
+		t.Fatal(err)
+	}
+	// A binding waiting for an address holds no port
+	if err := n.releasePort(types.PortBinding{Proto: types.TCP, Port: 80, HostIface: "lo", HostPort: 48080}); err != nil {
+		t.Fatalf("Unexpected failure releasing a pending binding: %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// desiredRule is an iptables rule the network needs, with the operation
+// programming it: the rules are inserted at the top of their chain, but the
+// ICC rule which is appended
+type desiredRule struct {
+	rule iptRule
+	op   iptables.Action
+}
+
+// desiredNetworkRules returns the rules of the network, without the jumps to
+// its chains, in the order they are programmed
+func desiredNetworkRules(config *networkConfiguration, addr net.Addr) []desiredRule {
+	var rules []desiredRule
+
+	natRule, hpNatRule, outRule, inRule := bridgeRules(config.BridgeName, addr, config.SNATAddress)
+	if config.EnableIPMasquerade {
+		rules = append(rules, desiredRule{natRule, iptables.Insert})
+	}
+	// In hairpin mode, masquerade traffic from localhost
+	if !config.EnableUserlandProxy {
+		rules = append(rules, desiredRule{hpNatRule, iptables.Insert})
+	}
+	rules = append(rules,
+		desiredRule{iccRule(config.BridgeName, config.EnableICC), iptables.Append},
+		desiredRule{outRule, iptables.Insert},
+		desiredRule{inRule, iptables.Insert})
+	for _, r := range icmpRules(config) {
+		rules = append(rules, desiredRule{r, iptables.Insert})
+	}
+	for _, r := range networkDSCPRules(config) {
+		rules = append(rules, desiredRule{r, iptables.Insert})
+	}
+
+	return rules
+}
+
+// chainKey identifies a chain of a table, of iptables or ip6tables
+type chainKey struct {
+	table iptables.Table
+	chain string
+	ipv6  bool
+}
+
+func ruleChain(r iptRule) chainKey {
+	table := r.table
+	if table == "" {
+		table = iptables.Filter
+	}
+	return chainKey{table: table, chain: r.chain, ipv6: r.ipv6}
+}
+
+// reconciledChains returns the built-in chains the network puts its rules
+// in, which are examined for its stale rules
+func reconciledChains(config *networkConfiguration) []chainKey {
+	chains := []chainKey{
+		{iptables.Nat, "POSTROUTING", false},
+		{iptables.Filter, "FORWARD", false},
+		{iptables.Mangle, "FORWARD", false},
+	}
+	if config.EnableIPv6 {
+		chains = append(chains,
+			chainKey{iptables.Filter, "FORWARD", true},
+			chainKey{iptables.Mangle, "FORWARD", true})
+	}
+	return chains
+}
+
+// reconcileIPTables brings the rules of the network in line with the kernel
+// ones. Rather than programming every rule again, which duplicates the
+// rules iptables does not report as existing, the chains are listed and
+// compared with the rules the network needs: the missing rules are
+// programmed, the extra copies of a rule are removed, as are the stale
+// rules of the bridge, like the ICC rule of the opposite policy or the ICMP
+// rules of a previous policy. The rules in keep, the jumps to the chains of
+// the network, are left alone.
+func reconcileIPTables(config *networkConfiguration, desired []desiredRule, keep []iptRule) error {
+	listed := map[chainKey][][]string{}
+	for _, ck := range reconciledChains(config) {
+		rules, err := listChainRules(ck)
+		if err != nil {
+			// The chain has no rule of the network to check
+			logrus.Debugf("Not reconciling iptables chain %s/%s: %v", ck.table, ck.chain, err)
+			continue
+		}
+		listed[ck] = rules
+	}
+
+	// The wanted rules of a chain are its desired rules, in order, then the
+	// ones to keep
+	wanted := map[chainKey][][]string{}
+	ndesired := map[chainKey]int{}
+	for _, r := range desired {
+		ck := ruleChain(r.rule)
+		wanted[ck] = append(wanted[ck], r.rule.args)
+		ndesired[ck]++
+		if _, ok := listed[ck]; ok {
+			continue
+		}
+		rules, err := listChainRules(ck)
+		if err != nil {
+			return fmt.Errorf("could not list the rules of iptables chain %s/%s: %v", ck.table, ck.chain, err)
+		}
+		listed[ck] = rules
+	}
+	for _, r := range keep {
+		ck := ruleChain(r)
+		wanted[ck] = append(wanted[ck], r.args)
+	}
+
+	var (
+		missing = map[chainKey]map[int]bool{}
+		remove  []iptRule
+	)
+	for ck, rules := range listed {
+		absent, duplicates, unmatched := diffChain(wanted[ck], rules)
+		for _, args := range duplicates {
+			remove = append(remove, iptRule{table: ck.table, chain: ck.chain, preArgs: []string{"-t", string(ck.table)}, args: args, ipv6: ck.ipv6})
+		}
+
+		// A rule iptables spells in a way the comparison misses is not
+		// missing if iptables finds it. The listed rule it is cannot be
+		// told then, no rule of the chain is deemed stale.
+		certain := true
+		missing[ck] = map[int]bool{}
+		for _, i := range absent {
+			if i >= ndesired[ck] {
+				// The chain jumps are added along with the chains
+				continue
+			}
+			if ruleExists(ck, wanted[ck][i]) {
+				certain = false
+				continue
+			}
+			missing[ck][i] = true
+		}
+		if !certain {
+			continue
+		}
+		for _, args := range unmatched {
+			if ownedRule(args, config.BridgeName) {
+				remove = append(remove, iptRule{table: ck.table, chain: ck.chain, preArgs: []string{"-t", string(ck.table)}, args: args, ipv6: ck.ipv6})
+			}
+		}
+	}
+
+	for _, r := range remove {
+		logrus.Debugf("Removing stale iptables rule %s", strings.Join(r.command(iptables.Delete), " "))
+		if err := runRule(r, iptables.Delete); err != nil {
+			return err
+		}
+	}
+
+	index := map[chainKey]int{}
+	for _, r := range desired {
+		ck := ruleChain(r.rule)
+		i := index[ck]
+		index[ck]++
+		if !missing[ck][i] {
+			continue
+		}
+		if err := runRule(r.rule, r.op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listChainRules(ck chainKey) ([][]string, error) {
+	if ck.ipv6 {
+		return iptables.ListRules6(ck.table, ck.chain)
+	}
+	return iptables.ListRules(ck.table, ck.chain)
+}
+
+func ruleExists(ck chainKey, args []string) bool {
+	if ck.ipv6 {
+		return iptables.Exists6(ck.table, ck.chain, args...)
+	}
+	return iptables.Exists(ck.table, ck.chain, args...)
+}
+
+// runRule programs the rule with the operation
+func runRule(r iptRule, op iptables.Action) error {
+	raw := iptables.Raw
+	if r.ipv6 {
+		raw = iptables.Raw6
+	}
+	args := append(append(append([]string{}, r.preArgs...), string(op), r.chain), r.args...)
+	if output, err := raw(args...); err != nil {
+		return fmt.Errorf("Unable to program iptables rule %s: %s", strings.Join(args, " "), err.Error())
+	} else if len(output) != 0 {
+		return &iptables.ChainError{Chain: r.chain, Output: output}
+	}
+	return nil
+}
+
+// diffChain compares the rules wanted in a chain with the rules listed in
+// it. It returns the indexes of the wanted rules which are not listed, the
+// extra copies of the listed ones and the listed rules matching no wanted
+// rule, in the order they are listed.
+func diffChain(wanted, listed [][]string) (absent []int, duplicates, unmatched [][]string) {
+	keys := make([]string, len(wanted))
+	seen := map[string]int{}
+	for i, args := range wanted {
+		keys[i] = normalizeRule(args)
+		seen[keys[i]] = 0
+	}
+
+	for _, args := range listed {
+		k := normalizeRule(args)
+		n, ok := seen[k]
+		switch {
+		case !ok:
+			unmatched = append(unmatched, args)
+		case n > 0:
+			duplicates = append(duplicates, args)
+		}
+		if ok {
+			seen[k] = n + 1
+		}
+	}
+
+	reported := map[string]bool{}
+	for i, k := range keys {
+		if seen[k] == 0 && !reported[k] {
+			absent = append(absent, i)
+			reported[k] = true
+		}
+	}
+	return absent, duplicates, unmatched
+}
+
+// ownedRule tells whether the rule is one of the bridge: it matches the
+// bridge interface, and no other
+func ownedRule(args []string, bridge string) bool {
+	owned := false
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-i", "--in-interface", "-o", "--out-interface":
+			if args[i+1] != bridge {
+				return false
+			}
+			owned = true
+		}
+	}
+	return owned
+}
+
+// The numbers iptables lists the neighbor discovery ICMPv6 types with
+var icmpv6TypeNumbers = map[string]string{
+	"router-solicitation":     "133",
+	"router-advertisement":    "134",
+	"neighbour-solicitation":  "135",
+	"neighbour-advertisement": "136",
+	"redirect":                "137",
+}
+
+// normalizeRule returns the rule spelled as `iptables -S` lists it: the
+// addresses are networks, the protocol match implied by -p is dropped, the
+// DSCP values and ICMPv6 types are numbers and the basic matches come
+// first, in the iptables order
+func normalizeRule(args []string) string {
+	var (
+		basic  = map[string]string{}
+		rest   []string
+		negate string
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "!" {
+			negate = "! "
+			continue
+		}
+		var value string
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch arg {
+		case "-s", "--source", "--src":
+			basic["-s"] = negate + "-s " + normalizeAddress(value)
+			i++
+		case "-d", "--destination", "--dst":
+			basic["-d"] = negate + "-d " + normalizeAddress(value)
+			i++
+		case "-i", "--in-interface":
+			basic["-i"] = negate + "-i " + value
+			i++
+		case "-o", "--out-interface":
+			basic["-o"] = negate + "-o " + value
+			i++
+		case "-p", "--protocol":
+			basic["-p"] = negate + "-p " + value
+			i++
+		case "-m", "--match":
+			proto := strings.TrimPrefix(basic["-p"], "-p ")
+			if value == proto || (value == "icmp6" && proto == "ipv6-icmp") {
+				i++
+				break
+			}
+			rest = append(rest, negate+arg, value)
+			i++
+		case "--set-dscp":
+			if n, err := strconv.ParseInt(value, 0, 8); err == nil {
+				value = fmt.Sprintf("0x%02x", n)
+			}
+			rest = append(rest, negate+arg, value)
+			i++
+		case "--icmpv6-type":
+			if n, ok := icmpv6TypeNumbers[value]; ok {
+				value = n
+			}
+			rest = append(rest, negate+arg, value)
+			i++
+		default:
+			rest = append(rest, negate+arg)
+		}
+		negate = ""
+	}
+
+	var key []string
+	for _, opt := range []string{"-s", "-d", "-i", "-o", "-p"} {
+		if m, ok := basic[opt]; ok {
+			key = append(key, m)
+		}
+	}
+	return strings.Join(append(key, rest...), " ")
+}
+
+// normalizeAddress returns the network of a CIDR address, or the host
+// network of an address
+func normalizeAddress(addr string) string {
+	if _, nw, err := net.ParseCIDR(addr); err == nil {
+		return nw.String()
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32"
+		}
+		return ip.String() + "/128"
+	}
+	return addr
+}
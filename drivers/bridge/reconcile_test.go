@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	cases := []struct {
+		rule   string
+		listed string
+	}{
+		{"-s 172.17.42.1/16 ! -o docker0 -j MASQUERADE", "-s 172.17.0.0/16 ! -o docker0 -j MASQUERADE"},
+		{"-m addrtype --src-type LOCAL -o docker0 -j MASQUERADE", "-o docker0 -m addrtype --src-type LOCAL -j MASQUERADE"},
+		{"-o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", "-o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT"},
+		{"-i docker0 -o docker0 -p ipv6-icmp --icmpv6-type router-solicitation -j ACCEPT", "-i docker0 -o docker0 -p ipv6-icmp -m icmp6 --icmpv6-type 133 -j ACCEPT"},
+		{"-i docker0 ! -o docker0 -j DSCP --set-dscp 10", "-i docker0 ! -o docker0 -j DSCP --set-dscp 0x0a"},
+		{"-s 172.17.0.2 ! -o docker0 -j SNAT --to-source 192.0.2.1", "-s 172.17.0.2/32 ! -o docker0 -j SNAT --to-source 192.0.2.1"},
+		{"-m addrtype --dst-type LOCAL -j DOCKER ! --dst 127.0.0.0/8", "! -d 127.0.0.0/8 -m addrtype --dst-type LOCAL -j DOCKER"},
+	}
+	for _, c := range cases {
+		if a, b := normalizeRule(strings.Fields(c.rule)), normalizeRule(strings.Fields(c.listed)); a != b {
+			t.Fatalf("Rule %q normalized to %q, its listing %q to %q", c.rule, a, c.listed, b)
+		}
+	}
+
+	if normalizeRule(strings.Fields("-i docker0 -o docker0 -j ACCEPT")) == normalizeRule(strings.Fields("-i docker0 -o docker0 -j DROP")) {
+		t.Fatal("Rules of different targets normalized alike")
+	}
+}
+
+func TestDiffChain(t *testing.T) {
+	config := &networkConfiguration{
+		BridgeName:          "docker0",
+		EnableIPMasquerade:  true,
+		EnableUserlandProxy: true,
+	}
+	addr := &net.IPNet{IP: net.ParseIP("172.17.42.1"), Mask: net.CIDRMask(16, 32)}
+
+	var wanted [][]string
+	for _, r := range desiredNetworkRules(config, addr) {
+		if ruleChain(r.rule) == (chainKey{iptables.Filter, "FORWARD", false}) {
+			wanted = append(wanted, r.rule.args)
+		}
+	}
+
+	// The listing of a daemon which ran with ICC enabled, and twice without
+	// reconciliation, along with a rule of another bridge
+	listing := `-P FORWARD ACCEPT
+-A FORWARD -o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A FORWARD -i docker0 ! -o docker0 -j ACCEPT
+-A FORWARD -o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A FORWARD -o docker0 -j DOCKER
+-A FORWARD -o br-1 -j DOCKER
+-A FORWARD -i docker0 -o docker0 -j ACCEPT
+`
+	var listed [][]string
+	for _, line := range strings.Split(listing, "\n") {
+		if f := strings.Fields(line); len(f) > 2 && f[0] == "-A" {
+			listed = append(listed, f[2:])
+		}
+	}
+
+	absent, duplicates, unmatched := diffChain(wanted, listed)
+	if len(absent) != 1 || strings.Join(wanted[absent[0]], " ") != "-i docker0 -o docker0 -j DROP" {
+		t.Fatalf("Unexpected missing rules %v", absent)
+	}
+	if len(duplicates) != 1 || strings.Join(duplicates[0], " ") != "-o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT" {
+		t.Fatalf("Unexpected duplicate rules %v", duplicates)
+	}
+	if len(unmatched) != 3 {
+		t.Fatalf("Unexpected unmatched rules %v", unmatched)
+	}
+
+	var stale []string
+	for _, args := range unmatched {
+		if ownedRule(args, config.BridgeName) {
+			stale = append(stale, strings.Join(args, " "))
+		}
+	}
+	// The jump to the chain is kept apart, it is not a desired rule
+	if len(stale) != 2 || stale[0] != "-o docker0 -j DOCKER" || stale[1] != "-i docker0 -o docker0 -j ACCEPT" {
+		t.Fatalf("Unexpected stale rules %v", stale)
+	}
+
+	for _, r := range chainJumpRules(config) {
+		if ruleChain(r) == (chainKey{iptables.Filter, "FORWARD", false}) {
+			wanted = append(wanted, r.args)
+		}
+	}
+	if _, _, unmatched = diffChain(wanted, listed); len(unmatched) != 2 {
+		t.Fatalf("The chain jump is not kept: %v", unmatched)
+	}
+}
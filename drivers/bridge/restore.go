@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// RestoreEndpoints creates again the endpoints a reloaded driver starts
+// without, with the options they were created with and their MAC and IP
+// addresses, as Import does. This programs their port mappings again, the
+// bindings without a host port getting a new one, and the DNAT rules of the
+// mappings are then verified. The endpoints the driver still has and the
+// ones of the networks it does not have are skipped.
+func (d *driver) RestoreEndpoints(ctx context.Context, endpoints []driverapi.RestoredEndpoint) error {
+	var failed []string
+
+	for _, rec := range endpoints {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := d.getNetwork(rec.NetworkID)
+		if err != nil {
+			logrus.Debugf("Skipping restored endpoint %s: %v", rec.EndpointID, err)
+			continue
+		}
+		n.Lock()
+		known := n.endpoints.get(rec.EndpointID) != nil
+		n.Unlock()
+		if known {
+			continue
+		}
+
+		if err := d.restoreEndpoint(ctx, rec); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", rec.EndpointID, err))
+			continue
+		}
+		if missing := n.missingPortRules(rec.EndpointID); len(missing) != 0 {
+			failed = append(failed, fmt.Sprintf("%s: missing port mapping rules %s", rec.EndpointID, strings.Join(missing, ", ")))
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to restore endpoints: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// restoreEndpoint creates the endpoint and moves it to its restored address
+func (d *driver) restoreEndpoint(ctx context.Context, rec driverapi.RestoredEndpoint) error {
+	epOptions := make(map[string]interface{}, len(rec.Options)+2)
+	for k, v := range rec.Options {
+		epOptions[k] = v
+	}
+
+	var iface driverapi.RestoredInterface
+	if len(rec.Interfaces) != 0 {
+		iface = rec.Interfaces[0]
+	}
+	if len(iface.MacAddress) != 0 {
+		epOptions[netlabel.MacAddress] = iface.MacAddress
+	}
+	if iface.AddressIPv6.IP != nil {
+		epOptions[importIPv6Option] = iface.AddressIPv6.IP
+	}
+
+	if err := d.CreateEndpoint(ctx, rec.NetworkID, rec.EndpointID, &importInfo{}, epOptions); err != nil {
+		return err
+	}
+
+	if iface.Address.IP != nil {
+		if _, err := d.ChangeEndpointAddress(rec.NetworkID, rec.EndpointID, iface.Address.IP); err != nil {
+			d.DeleteEndpoint(ctx, rec.NetworkID, rec.EndpointID)
+			return err
+		}
+	}
+	// The deferred bindings were bound when the container joined
+	if deferred, _ := rec.Options[netlabel.DeferPortBinding].(bool); deferred && rec.ContainerID != "" {
+		if err := d.SetExternalConnectivity(rec.NetworkID, rec.EndpointID, true); err != nil {
+			d.DeleteEndpoint(ctx, rec.NetworkID, rec.EndpointID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// missingPortRules returns the DNAT rules of the port mappings of the
+// endpoint which are not on the host
+func (n *bridgeNetwork) missingPortRules(eid types.UUID) []string {
+	n.Lock()
+	config := n.config
+	ep := n.endpoints.get(eid)
+	if ep == nil || ep.addr == nil || !config.EnableIPTables {
+		n.Unlock()
+		return nil
+	}
+	bindings := ep.portMapping
+	ip := ep.addr.IP
+	n.Unlock()
+
+	cmds, err := portMappingCommands(config, bindings, ip)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var missing []string
+	for _, cmd := range cmds {
+		r, ok := parseRuleCommand(cmd)
+		if !ok {
+			continue
+		}
+		exists := iptables.Exists
+		if r.ipv6 {
+			exists = iptables.Exists6
+		}
+		if !exists(r.table, r.chain, r.args...) {
+			missing = append(missing, ruleString(programmedRule(r)))
+		}
+	}
+	return missing
+}
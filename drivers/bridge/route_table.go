@@ -0,0 +1,160 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The fib rule message attributes and action the netlink package lacks. The
+// rule header has the layout of the route message, its type is the action.
+const (
+	frActToTbl  = 1
+	fraSrc      = 2
+	fraPriority = 6
+	fraTable    = 15
+)
+
+// The tables reserved by the kernel
+const (
+	rtTableDefault = 253
+	rtTableLocal   = 255
+)
+
+func validateRouteTable(c *networkConfiguration) error {
+	if c.RouteTable < 0 || (c.RouteTable >= rtTableDefault && c.RouteTable <= rtTableLocal) {
+		return InvalidRouteTableError(c.RouteTable)
+	}
+	if c.RouteRulePriority < 0 {
+		return types.BadRequestErrorf("invalid route rule priority %d", c.RouteRulePriority)
+	}
+	if c.RouteRulePriority != 0 && c.RouteTable == 0 {
+		return types.BadRequestErrorf("route rule priority %d requires a route table", c.RouteRulePriority)
+	}
+	return nil
+}
+
+// routedSubnets returns the subnets of the network whose traffic is routed
+// through its table: the subnet of the bridge and the IPv6 one the
+// containers get their global addresses from
+func routedSubnets(config *networkConfiguration, i *bridgeInterface) []*net.IPNet {
+	var subnets []*net.IPNet
+	if i.bridgeIPv4 != nil {
+		subnets = append(subnets, &net.IPNet{IP: i.bridgeIPv4.IP.Mask(i.bridgeIPv4.Mask), Mask: i.bridgeIPv4.Mask})
+	}
+	if config.EnableIPv6 && config.FixedCIDRv6 != nil {
+		subnets = append(subnets, config.FixedCIDRv6)
+	}
+	return subnets
+}
+
+// setupRouteTable adds the routes of the network to its table, and the rules
+// looking the table up for the traffic coming from the network. The rest of
+// the table, like its default route, is left to the host configuration.
+func setupRouteTable(config *networkConfiguration, i *bridgeInterface) error {
+	for _, subnet := range routedSubnets(config, i) {
+		var src net.IP
+		if subnet.IP.To4() != nil {
+			src = i.bridgeIPv4.IP
+		}
+		if err := programTableRoute(i.Link, subnet, src, config.RouteTable, true); err != nil {
+			return err
+		}
+		// A rule left over by a previous instance of the network would
+		// be doubled
+		programSourceRule(subnet, config.RouteTable, config.RouteRulePriority, false)
+		if err := programSourceRule(subnet, config.RouteTable, config.RouteRulePriority, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// teardownRouteTable removes the rules and routes of the network. The routes
+// go with the bridge anyway, unless it was created outside of the driver.
+func teardownRouteTable(config *networkConfiguration, i *bridgeInterface) {
+	for _, subnet := range routedSubnets(config, i) {
+		if err := programSourceRule(subnet, config.RouteTable, config.RouteRulePriority, false); err != nil {
+			logrus.Warnf("Failed to remove the rule looking up table %d for %s: %v", config.RouteTable, subnet, err)
+		}
+		programTableRoute(i.Link, subnet, nil, config.RouteTable, false)
+	}
+}
+
+// programTableRoute adds or removes the route of the subnet through the link
+// in the table, the equivalent of
+// `ip route add <subnet> dev <link> src <src> table <table>`. The netlink
+// package only programs the main table, hence the request is built here.
+func programTableRoute(link netlink.Link, subnet *net.IPNet, src net.IP, table int, add bool) error {
+	proto, flags := syscall.RTM_DELROUTE, syscall.NLM_F_ACK
+	if add {
+		proto, flags = syscall.RTM_NEWROUTE, flags|syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE
+	}
+
+	req := nl.NewNetlinkRequest(proto, flags)
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(nl.GetIPFamily(subnet.IP))
+	ones, _ := subnet.Mask.Size()
+	msg.Dst_len = uint8(ones)
+	msg.Scope = syscall.RT_SCOPE_LINK
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(syscall.RTA_DST, ipBytes(subnet.IP)))
+	req.AddData(nl.NewRtAttr(syscall.RTA_OIF, nl.Uint32Attr(uint32(link.Attrs().Index))))
+	req.AddData(nl.NewRtAttr(syscall.RTA_TABLE, nl.Uint32Attr(uint32(table))))
+	if src != nil {
+		req.AddData(nl.NewRtAttr(syscall.RTA_PREFSRC, ipBytes(src)))
+	}
+
+	if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("could not program the route of %s in table %d: %v", subnet, table, err)
+	}
+	return nil
+}
+
+// programSourceRule adds or removes the rule looking the table up for the
+// traffic coming from the subnet, the equivalent of
+// `ip rule add from <subnet> lookup <table> priority <priority>`. The
+// kernel picks the priority when it is 0.
+func programSourceRule(subnet *net.IPNet, table, priority int, add bool) error {
+	proto, flags := syscall.RTM_DELRULE, syscall.NLM_F_ACK
+	if add {
+		proto, flags = syscall.RTM_NEWRULE, flags|syscall.NLM_F_CREATE|syscall.NLM_F_EXCL
+	}
+
+	req := nl.NewNetlinkRequest(proto, flags)
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(nl.GetIPFamily(subnet.IP))
+	ones, _ := subnet.Mask.Size()
+	msg.Src_len = uint8(ones)
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	msg.Protocol = 0
+	msg.Scope = 0
+	msg.Type = frActToTbl
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(fraSrc, ipBytes(subnet.IP)))
+	req.AddData(nl.NewRtAttr(fraTable, nl.Uint32Attr(uint32(table))))
+	if priority != 0 {
+		req.AddData(nl.NewRtAttr(fraPriority, nl.Uint32Attr(uint32(priority))))
+	}
+
+	if _, err := req.Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("could not program the rule looking up table %d for %s: %v", table, subnet, err)
+	}
+	return nil
+}
+
+// ipBytes returns the address in the length of its family
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+)
+
+func TestRouteTableConfig(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromMap(map[string]interface{}{"RouteTable": "100", "RouteRulePriority": "1000"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.RouteTable != 100 || c.RouteRulePriority != 1000 {
+		t.Fatalf("Unexpected route table configuration: %d %d", c.RouteTable, c.RouteRulePriority)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []int{-1, 253, 254, 255} {
+		c := &networkConfiguration{RouteTable: table}
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Route table %d was accepted", table)
+		}
+	}
+	c = &networkConfiguration{RouteRulePriority: 10}
+	if err := c.Validate(); err == nil {
+		t.Fatal("Route rule priority without table was accepted")
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	_, addr, _ := net.ParseCIDR("172.31.0.1/24")
+	addr.IP = net.ParseIP("172.31.0.1")
+	config := &networkConfiguration{
+		BridgeName:            "rtbr0",
+		AddressIPv4:           addr,
+		AllowNonDefaultBridge: true,
+		RouteTable:            100,
+		RouteRulePriority:     1000,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "rt", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	out, err := exec.Command("ip", "rule", "show").Output()
+	if err != nil {
+		t.Skipf("Cannot list the rules: %v", err)
+	}
+	if !strings.Contains(string(out), "1000:\tfrom 172.31.0.0/24 lookup 100") {
+		t.Fatalf("Missing rule for the network in:\n%s", out)
+	}
+	out, err = exec.Command("ip", "route", "show", "table", "100").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "172.31.0.0/24 dev rtbr0") || !strings.Contains(string(out), "src 172.31.0.1") {
+		t.Fatalf("Missing route of the network in table 100:\n%s", out)
+	}
+
+	if err := d.DeleteNetwork(context.Background(), "rt"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = exec.Command("ip", "rule", "show").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "lookup 100") {
+		t.Fatalf("Rule of the network left after its deletion:\n%s", out)
+	}
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// secondaryPool returns the subnet of the network the secondary address is
+// allocated from: the bridge one for IPv4, the one the endpoints get their
+// primary IPv6 address from otherwise
+func secondaryPool(config *networkConfiguration, i *bridgeInterface, ip net.IP) (*net.IPNet, error) {
+	pool := i.bridgeIPv4
+	if ip.To4() == nil {
+		if !config.EnableIPv6 {
+			return nil, InvalidSecondaryAddressError(ip.String())
+		}
+		pool = i.bridgeIPv6
+		if config.FixedCIDRv6 != nil {
+			pool = config.FixedCIDRv6
+		}
+	}
+	if pool == nil || !pool.Contains(ip) {
+		return nil, InvalidSecondaryAddressError(ip.String())
+	}
+	return pool, nil
+}
+
+// allocateSecondaryAddresses reserves the secondary addresses in the pools of
+// the network. Either all of them are reserved or none is.
+func allocateSecondaryAddresses(config *networkConfiguration, i *bridgeInterface, ips []net.IP) ([]*net.IPNet, error) {
+	var addrs []*net.IPNet
+	for _, ip := range ips {
+		pool, err := secondaryPool(config, i, ip)
+		if err == nil {
+			_, err = ipAllocator.RequestIP(pool, ip)
+		}
+		if err != nil {
+			releaseSecondaryAddresses(config, i, addrs)
+			return nil, err
+		}
+		addrs = append(addrs, &net.IPNet{IP: ip, Mask: pool.Mask})
+	}
+	return addrs, nil
+}
+
+// releaseSecondaryAddresses gives the secondary addresses back to the pools
+// of the network
+func releaseSecondaryAddresses(config *networkConfiguration, i *bridgeInterface, addrs []*net.IPNet) {
+	for _, addr := range addrs {
+		pool, err := secondaryPool(config, i, addr.IP)
+		if err == nil {
+			err = ipAllocator.ReleaseIP(pool, addr.IP)
+		}
+		if err != nil {
+			logrus.Warnf("Failed to release secondary address %s: %v", addr.IP, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSecondaryAddresses(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	ip, nw, _ := net.ParseCIDR("192.168.137.1/24")
+	nw.IP = ip
+	config := &networkConfiguration{
+		BridgeName:          DefaultBridgeName,
+		AddressIPv4:         nw,
+		EnableUserlandProxy: true,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	secondary := []net.IP{net.ParseIP("192.168.137.100"), net.ParseIP("192.168.137.101")}
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, map[string]interface{}{netlabel.SecondaryAddresses: secondary}); err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if len(te.ifaces) != 1 || len(te.ifaces[0].secondary) != 2 || te.ifaces[0].secondary[1].String() != "192.168.137.101/24" {
+		t.Fatalf("Unexpected secondary addresses of the interface: %v", te.ifaces)
+	}
+
+	// The addresses are taken
+	if _, err := ipAllocator.RequestIP(nw, secondary[0]); err == nil {
+		t.Fatal("Secondary address was not reserved")
+	}
+	te2 := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep2", te2, map[string]interface{}{netlabel.SecondaryAddresses: []net.IP{net.ParseIP("192.168.137.102"), secondary[1]}}); err == nil {
+		t.Fatal("Secondary address of another endpoint was handed out")
+	}
+	// Including those of the failed endpoint
+	if _, err := ipAllocator.RequestIP(nw, net.ParseIP("192.168.137.102")); err != nil {
+		t.Fatalf("Secondary address of the failed endpoint was not released: %v", err)
+	}
+	ipAllocator.ReleaseIP(nw, net.ParseIP("192.168.137.102"))
+
+	// Out of the subnets of the network
+	te3 := &testEndpoint{ifaces: []*testInterface{}}
+	for _, ip := range []string{"10.0.0.1", "2001:db8::1"} {
+		if err := d.CreateEndpoint(context.Background(), "net1", "ep3", te3, map[string]interface{}{netlabel.SecondaryAddresses: []net.IP{net.ParseIP(ip)}}); err == nil {
+			t.Fatalf("Secondary address %s was accepted", ip)
+		} else if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for secondary address %s: %v", ip, err)
+		}
+	}
+
+	network, _ := d.(*driver).getNetwork("net1")
+	ep, _ := network.getEndpoint("ep1")
+	b, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &bridgeEndpoint{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.secondary) != 2 || !restored.secondary[0].IP.Equal(secondary[0]) {
+		t.Fatalf("Unexpected secondary addresses after unmarshal: %v", restored.secondary)
+	}
+
+	if err := d.DeleteEndpoint(context.Background(), "net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range secondary {
+		if _, err := ipAllocator.RequestIP(nw, ip); err != nil {
+			t.Fatalf("Secondary address %s was not released: %v", ip, err)
+		}
+	}
+}
+
+func TestSecondaryAddressesOption(t *testing.T) {
+	for _, ips := range [][]net.IP{{nil}, {net.IPv4zero}, {net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.1")}} {
+		if _, err := parseEndpointOptions(map[string]interface{}{netlabel.SecondaryAddresses: ips}); err == nil {
+			t.Fatalf("Secondary addresses %v were accepted", ips)
+		}
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.SecondaryAddresses: "192.0.2.1"}); err == nil {
+		t.Fatal("Secondary addresses of the wrong type were accepted")
+	}
+}
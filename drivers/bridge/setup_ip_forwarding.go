@@ -2,7 +2,9 @@ package bridge
 
 import (
 	"fmt"
-	"io/ioutil"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sysctl"
 )
 
 const (
@@ -17,9 +19,25 @@ func setupIPForwarding(config *configuration) error {
 	}
 
 	// Enable IPv4 forwarding
-	if err := ioutil.WriteFile(ipv4ForwardConf, []byte{'1', '\n'}, ipv4ForwardConfPerm); err != nil {
+	if err := sysctl.Default().Set(sysctl.IPv4Forward, "1"); err != nil {
 		return fmt.Errorf("Setup IP forwarding failed: %v", err)
 	}
 
 	return nil
 }
+
+// setupBridgeNetFiltering makes the traffic bridged between the containers
+// go through iptables, for the isolation rules to apply to it. It is only
+// possible once the br_netfilter module is loaded.
+func setupBridgeNetFiltering(config *networkConfiguration, i *bridgeInterface) error {
+	params := []string{sysctl.BridgeNFCallIPTables}
+	if config.EnableIPv6 {
+		params = append(params, sysctl.BridgeNFCallIP6Tables)
+	}
+	for _, p := range params {
+		if err := sysctl.Default().Set(p, "1"); err != nil {
+			logrus.Warnf("Unable to enable %s, the bridged traffic bypasses iptables: %v", p, err)
+		}
+	}
+	return nil
+}
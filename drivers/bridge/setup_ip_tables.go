@@ -3,7 +3,9 @@ package bridge
 import (
 	"fmt"
 	"net"
+	"strings"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netutils"
 )
@@ -11,8 +13,51 @@ import (
 // DockerChain: DOCKER iptable chain name
 const (
 	DockerChain = "DOCKER"
+	// maxChainPrefixLen is the longest chain name prefix, for the name it
+	// makes with the longest bridge name to be accepted by iptables
+	maxChainPrefixLen = 28 - 1 - 15
 )
 
+var builtinChains = map[string]bool{
+	"INPUT":       true,
+	"OUTPUT":      true,
+	"FORWARD":     true,
+	"PREROUTING":  true,
+	"POSTROUTING": true,
+}
+
+// chainName returns the name of the chain holding this network's NAT and
+// filter rules, its chain prefix followed by its bridge name. Networks
+// without a chain prefix share the DOCKER chain.
+func (c *networkConfiguration) chainName() string {
+	if c.ChainPrefix != "" {
+		return c.ChainPrefix + "-" + c.BridgeName
+	}
+	return DockerChain
+}
+
+func validateChainPrefix(prefix string) error {
+	if len(prefix) > maxChainPrefixLen || strings.ContainsAny(prefix, " \t\n!") || strings.HasPrefix(prefix, "-") {
+		return InvalidChainPrefixError(prefix)
+	}
+	// The DOCKER chains are shared by the networks with no chain prefix
+	if builtinChains[prefix] || prefix == DockerChain {
+		return InvalidChainPrefixError(prefix)
+	}
+	return nil
+}
+
+// removeNetworkChains removes the NAT and filter chains dedicated to a
+// network, after the rules jumping to them
+func removeNetworkChains(name, bridge string) {
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		c := &iptables.Chain{Name: name, Bridge: bridge, Table: table}
+		if err := c.Remove(); err != nil {
+			logrus.Warnf("Failed to remove iptables chain %s/%s: %v", table, name, err)
+		}
+	}
+}
+
 func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInterface) error {
 	// Sanity check.
 	if config.EnableIPTables == false {
@@ -25,16 +70,16 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 	if err != nil {
 		return fmt.Errorf("Failed to setup IP tables, cannot acquire Interface address: %s", err.Error())
 	}
-	if err = setupIPTablesInternal(config.BridgeName, addrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
+	if err = reconcileIPTables(config, desiredNetworkRules(config, addrv4), chainJumpRules(config)); err != nil {
 		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 	}
 
-	_, err = iptables.NewChain(DockerChain, config.BridgeName, iptables.Nat, hairpinMode)
+	_, err = iptables.NewChain(config.chainName(), config.BridgeName, iptables.Nat, hairpinMode)
 	if err != nil {
 		return fmt.Errorf("Failed to create NAT chain: %s", err.Error())
 	}
 
-	chain, err := iptables.NewChain(DockerChain, config.BridgeName, iptables.Filter, hairpinMode)
+	chain, err := iptables.NewChain(config.chainName(), config.BridgeName, iptables.Filter, hairpinMode)
 	if err != nil {
 		return fmt.Errorf("Failed to create FILTER chain: %s", err.Error())
 	}
@@ -49,47 +94,109 @@ type iptRule struct {
 	chain   string
 	preArgs []string
 	args    []string
+	// ipv6 rules are programmed with ip6tables
+	ipv6 bool
 }
 
-func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
+// command returns the rule in the iptables command line syntax, for the
+// given operation. IPv6 rules are marked with the -6 option.
+func (r iptRule) command(op iptables.Action) []string {
+	var args []string
+	if r.ipv6 {
+		args = append(args, "-6")
+	}
+	args = append(args, r.preArgs...)
+	args = append(args, string(op), r.chain)
+	return append(args, r.args...)
+}
 
-	var (
-		address   = addr.String()
-		natRule   = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		hpNatRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		outRule   = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
-		inRule    = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
-	)
+// bridgeRules returns the NAT and forwarding rules of the bridge. The
+// outbound traffic is translated to the snat address if one is given, it is
+// masqueraded otherwise.
+func bridgeRules(bridgeIface string, addr net.Addr, snat net.IP) (natRule, hpNatRule, outRule, inRule iptRule) {
+	address := addr.String()
+	if snat != nil {
+		natRule = snatRule(bridgeIface, address, snat)
+	} else {
+		natRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
+	}
+	hpNatRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
+	outRule = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+	inRule = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
+	return
+}
 
-	// Set NAT.
-	if ipmasq {
-		if err := programChainRule(natRule, "NAT", enable); err != nil {
-			return err
-		}
+// iccRule returns the rule accepting or dropping the traffic between the
+// interfaces of the bridge
+func iccRule(bridgeIface string, icc bool) iptRule {
+	target := "DROP"
+	if icc {
+		target = "ACCEPT"
 	}
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "-o", bridgeIface, "-j", target}}
+}
 
-	// In hairpin mode, masquerade traffic from localhost
-	if hairpin {
-		if err := programChainRule(hpNatRule, "MASQ LOCAL HOST", enable); err != nil {
-			return err
-		}
+// The ICMP policies of a network
+const (
+	ICMPAllow = "allow"
+	ICMPDeny  = "deny"
+)
+
+// The ICMPv6 types which are accepted whatever the ICMPv6 policy, as the
+// neighbor discovery relies on them
+var ndpTypes = []string{"router-solicitation", "router-advertisement",
+	"neighbour-solicitation", "neighbour-advertisement", "redirect"}
+
+func validateICMPPolicy(policy string) error {
+	switch policy {
+	case "", ICMPAllow, ICMPDeny:
+		return nil
 	}
+	return InvalidICMPPolicyError(policy)
+}
 
-	// Set Inter Container Communication.
-	if err := setIcc(bridgeIface, icc, enable); err != nil {
-		return err
+func icmpTarget(policy string) string {
+	if policy == ICMPAllow {
+		return "ACCEPT"
 	}
+	return "DROP"
+}
 
-	// Set Accept on all non-intercontainer outgoing packets.
-	if err := programChainRule(outRule, "ACCEPT NON_ICC OUTGOING", enable); err != nil {
-		return err
+// icmpRules returns the rules applying the ICMP policies of the network to
+// the traffic between the interfaces of the bridge, in the order they are
+// inserted at the top of the FORWARD chain, ahead of the ICC rule
+func icmpRules(config *networkConfiguration) []iptRule {
+	var (
+		rules []iptRule
+		iface = []string{"-i", config.BridgeName, "-o", config.BridgeName}
+	)
+
+	if config.ICMPPolicy != "" {
+		rules = append(rules, iptRule{table: iptables.Filter, chain: "FORWARD",
+			args: append(append([]string{}, iface...), "-p", "icmp", "-j", icmpTarget(config.ICMPPolicy))})
 	}
 
-	// Set Accept on incoming packets for existing connections.
-	if err := programChainRule(inRule, "ACCEPT INCOMING", enable); err != nil {
-		return err
+	if config.ICMPv6Policy != "" && config.EnableIPv6 {
+		rules = append(rules, iptRule{table: iptables.Filter, chain: "FORWARD", ipv6: true,
+			args: append(append([]string{}, iface...), "-p", "ipv6-icmp", "-j", icmpTarget(config.ICMPv6Policy))})
+		if config.ICMPv6Policy == ICMPDeny {
+			for _, t := range ndpTypes {
+				rules = append(rules, iptRule{table: iptables.Filter, chain: "FORWARD", ipv6: true,
+					args: append(append([]string{}, iface...), "-p", "ipv6-icmp", "--icmpv6-type", t, "-j", "ACCEPT")})
+			}
+		}
 	}
 
+	return rules
+}
+
+// programICMPRules inserts or removes the ICMP rules of the network
+func programICMPRules(config *networkConfiguration, insert bool) error {
+	for _, rule := range icmpRules(config) {
+		if err := programChainRule(rule, "ICMP POLICY", insert); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -98,9 +205,17 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 		prefix    []string
 		operation string
 		condition bool
-		doesExist = iptables.Exists(rule.table, rule.chain, rule.args...)
+		doesExist bool
+		raw       = iptables.Raw
 	)
 
+	if rule.ipv6 {
+		doesExist = iptables.Exists6(rule.table, rule.chain, rule.args...)
+		raw = iptables.Raw6
+	} else {
+		doesExist = iptables.Exists(rule.table, rule.chain, rule.args...)
+	}
+
 	if insert {
 		condition = !doesExist
 		prefix = []string{"-I", rule.chain}
@@ -115,7 +230,7 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	}
 
 	if condition {
-		if output, err := iptables.Raw(append(prefix, rule.args...)...); err != nil {
+		if output, err := raw(append(prefix, rule.args...)...); err != nil {
 			return fmt.Errorf("Unable to %s %s rule: %s", operation, ruleDescr, err.Error())
 		} else if len(output) != 0 {
 			return &iptables.ChainError{Chain: rule.chain, Output: output}
@@ -125,53 +240,6 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	return nil
 }
 
-func setIcc(bridgeIface string, iccEnable, insert bool) error {
-	var (
-		table      = iptables.Filter
-		chain      = "FORWARD"
-		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
-		acceptArgs = append(args, "ACCEPT")
-		dropArgs   = append(args, "DROP")
-	)
-
-	if insert {
-		if !iccEnable {
-			iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
-
-			if !iptables.Exists(table, chain, dropArgs...) {
-				if output, err := iptables.Raw(append([]string{"-A", chain}, dropArgs...)...); err != nil {
-					return fmt.Errorf("Unable to prevent intercontainer communication: %s", err.Error())
-				} else if len(output) != 0 {
-					return fmt.Errorf("Error disabling intercontainer communication: %s", output)
-				}
-			}
-		} else {
-			iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
-
-			if !iptables.Exists(table, chain, acceptArgs...) {
-				if output, err := iptables.Raw(append([]string{"-A", chain}, acceptArgs...)...); err != nil {
-					return fmt.Errorf("Unable to allow intercontainer communication: %s", err.Error())
-				} else if len(output) != 0 {
-					return fmt.Errorf("Error enabling intercontainer communication: %s", output)
-				}
-			}
-		}
-	} else {
-		// Remove any ICC rule.
-		if !iccEnable {
-			if iptables.Exists(table, chain, dropArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
-			}
-		} else {
-			if iptables.Exists(table, chain, acceptArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
-			}
-		}
-	}
-
-	return nil
-}
-
 // Control Inter Network Communication. Install/remove only if it is not/is present.
 func setINC(network1, network2 string, enable bool) error {
 	var (
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/docker/libnetwork/iptables"
@@ -103,3 +104,36 @@ func assertBridgeConfig(config *networkConfiguration, br *bridgeInterface, t *te
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestICMPRules(t *testing.T) {
+	config := getBasicTestConfig()
+	if rules := icmpRules(config); len(rules) != 0 {
+		t.Fatalf("Expected no ICMP rule without policies, got %v", rules)
+	}
+
+	config.ICMPPolicy = ICMPDeny
+	config.ICMPv6Policy = ICMPDeny
+	if rules := icmpRules(config); len(rules) != 1 || rules[0].ipv6 {
+		t.Fatalf("Expected only the IPv4 rule on a network without IPv6, got %v", rules)
+	}
+
+	config.EnableIPv6 = true
+	rules := icmpRules(config)
+	if len(rules) != 2+len(ndpTypes) {
+		t.Fatalf("Expected %d ICMP rules, got %v", 2+len(ndpTypes), rules)
+	}
+	expected := "-I FORWARD -i " + DefaultBridgeName + " -o " + DefaultBridgeName + " -p icmp -j DROP"
+	if r := strings.Join(rules[0].command(iptables.Insert), " "); r != expected {
+		t.Fatalf("Expected rule %q, got %q", expected, r)
+	}
+	// The neighbor discovery is accepted ahead of the ICMPv6 drop rule
+	last := strings.Join(rules[len(rules)-1].command(iptables.Insert), " ")
+	if !strings.HasPrefix(last, "-6 ") || !strings.HasSuffix(last, "-j ACCEPT") {
+		t.Fatalf("Expected an IPv6 accept rule last, got %q", last)
+	}
+
+	config.ICMPPolicy = "reject"
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an invalid ICMP policy to fail the validation")
+	}
+}
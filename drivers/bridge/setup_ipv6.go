@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -71,10 +72,10 @@ func setupGatewayIPv6(config *networkConfiguration, i *bridgeInterface) error {
 
 func setupIPv6Forwarding(config *networkConfiguration, i *bridgeInterface) error {
 	// Enable IPv6 forwarding
-	if err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/default/forwarding", []byte{'1', '\n'}, ipv6ForwardConfPerm); err != nil {
+	if err := sysctl.Default().Set(sysctl.IPv6ForwardDefault, "1"); err != nil {
 		logrus.Warnf("Unable to enable IPv6 default forwarding: %v", err)
 	}
-	if err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte{'1', '\n'}, ipv6ForwardConfPerm); err != nil {
+	if err := sysctl.Default().Set(sysctl.IPv6ForwardAll, "1"); err != nil {
 		logrus.Warnf("Unable to enable IPv6 all forwarding: %v", err)
 	}
 	return nil
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// parseSNATPool parses a comma separated list of IPv4 addresses
+func parseSNATPool(s string) ([]net.IP, error) {
+	var pool []net.IP
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		ip := net.ParseIP(f)
+		if ip == nil || ip.To4() == nil {
+			return nil, types.BadRequestErrorf("invalid SNATPool address %q", f)
+		}
+		pool = append(pool, ip)
+	}
+	return pool, nil
+}
+
+// inSNATPool tells whether the address is one of the SNAT pool of the network
+func (c *networkConfiguration) inSNATPool(ip net.IP) bool {
+	for _, p := range c.SNATPool {
+		if p.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSNAT checks the network SNAT address belongs to the pool, and that
+// the pool is only set on the networks whose traffic is masqueraded
+func (c *networkConfiguration) validateSNAT() error {
+	if len(c.SNATPool) == 0 {
+		if c.SNATAddress != nil {
+			return InvalidSNATAddressError(c.SNATAddress.String())
+		}
+		return nil
+	}
+	if !c.EnableIPTables || !c.EnableIPMasquerade {
+		return types.BadRequestErrorf("SNATPool requires iptables and IP masquerading to be enabled")
+	}
+	if c.SNATAddress != nil && !c.inSNATPool(c.SNATAddress) {
+		return InvalidSNATAddressError(c.SNATAddress.String())
+	}
+	return nil
+}
+
+// snatRule returns the rule translating the outbound traffic of the source
+// to the given host address, in place of masquerading it
+func snatRule(bridgeIface, source string, to net.IP) iptRule {
+	return iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", source, "!", "-o", bridgeIface, "-j", "SNAT", "--to-source", to.String()}}
+}
+
+// endpointSNATRule returns the rule translating the outbound traffic of an
+// endpoint which picked its own SNAT address. Inserted at the head of the
+// chain, it takes precedence over the rule of the network.
+func endpointSNATRule(config *networkConfiguration, epConfig *endpointConfiguration, addr *net.IPNet) (iptRule, bool) {
+	if epConfig == nil || epConfig.SNATAddress == nil || addr == nil {
+		return iptRule{}, false
+	}
+	return snatRule(config.BridgeName, addr.IP.String(), epConfig.SNATAddress), true
+}
+
+// programEndpointSNAT adds or removes the SNAT rule of the endpoint, if it
+// has one
+func programEndpointSNAT(config *networkConfiguration, epConfig *endpointConfiguration, addr *net.IPNet, enable bool) error {
+	rule, ok := endpointSNATRule(config, epConfig, addr)
+	if !ok {
+		return nil
+	}
+	return programChainRule(rule, "SNAT", enable)
+}
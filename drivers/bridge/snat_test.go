@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSNATConfig(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromMap(map[string]interface{}{"SNATPool": "192.0.2.10, 192.0.2.11", "SNATAddress": "192.0.2.11"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.SNATPool) != 2 || !c.SNATPool[0].Equal(net.ParseIP("192.0.2.10")) || !c.SNATAddress.Equal(net.ParseIP("192.0.2.11")) {
+		t.Fatalf("Unexpected SNAT configuration: %v %v", c.SNATPool, c.SNATAddress)
+	}
+
+	for _, pool := range []string{"192.0.2.300", "2001:db8::1", "host"} {
+		if _, err := parseSNATPool(pool); err == nil {
+			t.Fatalf("SNAT pool %q was accepted", pool)
+		}
+	}
+
+	// The pool is only used on masqueraded networks
+	if err := c.Validate(); err == nil {
+		t.Fatal("SNAT pool was accepted without IP masquerading")
+	}
+	c.EnableIPTables = true
+	c.EnableIPMasquerade = true
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SNATAddress = net.ParseIP("192.0.2.12")
+	if err := c.Validate(); err == nil {
+		t.Fatal("SNAT address out of the pool was accepted")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type %T: %v", err, err)
+	}
+	c.SNATPool = nil
+	if err := c.Validate(); err == nil {
+		t.Fatal("SNAT address without a pool was accepted")
+	}
+}
+
+func TestSNATRules(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("172.17.0.0/16")
+	snat := net.ParseIP("192.0.2.10")
+
+	natRule, _, _, _ := bridgeRules("docker0", addr, nil)
+	if !strings.Contains(strings.Join(natRule.args, " "), "-j MASQUERADE") {
+		t.Fatalf("Unexpected NAT rule without SNAT address: %v", natRule.args)
+	}
+	natRule, _, _, _ = bridgeRules("docker0", addr, snat)
+	if got := strings.Join(natRule.args, " "); got != "-s 172.17.0.0/16 ! -o docker0 -j SNAT --to-source 192.0.2.10" {
+		t.Fatalf("Unexpected NAT rule with SNAT address: %s", got)
+	}
+
+	config := &networkConfiguration{BridgeName: "docker0", SNATPool: []net.IP{snat}}
+	ipNet := &net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: addr.Mask}
+	if _, ok := endpointSNATRule(config, &endpointConfiguration{}, ipNet); ok {
+		t.Fatal("Unexpected SNAT rule for an endpoint without SNAT address")
+	}
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.SNATAddress: snat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, ok := endpointSNATRule(config, ec, ipNet)
+	if !ok {
+		t.Fatal("Missing SNAT rule for the endpoint")
+	}
+	if got := strings.Join(rule.args, " "); got != "-s 172.17.0.2 ! -o docker0 -j SNAT --to-source 192.0.2.10" {
+		t.Fatalf("Unexpected endpoint SNAT rule: %s", got)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.SNATAddress: "192.0.2.10"}); err == nil {
+		t.Fatal("SNAT address of the wrong type was accepted")
+	}
+}
@@ -0,0 +1,326 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// ProgrammedState returns the bridge, the host side veths of the endpoints,
+// the routes of the route table and the iptables rules the driver programmed
+// for the network. A bridge created outside of the driver is reported too,
+// the driver relies on it.
+func (d *driver) ProgrammedState(nid types.UUID) (*driverapi.ProgrammedState, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	config := n.config
+	bridge := n.bridge
+	eps := n.endpoints.snapshot()
+	n.Unlock()
+
+	st := &driverapi.ProgrammedState{}
+
+	br := driverapi.ProgrammedDevice{Name: config.BridgeName, Kind: "bridge"}
+	if bridge.bridgeIPv4 != nil {
+		br.Addresses = append(br.Addresses, bridge.bridgeIPv4)
+	}
+	if config.EnableIPv6 && bridge.bridgeIPv6 != nil {
+		br.Addresses = append(br.Addresses, bridge.bridgeIPv6)
+	}
+	st.Devices = append(st.Devices, br)
+
+	if config.RouteTable != 0 {
+		for _, subnet := range routedSubnets(config, bridge) {
+			st.Routes = append(st.Routes, driverapi.ProgrammedRoute{Destination: subnet, Device: config.BridgeName, Table: config.RouteTable})
+		}
+	}
+
+	var rules []iptRule
+	if config.EnableIPTables && bridge.bridgeIPv4 != nil {
+		rules = networkIptRules(config, bridge.bridgeIPv4)
+	}
+
+	for _, eid := range sortedEndpointIDs(eps) {
+		ep := eps[eid]
+		if ep.external {
+			continue
+		}
+		n.Lock()
+		hostName := ep.hostName
+		addr, addrv6 := ep.addr, ep.addrv6
+		epConfig := ep.config
+		bindings := ep.portMapping
+		n.Unlock()
+
+		st.Devices = append(st.Devices, driverapi.ProgrammedDevice{Name: hostName, Kind: "veth", Master: config.BridgeName})
+		if config.EnableIPTables && addr != nil {
+			rules = append(rules, endpointIptRules(config, eid, epConfig, bindings, addr, addrv6)...)
+		}
+	}
+
+	for _, r := range rules {
+		st.Rules = append(st.Rules, programmedRule(r))
+	}
+
+	return st, nil
+}
+
+// networkIptRules returns the iptables rules programmed for the network,
+// the jumps to its chains included
+func networkIptRules(config *networkConfiguration, bridgeIPv4 *net.IPNet) []iptRule {
+	var rules []iptRule
+	for _, r := range desiredNetworkRules(config, bridgeIPv4) {
+		rules = append(rules, r.rule)
+	}
+	return append(rules, chainJumpRules(config)...)
+}
+
+// chainJumpRules returns the rules jumping to the chains of the network
+func chainJumpRules(config *networkConfiguration) []iptRule {
+	var rules []iptRule
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		c := &iptables.Chain{Name: config.chainName(), Bridge: config.BridgeName, Table: table, HairpinMode: !config.EnableUserlandProxy}
+		for _, cmd := range c.SetupRules() {
+			if r, ok := parseRuleCommand(cmd); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+	return rules
+}
+
+// endpointIptRules returns the iptables rules programmed for the endpoint
+func endpointIptRules(config *networkConfiguration, eid types.UUID, epConfig *endpointConfiguration, bindings []types.PortBinding, addr, addrv6 *net.IPNet) []iptRule {
+	var rules []iptRule
+
+	cmds, err := portMappingCommands(config, bindings, addr.IP)
+	if err == nil {
+		for _, cmd := range cmds {
+			if r, ok := parseRuleCommand(cmd); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+	if epConfig == nil {
+		return rules
+	}
+
+	if r, ok := endpointSNATRule(config, epConfig, addr); ok {
+		rules = append(rules, r)
+	}
+	rules = append(rules, connLimitRules(eid, epConfig, bindings, addr.IP)...)
+	for _, a := range []*net.IPNet{addr, addrv6} {
+		if a == nil {
+			continue
+		}
+		if r, ok := endpointDSCPRule(config, epConfig, a.IP); ok {
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+// parseRuleCommand returns the rule of an iptables command line appending
+// or inserting it. The commands creating chains are not rules.
+func parseRuleCommand(cmd []string) (iptRule, bool) {
+	r := iptRule{table: iptables.Filter}
+	if len(cmd) > 0 && cmd[0] == "-6" {
+		r.ipv6 = true
+		cmd = cmd[1:]
+	}
+	if len(cmd) > 1 && cmd[0] == "-t" {
+		r.table = iptables.Table(cmd[1])
+		r.preArgs = cmd[:2]
+		cmd = cmd[2:]
+	}
+	if len(cmd) < 2 || (cmd[0] != string(iptables.Append) && cmd[0] != string(iptables.Insert)) {
+		return iptRule{}, false
+	}
+	r.chain = cmd[1]
+	r.args = cmd[2:]
+	return r, true
+}
+
+func programmedRule(r iptRule) driverapi.ProgrammedRule {
+	table := r.table
+	if table == "" {
+		table = iptables.Filter
+	}
+	return driverapi.ProgrammedRule{Table: string(table), Chain: r.chain, Args: r.args, IPv6: r.ipv6}
+}
+
+// VerifyState compares the programmed state of the network with the links,
+// addresses, routes and iptables rules of the host
+func (d *driver) VerifyState(nid types.UUID) ([]driverapi.Drift, error) {
+	st, err := d.ProgrammedState(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []driverapi.Drift
+
+	for _, dev := range st.Devices {
+		drifts = append(drifts, verifyDevice(dev)...)
+	}
+
+	tables := map[int][]tableRoute{}
+	for _, rt := range st.Routes {
+		if _, ok := tables[rt.Table]; !ok {
+			if tables[rt.Table], err = listTableRoutes(rt.Table); err != nil {
+				return nil, err
+			}
+		}
+		if !hasTableRoute(tables[rt.Table], rt) {
+			drifts = append(drifts, driverapi.Drift{Kind: driverapi.DriftRoute, Object: fmt.Sprintf("%s dev %s table %d", rt.Destination, rt.Device, rt.Table), Problem: "missing"})
+		}
+	}
+
+	for _, r := range st.Rules {
+		var ok bool
+		if r.IPv6 {
+			ok = iptables.Exists6(iptables.Table(r.Table), r.Chain, r.Args...)
+		} else {
+			ok = iptables.Exists(iptables.Table(r.Table), r.Chain, r.Args...)
+		}
+		if !ok {
+			drifts = append(drifts, driverapi.Drift{Kind: driverapi.DriftRule, Object: ruleString(r), Problem: "missing"})
+		}
+	}
+
+	return drifts, nil
+}
+
+func verifyDevice(dev driverapi.ProgrammedDevice) []driverapi.Drift {
+	link, err := netlink.LinkByName(dev.Name)
+	if err != nil {
+		return []driverapi.Drift{{Kind: driverapi.DriftDevice, Object: dev.Name, Problem: "missing"}}
+	}
+
+	var drifts []driverapi.Drift
+	if link.Type() != dev.Kind {
+		drifts = append(drifts, driverapi.Drift{Kind: driverapi.DriftDevice, Object: dev.Name, Problem: fmt.Sprintf("is a %s, not a %s", link.Type(), dev.Kind)})
+	}
+	if dev.Master != "" {
+		master, err := netlink.LinkByName(dev.Master)
+		if err != nil || link.Attrs().MasterIndex != master.Attrs().Index {
+			drifts = append(drifts, driverapi.Drift{Kind: driverapi.DriftDevice, Object: dev.Name, Problem: fmt.Sprintf("not attached to %s", dev.Master)})
+		}
+	}
+
+	if len(dev.Addresses) == 0 {
+		return drifts
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return append(drifts, driverapi.Drift{Kind: driverapi.DriftAddress, Object: dev.Name, Problem: fmt.Sprintf("addresses could not be listed: %v", err)})
+	}
+	for _, want := range dev.Addresses {
+		found := false
+		for _, a := range addrs {
+			if a.IPNet != nil && types.CompareIPNet(a.IPNet, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			drifts = append(drifts, driverapi.Drift{Kind: driverapi.DriftAddress, Object: fmt.Sprintf("%s on %s", want, dev.Name), Problem: "missing"})
+		}
+	}
+	return drifts
+}
+
+func ruleString(r driverapi.ProgrammedRule) string {
+	cmd := []string{"-t", r.Table, string(iptables.Append), r.Chain}
+	if r.IPv6 {
+		cmd = append([]string{"-6"}, cmd...)
+	}
+	return joinRules([][]string{append(cmd, r.Args...)})[0]
+}
+
+// tableRoute is a route of a routing table, as dumped by the kernel
+type tableRoute struct {
+	dst       *net.IPNet
+	linkIndex int
+}
+
+// listTableRoutes returns the routes of the table, the equivalent of
+// `ip route show table <table>`. The netlink package only lists the routes
+// of the main table, hence the dump is parsed here.
+func listTableRoutes(table int) ([]tableRoute, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETROUTE, syscall.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the routes of table %d: %v", table, err)
+	}
+
+	native := nl.NativeEndian()
+	var routes []tableRoute
+	for _, m := range msgs {
+		msg := nl.DeserializeRtMsg(m)
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, err
+		}
+		var (
+			rt      tableRoute
+			rtTable = int(msg.Table)
+		)
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_TABLE:
+				rtTable = int(native.Uint32(attr.Value[0:4]))
+			case syscall.RTA_DST:
+				rt.dst = &net.IPNet{IP: attr.Value, Mask: net.CIDRMask(int(msg.Dst_len), 8*len(attr.Value))}
+			case syscall.RTA_OIF:
+				rt.linkIndex = int(native.Uint32(attr.Value[0:4]))
+			}
+		}
+		if rtTable == table && rt.dst != nil {
+			routes = append(routes, rt)
+		}
+	}
+	return routes, nil
+}
+
+func hasTableRoute(routes []tableRoute, want driverapi.ProgrammedRoute) bool {
+	link, err := netlink.LinkByName(want.Device)
+	if err != nil {
+		return false
+	}
+	for _, rt := range routes {
+		if rt.linkIndex == link.Attrs().Index && types.CompareIPNet(rt.dst, want.Destination) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedEndpointIDs returns the ids of the endpoints in order
+func sortedEndpointIDs(eps map[types.UUID]*bridgeEndpoint) []types.UUID {
+	ids := make([]types.UUID, 0, len(eps))
+	for id := range eps {
+		ids = append(ids, id)
+	}
+	sort.Sort(byUUID(ids))
+	return ids
+}
+
+type byUUID []types.UUID
+
+func (s byUUID) Len() int           { return len(s) }
+func (s byUUID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byUUID) Less(i, j int) bool { return s[i] < s[j] }
@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseRuleCommand(t *testing.T) {
+	r, ok := parseRuleCommand([]string{"-t", "nat", "-A", "PREROUTING", "-m", "addrtype", "-j", "DOCKER"})
+	if !ok || r.table != iptables.Nat || r.chain != "PREROUTING" || len(r.args) != 4 {
+		t.Fatalf("Unexpected rule %+v", r)
+	}
+	r, ok = parseRuleCommand([]string{"-6", "-I", "FORWARD", "-j", "ACCEPT"})
+	if !ok || !r.ipv6 || r.table != iptables.Filter || r.chain != "FORWARD" {
+		t.Fatalf("Unexpected rule %+v", r)
+	}
+	if _, ok := parseRuleCommand([]string{"-t", "nat", "-N", "DOCKER"}); ok {
+		t.Fatal("Chain creation was parsed as a rule")
+	}
+}
+
+func TestVerifyState(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver().(*driver)
+
+	_, addr, _ := net.ParseCIDR("172.31.1.1/24")
+	addr.IP = net.ParseIP("172.31.1.1")
+	config := &networkConfiguration{
+		BridgeName:            "statebr0",
+		AddressIPv4:           addr,
+		AllowNonDefaultBridge: true,
+		RouteTable:            100,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep1", te, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := d.ProgrammedState("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Devices) != 2 || st.Devices[0].Kind != "bridge" || st.Devices[1].Master != "statebr0" {
+		t.Fatalf("Unexpected devices %+v", st.Devices)
+	}
+	if len(st.Routes) != 1 || st.Routes[0].Table != 100 || st.Routes[0].Destination.String() != "172.31.1.0/24" {
+		t.Fatalf("Unexpected routes %+v", st.Routes)
+	}
+	if len(st.Rules) != 0 {
+		t.Fatalf("Unexpected rules without iptables %+v", st.Rules)
+	}
+
+	drifts, err := d.VerifyState("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("Unexpected drift %v", drifts)
+	}
+
+	veth, err := netlink.LinkByName(st.Devices[1].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(veth); err != nil {
+		t.Fatal(err)
+	}
+	if drifts, err = d.VerifyState("net1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != driverapi.DriftDevice || drifts[0].Object != st.Devices[1].Name {
+		t.Fatalf("Unexpected drift %v", drifts)
+	}
+}
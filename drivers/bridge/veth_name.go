@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// vethIDPlaceholder stands for the endpoint ID in a veth name pattern
+	vethIDPlaceholder = "{id}"
+	// maxVethNameLen is the longest interface name the kernel accepts
+	maxVethNameLen = 15
+	// minVethIDLen is the shortest endpoint ID prefix a pattern may leave
+	// room for, to keep the names of the endpoints apart
+	minVethIDLen = 6
+)
+
+// validateVethName checks the name is usable as an interface name
+func validateVethName(name string) error {
+	if name == "" || len(name) > maxVethNameLen || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/: \t\n") {
+		return InvalidVethNameError(name)
+	}
+	return nil
+}
+
+// validateVethNamePattern checks the host side veth name pattern of a
+// network. The pattern is either a prefix completed with random characters,
+// or contains the {id} placeholder replaced by the endpoint ID.
+func validateVethNamePattern(pattern string) error {
+	fixed := strings.Replace(pattern, vethIDPlaceholder, "", 1)
+	if strings.Contains(fixed, vethIDPlaceholder) {
+		return InvalidVethNameError(pattern)
+	}
+	room := vethLen
+	if fixed != pattern {
+		room = minVethIDLen
+	}
+	if len(fixed)+room > maxVethNameLen {
+		return InvalidVethNameError(pattern)
+	}
+	if fixed == "" {
+		return nil
+	}
+	return validateVethName(fixed)
+}
+
+// hostVethName returns the name of the host side veth interface of the
+// endpoint, either the requested one or one following the pattern
+func hostVethName(pattern, requested string, eid types.UUID) (string, error) {
+	if requested != "" {
+		return requested, nil
+	}
+	if pattern == "" {
+		return netutils.GenerateIfaceName(vethPrefix, vethLen)
+	}
+	if !strings.Contains(pattern, vethIDPlaceholder) {
+		return netutils.GenerateIfaceName(pattern, vethLen)
+	}
+
+	id := string(eid)
+	if room := maxVethNameLen - len(pattern) + len(vethIDPlaceholder); len(id) > room {
+		id = id[:room]
+	}
+	return strings.Replace(pattern, vethIDPlaceholder, id, 1), nil
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestVethNamePattern(t *testing.T) {
+	for _, p := range []string{"ct", "ct-{id}", "{id}", "mon.{id}.x", "abcdefgh"} {
+		if err := validateVethNamePattern(p); err != nil {
+			t.Fatalf("Pattern %q was refused: %v", p, err)
+		}
+	}
+	for _, p := range []string{"abcdefghi", "{id}{id}", "a/{id}", "abcdefghij{id}", "c t"} {
+		if err := validateVethNamePattern(p); err == nil {
+			t.Fatalf("Pattern %q was accepted", p)
+		}
+	}
+
+	name, err := hostVethName("ct-{id}", "", "0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "ct-0123456789ab" {
+		t.Fatalf("Unexpected name %s", name)
+	}
+	if name, _ := hostVethName("ct-{id}", "", "0123"); name != "ct-0123" {
+		t.Fatalf("Unexpected name %s", name)
+	}
+	if name, _ := hostVethName("ct-{id}", "given0", "0123"); name != "given0" {
+		t.Fatalf("Expected the requested name, got %s", name)
+	}
+	if name, _ := hostVethName("ct", "", "0123"); !strings.HasPrefix(name, "ct") || len(name) != len("ct")+vethLen {
+		t.Fatalf("Unexpected name %s", name)
+	}
+}
+
+func TestEndpointVethNameAndMtu(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dd, _ := d.(*driver)
+
+	config := &networkConfiguration{
+		BridgeName:      DefaultBridgeName,
+		Mtu:             1400,
+		VethNamePattern: "ct-{id}",
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork(context.Background(), "net1", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "0123456789abcdef", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+	link, err := netlink.LinkByName("ct-0123456789ab")
+	if err != nil {
+		t.Fatalf("Host side veth not named after the pattern: %v", err)
+	}
+	if link.Attrs().MTU != 1400 {
+		t.Fatalf("Expected the network MTU, got %d", link.Attrs().MTU)
+	}
+
+	epOptions := map[string]interface{}{
+		netlabel.VethName: "mon0",
+		netlabel.MTU:      1300,
+	}
+	te = &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep2", te, epOptions); err != nil {
+		t.Fatalf("Failed to create an endpoint : %s", err.Error())
+	}
+	link, err = netlink.LinkByName("mon0")
+	if err != nil {
+		t.Fatalf("Host side veth not given the requested name: %v", err)
+	}
+	if link.Attrs().MTU != 1300 {
+		t.Fatalf("Expected the endpoint MTU, got %d", link.Attrs().MTU)
+	}
+
+	info, err := dd.EndpointOperInfo("net1", "ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[netlabel.VethName] != "mon0" {
+		t.Fatalf("Unexpected veth name in the operational data: %v", info[netlabel.VethName])
+	}
+
+	epOptions[netlabel.VethName] = "bad/name"
+	if err := d.CreateEndpoint(context.Background(), "net1", "ep3", te, epOptions); err == nil {
+		t.Fatal("Expected an invalid veth name to be refused")
+	}
+}
@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+	"github.com/docker/libnetwork/vrrp"
+)
+
+// gatewayStateEvent is the network event reporting the VRRP state of the
+// host for the gateway of the network
+const gatewayStateEvent = "gateway-state"
+
+func validateVRRP(c *networkConfiguration) error {
+	if c.VRRPRouterID < 0 || c.VRRPRouterID > 255 {
+		return types.BadRequestErrorf("invalid VRRP router id %d", c.VRRPRouterID)
+	}
+	if c.VRRPPriority < 0 || c.VRRPPriority > vrrp.OwnerPriority {
+		return types.BadRequestErrorf("invalid VRRP priority %d", c.VRRPPriority)
+	}
+	if c.VRRPRouterID == 0 {
+		if c.VRRPPriority != 0 {
+			return types.BadRequestErrorf("VRRP priority %d requires a VRRP router id", c.VRRPPriority)
+		}
+		return nil
+	}
+	if c.DefaultGatewayIPv4 == nil {
+		return types.BadRequestErrorf("VRRP router %d requires a default IPv4 gateway to share", c.VRRPRouterID)
+	}
+	return nil
+}
+
+// vrrpConfig returns the virtual router the network takes part in, holding
+// the gateway address on the bridge
+func vrrpConfig(c *networkConfiguration) vrrp.Config {
+	priority := c.VRRPPriority
+	if priority == 0 {
+		priority = vrrp.DefaultPriority
+	}
+	return vrrp.Config{
+		Interface: c.BridgeName,
+		RouterID:  uint8(c.VRRPRouterID),
+		Priority:  uint8(priority),
+		Addresses: []net.IP{c.DefaultGatewayIPv4},
+	}
+}
+
+// startVRRP runs the speaker electing the host holding the gateway of the
+// network, and reports its state transitions as network events
+func (d *driver) startVRRP(n *bridgeNetwork) error {
+	config := vrrpConfig(n.config)
+	s, err := vrrp.New(config, func(from, to vrrp.State) {
+		logrus.Infof("Host is %s for the gateway %s of network %s", to, n.config.DefaultGatewayIPv4, n.id)
+		if d.notifier == nil {
+			return
+		}
+		d.notifier.NotifyNetworkEvent(n.id, gatewayStateEvent, map[string]string{
+			"state":     to.String(),
+			"previous":  from.String(),
+			"gateway":   n.config.DefaultGatewayIPv4.String(),
+			"router-id": strconv.Itoa(int(config.RouterID)),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.speaker = s
+	n.Unlock()
+
+	s.Start()
+	return nil
+}
+
+// stopVRRP hands the gateway over to the backups
+func (n *bridgeNetwork) stopVRRP() {
+	n.Lock()
+	s := n.speaker
+	n.speaker = nil
+	n.Unlock()
+
+	if s != nil {
+		s.Stop()
+	}
+}
@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestVRRPConfig(t *testing.T) {
+	c := &networkConfiguration{DefaultGatewayIPv4: net.ParseIP("172.31.0.254")}
+	if err := c.fromMap(map[string]interface{}{"VRRPRouterID": "12", "VRRPPriority": "150"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.VRRPRouterID != 12 || c.VRRPPriority != 150 {
+		t.Fatalf("Unexpected VRRP configuration: %d %d", c.VRRPRouterID, c.VRRPPriority)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if vc := vrrpConfig(&networkConfiguration{VRRPRouterID: 1}); vc.Priority != 100 {
+		t.Fatalf("Unexpected default VRRP priority %d", vc.Priority)
+	}
+
+	for _, c := range []*networkConfiguration{
+		{VRRPRouterID: 256, DefaultGatewayIPv4: c.DefaultGatewayIPv4},
+		{VRRPRouterID: 1, VRRPPriority: -1, DefaultGatewayIPv4: c.DefaultGatewayIPv4},
+		{VRRPRouterID: 1, VRRPPriority: 256, DefaultGatewayIPv4: c.DefaultGatewayIPv4},
+		{VRRPPriority: 100, DefaultGatewayIPv4: c.DefaultGatewayIPv4},
+		{VRRPRouterID: 1},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("VRRP configuration %d %d was accepted", c.VRRPRouterID, c.VRRPPriority)
+		}
+	}
+}
+
+type testNotifier struct {
+	events []map[string]string
+	sync.Mutex
+}
+
+func (n *testNotifier) NotifyNetworkEvent(nid types.UUID, event string, attributes map[string]string) {
+	n.Lock()
+	defer n.Unlock()
+	if event == gatewayStateEvent {
+		n.events = append(n.events, attributes)
+	}
+}
+
+func (n *testNotifier) states() []string {
+	n.Lock()
+	defer n.Unlock()
+	var states []string
+	for _, ev := range n.events {
+		states = append(states, ev["state"])
+	}
+	return states
+}
+
+func TestVRRPGateway(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver().(*driver)
+	notifier := &testNotifier{}
+	d.notifier = notifier
+
+	_, addr, _ := net.ParseCIDR("172.31.0.1/24")
+	addr.IP = net.ParseIP("172.31.0.1")
+	config := &networkConfiguration{
+		BridgeName:            "vrrpbr0",
+		AddressIPv4:           addr,
+		DefaultGatewayIPv4:    net.ParseIP("172.31.0.254"),
+		AllowNonDefaultBridge: true,
+		VRRPRouterID:          12,
+		VRRPPriority:          255,
+	}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "vrrp", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// Alone on the link, the owner holds the gateway at once
+	link, err := netlink.LinkByName("vrrpbr0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasGateway := func() bool {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(config.DefaultGatewayIPv4) {
+				return true
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasGateway() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Gateway was not added to the bridge, states %v", notifier.states())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := d.DeleteNetwork(context.Background(), "vrrp"); err != nil {
+		t.Fatal(err)
+	}
+	states := notifier.states()
+	if len(states) != 2 || states[0] != "master" || states[1] != "init" {
+		t.Fatalf("Unexpected gateway states %v", states)
+	}
+}
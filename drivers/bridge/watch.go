@@ -0,0 +1,195 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netwatch"
+	"github.com/vishvananda/netlink"
+)
+
+// getWatcher returns the driver netlink watcher, creating it on first use
+func (d *driver) getWatcher() (*netwatch.Watcher, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.watcher != nil {
+		return d.watcher, nil
+	}
+
+	w, err := netwatch.New()
+	if err != nil {
+		return nil, err
+	}
+	d.watcher = w
+
+	return w, nil
+}
+
+// watchBridge restores the bridge state when the device is brought down or
+// loses its addresses, and marks the network degraded when the device is
+// deleted or renamed, as the endpoints can no longer be attached to it.
+// The bridge is watched by name, so that the network recovers once a
+// device gets the bridge name back.
+func (d *driver) watchBridge(n *bridgeNetwork) {
+	w, err := d.getWatcher()
+	if err != nil {
+		logrus.Warnf("Not watching bridge %s for external changes: %v", n.config.BridgeName, err)
+		return
+	}
+
+	stop, err := w.WatchName(n.config.BridgeName, func(ev netwatch.Event) {
+		d.handleBridgeEvent(n, ev)
+	})
+	if err != nil {
+		logrus.Warnf("Not watching bridge %s for external changes: %v", n.config.BridgeName, err)
+		return
+	}
+
+	n.Lock()
+	n.stopWatch = stop
+	n.Unlock()
+}
+
+func (d *driver) handleBridgeEvent(n *bridgeNetwork, ev netwatch.Event) {
+	// Events which race with the network removal are ignored
+	d.Lock()
+	cur, ok := d.networks[n.id]
+	d.Unlock()
+	if !ok || cur != n {
+		return
+	}
+
+	n.Lock()
+	link := n.bridge.Link
+	bridgeIPv4 := n.bridge.bridgeIPv4
+	bridgeIPv6 := n.bridge.bridgeIPv6
+	n.Unlock()
+
+	switch ev.Type {
+	case netwatch.LinkDown:
+		logrus.Warnf("Bridge %s of network %s was brought down, restoring it", ev.Name, n.id)
+		if err := netlink.LinkSetUp(link); err != nil {
+			n.setDegraded(fmt.Sprintf("failed to bring bridge %s up: %v", ev.Name, err))
+		}
+	case netwatch.AddrDeleted:
+		if !isBridgeAddr(ev, bridgeIPv4) && !isBridgeAddr(ev, bridgeIPv6) {
+			return
+		}
+		logrus.Warnf("Address %s was removed from bridge %s of network %s, restoring it", ev.Addr, ev.Name, n.id)
+		if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: ev.Addr}); err != nil {
+			n.setDegraded(fmt.Sprintf("failed to restore address %s on bridge %s: %v", ev.Addr, ev.Name, err))
+		}
+	case netwatch.LinkDeleted, netwatch.LinkRenamed:
+		n.setDegraded(fmt.Sprintf("bridge %s was %s", n.config.BridgeName, ev.Type))
+		return
+	case netwatch.LinkAdded:
+		logrus.Warnf("Bridge %s of network %s is back, restoring it", ev.Name, n.id)
+		if err := restoreBridge(ev.Name, bridgeIPv4, bridgeIPv6); err != nil {
+			n.setDegraded(fmt.Sprintf("failed to restore bridge %s: %v", ev.Name, err))
+			return
+		}
+	}
+
+	n.checkRecovered()
+}
+
+// restoreBridge brings up the device which got the bridge name and adds it
+// the bridge addresses it misses
+func restoreBridge(name string, addrs ...*net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if link.Type() != "bridge" {
+		return fmt.Errorf("device %s is a %s, not a bridge", name, link.Type())
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if addr == nil {
+			continue
+		}
+		ok, err := hasAddr(link, addr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: addr}); err != nil {
+			return fmt.Errorf("failed to add address %s: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+func isBridgeAddr(ev netwatch.Event, addr *net.IPNet) bool {
+	return addr != nil && ev.Addr.IP.Equal(addr.IP) && ev.Addr.Mask.String() == addr.Mask.String()
+}
+
+func hasAddr(link netlink.Link, addr *net.IPNet) (bool, error) {
+	family := netlink.FAMILY_V4
+	if addr.IP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	addrs, err := netlink.AddrList(link, family)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(addr.IP) && a.Mask.String() == addr.Mask.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setDegraded records why the network can no longer serve new endpoints
+func (n *bridgeNetwork) setDegraded(reason string) {
+	logrus.Errorf("Network %s is degraded: %s", n.id, reason)
+	n.Lock()
+	n.degraded = reason
+	n.Unlock()
+}
+
+// checkRecovered clears the degraded state of the network once its bridge
+// is back up, carrying the bridge addresses, and returns the reason the
+// network is still degraded, if any. It runs after the bridge events and
+// before the endpoint creations, as a bridge brought back up outside of the
+// driver is not reported.
+func (n *bridgeNetwork) checkRecovered() string {
+	n.Lock()
+	degraded := n.degraded
+	name := n.config.BridgeName
+	bridgeIPv4 := n.bridge.bridgeIPv4
+	bridgeIPv6 := n.bridge.bridgeIPv6
+	n.Unlock()
+
+	if degraded == "" {
+		return ""
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil || link.Type() != "bridge" || link.Attrs().Flags&net.FlagUp == 0 {
+		return degraded
+	}
+	for _, addr := range []*net.IPNet{bridgeIPv4, bridgeIPv6} {
+		if addr == nil {
+			continue
+		}
+		if ok, err := hasAddr(link, addr); err != nil || !ok {
+			return degraded
+		}
+	}
+
+	n.Lock()
+	n.bridge.Link = link
+	n.degraded = ""
+	n.Unlock()
+	logrus.Infof("Network %s recovered: bridge %s is back", n.id, name)
+
+	return ""
+}
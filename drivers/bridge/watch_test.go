@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/netwatch"
+	"github.com/vishvananda/netlink"
+)
+
+func waitDegraded(t *testing.T, n *bridgeNetwork, degraded bool) {
+	for i := 0; i < 50; i++ {
+		n.Lock()
+		reason := n.degraded
+		n.Unlock()
+		if (reason != "") == degraded {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Network degraded state did not become %t", degraded)
+}
+
+func TestBridgeRecovery(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+	dr := d.(*driver)
+
+	config := &networkConfiguration{BridgeName: DefaultBridgeName}
+	genericOption := map[string]interface{}{netlabel.GenericData: config}
+	if err := d.CreateNetwork(context.Background(), "rec", genericOption); err != nil {
+		t.Fatal(err)
+	}
+	n := dr.networks["rec"]
+	bridgeIPv4 := n.bridge.bridgeIPv4
+
+	if err := netlink.LinkDel(n.bridge.Link); err != nil {
+		t.Fatal(err)
+	}
+	waitDegraded(t, n, true)
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint(context.Background(), "rec", "ep1", te, nil); err == nil {
+		t.Fatal("Expected the endpoint creation to fail on a degraded network")
+	}
+
+	// A bridge getting the name back is restored and the network recovers.
+	// The watcher handlers do not run in the test namespace, the event is
+	// handled here instead.
+	n.stopWatch()
+	if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: DefaultBridgeName}}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.handleBridgeEvent(n, netwatch.Event{Type: netwatch.LinkAdded, Index: link.Attrs().Index, Name: DefaultBridgeName})
+	waitDegraded(t, n, false)
+
+	if link, err = netlink.LinkByName(DefaultBridgeName); err != nil {
+		t.Fatal(err)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		t.Fatal("Bridge was not brought up")
+	}
+	if ok, err := hasAddr(link, bridgeIPv4); err != nil || !ok {
+		t.Fatalf("Bridge address %s was not restored: %v", bridgeIPv4, err)
+	}
+
+	if err := d.CreateEndpoint(context.Background(), "rec", "ep1", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint on the recovered network: %v", err)
+	}
+}
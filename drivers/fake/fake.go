@@ -0,0 +1,517 @@
+// Package fake provides a network driver keeping its networks and endpoints
+// in memory, for the applications embedding libnetwork to unit test their
+// orchestration without root privileges nor network namespaces. The driver
+// allocates the endpoint addresses itself, records the calls it is made and
+// can be told to fail them. The package also provides IPAM, an in-memory
+// address manager recording its calls the same way.
+package fake
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkType is the network type the driver registers by default
+const NetworkType = "fake"
+
+// DefaultSubnet is the subnet of the networks which do not set one
+const DefaultSubnet = "10.0.0.0/24"
+
+// The names of the driver methods, as recorded in the calls
+const (
+	MethodConfig                = "Config"
+	MethodCreateNetwork         = "CreateNetwork"
+	MethodDeleteNetwork         = "DeleteNetwork"
+	MethodCreateEndpoint        = "CreateEndpoint"
+	MethodDeleteEndpoint        = "DeleteEndpoint"
+	MethodEndpointOperInfo      = "EndpointOperInfo"
+	MethodJoin                  = "Join"
+	MethodLeave                 = "Leave"
+	MethodChangeEndpointAddress = "ChangeEndpointAddress"
+	MethodPoolStatus            = "PoolStatus"
+)
+
+// Call is the record of a call made to the driver
+type Call struct {
+	Method     string
+	NetworkID  types.UUID
+	EndpointID types.UUID
+	// SandboxKey is the sandbox of a Join
+	SandboxKey string
+	Options    map[string]interface{}
+	// Err is the error the call returned
+	Err error
+}
+
+// Endpoint is the state of an endpoint of the driver
+type Endpoint struct {
+	ID         types.UUID
+	Address    *net.IPNet
+	MacAddress net.HardwareAddr
+	// SandboxKey is the sandbox the endpoint joined, empty if none
+	SandboxKey string
+}
+
+type network struct {
+	id        types.UUID
+	subnet    *net.IPNet
+	gateway   net.IP
+	allocator *ipallocator.IPAllocator
+	endpoints map[types.UUID]*Endpoint
+}
+
+// Driver is the in-memory network driver. Its methods are safe for
+// concurrent use.
+type Driver struct {
+	networkType string
+	networks    map[types.UUID]*network
+	calls       []Call
+	failures    map[string]error
+	noRecording bool
+	noInterface bool
+	sync.Mutex
+}
+
+// New returns a driver without networks, registering as NetworkType
+func New() *Driver {
+	return &Driver{
+		networkType: NetworkType,
+		networks:    map[types.UUID]*network{},
+		failures:    map[string]error{},
+	}
+}
+
+// SetRecording turns the recording of the calls on, as it is by default, or
+// off, for the benchmarks making many calls to hold no memory for them. The
+// failures set with FailOn apply either way.
+func (d *Driver) SetRecording(record bool) {
+	d.Lock()
+	d.noRecording = !record
+	d.Unlock()
+}
+
+// SetInterfaces sets whether the endpoints the driver creates get an
+// interface, and a gateway when they join, as they do by default. The
+// endpoints without them keep their address in the driver and join the
+// sandboxes without touching the host, the default sandbox included.
+func (d *Driver) SetInterfaces(add bool) {
+	d.Lock()
+	d.noInterface = !add
+	d.Unlock()
+}
+
+// Register registers the driver for the network type, NetworkType if
+// empty, with the scope. The libnetwork controller is a
+// driverapi.DriverCallback.
+func (d *Driver) Register(dc driverapi.DriverCallback, networkType string, scope driverapi.Scope) error {
+	if networkType == "" {
+		networkType = NetworkType
+	}
+	d.Lock()
+	d.networkType = networkType
+	d.Unlock()
+	return dc.RegisterDriver(networkType, d, driverapi.Capability{Scope: scope})
+}
+
+// Calls returns the calls made to the driver, oldest first
+func (d *Driver) Calls() []Call {
+	d.Lock()
+	defer d.Unlock()
+	return append([]Call(nil), d.calls...)
+}
+
+// CallsTo returns the calls made to the method of the driver, oldest first
+func (d *Driver) CallsTo(method string) []Call {
+	d.Lock()
+	defer d.Unlock()
+	var calls []Call
+	for _, c := range d.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets the calls made to the driver
+func (d *Driver) ResetCalls() {
+	d.Lock()
+	d.calls = nil
+	d.Unlock()
+}
+
+// FailOn makes the calls to the method fail with err, without changing the
+// driver state, until it is called again with a nil error
+func (d *Driver) FailOn(method string, err error) {
+	d.Lock()
+	defer d.Unlock()
+	if err == nil {
+		delete(d.failures, method)
+		return
+	}
+	d.failures[method] = err
+}
+
+// Networks returns the ids of the networks of the driver
+func (d *Driver) Networks() []types.UUID {
+	d.Lock()
+	defer d.Unlock()
+	ids := make([]types.UUID, 0, len(d.networks))
+	for id := range d.networks {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Endpoints returns the ids of the endpoints of the network
+func (d *Driver) Endpoints(nid types.UUID) []types.UUID {
+	d.Lock()
+	defer d.Unlock()
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil
+	}
+	ids := make([]types.UUID, 0, len(n.endpoints))
+	for id := range n.endpoints {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Endpoint returns a copy of the state of the endpoint of the network, or
+// nil if there is none
+func (d *Driver) Endpoint(nid, eid types.UUID) *Endpoint {
+	d.Lock()
+	defer d.Unlock()
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil
+	}
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil
+	}
+	cp := *ep
+	cp.Address = types.GetIPNetCopy(ep.Address)
+	return &cp
+}
+
+// record records the call and returns the failure set for its method. It is
+// called with the driver lock held.
+func (d *Driver) record(c Call) error {
+	c.Err = d.failures[c.Method]
+	if !d.noRecording {
+		d.calls = append(d.calls, c)
+	}
+	return c.Err
+}
+
+// fail records the error as the one returned by the last call
+func (d *Driver) fail(err error) error {
+	if !d.noRecording {
+		d.calls[len(d.calls)-1].Err = err
+	}
+	return err
+}
+
+// Config records the configuration pushed to the driver
+func (d *Driver) Config(options map[string]interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.record(Call{Method: MethodConfig, Options: options})
+}
+
+// CreateNetwork creates the network, with the subnet set by the "Subnet"
+// key of its generic data, DefaultSubnet otherwise. The first address of
+// the subnet is the gateway.
+func (d *Driver) CreateNetwork(ctx context.Context, nid types.UUID, options map[string]interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodCreateNetwork, NetworkID: nid, Options: options}); err != nil {
+		return err
+	}
+
+	if _, ok := d.networks[nid]; ok {
+		return d.fail(types.ForbiddenErrorf("network %s exists", nid))
+	}
+
+	subnet, err := parseSubnet(options)
+	if err != nil {
+		return d.fail(err)
+	}
+	n := &network{
+		id:        nid,
+		subnet:    subnet,
+		allocator: ipallocator.New(),
+		endpoints: map[types.UUID]*Endpoint{},
+	}
+	if n.gateway, err = n.allocator.RequestIP(subnet, nil); err != nil {
+		return d.fail(types.BadRequestErrorf("no gateway address in subnet %s: %v", subnet, err))
+	}
+	d.networks[nid] = n
+	return nil
+}
+
+// parseSubnet returns the subnet of the generic data of the network options
+func parseSubnet(opts map[string]interface{}) (*net.IPNet, error) {
+	s := DefaultSubnet
+	var data map[string]interface{}
+	switch g := opts[netlabel.GenericData].(type) {
+	case map[string]interface{}:
+		data = g
+	case options.Generic:
+		data = g
+	}
+	if v, ok := data["Subnet"]; ok {
+		if s, ok = v.(string); !ok {
+			return nil, types.BadRequestErrorf("invalid type %T for Subnet value", v)
+		}
+	}
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, types.BadRequestErrorf("invalid IPv4 subnet %q", s)
+	}
+	return subnet, nil
+}
+
+// DeleteNetwork deletes the network, which must have no endpoints
+func (d *Driver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodDeleteNetwork, NetworkID: nid}); err != nil {
+		return err
+	}
+
+	n, ok := d.networks[nid]
+	if !ok {
+		return d.fail(types.NotFoundErrorf("network %s not found", nid))
+	}
+	if len(n.endpoints) != 0 {
+		return d.fail(types.ForbiddenErrorf("network %s has active endpoints", nid))
+	}
+	delete(d.networks, nid)
+	return nil
+}
+
+func (d *Driver) network(nid types.UUID) (*network, error) {
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+	return n, nil
+}
+
+func (n *network) endpoint(eid types.UUID) (*Endpoint, error) {
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil, types.NotFoundErrorf("endpoint %s not found in network %s", eid, n.id)
+	}
+	return ep, nil
+}
+
+// CreateEndpoint creates the endpoint with the address and MAC address of
+// the interface libnetwork passes, or with the next free address of the
+// subnet and a MAC address derived from it.
+func (d *Driver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodCreateEndpoint, NetworkID: nid, EndpointID: eid, Options: options}); err != nil {
+		return err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return d.fail(err)
+	}
+	if _, ok := n.endpoints[eid]; ok {
+		return d.fail(driverapi.ErrEndpointExists(eid))
+	}
+
+	var (
+		ip  net.IP
+		mac net.HardwareAddr
+	)
+	if epInfo != nil {
+		if ifaces := epInfo.Interfaces(); len(ifaces) != 0 {
+			ip = ifaces[0].Address().IP
+			mac = ifaces[0].MacAddress()
+		}
+	}
+	if ip, err = n.allocator.RequestIP(n.subnet, ip); err != nil {
+		return d.fail(err)
+	}
+	if mac == nil {
+		mac = macAddress(ip)
+	}
+
+	ep := &Endpoint{ID: eid, Address: &net.IPNet{IP: ip, Mask: n.subnet.Mask}, MacAddress: mac}
+	if epInfo != nil && len(epInfo.Interfaces()) == 0 && !d.noInterface {
+		if err := epInfo.AddInterface(1, mac, *ep.Address, net.IPNet{}); err != nil {
+			n.allocator.ReleaseIP(n.subnet, ip)
+			return d.fail(err)
+		}
+	}
+	n.endpoints[eid] = ep
+	return nil
+}
+
+// macAddress derives a locally administered MAC address from the IPv4
+// address
+func macAddress(ip net.IP) net.HardwareAddr {
+	ip = ip.To4()
+	return net.HardwareAddr{0x02, 0x42, ip[0], ip[1], ip[2], ip[3]}
+}
+
+// DeleteEndpoint deletes the endpoint and releases its address
+func (d *Driver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodDeleteEndpoint, NetworkID: nid, EndpointID: eid}); err != nil {
+		return err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return d.fail(err)
+	}
+	ep, err := n.endpoint(eid)
+	if err != nil {
+		return d.fail(err)
+	}
+	n.allocator.ReleaseIP(n.subnet, ep.Address.IP)
+	delete(n.endpoints, eid)
+	return nil
+}
+
+// EndpointOperInfo reports the MAC address of the endpoint
+func (d *Driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodEndpointOperInfo, NetworkID: nid, EndpointID: eid}); err != nil {
+		return nil, err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	ep, err := n.endpoint(eid)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	return map[string]interface{}{netlabel.MacAddress: ep.MacAddress}, nil
+}
+
+// Join records the sandbox the endpoint joins and sets the gateway of the
+// network, unless the endpoints get no interface
+func (d *Driver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodJoin, NetworkID: nid, EndpointID: eid, SandboxKey: sboxKey, Options: options}); err != nil {
+		return err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return d.fail(err)
+	}
+	ep, err := n.endpoint(eid)
+	if err != nil {
+		return d.fail(err)
+	}
+	if ep.SandboxKey != "" {
+		return d.fail(types.ForbiddenErrorf("endpoint %s already joined sandbox %s", eid, ep.SandboxKey))
+	}
+	if jinfo != nil && !d.noInterface {
+		if err := jinfo.SetGateway(n.gateway); err != nil {
+			return d.fail(err)
+		}
+	}
+	ep.SandboxKey = sboxKey
+	return nil
+}
+
+// Leave detaches the endpoint from its sandbox
+func (d *Driver) Leave(ctx context.Context, nid, eid types.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodLeave, NetworkID: nid, EndpointID: eid}); err != nil {
+		return err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return d.fail(err)
+	}
+	ep, err := n.endpoint(eid)
+	if err != nil {
+		return d.fail(err)
+	}
+	ep.SandboxKey = ""
+	return nil
+}
+
+// Type returns the network type the driver is registered as
+func (d *Driver) Type() string {
+	d.Lock()
+	defer d.Unlock()
+	return d.networkType
+}
+
+// ChangeEndpointAddress moves the endpoint to the requested address, or to
+// the next free one if ip is nil
+func (d *Driver) ChangeEndpointAddress(nid, eid types.UUID, ip net.IP) (*net.IPNet, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodChangeEndpointAddress, NetworkID: nid, EndpointID: eid}); err != nil {
+		return nil, err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	ep, err := n.endpoint(eid)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	if ip, err = n.allocator.RequestIP(n.subnet, ip); err != nil {
+		return nil, d.fail(err)
+	}
+	n.allocator.ReleaseIP(n.subnet, ep.Address.IP)
+	ep.Address = &net.IPNet{IP: ip, Mask: n.subnet.Mask}
+	return types.GetIPNetCopy(ep.Address), nil
+}
+
+// PoolStatus reports the usage of the subnet of the network, the consumers
+// being the endpoints
+func (d *Driver) PoolStatus(nid types.UUID) ([]*ipam.PoolStatus, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.record(Call{Method: MethodPoolStatus, NetworkID: nid}); err != nil {
+		return nil, err
+	}
+
+	n, err := d.network(nid)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	ps, err := n.allocator.PoolStatus(n.subnet)
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	counts := make(map[string]uint64, len(n.endpoints))
+	for id := range n.endpoints {
+		counts[string(id)] = 1
+	}
+	ps.TopConsumers = ipam.TopConsumers(counts, ipam.MaxTopConsumers)
+	return []*ipam.PoolStatus{ps}, nil
+}
@@ -0,0 +1,160 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+type registry map[string]driverapi.Driver
+
+func (r registry) RegisterDriver(name string, driver driverapi.Driver, capability driverapi.Capability) error {
+	r[name] = driver
+	return nil
+}
+
+type testEndpoint struct {
+	ip  net.IPNet
+	mac net.HardwareAddr
+}
+
+func (ep *testEndpoint) Interfaces() []driverapi.InterfaceInfo {
+	return nil
+}
+
+func (ep *testEndpoint) AddInterface(ID int, mac net.HardwareAddr, ipv4 net.IPNet, ipv6 net.IPNet) error {
+	ep.ip = ipv4
+	ep.mac = mac
+	return nil
+}
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	r := registry{}
+	if err := d.Register(r, "", driverapi.LocalScope); err != nil {
+		t.Fatal(err)
+	}
+	if r[NetworkType] != d || d.Type() != NetworkType {
+		t.Fatalf("Driver was not registered as %s", NetworkType)
+	}
+
+	opts := map[string]interface{}{netlabel.GenericData: options.Generic{"Subnet": "192.0.2.0/28"}}
+	if err := d.CreateNetwork(ctx, "net1", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateNetwork(ctx, "net1", nil); err == nil {
+		t.Fatal("Network was created twice")
+	}
+
+	te := &testEndpoint{}
+	if err := d.CreateEndpoint(ctx, "net1", "ep1", te, nil); err != nil {
+		t.Fatal(err)
+	}
+	if te.ip.String() != "192.0.2.2/28" || te.mac.String() != "02:42:c0:00:02:02" {
+		t.Fatalf("Unexpected interface %s %s", te.ip.String(), te.mac)
+	}
+
+	ps, err := d.PoolStatus("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps[0].Used != 2 || len(ps[0].TopConsumers) != 1 {
+		t.Fatalf("Unexpected pool status %+v", ps[0])
+	}
+
+	if err := d.Join(ctx, "net1", "ep1", "/var/run/netns/sb1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ep := d.Endpoint("net1", "ep1"); ep == nil || ep.SandboxKey != "/var/run/netns/sb1" {
+		t.Fatalf("Unexpected endpoint state %+v", ep)
+	}
+	if err := d.DeleteNetwork(ctx, "net1"); err == nil {
+		t.Fatal("Network with endpoints was deleted")
+	}
+
+	if calls := d.CallsTo(MethodJoin); len(calls) != 1 || calls[0].EndpointID != "ep1" {
+		t.Fatalf("Unexpected join calls %v", calls)
+	}
+	if calls := d.CallsTo(MethodCreateNetwork); len(calls) != 2 || calls[1].Err == nil {
+		t.Fatalf("Failed network creation was not recorded: %v", calls)
+	}
+}
+
+func TestDriverFailures(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	if err := d.CreateNetwork(ctx, "net1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	injected := errors.New("injected failure")
+	d.FailOn(MethodCreateEndpoint, injected)
+	if err := d.CreateEndpoint(ctx, "net1", "ep1", &testEndpoint{}, nil); err != injected {
+		t.Fatalf("Expected the injected failure, got %v", err)
+	}
+	if d.Endpoint("net1", "ep1") != nil {
+		t.Fatal("Failed endpoint creation changed the driver state")
+	}
+
+	d.FailOn(MethodCreateEndpoint, nil)
+	if err := d.CreateEndpoint(ctx, "net1", "ep1", &testEndpoint{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteEndpoint(ctx, "net1", "ep2"); err == nil {
+		t.Fatal("Unknown endpoint was deleted")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Unexpected error type %T: %v", err, err)
+	}
+
+	d.ResetCalls()
+	if len(d.Calls()) != 0 {
+		t.Fatal("Calls were not reset")
+	}
+
+	if err := d.CreateNetwork(ctx, "net2", map[string]interface{}{netlabel.GenericData: map[string]interface{}{"Subnet": "2001:db8::/64"}}); err == nil {
+		t.Fatal("IPv6 subnet was accepted")
+	}
+}
+
+func TestDriverWithoutRecordingNorInterfaces(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	d.SetRecording(false)
+	d.SetInterfaces(false)
+	if err := d.CreateNetwork(ctx, "net1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	te := &testEndpoint{}
+	if err := d.CreateEndpoint(ctx, "net1", "ep1", te, nil); err != nil {
+		t.Fatal(err)
+	}
+	if te.mac != nil {
+		t.Fatalf("Endpoint was given interface %s %s", te.ip.String(), te.mac)
+	}
+	if ep := d.Endpoint("net1", "ep1"); ep == nil || ep.Address.String() != "10.0.0.2/24" {
+		t.Fatalf("Unexpected endpoint state %+v", ep)
+	}
+	if eps := d.Endpoints("net1"); len(eps) != 1 || eps[0] != "ep1" {
+		t.Fatalf("Unexpected endpoints %v", eps)
+	}
+	if err := d.CreateEndpoint(ctx, "net1", "ep1", te, nil); err == nil {
+		t.Fatal("Endpoint was created twice")
+	}
+
+	injected := errors.New("injected failure")
+	d.FailOn(MethodLeave, injected)
+	if err := d.Leave(ctx, "net1", "ep1"); err != injected {
+		t.Fatalf("Expected the injected failure, got %v", err)
+	}
+	if calls := d.Calls(); len(calls) != 0 {
+		t.Fatalf("Calls were recorded: %v", calls)
+	}
+}
@@ -0,0 +1,265 @@
+package fake
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/types"
+)
+
+// The names of the IPAM methods, as recorded in the calls
+const (
+	MethodRequest       = "Request"
+	MethodRequestV6     = "RequestV6"
+	MethodRelease       = "Release"
+	MethodAddSubnet     = "AddSubnet"
+	MethodRemoveSubnet  = "RemoveSubnet"
+	MethodAddVendorInfo = "AddVendorInfo"
+)
+
+// IPAMCall is the record of a call made to the IPAM
+type IPAMCall struct {
+	Method       string
+	AddressSpace ipam.AddressSpace
+	// Subnet is the subnet of a subnet configuration or of a request
+	Subnet *net.IPNet
+	// Address is the address requested, released or handed out
+	Address net.IP
+	// Err is the error the call returned
+	Err error
+}
+
+// IPAM is the in-memory address manager, an ipam.IPAM and ipam.Config
+// handing out the addresses of the subnets it is configured with, lowest
+// first. Its methods are safe for concurrent use.
+type IPAM struct {
+	subnets    map[ipam.AddressSpace][]*ipam.SubnetInfo
+	allocators map[ipam.AddressSpace]*ipallocator.IPAllocator
+	allocated  map[ipam.AddressSpace]map[string]bool
+	calls      []IPAMCall
+	failures   map[string]error
+	sync.Mutex
+}
+
+// NewIPAM returns an address manager without subnets
+func NewIPAM() *IPAM {
+	return &IPAM{
+		subnets:    map[ipam.AddressSpace][]*ipam.SubnetInfo{},
+		allocators: map[ipam.AddressSpace]*ipallocator.IPAllocator{},
+		allocated:  map[ipam.AddressSpace]map[string]bool{},
+		failures:   map[string]error{},
+	}
+}
+
+// Calls returns the calls made to the IPAM, oldest first
+func (a *IPAM) Calls() []IPAMCall {
+	a.Lock()
+	defer a.Unlock()
+	return append([]IPAMCall(nil), a.calls...)
+}
+
+// CallsTo returns the calls made to the method of the IPAM, oldest first
+func (a *IPAM) CallsTo(method string) []IPAMCall {
+	a.Lock()
+	defer a.Unlock()
+	var calls []IPAMCall
+	for _, c := range a.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets the calls made to the IPAM
+func (a *IPAM) ResetCalls() {
+	a.Lock()
+	a.calls = nil
+	a.Unlock()
+}
+
+// FailOn makes the calls to the method fail with err, without changing the
+// IPAM state, until it is called again with a nil error. Release, which
+// returns no error, is not failed.
+func (a *IPAM) FailOn(method string, err error) {
+	a.Lock()
+	defer a.Unlock()
+	if err == nil {
+		delete(a.failures, method)
+		return
+	}
+	a.failures[method] = err
+}
+
+// Allocated tells whether the address of the address space is handed out
+func (a *IPAM) Allocated(space ipam.AddressSpace, ip net.IP) bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.allocated[space][ip.String()]
+}
+
+// record records the call and returns the failure set for its method, else
+// the error of ctx once it is done. It is called with the IPAM lock held.
+func (a *IPAM) record(ctx context.Context, c IPAMCall) error {
+	c.Err = a.failures[c.Method]
+	if c.Err == nil {
+		c.Err = ctx.Err()
+	}
+	a.calls = append(a.calls, c)
+	return c.Err
+}
+
+// fail records the error as the one returned by the last call
+func (a *IPAM) fail(err error) error {
+	a.calls[len(a.calls)-1].Err = err
+	return err
+}
+
+// AddSubnet adds the subnet to the address space
+func (a *IPAM) AddSubnet(ctx context.Context, space ipam.AddressSpace, si *ipam.SubnetInfo) error {
+	a.Lock()
+	defer a.Unlock()
+	c := IPAMCall{Method: MethodAddSubnet, AddressSpace: space}
+	if si != nil {
+		c.Subnet = si.Subnet
+	}
+	if err := a.record(ctx, c); err != nil {
+		return err
+	}
+
+	if si == nil {
+		return a.fail(ipam.ErrInvalidSubnet)
+	}
+	if err := si.Validate(); err != nil {
+		return a.fail(err)
+	}
+	for _, s := range a.subnets[space] {
+		if s.Subnet.Contains(si.Subnet.IP) || si.Subnet.Contains(s.Subnet.IP) {
+			return a.fail(ipam.ErrOverlapSubnet)
+		}
+	}
+	if a.allocators[space] == nil {
+		a.allocators[space] = ipallocator.New()
+		a.allocated[space] = map[string]bool{}
+	}
+	cp := *si
+	cp.Subnet = types.GetIPNetCopy(si.Subnet)
+	a.subnets[space] = append(a.subnets[space], &cp)
+	return nil
+}
+
+// RemoveSubnet removes the subnet, and the addresses handed out from it,
+// from the address space
+func (a *IPAM) RemoveSubnet(ctx context.Context, space ipam.AddressSpace, subnet *net.IPNet) error {
+	a.Lock()
+	defer a.Unlock()
+	if err := a.record(ctx, IPAMCall{Method: MethodRemoveSubnet, AddressSpace: space, Subnet: subnet}); err != nil {
+		return err
+	}
+
+	if subnet == nil {
+		return a.fail(ipam.ErrInvalidSubnet)
+	}
+	subnets := a.subnets[space]
+	for i, s := range subnets {
+		if s.Subnet.String() != subnet.String() {
+			continue
+		}
+		for ip := range a.allocated[space] {
+			if addr := net.ParseIP(ip); s.Subnet.Contains(addr) {
+				a.allocators[space].ReleaseIP(s.Subnet, addr)
+				delete(a.allocated[space], ip)
+			}
+		}
+		a.subnets[space] = append(subnets[:i], subnets[i+1:]...)
+		return nil
+	}
+	return a.fail(ipam.ErrSubnetNotFound)
+}
+
+// AddVendorInfo records the vendor data, which the IPAM ignores
+func (a *IPAM) AddVendorInfo(ctx context.Context, data []byte) error {
+	a.Lock()
+	defer a.Unlock()
+	return a.record(ctx, IPAMCall{Method: MethodAddVendorInfo})
+}
+
+// Request hands out an IPv4 address of the address space, from the subnet
+// of the request if set, the first IPv4 subnet otherwise
+func (a *IPAM) Request(ctx context.Context, space ipam.AddressSpace, req *ipam.AddressRequest) (*ipam.AddressResponse, error) {
+	return a.request(ctx, MethodRequest, space, req, false)
+}
+
+// RequestV6 hands out an IPv6 address of the address space, from the
+// subnet of the request if set, the first IPv6 subnet otherwise
+func (a *IPAM) RequestV6(ctx context.Context, space ipam.AddressSpace, req *ipam.AddressRequest) (*ipam.AddressResponse, error) {
+	return a.request(ctx, MethodRequestV6, space, req, true)
+}
+
+func (a *IPAM) request(ctx context.Context, method string, space ipam.AddressSpace, req *ipam.AddressRequest, v6 bool) (*ipam.AddressResponse, error) {
+	a.Lock()
+	defer a.Unlock()
+	c := IPAMCall{Method: method, AddressSpace: space}
+	if req != nil {
+		if req.Subnet.IP != nil {
+			c.Subnet = &req.Subnet
+		}
+		c.Address = req.Address
+	}
+	if err := a.record(ctx, c); err != nil {
+		return nil, err
+	}
+
+	if req == nil {
+		return nil, a.fail(ipam.ErrInvalidRequest)
+	}
+	if err := req.Validate(); err != nil {
+		return nil, a.fail(err)
+	}
+
+	var si *ipam.SubnetInfo
+	for _, s := range a.subnets[space] {
+		if c.Subnet != nil {
+			if s.Subnet.String() == c.Subnet.String() {
+				si = s
+				break
+			}
+		} else if (s.Subnet.IP.To4() == nil) == v6 {
+			si = s
+			break
+		}
+	}
+	if si == nil {
+		if c.Subnet != nil {
+			return nil, a.fail(ipam.ErrSubnetNotFound)
+		}
+		return nil, a.fail(ipam.ErrNoAvailableSubnet)
+	}
+
+	ip, err := a.allocators[space].RequestIP(si.Subnet, req.Address)
+	if err != nil {
+		return nil, a.fail(err)
+	}
+	a.allocated[space][ip.String()] = true
+	a.calls[len(a.calls)-1].Address = ip
+
+	return &ipam.AddressResponse{Address: ip, Subnet: *si}, nil
+}
+
+// Release gives back the address to the address space
+func (a *IPAM) Release(ctx context.Context, space ipam.AddressSpace, ip net.IP) {
+	a.Lock()
+	defer a.Unlock()
+	a.calls = append(a.calls, IPAMCall{Method: MethodRelease, AddressSpace: space, Address: ip})
+
+	for _, s := range a.subnets[space] {
+		if s.Subnet.Contains(ip) {
+			a.allocators[space].ReleaseIP(s.Subnet, ip)
+			delete(a.allocated[space], ip.String())
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/ipam"
+)
+
+func TestIPAM(t *testing.T) {
+	a := NewIPAM()
+	var _ ipam.IPAM = a
+	var _ ipam.Config = a
+
+	_, sub, _ := net.ParseCIDR("192.0.2.0/28")
+	_, sub6, _ := net.ParseCIDR("2001:db8::/64")
+	if err := a.AddSubnet(context.Background(), "default", &ipam.SubnetInfo{Subnet: sub}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSubnet(context.Background(), "default", &ipam.SubnetInfo{Subnet: sub6}); err != nil {
+		t.Fatal(err)
+	}
+	_, inner, _ := net.ParseCIDR("192.0.2.8/29")
+	if err := a.AddSubnet(context.Background(), "default", &ipam.SubnetInfo{Subnet: inner}); err != ipam.ErrOverlapSubnet {
+		t.Fatalf("Expected the overlapping subnet to be refused, got %v", err)
+	}
+
+	rsp, err := a.Request(context.Background(), "default", &ipam.AddressRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.Contains(rsp.Address) || rsp.Subnet.Subnet.String() != sub.String() {
+		t.Fatalf("Unexpected response %v", rsp)
+	}
+	if !a.Allocated("default", rsp.Address) {
+		t.Fatalf("Address %s not reported as allocated", rsp.Address)
+	}
+	rsp6, err := a.RequestV6(context.Background(), "default", &ipam.AddressRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub6.Contains(rsp6.Address) {
+		t.Fatalf("Unexpected IPv6 address %s", rsp6.Address)
+	}
+	if _, err := a.Request(context.Background(), "default", &ipam.AddressRequest{Subnet: *sub, Address: rsp.Address}); err == nil {
+		t.Fatalf("Address %s was handed out twice", rsp.Address)
+	}
+	if _, err := a.Request(context.Background(), "other", &ipam.AddressRequest{}); err != ipam.ErrNoAvailableSubnet {
+		t.Fatalf("Expected no subnet in the unknown address space, got %v", err)
+	}
+
+	a.Release(context.Background(), "default", rsp.Address)
+	if a.Allocated("default", rsp.Address) {
+		t.Fatalf("Released address %s still allocated", rsp.Address)
+	}
+
+	calls := a.CallsTo(MethodRequest)
+	if len(calls) != 3 || !calls[0].Address.Equal(rsp.Address) || calls[1].Err == nil {
+		t.Fatalf("Unexpected request calls %v", calls)
+	}
+	if calls := a.CallsTo(MethodRelease); len(calls) != 1 || !calls[0].Address.Equal(rsp.Address) {
+		t.Fatalf("Unexpected release calls %v", calls)
+	}
+
+	injected := errors.New("injected failure")
+	a.FailOn(MethodRequest, injected)
+	if _, err := a.Request(context.Background(), "default", &ipam.AddressRequest{}); err != injected {
+		t.Fatalf("Expected the injected failure, got %v", err)
+	}
+	a.FailOn(MethodRequest, nil)
+
+	if err := a.RemoveSubnet(context.Background(), "default", sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Request(context.Background(), "default", &ipam.AddressRequest{Subnet: *sub}); err != ipam.ErrSubnetNotFound {
+		t.Fatalf("Expected the removed subnet not to be found, got %v", err)
+	}
+
+	a.ResetCalls()
+	if len(a.Calls()) != 0 {
+		t.Fatal("Calls were not reset")
+	}
+}
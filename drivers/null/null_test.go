@@ -1,6 +1,7 @@
 package null
 
 import (
+	"context"
 	"testing"
 
 	_ "github.com/docker/libnetwork/netutils"
@@ -14,7 +15,7 @@ func TestDriver(t *testing.T) {
 		t.Fatalf("Unexpected network type returned by driver")
 	}
 
-	err := d.CreateNetwork("first", nil)
+	err := d.CreateNetwork(context.Background(), "first", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -23,7 +24,7 @@ func TestDriver(t *testing.T) {
 		t.Fatalf("Unexpected network id stored")
 	}
 
-	err = d.CreateNetwork("second", nil)
+	err = d.CreateNetwork(context.Background(), "second", nil)
 	if err == nil {
 		t.Fatalf("Second network creation should fail on this driver")
 	}
@@ -31,7 +32,7 @@ func TestDriver(t *testing.T) {
 		t.Fatalf("Second network creation failed with unexpected error type")
 	}
 
-	err = d.DeleteNetwork("first")
+	err = d.DeleteNetwork(context.Background(), "first")
 	if err == nil {
 		t.Fatalf("network deletion should fail on this driver")
 	}
@@ -40,7 +41,7 @@ func TestDriver(t *testing.T) {
 	}
 
 	// we don't really check if it is there or not, delete is not allowed for this driver, period.
-	err = d.DeleteNetwork("unknown")
+	err = d.DeleteNetwork(context.Background(), "unknown")
 	if err == nil {
 		t.Fatalf("any network deletion should fail on this driver")
 	}
@@ -1,15 +1,28 @@
 package overlay
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 )
 
 // Join method is invoked when a Sandbox is attached to an endpoint.
-func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+func (d *driver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return d.join(nid, eid, jinfo, false)
+}
+
+// MigrateEndpoint attaches a Sandbox to an endpoint which was joined on
+// another host. The endpoint keeps the addresses it was created with, and
+// the peers are told to reach it through the vtep of this host.
+func (d *driver) MigrateEndpoint(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return d.join(nid, eid, jinfo, true)
+}
+
+func (d *driver) join(nid, eid types.UUID, jinfo driverapi.JoinInfo, migrate bool) error {
 	if err := validateID(nid, eid); err != nil {
 		return err
 	}
@@ -60,24 +73,43 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 		}
 	}
 
-	err = jinfo.SetGateway(bridgeIP.IP)
-	if err != nil {
+	// The containers of an internal network have no route out of it, and
+	// what they send out anyway is dropped and counted
+	if n.isInternal() {
+		if err := n.addInternalEndpoint(ep); err != nil {
+			return err
+		}
+	} else if err := jinfo.SetGateway(bridgeIP.IP); err != nil {
 		return err
 	}
 
+	action := "join"
+	if migrate {
+		action = "migrate"
+		// The endpoint is no longer reached through the vtep of the host
+		// it moves from
+		if pEntry, ok := d.peerDbGet(nid, ep.addr.IP, ep.mac); ok && !pEntry.isLocal {
+			if err := d.peerDelete(nid, eid, ep.addr.IP, ep.mac, pEntry.vtep, false); err != nil {
+				logrus.Warnf("Removing the peer entry of migrated endpoint %s failed: %v", eid, err)
+			}
+		}
+	}
+
 	d.peerDbAdd(nid, eid, ep.addr.IP, ep.mac,
 		d.serfInstance.LocalMember().Addr, true)
 	d.notifyCh <- ovNotify{
-		action: "join",
+		action: action,
 		nid:    nid,
 		eid:    eid,
+		ip:     ep.addr.IP,
+		mac:    ep.mac,
 	}
 
 	return nil
 }
 
 // Leave method is invoked when a Sandbox detaches from an endpoint.
-func (d *driver) Leave(nid, eid types.UUID) error {
+func (d *driver) Leave(ctx context.Context, nid, eid types.UUID) error {
 	if err := validateID(nid, eid); err != nil {
 		return err
 	}
@@ -87,10 +119,39 @@ func (d *driver) Leave(nid, eid types.UUID) error {
 		return fmt.Errorf("could not find network with id %s", nid)
 	}
 
+	// The event may be broadcast after the endpoint is deleted
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return fmt.Errorf("could not find endpoint with id %s", eid)
+	}
+
 	d.notifyCh <- ovNotify{
 		action: "leave",
 		nid:    nid,
 		eid:    eid,
+		ip:     ep.addr.IP,
+		mac:    ep.mac,
+	}
+
+	if n.isInternal() {
+		n.removeInternalEndpoint(ep)
+	}
+	n.leaveSandbox()
+
+	return nil
+}
+
+// ReleaseEndpoint is invoked on the host an endpoint migrated from, when the
+// Sandbox detaches from it. The peers already reach the endpoint on the host
+// it moved to, so they are not notified.
+func (d *driver) ReleaseEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	if err := validateID(nid, eid); err != nil {
+		return err
+	}
+
+	n := d.network(nid)
+	if n == nil {
+		return fmt.Errorf("could not find network with id %s", nid)
 	}
 
 	n.leaveSandbox()
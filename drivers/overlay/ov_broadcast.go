@@ -0,0 +1,239 @@
+package overlay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+)
+
+// Broadcasts is the key of the endpoint operational data holding the
+// counters of the events broadcast for the network, a BroadcastStats
+const Broadcasts = "Broadcasts"
+
+const (
+	// maxPendingBroadcasts bounds the events of a network waiting to be
+	// broadcast, the events beyond it are set aside until there is room
+	maxPendingBroadcasts = 1024
+	// broadcastFlushInterval is the period the rate limited events are
+	// sent at
+	broadcastFlushInterval = 100 * time.Millisecond
+)
+
+// BroadcastStats counts the events of a network handed to the gossip agent
+type BroadcastStats struct {
+	Sent uint64
+	// Coalesced counts the events superseded by a later one for the same
+	// endpoint before they were sent
+	Coalesced uint64
+	// Dropped counts the events set aside because too many were pending,
+	// Resynced the ones of them queued again once there was room
+	Dropped  uint64
+	Resynced uint64
+}
+
+// broadcastQueue holds the pending events of a network, at most one per
+// endpoint, in the order they were first queued. The events which did not
+// fit are kept aside in overflow, the latest one per endpoint, so that the
+// peers learn the state of the endpoints they missed the events of.
+type broadcastQueue struct {
+	tokens        float64
+	last          time.Time
+	pending       map[types.UUID]*ovNotify
+	order         []types.UUID
+	overflow      map[types.UUID]*ovNotify
+	overflowOrder []types.UUID
+}
+
+// broadcaster limits the rate of the join and leave events each network
+// broadcasts to the cluster, so that the endpoints churning on a host do not
+// flood the gossip. The events are let through by a token bucket per
+// network refilled at rate events per second, up to burst. The events held
+// back are coalesced by endpoint, the peers only need the latest state of
+// each endpoint. Without a rate the events go out as they come.
+type broadcaster struct {
+	sync.Mutex
+	rate     float64
+	burst    float64
+	queues   map[types.UUID]*broadcastQueue
+	counters map[types.UUID]*BroadcastStats
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newBroadcaster(rate, burst int) *broadcaster {
+	// Without a burst, a second worth of events can go at once
+	if burst == 0 {
+		burst = rate
+	}
+	return &broadcaster{
+		rate:     float64(rate),
+		burst:    float64(burst),
+		queues:   map[types.UUID]*broadcastQueue{},
+		counters: map[types.UUID]*BroadcastStats{},
+		now:      time.Now,
+	}
+}
+
+// limited tells whether the events are rate limited
+func (b *broadcaster) limited() bool {
+	return b.rate > 0
+}
+
+// push queues the event, in place of the pending event of the endpoint if
+// there is one
+func (b *broadcaster) push(ev ovNotify) {
+	b.Lock()
+	defer b.Unlock()
+
+	q, ok := b.queues[ev.nid]
+	if !ok {
+		q = &broadcastQueue{
+			tokens:   b.burst,
+			last:     b.now(),
+			pending:  map[types.UUID]*ovNotify{},
+			overflow: map[types.UUID]*ovNotify{},
+		}
+		b.queues[ev.nid] = q
+	}
+	st := b.counter(ev.nid)
+
+	// The event of an endpoint set aside stays behind the earlier one
+	if coalesce(q.pending, &q.order, ev, st) || coalesce(q.overflow, &q.overflowOrder, ev, st) {
+		return
+	}
+	if len(q.pending) >= maxPendingBroadcasts {
+		st.Dropped++
+		// A storm is reported once, the count is logged with the network
+		// deletion
+		log := logrus.Debugf
+		if st.Dropped == 1 {
+			log = logrus.Warnf
+		}
+		log("Setting aside %s event of endpoint %s, %d events of network %s are pending broadcast", ev.action, ev.eid, len(q.pending), ev.nid)
+		q.overflow[ev.eid] = &ev
+		q.overflowOrder = append(q.overflowOrder, ev.eid)
+		return
+	}
+	q.pending[ev.eid] = &ev
+	q.order = append(q.order, ev.eid)
+}
+
+// coalesce replaces the event of the endpoint held in events, if any, with
+// ev, and tells whether it did. A leave cancels the held join of the
+// endpoint, the peers never learnt about it.
+func coalesce(events map[types.UUID]*ovNotify, order *[]types.UUID, ev ovNotify, st *BroadcastStats) bool {
+	prev, ok := events[ev.eid]
+	if !ok {
+		return false
+	}
+	if prev.action == "join" && ev.action == "leave" {
+		delete(events, ev.eid)
+		for i, eid := range *order {
+			if eid == ev.eid {
+				*order = append((*order)[:i], (*order)[i+1:]...)
+				break
+			}
+		}
+		st.Coalesced += 2
+		return true
+	}
+	events[ev.eid] = &ev
+	st.Coalesced++
+	return true
+}
+
+// pop returns the events which can be broadcast now
+func (b *broadcaster) pop() []ovNotify {
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+	var events []ovNotify
+	for nid, q := range b.queues {
+		// The counters of a deleted network are gone, not its events
+		st, ok := b.counters[nid]
+		if !ok {
+			st = &BroadcastStats{}
+		}
+		if b.limited() {
+			q.tokens += now.Sub(q.last).Seconds() * b.rate
+			if q.tokens > b.burst {
+				q.tokens = b.burst
+			}
+		}
+		q.last = now
+
+		// The events set aside are queued again as the room frees up
+		n := 0
+		for _, eid := range q.overflowOrder {
+			if len(q.pending) >= maxPendingBroadcasts {
+				break
+			}
+			q.pending[eid] = q.overflow[eid]
+			q.order = append(q.order, eid)
+			delete(q.overflow, eid)
+			st.Resynced++
+			n++
+		}
+		q.overflowOrder = q.overflowOrder[n:]
+
+		n = 0
+		for _, eid := range q.order {
+			if b.limited() && q.tokens < 1 {
+				break
+			}
+			events = append(events, *q.pending[eid])
+			delete(q.pending, eid)
+			st.Sent++
+			q.tokens--
+			n++
+		}
+		q.order = q.order[n:]
+
+		// An idle network with a full bucket has nothing to remember
+		if len(q.order) == 0 && len(q.overflowOrder) == 0 && (!b.limited() || q.tokens >= b.burst) {
+			delete(b.queues, nid)
+		}
+	}
+	return events
+}
+
+// counter returns the counters of the network. Called with the lock held.
+func (b *broadcaster) counter(nid types.UUID) *BroadcastStats {
+	st, ok := b.counters[nid]
+	if !ok {
+		st = &BroadcastStats{}
+		b.counters[nid] = st
+	}
+	return st
+}
+
+// stats returns the counters of the network
+func (b *broadcaster) stats(nid types.UUID) BroadcastStats {
+	b.Lock()
+	defer b.Unlock()
+
+	if st, ok := b.counters[nid]; ok {
+		return *st
+	}
+	return BroadcastStats{}
+}
+
+// forget drops the counters of a deleted network, reporting the events
+// which were not sent as they came. Its pending events are still sent.
+func (b *broadcaster) forget(nid types.UUID) {
+	b.Lock()
+	defer b.Unlock()
+
+	st, ok := b.counters[nid]
+	if !ok {
+		return
+	}
+	if st.Coalesced != 0 || st.Dropped != 0 {
+		logrus.Infof("Broadcast %d events of network %s, %d were coalesced and %d set aside", st.Sent, nid, st.Coalesced, st.Dropped)
+	}
+	delete(b.counters, nid)
+}
@@ -0,0 +1,95 @@
+package overlay
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestBroadcastUnlimited(t *testing.T) {
+	b := newBroadcaster(0, 0)
+
+	b.push(ovNotify{action: "join", nid: "n1", eid: "e1"})
+	if evs := b.pop(); len(evs) != 1 || evs[0].eid != "e1" {
+		t.Fatalf("Unexpected events %v", evs)
+	}
+	b.push(ovNotify{action: "leave", nid: "n1", eid: "e1"})
+	if evs := b.pop(); len(evs) != 1 || evs[0].action != "leave" {
+		t.Fatalf("Unexpected events %v", evs)
+	}
+	if st := b.stats("n1"); st.Sent != 2 || st.Coalesced != 0 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+}
+
+func TestBroadcastRateLimit(t *testing.T) {
+	now := time.Now()
+	b := newBroadcaster(10, 2)
+	b.now = func() time.Time { return now }
+
+	for _, eid := range []types.UUID{"e1", "e2", "e3", "e4"} {
+		b.push(ovNotify{action: "join", nid: "n1", eid: eid})
+	}
+	// Another network has a bucket of its own
+	b.push(ovNotify{action: "join", nid: "n2", eid: "e5"})
+
+	evs := b.pop()
+	if len(evs) != 3 {
+		t.Fatalf("Expected the burst of each network, got %v", evs)
+	}
+
+	// Updates of a pending endpoint replace its event, a leave cancels its
+	// pending join
+	b.push(ovNotify{action: "migrate", nid: "n1", eid: "e3"})
+	b.push(ovNotify{action: "leave", nid: "n1", eid: "e4"})
+	if evs := b.pop(); len(evs) != 0 {
+		t.Fatalf("Events exceeded the rate: %v", evs)
+	}
+
+	now = now.Add(time.Second)
+	evs = b.pop()
+	if len(evs) != 1 || evs[0].eid != "e3" || evs[0].action != "migrate" {
+		t.Fatalf("Unexpected events %v", evs)
+	}
+	if st := b.stats("n1"); st.Sent != 3 || st.Coalesced != 3 || st.Dropped != 0 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+
+	b.forget("n1")
+	if st := b.stats("n1"); st.Sent != 0 {
+		t.Fatalf("Stats of a forgotten network were kept: %+v", st)
+	}
+}
+
+func TestBroadcastDrop(t *testing.T) {
+	now := time.Time{}
+	b := newBroadcaster(1, 1)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < maxPendingBroadcasts+2; i++ {
+		b.push(ovNotify{action: "join", nid: "n1", eid: types.UUID(fmt.Sprintf("e%d", i))})
+	}
+	if st := b.stats("n1"); st.Dropped != 2 {
+		t.Fatalf("Expected two events set aside, got %+v", st)
+	}
+	// The events set aside are coalesced too
+	last := types.UUID(fmt.Sprintf("e%d", maxPendingBroadcasts+1))
+	b.push(ovNotify{action: "leave", nid: "n1", eid: last})
+
+	if evs := b.pop(); len(evs) != 1 || evs[0].eid != "e0" {
+		t.Fatalf("Unexpected events %v", evs)
+	}
+	now = now.Add(time.Second)
+	if evs := b.pop(); len(evs) != 1 || evs[0].eid != "e1" {
+		t.Fatalf("Unexpected events %v", evs)
+	}
+	aside := types.UUID(fmt.Sprintf("e%d", maxPendingBroadcasts))
+	if q := b.queues["n1"]; q.pending[aside] == nil || len(q.overflow) != 0 || q.order[len(q.order)-1] != aside {
+		t.Fatalf("Event set aside was not queued again")
+	}
+	if st := b.stats("n1"); st.Sent != 2 || st.Coalesced != 2 || st.Dropped != 2 || st.Resynced != 1 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+}
@@ -0,0 +1,168 @@
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// availabilityTag is the tag a member announces its availability with
+	availabilityTag = "availability"
+	// availabilityEvent is the user event asking a member to change its
+	// availability, its payload is the member name and the availability
+	availabilityEvent = "availability"
+)
+
+func (d *driver) cluster() (*serf.Serf, error) {
+	d.Lock()
+	s := d.serfInstance
+	d.Unlock()
+	if s == nil {
+		return nil, types.NotImplementedErrorf("overlay driver is not part of a cluster, it is not configured")
+	}
+	return s, nil
+}
+
+func clusterMember(m serf.Member, local string) driverapi.ClusterMember {
+	a := m.Tags[availabilityTag]
+	if a == "" {
+		a = driverapi.NodeActive
+	}
+	return driverapi.ClusterMember{
+		Name:         m.Name,
+		Addr:         m.Addr,
+		Port:         m.Port,
+		Status:       m.Status.String(),
+		Availability: a,
+		Local:        m.Name == local,
+	}
+}
+
+// ClusterMembers is part of the driverapi.ClusterManager interface
+func (d *driver) ClusterMembers() ([]driverapi.ClusterMember, error) {
+	s, err := d.cluster()
+	if err != nil {
+		return nil, err
+	}
+
+	local := s.LocalMember().Name
+	var members []driverapi.ClusterMember
+	for _, m := range s.Members() {
+		members = append(members, clusterMember(m, local))
+	}
+	return members, nil
+}
+
+func findMember(s *serf.Serf, name string) (serf.Member, bool) {
+	for _, m := range s.Members() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return serf.Member{}, false
+}
+
+// RemoveClusterMember is part of the driverapi.ClusterManager interface.
+// Only the failed members are removed: a live member would refute its
+// removal anyway.
+func (d *driver) RemoveClusterMember(name string) error {
+	s, err := d.cluster()
+	if err != nil {
+		return err
+	}
+
+	m, ok := findMember(s, name)
+	if !ok {
+		return types.NotFoundErrorf("cluster member %s not found", name)
+	}
+	if m.Status != serf.StatusFailed {
+		return types.ForbiddenErrorf("cluster member %s is %s, only a failed member can be removed", name, m.Status)
+	}
+	if err := s.RemoveFailedNode(name); err != nil {
+		return fmt.Errorf("failed to remove cluster member %s: %v", name, err)
+	}
+	logrus.Infof("Removed failed cluster member %s", name)
+
+	// The subnet slices the member claimed are not used anymore
+	if na, ok := d.ipAllocator.(*nodeAllocator); ok {
+		if err := na.releaseNodeSlices(name); err != nil {
+			logrus.Warnf("Failed to give back the subnet slices of removed cluster member %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// SetNodeAvailability is part of the driverapi.ClusterManager interface.
+// The host sets the availability it announces itself, the other members
+// are asked to with a user event.
+func (d *driver) SetNodeAvailability(name, availability string) error {
+	if availability != driverapi.NodeActive && availability != driverapi.NodeDrain {
+		return types.BadRequestErrorf("invalid availability %q", availability)
+	}
+	s, err := d.cluster()
+	if err != nil {
+		return err
+	}
+
+	if name == s.LocalMember().Name {
+		return setAvailability(s, availability)
+	}
+	m, ok := findMember(s, name)
+	if !ok {
+		return types.NotFoundErrorf("cluster member %s not found", name)
+	}
+	if m.Status != serf.StatusAlive {
+		return types.ForbiddenErrorf("cluster member %s is %s", name, m.Status)
+	}
+	if err := s.UserEvent(availabilityEvent, []byte(name+" "+availability), false); err != nil {
+		return fmt.Errorf("failed to ask cluster member %s to change its availability: %v", name, err)
+	}
+	return nil
+}
+
+func setAvailability(s *serf.Serf, availability string) error {
+	local := s.LocalMember()
+	tags := make(map[string]string, len(local.Tags)+1)
+	for k, v := range local.Tags {
+		tags[k] = v
+	}
+	tags[availabilityTag] = availability
+	if err := s.SetTags(tags); err != nil {
+		return fmt.Errorf("failed to announce availability %s: %v", availability, err)
+	}
+	logrus.Infof("Cluster member %s is now %s", local.Name, availability)
+	return nil
+}
+
+// processAvailabilityEvent applies the availability the event asks the
+// host for, if it is the member named
+func (d *driver) processAvailabilityEvent(u serf.UserEvent) {
+	var name, availability string
+	if _, err := fmt.Sscan(string(u.Payload), &name, &availability); err != nil {
+		logrus.Warnf("Failed to scan availability event %q: %v", string(u.Payload), err)
+		return
+	}
+	if name != d.serfInstance.LocalMember().Name {
+		return
+	}
+	if availability != driverapi.NodeActive && availability != driverapi.NodeDrain {
+		logrus.Warnf("Ignoring invalid availability %q", availability)
+		return
+	}
+	if err := setAvailability(d.serfInstance, availability); err != nil {
+		logrus.Warn(err)
+	}
+}
+
+// draining tells whether the host is drained, and takes no new endpoints
+func (d *driver) draining() bool {
+	s, err := d.cluster()
+	if err != nil {
+		return false
+	}
+	return s.LocalMember().Tags[availabilityTag] == driverapi.NodeDrain
+}
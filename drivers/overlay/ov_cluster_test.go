@@ -0,0 +1,32 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/hashicorp/serf/serf"
+)
+
+func TestClusterMember(t *testing.T) {
+	m := clusterMember(serf.Member{Name: "host2", Addr: net.ParseIP("10.0.0.2"), Port: 7946, Status: serf.StatusFailed}, "host1")
+	if m.Name != "host2" || m.Status != "failed" || m.Availability != driverapi.NodeActive || m.Local {
+		t.Fatalf("Unexpected member %+v", m)
+	}
+
+	m = clusterMember(serf.Member{Name: "host1", Tags: map[string]string{availabilityTag: driverapi.NodeDrain}, Status: serf.StatusAlive}, "host1")
+	if m.Status != "alive" || m.Availability != driverapi.NodeDrain || !m.Local {
+		t.Fatalf("Unexpected member %+v", m)
+	}
+
+	d := &driver{}
+	if _, err := d.ClusterMembers(); err == nil {
+		t.Fatal("Expected the driver without cluster to fail")
+	}
+	if err := d.SetNodeAvailability("host1", "paused"); err == nil {
+		t.Fatal("Expected the invalid availability to be refused")
+	}
+	if d.draining() {
+		t.Fatal("Driver without cluster is drained")
+	}
+}
@@ -0,0 +1,168 @@
+package overlay
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/macallocator"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// configuration is the typed form of the overlay driver options. It is
+// built and validated before any of the options is applied, so that an
+// invalid option fails the configuration of the driver with a clear error
+// instead of when the gossip agent is started.
+type configuration struct {
+	BindInterface     string
+	BindAddress       string
+	AdvertiseAddress  string
+	AddressFamily     string
+	NeighborIP        string
+	KVProvider        string
+	KVProviderURL     string
+	MacRanges         []macallocator.Range
+	UnderlayAddresses []net.IP
+	// BroadcastRate and BroadcastBurst limit the endpoint events each
+	// network broadcasts, per second and at once. No rate means no limit.
+	BroadcastRate  int
+	BroadcastBurst int
+	// NodeSubnetSize is the prefix length of the slices of the subnet each
+	// node claims, 0 when the addresses are allocated cluster wide
+	NodeSubnetSize int
+	// NeighborTableSize is the number of peers each network keeps in its
+	// neighbor and fdb tables, the least recently used evicted beyond it.
+	// 0 means no limit.
+	NeighborTableSize int
+}
+
+// stringOption returns the string value of the label, if any
+func stringOption(option map[string]interface{}, label string) (string, bool, error) {
+	v, ok := option[label]
+	if !ok || v == nil {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, types.BadRequestErrorf("invalid type %T for %s value", v, label)
+	}
+	return s, true, nil
+}
+
+// fromMap retrieves the configuration from the driver options map
+func (c *configuration) fromMap(option map[string]interface{}) error {
+	for label, field := range map[string]*string{
+		netlabel.OverlayBindInterface:    &c.BindInterface,
+		netlabel.OverlayBindAddress:      &c.BindAddress,
+		netlabel.OverlayAdvertiseAddress: &c.AdvertiseAddress,
+		netlabel.OverlayAddressFamily:    &c.AddressFamily,
+		netlabel.OverlayNeighborIP:       &c.NeighborIP,
+		netlabel.KVProvider:              &c.KVProvider,
+		netlabel.KVProviderURL:           &c.KVProviderURL,
+	} {
+		s, _, err := stringOption(option, label)
+		if err != nil {
+			return err
+		}
+		*field = s
+	}
+
+	s, ok, err := stringOption(option, netlabel.OverlayMacRanges)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if c.MacRanges, err = macallocator.ParseRanges(s); err != nil {
+			return err
+		}
+	}
+
+	// The underlay addresses are checked against the address family
+	s, ok, err = stringOption(option, netlabel.OverlayUnderlayAddresses)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err = c.validateFamily(); err != nil {
+			return err
+		}
+		if c.UnderlayAddresses, err = parseUnderlayAddrs(s, c.AddressFamily); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+
+	for label, field := range map[string]*int{
+		netlabel.OverlayBroadcastRate:     &c.BroadcastRate,
+		netlabel.OverlayBroadcastBurst:    &c.BroadcastBurst,
+		netlabel.OverlayNodeSubnetSize:    &c.NodeSubnetSize,
+		netlabel.OverlayNeighborTableSize: &c.NeighborTableSize,
+	} {
+		s, ok, err := stringOption(option, label)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if *field, err = strconv.Atoi(s); err != nil {
+			return types.BadRequestErrorf("invalid %s value %q", label, s)
+		}
+	}
+
+	return nil
+}
+
+func (c *configuration) validateFamily() error {
+	switch c.AddressFamily {
+	case "", familyIPv4, familyIPv6:
+		return nil
+	}
+	return types.BadRequestErrorf("invalid address family %q", c.AddressFamily)
+}
+
+// Validate performs a static validation of the configuration, whatever can
+// be checked before the gossip agent is started
+func (c *configuration) Validate() error {
+	if err := c.validateFamily(); err != nil {
+		return err
+	}
+
+	if c.BindInterface != "" && c.BindAddress != "" {
+		return types.BadRequestErrorf("bind interface %s and bind address %s cannot be both specified", c.BindInterface, c.BindAddress)
+	}
+	if c.BindAddress != "" {
+		if err := checkFamily("bind", c.BindAddress, c.AddressFamily); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+	if c.AdvertiseAddress != "" {
+		if err := checkFamily("advertise", c.AdvertiseAddress, c.AddressFamily); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+
+	if c.BroadcastRate < 0 || c.BroadcastBurst < 0 {
+		return types.BadRequestErrorf("invalid broadcast rate %d and burst %d", c.BroadcastRate, c.BroadcastBurst)
+	}
+	if c.BroadcastBurst != 0 && c.BroadcastRate == 0 {
+		return types.BadRequestErrorf("broadcast burst %d set without a broadcast rate", c.BroadcastBurst)
+	}
+
+	if c.NodeSubnetSize != 0 && (c.NodeSubnetSize <= bridgeSubnetSize || c.NodeSubnetSize > maxNodeSubnetSize) {
+		return types.BadRequestErrorf("invalid node subnet size %d, not within /%d and /%d", c.NodeSubnetSize, bridgeSubnetSize+1, maxNodeSubnetSize)
+	}
+
+	if c.NeighborTableSize < 0 {
+		return types.BadRequestErrorf("invalid neighbor table size %d", c.NeighborTableSize)
+	}
+
+	// The neighbor may be given with the port of its agent
+	if c.NeighborIP != "" && net.ParseIP(c.NeighborIP) == nil {
+		host, _, err := net.SplitHostPort(c.NeighborIP)
+		if err != nil || net.ParseIP(host) == nil {
+			return types.BadRequestErrorf("invalid neighbor address %s", c.NeighborIP)
+		}
+	}
+
+	return nil
+}
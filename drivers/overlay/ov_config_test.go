@@ -0,0 +1,65 @@
+package overlay
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestConfigurationFromMap(t *testing.T) {
+	cfg := &configuration{}
+	err := cfg.fromMap(map[string]interface{}{
+		netlabel.OverlayAddressFamily:     "ipv6",
+		netlabel.OverlayBindAddress:       "fd00::1",
+		netlabel.OverlayNeighborIP:        "[fd00::2]:7946",
+		netlabel.OverlayMacRanges:         "02:42:ac",
+		netlabel.OverlayUnderlayAddresses: "fd00::1, fd00::3",
+		netlabel.OverlayBroadcastRate:     "50",
+		netlabel.OverlayBroadcastBurst:    "200",
+		netlabel.OverlayNodeSubnetSize:    "24",
+		netlabel.OverlayNeighborTableSize: "4096",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BindAddress != "fd00::1" || len(cfg.MacRanges) != 1 || len(cfg.UnderlayAddresses) != 2 ||
+		cfg.BroadcastRate != 50 || cfg.BroadcastBurst != 200 || cfg.NodeSubnetSize != 24 ||
+		cfg.NeighborTableSize != 4096 {
+		t.Fatalf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+func TestInvalidConfiguration(t *testing.T) {
+	for _, option := range []map[string]interface{}{
+		{netlabel.OverlayBindAddress: 10},
+		{netlabel.OverlayAddressFamily: "ipx"},
+		{netlabel.OverlayAddressFamily: "ipv4", netlabel.OverlayBindAddress: "fd00::1"},
+		{netlabel.OverlayBindInterface: "eth0", netlabel.OverlayBindAddress: "10.0.0.1"},
+		{netlabel.OverlayAdvertiseAddress: "10.0.0"},
+		{netlabel.OverlayNeighborIP: "node-2:7946"},
+		{netlabel.OverlayMacRanges: "02:42"},
+		{netlabel.OverlayAddressFamily: "ipv4", netlabel.OverlayUnderlayAddresses: "fd00::1"},
+		{netlabel.OverlayBroadcastRate: "fast"},
+		{netlabel.OverlayBroadcastRate: "-1"},
+		{netlabel.OverlayBroadcastBurst: "10"},
+		{netlabel.OverlayNodeSubnetSize: "16"},
+		{netlabel.OverlayNodeSubnetSize: "31"},
+		{netlabel.OverlayNeighborTableSize: "-1"},
+	} {
+		cfg := &configuration{}
+		err := cfg.fromMap(option)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err == nil {
+			t.Fatalf("Invalid options were accepted: %v", option)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for options %v: %v", option, err)
+		}
+	}
+}
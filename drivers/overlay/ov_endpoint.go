@@ -1,6 +1,7 @@
 package overlay
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -37,7 +38,7 @@ func (n *network) deleteEndpoint(eid types.UUID) {
 	n.Unlock()
 }
 
-func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointInfo,
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo,
 	epOptions map[string]interface{}) error {
 	if err := validateID(nid, eid); err != nil {
 		return err
@@ -48,6 +49,11 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		return fmt.Errorf("network id %q not found", nid)
 	}
 
+	// A drained host keeps its endpoints but takes no new ones
+	if d.draining() {
+		return types.ForbiddenErrorf("host is drained, it takes no new endpoints")
+	}
+
 	ep := &endpoint{
 		id: eid,
 	}
@@ -56,6 +62,11 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		addr := epInfo.Interfaces()[0].Address()
 		ep.addr = &addr
 		ep.mac = epInfo.Interfaces()[0].MacAddress()
+		if ep.mac != nil && d.macAllocator != nil {
+			if _, err := d.macAllocator.Request(ep.mac); err != nil {
+				return fmt.Errorf("could not reserve mac address %s: %v", ep.mac, err)
+			}
+		}
 		n.addEndpoint(ep)
 		return nil
 	}
@@ -73,10 +84,18 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 
 	binary.BigEndian.PutUint32(ep.addr.IP, bridgeSubnetInt+ipID)
 
-	ep.mac = netutils.GenerateRandomMAC()
+	if d.macAllocator != nil {
+		if ep.mac, err = d.macAllocator.Request(nil); err != nil {
+			d.ipAllocator.Release(ipID)
+			return fmt.Errorf("could not allocate mac address: %v", err)
+		}
+	} else {
+		ep.mac = netutils.GenerateRandomMAC()
+	}
 
 	err = epInfo.AddInterface(1, ep.mac, *ep.addr, net.IPNet{})
 	if err != nil {
+		d.releaseMac(ep.mac)
 		return fmt.Errorf("could not add interface to endpoint info: %v", err)
 	}
 
@@ -85,7 +104,7 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 	return nil
 }
 
-func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+func (d *driver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
 	if err := validateID(nid, eid); err != nil {
 		return err
 	}
@@ -101,10 +120,40 @@ func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
 	}
 
 	d.ipAllocator.Release(binary.BigEndian.Uint32(ep.addr.IP) - bridgeSubnetInt)
+	d.releaseMac(ep.mac)
 	n.deleteEndpoint(eid)
 	return nil
 }
 
+func (d *driver) releaseMac(mac net.HardwareAddr) {
+	if mac != nil && d.macAllocator != nil {
+		d.macAllocator.Release(mac)
+	}
+}
+
 func (d *driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
-	return make(map[string]interface{}, 0), nil
+	m := make(map[string]interface{}, 0)
+
+	n := d.network(nid)
+	if n == nil {
+		return m, nil
+	}
+	m[NeighborTable] = n.neigh.stats()
+	if d.broadcaster != nil {
+		m[Broadcasts] = d.broadcaster.stats(nid)
+	}
+
+	if !n.isInternal() || n.sandbox() == nil {
+		return m, nil
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return m, nil
+	}
+	c, err := n.internalViolations(ep)
+	if err != nil {
+		return nil, err
+	}
+	m[InternalViolations] = c
+	return m, nil
 }
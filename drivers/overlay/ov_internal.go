@@ -0,0 +1,119 @@
+package overlay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// internalChain is the chain of the network sandbox dropping the traffic the
+// containers of an internal network send outside of the overlay. It holds a
+// rule per joined endpoint, counting the packets each sent, ahead of a rule
+// dropping the traffic of the other sources.
+const internalChain = "OVERLAY-INTERNAL"
+
+// InternalViolations is the key of the endpoint operational data holding
+// the number of packets and bytes the container of an endpoint of an
+// internal network sent outside of the overlay, dropped
+const InternalViolations = "InternalViolations"
+
+// ViolationCounters counts the traffic dropped for an endpoint
+type ViolationCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// internalJumps are the rules sending the routed traffic leaving the
+// bridge, and the traffic addressed to the sandbox itself, to the chain
+var internalJumps = [][]string{
+	{"FORWARD", "-i", "bridge1", "!", "-o", "bridge1", "-j", internalChain},
+	{"INPUT", "-i", "bridge1", "-j", internalChain},
+}
+
+// sandboxIptables runs iptables in the network sandbox
+func (n *network) sandboxIptables(args ...string) ([]byte, error) {
+	sbox := n.sandbox()
+	if sbox == nil {
+		return nil, fmt.Errorf("network %s has no sandbox", n.id)
+	}
+	var (
+		out []byte
+		err error
+	)
+	if ierr := sbox.InvokeFunc(func() {
+		out, err = iptables.RawLocal(append([]string{"-t", string(iptables.Filter)}, args...)...)
+	}); ierr != nil {
+		return nil, ierr
+	}
+	return out, err
+}
+
+// setupInternal creates the chain of an internal network in its sandbox
+func (n *network) setupInternal() error {
+	rules := [][]string{
+		{"-N", internalChain},
+		{"-A", internalChain, "-j", "DROP"},
+	}
+	for _, j := range internalJumps {
+		rules = append(rules, append([]string{"-A"}, j...))
+	}
+	for _, r := range rules {
+		if _, err := n.sandboxIptables(r...); err != nil {
+			return fmt.Errorf("could not isolate internal network %s: %v", n.id, err)
+		}
+	}
+	return nil
+}
+
+func endpointRule(action string, ep *endpoint) []string {
+	return []string{action, internalChain, "-s", ep.addr.IP.String() + "/32", "-j", "DROP"}
+}
+
+// addInternalEndpoint counts the traffic of the endpoint on its own
+func (n *network) addInternalEndpoint(ep *endpoint) error {
+	if _, err := n.sandboxIptables(endpointRule("-I", ep)...); err != nil {
+		return fmt.Errorf("could not isolate endpoint %s of internal network %s: %v", ep.id, n.id, err)
+	}
+	return nil
+}
+
+func (n *network) removeInternalEndpoint(ep *endpoint) {
+	if _, err := n.sandboxIptables(endpointRule("-D", ep)...); err != nil {
+		logrus.Debugf("Could not remove the rule of endpoint %s of internal network %s: %v", ep.id, n.id, err)
+	}
+}
+
+// internalViolations returns the traffic of the endpoint dropped so far
+func (n *network) internalViolations(ep *endpoint) (ViolationCounters, error) {
+	out, err := n.sandboxIptables("-L", internalChain, "-n", "-v", "-x")
+	if err != nil {
+		return ViolationCounters{}, err
+	}
+	return parseViolations(out)[ep.addr.IP.String()], nil
+}
+
+// parseViolations returns the counters of the rules of the chain listing,
+// by source address
+func parseViolations(out []byte) map[string]ViolationCounters {
+	counters := make(map[string]ViolationCounters)
+	for _, line := range strings.Split(string(out), "\n") {
+		// pkts bytes target prot opt in out source destination
+		f := strings.Fields(line)
+		if len(f) < 9 || f[2] != "DROP" {
+			continue
+		}
+		pkts, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		bytes, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[strings.TrimSuffix(f[7], "/32")] = ViolationCounters{Packets: pkts, Bytes: bytes}
+	}
+	return counters
+}
@@ -0,0 +1,40 @@
+package overlay
+
+import "testing"
+
+func TestInternalValue(t *testing.T) {
+	n := &network{id: "net1", vni: 256, internal: true}
+	other := &network{id: "net1"}
+	if err := other.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if other.vni != 256 || !other.internal || !other.vxlan.isDefault() {
+		t.Fatalf("Unexpected network read back: %d %t %+v", other.vni, other.internal, other.vxlan)
+	}
+
+	if err := other.SetValue([]byte("257")); err != nil {
+		t.Fatal(err)
+	}
+	if other.internal {
+		t.Fatal("Expected a network stored as its vxlan id not to be internal")
+	}
+}
+
+func TestParseViolations(t *testing.T) {
+	out := `Chain OVERLAY-INTERNAL (2 references)
+    pkts      bytes target     prot opt in     out     source               destination
+       3      252 DROP       all  --  *      *       172.21.0.2           0.0.0.0/0
+       0        0 DROP       all  --  *      *       172.21.0.3           0.0.0.0/0
+       1       84 DROP       all  --  *      *       0.0.0.0/0            0.0.0.0/0
+`
+	c := parseViolations([]byte(out))
+	if c["172.21.0.2"] != (ViolationCounters{Packets: 3, Bytes: 252}) {
+		t.Fatalf("Unexpected counters %+v", c["172.21.0.2"])
+	}
+	if _, ok := c["172.21.0.3"]; !ok {
+		t.Fatal("Expected the counters of the endpoint without violations")
+	}
+	if c["0.0.0.0/0"].Packets != 1 {
+		t.Fatalf("Unexpected counters of the other sources %+v", c["0.0.0.0/0"])
+	}
+}
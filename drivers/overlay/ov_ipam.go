@@ -0,0 +1,254 @@
+package overlay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/idm"
+)
+
+const (
+	// bridgeSubnetSize is the prefix length of the overlay subnet
+	bridgeSubnetSize = 16
+	// maxNodeSubnetSize is the longest prefix of a node slice, which keeps
+	// two usable addresses in every slice
+	maxNodeSubnetSize = 30
+	// lastAddrID is the offset in the subnet of the last endpoint address,
+	// the bridge address and the broadcast address follow it
+	lastAddrID = 0xFFFF - 2
+)
+
+// ipIDAllocator allocates the offsets in the overlay subnet of the endpoint
+// addresses
+type ipIDAllocator interface {
+	GetID() (uint32, error)
+	Release(id uint32)
+}
+
+// nodeAllocator allocates the endpoint addresses from the slices of the
+// subnet the node claimed. The slices are claimed in the datastore, shared
+// by the cluster, when the node runs out of addresses; the addresses of a
+// slice are then allocated locally, without a round trip to the datastore.
+// The slices each node claimed are recorded in the datastore under the name
+// of the node, so that they can be given back when the node restarts or is
+// removed from the cluster.
+type nodeAllocator struct {
+	slices    *idm.Idm
+	sliceSize uint32
+	owned     []*nodeSlice
+	store     datastore.DataStore
+	claims    *sliceClaims
+	sync.Mutex
+}
+
+// nodeSlice is a slice of the subnet claimed by the node
+type nodeSlice struct {
+	index uint32
+	addrs *idm.Idm
+	inUse int
+}
+
+// sliceClaims is the record of the slices a node claimed
+type sliceClaims struct {
+	prefixLen int
+	node      string
+	Slices    []uint32
+	dbIndex   uint64
+	dbExists  bool
+}
+
+// newNodeAllocator returns the allocator of the node claiming the slices of
+// the given prefix length from the datastore. The slices the node claimed
+// before it restarted are given back: the addresses of a slice are only
+// allocated in memory, and the endpoints they were allocated to are gone.
+func newNodeAllocator(ds datastore.DataStore, prefixLen int, node string) (*nodeAllocator, error) {
+	count := uint32(1) << uint(prefixLen-bridgeSubnetSize)
+	slices, err := idm.New(ds, fmt.Sprintf("ipam-slice-id-%d", prefixLen), 0, count-1)
+	if err != nil {
+		return nil, err
+	}
+	na := &nodeAllocator{
+		slices:    slices,
+		sliceSize: (0xFFFF + 1) / count,
+		store:     ds,
+		claims:    &sliceClaims{prefixLen: prefixLen, node: node},
+	}
+	if err := na.releaseNodeSlices(node); err != nil {
+		return nil, fmt.Errorf("failed to give back the subnet slices claimed before: %v", err)
+	}
+	return na, nil
+}
+
+// GetID returns the offset of a free address of the slices of the node,
+// claiming another slice when they are all in use
+func (na *nodeAllocator) GetID() (uint32, error) {
+	na.Lock()
+	defer na.Unlock()
+
+	for _, s := range na.owned {
+		if id, err := s.addrs.GetID(); err == nil {
+			s.inUse++
+			return id, nil
+		}
+	}
+
+	s, err := na.claim()
+	if err != nil {
+		return 0, err
+	}
+	id, err := s.addrs.GetID()
+	if err != nil {
+		return 0, err
+	}
+	s.inUse++
+	return id, nil
+}
+
+func (na *nodeAllocator) claim() (*nodeSlice, error) {
+	index, err := na.slices.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("no subnet slice left to claim: %v", err)
+	}
+
+	// The network address, the bridge address and the broadcast address
+	// are left out of the first and last slices
+	start, end := index*na.sliceSize, (index+1)*na.sliceSize-1
+	if start == 0 {
+		start = 1
+	}
+	if end > lastAddrID {
+		end = lastAddrID
+	}
+	addrs, err := idm.New(nil, fmt.Sprintf("ipam-slice-%d", index), start, end)
+	if err != nil {
+		na.slices.Release(index)
+		return nil, err
+	}
+
+	s := &nodeSlice{index: index, addrs: addrs}
+	na.owned = append(na.owned, s)
+	if err := na.saveClaims(); err != nil {
+		na.owned = na.owned[:len(na.owned)-1]
+		na.slices.Release(index)
+		return nil, fmt.Errorf("failed to record the claim of subnet slice %d: %v", index, err)
+	}
+	logrus.Debugf("Claimed overlay subnet slice %d, addresses %d to %d", index, start, end)
+	return s, nil
+}
+
+// Release frees the address of the given offset. A slice claimed when the
+// first one ran out is given back once none of its addresses is in use.
+func (na *nodeAllocator) Release(id uint32) {
+	na.Lock()
+	defer na.Unlock()
+
+	index := id / na.sliceSize
+	for i, s := range na.owned {
+		if s.index != index {
+			continue
+		}
+		s.addrs.Release(id)
+		s.inUse--
+		if s.inUse == 0 && i > 0 {
+			na.owned = append(na.owned[:i], na.owned[i+1:]...)
+			na.slices.Release(index)
+			if err := na.saveClaims(); err != nil {
+				logrus.Warnf("Failed to record the release of subnet slice %d: %v", index, err)
+			}
+		}
+		return
+	}
+}
+
+// releaseUnused gives back the slices none of whose addresses is in use
+func (na *nodeAllocator) releaseUnused() {
+	na.Lock()
+	defer na.Unlock()
+
+	var owned []*nodeSlice
+	for _, s := range na.owned {
+		if s.inUse == 0 {
+			na.slices.Release(s.index)
+			continue
+		}
+		owned = append(owned, s)
+	}
+	na.owned = owned
+	if err := na.saveClaims(); err != nil {
+		logrus.Warnf("Failed to record the release of the unused subnet slices: %v", err)
+	}
+}
+
+// saveClaims records the slices the node owns in the datastore
+func (na *nodeAllocator) saveClaims() error {
+	if na.store == nil {
+		return nil
+	}
+	na.claims.Slices = nil
+	for _, s := range na.owned {
+		na.claims.Slices = append(na.claims.Slices, s.index)
+	}
+	return na.store.PutObjectAtomic(na.claims)
+}
+
+// releaseNodeSlices gives back the slices recorded as claimed by the node
+// and drops its record
+func (na *nodeAllocator) releaseNodeSlices(node string) error {
+	if na.store == nil {
+		return nil
+	}
+	c := &sliceClaims{prefixLen: na.claims.prefixLen, node: node}
+	if err := na.store.GetObject(c.Key().String(), c); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	for _, index := range c.Slices {
+		na.slices.Release(index)
+	}
+	if err := na.store.DeleteObjectAtomic(c); err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+	if len(c.Slices) != 0 {
+		logrus.Infof("Gave back the overlay subnet slices %v of node %s", c.Slices, node)
+	}
+	return nil
+}
+
+func (c *sliceClaims) Key() datastore.KeyPath {
+	return datastore.NewKeyPath("overlay", "ipam-slices", strconv.Itoa(c.prefixLen), c.node)
+}
+
+func (c *sliceClaims) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath("overlay", "ipam-slices", strconv.Itoa(c.prefixLen))
+}
+
+func (c *sliceClaims) Value() []byte {
+	b, err := json.Marshal(c.Slices)
+	if err != nil {
+		return []byte{}
+	}
+	return b
+}
+
+func (c *sliceClaims) SetValue(value []byte) error {
+	return json.Unmarshal(value, &c.Slices)
+}
+
+func (c *sliceClaims) Index() uint64 {
+	return c.dbIndex
+}
+
+func (c *sliceClaims) SetIndex(index uint64) {
+	c.dbIndex = index
+	c.dbExists = true
+}
+
+func (c *sliceClaims) Exists() bool {
+	return c.dbExists
+}
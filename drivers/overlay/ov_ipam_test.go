@@ -0,0 +1,118 @@
+package overlay
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/idm"
+)
+
+func TestNodeAllocator(t *testing.T) {
+	// Two nodes sharing the slices, as through the datastore
+	slices, err := idm.New(nil, "ipam-slice-id-30", 0, 1<<14-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	na1 := &nodeAllocator{slices: slices, sliceSize: 4}
+	na2 := &nodeAllocator{slices: slices, sliceSize: 4}
+
+	var ids1 []uint32
+	for i := 0; i < 4; i++ {
+		id, err := na1.GetID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids1 = append(ids1, id)
+	}
+	// The network address is not allocated, the first slice is short
+	if ids1[0] != 1 || ids1[2] != 3 || ids1[3] < 4 {
+		t.Fatalf("Unexpected addresses %v", ids1)
+	}
+	if len(na1.owned) != 2 {
+		t.Fatalf("Expected 2 slices claimed, got %d", len(na1.owned))
+	}
+
+	id2, err := na2.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids1 {
+		if id/4 == id2/4 {
+			t.Fatalf("Nodes share the slice of address %d", id2)
+		}
+	}
+
+	// The extra slice is given back once unused, for the other node to claim
+	index := ids1[3] / 4
+	na1.Release(ids1[3])
+	if len(na1.owned) != 1 {
+		t.Fatalf("Slice %d not given back", index)
+	}
+	if err := slices.GetSpecificID(index); err != nil {
+		t.Fatalf("Slice %d still claimed: %v", index, err)
+	}
+	slices.Release(index)
+
+	na1.Release(ids1[0])
+	if id, err := na1.GetID(); err != nil || id != ids1[0] {
+		t.Fatalf("Expected address %d to be allocated again, got %d: %v", ids1[0], id, err)
+	}
+
+	na2.Release(id2)
+	na2.releaseUnused()
+	if len(na2.owned) != 0 {
+		t.Fatal("Unused slice not given back")
+	}
+}
+
+func TestNodeAllocatorLastSlice(t *testing.T) {
+	slices, err := idm.New(nil, "ipam-slice-id-30", 1<<14-1, 1<<14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	na := &nodeAllocator{slices: slices, sliceSize: 4}
+
+	// The bridge and broadcast addresses end the last slice
+	for _, want := range []uint32{0xFFFC, 0xFFFD} {
+		if id, err := na.GetID(); err != nil || id != want {
+			t.Fatalf("Expected address %d, got %d: %v", want, id, err)
+		}
+	}
+}
+
+func TestNodeAllocatorClaims(t *testing.T) {
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+
+	na1, err := newNodeAllocator(ds, 30, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nodes share the slices, as through the datastore
+	na2 := &nodeAllocator{slices: na1.slices, sliceSize: 4, store: ds, claims: &sliceClaims{prefixLen: 30, node: "node2"}}
+	id1, err := na1.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := na2.GetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node1 restarts: the slice it claimed before is given back
+	na1, err = newNodeAllocator(ds, 30, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := na1.slices.GetSpecificID(id1 / 4); err != nil {
+		t.Fatalf("Slice %d of the restarted node still claimed: %v", id1/4, err)
+	}
+	na1.slices.Release(id1 / 4)
+
+	// node2 is removed from the cluster
+	if err := na1.releaseNodeSlices("node2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := na1.slices.GetSpecificID(id2 / 4); err != nil {
+		t.Fatalf("Slice %d of the removed node still claimed: %v", id2/4, err)
+	}
+}
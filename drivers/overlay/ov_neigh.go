@@ -0,0 +1,288 @@
+package overlay
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sysctl"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// NeighborTable is the key of the endpoint operational data holding the
+// occupancy of the neighbor table of the network, a NeighborTableStats
+const NeighborTable = "NeighborTable"
+
+// The host neighbor table garbage collection thresholds, shared by all the
+// network namespaces
+const (
+	neighGCThresh1 = "net/ipv4/neigh/default/gc_thresh1"
+	neighGCThresh2 = "net/ipv4/neigh/default/gc_thresh2"
+	neighGCThresh3 = "net/ipv4/neigh/default/gc_thresh3"
+)
+
+// neighInUse is how recently a peer must have been sent to for it to be
+// kept in the sandbox beyond the limit of the neighbor table
+const neighInUse = 30 * time.Second
+
+// userHZ is the unit of the times of the neighbor cache info, the clock
+// ticks of the kernel ABI
+const userHZ = 100
+
+// NeighborTableStats is the occupancy of the neighbor table of a network
+type NeighborTableStats struct {
+	// Entries is the number of peers with neighbor and fdb entries in the
+	// network sandbox
+	Entries int
+	// Limit is the number of entries above which the least recently used
+	// ones are evicted, 0 for no limit
+	Limit int
+	// Evictions is the number of entries evicted since the sandbox was
+	// created
+	Evictions uint64
+}
+
+type neighEntry struct {
+	ip   net.IP
+	mac  net.HardwareAddr
+	vtep net.IP
+}
+
+// neighTable tracks the peers programmed in the sandbox of a network, the
+// most recently added, missed or sent to first. The peers evicted are removed
+// from the sandbox but kept in the peer database, so that the miss of the
+// next packet towards them adds them back.
+type neighTable struct {
+	limit     int
+	lru       *list.List
+	entries   map[string]*list.Element
+	evictions uint64
+	sync.Mutex
+}
+
+func newNeighTable(limit int) *neighTable {
+	return &neighTable{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func neighKey(ip net.IP, mac net.HardwareAddr) string {
+	return peerKey{peerIP: ip, peerMac: mac}.String()
+}
+
+// touch marks the peer as the most recently used
+func (t *neighTable) touch(ip net.IP, mac net.HardwareAddr, vtep net.IP) {
+	t.Lock()
+	defer t.Unlock()
+
+	key := neighKey(ip, mac)
+	if e, ok := t.entries[key]; ok {
+		e.Value.(*neighEntry).vtep = vtep
+		t.lru.MoveToFront(e)
+		return
+	}
+	t.entries[key] = t.lru.PushFront(&neighEntry{ip: ip, mac: mac, vtep: vtep})
+}
+
+// full tells whether the table holds more entries than its limit
+func (t *neighTable) full() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.limit > 0 && t.lru.Len() > t.limit
+}
+
+// trim returns the least recently used entries evicted to keep the table
+// within its limit. The entries inUse tells are still used are marked as the
+// most recently used instead, and are kept even if the table stays beyond
+// its limit.
+func (t *neighTable) trim(inUse func(*neighEntry) bool) []*neighEntry {
+	t.Lock()
+	defer t.Unlock()
+
+	var evicted []*neighEntry
+	for left := t.lru.Len(); left > 0 && t.limit > 0 && t.lru.Len() > t.limit; left-- {
+		e := t.lru.Back()
+		ne := e.Value.(*neighEntry)
+		if inUse(ne) {
+			t.lru.MoveToFront(e)
+			continue
+		}
+		t.lru.Remove(e)
+		delete(t.entries, neighKey(ne.ip, ne.mac))
+		evicted = append(evicted, ne)
+		t.evictions++
+	}
+	return evicted
+}
+
+// remove forgets the peer, returning whether its entries may be in the
+// sandbox, which they are not once evicted
+func (t *neighTable) remove(ip net.IP, mac net.HardwareAddr) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	key := neighKey(ip, mac)
+	e, ok := t.entries[key]
+	if !ok {
+		return t.limit == 0
+	}
+	t.lru.Remove(e)
+	delete(t.entries, key)
+	return true
+}
+
+// reset forgets the entries, once the sandbox holding them is destroyed
+func (t *neighTable) reset() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.lru.Init()
+	t.entries = make(map[string]*list.Element)
+	t.evictions = 0
+}
+
+func (t *neighTable) stats() NeighborTableStats {
+	t.Lock()
+	defer t.Unlock()
+
+	return NeighborTableStats{Entries: t.lru.Len(), Limit: t.limit, Evictions: t.evictions}
+}
+
+// trimNeighbors evicts the least recently used peers beyond the limit of the
+// neighbor table from the sandbox of the network. The peers sent to lately,
+// which the table only learns about from the fdb of the sandbox, are kept.
+func (n *network) trimNeighbors() {
+	if !n.neigh.full() {
+		return
+	}
+	sbox := n.sandbox()
+	if sbox == nil {
+		return
+	}
+
+	var used map[string]time.Duration
+	if err := sbox.Invoke(func() error {
+		var err error
+		used, err = fdbUsage()
+		return err
+	}); err != nil {
+		// Without the usage, the peers are evicted by their last addition
+		logrus.Debugf("Could not read the fdb usage of network %s: %v", n.id, err)
+	}
+
+	evicted := n.neigh.trim(func(ne *neighEntry) bool {
+		ago, ok := used[ne.mac.String()]
+		return ok && ago < neighInUse
+	})
+	for _, ne := range evicted {
+		if err := sbox.DeleteNeighbor(ne.vtep, ne.mac); err != nil {
+			logrus.Debugf("Could not evict fdb entry of %s from network %s: %v", ne.mac, n.id, err)
+		}
+		if err := sbox.DeleteNeighbor(ne.ip, ne.mac); err != nil {
+			logrus.Debugf("Could not evict neighbor entry of %s from network %s: %v", ne.ip, n.id, err)
+		}
+	}
+}
+
+// fdbUsage returns how long ago each mac address of the fdb of the current
+// network namespace was last sent to. The kernel updates the time on every
+// packet the vxlan interface sends to the address.
+func fdbUsage() (map[string]time.Duration, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP)
+	req.AddData(&netlink.Ndmsg{Family: syscall.AF_BRIDGE})
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWNEIGH)
+	if err != nil {
+		return nil, err
+	}
+
+	ndmLen := (&netlink.Ndmsg{}).Len()
+	used := make(map[string]time.Duration, len(msgs))
+	for _, m := range msgs {
+		if len(m) < ndmLen {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[ndmLen:])
+		if err != nil {
+			continue
+		}
+		var (
+			mac net.HardwareAddr
+			ago time.Duration
+			ok  bool
+		)
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case netlink.NDA_LLADDR:
+				mac = net.HardwareAddr(attr.Value)
+			case netlink.NDA_CACHEINFO:
+				// struct nda_cacheinfo: confirmed, used, updated, refcnt
+				if len(attr.Value) >= 8 {
+					ticks := nl.NativeEndian().Uint32(attr.Value[4:8])
+					ago = time.Duration(ticks) * time.Second / userHZ
+					ok = true
+				}
+			}
+		}
+		if mac == nil || !ok {
+			continue
+		}
+		// An address may be reached through several vteps
+		if cur, seen := used[mac.String()]; !seen || ago < cur {
+			used[mac.String()] = ago
+		}
+	}
+	return used, nil
+}
+
+// neighGCThresholds returns the garbage collection thresholds for a host
+// neighbor table of the given number of entries, in the ratios of the kernel
+// defaults
+func neighGCThresholds(entries int) map[string]int {
+	return map[string]int{
+		neighGCThresh1: entries / 8,
+		neighGCThresh2: entries / 2,
+		neighGCThresh3: entries,
+	}
+}
+
+// tuneNeighGC raises the garbage collection thresholds of the host neighbor
+// table to fit the limited neighbor tables of the networks with a sandbox,
+// so that the kernel does not drop the entries of the peers before the
+// driver evicts them. The thresholds are never lowered.
+func (d *driver) tuneNeighGC() {
+	if d.neighTableSize == 0 {
+		return
+	}
+
+	d.Lock()
+	sandboxes := 0
+	for _, n := range d.networks {
+		if n.sandbox() != nil {
+			sandboxes++
+		}
+	}
+	d.Unlock()
+
+	m := sysctl.Default()
+	for name, value := range neighGCThresholds(d.neighTableSize * sandboxes) {
+		cur, err := m.Get(name)
+		if err != nil {
+			logrus.Debugf("Could not read %s: %v", name, err)
+			continue
+		}
+		if v, err := strconv.Atoi(cur); err == nil && v >= value {
+			continue
+		}
+		if err := m.Set(name, strconv.Itoa(value)); err != nil {
+			logrus.Warnf("Could not raise %s to %d: %v", name, value, err)
+		}
+	}
+}
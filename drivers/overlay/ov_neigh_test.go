@@ -0,0 +1,104 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+)
+
+func testPeer(i byte) (net.IP, net.HardwareAddr, net.IP) {
+	return net.IPv4(10, 0, 0, i), net.HardwareAddr{0x02, 0x42, 0x0a, 0, 0, i}, net.IPv4(192, 168, 0, i)
+}
+
+func notInUse(*neighEntry) bool { return false }
+
+func TestNeighTableEviction(t *testing.T) {
+	nt := newNeighTable(2)
+
+	for i := byte(1); i <= 2; i++ {
+		nt.touch(testPeer(i))
+	}
+	if nt.full() {
+		t.Fatal("Table is full below its limit")
+	}
+	if evicted := nt.trim(notInUse); len(evicted) != 0 {
+		t.Fatalf("Unexpected evictions below the limit: %v", evicted)
+	}
+
+	// The use of the first peer makes the second the least recently used
+	nt.touch(testPeer(1))
+	nt.touch(testPeer(3))
+	if !nt.full() {
+		t.Fatal("Table is not full beyond its limit")
+	}
+	evicted := nt.trim(notInUse)
+	if len(evicted) != 1 || !evicted[0].ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("Expected the second peer to be evicted, got %v", evicted)
+	}
+	if s := nt.stats(); s != (NeighborTableStats{Entries: 2, Limit: 2, Evictions: 1}) {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+
+	// The entries of the evicted peer are no longer in the sandbox
+	ip, mac, _ := testPeer(2)
+	if nt.remove(ip, mac) {
+		t.Fatal("Expected the evicted peer to have no entries to remove")
+	}
+	ip, mac, _ = testPeer(3)
+	if !nt.remove(ip, mac) {
+		t.Fatal("Expected the peer to have entries to remove")
+	}
+
+	nt.reset()
+	if s := nt.stats(); s != (NeighborTableStats{Limit: 2}) {
+		t.Fatalf("Unexpected stats after reset: %+v", s)
+	}
+}
+
+func TestNeighTableInUse(t *testing.T) {
+	nt := newNeighTable(2)
+	for i := byte(1); i <= 3; i++ {
+		nt.touch(testPeer(i))
+	}
+
+	// The least recently added peer is still sent to, the next one goes
+	_, used, _ := testPeer(1)
+	evicted := nt.trim(func(ne *neighEntry) bool { return ne.mac.String() == used.String() })
+	if len(evicted) != 1 || !evicted[0].ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("Expected the second peer to be evicted, got %v", evicted)
+	}
+
+	// The peers all in use are kept beyond the limit
+	nt.touch(testPeer(4))
+	if evicted := nt.trim(func(*neighEntry) bool { return true }); len(evicted) != 0 {
+		t.Fatalf("Unexpected evictions of peers in use: %v", evicted)
+	}
+	if s := nt.stats(); s.Entries != 3 || s.Evictions != 1 {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestNeighTableUnlimited(t *testing.T) {
+	nt := newNeighTable(0)
+	for i := byte(1); i <= 100; i++ {
+		nt.touch(testPeer(i))
+	}
+	if evicted := nt.trim(notInUse); len(evicted) != 0 {
+		t.Fatalf("Unexpected evictions without a limit: %v", evicted)
+	}
+	if s := nt.stats(); s.Entries != 100 || s.Evictions != 0 {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+
+	// Without a limit, the entries of an unknown peer may still be there
+	ip, mac, _ := testPeer(200)
+	if !nt.remove(ip, mac) {
+		t.Fatal("Expected the unknown peer entries to be removed")
+	}
+}
+
+func TestNeighGCThresholds(t *testing.T) {
+	th := neighGCThresholds(8192)
+	if th[neighGCThresh1] != 1024 || th[neighGCThresh2] != 4096 || th[neighGCThresh3] != 8192 {
+		t.Fatalf("Unexpected thresholds: %v", th)
+	}
+}
@@ -1,15 +1,18 @@
 package overlay
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"syscall"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/sandbox"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
@@ -28,12 +31,17 @@ type network struct {
 	ipAllocator *ipallocator.IPAllocator
 	gw          net.IP
 	vxlanName   string
-	driver      *driver
-	joinCnt     int
+	vxlan       vxlanOptions
+	// internal keeps the traffic of the containers inside the overlay
+	internal bool
+	// neigh tracks the peers programmed in the sandbox
+	neigh   *neighTable
+	driver  *driver
+	joinCnt int
 	sync.Mutex
 }
 
-func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) error {
+func (d *driver) CreateNetwork(ctx context.Context, id types.UUID, option map[string]interface{}) error {
 	if id == "" {
 		return fmt.Errorf("invalid network id")
 	}
@@ -42,6 +50,17 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 		id:        id,
 		driver:    d,
 		endpoints: endpointTable{},
+		neigh:     newNeighTable(d.neighTableSize),
+	}
+	if err := n.vxlan.fromMap(option); err != nil {
+		return err
+	}
+	if s, ok, err := stringOption(option, netlabel.OverlayInternal); err != nil {
+		return err
+	} else if ok {
+		if n.internal, err = strconv.ParseBool(s); err != nil {
+			return types.BadRequestErrorf("invalid %s value %q", netlabel.OverlayInternal, s)
+		}
 	}
 
 	n.gw = bridgeIP.IP
@@ -55,7 +74,7 @@ func (d *driver) CreateNetwork(id types.UUID, option map[string]interface{}) err
 	return nil
 }
 
-func (d *driver) DeleteNetwork(nid types.UUID) error {
+func (d *driver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
 	if nid == "" {
 		return fmt.Errorf("invalid network id")
 	}
@@ -66,6 +85,9 @@ func (d *driver) DeleteNetwork(nid types.UUID) error {
 	}
 
 	d.deleteNetwork(nid)
+	if d.broadcaster != nil {
+		d.broadcaster.forget(nid)
+	}
 
 	return n.releaseVxlanID()
 }
@@ -108,6 +130,11 @@ func (n *network) destroySandbox() {
 
 		sbox.Destroy()
 	}
+
+	if s := n.neigh.stats(); s.Evictions != 0 {
+		logrus.Debugf("Network %s evicted %d neighbor entries, %d left", n.id, s.Evictions, s.Entries)
+	}
+	n.neigh.reset()
 }
 
 func (n *network) initSandbox() error {
@@ -123,7 +150,7 @@ func (n *network) initSandbox() error {
 		return fmt.Errorf("could not create bridge inside the network sandbox: %v", err)
 	}
 
-	vxlanName, err := createVxlan(n.vxlanID())
+	vxlanName, err := createVxlan(n.vxlanID(), n.vxlanOptions())
 	if err != nil {
 		return err
 	}
@@ -138,6 +165,13 @@ func (n *network) initSandbox() error {
 
 	n.setSandbox(sbox)
 
+	if n.isInternal() {
+		if err := n.setupInternal(); err != nil {
+			return err
+		}
+	}
+
+	n.driver.tuneNeighGC()
 	n.driver.peerDbUpdateSandbox(n.id)
 
 	var nlSock *nl.NetlinkSocket
@@ -172,7 +206,8 @@ func (n *network) watchMiss(nlSock *nl.NetlinkSocket) {
 				continue
 			}
 
-			if neigh.IP.To16() != nil {
+			// The l3 misses of the IPv4 peers, the fdb misses have no IP
+			if neigh.IP.To4() == nil {
 				continue
 			}
 
@@ -180,13 +215,20 @@ func (n *network) watchMiss(nlSock *nl.NetlinkSocket) {
 				continue
 			}
 
-			mac, vtep, err := n.driver.resolvePeer(n.id, neigh.IP)
+			// The peers evicted from the neighbor table, or left out of it,
+			// are still in the peer database
+			updateDb := false
+			mac, vtep, err := n.driver.peerDbSearch(n.id, neigh.IP)
 			if err != nil {
-				logrus.Errorf("could not resolve peer %q: %v", neigh.IP, err)
-				continue
+				mac, vtep, err = n.driver.resolvePeer(n.id, neigh.IP)
+				if err != nil {
+					logrus.Errorf("could not resolve peer %q: %v", neigh.IP, err)
+					continue
+				}
+				updateDb = true
 			}
 
-			if err := n.driver.peerAdd(n.id, types.UUID("dummy"), neigh.IP, mac, vtep, true); err != nil {
+			if err := n.driver.peerAdd(n.id, types.UUID("dummy"), neigh.IP, mac, vtep, updateDb); err != nil {
 				logrus.Errorf("could not add neighbor entry for missed peer: %v", err)
 			}
 		}
@@ -238,16 +280,46 @@ func (n *network) setVxlanID(vni uint32) {
 	n.Unlock()
 }
 
-func (n *network) Key() []string {
-	return []string{"overlay", "network", string(n.id)}
+func (n *network) Key() datastore.KeyPath {
+	return datastore.NewKeyPath("overlay", "network", string(n.id))
+}
+
+func (n *network) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath("overlay", "network")
+}
+
+func (n *network) vxlanOptions() vxlanOptions {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.vxlan
+}
+
+func (n *network) isInternal() bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.internal
 }
 
-func (n *network) KeyPrefix() []string {
-	return []string{"overlay", "network"}
+// networkValue is the stored form of a network with vxlan options or
+// internal. The other networks are stored as their vxlan id alone, as they
+// were before.
+type networkValue struct {
+	VNI      uint32       `json:"vni"`
+	Vxlan    vxlanOptions `json:"vxlan"`
+	Internal bool         `json:"internal,omitempty"`
 }
 
 func (n *network) Value() []byte {
-	b, err := json.Marshal(n.vxlanID())
+	n.Lock()
+	var v interface{} = n.vni
+	if !n.vxlan.isDefault() || n.internal {
+		v = &networkValue{VNI: n.vni, Vxlan: n.vxlan, Internal: n.internal}
+	}
+	n.Unlock()
+
+	b, err := json.Marshal(v)
 	if err != nil {
 		return []byte{}
 	}
@@ -268,13 +340,29 @@ func (n *network) Exists() bool {
 	return n.dbExists
 }
 
+// SetValue sets the vxlan id and options of the network, as the host which
+// allocated the vxlan id stored them
 func (n *network) SetValue(value []byte) error {
-	var vni uint32
-	err := json.Unmarshal(value, &vni)
-	if err == nil {
-		n.setVxlanID(vni)
+	var v networkValue
+	if err := json.Unmarshal(value, &v.VNI); err != nil {
+		if err := json.Unmarshal(value, &v); err != nil {
+			return err
+		}
+	}
+
+	n.Lock()
+	if n.vxlan != v.Vxlan {
+		logrus.Warnf("Network %s uses the vxlan options %+v it was created with rather than %+v", n.id, v.Vxlan, n.vxlan)
 	}
-	return err
+	if n.internal != v.Internal {
+		logrus.Warnf("Network %s is internal as it was created, %t, rather than %t", n.id, v.Internal, n.internal)
+	}
+	n.vni = v.VNI
+	n.vxlan = v.Vxlan
+	n.internal = v.Internal
+	n.Unlock()
+
+	return nil
 }
 
 func (n *network) writeToStore() error {
@@ -312,7 +400,7 @@ func (n *network) obtainVxlanID() error {
 
 	for {
 		var vxlanID uint32
-		if err := n.driver.store.GetObject(datastore.Key(n.Key()...), n); err != nil {
+		if err := n.driver.store.GetObject(n.Key().String(), n); err != nil {
 			if err == datastore.ErrKeyNotFound {
 				vxlanID, err = n.driver.vxlanIdm.GetID()
 				if err != nil {
@@ -15,6 +15,8 @@ type ovNotify struct {
 	action string
 	eid    types.UUID
 	nid    types.UUID
+	ip     net.IP
+	mac    net.HardwareAddr
 }
 
 type logWriter struct{}
@@ -36,7 +38,12 @@ func (l *logWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func getBindAddr(ifaceName string) (string, error) {
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+func getBindAddr(ifaceName, family string) (string, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return "", fmt.Errorf("failed to find interface %s: %v", ifaceName, err)
@@ -58,24 +65,83 @@ func getBindAddr(ifaceName string) (string, error) {
 			continue
 		}
 
+		if (family == familyIPv4 && addrIP.To4() == nil) || (family == familyIPv6 && addrIP.To4() != nil) {
+			continue
+		}
+
 		return addrIP.String(), nil
 	}
 
+	if family != "" {
+		return "", fmt.Errorf("failed to get %s bind address on interface %s", family, ifaceName)
+	}
 	return "", fmt.Errorf("failed to get bind address")
 }
 
+// gossipAddrs returns the address the cluster agent binds to and the
+// address it advertises to its peers, as configured on the driver. Empty
+// addresses leave the choice to the agent.
+func (d *driver) gossipAddrs() (bindAddr, advertiseAddr string, err error) {
+	switch d.addrFamily {
+	case "", familyIPv4, familyIPv6:
+	default:
+		return "", "", fmt.Errorf("invalid address family %q", d.addrFamily)
+	}
+
+	if d.ifaceName != "" && d.bindAddr != "" {
+		return "", "", fmt.Errorf("bind interface %s and bind address %s cannot be both specified", d.ifaceName, d.bindAddr)
+	}
+
+	switch {
+	case d.ifaceName != "":
+		if bindAddr, err = getBindAddr(d.ifaceName, d.addrFamily); err != nil {
+			return "", "", fmt.Errorf("getBindAddr error: %v", err)
+		}
+	case d.bindAddr != "":
+		if err = checkFamily("bind", d.bindAddr, d.addrFamily); err != nil {
+			return "", "", err
+		}
+		bindAddr = d.bindAddr
+	case d.addrFamily == familyIPv6:
+		bindAddr = net.IPv6unspecified.String()
+	}
+
+	if d.advertiseAddr != "" {
+		if err = checkFamily("advertise", d.advertiseAddr, d.addrFamily); err != nil {
+			return "", "", err
+		}
+		advertiseAddr = d.advertiseAddr
+	}
+
+	return bindAddr, advertiseAddr, nil
+}
+
+func checkFamily(kind, addr, family string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid %s address %s", kind, addr)
+	}
+	if (family == familyIPv4 && ip.To4() == nil) || (family == familyIPv6 && ip.To4() != nil) {
+		return fmt.Errorf("%s address %s is not an %s address", kind, addr, family)
+	}
+	return nil
+}
+
 func (d *driver) serfInit() error {
 	var err error
 
 	config := serf.DefaultConfig()
 	config.Init()
-	if d.ifaceName != "" {
-		bindAddr, err := getBindAddr(d.ifaceName)
-		if err != nil {
-			return fmt.Errorf("getBindAddr error: %v", err)
-		}
+	bindAddr, advertiseAddr, err := d.gossipAddrs()
+	if err != nil {
+		return err
+	}
+	if bindAddr != "" {
 		config.MemberlistConfig.BindAddr = bindAddr
 	}
+	if advertiseAddr != "" {
+		config.MemberlistConfig.AdvertiseAddr = advertiseAddr
+	}
 
 	d.eventCh = make(chan serf.Event, 4)
 	config.EventCh = d.eventCh
@@ -101,7 +167,9 @@ func (d *driver) serfInit() error {
 		}
 	}
 
+	d.Lock()
 	d.serfInstance = s
+	d.Unlock()
 
 	d.notifyCh = make(chan ovNotify)
 	d.exitCh = make(chan chan struct{})
@@ -111,10 +179,7 @@ func (d *driver) serfInit() error {
 }
 
 func (d *driver) notifyEvent(event ovNotify) {
-	n := d.network(event.nid)
-	ep := n.endpoint(event.eid)
-
-	ePayload := fmt.Sprintf("%s %s %s", event.action, ep.addr.IP.String(), ep.mac.String())
+	ePayload := fmt.Sprintf("%s %s %s", event.action, event.ip.String(), event.mac.String())
 	eName := fmt.Sprintf("jl %s %s %s", d.serfInstance.LocalMember().Addr.String(),
 		event.nid, event.eid)
 
@@ -154,9 +219,14 @@ func (d *driver) processEvent(u serf.UserEvent) {
 			net.ParseIP(vtepStr), true); err != nil {
 			fmt.Printf("Peer add failed in the driver: %v\n", err)
 		}
+	case "migrate":
+		if err := d.peerMigrate(types.UUID(nid), types.UUID(eid), net.ParseIP(ipStr), mac,
+			net.ParseIP(vtepStr)); err != nil {
+			fmt.Printf("Peer migrate failed in the driver: %v\n", err)
+		}
 	case "leave":
-		if err := d.peerDelete(types.UUID(nid), types.UUID(eid), net.ParseIP(ipStr), mac,
-			net.ParseIP(vtepStr), true); err != nil {
+		if err := d.peerLeave(types.UUID(nid), types.UUID(eid), net.ParseIP(ipStr), mac,
+			net.ParseIP(vtepStr)); err != nil {
 			fmt.Printf("Peer delete failed in the driver: %v\n", err)
 		}
 	}
@@ -209,6 +279,15 @@ func (d *driver) resolvePeer(nid types.UUID, peerIP net.IP) (net.HardwareAddr, n
 func (d *driver) startSerfLoop(eventCh chan serf.Event, notifyCh chan ovNotify,
 	exitCh chan chan struct{}) {
 
+	// The events held back by the rate limit are sent as the networks
+	// earn their tokens back
+	var flushCh <-chan time.Time
+	if d.broadcaster.limited() {
+		ticker := time.NewTicker(broadcastFlushInterval)
+		defer ticker.Stop()
+		flushCh = ticker.C
+	}
+
 	for {
 		select {
 		case notify, ok := <-notifyCh:
@@ -216,7 +295,14 @@ func (d *driver) startSerfLoop(eventCh chan serf.Event, notifyCh chan ovNotify,
 				break
 			}
 
-			d.notifyEvent(notify)
+			d.broadcaster.push(notify)
+			for _, ev := range d.broadcaster.pop() {
+				d.notifyEvent(ev)
+			}
+		case <-flushCh:
+			for _, ev := range d.broadcaster.pop() {
+				d.notifyEvent(ev)
+			}
 		case ch, ok := <-exitCh:
 			if !ok {
 				break
@@ -243,6 +329,10 @@ func (d *driver) startSerfLoop(eventCh chan serf.Event, notifyCh chan ovNotify,
 			if !ok {
 				break
 			}
+			if u.Name == availabilityEvent {
+				d.processAvailabilityEvent(u)
+				break
+			}
 			d.processEvent(u)
 		}
 	}
@@ -2,6 +2,7 @@ package overlay
 
 import (
 	"fmt"
+	"syscall"
 
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/types"
@@ -44,23 +45,21 @@ func createVethPair() (string, string, error) {
 	return name1, name2, nil
 }
 
-func createVxlan(vni uint32) (string, error) {
+func createVxlan(vni uint32, opts vxlanOptions) (string, error) {
 	name, err := netutils.GenerateIfaceName("vxlan", 7)
 	if err != nil {
 		return "", fmt.Errorf("error generating vxlan name: %v", err)
 	}
 
-	vxlan := &netlink.Vxlan{
-		LinkAttrs: netlink.LinkAttrs{Name: name},
-		VxlanId:   int(vni),
-		Learning:  true,
-		Proxy:     true,
-		L3miss:    true,
-		L2miss:    true,
+	if _, err := vxlanRequest(name, vni, opts).Execute(syscall.NETLINK_ROUTE, 0); err != nil {
+		return "", fmt.Errorf("error creating vxlan interface: %v", err)
 	}
 
-	if err := netlink.LinkAdd(vxlan); err != nil {
-		return "", fmt.Errorf("error creating vxlan interface: %v", err)
+	if opts.DisableChecksumOffload {
+		if err := disableChecksumOffload(name); err != nil {
+			deleteVxlan(name)
+			return "", err
+		}
 	}
 
 	return name, nil
@@ -0,0 +1,131 @@
+package overlay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// vxlanOptions are the per network settings of the vxlan device. They are
+// kept with the vxlan id of the network, so that all the hosts create the
+// device the same way.
+type vxlanOptions struct {
+	// Port is the UDP destination port, the kernel default when unset
+	Port uint16 `json:"port,omitempty"`
+	TOS  uint8  `json:"tos,omitempty"`
+	TTL  uint8  `json:"ttl,omitempty"`
+	// DisableLearning stops the device from learning the remote MAC
+	// addresses from the traffic it receives
+	DisableLearning bool `json:"disableLearning,omitempty"`
+	// DisableChecksumOffload makes the device compute the checksums of the
+	// packets it sends, for the NICs and kernels corrupting the offloaded
+	// checksums of the encapsulated packets
+	DisableChecksumOffload bool `json:"disableChecksumOffload,omitempty"`
+}
+
+func (o *vxlanOptions) isDefault() bool {
+	return *o == vxlanOptions{}
+}
+
+// fromMap retrieves the vxlan options from the network options map
+func (o *vxlanOptions) fromMap(option map[string]interface{}) error {
+	for label, field := range map[string]*uint8{
+		netlabel.OverlayVxlanTOS: &o.TOS,
+		netlabel.OverlayVxlanTTL: &o.TTL,
+	} {
+		s, ok, err := stringOption(option, label)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return types.BadRequestErrorf("invalid %s value %q", label, s)
+		}
+		*field = uint8(v)
+	}
+
+	s, ok, err := stringOption(option, netlabel.OverlayVxlanPort)
+	if err != nil {
+		return err
+	}
+	if ok {
+		v, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || v == 0 {
+			return types.BadRequestErrorf("invalid %s value %q", netlabel.OverlayVxlanPort, s)
+		}
+		o.Port = uint16(v)
+	}
+
+	for label, field := range map[string]*bool{
+		netlabel.OverlayVxlanLearning:   &o.DisableLearning,
+		netlabel.OverlayChecksumOffload: &o.DisableChecksumOffload,
+	} {
+		s, ok, err := stringOption(option, label)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return types.BadRequestErrorf("invalid %s value %q", label, s)
+		}
+		*field = !v
+	}
+
+	return nil
+}
+
+// vxlanRequest returns the request creating the vxlan device. The request
+// is built here as the attributes are not all supported by netlink, which
+// also sends the port in host byte order.
+func vxlanRequest(name string, vni uint32, opts vxlanOptions) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)))
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("vxlan"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_ID, nl.Uint32Attr(vni))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_TTL, nl.Uint8Attr(opts.TTL))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_TOS, nl.Uint8Attr(opts.TOS))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_LEARNING, boolAttr(!opts.DisableLearning))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_PROXY, boolAttr(true))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_L2MISS, boolAttr(true))
+	nl.NewRtAttrChild(data, nl.IFLA_VXLAN_L3MISS, boolAttr(true))
+	if opts.Port != 0 {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, opts.Port)
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_PORT, port)
+	}
+	req.AddData(linkInfo)
+
+	return req
+}
+
+func boolAttr(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// disableChecksumOffload turns the transmit checksum offload of the
+// interface off, the equivalent of `ethtool -K <name> tx off`
+func disableChecksumOffload(name string) error {
+	if err := netutils.EthtoolSet(name, netutils.EthtoolSTxCsum, 0); err != nil {
+		return fmt.Errorf("could not disable the checksum offload of %s: %v", name, err)
+	}
+	return nil
+}
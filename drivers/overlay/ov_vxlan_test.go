@@ -0,0 +1,117 @@
+package overlay
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestVxlanOptions(t *testing.T) {
+	var opts vxlanOptions
+	if err := opts.fromMap(map[string]interface{}{
+		netlabel.OverlayVxlanPort:       "8472",
+		netlabel.OverlayVxlanTOS:        "1",
+		netlabel.OverlayVxlanTTL:        "64",
+		netlabel.OverlayVxlanLearning:   "false",
+		netlabel.OverlayChecksumOffload: "false",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := vxlanOptions{Port: 8472, TOS: 1, TTL: 64, DisableLearning: true, DisableChecksumOffload: true}
+	if opts != expected {
+		t.Fatalf("Unexpected vxlan options %+v", opts)
+	}
+
+	for _, option := range []map[string]interface{}{
+		{netlabel.OverlayVxlanPort: "0"},
+		{netlabel.OverlayVxlanPort: "65536"},
+		{netlabel.OverlayVxlanTTL: "256"},
+		{netlabel.OverlayVxlanTOS: 1},
+		{netlabel.OverlayChecksumOffload: "off"},
+	} {
+		var opts vxlanOptions
+		if err := opts.fromMap(option); err == nil {
+			t.Fatalf("Invalid vxlan options %v were accepted", option)
+		}
+	}
+}
+
+func TestVxlanValue(t *testing.T) {
+	// The networks without options are stored as they were before
+	n := &network{id: "net1", vni: 256}
+	if v := string(n.Value()); v != "256" {
+		t.Fatalf("Unexpected value %s", v)
+	}
+
+	n.vxlan = vxlanOptions{Port: 8472, DisableChecksumOffload: true}
+	other := &network{id: "net1"}
+	if err := other.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if other.vni != 256 || other.vxlan != n.vxlan {
+		t.Fatalf("Unexpected network read back: %d %+v", other.vni, other.vxlan)
+	}
+
+	if err := other.SetValue([]byte("257")); err != nil {
+		t.Fatal(err)
+	}
+	if other.vni != 257 || !other.vxlan.isDefault() {
+		t.Fatalf("Unexpected network read back: %d %+v", other.vni, other.vxlan)
+	}
+}
+
+// txChecksumOffload returns whether the transmit checksum offload of the
+// interface is on
+func txChecksumOffload(t *testing.T, name string) bool {
+	on, err := netutils.EthtoolGet(name, netutils.EthtoolGTxCsum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return on != 0
+}
+
+func TestCreateVxlan(t *testing.T) {
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origns.Close()
+	defer netutils.SetupTestNetNS(t)()
+	// The other tests of the package use the loopback of the host
+	defer netns.Set(origns)
+
+	opts := vxlanOptions{Port: 8472, TOS: 1, TTL: 64, DisableLearning: true, DisableChecksumOffload: true}
+	name, err := createVxlan(300, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteVxlan(name)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vxlan, ok := link.(*netlink.Vxlan)
+	if !ok {
+		t.Fatalf("Unexpected link %+v", link)
+	}
+	if vxlan.VxlanId != 300 || vxlan.TTL != 64 || vxlan.TOS != 1 || vxlan.Learning || !vxlan.L2miss || !vxlan.L3miss || !vxlan.Proxy {
+		t.Fatalf("Unexpected vxlan device %+v", vxlan)
+	}
+	if txChecksumOffload(t, name) {
+		t.Fatal("Checksum offload was not disabled")
+	}
+
+	out, err := exec.Command("ip", "-d", "link", "show", name).Output()
+	if err != nil {
+		t.Skipf("Cannot show the vxlan details: %v", err)
+	}
+	if !strings.Contains(string(out), "dstport 8472 ") {
+		t.Fatalf("Unexpected vxlan port:\n%s", out)
+	}
+}
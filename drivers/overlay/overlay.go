@@ -4,13 +4,14 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"os"
 	"sync"
 
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/idm"
-	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/macallocator"
 	"github.com/docker/libnetwork/types"
 	"github.com/hashicorp/serf/serf"
 )
@@ -24,17 +25,25 @@ const (
 )
 
 type driver struct {
-	eventCh      chan serf.Event
-	notifyCh     chan ovNotify
-	exitCh       chan chan struct{}
-	ifaceName    string
-	neighIP      string
-	peerDb       peerNetworkMap
-	serfInstance *serf.Serf
-	networks     networkTable
-	store        datastore.DataStore
-	ipAllocator  *idm.Idm
-	vxlanIdm     *idm.Idm
+	eventCh       chan serf.Event
+	notifyCh      chan ovNotify
+	exitCh        chan chan struct{}
+	ifaceName     string
+	bindAddr      string
+	advertiseAddr string
+	addrFamily    string
+	neighIP       string
+	peerDb        peerNetworkMap
+	serfInstance  *serf.Serf
+	networks      networkTable
+	store         datastore.DataStore
+	ipAllocator   ipIDAllocator
+	vxlanIdm      *idm.Idm
+	macAllocator  *macallocator.Allocator
+	underlay      *underlay
+	broadcaster   *broadcaster
+	// neighTableSize limits the neighbor table of each network
+	neighTableSize int
 	sync.Once
 	sync.Mutex
 }
@@ -81,10 +90,23 @@ func Init(dc driverapi.DriverCallback) error {
 	}, c)
 }
 
+// Unload is part of the driverapi.Unloader interface
+func (d *driver) Unload() {
+	Fini(d)
+}
+
 // Fini cleans up the driver resources
 func Fini(drv driverapi.Driver) {
 	d := drv.(*driver)
 
+	if d.underlay != nil {
+		d.underlay.stop()
+	}
+
+	if na, ok := d.ipAllocator.(*nodeAllocator); ok {
+		na.releaseUnused()
+	}
+
 	if d.exitCh != nil {
 		waitCh := make(chan struct{})
 
@@ -96,30 +118,36 @@ func Fini(drv driverapi.Driver) {
 
 func (d *driver) Config(option map[string]interface{}) error {
 	var onceDone bool
-	var err error
+
+	// The options are checked before any of them is applied, so that an
+	// invalid configuration can be fixed and applied again
+	cfg := &configuration{}
+	err := cfg.fromMap(option)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		return err
+	}
 
 	d.Do(func() {
 		onceDone = true
 
-		if ifaceName, ok := option[netlabel.OverlayBindInterface]; ok {
-			d.ifaceName = ifaceName.(string)
-		}
-
-		if neighIP, ok := option[netlabel.OverlayNeighborIP]; ok {
-			d.neighIP = neighIP.(string)
-		}
-
-		provider, provOk := option[netlabel.KVProvider]
-		provURL, urlOk := option[netlabel.KVProviderURL]
+		d.ifaceName = cfg.BindInterface
+		d.neighIP = cfg.NeighborIP
+		d.bindAddr = cfg.BindAddress
+		d.advertiseAddr = cfg.AdvertiseAddress
+		d.addrFamily = cfg.AddressFamily
+		d.neighTableSize = cfg.NeighborTableSize
 
-		if provOk && urlOk {
-			cfg := &config.DatastoreCfg{
+		if cfg.KVProvider != "" && cfg.KVProviderURL != "" {
+			dsCfg := &config.DatastoreCfg{
 				Client: config.DatastoreClientCfg{
-					Provider: provider.(string),
-					Address:  provURL.(string),
+					Provider: cfg.KVProvider,
+					Address:  cfg.KVProviderURL,
 				},
 			}
-			d.store, err = datastore.NewDataStore(cfg)
+			d.store, err = datastore.NewDataStore(dsCfg)
 			if err != nil {
 				err = fmt.Errorf("failed to initialize data store: %v", err)
 				return
@@ -132,15 +160,45 @@ func (d *driver) Config(option map[string]interface{}) error {
 			return
 		}
 
-		d.ipAllocator, err = idm.New(d.store, "ipam-id", 1, 0xFFFF-2)
+		if cfg.NodeSubnetSize != 0 {
+			// The slices are claimed under the name of the node in the
+			// cluster, which is its host name
+			var node string
+			if node, err = os.Hostname(); err != nil {
+				err = fmt.Errorf("failed to get the node name: %v", err)
+				return
+			}
+			d.ipAllocator, err = newNodeAllocator(d.store, cfg.NodeSubnetSize, node)
+		} else {
+			d.ipAllocator, err = idm.New(d.store, "ipam-id", 1, lastAddrID)
+		}
 		if err != nil {
 			err = fmt.Errorf("failed to initalize ipam id manager: %v", err)
 			return
 		}
 
+		if len(cfg.MacRanges) > 0 {
+			d.macAllocator, err = macallocator.New(d.store, cfg.MacRanges)
+			if err != nil {
+				err = fmt.Errorf("failed to initialize mac allocator: %v", err)
+				return
+			}
+		}
+
+		if len(cfg.UnderlayAddresses) > 0 {
+			d.underlay = newUnderlay(cfg.UnderlayAddresses)
+		}
+
+		d.broadcaster = newBroadcaster(cfg.BroadcastRate, cfg.BroadcastBurst)
+
 		err = d.serfInit()
 		if err != nil {
 			err = fmt.Errorf("initializing serf instance failed: %v", err)
+			return
+		}
+
+		if d.underlay != nil {
+			d.underlay.start(underlayCheckInterval)
 		}
 
 	})
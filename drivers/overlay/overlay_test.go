@@ -1,10 +1,12 @@
 package overlay
 
 import (
+	"net"
 	"testing"
 	"time"
 
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
 )
 
 type driverTester struct {
@@ -128,3 +130,78 @@ func TestOverlayType(t *testing.T) {
 			dt.d.Type())
 	}
 }
+
+func TestGossipAddrs(t *testing.T) {
+	valid := []struct {
+		d         *driver
+		bind, adv string
+	}{
+		{&driver{}, "", ""},
+		{&driver{ifaceName: "lo", addrFamily: familyIPv4}, "127.0.0.1", ""},
+		{&driver{bindAddr: "10.0.0.1", advertiseAddr: "192.168.1.1"}, "10.0.0.1", "192.168.1.1"},
+		{&driver{addrFamily: familyIPv6}, "::", ""},
+		{&driver{addrFamily: familyIPv6, advertiseAddr: "2001:db8::1"}, "::", "2001:db8::1"},
+	}
+	for _, v := range valid {
+		bind, adv, err := v.d.gossipAddrs()
+		if err != nil {
+			t.Fatalf("Unexpected failure for %+v: %v", v.d, err)
+		}
+		if bind != v.bind || adv != v.adv {
+			t.Fatalf("Expected %q/%q for %+v, got %q/%q", v.bind, v.adv, v.d, bind, adv)
+		}
+	}
+
+	invalid := []*driver{
+		{addrFamily: "ipx"},
+		{ifaceName: "lo", bindAddr: "127.0.0.1"},
+		{bindAddr: "10.0.0.1", addrFamily: familyIPv6},
+		{bindAddr: "10.0.0"},
+		{advertiseAddr: "2001:db8::1", addrFamily: familyIPv4},
+	}
+	for _, d := range invalid {
+		if _, _, err := d.gossipAddrs(); err == nil {
+			t.Fatalf("Expected failure for %+v", d)
+		}
+	}
+}
+
+func TestPeerMigrate(t *testing.T) {
+	d := &driver{
+		peerDb: peerNetworkMap{
+			mp: map[types.UUID]peerMap{},
+		},
+	}
+
+	nid, eid := types.UUID("net1"), types.UUID("ep1")
+	ip := net.ParseIP("172.21.0.2")
+	mac, _ := net.ParseMAC("02:42:ac:15:00:02")
+	src, dst := net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2")
+
+	if err := d.peerAdd(nid, eid, ip, mac, src, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.peerMigrate(nid, eid, ip, mac, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	pEntry, ok := d.peerDbGet(nid, ip, mac)
+	if !ok || !pEntry.vtep.Equal(dst) {
+		t.Fatalf("Expected the peer to be reached through %s, got %v", dst, pEntry.vtep)
+	}
+
+	// The leave of the host the peer migrated from is ignored
+	if err := d.peerLeave(nid, eid, ip, mac, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.peerDbGet(nid, ip, mac); !ok {
+		t.Fatal("Expected the migrated peer to be kept")
+	}
+
+	if err := d.peerLeave(nid, eid, ip, mac, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.peerDbGet(nid, ip, mac); ok {
+		t.Fatal("Expected the peer to be removed")
+	}
+}
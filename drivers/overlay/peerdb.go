@@ -6,6 +6,7 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -113,6 +114,28 @@ func (d *driver) peerDbSearch(nid types.UUID, peerIP net.IP) (net.HardwareAddr,
 	return peerMac, vtep, nil
 }
 
+// peerDbGet returns the peerdb entry of the peer, if any
+func (d *driver) peerDbGet(nid types.UUID, peerIP net.IP,
+	peerMac net.HardwareAddr) (peerEntry, bool) {
+	d.peerDb.Lock()
+	pMap, ok := d.peerDb.mp[nid]
+	d.peerDb.Unlock()
+	if !ok {
+		return peerEntry{}, false
+	}
+
+	pKey := peerKey{
+		peerIP:  peerIP,
+		peerMac: peerMac,
+	}
+
+	pMap.Lock()
+	pEntry, ok := pMap.mp[pKey.String()]
+	pMap.Unlock()
+
+	return pEntry, ok
+}
+
 func (d *driver) peerDbAdd(nid, eid types.UUID, peerIP net.IP,
 	peerMac net.HardwareAddr, vtep net.IP, isLocal bool) {
 
@@ -201,6 +224,11 @@ func (d *driver) peerDbUpdateSandbox(nid types.UUID) {
 
 		peerOps = append(peerOps, op)
 	}
+	// The peers beyond the neighbor table size would only be evicted, they
+	// are added back on miss instead
+	if d.neighTableSize > 0 && len(peerOps) > d.neighTableSize {
+		peerOps = peerOps[:d.neighTableSize]
+	}
 	pMap.Unlock()
 
 	for _, op := range peerOps {
@@ -210,6 +238,11 @@ func (d *driver) peerDbUpdateSandbox(nid types.UUID) {
 	peerDbWg.Done()
 }
 
+func underlayPeerKey(nid types.UUID, peerIP net.IP, peerMac net.HardwareAddr) string {
+	pKey := peerKey{peerIP: peerIP, peerMac: peerMac}
+	return fmt.Sprintf("%s/%s", nid, pKey.String())
+}
+
 func (d *driver) peerAdd(nid, eid types.UUID, peerIP net.IP,
 	peerMac net.HardwareAddr, vtep net.IP, updateDb bool) error {
 
@@ -221,6 +254,12 @@ func (d *driver) peerAdd(nid, eid types.UUID, peerIP net.IP,
 		d.peerDbAdd(nid, eid, peerIP, peerMac, vtep, false)
 	}
 
+	if d.underlay != nil {
+		if err := d.underlay.addPeer(underlayPeerKey(nid, peerIP, peerMac), vtep); err != nil {
+			logrus.Warn(err)
+		}
+	}
+
 	n := d.network(nid)
 	if n == nil {
 		return nil
@@ -242,6 +281,9 @@ func (d *driver) peerAdd(nid, eid types.UUID, peerIP net.IP,
 		return fmt.Errorf("could not add fdb entry into the sandbox: %v", err)
 	}
 
+	n.neigh.touch(peerIP, peerMac, vtep)
+	n.trimNeighbors()
+
 	return nil
 }
 
@@ -256,6 +298,10 @@ func (d *driver) peerDelete(nid, eid types.UUID, peerIP net.IP,
 		d.peerDbDelete(nid, eid, peerIP, peerMac, vtep)
 	}
 
+	if d.underlay != nil {
+		d.underlay.deletePeer(underlayPeerKey(nid, peerIP, peerMac))
+	}
+
 	n := d.network(nid)
 	if n == nil {
 		return nil
@@ -266,6 +312,10 @@ func (d *driver) peerDelete(nid, eid types.UUID, peerIP net.IP,
 		return nil
 	}
 
+	if !n.neigh.remove(peerIP, peerMac) {
+		return nil
+	}
+
 	// Delete fdb entry to the bridge for the peer mac
 	if err := sbox.DeleteNeighbor(vtep, peerMac); err != nil {
 		return fmt.Errorf("could not delete fdb entry into the sandbox: %v", err)
@@ -278,3 +328,32 @@ func (d *driver) peerDelete(nid, eid types.UUID, peerIP net.IP,
 
 	return nil
 }
+
+// peerMigrate points the peer, which moved to another host with the same
+// addresses, to the vtep of that host. The entries for the vtep it moved
+// from are replaced rather than left next to the new ones.
+func (d *driver) peerMigrate(nid, eid types.UUID, peerIP net.IP,
+	peerMac net.HardwareAddr, vtep net.IP) error {
+
+	if pEntry, ok := d.peerDbGet(nid, peerIP, peerMac); ok && !pEntry.vtep.Equal(vtep) &&
+		!pEntry.isLocal {
+		if err := d.peerDelete(nid, eid, peerIP, peerMac, pEntry.vtep, false); err != nil {
+			return err
+		}
+	}
+
+	return d.peerAdd(nid, eid, peerIP, peerMac, vtep, true)
+}
+
+// peerLeave removes the peer which left on the host of vtep. A peer which
+// migrated away from that host meanwhile is kept, whichever order the
+// events are received in.
+func (d *driver) peerLeave(nid, eid types.UUID, peerIP net.IP,
+	peerMac net.HardwareAddr, vtep net.IP) error {
+
+	if pEntry, ok := d.peerDbGet(nid, peerIP, peerMac); ok && !pEntry.vtep.Equal(vtep) {
+		return nil
+	}
+
+	return d.peerDelete(nid, eid, peerIP, peerMac, vtep, true)
+}
@@ -0,0 +1,420 @@
+package overlay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sysctl"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// underlayCheckInterval is the period of the underlay paths health checks
+const underlayCheckInterval = 5 * time.Second
+
+// underlayPath is a local address the encapsulated traffic can be sent from
+type underlayPath struct {
+	addr    net.IP
+	healthy bool
+}
+
+// The sysctls selecting the fields the kernel hashes the multipath routes
+// over, set to the layer 4 fields
+const (
+	multipathHashPolicy   = "net/ipv4/fib_multipath_hash_policy"
+	multipathHashPolicyV6 = "net/ipv6/fib_multipath_hash_policy"
+)
+
+// underlay spreads the encapsulated traffic over several local underlay
+// addresses. The vxlan interfaces are not bound to a local address, so the
+// kernel picks the source address of the route towards the remote vtep: the
+// driver programs a multipath host route for each vtep in use, with a
+// nexthop through each healthy path. The kernel hashes each flow over the
+// nexthops by its layer 4 fields, the vxlan interfaces deriving the UDP
+// source port of the encapsulated packets from the inner flow, so that the
+// flows towards a vtep are spread over the paths while each flow keeps to
+// one. The paths failing their health check are left out until they
+// recover.
+type underlay struct {
+	sync.Mutex
+	paths  []*underlayPath
+	peers  map[string]string           // vtep of each known peer
+	routes map[string][]*netlink.Route // nexthops programmed for each vtep in use
+	stopCh chan struct{}
+
+	// Kernel facing operations, replaced in tests
+	checkPath func(addr net.IP) error
+	vtepRoute func(vtep, src net.IP) (*netlink.Route, error)
+	addRoute  func(hops []*netlink.Route) error
+	delRoute  func(hops []*netlink.Route) error
+}
+
+// parseUnderlayAddrs parses a comma separated list of underlay addresses of
+// the given family, if any
+func parseUnderlayAddrs(s, family string) ([]net.IP, error) {
+	var addrs []net.IP
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if err := checkFamily("underlay", f, family); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(f)
+		for _, a := range addrs {
+			if a.Equal(ip) {
+				return nil, fmt.Errorf("duplicate underlay address %s", f)
+			}
+		}
+		if len(addrs) > 0 && (addrs[0].To4() == nil) != (ip.To4() == nil) {
+			return nil, fmt.Errorf("underlay addresses %s and %s are of different families", addrs[0], f)
+		}
+		addrs = append(addrs, ip)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no underlay address in %q", s)
+	}
+	return addrs, nil
+}
+
+func newUnderlay(addrs []net.IP) *underlay {
+	u := &underlay{
+		peers:     map[string]string{},
+		routes:    map[string][]*netlink.Route{},
+		checkPath: checkUnderlayPath,
+		vtepRoute: vtepRoute,
+		addRoute:  addMultipathRoute,
+		delRoute:  delMultipathRoute,
+	}
+	for _, a := range addrs {
+		u.paths = append(u.paths, &underlayPath{addr: a, healthy: true})
+	}
+	return u
+}
+
+// start checks the paths health now and then periodically, until stopped
+func (u *underlay) start(interval time.Duration) {
+	if len(u.paths) > 1 {
+		param := multipathHashPolicy
+		if u.paths[0].addr.To4() == nil {
+			param = multipathHashPolicyV6
+		}
+		if err := sysctl.Default().Set(param, "1"); err != nil {
+			logrus.Warnf("Failed to hash the underlay flows by their ports, each vtep may keep to one path: %v", err)
+		}
+	}
+	u.checkHealth()
+
+	u.stopCh = make(chan struct{})
+	go func(stopCh chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				u.checkHealth()
+			case <-stopCh:
+				return
+			}
+		}
+	}(u.stopCh)
+}
+
+// stop ends the health checks and removes the routes programmed for the vteps
+func (u *underlay) stop() {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.stopCh != nil {
+		close(u.stopCh)
+		u.stopCh = nil
+	}
+	for vtep := range u.routes {
+		u.clearRoute(vtep)
+	}
+}
+
+// addPeer records the vtep of a peer and routes the vtep through an
+// underlay path if it is the first peer behind it
+func (u *underlay) addPeer(key string, vtep net.IP) error {
+	u.Lock()
+	defer u.Unlock()
+
+	old, ok := u.peers[key]
+	if ok && old == vtep.String() {
+		return nil
+	}
+	u.peers[key] = vtep.String()
+	if ok && !u.inUse(old) {
+		u.clearRoute(old)
+	}
+
+	return u.program(vtep.String())
+}
+
+// deletePeer forgets a peer, removing the route of its vtep if no other peer
+// is behind it
+func (u *underlay) deletePeer(key string) {
+	u.Lock()
+	defer u.Unlock()
+
+	vtep, ok := u.peers[key]
+	if !ok {
+		return
+	}
+	delete(u.peers, key)
+	if !u.inUse(vtep) {
+		u.clearRoute(vtep)
+	}
+}
+
+func (u *underlay) inUse(vtep string) bool {
+	for _, v := range u.peers {
+		if v == vtep {
+			return true
+		}
+	}
+	return false
+}
+
+// healthy returns the paths which passed their last health check
+func (u *underlay) healthy() []*underlayPath {
+	var healthy []*underlayPath
+	for _, p := range u.paths {
+		if p.healthy {
+			healthy = append(healthy, p)
+		}
+	}
+	return healthy
+}
+
+// program routes the vtep through all the healthy paths. Without healthy
+// path, the vtep is left to the main routing table.
+func (u *underlay) program(vtep string) error {
+	ip := net.ParseIP(vtep)
+	healthy := u.healthy()
+	if hops, ok := u.routes[vtep]; ok {
+		if sameHops(hops, healthy) {
+			return nil
+		}
+		u.clearRoute(vtep)
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	var hops []*netlink.Route
+	for _, p := range healthy {
+		r, err := u.vtepRoute(ip, p.addr)
+		if err != nil {
+			return fmt.Errorf("failed to route vtep %s through underlay address %s: %v", vtep, p.addr, err)
+		}
+		hops = append(hops, r)
+	}
+	if err := u.addRoute(hops); err != nil {
+		return fmt.Errorf("failed to route vtep %s through the underlay paths: %v", vtep, err)
+	}
+	u.routes[vtep] = hops
+
+	return nil
+}
+
+// sameHops tells whether the nexthops go through exactly the paths
+func sameHops(hops []*netlink.Route, paths []*underlayPath) bool {
+	if len(hops) != len(paths) {
+		return false
+	}
+	for i, p := range paths {
+		if !hops[i].Src.Equal(p.addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *underlay) clearRoute(vtep string) {
+	hops, ok := u.routes[vtep]
+	if !ok {
+		return
+	}
+	if err := u.delRoute(hops); err != nil {
+		logrus.Warnf("Failed to remove the route of vtep %s: %v", vtep, err)
+	}
+	delete(u.routes, vtep)
+}
+
+// checkHealth updates the health of the paths, moving the vteps routed
+// through a failed path over to the healthy ones
+func (u *underlay) checkHealth() {
+	u.Lock()
+	defer u.Unlock()
+
+	var changed bool
+	for _, p := range u.paths {
+		err := u.checkPath(p.addr)
+		if healthy := err == nil; healthy != p.healthy {
+			if healthy {
+				logrus.Infof("Overlay underlay path %s recovered", p.addr)
+			} else {
+				logrus.Warnf("Overlay underlay path %s failed: %v", p.addr, err)
+			}
+			p.healthy = healthy
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	vteps := map[string]struct{}{}
+	for _, v := range u.peers {
+		vteps[v] = struct{}{}
+	}
+	for v := range vteps {
+		if err := u.program(v); err != nil {
+			logrus.Warn(err)
+		}
+	}
+}
+
+// checkUnderlayPath checks the interface owning the address is up and has
+// carrier
+func checkUnderlayPath(addr net.IP) error {
+	iface, err := ifaceByAddr(addr)
+	if err != nil {
+		return err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", iface.Name)
+	}
+	// Not all interfaces report their carrier
+	if b, err := ioutil.ReadFile("/sys/class/net/" + iface.Name + "/carrier"); err == nil && strings.TrimSpace(string(b)) == "0" {
+		return fmt.Errorf("interface %s has no carrier", iface.Name)
+	}
+	return nil
+}
+
+func ifaceByAddr(addr net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %v", err)
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface has address %s", addr)
+}
+
+// vtepRoute returns the host route sending the traffic towards the vtep
+// through the interface owning src, with src as preferred source address,
+// using the most specific route of the interface reaching the vtep
+func vtepRoute(vtep, src net.IP) (*netlink.Route, error) {
+	iface, err := ifaceByAddr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	family, bits := netlink.FAMILY_V4, 32
+	if vtep.To4() == nil {
+		family, bits = netlink.FAMILY_V6, 128
+	}
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+
+	best := -1
+	var gw net.IP
+	for _, r := range routes {
+		if r.LinkIndex != iface.Index {
+			continue
+		}
+		ones := 0
+		if r.Dst != nil {
+			if !r.Dst.Contains(vtep) {
+				continue
+			}
+			ones, _ = r.Dst.Mask.Size()
+		}
+		if ones > best {
+			best, gw = ones, r.Gw
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("interface %s has no route to %s", iface.Name, vtep)
+	}
+
+	return &netlink.Route{
+		LinkIndex: iface.Index,
+		Dst:       &net.IPNet{IP: vtep, Mask: net.CIDRMask(bits, bits)},
+		Src:       src,
+		Gw:        gw,
+	}, nil
+}
+
+// addMultipathRoute programs the route of the vtep through the nexthops,
+// the routes of the vtep through each path. A single nexthop keeps its
+// source address. The kernel derives the source address of each nexthop of
+// a multipath route from its interface, a multipath route having a single
+// preferred source address.
+func addMultipathRoute(hops []*netlink.Route) error {
+	if len(hops) == 1 {
+		return netlink.RouteAdd(hops[0])
+	}
+
+	dst := hops[0].Dst
+	family, data := netlink.FAMILY_V4, []byte(dst.IP.To4())
+	if dst.IP.To4() == nil {
+		family, data = netlink.FAMILY_V6, []byte(dst.IP.To16())
+	}
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(family)
+	ones, _ := dst.Mask.Size()
+	msg.Dst_len = uint8(ones)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(syscall.RTA_DST, data))
+
+	// Each nexthop is a struct rtnexthop followed by its gateway attribute
+	native := nl.NativeEndian()
+	var nexthops []byte
+	for _, h := range hops {
+		var attrs []byte
+		if h.Gw != nil {
+			gw := []byte(h.Gw.To4())
+			if family == netlink.FAMILY_V6 {
+				gw = h.Gw.To16()
+			}
+			attrs = nl.NewRtAttr(syscall.RTA_GATEWAY, gw).Serialize()
+		}
+		rtnh := make([]byte, syscall.SizeofRtNexthop)
+		native.PutUint16(rtnh[0:], uint16(syscall.SizeofRtNexthop+len(attrs)))
+		native.PutUint32(rtnh[4:], uint32(h.LinkIndex))
+		nexthops = append(nexthops, rtnh...)
+		nexthops = append(nexthops, attrs...)
+	}
+	req.AddData(nl.NewRtAttr(syscall.RTA_MULTIPATH, nexthops))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// delMultipathRoute removes the route of the vtep, whatever its nexthops
+func delMultipathRoute(hops []*netlink.Route) error {
+	return netlink.RouteDel(&netlink.Route{Dst: hops[0].Dst})
+}
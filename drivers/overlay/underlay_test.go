@@ -0,0 +1,154 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+type fakeUnderlay struct {
+	failed map[string]bool
+	routes map[string]string // source addresses of the nexthops of each vtep
+}
+
+func newTestUnderlay(addrs ...string) (*underlay, *fakeUnderlay) {
+	var ips []net.IP
+	for _, a := range addrs {
+		ips = append(ips, net.ParseIP(a))
+	}
+	u := newUnderlay(ips)
+	f := &fakeUnderlay{failed: map[string]bool{}, routes: map[string]string{}}
+	u.checkPath = func(addr net.IP) error {
+		if f.failed[addr.String()] {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}
+	u.vtepRoute = func(vtep, src net.IP) (*netlink.Route, error) {
+		return &netlink.Route{Dst: &net.IPNet{IP: vtep, Mask: net.CIDRMask(32, 32)}, Src: src}, nil
+	}
+	u.addRoute = func(hops []*netlink.Route) error {
+		dst := hops[0].Dst.IP.String()
+		if _, ok := f.routes[dst]; ok {
+			return fmt.Errorf("route to %s exists", dst)
+		}
+		var srcs []string
+		for _, h := range hops {
+			srcs = append(srcs, h.Src.String())
+		}
+		f.routes[dst] = strings.Join(srcs, ",")
+		return nil
+	}
+	u.delRoute = func(hops []*netlink.Route) error {
+		delete(f.routes, hops[0].Dst.IP.String())
+		return nil
+	}
+	return u, f
+}
+
+func TestParseUnderlayAddrs(t *testing.T) {
+	addrs, err := parseUnderlayAddrs("10.0.0.1, 10.1.0.1", "")
+	if err != nil || len(addrs) != 2 || !addrs[1].Equal(net.ParseIP("10.1.0.1")) {
+		t.Fatalf("Unexpected addresses %v: %v", addrs, err)
+	}
+
+	for _, s := range []string{"", "10.0.0.1,bogus", "10.0.0.1,10.0.0.1", "10.0.0.1,fd00::1"} {
+		if _, err := parseUnderlayAddrs(s, ""); err == nil {
+			t.Fatalf("Expected failure parsing %q", s)
+		}
+	}
+	if _, err := parseUnderlayAddrs("10.0.0.1", familyIPv6); err == nil {
+		t.Fatalf("Expected failure parsing an ipv4 address for the ipv6 family")
+	}
+}
+
+func TestUnderlaySpread(t *testing.T) {
+	u, f := newTestUnderlay("10.0.0.1", "10.1.0.1")
+
+	for i := 0; i < 64; i++ {
+		vtep := net.IPv4(192, 168, 0, byte(i))
+		if err := u.addPeer(fmt.Sprintf("peer%d", i), vtep); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The flows towards each vtep are spread over both paths
+	for v, srcs := range f.routes {
+		if srcs != "10.0.0.1,10.1.0.1" {
+			t.Fatalf("Expected vtep %s to be routed through both paths, got %s", v, srcs)
+		}
+	}
+	if len(f.routes) != 64 {
+		t.Fatalf("Expected a route per vtep, got %d", len(f.routes))
+	}
+}
+
+func TestUnderlayPeers(t *testing.T) {
+	u, f := newTestUnderlay("10.0.0.1", "10.1.0.1")
+	vtep := net.ParseIP("192.168.0.10")
+
+	if err := u.addPeer("peer1", vtep); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.addPeer("peer2", vtep); err != nil {
+		t.Fatal(err)
+	}
+	// Adding a known peer again is a no-op
+	if err := u.addPeer("peer1", vtep); err != nil {
+		t.Fatal(err)
+	}
+
+	u.deletePeer("peer1")
+	if _, ok := f.routes[vtep.String()]; !ok {
+		t.Fatalf("Expected the vtep route to remain while a peer is behind it")
+	}
+	u.deletePeer("peer2")
+	if _, ok := f.routes[vtep.String()]; ok {
+		t.Fatalf("Expected the vtep route to be removed with its last peer")
+	}
+}
+
+func TestUnderlayFailover(t *testing.T) {
+	u, f := newTestUnderlay("10.0.0.1", "10.1.0.1")
+
+	for i := 0; i < 16; i++ {
+		if err := u.addPeer(fmt.Sprintf("peer%d", i), net.IPv4(192, 168, 0, byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := map[string]string{}
+	for v, src := range f.routes {
+		before[v] = src
+	}
+
+	f.failed["10.0.0.1"] = true
+	u.checkHealth()
+	for v, src := range f.routes {
+		if src != "10.1.0.1" {
+			t.Fatalf("Expected vtep %s to move to the healthy path, got %s", v, src)
+		}
+	}
+
+	f.failed["10.1.0.1"] = true
+	u.checkHealth()
+	if len(f.routes) != 0 {
+		t.Fatalf("Expected the vteps to be left to the main routing table, got %v", f.routes)
+	}
+
+	delete(f.failed, "10.0.0.1")
+	delete(f.failed, "10.1.0.1")
+	u.checkHealth()
+	for v, src := range before {
+		if f.routes[v] != src {
+			t.Fatalf("Expected vtep %s back on path %s, got %s", v, src, f.routes[v])
+		}
+	}
+
+	u.stop()
+	if len(f.routes) != 0 {
+		t.Fatalf("Expected the routes to be removed on stop, got %v", f.routes)
+	}
+}
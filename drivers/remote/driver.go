@@ -1,8 +1,13 @@
 package remote
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
@@ -10,11 +15,24 @@ import (
 	"github.com/docker/libnetwork/types"
 )
 
+const (
+	// maxResolveAttempts bounds the status queries and replays made to
+	// find out the outcome of an operation
+	maxResolveAttempts = 5
+	resolveInterval    = time.Second
+)
+
 type driver struct {
 	endpoint    *plugins.Client
 	networkType string
 }
 
+// restoringDriver is the driver of the plugins which reported they restore
+// their endpoints, only those implement driverapi.Restorer
+type restoringDriver struct {
+	*driver
+}
+
 func newDriver(name string, client *plugins.Client) driverapi.Driver {
 	return &driver{networkType: name, endpoint: client}
 }
@@ -23,16 +41,70 @@ func newDriver(name string, client *plugins.Client) driverapi.Driver {
 // plugin is activated.
 func Init(dc driverapi.DriverCallback) error {
 	plugins.Handle(driverapi.NetworkPluginEndpointType, func(name string, client *plugins.Client) {
-		c := driverapi.Capability{
-			Scope: driverapi.GlobalScope,
-		}
-		if err := dc.RegisterDriver(name, newDriver(name, client), c); err != nil {
+		if err := register(dc, name, client); err != nil {
 			log.Errorf("error registering driver for %s due to %v", name, err)
 		}
 	})
 	return nil
 }
 
+func register(dc driverapi.DriverCallback, name string, client *plugins.Client) error {
+	c := driverapi.Capability{
+		Scope: driverapi.GlobalScope,
+	}
+	// Reporting the capabilities is optional, the plugins which do not
+	// keep the defaults
+	var (
+		res      getCapabilitiesResponse
+		restores bool
+	)
+	if err := client.Call(driverapi.NetworkPluginEndpointType+".GetCapabilities", nil, &res); err != nil || res.Err != "" {
+		log.Debugf("Plugin %s did not report its capabilities, using the defaults", name)
+	} else {
+		switch res.Scope {
+		case "", scopeGlobal:
+		case scopeLocal:
+			c.Scope = driverapi.LocalScope
+		default:
+			return fmt.Errorf("plugin %s reported the invalid scope %q", name, res.Scope)
+		}
+		c.DriverAssignsAddress = res.AssignsAddress
+		restores = res.RestoresEndpoints
+	}
+
+	d := newDriver(name, client)
+	if restores {
+		d = &restoringDriver{d.(*driver)}
+	}
+	return dc.RegisterDriver(name, d, c)
+}
+
+// Activate activates the plugin served at addr, which is not known to the
+// docker plugin registry, and registers it as a network driver. Plugins
+// started on the first connection, like the systemd socket activated ones,
+// are given timeout to answer.
+func Activate(dc driverapi.DriverCallback, name, addr string, timeout time.Duration) error {
+	client := plugins.NewClient(addr)
+	m := &plugins.Manifest{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := callContext(ctx, client, "Plugin.Activate", nil, m); err != nil {
+		if err == context.DeadlineExceeded {
+			return types.TimeoutErrorf("plugin %s at %s was not activated within %s", name, addr, timeout)
+		}
+		return fmt.Errorf("failed to activate plugin %s at %s: %v", name, addr, err)
+	}
+
+	for _, iface := range m.Implements {
+		if iface == driverapi.NetworkPluginEndpointType {
+			return register(dc, name, client)
+		}
+	}
+
+	return plugins.ErrNotImplements
+}
+
 // Config is not implemented for remote drivers, since it is assumed
 // to be supplied to the remote process out-of-band (e.g., as command
 // line arguments).
@@ -40,11 +112,18 @@ func (d *driver) Config(option map[string]interface{}) error {
 	return &driverapi.ErrNotImplemented{}
 }
 
-func (d *driver) call(methodName string, arg interface{}, retVal maybeError) error {
+func (d *driver) call(ctx context.Context, methodName string, arg interface{}, retVal maybeError) error {
 	method := driverapi.NetworkPluginEndpointType + "." + methodName
-	err := d.endpoint.Call(method, arg, retVal)
+	err := d.callContext(ctx, method, arg, retVal)
 	if err != nil {
-		return err
+		// A request given up on is not resolved, the caller is gone
+		op, ok := arg.(operation)
+		if !ok || ctx.Err() != nil || !isUncertain(err) {
+			return err
+		}
+		if err = d.resolve(ctx, method, op.operationID(), arg, retVal, err); err != nil {
+			return err
+		}
 	}
 	if e := retVal.getError(); e != "" {
 		return fmt.Errorf("remote: %s", e)
@@ -52,20 +131,105 @@ func (d *driver) call(methodName string, arg interface{}, retVal maybeError) err
 	return nil
 }
 
-func (d *driver) CreateNetwork(id types.UUID, options map[string]interface{}) error {
+func (d *driver) callContext(ctx context.Context, method string, arg interface{}, retVal interface{}) error {
+	return callContext(ctx, d.endpoint, method, arg, retVal)
+}
+
+// callContext issues the request and gives up on it once ctx is done. The
+// plugin client retries for a long while when the plugin does not answer,
+// which would otherwise block the caller as long. The request given up on
+// runs to its end in the background, its response is decoded apart so that
+// it is never written to retVal once the caller is gone.
+func callContext(ctx context.Context, client *plugins.Client, method string, arg interface{}, retVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var raw json.RawMessage
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(method, arg, &raw)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil || len(raw) == 0 {
+			// A null response leaves raw empty
+			return err
+		}
+		return json.Unmarshal(raw, retVal)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isUncertain tells whether the plugin may or may not have applied the
+// request which failed with err, because the exchange broke down before
+// the response was received.
+func isUncertain(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error, *json.SyntaxError:
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// resolve finds out the outcome of the operation whose request failed with
+// callErr by querying the plugin for the state of the operation. The
+// response of a completed operation is decoded into retVal, an operation
+// unknown to the plugin is replayed. If the plugin cannot tell, callErr is
+// returned.
+func (d *driver) resolve(ctx context.Context, method, opID string, arg interface{}, retVal maybeError, callErr error) error {
+	for i := 0; i < maxResolveAttempts; i++ {
+		var status operationStatusResponse
+		req := &operationStatusRequest{OperationID: opID}
+		if err := d.callContext(ctx, driverapi.NetworkPluginEndpointType+".OperationStatus", req, &status); err != nil || status.Err != "" {
+			return fmt.Errorf("%v (outcome of operation %s unknown)", callErr, opID)
+		}
+
+		switch status.Status {
+		case operationCompleted:
+			if len(status.Response) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(status.Response, retVal); err != nil {
+				return fmt.Errorf("failed to decode response of operation %s: %v", opID, err)
+			}
+			return nil
+		case operationUnknown:
+			log.Debugf("Replaying operation %s to %s after: %v", opID, method, callErr)
+			if callErr = d.callContext(ctx, method, arg, retVal); callErr == nil || !isUncertain(callErr) {
+				return callErr
+			}
+		case operationPending:
+			select {
+			case <-time.After(resolveInterval):
+			case <-ctx.Done():
+				return fmt.Errorf("%v (outcome of operation %s unknown: %v)", callErr, opID, ctx.Err())
+			}
+		default:
+			return fmt.Errorf("%v (invalid state %q reported for operation %s)", callErr, status.Status, opID)
+		}
+	}
+
+	return fmt.Errorf("%v (operation %s not resolved after %d attempts)", callErr, opID, maxResolveAttempts)
+}
+
+func (d *driver) CreateNetwork(ctx context.Context, id types.UUID, options map[string]interface{}) error {
 	create := &createNetworkRequest{
+		request:   newRequest(),
 		NetworkID: string(id),
 		Options:   options,
 	}
-	return d.call("CreateNetwork", create, &createNetworkResponse{})
+	return d.call(ctx, "CreateNetwork", create, &createNetworkResponse{})
 }
 
-func (d *driver) DeleteNetwork(nid types.UUID) error {
-	delete := &deleteNetworkRequest{NetworkID: string(nid)}
-	return d.call("DeleteNetwork", delete, &deleteNetworkResponse{})
+func (d *driver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
+	delete := &deleteNetworkRequest{request: newRequest(), NetworkID: string(nid)}
+	return d.call(ctx, "DeleteNetwork", delete, &deleteNetworkResponse{})
 }
 
-func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointInfo, epOptions map[string]interface{}) error {
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, epOptions map[string]interface{}) error {
 	if epInfo == nil {
 		return fmt.Errorf("must not be called with nil EndpointInfo")
 	}
@@ -82,13 +246,14 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 		}
 	}
 	create := &createEndpointRequest{
+		request:    newRequest(),
 		NetworkID:  string(nid),
 		EndpointID: string(eid),
 		Interfaces: reqIfaces,
 		Options:    epOptions,
 	}
 	var res createEndpointResponse
-	if err := d.call("CreateEndpoint", create, &res); err != nil {
+	if err := d.call(ctx, "CreateEndpoint", create, &res); err != nil {
 		return err
 	}
 
@@ -99,7 +264,7 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 	if len(reqIfaces) > 0 && len(ifaces) > 0 {
 		// We're not supposed to add interfaces if there already are
 		// some. Attempt to roll back
-		return errorWithRollback("driver attempted to add more interfaces", d.DeleteEndpoint(nid, eid))
+		return errorWithRollback("driver attempted to add more interfaces", d.DeleteEndpoint(context.Background(), nid, eid))
 	}
 	for _, iface := range ifaces {
 		var addr4, addr6 net.IPNet
@@ -110,7 +275,7 @@ func (d *driver) CreateEndpoint(nid, eid types.UUID, epInfo driverapi.EndpointIn
 			addr6 = *(iface.AddressIPv6)
 		}
 		if err := epInfo.AddInterface(iface.ID, iface.MacAddress, addr4, addr6); err != nil {
-			return errorWithRollback(fmt.Sprintf("failed to AddInterface %v: %s", iface, err), d.DeleteEndpoint(nid, eid))
+			return errorWithRollback(fmt.Sprintf("failed to AddInterface %v: %s", iface, err), d.DeleteEndpoint(context.Background(), nid, eid))
 		}
 	}
 	return nil
@@ -124,12 +289,13 @@ func errorWithRollback(msg string, err error) error {
 	return fmt.Errorf("%s; %s", msg, rollback)
 }
 
-func (d *driver) DeleteEndpoint(nid, eid types.UUID) error {
+func (d *driver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
 	delete := &deleteEndpointRequest{
+		request:    newRequest(),
 		NetworkID:  string(nid),
 		EndpointID: string(eid),
 	}
-	return d.call("DeleteEndpoint", delete, &deleteEndpointResponse{})
+	return d.call(ctx, "DeleteEndpoint", delete, &deleteEndpointResponse{})
 }
 
 func (d *driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
@@ -138,15 +304,16 @@ func (d *driver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{},
 		EndpointID: string(eid),
 	}
 	var res endpointInfoResponse
-	if err := d.call("EndpointOperInfo", info, &res); err != nil {
+	if err := d.call(context.Background(), "EndpointOperInfo", info, &res); err != nil {
 		return nil, err
 	}
 	return res.Value, nil
 }
 
 // Join method is invoked when a Sandbox is attached to an endpoint.
-func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+func (d *driver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
 	join := &joinRequest{
+		request:    newRequest(),
 		NetworkID:  string(nid),
 		EndpointID: string(eid),
 		SandboxKey: sboxKey,
@@ -156,7 +323,7 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 		res joinResponse
 		err error
 	)
-	if err = d.call("Join", join, &res); err != nil {
+	if err = d.call(ctx, "Join", join, &res); err != nil {
 		return err
 	}
 
@@ -172,7 +339,7 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 		}
 		supplied := ifaceNames[i]
 		if err := iface.SetNames(supplied.SrcName, supplied.DstPrefix); err != nil {
-			return errorWithRollback(fmt.Sprintf("failed to set interface name: %s", err), d.Leave(nid, eid))
+			return errorWithRollback(fmt.Sprintf("failed to set interface name: %s", err), d.Leave(context.Background(), nid, eid))
 		}
 	}
 
@@ -182,7 +349,7 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 			return fmt.Errorf(`unable to parse Gateway "%s"`, res.Gateway)
 		}
 		if jinfo.SetGateway(addr) != nil {
-			return errorWithRollback(fmt.Sprintf("failed to set gateway: %v", addr), d.Leave(nid, eid))
+			return errorWithRollback(fmt.Sprintf("failed to set gateway: %v", addr), d.Leave(context.Background(), nid, eid))
 		}
 	}
 	if res.GatewayIPv6 != "" {
@@ -190,7 +357,7 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 			return fmt.Errorf(`unable to parse GatewayIPv6 "%s"`, res.GatewayIPv6)
 		}
 		if jinfo.SetGatewayIPv6(addr) != nil {
-			return errorWithRollback(fmt.Sprintf("failed to set gateway IPv6: %v", addr), d.Leave(nid, eid))
+			return errorWithRollback(fmt.Sprintf("failed to set gateway IPv6: %v", addr), d.Leave(context.Background(), nid, eid))
 		}
 	}
 	if len(res.StaticRoutes) > 0 {
@@ -200,26 +367,74 @@ func (d *driver) Join(nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinI
 		}
 		for _, route := range routes {
 			if jinfo.AddStaticRoute(route.Destination, route.RouteType, route.NextHop, route.InterfaceID) != nil {
-				return errorWithRollback(fmt.Sprintf("failed to set static route: %v", route), d.Leave(nid, eid))
+				return errorWithRollback(fmt.Sprintf("failed to set static route: %v", route), d.Leave(context.Background(), nid, eid))
+			}
+		}
+	}
+	if len(res.Interfaces) > 0 {
+		ifaces, err := res.parseInterfaces()
+		if err != nil {
+			return errorWithRollback(fmt.Sprintf("failed to parse interface addresses: %v", err), d.Leave(context.Background(), nid, eid))
+		}
+		as, ok := jinfo.(driverapi.InterfaceAddressSetter)
+		if !ok {
+			return errorWithRollback("interface addresses cannot be set on join", d.Leave(context.Background(), nid, eid))
+		}
+		for _, iface := range ifaces {
+			var addr4, addr6 net.IPNet
+			if iface.Address != nil {
+				addr4 = *(iface.Address)
+			}
+			if iface.AddressIPv6 != nil {
+				addr6 = *(iface.AddressIPv6)
+			}
+			if err := as.SetInterfaceAddress(iface.ID, addr4, addr6); err != nil {
+				return errorWithRollback(fmt.Sprintf("failed to set addresses of interface %d: %v", iface.ID, err), d.Leave(context.Background(), nid, eid))
 			}
 		}
 	}
 	if jinfo.SetHostsPath(res.HostsPath) != nil {
-		return errorWithRollback(fmt.Sprintf("failed to set hosts path: %s", res.HostsPath), d.Leave(nid, eid))
+		return errorWithRollback(fmt.Sprintf("failed to set hosts path: %s", res.HostsPath), d.Leave(context.Background(), nid, eid))
 	}
 	if jinfo.SetResolvConfPath(res.ResolvConfPath) != nil {
-		return errorWithRollback(fmt.Sprintf("failed to set resolv.conf path: %s", res.ResolvConfPath), d.Leave(nid, eid))
+		return errorWithRollback(fmt.Sprintf("failed to set resolv.conf path: %s", res.ResolvConfPath), d.Leave(context.Background(), nid, eid))
 	}
 	return nil
 }
 
 // Leave method is invoked when a Sandbox detaches from an endpoint.
-func (d *driver) Leave(nid, eid types.UUID) error {
+func (d *driver) Leave(ctx context.Context, nid, eid types.UUID) error {
 	leave := &leaveRequest{
+		request:    newRequest(),
 		NetworkID:  string(nid),
 		EndpointID: string(eid),
 	}
-	return d.call("Leave", leave, &leaveResponse{})
+	return d.call(ctx, "Leave", leave, &leaveResponse{})
+}
+
+// RestoreEndpoints hands the endpoints of the networks of the plugin over to
+// it, for the plugin to reconcile its state after a restart of the daemon.
+func (d *restoringDriver) RestoreEndpoints(ctx context.Context, endpoints []driverapi.RestoredEndpoint) error {
+	restore := &restoreEndpointsRequest{Endpoints: make([]*restoredEndpoint, len(endpoints))}
+	for i, ep := range endpoints {
+		rep := &restoredEndpoint{
+			NetworkID:   string(ep.NetworkID),
+			EndpointID:  string(ep.EndpointID),
+			Interfaces:  make([]*endpointInterface, len(ep.Interfaces)),
+			Options:     ep.Options,
+			ContainerID: ep.ContainerID,
+		}
+		for j, iface := range ep.Interfaces {
+			rep.Interfaces[j] = &endpointInterface{
+				ID:          iface.ID,
+				Address:     iface.Address.String(),
+				AddressIPv6: iface.AddressIPv6.String(),
+				MacAddress:  iface.MacAddress.String(),
+			}
+		}
+		restore.Endpoints[i] = rep
+	}
+	return d.call(ctx, "RestoreEndpoints", restore, &restoreEndpointsResponse{})
 }
 
 func (d *driver) Type() string {
@@ -1,12 +1,16 @@
 package remote
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/driverapi"
@@ -152,6 +156,11 @@ func (test *testEndpoint) SetResolvConfPath(p string) error {
 	return nil
 }
 
+func (test *testEndpoint) AddBoundSocket(binding types.PortBinding, f *os.File) error {
+	test.t.Fatalf("Unexpected bound socket for %v", binding)
+	return nil
+}
+
 func (test *testEndpoint) SetNames(src string, dst string) error {
 	if test.src != src {
 		test.t.Fatalf(`Wrong SrcName; expected "%s", got "%s"`, test.src, src)
@@ -283,32 +292,32 @@ func TestRemoteDriver(t *testing.T) {
 	}
 
 	netID := types.UUID("dummy-network")
-	err = driver.CreateNetwork(netID, map[string]interface{}{})
+	err = driver.CreateNetwork(context.Background(), netID, map[string]interface{}{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	endID := types.UUID("dummy-endpoint")
-	err = driver.CreateEndpoint(netID, endID, ep, map[string]interface{}{})
+	err = driver.CreateEndpoint(context.Background(), netID, endID, ep, map[string]interface{}{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	joinOpts := map[string]interface{}{"foo": "fooValue"}
-	err = driver.Join(netID, endID, "sandbox-key", ep, joinOpts)
+	err = driver.Join(context.Background(), netID, endID, "sandbox-key", ep, joinOpts)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if _, err = driver.EndpointOperInfo(netID, endID); err != nil {
 		t.Fatal(err)
 	}
-	if err = driver.Leave(netID, endID); err != nil {
+	if err = driver.Leave(context.Background(), netID, endID); err != nil {
 		t.Fatal(err)
 	}
-	if err = driver.DeleteEndpoint(netID, endID); err != nil {
+	if err = driver.DeleteEndpoint(context.Background(), netID, endID); err != nil {
 		t.Fatal(err)
 	}
-	if err = driver.DeleteNetwork(netID); err != nil {
+	if err = driver.DeleteNetwork(context.Background(), netID); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -345,11 +354,48 @@ func TestDriverError(t *testing.T) {
 
 	driver := newDriver(plugin, p.Client)
 
-	if err := driver.CreateEndpoint(types.UUID("dummy"), types.UUID("dummy"), &testEndpoint{t: t}, map[string]interface{}{}); err == nil {
+	if err := driver.CreateEndpoint(context.Background(), types.UUID("dummy"), types.UUID("dummy"), &testEndpoint{t: t}, map[string]interface{}{}); err == nil {
 		t.Fatalf("Expected error from driver")
 	}
 }
 
+func TestCancel(t *testing.T) {
+	var plugin = "test-net-driver-cancel"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	release := make(chan struct{})
+	defer close(release)
+	handle(t, mux, "CreateNetwork", func(msg map[string]interface{}) interface{} {
+		<-release
+		return map[string]string{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newDriver(plugin, p.Client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := driver.CreateNetwork(ctx, types.UUID("dummy"), nil); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the call to time out, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("The call was not abandoned when its context expired")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := driver.DeleteNetwork(ctx, types.UUID("dummy")); err != context.Canceled {
+		t.Fatalf("Expected the call to be canceled, got %v", err)
+	}
+}
+
 func TestMissingValues(t *testing.T) {
 	var plugin = "test-net-driver-missing"
 
@@ -379,7 +425,7 @@ func TestMissingValues(t *testing.T) {
 	}
 	driver := newDriver(plugin, p.Client)
 
-	if err := driver.CreateEndpoint(types.UUID("dummy"), types.UUID("dummy"), ep, map[string]interface{}{}); err != nil {
+	if err := driver.CreateEndpoint(context.Background(), types.UUID("dummy"), types.UUID("dummy"), ep, map[string]interface{}{}); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -427,10 +473,317 @@ func TestRollback(t *testing.T) {
 
 	ep := &rollbackEndpoint{}
 
-	if err := driver.CreateEndpoint(types.UUID("dummy"), types.UUID("dummy"), ep, map[string]interface{}{}); err == nil {
+	if err := driver.CreateEndpoint(context.Background(), types.UUID("dummy"), types.UUID("dummy"), ep, map[string]interface{}{}); err == nil {
 		t.Fatalf("Expected error from driver")
 	}
 	if !rolledback {
 		t.Fatalf("Expected to have had DeleteEndpoint called")
 	}
 }
+
+func TestOperationResolve(t *testing.T) {
+	var plugin = "test-net-driver-resolve"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	var (
+		createIDs []string
+		deleteIDs []string
+	)
+
+	// The responses are cut short, so that the outcome is unknown to the
+	// driver. The network creation did take effect, the endpoint deletion
+	// did not.
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		msg, err := decodeToMap(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		createIDs = append(createIDs, msg["OperationID"].(string))
+		fmt.Fprint(w, `{"Err":`)
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.DeleteEndpoint", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		msg, err := decodeToMap(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleteIDs = append(deleteIDs, msg["OperationID"].(string))
+		if len(deleteIDs) == 1 {
+			fmt.Fprint(w, `{"Err":`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	})
+	handle(t, mux, "OperationStatus", func(msg map[string]interface{}) interface{} {
+		id := msg["OperationID"].(string)
+		if len(createIDs) != 0 && id == createIDs[0] {
+			return map[string]interface{}{"Status": operationCompleted, "Response": map[string]interface{}{}}
+		}
+		return map[string]interface{}{"Status": operationUnknown}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newDriver(plugin, p.Client)
+
+	if err := driver.CreateNetwork(context.Background(), "dummy", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(createIDs) != 1 || createIDs[0] == "" {
+		t.Fatalf("Completed operation was replayed: %v", createIDs)
+	}
+
+	if err := driver.DeleteEndpoint(context.Background(), "dummy", "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleteIDs) != 2 || deleteIDs[0] != deleteIDs[1] {
+		t.Fatalf("Unknown operation was not replayed with the same id: %v", deleteIDs)
+	}
+}
+
+func TestOperationResolveUnsupported(t *testing.T) {
+	var plugin = "test-net-driver-resolve-unsupported"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	mux.HandleFunc(fmt.Sprintf("/%s.Leave", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Err":`)
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newDriver(plugin, p.Client)
+
+	if err := driver.Leave(context.Background(), "dummy", "dummy"); err == nil {
+		t.Fatal("Expected failure when the plugin cannot report the operation state")
+	}
+}
+
+func TestRestoreEndpoints(t *testing.T) {
+	var plugin = "test-net-driver-restore"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	var restored []interface{}
+	handle(t, mux, "RestoreEndpoints", func(msg map[string]interface{}) interface{} {
+		if _, ok := msg["OperationID"]; ok {
+			t.Fatal("Restore request carries an operation id")
+		}
+		restored = msg["Endpoints"].([]interface{})
+		return map[string]string{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := &restoringDriver{newDriver(plugin, p.Client).(*driver)}
+
+	ip, ipNet, _ := net.ParseCIDR("10.0.0.2/24")
+	addr := net.IPNet{IP: ip, Mask: ipNet.Mask}
+	mac, _ := net.ParseMAC("ac:cd:ef:12:34:56")
+	eps := []driverapi.RestoredEndpoint{{
+		NetworkID:   "net1",
+		EndpointID:  "ep1",
+		Interfaces:  []driverapi.RestoredInterface{{ID: 0, MacAddress: mac, Address: addr}},
+		Options:     map[string]interface{}{"foo": "bar"},
+		ContainerID: "container1",
+	}}
+	if err := driver.RestoreEndpoints(context.Background(), eps); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored) != 1 {
+		t.Fatalf("Expected one endpoint in the request, got %v", restored)
+	}
+	ep := restored[0].(map[string]interface{})
+	if ep["NetworkID"] != "net1" || ep["EndpointID"] != "ep1" || ep["ContainerID"] != "container1" {
+		t.Fatalf("Unexpected endpoint in the request: %v", ep)
+	}
+	ifaces := ep["Interfaces"].([]interface{})
+	if len(ifaces) != 1 {
+		t.Fatalf("Unexpected interfaces in the request: %v", ifaces)
+	}
+	iface := ifaces[0].(map[string]interface{})
+	if iface["Address"] != "10.0.0.2/24" || iface["MacAddress"] != "ac:cd:ef:12:34:56" {
+		t.Fatalf("Unexpected interface in the request: %v", iface)
+	}
+	if opts := ep["Options"].(map[string]interface{}); opts["foo"] != "bar" {
+		t.Fatalf("Unexpected options in the request: %v", opts)
+	}
+}
+
+type testCallback struct {
+	drivers      map[string]driverapi.Driver
+	capabilities map[string]driverapi.Capability
+}
+
+func (cb *testCallback) RegisterDriver(name string, driver driverapi.Driver, capability driverapi.Capability) error {
+	cb.drivers[name] = driver
+	if cb.capabilities != nil {
+		cb.capabilities[name] = capability
+	}
+	return nil
+}
+
+func TestActivate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	serve := func(name, implements string, delay time.Duration) (string, func()) {
+		path := filepath.Join(tmp, name+".sock")
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			fmt.Fprintf(w, `{"Implements": ["%s"]}`, implements)
+		})
+		go http.Serve(l, mux)
+		return "unix://" + path, func() { l.Close() }
+	}
+
+	cb := &testCallback{drivers: make(map[string]driverapi.Driver)}
+
+	addr, stop := serve("net1", driverapi.NetworkPluginEndpointType, 0)
+	defer stop()
+	if err := Activate(cb, "net1", addr, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := cb.drivers["net1"]; !ok || d.Type() != "net1" {
+		t.Fatalf("Plugin was not registered as a driver: %v", cb.drivers)
+	}
+
+	addr, stop = serve("vol1", "VolumeDriver", 0)
+	defer stop()
+	if err := Activate(cb, "vol1", addr, time.Second); err != plugins.ErrNotImplements {
+		t.Fatalf("Expected ErrNotImplements, got %v", err)
+	}
+
+	addr, stop = serve("net2", driverapi.NetworkPluginEndpointType, time.Second)
+	defer stop()
+	err = Activate(cb, "net2", addr, 100*time.Millisecond)
+	if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if _, ok := cb.drivers["net2"]; ok {
+		t.Fatal("Plugin was registered after the activation timed out")
+	}
+}
+
+type addressEndpoint struct {
+	*testEndpoint
+	addr   net.IPNet
+	addrv6 net.IPNet
+}
+
+func (a *addressEndpoint) SetInterfaceAddress(ID int, ipv4 net.IPNet, ipv6 net.IPNet) error {
+	if ID != a.id {
+		a.t.Fatalf("Wrong ID passed to SetInterfaceAddress: %d", ID)
+	}
+	a.addr, a.addrv6 = ipv4, ipv6
+	return nil
+}
+
+func TestDriverAssignsAddress(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "dhcp.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{"Scope": "local", "AssignsAddress": true}
+	})
+	handle(t, mux, "Join", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"InterfaceNames": []map[string]interface{}{{"SrcName": "vethsrc", "DstPrefix": "vethdst"}},
+			"Interfaces":     []map[string]interface{}{{"ID": 0, "Address": "10.1.0.5/24"}},
+		}
+	})
+	go http.Serve(l, mux)
+
+	cb := &testCallback{drivers: make(map[string]driverapi.Driver), capabilities: make(map[string]driverapi.Capability)}
+	if err := Activate(cb, "dhcp", "unix://"+path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c := cb.capabilities["dhcp"]
+	if c.Scope != driverapi.LocalScope || !c.DriverAssignsAddress {
+		t.Fatalf("Unexpected capability %+v", c)
+	}
+
+	ep := &addressEndpoint{testEndpoint: &testEndpoint{t: t, src: "vethsrc", dst: "vethdst"}}
+	if err := cb.drivers["dhcp"].Join(context.Background(), "net1", "ep1", "sbox", ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ep.addr.String() != "10.1.0.5/24" || ep.addrv6.IP != nil {
+		t.Fatalf("Unexpected addresses set on join: %v, %v", ep.addr, ep.addrv6)
+	}
+
+	// The JoinInfo must be able to take the addresses
+	if err := cb.drivers["dhcp"].Join(context.Background(), "net1", "ep1", "sbox", ep.testEndpoint, nil); err == nil {
+		t.Fatal("Addresses were reported to a JoinInfo which cannot set them")
+	}
+}
+
+func TestRestoresEndpointsCapability(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cb := &testCallback{drivers: make(map[string]driverapi.Driver), capabilities: make(map[string]driverapi.Capability)}
+	for _, restores := range []bool{true, false} {
+		name := fmt.Sprintf("restores-%t", restores)
+		path := filepath.Join(tmp, name+".sock")
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		capabilities := map[string]interface{}{"RestoresEndpoints": restores}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+		})
+		handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+			return capabilities
+		})
+		go http.Serve(l, mux)
+
+		if err := Activate(cb, name, "unix://"+path, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cb.drivers[name].(driverapi.Restorer); ok != restores {
+			t.Fatalf("Driver of plugin reporting RestoresEndpoints %t implements driverapi.Restorer: %t", restores, ok)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package remote
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -19,7 +21,63 @@ func (r *response) getError() string {
 	return r.Err
 }
 
+// request carries the fields common to the requests which change the
+// plugin state. The OperationID identifies the operation and is kept when
+// the request is replayed, so that a plugin can recognize a request it has
+// already applied.
+type request struct {
+	OperationID string
+}
+
+func newRequest() request {
+	return request{OperationID: stringid.GenerateRandomID()}
+}
+
+func (r *request) operationID() string {
+	return r.OperationID
+}
+
+// operation is implemented by the requests carrying an operation id
+type operation interface {
+	operationID() string
+}
+
+// Operation states reported by the plugin
+const (
+	// operationCompleted means the plugin handled the operation, its
+	// response is returned in the status
+	operationCompleted = "completed"
+	// operationPending means the plugin is still handling the operation
+	operationPending = "pending"
+	// operationUnknown means the plugin never received the operation
+	operationUnknown = "unknown"
+)
+
+type operationStatusRequest struct {
+	OperationID string
+}
+
+type operationStatusResponse struct {
+	response
+	Status   string
+	Response json.RawMessage
+}
+
+// Scopes a plugin reports in its capabilities
+const (
+	scopeLocal  = "local"
+	scopeGlobal = "global"
+)
+
+type getCapabilitiesResponse struct {
+	response
+	Scope             string
+	AssignsAddress    bool
+	RestoresEndpoints bool
+}
+
 type createNetworkRequest struct {
+	request
 	NetworkID string
 	Options   map[string]interface{}
 }
@@ -29,6 +87,7 @@ type createNetworkResponse struct {
 }
 
 type deleteNetworkRequest struct {
+	request
 	NetworkID string
 }
 
@@ -37,6 +96,7 @@ type deleteNetworkResponse struct {
 }
 
 type createEndpointRequest struct {
+	request
 	NetworkID  string
 	EndpointID string
 	Interfaces []*endpointInterface
@@ -79,8 +139,16 @@ type iface struct {
 }
 
 func (r *createEndpointResponse) parseInterfaces() ([]*iface, error) {
-	var ifaces = make([]*iface, len(r.Interfaces))
-	for i, inIf := range r.Interfaces {
+	return parseInterfaces(r.Interfaces)
+}
+
+func (r *joinResponse) parseInterfaces() ([]*iface, error) {
+	return parseInterfaces(r.Interfaces)
+}
+
+func parseInterfaces(in []*endpointInterface) ([]*iface, error) {
+	var ifaces = make([]*iface, len(in))
+	for i, inIf := range in {
 		var err error
 		outIf := &iface{ID: inIf.ID}
 		if inIf.Address != "" {
@@ -128,6 +196,7 @@ func (r *joinResponse) parseStaticRoutes() ([]*types.StaticRoute, error) {
 }
 
 type deleteEndpointRequest struct {
+	request
 	NetworkID  string
 	EndpointID string
 }
@@ -147,6 +216,7 @@ type endpointInfoResponse struct {
 }
 
 type joinRequest struct {
+	request
 	NetworkID  string
 	EndpointID string
 	SandboxKey string
@@ -166,9 +236,13 @@ type joinResponse struct {
 	StaticRoutes   []*staticRoute
 	HostsPath      string
 	ResolvConfPath string
+	// Interfaces carries the addresses of the interfaces, from the
+	// plugins which assign them
+	Interfaces []*endpointInterface
 }
 
 type leaveRequest struct {
+	request
 	NetworkID  string
 	EndpointID string
 }
@@ -176,3 +250,22 @@ type leaveRequest struct {
 type leaveResponse struct {
 	response
 }
+
+// restoreEndpointsRequest lists the endpoints libnetwork knows of in the
+// networks of the plugin. It does not change the libnetwork state and
+// carries no operation id.
+type restoreEndpointsRequest struct {
+	Endpoints []*restoredEndpoint
+}
+
+type restoredEndpoint struct {
+	NetworkID   string
+	EndpointID  string
+	Interfaces  []*endpointInterface
+	Options     map[string]interface{}
+	ContainerID string `json:",omitempty"`
+}
+
+type restoreEndpointsResponse struct {
+	response
+}
@@ -9,17 +9,20 @@ import (
 	"github.com/docker/libnetwork/drivers/remote"
 )
 
+// builtinDrivers are the drivers built in, by network type, for
+// ReloadDriver to initialize one of them again
+var builtinDrivers = []builtinDriver{
+	{"bridge", bridge.Init},
+	{"host", host.Init},
+	{"null", null.Init},
+	{"overlay", o.Init},
+}
+
 func initDrivers(dc driverapi.DriverCallback) error {
-	for _, fn := range [](func(driverapi.DriverCallback) error){
-		bridge.Init,
-		host.Init,
-		null.Init,
-		remote.Init,
-		o.Init,
-	} {
-		if err := fn(dc); err != nil {
+	for _, b := range builtinDrivers {
+		if err := b.init(dc); err != nil {
 			return err
 		}
 	}
-	return nil
+	return remote.Init(dc)
 }
@@ -5,11 +5,15 @@ import (
 	"github.com/docker/libnetwork/drivers/windows"
 )
 
+// builtinDrivers are the drivers built in, by network type, for
+// ReloadDriver to initialize one of them again
+var builtinDrivers = []builtinDriver{
+	{"windows", windows.Init},
+}
+
 func initDrivers(dc driverapi.DriverCallback) error {
-	for _, fn := range [](func(driverapi.DriverCallback) error){
-		windows.Init,
-	} {
-		if err := fn(dc); err != nil {
+	for _, b := range builtinDrivers {
+		if err := b.init(dc); err != nil {
 			return err
 		}
 	}
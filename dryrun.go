@@ -0,0 +1,95 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// DryRunResult is filled by the dry run of a network or endpoint creation,
+// requested with the NetworkOptionDryRun and CreateOptionDryRun options.
+type DryRunResult struct {
+	// Driver is the type of the driver which would program the network
+	Driver string
+	// Plan is what the driver would program on the host
+	Plan *driverapi.Plan
+}
+
+// NetworkOptionDryRun function returns an option setter which turns the
+// network creation into a dry run: the options are validated and result is
+// filled with what the driver would program, but neither the host nor the
+// store is changed, and NewNetwork returns no network.
+func NetworkOptionDryRun(result *DryRunResult) NetworkOption {
+	return func(n *network) {
+		n.dryRun = result
+	}
+}
+
+// CreateOptionDryRun function returns an option setter which turns the
+// endpoint creation into a dry run: the options are validated and result is
+// filled with the addresses and rules the driver would program, but neither
+// the host nor the store is changed, and CreateEndpoint returns no endpoint.
+func CreateOptionDryRun(result *DryRunResult) EndpointOption {
+	return func(ep *endpoint) {
+		ep.dryRun = result
+	}
+}
+
+func (c *controller) planNetwork(n *network) error {
+	c.Lock()
+	dd, ok := c.drivers[n.networkType]
+	c.Unlock()
+
+	if !ok {
+		var err error
+		if dd, err = c.loadDriver(n.networkType); err != nil {
+			return err
+		}
+	}
+
+	plan, err := planner(n.networkType, dd.driver)
+	if err != nil {
+		return err
+	}
+	p, err := plan.PlanNetwork(n.id, n.generic)
+	if err != nil {
+		return err
+	}
+
+	n.dryRun.Driver = n.networkType
+	n.dryRun.Plan = p
+	return nil
+}
+
+func (n *network) planEndpoint(ep *endpoint) error {
+	n.Lock()
+	d := n.driver
+	networkType := n.networkType
+	materialized := n.materialized
+	n.Unlock()
+
+	// The driver only knows the networks it created
+	if !materialized {
+		return types.ForbiddenErrorf("network %s is not created on this host yet, endpoint creation cannot be dry run", n.Name())
+	}
+
+	plan, err := planner(networkType, d)
+	if err != nil {
+		return err
+	}
+	p, err := plan.PlanEndpoint(n.id, ep.id, ep.generic)
+	if err != nil {
+		return err
+	}
+
+	ep.dryRun.Driver = networkType
+	ep.dryRun.Plan = p
+	return nil
+}
+
+func planner(networkType string, d driverapi.Driver) (driverapi.Planner, error) {
+	p, ok := d.(driverapi.Planner)
+	if !ok {
+		return nil, types.NotImplementedErrorf("driver %s does not support dry runs", networkType)
+	}
+	return p, nil
+}
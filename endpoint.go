@@ -2,9 +2,11 @@ package libnetwork
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -12,7 +14,9 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/libnetwork/capture"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/etchosts"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/resolvconf"
@@ -33,12 +37,17 @@ type Endpoint interface {
 
 	// Join creates a new sandbox for the given container ID and populates the
 	// network resources allocated for the endpoint and joins the sandbox to
-	// the endpoint.
-	Join(containerID string, options ...EndpointOption) error
+	// the endpoint. The join is abandoned once ctx is done.
+	Join(ctx context.Context, containerID string, options ...EndpointOption) error
 
 	// Leave removes the sandbox associated with  container ID and detaches
 	// the network resources populated in the sandbox
-	Leave(containerID string, options ...EndpointOption) error
+	Leave(ctx context.Context, containerID string, options ...EndpointOption) error
+
+	// Migrate joins the container to the endpoint on this host, taking over
+	// the endpoint it is joined to on another host, with the same addresses.
+	// The endpoint is then left on the other host with LeaveOptionMigrated.
+	Migrate(ctx context.Context, containerID string, options ...EndpointOption) error
 
 	// Return certain operational data belonging to this endpoint
 	Info() EndpointInfo
@@ -49,8 +58,39 @@ type Endpoint interface {
 	// ContainerInfo returns the info available at the endpoint about the attached container
 	ContainerInfo() ContainerInfo
 
-	// Delete and detaches this endpoint from the network.
-	Delete() error
+	// ChangeAddress moves the endpoint to the passed IPv4 address, or to a
+	// newly allocated one if ip is nil, without recreating it. The service
+	// records and, if a container has joined, its interface and hosts file
+	// follow the new address.
+	ChangeAddress(ctx context.Context, ip net.IP) error
+
+	// StartCapture starts a bounded capture of the packets sent and received
+	// by the endpoint. The packets are read in pcap format from the returned
+	// capture until it is stopped or its duration expires.
+	StartCapture(ctx context.Context, opts capture.Options) (*capture.Capture, error)
+
+	// Announce sends a gratuitous ARP and an unsolicited neighbor
+	// advertisement for the addresses of the endpoint from the sandbox at
+	// sandboxKey, or from the sandbox of its container if the key is empty.
+	// The daemon calls it for the containers it restores after a restart,
+	// whose sandboxes are not joined again.
+	Announce(ctx context.Context, sandboxKey string) error
+
+	// ActivatePorts binds the published ports of an endpoint created with
+	// CreateOptionDeferPortBinding ahead of the join, which binds them
+	// otherwise. The ports stay bound until a container leaves it.
+	ActivatePorts(ctx context.Context) error
+
+	// Activate adds the joined endpoint, created with the RegisterOnActivate
+	// service registration, to the service records of its network, once its
+	// container is ready for traffic. Deactivate removes it again, as does
+	// the leave of the container.
+	Activate(ctx context.Context) error
+	Deactivate(ctx context.Context) error
+
+	// Delete and detaches this endpoint from the network. The containers
+	// must have left the endpoint first, unless DeleteOptionForce is passed.
+	Delete(ctx context.Context, options ...DeleteOption) error
 }
 
 // EndpointOption is a option setter function type used to pass varios options to Network
@@ -58,9 +98,20 @@ type Endpoint interface {
 // provided by libnetwork, they look like <Create|Join|Leave>Option[...](...)
 type EndpointOption func(ep *endpoint)
 
+// DeleteOption is an option setter function type used to pass options to
+// the endpoint Delete method. The options only apply to the call they are
+// passed to.
+type DeleteOption func(do *deleteOptions)
+
+type deleteOptions struct {
+	force bool
+}
+
 // ContainerData is a set of data returned when a container joins an endpoint.
 type ContainerData struct {
 	SandboxKey string
+	// FwMark marks the traffic of the endpoint in the namespace of a pod
+	FwMark uint32
 }
 
 // These are the container configs used to customize container /etc/hosts file.
@@ -77,6 +128,7 @@ type resolvConfPathConfig struct {
 	resolvConfPath string
 	dnsList        []string
 	dnsSearchList  []string
+	dnsOptionsList []string
 }
 
 type containerConfig struct {
@@ -84,6 +136,11 @@ type containerConfig struct {
 	resolvConfPathConfig
 	generic           map[string]interface{}
 	useDefaultSandBox bool
+	sandboxKey        string
+	ifName            string
+	pod               string
+	socketReceiver    string
+	migrated          bool
 	prio              int // higher the value, more the priority
 }
 
@@ -125,6 +182,24 @@ type endpoint struct {
 	joinLeaveDone chan struct{}
 	dbIndex       uint64
 	dbExists      bool
+	// dryRun is set when the creation is only validated
+	dryRun *DryRunResult
+	// quotaAddresses is the number of addresses accounted to the tenant
+	// of the network
+	quotaAddresses int
+	// portsActive is set while the deferred ports of the endpoint are bound
+	portsActive bool
+	// serviceActive is set while the endpoint registered on activation is
+	// activated
+	serviceActive bool
+	// external is set on the interfaces the driver adopted on the network,
+	// which are recorded but never joined nor deleted
+	external bool
+	// generation is increased at every write of the endpoint to the store,
+	// by writer, so that a daemon loading an older generation than the one
+	// it wrote can tell that another daemon writes the endpoint as well
+	generation uint64
+	writer     string
 	sync.Mutex
 }
 
@@ -161,6 +236,19 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	if ep.container != nil {
 		epMap["container"] = ep.container
 	}
+	if ep.quotaAddresses != 0 {
+		epMap["quota_addresses"] = ep.quotaAddresses
+	}
+	if ep.serviceActive {
+		epMap["service_active"] = true
+	}
+	if ep.external {
+		epMap["external"] = true
+	}
+	if ep.generation != 0 {
+		epMap["generation"] = ep.generation
+		epMap["writer"] = ep.writer
+	}
 	return json.Marshal(epMap)
 }
 
@@ -199,6 +287,15 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 	if epMap["generic"] != nil {
 		ep.generic = epMap["generic"].(map[string]interface{})
 	}
+	if v, ok := epMap["quota_addresses"]; ok {
+		ep.quotaAddresses = int(v.(float64))
+	}
+	ep.serviceActive, _ = epMap["service_active"].(bool)
+	ep.external, _ = epMap["external"].(bool)
+	if v, ok := epMap["generation"]; ok {
+		ep.generation = uint64(v.(float64))
+		ep.writer, _ = epMap["writer"].(string)
+	}
 	return nil
 }
 
@@ -226,29 +323,30 @@ func (ep *endpoint) Network() string {
 }
 
 // endpoint Key structure : endpoint/network-id/endpoint-id
-func (ep *endpoint) Key() []string {
+func (ep *endpoint) Key() datastore.KeyPath {
 	ep.Lock()
 	n := ep.network
 	defer ep.Unlock()
-	return []string{datastore.EndpointKeyPrefix, string(n.id), string(ep.id)}
+	return datastore.NewKeyPath(datastore.EndpointKeyPrefix, string(n.id), string(ep.id))
 }
 
-func (ep *endpoint) KeyPrefix() []string {
+func (ep *endpoint) KeyPrefix() datastore.KeyPath {
 	ep.Lock()
 	n := ep.network
 	defer ep.Unlock()
-	return []string{datastore.EndpointKeyPrefix, string(n.id)}
+	return datastore.NewKeyPath(datastore.EndpointKeyPrefix, string(n.id))
 }
 
-func (ep *endpoint) networkIDFromKey(key []string) (types.UUID, error) {
+func (ep *endpoint) networkIDFromKey(key datastore.KeyPath) (types.UUID, error) {
 	// endpoint Key structure : endpoint/network-id/endpoint-id
 	// its an invalid key if the key doesnt have all the 3 key elements above
-	if key == nil || len(key) < 3 || key[0] != datastore.EndpointKeyPrefix {
+	ids, err := key.IDs()
+	if err != nil || len(ids) < 2 || key.Prefix() != datastore.EndpointKeyPrefix {
 		return types.UUID(""), fmt.Errorf("invalid endpoint key : %v", key)
 	}
 
-	// network-id is placed at index=1. pls refer to endpoint.Key() method
-	return types.UUID(key[1]), nil
+	// network-id is the first id. pls refer to endpoint.Key() method
+	return types.UUID(ids[0]), nil
 }
 
 func (ep *endpoint) Value() []byte {
@@ -351,20 +449,70 @@ func (ep *endpoint) joinLeaveEnd() {
 	}
 }
 
-func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
+func (ep *endpoint) Join(ctx context.Context, containerID string, options ...EndpointOption) error {
+	c := ep.controller()
+	ev := ep.hookEvent(HookPreJoin, containerID)
+	err := ep.authorize(ctx, AuditEndpointJoin, map[string]string{"container": containerID})
+	if err == nil {
+		err = c.runPreHooks(ctx, ev)
+	}
+	if err == nil {
+		err = ep.join(ctx, containerID, false, options...)
+	}
+	ep.audit(ctx, AuditEndpointJoin, map[string]string{"container": containerID}, err)
+	ev.Point = HookPostJoin
+	c.runPostHooks(ctx, ev, err)
+	return err
+}
+
+// Migrate runs the join hooks, a migrated endpoint joining the container on
+// this host
+func (ep *endpoint) Migrate(ctx context.Context, containerID string, options ...EndpointOption) error {
+	c := ep.controller()
+	ev := ep.hookEvent(HookPreJoin, containerID)
+	err := ep.authorize(ctx, AuditEndpointMigrate, map[string]string{"container": containerID})
+	if err == nil {
+		err = c.runPreHooks(ctx, ev)
+	}
+	if err == nil {
+		err = ep.join(ctx, containerID, true, options...)
+	}
+	ep.audit(ctx, AuditEndpointMigrate, map[string]string{"container": containerID}, err)
+	ev.Point = HookPostJoin
+	c.runPostHooks(ctx, ev, err)
+	return err
+}
+
+func (ep *endpoint) join(ctx context.Context, containerID string, migrate bool, options ...EndpointOption) error {
 	var err error
 
 	if containerID == "" {
 		return InvalidContainerIDError(containerID)
 	}
 
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
+	if ep.isExternal() {
+		return types.ForbiddenErrorf("endpoint %s is external to the driver and cannot be joined", ep.Name())
+	}
+
 	ep.joinLeaveStart()
 	defer func() {
 		ep.joinLeaveEnd()
 	}()
 
 	ep.Lock()
-	if ep.container != nil {
+	// An endpoint is migrated with the container it is joined to on
+	// another host, which has no sandbox on this one
+	prev := ep.container
+	if migrate {
+		if prev == nil || prev.id != containerID || prev.data.SandboxKey != "" {
+			ep.Unlock()
+			return types.ForbiddenErrorf("endpoint %s is not joined to container %s on another host", ep.name, containerID)
+		}
+	} else if prev != nil {
 		ep.Unlock()
 		return ErrInvalidJoin{}
 	}
@@ -388,7 +536,7 @@ func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
 	defer func() {
 		if err != nil {
 			ep.Lock()
-			ep.container = nil
+			ep.container = prev
 			ep.Unlock()
 		}
 	}()
@@ -397,6 +545,7 @@ func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
 	driver := network.driver
 	nid := network.id
 	ctrlr := network.ctrlr
+	networkType := network.networkType
 	network.Unlock()
 
 	ep.processOptions(options...)
@@ -404,20 +553,85 @@ func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
 	sboxKey := sandbox.GenerateKey(containerID)
 	if container.config.useDefaultSandBox {
 		sboxKey = sandbox.GenerateKey("default")
+	} else if container.config.sandboxKey != "" {
+		sboxKey = container.config.sandboxKey
+	} else if container.config.pod != "" {
+		sboxKey = podSandboxKey(container.config.pod)
+	}
+
+	join, leave := driver.Join, driver.Leave
+	if migrate {
+		m, ok := driver.(driverapi.Migrator)
+		if !ok {
+			err = types.NotImplementedErrorf("%s driver does not support migrating endpoints", driver.Type())
+			return err
+		}
+		// The other hosts were pointed to this one, the endpoint is not
+		// withdrawn from them on rollback
+		join, leave = m.MigrateEndpoint, m.ReleaseEndpoint
+	}
+
+	// The addresses the driver reports on join are given back, with their
+	// quota, if the join fails
+	if ctrlr.driverAssignsAddress(networkType) {
+		defer func() {
+			if err != nil {
+				ep.releaseDriverAssignedAddresses()
+				if e := ep.chargeQuota(context.Background()); e != nil {
+					log.Warnf("failed to give back the quota of the addresses of endpoint %s: %v", ep.Name(), e)
+				}
+			}
+		}()
 	}
 
-	err = driver.Join(nid, epid, sboxKey, ep, container.config.generic)
+	err = join(ctx, nid, epid, sboxKey, ep, container.config.generic)
+	// The sockets handed over by the driver are only held for the join
+	defer ep.closeBoundSockets()
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
-			if err = driver.Leave(nid, epid); err != nil {
+			if err = leave(context.Background(), nid, epid); err != nil {
 				log.Warnf("driver leave failed while rolling back join: %v", err)
 			}
 		}
 	}()
 
+	if err = ep.activatePorts(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ep.deactivatePorts()
+		}
+	}()
+
+	// The addresses the driver reported on join are accounted and
+	// published now
+	if ctrlr.driverAssignsAddress(networkType) {
+		if err = ep.chargeQuota(ctx); err != nil {
+			return err
+		}
+		network.updateSvcRecord(ep, true)
+		defer func() {
+			if err != nil {
+				network.updateSvcRecord(ep, false)
+			}
+		}()
+	}
+
+	// The endpoint registered on join is published now, unless it was
+	// already published by the host it migrated from
+	if !migrate && !ctrlr.driverAssignsAddress(networkType) && ep.serviceRegistration() == RegisterOnJoin {
+		network.updateSvcRecord(ep, true)
+		defer func() {
+			if err != nil {
+				network.updateSvcRecord(ep, false)
+			}
+		}()
+	}
+
 	err = ep.buildHostsFiles()
 	if err != nil {
 		return err
@@ -428,7 +642,7 @@ func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
 		return err
 	}
 
-	err = ep.setupDNS()
+	err = ep.setupDNS(sboxKey)
 	if err != nil {
 		return err
 	}
@@ -443,14 +657,44 @@ func (ep *endpoint) Join(containerID string, options ...EndpointOption) error {
 		}
 	}()
 
-	if err := network.ctrlr.updateEndpointToStore(ep); err != nil {
+	if err = ep.passBoundSockets(container.config.socketReceiver); err != nil {
+		return err
+	}
+
+	// The neighbors and the switches may hold a stale entry for the
+	// addresses, of a previous container or of the host it migrated from
+	if e := ctrlr.announceEndpoint(sboxKey, ep); e != nil {
+		log.Warnf("failed to announce endpoint %s: %v", ep.Name(), e)
+	}
+
+	if err := network.ctrlr.updateEndpointToStore(ctx, ep); err != nil {
 		return err
 	}
 
+	ep.Lock()
 	container.data.SandboxKey = sb.Key()
+	ep.Unlock()
+	ctrlr.saveSandboxState(sboxKey)
 	return nil
 }
 
+func (ep *endpoint) Announce(ctx context.Context, sandboxKey string) error {
+	ep.Lock()
+	name := ep.name
+	if sandboxKey == "" && ep.container != nil {
+		sandboxKey = ep.container.data.SandboxKey
+	}
+	ep.Unlock()
+
+	if sandboxKey == "" {
+		return types.ForbiddenErrorf("endpoint %s is not joined to a sandbox on this host", name)
+	}
+	if err := ep.authorize(ctx, AuthzEndpointAnnounce, nil); err != nil {
+		return err
+	}
+	return ep.controller().announceEndpoint(sandboxKey, ep)
+}
+
 func (ep *endpoint) hasInterface(iName string) bool {
 	ep.Lock()
 	defer ep.Unlock()
@@ -464,9 +708,29 @@ func (ep *endpoint) hasInterface(iName string) bool {
 	return false
 }
 
-func (ep *endpoint) Leave(containerID string, options ...EndpointOption) error {
+func (ep *endpoint) Leave(ctx context.Context, containerID string, options ...EndpointOption) error {
+	c := ep.controller()
+	ev := ep.hookEvent(HookPreLeave, containerID)
+	err := ep.authorize(ctx, AuditEndpointLeave, map[string]string{"container": containerID})
+	if err == nil {
+		err = c.runPreHooks(ctx, ev)
+	}
+	if err == nil {
+		err = ep.leave(ctx, containerID, options...)
+	}
+	ep.audit(ctx, AuditEndpointLeave, map[string]string{"container": containerID}, err)
+	ev.Point = HookPostLeave
+	c.runPostHooks(ctx, ev, err)
+	return err
+}
+
+func (ep *endpoint) leave(ctx context.Context, containerID string, options ...EndpointOption) error {
 	var err error
 
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
 	ep.joinLeaveStart()
 	defer ep.joinLeaveEnd()
 
@@ -487,30 +751,264 @@ func (ep *endpoint) Leave(containerID string, options ...EndpointOption) error {
 		ep.Unlock()
 		return err
 	}
-	ep.container = nil
+	migrated := container.config.migrated
+	container.config.migrated = false
 	ep.Unlock()
 
 	n.Lock()
 	driver := n.driver
 	ctrlr := n.ctrlr
+	networkType := n.networkType
 	n.Unlock()
 
-	if err := ctrlr.updateEndpointToStore(ep); err != nil {
+	if migrated {
+		m, ok := driver.(driverapi.Migrator)
+		if !ok {
+			return types.NotImplementedErrorf("%s driver does not support migrating endpoints", driver.Type())
+		}
+
+		// The store already records the container joined on the host the
+		// endpoint migrated to
+		ep.Lock()
+		ep.container = &containerInfo{id: container.id}
+		ep.Unlock()
+
+		err = m.ReleaseEndpoint(ctx, n.id, ep.id)
+		ctrlr.sandboxRm(container.data.SandboxKey, ep)
+		ep.deactivatePorts()
+		return err
+	}
+
+	ep.Lock()
+	ep.container = nil
+	ep.Unlock()
+	ep.unregisterOnLeave()
+
+	if err := ctrlr.updateEndpointToStore(ctx, ep); err != nil {
 		ep.Lock()
 		ep.container = container
 		ep.Unlock()
 		return err
 	}
 
-	err = driver.Leave(n.id, ep.id)
+	err = driver.Leave(ctx, n.id, ep.id)
 
 	ctrlr.sandboxRm(container.data.SandboxKey, ep)
+	ctrlr.rewriteSandboxDNS(container.data.SandboxKey, container.id)
+	ep.deactivatePorts()
+
+	// The addresses the driver reported on join go with the sandbox
+	if ctrlr.driverAssignsAddress(networkType) {
+		n.updateSvcRecord(ep, false)
+		ep.releaseDriverAssignedAddresses()
+		if e := ep.chargeQuota(ctx); e != nil {
+			log.Warnf("failed to give back the quota of the addresses of endpoint %s: %v", ep.Name(), e)
+		}
+		if e := ctrlr.updateEndpointToStore(ctx, ep); e != nil {
+			log.Warnf("failed to update endpoint %s in the store after releasing its addresses: %v", ep.Name(), e)
+		}
+	}
+
+	return err
+}
+
+func (ep *endpoint) ChangeAddress(ctx context.Context, ip net.IP) error {
+	params := map[string]string{"address": ip.String()}
+	err := ep.authorize(ctx, AuditEndpointChange, params)
+	if err == nil {
+		err = ep.changeAddress(ip)
+	}
+	ep.audit(ctx, AuditEndpointChange, params, err)
+	return err
+}
+
+func (ep *endpoint) changeAddress(ip net.IP) error {
+	var err error
+
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
+	ep.joinLeaveStart()
+	defer ep.joinLeaveEnd()
+
+	ep.Lock()
+	name := ep.name
+	epid := ep.id
+	n := ep.network
+	container := ep.container
+	if len(ep.iFaces) == 0 {
+		ep.Unlock()
+		return types.ForbiddenErrorf("endpoint %s has no interface to change the address of", name)
+	}
+	oldAddr := ep.iFaces[0].addr
+	ep.Unlock()
+
+	n.Lock()
+	driver := n.driver
+	nid := n.id
+	ctrlr := n.ctrlr
+	n.Unlock()
+
+	ac, ok := driver.(driverapi.AddressChanger)
+	if !ok {
+		return types.NotImplementedErrorf("%s driver does not support changing endpoint addresses", driver.Type())
+	}
+
+	// The service records are removed with the old address and published
+	// again with whichever address the endpoint ends up with.
+	n.updateSvcRecord(ep, false)
+	defer n.updateSvcRecord(ep, true)
+
+	newAddr, err := ac.ChangeEndpointAddress(nid, epid, ip)
+	if err != nil {
+		return err
+	}
+
+	ep.Lock()
+	ep.iFaces[0].addr = *newAddr
+	ep.Unlock()
+
+	defer func() {
+		if err != nil {
+			if _, e := ac.ChangeEndpointAddress(nid, epid, oldAddr.IP); e != nil {
+				log.Warnf("failed to restore address %s of endpoint %s: %v", oldAddr.IP, name, e)
+			}
+			ep.Lock()
+			ep.iFaces[0].addr = oldAddr
+			ep.Unlock()
+		}
+	}()
+
+	if container != nil {
+		if err = ctrlr.sandboxUpdateAddress(container.data.SandboxKey, ep, newAddr); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if e := ctrlr.sandboxUpdateAddress(container.data.SandboxKey, ep, &oldAddr); e != nil {
+					log.Warnf("failed to restore sandbox address of endpoint %s: %v", name, e)
+				}
+			}
+		}()
+
+		if container.config.hostName != "" {
+			if e := etchosts.Update(container.config.hostsPath, newAddr.IP.String(), container.config.hostName); e != nil {
+				log.Warnf("failed to update hosts file of container %s: %v", container.id, e)
+			}
+		}
+	}
+
+	if err = ctrlr.updateEndpointToStore(context.Background(), ep); err != nil {
+		return err
+	}
+
+	// The ports now lead to the new address
+	ep.unpublishExternal(context.Background(), ctrlr)
+	if e := ep.publishExternal(context.Background(), ctrlr); e != nil {
+		log.Warnf("failed to publish the ports of endpoint %s at its new address: %v", name, e)
+	}
+
+	return nil
+}
+
+func (ep *endpoint) StartCapture(ctx context.Context, opts capture.Options) (*capture.Capture, error) {
+	ep.Lock()
+	n := ep.network
+	id := ep.id
+	ep.Unlock()
+
+	n.Lock()
+	d := n.driver
+	nid := n.id
+	n.Unlock()
+
+	pc, ok := d.(driverapi.PacketCapturer)
+	if !ok {
+		return nil, types.NotImplementedErrorf("%s driver does not support packet capture", d.Type())
+	}
+	// The traffic of the endpoint is exposed to the caller
+	if err := ep.authorize(ctx, AuthzEndpointCapture, nil); err != nil {
+		return nil, err
+	}
+
+	return pc.StartCapture(nid, id, opts)
+}
+
+// setExternalConnectivity asks the driver to remove or restore the external
+// access of the endpoint, and the port publisher to withdraw or publish its
+// ports again. Drivers which do not provide any are skipped.
+func (ep *endpoint) setExternalConnectivity(enable bool) error {
+	ep.Lock()
+	n := ep.network
+	id := ep.id
+	ep.Unlock()
+
+	n.Lock()
+	d := n.driver
+	nid := n.id
+	ctrlr := n.ctrlr
+	n.Unlock()
+
+	ecs, ok := d.(driverapi.ExternalConnectivitySetter)
+	if ok {
+		if err := ecs.SetExternalConnectivity(nid, id, enable); err != nil {
+			return err
+		}
+	}
+
+	if !enable {
+		ep.unpublishExternal(context.Background(), ctrlr)
+		return nil
+	}
+	if err := ep.publishExternal(context.Background(), ctrlr); err != nil {
+		if ok {
+			if e := ecs.SetExternalConnectivity(nid, id, false); e != nil {
+				log.Warnf("failed to withdraw the external connectivity of endpoint %s: %v", ep.Name(), e)
+			}
+		}
+		return err
+	}
+	return nil
+}
 
+func (ep *endpoint) Delete(ctx context.Context, options ...DeleteOption) error {
+	c := ep.controller()
+	ev := ep.hookEvent(HookPreDeleteEndpoint, "")
+	err := ep.authorize(ctx, AuditEndpointDelete, nil)
+	if err == nil {
+		err = c.runPreHooks(ctx, ev)
+	}
+	if err == nil {
+		err = ep.delete(ctx, options...)
+	}
+	ep.audit(ctx, AuditEndpointDelete, nil, err)
+	ev.Point = HookPostDeleteEndpoint
+	c.runPostHooks(ctx, ev, err)
 	return err
 }
 
-func (ep *endpoint) Delete() error {
+func (ep *endpoint) delete(ctx context.Context, options ...DeleteOption) error {
 	var err error
+
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
+	if ep.isExternal() {
+		return types.ForbiddenErrorf("endpoint %s is external to the driver and is deleted along with its network", ep.Name())
+	}
+
+	var do deleteOptions
+	for _, opt := range options {
+		if opt != nil {
+			opt(&do)
+		}
+	}
+	if do.force {
+		return ep.forceDelete()
+	}
+
 	ep.Lock()
 	epid := ep.id
 	name := ep.name
@@ -524,13 +1022,14 @@ func (ep *endpoint) Delete() error {
 	n.Unlock()
 	ep.Unlock()
 
-	if err = ctrlr.deleteEndpointFromStore(ep); err != nil {
+	// The cleanups must not be abandoned with the request
+	if err = ctrlr.deleteEndpointFromStore(ctx, ep); err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
 			ep.SetIndex(0)
-			if e := ctrlr.updateEndpointToStore(ep); e != nil {
+			if e := ctrlr.updateEndpointToStore(context.Background(), ep); e != nil {
 				log.Warnf("failed to recreate endpoint in store %s : %v", name, err)
 			}
 		}
@@ -538,26 +1037,27 @@ func (ep *endpoint) Delete() error {
 
 	// Update the endpoint count in network and update it in the datastore
 	n.DecEndpointCnt()
-	if err = ctrlr.updateNetworkToStore(n); err != nil {
+	if err = ctrlr.updateNetworkToStore(ctx, n); err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
 			n.IncEndpointCnt()
-			if e := ctrlr.updateNetworkToStore(n); e != nil {
+			if e := ctrlr.updateNetworkToStore(context.Background(), n); e != nil {
 				log.Warnf("failed to update network %s : %v", n.name, e)
 			}
 		}
 	}()
 
-	if err = ep.deleteEndpoint(); err != nil {
+	if err = ep.deleteEndpoint(ctx); err != nil {
 		return err
 	}
 
+	ep.releaseQuota()
 	return nil
 }
 
-func (ep *endpoint) deleteEndpoint() error {
+func (ep *endpoint) deleteEndpoint(ctx context.Context) error {
 	ep.Lock()
 	n := ep.network
 	name := ep.name
@@ -573,10 +1073,17 @@ func (ep *endpoint) deleteEndpoint() error {
 
 	nid := n.id
 	driver := n.driver
+	ctrlr := n.ctrlr
 	delete(n.endpoints, epid)
 	n.Unlock()
 
-	if err := driver.DeleteEndpoint(nid, epid); err != nil {
+	// A read-only controller never created the endpoint in the driver
+	if ctrlr.isReadOnly() {
+		n.updateSvcRecord(ep, false)
+		return nil
+	}
+
+	if err := driver.DeleteEndpoint(ctx, nid, epid); err != nil {
 		if _, ok := err.(types.ForbiddenError); ok {
 			n.Lock()
 			n.endpoints[epid] = ep
@@ -586,10 +1093,31 @@ func (ep *endpoint) deleteEndpoint() error {
 		log.Warnf("driver error deleting endpoint %s : %v", name, err)
 	}
 
+	ctrlr.ports.release(epid)
+	ep.unpublishExternal(ctx, ctrlr)
 	n.updateSvcRecord(ep, false)
 	return nil
 }
 
+// isReadOnly tells whether the endpoint is managed by a read-only controller
+// isExternal tells whether the endpoint was adopted by the driver rather
+// than created through the controller
+func (ep *endpoint) isExternal() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.external
+}
+
+func (ep *endpoint) isReadOnly() bool {
+	ep.Lock()
+	n := ep.network
+	ep.Unlock()
+
+	n.Lock()
+	defer n.Unlock()
+	return n.ctrlr.isReadOnly()
+}
+
 func (ep *endpoint) addHostEntries(recs []etchosts.Record) {
 	ep.Lock()
 	container := ep.container
@@ -618,6 +1146,20 @@ func (ep *endpoint) deleteHostEntries(recs []etchosts.Record) {
 	}
 }
 
+func (ep *endpoint) deleteHostRecords(recs []etchosts.Record) {
+	ep.Lock()
+	container := ep.container
+	ep.Unlock()
+
+	if container == nil {
+		return
+	}
+
+	if err := etchosts.DeleteRecords(container.config.hostsPath, recs); err != nil {
+		log.Warnf("Failed deleting service host records from the running container: %v", err)
+	}
+}
+
 func (ep *endpoint) buildHostsFiles() error {
 	var extraContent []etchosts.Record
 
@@ -663,6 +1205,11 @@ func (ep *endpoint) buildHostsFiles() error {
 	IP := ""
 	if len(ifaces) != 0 && ifaces[0] != nil {
 		IP = ifaces[0].addr.IP.String()
+		// The hostname resolves to the secondary addresses as well
+		for _, addr := range ifaces[0].secondary {
+			extraContent = append(extraContent,
+				etchosts.Record{Hosts: container.config.hostName, IP: addr.IP.String()})
+		}
 	}
 
 	return etchosts.Build(container.config.hostsPath, IP, container.config.hostName,
@@ -781,7 +1328,7 @@ func (ep *endpoint) updateDNS(resolvConf []byte) error {
 	return os.Rename(tmpResolvFile.Name(), container.config.resolvConfPath)
 }
 
-func (ep *endpoint) setupDNS() error {
+func (ep *endpoint) setupDNS(sboxKey string) error {
 	ep.Lock()
 	container := ep.container
 	ep.Unlock()
@@ -805,27 +1352,105 @@ func (ep *endpoint) setupDNS() error {
 		return err
 	}
 
+	return ep.writeDNS(sboxKey, resolvConf, false)
+}
+
+// writeDNS writes the resolv.conf of the container from the contents of the
+// host one. On a reload, a file the user changed since it was written is
+// left alone.
+func (ep *endpoint) writeDNS(sboxKey string, resolvConf []byte, reload bool) error {
+	ep.Lock()
+	container := ep.container
+	ep.Unlock()
+
+	if container == nil {
+		return ErrNoContainer{}
+	}
+
+	// The search domains and options of the container are merged with the
+	// ones of all the networks it is attached to in the sandbox
+	netSearch, netOptions := ep.sandboxNetworkDNS(sboxKey)
+	dnsSearch := mergeDNSSearch(container.config.dnsSearchList, netSearch)
+	dnsOptions := mergeDNSOptions(container.config.dnsOptionsList, netOptions)
+
 	if len(container.config.dnsList) > 0 ||
-		len(container.config.dnsSearchList) > 0 {
+		len(dnsSearch) > 0 || len(dnsOptions) > 0 {
 		var (
 			dnsList       = resolvconf.GetNameservers(resolvConf)
 			dnsSearchList = resolvconf.GetSearchDomains(resolvConf)
+			dnsOptionList = resolvconf.GetOptions(resolvConf)
 		)
 
 		if len(container.config.dnsList) > 0 {
 			dnsList = container.config.dnsList
 		}
 
-		if len(container.config.dnsSearchList) > 0 {
-			dnsSearchList = container.config.dnsSearchList
+		if len(dnsSearch) > 0 {
+			dnsSearchList = dnsSearch
+		}
+
+		if len(dnsOptions) > 0 {
+			dnsOptionList = dnsOptions
 		}
 
-		return resolvconf.Build(container.config.resolvConfPath, dnsList, dnsSearchList)
+		if reload {
+			if modified, err := resolvConfModified(container.config.resolvConfPath); err != nil || modified {
+				return err
+			}
+		}
+
+		if err := resolvconf.BuildWithOptions(container.config.resolvConfPath, dnsList, dnsSearchList, dnsOptionList); err != nil {
+			return err
+		}
+		return writeResolvConfHash(container.config.resolvConfPath)
 	}
 
 	return ep.updateDNS(resolvConf)
 }
 
+// resolvConfModified tells whether the resolv.conf at path was changed since
+// its hash was recorded. A file without a recorded hash is deemed changed.
+func resolvConfModified(path string) (bool, error) {
+	resolvBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	oldHash, err := ioutil.ReadFile(path + ".hash")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	curHash, err := ioutils.HashData(bytes.NewReader(resolvBytes))
+	if err != nil {
+		return false, err
+	}
+
+	return curHash != string(oldHash), nil
+}
+
+// writeResolvConfHash records the hash of the resolv.conf at path, so that
+// the changes made by the user can be told apart
+func writeResolvConfHash(path string) error {
+	resolvBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	hash, err := ioutils.HashData(bytes.NewReader(resolvBytes))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path+".hash", []byte(hash), 0644)
+}
+
 // EndpointOptionGeneric function returns an option setter for a Generic option defined
 // in a Dictionary of Key-Value pair
 func EndpointOptionGeneric(generic map[string]interface{}) EndpointOption {
@@ -908,6 +1533,14 @@ func JoinOptionDNSSearch(search string) EndpointOption {
 	}
 }
 
+// JoinOptionDNSOptions function returns an option setter for dns resolver option
+// entry, like "ndots:2", to be passed to endpoint Join method.
+func JoinOptionDNSOptions(option string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.container.config.dnsOptionsList = append(ep.container.config.dnsOptionsList, option)
+	}
+}
+
 // JoinOptionUseDefaultSandbox function returns an option setter for using default sandbox to
 // be passed to endpoint Join method.
 func JoinOptionUseDefaultSandbox() EndpointOption {
@@ -916,6 +1549,67 @@ func JoinOptionUseDefaultSandbox() EndpointOption {
 	}
 }
 
+// JoinOptionSandboxKey function returns an option setter for joining the
+// endpoint to an existing network namespace mounted at key, which is owned by
+// the caller, instead of one created by libnetwork. To be passed to endpoint
+// Join method.
+func JoinOptionSandboxKey(key string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.container.config.sandboxKey = key
+	}
+}
+
+// JoinOptionInterfaceName function returns an option setter for naming the
+// first interface of the endpoint in the sandbox, instead of the prefix the
+// driver chose followed by an index. To be passed to endpoint Join method.
+func JoinOptionInterfaceName(name string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.container.config.ifName = name
+	}
+}
+
+// JoinOptionPod function returns an option setter for joining the endpoint
+// to the network namespace shared by the containers of the pod id, created by
+// the first endpoint joining it and destroyed when the last one leaves. To be
+// passed to endpoint Join method.
+func JoinOptionPod(id string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.container.config.pod = id
+	}
+}
+
+// LeaveOptionMigrated function returns an option setter for leaving the
+// endpoint on the host it was migrated from with Migrate. The container is
+// detached from the local sandbox but stays joined on the other host. To be
+// passed to endpoint Leave method.
+func LeaveOptionMigrated() EndpointOption {
+	return func(ep *endpoint) {
+		if ep.container != nil {
+			ep.container.config.migrated = true
+		}
+	}
+}
+
+// DeleteOptionForce function returns an option setter for deleting the
+// endpoint even though a container has not left it or its driver fails to
+// delete it. Every cleanup is attempted, the ones which fail are reported
+// with an IncompleteDeleteError. To be passed to endpoint Delete method.
+func DeleteOptionForce() DeleteOption {
+	return func(do *deleteOptions) {
+		do.force = true
+	}
+}
+
+// JoinOptionSocketReceiver function returns an option setter for passing the
+// host sockets bound for the port bindings of the endpoint to the process
+// listening on the unix socket at path, instead of forwarding their traffic.
+// To be passed to endpoint Join method.
+func JoinOptionSocketReceiver(path string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.container.config.socketReceiver = path
+	}
+}
+
 // CreateOptionExposedPorts function returns an option setter for the container exposed
 // ports option to be passed to network.CreateEndpoint() method.
 func CreateOptionExposedPorts(exposedPorts []types.TransportPort) EndpointOption {
@@ -940,6 +1634,102 @@ func CreateOptionPortMapping(portBindings []types.PortBinding) EndpointOption {
 	}
 }
 
+// CreateOptionMirror function returns an option setter for the traffic
+// mirror option to be passed to network.CreateEndpoint() method.
+func CreateOptionMirror(mirror types.MirrorConfig) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.Mirror] = mirror.GetCopy()
+	}
+}
+
+// CreateOptionVethName function returns an option setter for the name of
+// the host side veth interface of the endpoint, to be passed to
+// network.CreateEndpoint() method.
+func CreateOptionVethName(name string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.VethName] = name
+	}
+}
+
+// CreateOptionMTU function returns an option setter for the MTU of the
+// endpoint interfaces, overriding the one of the network, to be passed to
+// network.CreateEndpoint() method.
+func CreateOptionMTU(mtu int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.MTU] = mtu
+	}
+}
+
+// CreateOptionSNATAddress function returns an option setter for the host
+// address the outbound traffic of the endpoint is translated to, picked from
+// the SNAT pool of the network, to be passed to network.CreateEndpoint() method.
+func CreateOptionSNATAddress(ip net.IP) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.SNATAddress] = ip
+	}
+}
+
+// CreateOptionSecondaryAddresses function returns an option setter for the
+// IPv4 and IPv6 addresses the endpoint gets besides its primary ones, to be
+// passed to network.CreateEndpoint() method.
+func CreateOptionSecondaryAddresses(ips ...net.IP) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.SecondaryAddresses] = ips
+	}
+}
+
+// CreateOptionConnectionLimits function returns an option setter for the
+// limits of concurrent connections and of new connections per second to each
+// published TCP port of the endpoint, zero being no limit, to be passed to
+// network.CreateEndpoint() method.
+func CreateOptionConnectionLimits(connections, synPerSecond int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.ConnectionLimit] = connections
+		ep.generic[netlabel.SYNRateLimit] = synPerSecond
+	}
+}
+
+// CreateOptionDSCP function returns an option setter for the DSCP value the
+// outbound traffic of the endpoint is marked with, overriding the one of the
+// network, to be passed to network.CreateEndpoint() method.
+func CreateOptionDSCP(dscp int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.EndpointDSCP] = dscp
+	}
+}
+
+// CreateOptionPerfProfile function returns an option setter for the
+// performance profile the endpoint interfaces are tuned for, "latency" or
+// "throughput", and the CPU mask their queues are steered to, empty for the
+// default of the profile, to be passed to network.CreateEndpoint() method.
+func CreateOptionPerfProfile(profile, cpus string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.PerfProfile] = profile
+		if cpus != "" {
+			ep.generic[netlabel.PerfCPUs] = cpus
+		}
+	}
+}
+
+// CreateOptionDeferPortBinding function returns an option setter for
+// binding the published ports of the endpoint only once a container joins
+// it, or once they are activated, rather than on creation, to be passed to
+// network.CreateEndpoint() method. The host ports are not reserved meanwhile.
+func CreateOptionDeferPortBinding() EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.DeferPortBinding] = true
+	}
+}
+
+// CreateOptionServiceRegistration function returns an option setter for
+// when the endpoint is added to the service records of its network, to be
+// passed to network.CreateEndpoint() method
+func CreateOptionServiceRegistration(r ServiceRegistration) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.ServiceRegistration] = string(r)
+	}
+}
+
 // JoinOptionGeneric function returns an option setter for Generic configuration
 // that is not managed by libNetwork but can be used by the Drivers during the call to
 // endpoint join method. Container Labels are a good example.
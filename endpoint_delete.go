@@ -0,0 +1,72 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+)
+
+// forceDelete deletes the endpoint whatever its state. The container which
+// has not left it is detached, and each cleanup is attempted even when the
+// previous ones failed: the failures are reported rather than rolled back.
+// Once the endpoint is unlinked from its network, the cleanups must not be
+// abandoned with the request, so they do not take its context.
+func (ep *endpoint) forceDelete() error {
+	var skipped []string
+
+	ep.joinLeaveStart()
+	defer ep.joinLeaveEnd()
+
+	ep.Lock()
+	name := ep.name
+	epid := ep.id
+	n := ep.network
+	container := ep.container
+	ep.container = nil
+	ep.Unlock()
+
+	n.Lock()
+	nid := n.id
+	driver := n.driver
+	ctrlr := n.ctrlr
+	networkType := n.networkType
+	_, created := n.endpoints[epid]
+	delete(n.endpoints, epid)
+	n.Unlock()
+
+	// A container joined on another host has no local sandbox
+	if container != nil && container.data.SandboxKey != "" {
+		if err := driver.Leave(context.Background(), nid, epid); err != nil {
+			skipped = append(skipped, fmt.Sprintf("leave of container %s: %v", container.id, err))
+		}
+		ctrlr.sandboxRm(container.data.SandboxKey, ep)
+		ep.deactivatePorts()
+		if ctrlr.driverAssignsAddress(networkType) {
+			ep.releaseDriverAssignedAddresses()
+		}
+	}
+
+	if err := ctrlr.deleteEndpointFromStore(context.Background(), ep); err != nil {
+		skipped = append(skipped, fmt.Sprintf("datastore key: %v", err))
+	}
+
+	n.DecEndpointCnt()
+	if err := ctrlr.updateNetworkToStore(context.Background(), n); err != nil {
+		skipped = append(skipped, fmt.Sprintf("endpoint count of network %s: %v", n.Name(), err))
+	}
+
+	if created {
+		if err := driver.DeleteEndpoint(context.Background(), nid, epid); err != nil {
+			skipped = append(skipped, fmt.Sprintf("driver deletion: %v", err))
+		}
+	}
+
+	ctrlr.ports.release(epid)
+	ep.unpublishExternal(context.Background(), ctrlr)
+	n.updateSvcRecord(ep, false)
+	ep.releaseQuota()
+
+	if len(skipped) != 0 {
+		return &IncompleteDeleteError{name: name, id: string(epid), Skipped: skipped}
+	}
+	return nil
+}
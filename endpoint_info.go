@@ -1,10 +1,14 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"net"
+	"os"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -26,6 +30,10 @@ type EndpointInfo interface {
 	// the endpoint. If there is no container joined then this will return an
 	// empty string.
 	SandboxKey() string
+
+	// FwMark returns the firewall mark of the traffic of the endpoint when
+	// it joined the sandbox of a pod, 0 otherwise.
+	FwMark() uint32
 }
 
 // InterfaceInfo provides an interface to retrieve interface addresses bound to the endpoint.
@@ -38,6 +46,10 @@ type InterfaceInfo interface {
 
 	// AddressIPv6 returns the IPv6 address assigned to the endpoint.
 	AddressIPv6() net.IPNet
+
+	// SecondaryAddresses returns the IPv4 and IPv6 addresses assigned to
+	// the endpoint besides the primary ones.
+	SecondaryAddresses() []net.IPNet
 }
 
 // ContainerInfo provides an interface to retrieve the info about the container attached to the endpoint
@@ -53,9 +65,13 @@ type endpointInterface struct {
 	mac       net.HardwareAddr
 	addr      net.IPNet
 	addrv6    net.IPNet
-	srcName   string
-	dstPrefix string
-	routes    []*net.IPNet
+	secondary []*net.IPNet
+	// driverAssigned is set when the addresses were reported by the
+	// driver on join, and are only held while the endpoint is joined
+	driverAssigned bool
+	srcName        string
+	dstPrefix      string
+	routes         []*net.IPNet
 }
 
 func (epi *endpointInterface) MarshalJSON() ([]byte, error) {
@@ -71,6 +87,16 @@ func (epi *endpointInterface) MarshalJSON() ([]byte, error) {
 		routes = append(routes, route.String())
 	}
 	epMap["routes"] = routes
+	if len(epi.secondary) != 0 {
+		var secondary []string
+		for _, addr := range epi.secondary {
+			secondary = append(secondary, addr.String())
+		}
+		epMap["secondary"] = secondary
+	}
+	if epi.driverAssigned {
+		epMap["driverAssigned"] = true
+	}
 	return json.Marshal(epMap)
 }
 
@@ -111,6 +137,23 @@ func (epi *endpointInterface) UnmarshalJSON(b []byte) (err error) {
 		}
 	}
 
+	if v, ok := epMap["driverAssigned"]; ok {
+		epi.driverAssigned = v.(bool)
+	}
+
+	if v, ok := epMap["secondary"]; ok {
+		sb, _ := json.Marshal(v)
+		var secondary []string
+		json.Unmarshal(sb, &secondary)
+		for _, s := range secondary {
+			addr, err := types.ParseCIDR(s)
+			if err != nil {
+				return types.InternalErrorf("failed to decode secondary address %s of interface %d: %v", s, epi.id, err)
+			}
+			epi.secondary = append(epi.secondary, addr)
+		}
+	}
+
 	return nil
 }
 
@@ -120,6 +163,7 @@ type endpointJoinInfo struct {
 	hostsPath      string
 	resolvConfPath string
 	StaticRoutes   []*types.StaticRoute
+	sockets        []boundSocket
 }
 
 func (ep *endpoint) ContainerInfo() ContainerInfo {
@@ -193,6 +237,19 @@ func (ep *endpoint) AddInterface(id int, mac net.HardwareAddr, ipv4 net.IPNet, i
 	return nil
 }
 
+func (ep *endpoint) AddSecondaryAddress(id int, addr net.IPNet) error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	for _, iface := range ep.iFaces {
+		if iface.id == id {
+			iface.secondary = append(iface.secondary, types.GetIPNetCopy(&addr))
+			return nil
+		}
+	}
+	return types.BadRequestErrorf("endpoint %s has no interface %d", ep.id, id)
+}
+
 func (epi *endpointInterface) ID() int {
 	return epi.id
 }
@@ -209,6 +266,28 @@ func (epi *endpointInterface) AddressIPv6() net.IPNet {
 	return (*types.GetIPNetCopy(&epi.addrv6))
 }
 
+func (epi *endpointInterface) SecondaryAddresses() []net.IPNet {
+	addrs := make([]net.IPNet, 0, len(epi.secondary))
+	for _, addr := range epi.secondary {
+		addrs = append(addrs, *types.GetIPNetCopy(addr))
+	}
+	return addrs
+}
+
+// recordAddresses returns the addresses the name of the endpoint resolves
+// to through the interface: its primary IPv4 address, then the secondary
+// ones
+func (epi *endpointInterface) recordAddresses() []net.IP {
+	var ips []net.IP
+	if len(epi.addr.IP) != 0 {
+		ips = append(ips, epi.addr.IP)
+	}
+	for _, addr := range epi.secondary {
+		ips = append(ips, addr.IP)
+	}
+	return ips
+}
+
 func (epi *endpointInterface) SetNames(srcName string, dstPrefix string) error {
 	epi.srcName = srcName
 	epi.dstPrefix = dstPrefix
@@ -246,6 +325,163 @@ func (ep *endpoint) AddStaticRoute(destination *net.IPNet, routeType int, nextHo
 	return nil
 }
 
+func (ep *endpoint) SetInterfaceAddress(id int, ipv4 net.IPNet, ipv6 net.IPNet) error {
+	ep.Lock()
+	n := ep.network
+	ep.Unlock()
+
+	n.Lock()
+	ctrlr := n.ctrlr
+	networkType := n.networkType
+	n.Unlock()
+
+	if !ctrlr.driverAssignsAddress(networkType) {
+		return types.ForbiddenErrorf("%s driver does not assign the endpoint addresses", networkType)
+	}
+
+	ep.Lock()
+	var iface *endpointInterface
+	for _, i := range ep.iFaces {
+		if i.id == id {
+			iface = i
+			break
+		}
+	}
+	var prev []net.IP
+	if iface != nil && iface.driverAssigned {
+		prev = []net.IP{iface.addr.IP, iface.addrv6.IP}
+	}
+	eid := ep.id
+	ep.Unlock()
+	if iface == nil {
+		return types.BadRequestErrorf("Interface with ID %d doesn't exist.", id)
+	}
+
+	// The addresses are recorded as owned by the endpoint, so that the
+	// driver cannot hand them to another endpoint of the network
+	var reserved []net.IP
+	for _, ip := range []net.IP{ipv4.IP, ipv6.IP} {
+		if len(ip) == 0 {
+			continue
+		}
+		if err := n.reserveAssignedAddress(ip, eid); err != nil {
+			n.releaseAssignedAddresses(reserved...)
+			return err
+		}
+		reserved = append(reserved, ip)
+	}
+	n.releaseAssignedAddresses(stale(prev, reserved)...)
+
+	ep.Lock()
+	iface.addr = *types.GetIPNetCopy(&ipv4)
+	iface.addrv6 = *types.GetIPNetCopy(&ipv6)
+	iface.driverAssigned = true
+	ep.Unlock()
+	return nil
+}
+
+// stale returns the addresses of prev which are not in cur
+func stale(prev, cur []net.IP) []net.IP {
+	var ips []net.IP
+	for _, ip := range prev {
+		found := len(ip) == 0
+		for _, c := range cur {
+			found = found || ip.Equal(c)
+		}
+		if !found {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// releaseDriverAssignedAddresses forgets the addresses the driver reported
+// on join, which are not held by the endpoint once it left
+func (ep *endpoint) releaseDriverAssignedAddresses() {
+	ep.Lock()
+	n := ep.network
+	var ips []net.IP
+	for _, iface := range ep.iFaces {
+		if iface.driverAssigned {
+			ips = append(ips, iface.addr.IP, iface.addrv6.IP)
+			iface.addr = net.IPNet{}
+			iface.addrv6 = net.IPNet{}
+			iface.driverAssigned = false
+		}
+	}
+	ep.Unlock()
+
+	n.releaseAssignedAddresses(ips...)
+}
+
+// reserveDriverAssignedAddresses records again the addresses the driver
+// reported on join as owned by the endpoint, once it is restored
+func (ep *endpoint) reserveDriverAssignedAddresses() {
+	ep.Lock()
+	n := ep.network
+	eid := ep.id
+	var ips []net.IP
+	for _, iface := range ep.iFaces {
+		if iface.driverAssigned {
+			ips = append(ips, iface.addr.IP, iface.addrv6.IP)
+		}
+	}
+	ep.Unlock()
+
+	for _, ip := range ips {
+		if len(ip) == 0 {
+			continue
+		}
+		if err := n.reserveAssignedAddress(ip, eid); err != nil {
+			log.Warnf("Failed to record address %s of endpoint %s: %v", ip, ep.Name(), err)
+		}
+	}
+}
+
+// assignedAddressSpace is the address space the addresses assigned by the
+// driver of a network are recorded in, as owned by their endpoint
+const assignedAddressSpace = ipam.AddressSpace("assigned")
+
+// reserveAssignedAddress records the address the driver assigned as owned
+// by the endpoint. The allocator is created on first use and kept in
+// memory, like the service VIPs.
+func (n *network) reserveAssignedAddress(ip net.IP, eid types.UUID) error {
+	n.Lock()
+	if n.assignedIPAM == nil {
+		a, err := ipam.NewAllocator(nil)
+		if err != nil {
+			n.Unlock()
+			return err
+		}
+		n.assignedIPAM = a
+	}
+	a := n.assignedIPAM
+	n.Unlock()
+
+	if err := a.ReserveExternal(context.Background(), assignedAddressSpace, ip, string(eid)); err != nil {
+		if err == ipam.ErrIPAlreadyAllocated {
+			return types.ForbiddenErrorf("address %s is already assigned to another endpoint of network %s", ip, n.Name())
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *network) releaseAssignedAddresses(ips ...net.IP) {
+	n.Lock()
+	a := n.assignedIPAM
+	n.Unlock()
+	if a == nil {
+		return
+	}
+
+	for _, ip := range ips {
+		if len(ip) != 0 {
+			a.Release(context.Background(), assignedAddressSpace, ip)
+		}
+	}
+}
+
 func (ep *endpoint) addInterfaceRoute(route *types.StaticRoute) error {
 	for _, iface := range ep.iFaces {
 		if iface.id == route.InterfaceID {
@@ -268,6 +504,17 @@ func (ep *endpoint) SandboxKey() string {
 	return ep.container.data.SandboxKey
 }
 
+func (ep *endpoint) FwMark() uint32 {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.container == nil {
+		return 0
+	}
+
+	return ep.container.data.FwMark
+}
+
 func (ep *endpoint) Gateway() net.IP {
 	ep.Lock()
 	defer ep.Unlock()
@@ -321,3 +568,11 @@ func (ep *endpoint) SetResolvConfPath(path string) error {
 	ep.joinInfo.resolvConfPath = path
 	return nil
 }
+
+func (ep *endpoint) AddBoundSocket(binding types.PortBinding, f *os.File) error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	ep.joinInfo.sockets = append(ep.joinInfo.sockets, boundSocket{binding: binding.GetCopy(), file: f})
+	return nil
+}
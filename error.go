@@ -2,6 +2,7 @@ package libnetwork
 
 import (
 	"fmt"
+	"strings"
 )
 
 // ErrNoSuchNetwork is returned when a network query finds no result
@@ -97,6 +98,29 @@ func (nt NetworkTypeError) Error() string {
 // NotFound denotes the type of this error
 func (nt NetworkTypeError) NotFound() {}
 
+// ErrDriverUnloaded is returned by the operations on the networks of a
+// driver unregistered with UnregisterDriver, until a driver of their type is
+// registered again
+type ErrDriverUnloaded string
+
+func (du ErrDriverUnloaded) Error() string {
+	return fmt.Sprintf("driver %s is unloaded", string(du))
+}
+
+// NoService denotes the type of this error
+func (du ErrDriverUnloaded) NoService() {}
+
+// ErrDriverNotReloadable is returned by ReloadDriver for a driver which does
+// not restore the endpoints of its networks, while they have some
+type ErrDriverNotReloadable string
+
+func (dr ErrDriverNotReloadable) Error() string {
+	return fmt.Sprintf("driver %s does not restore the endpoints of its networks, it cannot be reloaded while they have some", string(dr))
+}
+
+// Forbidden denotes the type of this error
+func (dr ErrDriverNotReloadable) Forbidden() {}
+
 // NetworkNameError is returned when a network with the same name already exists.
 type NetworkNameError string
 
@@ -135,6 +159,21 @@ func (aee *ActiveEndpointsError) Error() string {
 // Forbidden denotes the type of this error
 func (aee *ActiveEndpointsError) Forbidden() {}
 
+// EndpointLimitError is returned when an endpoint is created on a network
+// which has as many endpoints as it is capped to
+type EndpointLimitError struct {
+	name  string
+	id    string
+	Limit int
+}
+
+func (ele *EndpointLimitError) Error() string {
+	return fmt.Sprintf("network with name %s id %s is limited to %d endpoints", ele.name, ele.id, ele.Limit)
+}
+
+// Forbidden denotes the type of this error
+func (ele *EndpointLimitError) Forbidden() {}
+
 // UnknownEndpointError is returned when libnetwork could not find in it's database
 // an endpoint with the same name and id.
 type UnknownEndpointError struct {
@@ -163,6 +202,38 @@ func (ace *ActiveContainerError) Error() string {
 // Forbidden denotes the type of this error
 func (ace *ActiveContainerError) Forbidden() {}
 
+// IncompleteDeleteError is returned when an endpoint deleted with
+// DeleteOptionForce is gone, but some of its cleanups failed and were
+// skipped. The resources they release may be left behind.
+type IncompleteDeleteError struct {
+	name string
+	id   string
+	// Skipped describes the cleanups which failed
+	Skipped []string
+}
+
+func (ide *IncompleteDeleteError) Error() string {
+	return fmt.Sprintf("endpoint with name %s id %s deleted, but some cleanups were skipped: %s", ide.name, ide.id, strings.Join(ide.Skipped, "; "))
+}
+
+// NotAuthorizedError is returned when the authorizer of the controller
+// denies an operation
+type NotAuthorizedError struct {
+	Operation string
+	Actor     string
+	Reason    error
+}
+
+func (nae *NotAuthorizedError) Error() string {
+	if nae.Actor == "" {
+		return fmt.Sprintf("operation %s not authorized: %v", nae.Operation, nae.Reason)
+	}
+	return fmt.Sprintf("operation %s not authorized for %s: %v", nae.Operation, nae.Actor, nae.Reason)
+}
+
+// Forbidden denotes the type of this error
+func (nae *NotAuthorizedError) Forbidden() {}
+
 // InvalidContainerIDError is returned when an invalid container id is passed
 // in Join/Leave
 type InvalidContainerIDError string
@@ -173,3 +244,29 @@ func (id InvalidContainerIDError) Error() string {
 
 // BadRequest denotes the type of this error
 func (id InvalidContainerIDError) BadRequest() {}
+
+// ErrReadOnly is returned when an operation which changes the state is
+// invoked on a read-only controller
+type ErrReadOnly struct{}
+
+func (ro ErrReadOnly) Error() string {
+	return "the controller is read-only"
+}
+
+// Forbidden denotes the type of this error
+func (ro ErrReadOnly) Forbidden() {}
+
+// QuotaExceededError is returned when an operation would take a tenant
+// beyond one of its quotas
+type QuotaExceededError struct {
+	Tenant   string
+	Resource string
+	Limit    int
+}
+
+func (qe *QuotaExceededError) Error() string {
+	return fmt.Sprintf("tenant %s is limited to %d %s", qe.Tenant, qe.Limit, qe.Resource)
+}
+
+// Forbidden denotes the type of this error
+func (qe *QuotaExceededError) Forbidden() {}
@@ -113,6 +113,29 @@ func Delete(path string, recs []Record) error {
 	return ioutil.WriteFile(path, re.ReplaceAll(old, []byte("")), 0644)
 }
 
+// DeleteRecords deletes the Records already existing in /etc/hosts file
+// which match both the hosts and the IP address of one of recs, leaving the
+// other addresses of the hosts
+func DeleteRecords(path string, recs []Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	old, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	regexpStr := fmt.Sprintf("(?m)^(%s\\t%s\\n", regexp.QuoteMeta(recs[0].IP), regexp.QuoteMeta(recs[0].Hosts))
+	for _, r := range recs[1:] {
+		regexpStr = regexpStr + "|" + fmt.Sprintf("%s\\t%s\\n", regexp.QuoteMeta(r.IP), regexp.QuoteMeta(r.Hosts))
+	}
+	regexpStr = regexpStr + ")"
+
+	var re = regexp.MustCompile(regexpStr)
+	return ioutil.WriteFile(path, re.ReplaceAll(old, []byte("")), 0644)
+}
+
 // Update all IP addresses where hostname matches.
 // path is path to host file
 // IP is new IP address
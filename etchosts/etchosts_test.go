@@ -247,3 +247,48 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("Did not expect to find '%s' got '%s'", expected, content)
 	}
 }
+
+func TestDeleteRecords(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	err = Build(file.Name(), "", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Add(file.Name(), []Record{
+		{Hosts: "web", IP: "1.1.1.1"},
+		{Hosts: "web", IP: "1.1.1.2"},
+		{Hosts: "web.net", IP: "1.1.1.1"},
+		{Hosts: "db", IP: "11.1.1.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteRecords(file.Name(), []Record{
+		{Hosts: "web", IP: "1.1.1.1"},
+		{Hosts: "web.net", IP: "1.1.1.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"1.1.1.2\tweb\n", "11.1.1.1\tdb\n"} {
+		if !bytes.Contains(content, []byte(expected)) {
+			t.Fatalf("Expected to find '%s' got '%s'", expected, content)
+		}
+	}
+	for _, unexpected := range []string{"\n1.1.1.1\tweb\n", "1.1.1.1\tweb.net\n"} {
+		if bytes.Contains(content, []byte(unexpected)) {
+			t.Fatalf("Did not expect to find '%s' got '%s'", unexpected, content)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package libnetwork
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+// recordExternalEndpoints records the interfaces the driver adopted on the
+// network as external endpoints. They are listed along with the other
+// endpoints of the network and kept in the store, but never joined nor
+// deleted on their own, and not counted in the network.
+func (n *network) recordExternalEndpoints(ctx context.Context) error {
+	n.Lock()
+	r, ok := n.driver.(driverapi.ExternalEndpointReporter)
+	materialized := n.materialized
+	n.Unlock()
+	if !ok || !materialized {
+		return nil
+	}
+
+	ees, err := r.ExternalEndpoints(n.id)
+	if err != nil {
+		return err
+	}
+
+	for _, ee := range ees {
+		iface := &endpointInterface{id: 1, mac: ee.MacAddress, srcName: ee.Name}
+		for i, addr := range ee.Addresses {
+			if i == 0 {
+				iface.addr = *addr
+			} else {
+				iface.secondary = append(iface.secondary, addr)
+			}
+		}
+		ep := &endpoint{
+			id:       ee.ID,
+			name:     ee.Name,
+			network:  n,
+			iFaces:   []*endpointInterface{iface},
+			generic:  make(map[string]interface{}),
+			external: true,
+		}
+
+		n.Lock()
+		if _, ok := n.endpoints[ep.id]; ok {
+			n.Unlock()
+			continue
+		}
+		n.endpoints[ep.id] = ep
+		n.Unlock()
+
+		if err := n.ctrlr.updateEndpointToStore(ctx, ep); err != nil {
+			n.Lock()
+			delete(n.endpoints, ep.id)
+			n.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteExternalEndpoints removes the external endpoints of the network,
+// which the driver released along with the network, from the store
+func (n *network) deleteExternalEndpoints(ctx context.Context) {
+	n.Lock()
+	var eps []*endpoint
+	for _, ep := range n.endpoints {
+		eps = append(eps, ep)
+	}
+	n.Unlock()
+
+	for _, ep := range eps {
+		if !ep.isExternal() {
+			continue
+		}
+		if err := n.ctrlr.deleteEndpointFromStore(ctx, ep); err != nil {
+			log.Warnf("failed to delete external endpoint %s of network %s from the store: %v", ep.Name(), n.Name(), err)
+		}
+		n.Lock()
+		delete(n.endpoints, ep.id)
+		n.Unlock()
+	}
+}
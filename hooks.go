@@ -0,0 +1,176 @@
+package libnetwork
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+)
+
+// HookPoint identifies the operation, and the side of it, a hook runs at
+type HookPoint string
+
+// The points hooks can be registered at
+const (
+	HookPreCreateNetwork   HookPoint = "pre-create-network"
+	HookPostCreateNetwork  HookPoint = "post-create-network"
+	HookPreDeleteNetwork   HookPoint = "pre-delete-network"
+	HookPostDeleteNetwork  HookPoint = "post-delete-network"
+	HookPreCreateEndpoint  HookPoint = "pre-create-endpoint"
+	HookPostCreateEndpoint HookPoint = "post-create-endpoint"
+	HookPreDeleteEndpoint  HookPoint = "pre-delete-endpoint"
+	HookPostDeleteEndpoint HookPoint = "post-delete-endpoint"
+	HookPreJoin            HookPoint = "pre-join"
+	HookPostJoin           HookPoint = "post-join"
+	HookPreLeave           HookPoint = "pre-leave"
+	HookPostLeave          HookPoint = "post-leave"
+	// HookNetworkEvent is run with the events the drivers report about
+	// their networks. The errors of its hooks are logged.
+	HookNetworkEvent HookPoint = "network-event"
+)
+
+// HookEvent describes the operation a hook is invoked for
+type HookEvent struct {
+	Point HookPoint
+	// NetworkType is the type of the network, and Name the name of the
+	// network or of the endpoint the operation is about
+	NetworkType string
+	Name        string
+	// Network is nil until the network is created, Endpoint is nil until
+	// the endpoint is created and for the network operations
+	Network  Network
+	Endpoint Endpoint
+	// ContainerID is the container joining or leaving the endpoint
+	ContainerID string
+	// Err is the outcome of the operation, for the post hooks
+	Err error
+	// DryRun is set when the creation is only validated, the post hooks
+	// then get the outcome of the validation
+	DryRun bool
+	// Event and Attributes describe the event reported by the driver, for
+	// the network event hooks
+	Event      string
+	Attributes map[string]string
+}
+
+// Hook is a function registered by the embedder at a hook point. An error
+// returned by a pre hook aborts the operation with that error, an error
+// returned by a post hook is logged.
+type Hook func(ctx context.Context, ev HookEvent) error
+
+type hookTable map[HookPoint][]Hook
+
+func (c *controller) RegisterHook(point HookPoint, hook Hook) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.hooks == nil {
+		c.hooks = hookTable{}
+	}
+	c.hooks[point] = append(c.hooks[point], hook)
+}
+
+func (c *controller) hooksAt(point HookPoint) []Hook {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]Hook(nil), c.hooks[point]...)
+}
+
+// runPreHooks runs the hooks of the point in their registration order and
+// stops at the first error
+func (c *controller) runPreHooks(ctx context.Context, ev HookEvent) error {
+	for _, hook := range c.hooksAt(ev.Point) {
+		if err := hook(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookAbortError is the error of a pre hook run within a creation, which
+// aborts it without running the post hooks
+type hookAbortError struct {
+	err error
+}
+
+func (e *hookAbortError) Error() string {
+	return e.err.Error()
+}
+
+// abortedByHook returns the error of the pre hook which aborted the
+// operation, if one did
+func abortedByHook(err error) (error, bool) {
+	if e, ok := err.(*hookAbortError); ok {
+		return e.err, true
+	}
+	return err, false
+}
+
+// runPostHooks runs all the hooks of the point with the outcome of the
+// operation
+func (c *controller) runPostHooks(ctx context.Context, ev HookEvent, err error) {
+	ev.Err = err
+	for _, hook := range c.hooksAt(ev.Point) {
+		if e := hook(ctx, ev); e != nil {
+			log.Warnf("%s hook for %s failed: %v", ev.Point, ev.Name, e)
+		}
+	}
+}
+
+// NotifyNetworkEvent runs the network event hooks with the event the driver
+// reports about one of its networks
+func (c *controller) NotifyNetworkEvent(nid types.UUID, event string, attributes map[string]string) {
+	c.Lock()
+	n, ok := c.networks[nid]
+	c.Unlock()
+	if !ok {
+		log.Debugf("Dropping %s event of unknown network %s", event, nid)
+		return
+	}
+
+	n.Lock()
+	ev := HookEvent{
+		Point:       HookNetworkEvent,
+		NetworkType: n.networkType,
+		Name:        n.name,
+		Network:     n,
+		Event:       event,
+		Attributes:  attributes,
+	}
+	n.Unlock()
+
+	c.runPostHooks(context.Background(), ev, nil)
+}
+
+// hookEvent returns the event of an operation on the endpoint
+func (ep *endpoint) hookEvent(point HookPoint, containerID string) HookEvent {
+	ep.Lock()
+	n := ep.network
+	name := ep.name
+	ep.Unlock()
+
+	n.Lock()
+	networkType := n.networkType
+	n.Unlock()
+
+	return HookEvent{
+		Point:       point,
+		NetworkType: networkType,
+		Name:        name,
+		Network:     n,
+		Endpoint:    ep,
+		ContainerID: containerID,
+	}
+}
+
+func (ep *endpoint) controller() *controller {
+	ep.Lock()
+	n := ep.network
+	ep.Unlock()
+
+	n.Lock()
+	defer n.Unlock()
+
+	return n.ctrlr
+}
@@ -4,11 +4,14 @@ package ipallocator
 
 import (
 	"errors"
+	"math"
 	"math/big"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/netutils"
 )
 
@@ -46,6 +49,8 @@ var (
 	ErrNetworkAlreadyRegistered = errors.New("network already registered")
 	// ErrBadSubnet preformatted error
 	ErrBadSubnet = errors.New("network does not contain specified subnet")
+	// ErrNetworkNotFound preformatted error
+	ErrNetworkNotFound = errors.New("no address was allocated on network")
 )
 
 // IPAllocator manages the ipam
@@ -103,6 +108,24 @@ func (a *IPAllocator) RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
 	return allocated.checkIP(ip)
 }
 
+// PeekIP returns the ip the next RequestIP for the given network would
+// return if ip is nil, without allocating it
+func (a *IPAllocator) PeekIP(network *net.IPNet) (net.IP, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		allocated = newAllocatedMap(network)
+	}
+
+	pos, err := allocated.nextPos()
+	if err != nil {
+		return nil, err
+	}
+	return bigIntToIP(pos), nil
+}
+
 // ReleaseIP adds the provided ip back into the pool of
 // available ips to be returned for use.
 func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
@@ -115,6 +138,84 @@ func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
 	return nil
 }
 
+// AllocatedIPs returns the addresses allocated on the given network, in no
+// particular order
+func (a *IPAllocator) AllocatedIPs(network *net.IPNet) ([]net.IP, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		return nil, ErrNetworkNotFound
+	}
+
+	ips := make([]net.IP, 0, len(allocated.p))
+	for ip := range allocated.p {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips, nil
+}
+
+// PoolStatus reports the usage of the addresses of the given network, within
+// the subnet registered for it if any. The network and broadcast addresses
+// are not part of the pool.
+func (a *IPAllocator) PoolStatus(network *net.IPNet) (*ipam.PoolStatus, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		return nil, ErrNetworkNotFound
+	}
+
+	used := make([]*big.Int, 0, len(allocated.p))
+	for ip := range allocated.p {
+		used = append(used, ipToBigInt(net.ParseIP(ip)))
+	}
+	sort.Sort(bigInts(used))
+
+	first, _ := netutils.NetworkRange(network)
+	ps := &ipam.PoolStatus{
+		Subnet: &net.IPNet{IP: first, Mask: network.Mask},
+		Used:   uint64(len(used)),
+	}
+	total := big.NewInt(0).Sub(allocated.end, allocated.begin)
+	total.Add(total, big.NewInt(1))
+	ps.Total = toUint64(total)
+	ps.Free = ps.Total - ps.Used
+
+	// Walk the gaps between the allocated addresses
+	next := big.NewInt(0).Set(allocated.begin)
+	gap := big.NewInt(0)
+	one := big.NewInt(1)
+	for _, pos := range append(used, big.NewInt(0).Add(allocated.end, one)) {
+		gap.Sub(pos, next)
+		if gap.Sign() > 0 {
+			ps.FreeRanges++
+			if g := toUint64(gap); g > ps.LargestFreeRange {
+				ps.LargestFreeRange = g
+			}
+		}
+		next.Add(pos, one)
+	}
+
+	return ps, nil
+}
+
+type bigInts []*big.Int
+
+func (b bigInts) Len() int           { return len(b) }
+func (b bigInts) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bigInts) Less(i, j int) bool { return b[i].Cmp(b[j]) < 0 }
+
+// toUint64 converts v, saturating the IPv6 sizes which do not fit
+func toUint64(v *big.Int) uint64 {
+	if v.BitLen() > 64 {
+		return math.MaxUint64
+	}
+	return v.Uint64()
+}
+
 func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 	if _, ok := allocated.p[ip.String()]; ok {
 		return nil, ErrIPAlreadyAllocated
@@ -135,6 +236,18 @@ func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 // return an available ip if one is currently available.  If not,
 // return the next available ip for the network
 func (allocated *allocatedMap) getNextIP() (net.IP, error) {
+	pos, err := allocated.nextPos()
+	if err != nil {
+		return nil, err
+	}
+	allocated.p[bigIntToIP(pos).String()] = struct{}{}
+	allocated.last.Set(pos)
+	return bigIntToIP(pos), nil
+}
+
+// nextPos returns the position of the next available ip, without
+// allocating it
+func (allocated *allocatedMap) nextPos() (*big.Int, error) {
 	pos := big.NewInt(0).Set(allocated.last)
 	allRange := big.NewInt(0).Sub(allocated.end, allocated.begin)
 	for i := big.NewInt(0); i.Cmp(allRange) <= 0; i.Add(i, big.NewInt(1)) {
@@ -145,9 +258,7 @@ func (allocated *allocatedMap) getNextIP() (net.IP, error) {
 		if _, ok := allocated.p[bigIntToIP(pos).String()]; ok {
 			continue
 		}
-		allocated.p[bigIntToIP(pos).String()] = struct{}{}
-		allocated.last.Set(pos)
-		return bigIntToIP(pos), nil
+		return pos, nil
 	}
 	return nil, ErrNoAvailableIPs
 }
@@ -2,6 +2,7 @@ package ipallocator
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"net"
 	"testing"
@@ -688,3 +689,118 @@ func BenchmarkRequestIP(b *testing.B) {
 		}
 	}
 }
+
+func TestPoolStatus(t *testing.T) {
+	a := New()
+	network := &net.IPNet{IP: []byte{192, 168, 0, 1}, Mask: []byte{255, 255, 255, 0}}
+
+	if _, err := a.PoolStatus(network); err != ErrNetworkNotFound {
+		t.Fatalf("Unexpected error for unknown network: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := a.RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.ReleaseIP(network, net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := a.PoolStatus(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Subnet.String() != "192.168.0.0/24" {
+		t.Fatalf("Unexpected subnet %s", ps.Subnet)
+	}
+	if ps.Total != 254 || ps.Used != 3 || ps.Free != 251 {
+		t.Fatalf("Unexpected address counts: %+v", ps)
+	}
+	// 192.168.0.2 and 192.168.0.5-254
+	if ps.FreeRanges != 2 || ps.LargestFreeRange != 250 {
+		t.Fatalf("Unexpected free ranges: %+v", ps)
+	}
+
+	v6 := &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(48, 128)}
+	if _, err := a.RequestIP(v6, nil); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = a.PoolStatus(v6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Total != math.MaxUint64 || ps.Used != 1 || ps.FreeRanges != 1 {
+		t.Fatalf("Unexpected IPv6 pool status: %+v", ps)
+	}
+}
+
+func TestPeekIP(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	for i := 0; i < 2; i++ {
+		ip, err := a.PeekIP(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "192.168.0.1"; ip.String() != expected {
+			t.Fatalf("Expected ip %s got %s", expected, ip)
+		}
+	}
+
+	if _, err := a.RequestIP(network, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.RequestIP(network, net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := a.PeekIP(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "192.168.0.3"; ip.String() != expected {
+		t.Fatalf("Expected ip %s got %s", expected, ip)
+	}
+	requested, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requested.Equal(ip) {
+		t.Fatalf("Requested ip %s differs from peeked ip %s", requested, ip)
+	}
+}
+
+func TestAllocatedIPs(t *testing.T) {
+	a := New()
+	network := &net.IPNet{IP: []byte{192, 168, 0, 1}, Mask: []byte{255, 255, 255, 0}}
+
+	if _, err := a.AllocatedIPs(network); err != ErrNetworkNotFound {
+		t.Fatalf("Unexpected error for unknown network: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.ReleaseIP(network, net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	ips, err := a.AllocatedIPs(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, ip := range ips {
+		found[ip.String()] = true
+	}
+	if len(found) != 2 || !found["192.168.0.1"] || !found["192.168.0.3"] {
+		t.Fatalf("Unexpected allocated addresses: %v", ips)
+	}
+}
@@ -1,6 +1,7 @@
 package ipam
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -34,6 +35,13 @@ type Allocator struct {
 	subnets map[subnetKey]*SubnetInfo
 	// Allocated addresses in each address space's internal subnet
 	addresses map[subnetKey]*bitseq.Handle
+	// Endpoint which requested each address, for the requests served here
+	owners map[string]string
+	// Address last leased to each endpoint, by lease key, also kept in the
+	// datastore
+	leases map[string]net.IP
+	// Release history of the internal subnets of the StrategyLRU subnets
+	lru map[subnetKey]*lruState
 	// Datastore
 	store    datastore.DataStore
 	App      string
@@ -48,6 +56,9 @@ func NewAllocator(ds datastore.DataStore) (*Allocator, error) {
 	a := &Allocator{}
 	a.subnets = make(map[subnetKey]*SubnetInfo)
 	a.addresses = make(map[subnetKey]*bitseq.Handle)
+	a.owners = make(map[string]string)
+	a.leases = make(map[string]net.IP)
+	a.lru = make(map[subnetKey]*lruState)
 	a.internalHostSize = defaultInternalHostSize
 	a.store = ds
 	a.App = "ipam"
@@ -61,7 +72,7 @@ func NewAllocator(ds datastore.DataStore) (*Allocator, error) {
 	a.watchForChanges()
 
 	// Get the initial subnet configs status from the ds if present.
-	kvPair, err := a.store.KVStore().Get(datastore.Key(a.Key()...))
+	kvPair, err := a.store.KVStore().Get(a.Key().String())
 	if err != nil {
 		if err != store.ErrKeyNotFound {
 			return nil, fmt.Errorf("failed to retrieve the ipam subnet configs from datastore: %v", err)
@@ -165,14 +176,17 @@ const (
  ********************/
 
 // AddSubnet adds a subnet for the specified address space
-func (a *Allocator) AddSubnet(addrSpace AddressSpace, subnetInfo *SubnetInfo) error {
+func (a *Allocator) AddSubnet(ctx context.Context, addrSpace AddressSpace, subnetInfo *SubnetInfo) error {
 	// Sanity check
 	if addrSpace == "" {
 		return ErrInvalidAddressSpace
 	}
-	if subnetInfo == nil || subnetInfo.Subnet == nil {
+	if subnetInfo == nil {
 		return ErrInvalidSubnet
 	}
+	if err := subnetInfo.Validate(); err != nil {
+		return err
+	}
 	// Convert to smaller internal subnets (if needed)
 	subnetList, err := getInternalSubnets(subnetInfo.Subnet, a.internalHostSize)
 	if err != nil {
@@ -188,13 +202,16 @@ retry:
 	a.Lock()
 	a.subnets[key] = subnetInfo
 	a.Unlock()
-	err = a.writeToStore()
+	err = a.writeToStore(ctx)
 	if err != nil {
 		if _, ok := err.(types.RetryError); !ok {
+			a.Lock()
+			delete(a.subnets, key)
+			a.Unlock()
 			return types.InternalErrorf("subnet configuration failed because of %s", err.Error())
 		}
 		// Update to latest
-		if erru := a.readFromStore(); erru != nil {
+		if erru := a.readFromStore(ctx); erru != nil {
 			// Restore and bail out
 			a.Lock()
 			delete(a.addresses, key)
@@ -211,20 +228,30 @@ retry:
 }
 
 // Create and insert the internal subnet(s) addresses masks into the address database. Mask data may come from the bitseq datastore.
+// The masks are represented with the backend suiting the strategy of the subnet, the ones stored with another backend are migrated.
 func (a *Allocator) insertAddressMasks(parentKey subnetKey, internalSubnetList []*net.IPNet) error {
+	a.Lock()
+	backend := strategyBackend(a.subnetStrategy(parentKey))
+	a.Unlock()
+
 	for _, intSub := range internalSubnetList {
-		var err error
 		ones, bits := intSub.Mask.Size()
 		numAddresses := 1 << uint(bits-ones)
 		smallKey := subnetKey{parentKey.addressSpace, parentKey.subnet, intSub.String()}
 
 		// Insert the new address masks. AddressMask content may come from datastore
-		a.Lock()
-		a.addresses[smallKey], err = bitseq.NewHandle(dsDataKey, a.store, smallKey.String(), uint32(numAddresses))
-		a.Unlock()
+		h, err := bitseq.NewHandleWithBackend(dsDataKey, a.store, smallKey.String(), uint32(numAddresses), backend)
 		if err != nil {
 			return err
 		}
+		if h.Backend() != backend {
+			if err := h.Migrate(backend); err != nil {
+				log.Warnf("Failed to migrate the address mask of %s to the %s backend: %v", smallKey.String(), backend, err)
+			}
+		}
+		a.Lock()
+		a.addresses[smallKey] = h
+		a.Unlock()
 	}
 	return nil
 }
@@ -305,7 +332,7 @@ func getInternalSubnets(inSubnet *net.IPNet, internalHostSize int) ([]*net.IPNet
 }
 
 // RemoveSubnet removes the subnet from the specified address space
-func (a *Allocator) RemoveSubnet(addrSpace AddressSpace, subnet *net.IPNet) error {
+func (a *Allocator) RemoveSubnet(ctx context.Context, addrSpace AddressSpace, subnet *net.IPNet) error {
 	if addrSpace == "" {
 		return ErrInvalidAddressSpace
 	}
@@ -327,13 +354,13 @@ retry:
 	a.Lock()
 	delete(a.subnets, subKey)
 	a.Unlock()
-	err := a.writeToStore()
+	err := a.writeToStore(ctx)
 	if err != nil {
 		if _, ok := err.(types.RetryError); !ok {
 			return types.InternalErrorf("subnet removal failed because of %s", err.Error())
 		}
 		// Update to latest
-		if erru := a.readFromStore(); erru != nil {
+		if erru := a.readFromStore(ctx); erru != nil {
 			// Restore and bail out
 			a.Lock()
 			a.subnets[subKey] = current
@@ -356,15 +383,24 @@ retry:
 			bm.Destroy()
 		}
 		delete(a.addresses, sk)
+		delete(a.lru, sk)
 		a.Unlock()
 	}
 
+	a.Lock()
+	for k := range a.owners {
+		if space, ip := splitOwnerKey(k); space == addrSpace && current.Subnet.Contains(ip) {
+			delete(a.owners, k)
+		}
+	}
+	a.Unlock()
+
 	return nil
 
 }
 
 // AddVendorInfo adds vendor specific data
-func (a *Allocator) AddVendorInfo([]byte) error {
+func (a *Allocator) AddVendorInfo(context.Context, []byte) error {
 	// no op for us
 	return nil
 }
@@ -374,16 +410,16 @@ func (a *Allocator) AddVendorInfo([]byte) error {
  ****************/
 
 // Request allows requesting an IPv4 address from the specified address space
-func (a *Allocator) Request(addrSpace AddressSpace, req *AddressRequest) (*AddressResponse, error) {
-	return a.request(addrSpace, req, v4)
+func (a *Allocator) Request(ctx context.Context, addrSpace AddressSpace, req *AddressRequest) (*AddressResponse, error) {
+	return a.request(ctx, addrSpace, req, v4)
 }
 
 // RequestV6 requesting an IPv6 address from the specified address space
-func (a *Allocator) RequestV6(addrSpace AddressSpace, req *AddressRequest) (*AddressResponse, error) {
-	return a.request(addrSpace, req, v6)
+func (a *Allocator) RequestV6(ctx context.Context, addrSpace AddressSpace, req *AddressRequest) (*AddressResponse, error) {
+	return a.request(ctx, addrSpace, req, v6)
 }
 
-func (a *Allocator) request(addrSpace AddressSpace, req *AddressRequest, version ipVersion) (*AddressResponse, error) {
+func (a *Allocator) request(ctx context.Context, addrSpace AddressSpace, req *AddressRequest, version ipVersion) (*AddressResponse, error) {
 	// Empty response
 	response := &AddressResponse{}
 
@@ -402,21 +438,45 @@ func (a *Allocator) request(addrSpace AddressSpace, req *AddressRequest, version
 		return response, ErrInvalidRequest
 	}
 
+	// The address last leased to the endpoint is preferred, but any will do
+	prefAddress, fallback := req.Address, req.Fallback
+	if prefAddress == nil && req.Affinity {
+		if ip := a.lease(ctx, addrSpace, req.Endpoint); ip != nil && req.Subnet.Contains(ip) {
+			prefAddress, fallback = ip, FallbackAny
+		}
+	}
+	// The host portion is taken from the address in the length of its family
+	if prefAddress != nil && version == v4 {
+		prefAddress = prefAddress.To4()
+	}
+
 	// Look for an address
-	ip, _, err := a.reserveAddress(addrSpace, &req.Subnet, req.Address, version)
+	ip, _, err := a.reserveAddress(ctx, addrSpace, &req.Subnet, prefAddress, version)
+	if err == ErrNoAvailableIPs && prefAddress != nil && fallback != FallbackNone {
+		ip, err = a.reserveInBlock(ctx, addrSpace, &req.Subnet, prefAddress, version)
+		if err == ErrNoAvailableIPs && fallback == FallbackAny {
+			ip, _, err = a.reserveAddress(ctx, addrSpace, &req.Subnet, nil, version)
+		}
+	}
 	if err == nil {
 		// Populate response
 		response.Address = ip
 		a.Lock()
 		response.Subnet = *a.subnets[subnetKey{addrSpace, req.Subnet.String(), ""}]
+		if req.Endpoint != "" {
+			a.owners[ownerKey(addrSpace, ip)] = req.Endpoint
+		}
 		a.Unlock()
+		if req.Endpoint != "" {
+			a.recordLease(ctx, addrSpace, req.Endpoint, ip)
+		}
 	}
 
 	return response, err
 }
 
 // Release allows releasing the address from the specified address space
-func (a *Allocator) Release(addrSpace AddressSpace, address net.IP) {
+func (a *Allocator) Release(ctx context.Context, addrSpace AddressSpace, address net.IP) {
 	if address == nil {
 		return
 	}
@@ -424,6 +484,9 @@ func (a *Allocator) Release(addrSpace AddressSpace, address net.IP) {
 	if ver == v4 {
 		address = address.To4()
 	}
+	a.Lock()
+	delete(a.owners, ownerKey(addrSpace, address))
+	a.Unlock()
 	for _, subKey := range a.getSubnetList(addrSpace, ver) {
 		a.Lock()
 		space := a.addresses[subKey]
@@ -435,7 +498,8 @@ func (a *Allocator) Release(addrSpace AddressSpace, address net.IP) {
 			// Release it
 			for {
 				var err error
-				if err = space.PushReservation(ordinal/8, ordinal%8, true); err == nil {
+				if err = space.PushReservationContext(ctx, ordinal/8, ordinal%8, true); err == nil {
+					a.recordRelease(subKey, space, ordinal)
 					break
 				}
 				if _, ok := err.(types.RetryError); ok {
@@ -451,7 +515,56 @@ func (a *Allocator) Release(addrSpace AddressSpace, address net.IP) {
 	}
 }
 
-func (a *Allocator) reserveAddress(addrSpace AddressSpace, subnet *net.IPNet, prefAddress net.IP, ver ipVersion) (net.IP, *net.IPNet, error) {
+// ReserveExternal records the address, assigned outside of the allocator,
+// as owned by the endpoint, so that it is neither handed out nor recorded
+// for another endpoint. The address is reserved in the subnet of the address
+// space containing it, if any.
+func (a *Allocator) ReserveExternal(ctx context.Context, addrSpace AddressSpace, address net.IP, endpoint string) error {
+	if addrSpace == "" {
+		return ErrInvalidAddressSpace
+	}
+	if address == nil || endpoint == "" {
+		return ErrInvalidRequest
+	}
+	ver := getAddressVersion(address)
+	if ver == v4 {
+		address = address.To4()
+	}
+	key := ownerKey(addrSpace, address)
+
+	a.Lock()
+	owner, ok := a.owners[key]
+	var subnet *net.IPNet
+	for k, info := range a.subnets {
+		if k.addressSpace == addrSpace && k.childSubnet == "" && info.Subnet.Contains(address) {
+			subnet = info.Subnet
+			break
+		}
+	}
+	a.Unlock()
+	if ok {
+		if owner == endpoint {
+			return nil
+		}
+		return ErrIPAlreadyAllocated
+	}
+
+	if subnet != nil {
+		if _, _, err := a.reserveAddress(ctx, addrSpace, subnet, address, ver); err != nil {
+			if err == ErrNoAvailableIPs {
+				return ErrIPAlreadyAllocated
+			}
+			return err
+		}
+	}
+
+	a.Lock()
+	a.owners[key] = endpoint
+	a.Unlock()
+	return nil
+}
+
+func (a *Allocator) reserveAddress(ctx context.Context, addrSpace AddressSpace, subnet *net.IPNet, prefAddress net.IP, ver ipVersion) (net.IP, *net.IPNet, error) {
 	var keyList []subnetKey
 
 	// Get the list of pointers to the internal subnets
@@ -481,10 +594,13 @@ func (a *Allocator) reserveAddress(addrSpace AddressSpace, subnet *net.IPNet, pr
 			fmt.Printf("\nDid not find a bitmask for subnet key: %s", key.String())
 			continue
 		}
-		address, err := a.getAddress(key.canonicalChildSubnet(), bitmask, prefAddress, ver)
+		address, err := a.getAddress(ctx, key, bitmask, prefAddress, ver)
 		if err == nil {
 			return address, subnet, nil
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	return nil, nil, ErrNoAvailableIPs
@@ -507,20 +623,25 @@ func (a *Allocator) getSubnetList(addrSpace AddressSpace, ver ipVersion) []subne
 	return list[0:ind]
 }
 
-func (a *Allocator) getAddress(subnet *net.IPNet, bitmask *bitseq.Handle, prefAddress net.IP, ver ipVersion) (net.IP, error) {
+func (a *Allocator) getAddress(ctx context.Context, key subnetKey, bitmask *bitseq.Handle, prefAddress net.IP, ver ipVersion) (net.IP, error) {
 	var (
 		bytePos, bitPos int
 		ordinal         int
 		err             error
 	)
 
+	subnet := key.canonicalChildSubnet()
+	a.Lock()
+	strategy := a.subnetStrategy(key)
+	a.Unlock()
+
 	// Look for free IP, skip .0 and .255, they will be automatically reserved
 	for {
 		if bitmask.Unselected() <= 0 {
 			return nil, ErrNoAvailableIPs
 		}
 		if prefAddress == nil {
-			bytePos, bitPos, err = bitmask.GetFirstAvailable()
+			bytePos, bitPos, err = a.pickAddress(key, bitmask, strategy)
 		} else {
 			ordinal = ipToInt(getHostPortionIP(prefAddress, subnet))
 			bytePos, bitPos, err = bitmask.CheckIfAvailable(ordinal)
@@ -530,7 +651,7 @@ func (a *Allocator) getAddress(subnet *net.IPNet, bitmask *bitseq.Handle, prefAd
 		}
 
 		// Lock it
-		if err = bitmask.PushReservation(bytePos, bitPos, false); err != nil {
+		if err = bitmask.PushReservationContext(ctx, bytePos, bitPos, false); err != nil {
 			if _, ok := err.(types.RetryError); !ok {
 				return nil, fmt.Errorf("internal failure while reserving the address: %s", err.Error())
 			}
@@ -551,6 +672,65 @@ func (a *Allocator) getAddress(subnet *net.IPNet, bitmask *bitseq.Handle, prefAd
 	return generateAddress(ordinal, subnet), nil
 }
 
+// PoolStatus reports the usage of the specified subnet of the address space.
+// The top consumers are the endpoints of the address requests served by this
+// allocator instance.
+func (a *Allocator) PoolStatus(addrSpace AddressSpace, subnet *net.IPNet) (*PoolStatus, error) {
+	if addrSpace == "" {
+		return nil, ErrInvalidAddressSpace
+	}
+	if subnet == nil {
+		return nil, ErrInvalidSubnet
+	}
+
+	var masks []*bitseq.Handle
+	counts := make(map[string]uint64)
+
+	a.Lock()
+	info, ok := a.subnets[subnetKey{addrSpace, subnet.String(), ""}]
+	if ok {
+		for k, h := range a.addresses {
+			if k.addressSpace == addrSpace && k.subnet == subnet.String() {
+				masks = append(masks, h)
+			}
+		}
+		for k, ep := range a.owners {
+			if space, ip := splitOwnerKey(k); space == addrSpace && info.Subnet.Contains(ip) {
+				counts[ep]++
+			}
+		}
+	}
+	a.Unlock()
+
+	if !ok {
+		return nil, ErrSubnetNotFound
+	}
+
+	ps := &PoolStatus{Subnet: types.GetIPNetCopy(info.Subnet)}
+	for _, h := range masks {
+		ps.Total += uint64(h.Bits())
+		ps.Free += uint64(h.Unselected())
+		ranges, longest := h.FreeRanges()
+		ps.FreeRanges += uint64(ranges)
+		if uint64(longest) > ps.LargestFreeRange {
+			ps.LargestFreeRange = uint64(longest)
+		}
+	}
+	ps.Used = ps.Total - ps.Free
+	ps.TopConsumers = TopConsumers(counts, MaxTopConsumers)
+
+	return ps, nil
+}
+
+func ownerKey(addrSpace AddressSpace, ip net.IP) string {
+	return fmt.Sprintf("%s/%s", addrSpace, ip)
+}
+
+func splitOwnerKey(key string) (AddressSpace, net.IP) {
+	i := strings.LastIndex(key, "/")
+	return AddressSpace(key[:i]), net.ParseIP(key[i+1:])
+}
+
 // DumpDatabase dumps the internal info
 func (a *Allocator) DumpDatabase() {
 	a.Lock()
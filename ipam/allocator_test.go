@@ -1,12 +1,15 @@
 package ipam
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/datastore"
 )
 
 func getAllocator(t *testing.T, subnet *net.IPNet) *Allocator {
@@ -14,7 +17,7 @@ func getAllocator(t *testing.T, subnet *net.IPNet) *Allocator {
 	if err != nil {
 		t.Fatal(err)
 	}
-	a.AddSubnet("default", &SubnetInfo{Subnet: subnet})
+	a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: subnet})
 	return a
 }
 
@@ -99,29 +102,29 @@ func TestAddSubnets(t *testing.T) {
 	}
 
 	_, sub0, _ := net.ParseCIDR("10.0.0.0/8")
-	err = a.AddSubnet("default", &SubnetInfo{Subnet: sub0})
+	err = a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub0})
 	if err != nil {
 		t.Fatalf("Unexpected failure in adding subent")
 	}
 
-	err = a.AddSubnet("abc", &SubnetInfo{Subnet: sub0})
+	err = a.AddSubnet(context.Background(), "abc", &SubnetInfo{Subnet: sub0})
 	if err != nil {
 		t.Fatalf("Unexpected failure in adding overlapping subents to different address spaces")
 	}
 
-	err = a.AddSubnet("abc", &SubnetInfo{Subnet: sub0})
+	err = a.AddSubnet(context.Background(), "abc", &SubnetInfo{Subnet: sub0})
 	if err == nil {
 		t.Fatalf("Failed to detect overlapping subnets: %s and %s", sub0, sub0)
 	}
 
 	_, sub1, _ := net.ParseCIDR("10.20.2.0/24")
-	err = a.AddSubnet("default", &SubnetInfo{Subnet: sub1})
+	err = a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub1})
 	if err == nil {
 		t.Fatalf("Failed to detect overlapping subnets: %s and %s", sub0, sub1)
 	}
 
 	_, sub2, _ := net.ParseCIDR("10.128.0.0/9")
-	err = a.AddSubnet("default", &SubnetInfo{Subnet: sub2})
+	err = a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub2})
 	if err == nil {
 		t.Fatalf("Failed to detect overlapping subnets: %s and %s", sub1, sub2)
 	}
@@ -130,7 +133,7 @@ func TestAddSubnets(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Wrong input, Can't proceed: %s", err.Error())
 	}
-	err = a.AddSubnet("default", &SubnetInfo{Subnet: sub6})
+	err = a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub6})
 	if err != nil {
 		t.Fatalf("Failed to add v6 subnet: %s", err.Error())
 	}
@@ -139,7 +142,7 @@ func TestAddSubnets(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Wrong input, Can't proceed: %s", err.Error())
 	}
-	err = a.AddSubnet("default", &SubnetInfo{Subnet: sub6})
+	err = a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub6})
 	if err == nil {
 		t.Fatalf("Failed to detect overlapping v6 subnet")
 	}
@@ -195,14 +198,14 @@ func TestRemoveSubnet(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Wrong input, Can't proceed: %s", err.Error())
 		}
-		err = a.AddSubnet(i.addrSpace, &SubnetInfo{Subnet: sub})
+		err = a.AddSubnet(context.Background(), i.addrSpace, &SubnetInfo{Subnet: sub})
 		if err != nil {
 			t.Fatalf("Failed to apply input. Can't proceed: %s", err.Error())
 		}
 	}
 
 	_, sub, _ := net.ParseCIDR("172.17.0.0/16")
-	a.RemoveSubnet("default", sub)
+	a.RemoveSubnet(context.Background(), "default", sub)
 	if len(a.subnets) != 7 {
 		t.Fatalf("Failed to remove subnet info")
 	}
@@ -212,7 +215,7 @@ func TestRemoveSubnet(t *testing.T) {
 	}
 
 	_, sub, _ = net.ParseCIDR("2002:1:2:3:4:5:ffff::/112")
-	a.RemoveSubnet("default", sub)
+	a.RemoveSubnet(context.Background(), "default", sub)
 	if len(a.subnets) != 6 {
 		t.Fatalf("Failed to remove subnet info")
 	}
@@ -222,7 +225,7 @@ func TestRemoveSubnet(t *testing.T) {
 	}
 
 	_, sub, _ = net.ParseCIDR("2002:1:2:3:4:5:6::/112")
-	a.RemoveSubnet("splane", sub)
+	a.RemoveSubnet(context.Background(), "splane", sub)
 	if len(a.subnets) != 5 {
 		t.Fatalf("Failed to remove subnet info")
 	}
@@ -311,7 +314,7 @@ func TestGetSubnetList(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Wrong input, Can't proceed: %s", err.Error())
 		}
-		err = a.AddSubnet(i.addrSpace, &SubnetInfo{Subnet: sub})
+		err = a.AddSubnet(context.Background(), i.addrSpace, &SubnetInfo{Subnet: sub})
 		if err != nil {
 			t.Fatalf("Failed to apply input. Can't proceed: %s", err.Error())
 		}
@@ -350,25 +353,25 @@ func TestRequestSyntaxCheck(t *testing.T) {
 
 	// Add subnet and create base request
 	_, sub, _ := net.ParseCIDR(subnet)
-	a.AddSubnet(addSpace, &SubnetInfo{Subnet: sub})
+	a.AddSubnet(context.Background(), addSpace, &SubnetInfo{Subnet: sub})
 	req := &AddressRequest{Subnet: *sub}
 
 	// Empty address space request
-	_, err = a.Request("", req)
+	_, err = a.Request(context.Background(), "", req)
 	if err == nil {
 		t.Fatalf("Failed to detect wrong request: empty address space")
 	}
 
 	// Preferred address from different subnet in request
 	req.Address = net.ParseIP("172.17.0.23")
-	_, err = a.Request(addSpace, req)
+	_, err = a.Request(context.Background(), addSpace, req)
 	if err == nil {
 		t.Fatalf("Failed to detect wrong request: preferred IP from different subnet")
 	}
 
 	// Preferred address specified and nil subnet
 	req = &AddressRequest{Address: net.ParseIP("172.17.0.23")}
-	_, err = a.Request(addSpace, req)
+	_, err = a.Request(context.Background(), addSpace, req)
 	if err == nil {
 		t.Fatalf("Failed to detect wrong request: subnet not specified but preferred address specified")
 	}
@@ -419,7 +422,7 @@ func TestRelease(t *testing.T) {
 
 	// Allocate all addresses
 	for err != ErrNoAvailableIPs {
-		_, err = a.Request("default", req)
+		_, err = a.Request(context.Background(), "default", req)
 	}
 
 	toRelease := []struct {
@@ -453,12 +456,12 @@ func TestRelease(t *testing.T) {
 	req = &AddressRequest{Subnet: *sub}
 	for i, inp := range toRelease {
 		address := net.ParseIP(inp.address)
-		a.Release("default", address)
+		a.Release(context.Background(), "default", address)
 		if bm.Unselected() != 1 {
 			t.Fatalf("Failed to update free address count after release. Expected %d, Found: %d", i+1, bm.Unselected())
 		}
 
-		rsp, err := a.Request("default", req)
+		rsp, err := a.Request(context.Background(), "default", req)
 		if err != nil {
 			t.Fatalf("Failed to obtain the address: %s", err.Error())
 		}
@@ -509,7 +512,7 @@ func assertGetAddress(t *testing.T, subnet string) {
 	start := time.Now()
 	run := 0
 	for err != ErrNoAvailableIPs {
-		_, err = a.getAddress(sub, bm, nil, v4)
+		_, err = a.getAddress(context.Background(), subnetKey{"default", subnet, subnet}, bm, nil, v4)
 		run++
 	}
 	if printTime {
@@ -526,6 +529,51 @@ func assertGetAddress(t *testing.T, subnet string) {
 	*/
 }
 
+func TestPoolStatus(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	a := getAllocator(t, sub)
+
+	for _, ep := range []string{"ep1", "ep1", "ep1", "ep2"} {
+		if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Endpoint: ep}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Release(context.Background(), "default", net.ParseIP("192.168.100.2"))
+
+	ps, err := a.PoolStatus("default", sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The network address is reserved along with the first request
+	if ps.Total != 256 || ps.Used != 4 || ps.Free != 252 {
+		t.Fatalf("Unexpected address counts: %+v", ps)
+	}
+	if ps.FreeRanges != 2 || ps.LargestFreeRange != 251 {
+		t.Fatalf("Unexpected free ranges: %+v", ps)
+	}
+	if f := ps.Fragmentation(); f <= 0 || f >= 0.01 {
+		t.Fatalf("Unexpected fragmentation %f", f)
+	}
+	expected := []Consumer{{"ep1", 2}, {"ep2", 1}}
+	if !reflect.DeepEqual(ps.TopConsumers, expected) {
+		t.Fatalf("Unexpected top consumers: %v", ps.TopConsumers)
+	}
+
+	_, other, _ := net.ParseCIDR("192.168.101.0/24")
+	if _, err := a.PoolStatus("default", other); err != ErrSubnetNotFound {
+		t.Fatalf("Unexpected error for unknown subnet: %v", err)
+	}
+}
+
+func TestTopConsumers(t *testing.T) {
+	counts := map[string]uint64{"a": 1, "b": 3, "c": 3, "d": 2}
+	top := TopConsumers(counts, 3)
+	expected := []Consumer{{"b", 3}, {"c", 3}, {"d", 2}}
+	if !reflect.DeepEqual(top, expected) {
+		t.Fatalf("Unexpected top consumers: %v", top)
+	}
+}
+
 func assertNRequests(t *testing.T, subnet string, numReq int, lastExpectedIP string) {
 	var (
 		err       error
@@ -543,7 +591,7 @@ func assertNRequests(t *testing.T, subnet string, numReq int, lastExpectedIP str
 	i := 0
 	start := time.Now()
 	for ; i < numReq; i++ {
-		rsp, err = a.Request("default", req)
+		rsp, err = a.Request(context.Background(), "default", req)
 	}
 	if printTime {
 		fmt.Printf("\nTaken %v, to allocate %d addresses on %s\n", time.Since(start), numReq, subnet)
@@ -559,11 +607,11 @@ func benchmarkRequest(subnet *net.IPNet) {
 
 	a, _ := NewAllocator(nil)
 	a.internalHostSize = 20
-	a.AddSubnet("default", &SubnetInfo{Subnet: subnet})
+	a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: subnet})
 
 	req := &AddressRequest{Subnet: *subnet}
 	for err != ErrNoAvailableIPs {
-		_, err = a.Request("default", req)
+		_, err = a.Request(context.Background(), "default", req)
 
 	}
 }
@@ -585,3 +633,239 @@ func BenchmarkRequest_16(b *testing.B) {
 func BenchmarkRequest_8(b *testing.B) {
 	benchmarkRequest(&net.IPNet{IP: []byte{10, 0, 0, 0}, Mask: []byte{255, 0xfc, 0, 0}})
 }
+
+func TestInvalidStrategy(t *testing.T) {
+	a, err := NewAllocator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub, Strategy: "fastest"}); err != ErrInvalidStrategy {
+		t.Fatalf("Unexpected error for invalid strategy: %v", err)
+	}
+}
+
+func TestParseSubnetInfo(t *testing.T) {
+	si, err := ParseSubnetInfo(map[string]string{"Subnet": "192.168.100.0/24", "Gateway": "192.168.100.1", "Strategy": "lru"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.Subnet.String() != "192.168.100.0/24" || !si.Gateway.Equal(net.ParseIP("192.168.100.1")) || si.Strategy != StrategyLRU {
+		t.Fatalf("Unexpected subnet information: %+v", si)
+	}
+
+	for _, tc := range []struct {
+		labels   map[string]string
+		expected error
+	}{
+		{map[string]string{"Subnet": "192.168.100.0/33"}, ErrInvalidSubnet},
+		{map[string]string{"Subnet": "192.168.100.0/24", "Gateway": "192.168.1.1"}, ErrInvalidGateway},
+		{map[string]string{"Subnet": "192.168.100.0/24", "Gateway": "192.168.100"}, ErrInvalidGateway},
+		{map[string]string{"Subnet": "192.168.100.0/24", "Strategy": "fastest"}, ErrInvalidStrategy},
+		{map[string]string{"Subnet": "192.168.100.0/24", "Strategy": "sequential"}, nil},
+	} {
+		if _, err := ParseSubnetInfo(tc.labels); err != tc.expected {
+			t.Fatalf("Unexpected error for %v: %v", tc.labels, err)
+		}
+	}
+}
+
+func TestReserveExternal(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	a := getAllocator(t, sub)
+	ctx := context.Background()
+
+	in, out := net.ParseIP("192.168.100.7"), net.ParseIP("10.0.0.7")
+	for _, ip := range []net.IP{in, out} {
+		if err := a.ReserveExternal(ctx, "default", ip, "ep1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.ReserveExternal(ctx, "default", ip, "ep1"); err != nil {
+			t.Fatalf("Address reserved again for its owner: %v", err)
+		}
+		if err := a.ReserveExternal(ctx, "default", ip, "ep2"); err != ErrIPAlreadyAllocated {
+			t.Fatalf("Expected %v, got %v", ErrIPAlreadyAllocated, err)
+		}
+	}
+	if _, err := a.Request(ctx, "default", &AddressRequest{Subnet: *sub, Address: in}); err == nil {
+		t.Fatal("Externally owned address was handed out")
+	}
+
+	a.Release(ctx, "default", in)
+	a.Release(ctx, "default", out)
+	for _, ip := range []net.IP{in, out} {
+		if err := a.ReserveExternal(ctx, "default", ip, "ep2"); err != nil {
+			t.Fatalf("Released address could not be reserved again: %v", err)
+		}
+	}
+}
+
+func TestRandomStrategy(t *testing.T) {
+	a, err := NewAllocator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub, Strategy: StrategyRandom}); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	sequential := true
+	for i := 1; i <= 254; i++ {
+		rsp, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub})
+		if err != nil {
+			t.Fatalf("Failed on request %d: %v", i, err)
+		}
+		ip := rsp.Address.String()
+		if seen[ip] {
+			t.Fatalf("Address %s handed out twice", ip)
+		}
+		if !sub.Contains(rsp.Address) || ip == "192.168.100.0" || ip == "192.168.100.255" {
+			t.Fatalf("Unexpected address %s", ip)
+		}
+		seen[ip] = true
+		if ip != fmt.Sprintf("192.168.100.%d", i) {
+			sequential = false
+		}
+	}
+	if sequential {
+		t.Fatal("Addresses were handed out sequentially")
+	}
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub}); err != ErrNoAvailableIPs {
+		t.Fatalf("Unexpected error on exhausted subnet: %v", err)
+	}
+}
+
+func TestLRUStrategy(t *testing.T) {
+	a, err := NewAllocator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, _ := net.ParseCIDR("192.168.100.0/29")
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub, Strategy: StrategyLRU}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(expected string) {
+		rsp, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Address.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, rsp.Address)
+		}
+	}
+
+	request("192.168.100.1")
+	request("192.168.100.2")
+	request("192.168.100.3")
+	a.Release(context.Background(), "default", net.ParseIP("192.168.100.2"))
+	a.Release(context.Background(), "default", net.ParseIP("192.168.100.1"))
+
+	// Addresses never handed out come first
+	for i := 4; i <= 7; i++ {
+		request(fmt.Sprintf("192.168.100.%d", i))
+	}
+	// Then the released ones, least recently released first
+	request("192.168.100.2")
+	request("192.168.100.1")
+}
+
+func TestStrategyStore(t *testing.T) {
+	_, seq, _ := net.ParseCIDR("192.168.100.0/24")
+	_, rnd, _ := net.ParseCIDR("192.168.200.0/24")
+	m := map[subnetKey]*SubnetInfo{
+		subnetKey{"default", seq.String(), ""}: {Subnet: seq},
+		subnetKey{"default", rnd.String(), ""}: {Subnet: rnd, Strategy: StrategyRandom},
+	}
+
+	b, err := subnetsToByteArray(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := byteArrayToSubnets(b)
+	if len(mm) != 2 {
+		t.Fatalf("Unexpected subnets: %v", mm)
+	}
+	for k, v := range m {
+		if mm[k] == nil || mm[k].Subnet.String() != v.Subnet.String() || mm[k].Strategy != v.Strategy {
+			t.Fatalf("Unexpected subnet for %s: %v", k.String(), mm[k])
+		}
+	}
+
+	// Subnets stored before strategies were introduced
+	mm = byteArrayToSubnets([]byte(`{"default/192.168.100.0/24":"192.168.100.0/24"}`))
+	if si := mm[subnetKey{"default", "192.168.100.0/24", ""}]; si == nil || si.Strategy != "" {
+		t.Fatalf("Unexpected legacy subnet: %v", si)
+	}
+}
+
+func TestStrategyBackend(t *testing.T) {
+	_, seq, _ := net.ParseCIDR("192.168.100.0/24")
+	_, rnd, _ := net.ParseCIDR("192.168.200.0/24")
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+
+	// An address mask stored before the strategy picked the backend
+	rndKey := subnetKey{"default", rnd.String(), rnd.String()}
+	h, err := bitseq.NewHandle(dsDataKey, ds, rndKey.String(), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.PushReservation(0, 5, false); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAllocator(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: seq}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: rnd, Strategy: StrategyRandom}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := a.addresses[subnetKey{"default", seq.String(), seq.String()}].Backend(); b != bitseq.RunLength {
+		t.Fatalf("Unexpected backend of the sequential subnet: %s", b)
+	}
+	bm := a.addresses[rndKey]
+	if b := bm.Backend(); b != bitseq.Roaring {
+		t.Fatalf("Unexpected backend of the random subnet: %s", b)
+	}
+	if _, _, err := bm.CheckIfAvailable(5); err == nil {
+		t.Fatal("The address reserved before the migration was lost")
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	a, err := NewAllocator(datastore.NewCustomDataStore(datastore.NewMockStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.Request(ctx, "default", &AddressRequest{Subnet: *sub}); err != context.Canceled {
+		t.Fatalf("Expected the request to be canceled, got %v", err)
+	}
+	if err := a.AddSubnet(ctx, "other", &SubnetInfo{Subnet: sub}); err == nil {
+		t.Fatal("Subnet added with a canceled context")
+	}
+	if _, ok := a.subnets[subnetKey{"other", sub.String(), ""}]; ok {
+		t.Fatal("Subnet of the canceled addition kept")
+	}
+
+	rsp, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.Address.Equal(net.ParseIP("192.168.100.1")) {
+		t.Fatalf("Unexpected address %s after the canceled request", rsp.Address)
+	}
+}
@@ -3,8 +3,10 @@
 package ipam
 
 import (
+	"context"
 	"errors"
 	"net"
+	"sort"
 )
 
 /**************
@@ -28,6 +30,9 @@ var (
 	ErrIPOutOfRange             = errors.New("Requested address is out of range")
 	ErrSubnetAlreadyRegistered  = errors.New("Subnet already registered on this address space")
 	ErrBadSubnet                = errors.New("Address space does not contain specified subnet")
+	ErrInvalidStrategy          = errors.New("Invalid address allocation strategy")
+	ErrInvalidGateway           = errors.New("Gateway is not in the subnet")
+	ErrInvalidFallback          = errors.New("Invalid address fallback")
 )
 
 // AddressSpace identifies a unique pool of network addresses
@@ -39,14 +44,41 @@ type AddressSpace string
 
 // Config represents the interface the IPAM service plugins must implement
 // in order to allow injection/modification of IPAM database.
-// Common key is a addressspace
+// Common key is a addressspace. The methods are passed the context of the
+// request, which the datastore operations are bound by.
 type Config interface {
 	// AddSubnet adds a subnet to the specified address space
-	AddSubnet(AddressSpace, *SubnetInfo) error
+	AddSubnet(context.Context, AddressSpace, *SubnetInfo) error
 	// RemoveSubnet removes a subnet from the specified address space
-	RemoveSubnet(AddressSpace, *net.IPNet) error
+	RemoveSubnet(context.Context, AddressSpace, *net.IPNet) error
 	// AddVendorInfo adds Vendor specific data
-	AddVendorInfo([]byte) error
+	AddVendorInfo(context.Context, []byte) error
+}
+
+// AllocationStrategy selects the address handed out for a request which
+// does not ask for a specific one
+type AllocationStrategy string
+
+const (
+	// StrategySequential hands out the lowest available address. It is the
+	// default strategy.
+	StrategySequential AllocationStrategy = "sequential"
+	// StrategyRandom hands out an available address picked at random, so
+	// that the addresses are hard to predict
+	StrategyRandom AllocationStrategy = "random"
+	// StrategyLRU hands out the available address which was released the
+	// longest ago, the addresses never handed out coming first, so that a
+	// released address is reused as late as possible
+	StrategyLRU AllocationStrategy = "lru"
+)
+
+// Validate checks the strategy is a known one
+func (s AllocationStrategy) Validate() error {
+	switch s {
+	case "", StrategySequential, StrategyRandom, StrategyLRU:
+		return nil
+	}
+	return ErrInvalidStrategy
 }
 
 // SubnetInfo contains the information subnet hosts need in order to communicate
@@ -54,6 +86,44 @@ type SubnetInfo struct {
 	Subnet     *net.IPNet
 	Gateway    net.IP
 	OpaqueData []byte // Vendor specific
+	// Strategy is the address allocation strategy of the subnet,
+	// StrategySequential if empty
+	Strategy AllocationStrategy
+}
+
+// Validate checks the subnet is set, the gateway, if any, is part of it
+// and the strategy is a known one
+func (si *SubnetInfo) Validate() error {
+	if si.Subnet == nil {
+		return ErrInvalidSubnet
+	}
+	if si.Gateway != nil && !si.Subnet.Contains(si.Gateway) {
+		return ErrInvalidGateway
+	}
+	return si.Strategy.Validate()
+}
+
+// ParseSubnetInfo builds the subnet information from its label form, with
+// the "Subnet" in CIDR notation and the optional "Gateway" and "Strategy"
+func ParseSubnetInfo(labels map[string]string) (*SubnetInfo, error) {
+	si := &SubnetInfo{Strategy: AllocationStrategy(labels["Strategy"])}
+
+	_, subnet, err := net.ParseCIDR(labels["Subnet"])
+	if err != nil {
+		return nil, ErrInvalidSubnet
+	}
+	si.Subnet = subnet
+
+	if gw, ok := labels["Gateway"]; ok {
+		if si.Gateway = net.ParseIP(gw); si.Gateway == nil {
+			return nil, ErrInvalidGateway
+		}
+	}
+
+	if err := si.Validate(); err != nil {
+		return nil, err
+	}
+	return si, nil
 }
 
 /*************************
@@ -61,23 +131,50 @@ type SubnetInfo struct {
  *************************/
 
 // IPAM defines the interface that needs to be implemented by IPAM service plugin
-// Common key is a unique address space identifier
+// Common key is a unique address space identifier. The methods are passed
+// the context of the request, which the datastore operations are bound by.
 type IPAM interface {
 	// Request address from the specified address space
-	Request(AddressSpace, *AddressRequest) (*AddressResponse, error)
+	Request(context.Context, AddressSpace, *AddressRequest) (*AddressResponse, error)
 	// Separate API for IPv6
-	RequestV6(AddressSpace, *AddressRequest) (*AddressResponse, error)
+	RequestV6(context.Context, AddressSpace, *AddressRequest) (*AddressResponse, error)
 	// Release the address from the specified address space
-	Release(AddressSpace, net.IP)
+	Release(context.Context, AddressSpace, net.IP)
 }
 
 // AddressRequest encloses the information a client
 // needs to pass to IPAM when requesting an address
 type AddressRequest struct {
-	Subnet     net.IPNet // Preferred subnet pool (Optional)
-	Address    net.IP    // Preferred address (Optional)
-	Endpoint   string    // For static IP mapping (Optional)
-	OpaqueData []byte    // Vendor specific request data
+	Subnet     net.IPNet       // Preferred subnet pool (Optional)
+	Address    net.IP          // Preferred address (Optional)
+	Fallback   AddressFallback // What to allocate if the preferred address is taken (Optional)
+	Endpoint   string          // For static IP mapping (Optional)
+	Affinity   bool            // Prefer the address last leased to the endpoint (Optional)
+	OpaqueData []byte          // Vendor specific request data
+}
+
+// AddressFallback selects the address allocated for a request whose
+// preferred address is taken
+type AddressFallback string
+
+const (
+	// FallbackNone fails the request. It is the default.
+	FallbackNone AddressFallback = ""
+	// FallbackBlock allocates another address of the block of 16 addresses,
+	// the /28 for IPv4, of the preferred one
+	FallbackBlock AddressFallback = "block"
+	// FallbackAny allocates another address of the block of the preferred
+	// one, else any address of the subnet
+	FallbackAny AddressFallback = "any"
+)
+
+// Validate checks the fallback is a known one
+func (f AddressFallback) Validate() error {
+	switch f {
+	case FallbackNone, FallbackBlock, FallbackAny:
+		return nil
+	}
+	return ErrInvalidFallback
 }
 
 // Validate runs syntactic validation on this AddressRequest object
@@ -89,6 +186,15 @@ func (req *AddressRequest) Validate() error {
 		return ErrInvalidRequest
 	}
 
+	if err := req.Fallback.Validate(); err != nil {
+		return err
+	}
+
+	// The lease is looked up by endpoint
+	if req.Affinity && req.Endpoint == "" {
+		return ErrInvalidRequest
+	}
+
 	return nil
 }
 
@@ -98,3 +204,65 @@ type AddressResponse struct {
 	Address net.IP
 	Subnet  SubnetInfo
 }
+
+// MaxTopConsumers is the number of consumers reported in a PoolStatus
+const MaxTopConsumers = 5
+
+// PoolStatus reports the usage of an address pool
+type PoolStatus struct {
+	Subnet *net.IPNet
+	// Total is the number of addresses in the pool
+	Total uint64
+	// Used is the number of allocated or reserved addresses
+	Used uint64
+	// Free is the number of addresses available for allocation
+	Free uint64
+	// FreeRanges is the number of ranges of consecutive free addresses
+	FreeRanges uint64
+	// LargestFreeRange is the size of the largest range of free addresses
+	LargestFreeRange uint64
+	// TopConsumers are the consumers holding the most addresses
+	TopConsumers []Consumer `json:",omitempty"`
+}
+
+// Fragmentation returns the share of the free addresses which are outside
+// of the largest free range, from 0 for a single free range to close to 1
+// when the free addresses are scattered
+func (p *PoolStatus) Fragmentation() float64 {
+	if p.Free == 0 {
+		return 0
+	}
+	return 1 - float64(p.LargestFreeRange)/float64(p.Free)
+}
+
+// Consumer reports the addresses held in a pool by one of its users
+type Consumer struct {
+	Name      string
+	Addresses uint64
+}
+
+// TopConsumers returns the n consumers holding the most addresses, given the
+// number of addresses held by each of them. Consumers holding as many
+// addresses are ordered by name.
+func TopConsumers(counts map[string]uint64, n int) []Consumer {
+	list := make([]Consumer, 0, len(counts))
+	for name, c := range counts {
+		list = append(list, Consumer{Name: name, Addresses: c})
+	}
+	sort.Sort(byAddresses(list))
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+type byAddresses []Consumer
+
+func (b byAddresses) Len() int      { return len(b) }
+func (b byAddresses) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byAddresses) Less(i, j int) bool {
+	if b[i].Addresses != b[j].Addresses {
+		return b[i].Addresses > b[j].Addresses
+	}
+	return b[i].Name < b[j].Name
+}
@@ -0,0 +1,116 @@
+package ipam
+
+import (
+	"context"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+)
+
+const (
+	// dsLeaseKey is the datastore key of the leases:
+	// ipam-lease/<address space>/<endpoint>
+	dsLeaseKey = "ipam-lease"
+	// fallbackBlockBits is the size, in host bits, of the block of
+	// addresses around the preferred one a fallback allocation tries first
+	fallbackBlockBits = 4
+)
+
+func leaseKey(addrSpace AddressSpace, endpoint string) string {
+	return datastore.NewKeyPath(dsLeaseKey, string(addrSpace), endpoint).String()
+}
+
+// lease returns the address last leased to the endpoint, nil if none was
+func (a *Allocator) lease(ctx context.Context, addrSpace AddressSpace, endpoint string) net.IP {
+	a.Lock()
+	ip, ok := a.leases[leaseKey(addrSpace, endpoint)]
+	ds := a.store
+	a.Unlock()
+	if ok || ds == nil {
+		return ip
+	}
+
+	kvPair, err := datastore.WithContext(ctx, ds).KVStore().Get(leaseKey(addrSpace, endpoint))
+	if err != nil {
+		if err != store.ErrKeyNotFound {
+			log.Warnf("Failed to read the address lease of endpoint %s: %v", endpoint, err)
+		}
+		return nil
+	}
+	return net.ParseIP(string(kvPair.Value))
+}
+
+// recordLease records the address leased to the endpoint, for the endpoint
+// to get it again when it is recreated. A failure to store it is logged.
+func (a *Allocator) recordLease(ctx context.Context, addrSpace AddressSpace, endpoint string, ip net.IP) {
+	a.Lock()
+	a.leases[leaseKey(addrSpace, endpoint)] = ip
+	ds := a.store
+	a.Unlock()
+	if ds == nil {
+		return
+	}
+
+	if err := datastore.WithContext(ctx, ds).KVStore().Put(leaseKey(addrSpace, endpoint), []byte(ip.String()), nil); err != nil {
+		log.Warnf("Failed to store the address lease of endpoint %s: %v", endpoint, err)
+	}
+}
+
+// ForgetLease drops the address last leased to the endpoint, for an endpoint
+// which is gone for good. The address itself is not released.
+func (a *Allocator) ForgetLease(ctx context.Context, addrSpace AddressSpace, endpoint string) error {
+	a.Lock()
+	delete(a.leases, leaseKey(addrSpace, endpoint))
+	ds := a.store
+	a.Unlock()
+	if ds == nil {
+		return nil
+	}
+
+	if err := datastore.WithContext(ctx, ds).KVStore().Delete(leaseKey(addrSpace, endpoint)); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// reserveInBlock reserves an available address of the block of the
+// preferred address within the subnet
+func (a *Allocator) reserveInBlock(ctx context.Context, addrSpace AddressSpace, subnet *net.IPNet, prefAddress net.IP, ver ipVersion) (net.IP, error) {
+	bits := len(prefAddress) * 8
+	block := &net.IPNet{IP: prefAddress.Mask(net.CIDRMask(bits-fallbackBlockBits, bits)), Mask: net.CIDRMask(bits-fallbackBlockBits, bits)}
+
+	subnetList, err := getInternalSubnets(subnet, a.internalHostSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subnetList {
+		if !s.Contains(block.IP) {
+			continue
+		}
+		key := subnetKey{addrSpace, subnet.String(), s.String()}
+		a.Lock()
+		bitmask, ok := a.addresses[key]
+		a.Unlock()
+		if !ok {
+			return nil, ErrNoAvailableIPs
+		}
+		for i := 0; i < 1<<fallbackBlockBits; i++ {
+			candidate := generateAddress(ipToInt(getHostPortionIP(block.IP, s))+i, s)
+			if !subnet.Contains(candidate) || candidate.Equal(prefAddress) {
+				continue
+			}
+			if ver == v4 && !isValidIP(ipToInt(getHostPortionIP(candidate, s))) {
+				continue
+			}
+			if ip, err := a.getAddress(ctx, key, bitmask, candidate, ver); err == nil {
+				return ip, nil
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrNoAvailableIPs
+}
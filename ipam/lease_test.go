@@ -0,0 +1,106 @@
+package ipam
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+)
+
+func TestFallback(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	a := getAllocator(t, sub)
+
+	pref := net.ParseIP("192.168.100.20")
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref}); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected the taken address to be refused, got %v", err)
+	}
+
+	// The other addresses of 192.168.100.16/28 come first
+	rsp, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref, Fallback: FallbackBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.Address.Equal(net.ParseIP("192.168.100.16")) {
+		t.Fatalf("Unexpected fallback address %s", rsp.Address)
+	}
+	for i := 17; i < 32; i++ {
+		if i == 20 {
+			continue
+		}
+		if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref, Fallback: FallbackBlock}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref, Fallback: FallbackBlock}); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected the full block to be refused, got %v", err)
+	}
+
+	rsp, err = a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Address: pref, Fallback: FallbackAny})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Contains(rsp.Address) && rsp.Address[len(rsp.Address)-1] >= 16 && rsp.Address[len(rsp.Address)-1] < 32 {
+		t.Fatalf("Unexpected fallback address %s", rsp.Address)
+	}
+
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Fallback: "nearest"}); err != ErrInvalidFallback {
+		t.Fatalf("Expected the unknown fallback to be refused, got %v", err)
+	}
+}
+
+func TestLeaseAffinity(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("192.168.100.0/24")
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+	a, err := NewAllocator(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSubnet(context.Background(), "default", &SubnetInfo{Subnet: sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Endpoint: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Endpoint: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leased := rsp.Address
+	a.Release(context.Background(), "default", leased)
+
+	// Another endpoint would get the released address, the one it was
+	// leased to gets it back, from the stored lease
+	a.Lock()
+	a.leases = make(map[string]net.IP)
+	a.Unlock()
+	rsp, err = a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Endpoint: "db", Affinity: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsp.Address.Equal(leased) {
+		t.Fatalf("Expected the leased address %s, got %s", leased, rsp.Address)
+	}
+
+	// A taken leased address is not an error
+	if err := a.ForgetLease(context.Background(), "default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	a.recordLease(context.Background(), "default", "cache", leased)
+	rsp, err = a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Endpoint: "cache", Affinity: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Address.Equal(leased) {
+		t.Fatalf("Address %s was allocated twice", leased)
+	}
+
+	if _, err := a.Request(context.Background(), "default", &AddressRequest{Subnet: *sub, Affinity: true}); err != ErrInvalidRequest {
+		t.Fatalf("Expected affinity without endpoint to be refused, got %v", err)
+	}
+}
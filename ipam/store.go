@@ -1,6 +1,7 @@
 package ipam
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 
@@ -10,17 +11,17 @@ import (
 )
 
 // Key provides the Key to be used in KV Store
-func (a *Allocator) Key() []string {
+func (a *Allocator) Key() datastore.KeyPath {
 	a.Lock()
 	defer a.Unlock()
-	return []string{a.App, a.ID}
+	return datastore.NewKeyPath(a.App, a.ID)
 }
 
 // KeyPrefix returns the immediate parent key that can be used for tree walk
-func (a *Allocator) KeyPrefix() []string {
+func (a *Allocator) KeyPrefix() datastore.KeyPath {
 	a.Lock()
 	defer a.Unlock()
-	return []string{a.App}
+	return datastore.NewKeyPath(a.App)
 }
 
 // Value marshals the data to be stored in the KV store
@@ -45,14 +46,25 @@ func (a *Allocator) SetValue(value []byte) error {
 	return nil
 }
 
+// storedSubnet is the stored form of a subnet which is not allocated with
+// the default strategy. The others are stored as their subnet string only.
+type storedSubnet struct {
+	Subnet   string
+	Strategy AllocationStrategy
+}
+
 func subnetsToByteArray(m map[subnetKey]*SubnetInfo) ([]byte, error) {
 	if m == nil {
 		return nil, nil
 	}
 
-	mm := make(map[string]string, len(m))
+	mm := make(map[string]interface{}, len(m))
 	for k, v := range m {
-		mm[k.String()] = v.Subnet.String()
+		if v.Strategy == "" || v.Strategy == StrategySequential {
+			mm[k.String()] = v.Subnet.String()
+			continue
+		}
+		mm[k.String()] = storedSubnet{Subnet: v.Subnet.String(), Strategy: v.Strategy}
 	}
 
 	return json.Marshal(mm)
@@ -65,22 +77,29 @@ func byteArrayToSubnets(ba []byte) map[subnetKey]*SubnetInfo {
 		return m
 	}
 
-	var mm map[string]string
+	var mm map[string]json.RawMessage
 	err := json.Unmarshal(ba, &mm)
 	if err != nil {
 		log.Warnf("Failed to decode subnets byte array: %v", err)
 		return m
 	}
-	for ks, vs := range mm {
+	for ks, raw := range mm {
 		sk := subnetKey{}
 		if err := sk.FromString(ks); err != nil {
-			log.Warnf("Failed to decode subnets map entry: (%s, %s)", ks, vs)
+			log.Warnf("Failed to decode subnets map entry: (%s, %s)", ks, raw)
 			continue
 		}
-		si := &SubnetInfo{}
-		_, nw, err := net.ParseCIDR(vs)
+		var ss storedSubnet
+		if err := json.Unmarshal(raw, &ss.Subnet); err != nil {
+			if err := json.Unmarshal(raw, &ss); err != nil {
+				log.Warnf("Failed to decode subnets map entry value: (%s, %s)", ks, raw)
+				continue
+			}
+		}
+		si := &SubnetInfo{Strategy: ss.Strategy}
+		_, nw, err := net.ParseCIDR(ss.Subnet)
 		if err != nil {
-			log.Warnf("Failed to decode subnets map entry value: (%s, %s)", ks, vs)
+			log.Warnf("Failed to decode subnets map entry value: (%s, %s)", ks, raw)
 			continue
 		}
 		si.Subnet = nw
@@ -116,7 +135,7 @@ func (a *Allocator) watchForChanges() error {
 		return nil
 	}
 
-	kvpChan, err := a.store.KVStore().Watch(datastore.Key(a.Key()...), nil)
+	kvpChan, err := a.store.KVStore().Watch(a.Key().String(), nil)
 	if err != nil {
 		return err
 	}
@@ -134,7 +153,7 @@ func (a *Allocator) watchForChanges() error {
 	return nil
 }
 
-func (a *Allocator) readFromStore() error {
+func (a *Allocator) readFromStore(ctx context.Context) error {
 	a.Lock()
 	store := a.store
 	a.Unlock()
@@ -143,7 +162,7 @@ func (a *Allocator) readFromStore() error {
 		return nil
 	}
 
-	kvPair, err := a.store.KVStore().Get(datastore.Key(a.Key()...))
+	kvPair, err := datastore.WithContext(ctx, store).KVStore().Get(a.Key().String())
 	if err != nil {
 		return err
 	}
@@ -153,14 +172,14 @@ func (a *Allocator) readFromStore() error {
 	return nil
 }
 
-func (a *Allocator) writeToStore() error {
+func (a *Allocator) writeToStore(ctx context.Context) error {
 	a.Lock()
 	store := a.store
 	a.Unlock()
 	if store == nil {
 		return nil
 	}
-	err := store.PutObjectAtomic(a)
+	err := datastore.WithContext(ctx, store).PutObjectAtomic(a)
 	if err == datastore.ErrKeyModified {
 		return types.RetryErrorf("failed to perform atomic write (%v). retry might fix the error", err)
 	}
@@ -0,0 +1,131 @@
+package ipam
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/docker/libnetwork/bitseq"
+)
+
+// maxRandomAttempts bounds the attempts to pick a random address while the
+// bitmask is changed by concurrent requests
+const maxRandomAttempts = 3
+
+// lruState keeps the release history of an internal subnet of a subnet with
+// the StrategyLRU strategy. The history is kept in memory only: after a
+// restart, the addresses released before are handed out lowest first, once
+// the ones never handed out since the restart are exhausted.
+type lruState struct {
+	// The ordinals from next on were not handed out yet
+	next int
+	// Released ordinals, oldest first
+	released []int
+	sync.Mutex
+}
+
+func (l *lruState) pick(bitmask *bitseq.Handle) (int, int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	for l.next < int(bitmask.Bits()) {
+		ordinal := l.next
+		l.next++
+		if bytePos, bitPos, err := bitmask.CheckIfAvailable(ordinal); err == nil {
+			return bytePos, bitPos, nil
+		}
+	}
+
+	for len(l.released) > 0 {
+		ordinal := l.released[0]
+		l.released = l.released[1:]
+		if bytePos, bitPos, err := bitmask.CheckIfAvailable(ordinal); err == nil {
+			return bytePos, bitPos, nil
+		}
+	}
+
+	return bitmask.GetFirstAvailable()
+}
+
+func (l *lruState) release(ordinal int, bits uint32) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.released = append(l.released, ordinal)
+	// Stale entries are dropped when picked, bound them anyway
+	if len(l.released) > int(bits) {
+		l.released = l.released[len(l.released)-int(bits):]
+	}
+}
+
+// subnetStrategy returns the allocation strategy of the subnet the internal
+// subnet key belongs to. Must be called with the allocator lock held.
+func (a *Allocator) subnetStrategy(key subnetKey) AllocationStrategy {
+	if info, ok := a.subnets[subnetKey{key.addressSpace, key.subnet, ""}]; ok && info.Strategy != "" {
+		return info.Strategy
+	}
+	return StrategySequential
+}
+
+// strategyBackend returns the representation of the address masks of the
+// subnets with the strategy. The addresses picked at random or least
+// recently released end up scattered, which a roaring bitmap holds in less
+// space than a run-length encoded sequence.
+func strategyBackend(strategy AllocationStrategy) bitseq.Backend {
+	switch strategy {
+	case StrategyRandom, StrategyLRU:
+		return bitseq.Roaring
+	}
+	return bitseq.RunLength
+}
+
+// lruStateOf returns the release history of the internal subnet
+func (a *Allocator) lruStateOf(key subnetKey) *lruState {
+	a.Lock()
+	defer a.Unlock()
+
+	l, ok := a.lru[key]
+	if !ok {
+		l = &lruState{}
+		a.lru[key] = l
+	}
+	return l
+}
+
+// pickAddress returns the byte and bit position of the address to hand out
+// in the internal subnet, according to the strategy
+func (a *Allocator) pickAddress(key subnetKey, bitmask *bitseq.Handle, strategy AllocationStrategy) (int, int, error) {
+	switch strategy {
+	case StrategyRandom:
+		for i := 0; i < maxRandomAttempts; i++ {
+			free := bitmask.Unselected()
+			if free == 0 {
+				break
+			}
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(free)))
+			if err != nil {
+				return -1, -1, fmt.Errorf("failed to pick a random address: %v", err)
+			}
+			if bytePos, bitPos, err := bitmask.GetNthAvailable(uint32(n.Int64())); err == nil {
+				return bytePos, bitPos, nil
+			}
+		}
+		return -1, -1, ErrNoAvailableIPs
+	case StrategyLRU:
+		return a.lruStateOf(key).pick(bitmask)
+	}
+	return bitmask.GetFirstAvailable()
+}
+
+// recordRelease adds the released address to the history of the internal
+// subnet, if its subnet has the StrategyLRU strategy
+func (a *Allocator) recordRelease(key subnetKey, bitmask *bitseq.Handle, ordinal int) {
+	a.Lock()
+	strategy := a.subnetStrategy(key)
+	a.Unlock()
+
+	if strategy == StrategyLRU {
+		a.lruStateOf(key).release(ordinal, bitmask.Bits())
+	}
+}
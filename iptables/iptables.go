@@ -35,11 +35,14 @@ const (
 
 var (
 	iptablesPath  string
+	ip6tablesPath string
 	supportsXlock = false
 	// used to lock iptables commands if xtables lock is not supported
 	bestEffortLock sync.Mutex
 	// ErrIptablesNotFound is returned when the rule is not found.
 	ErrIptablesNotFound = errors.New("Iptables not found")
+	// ErrIp6tablesNotFound is returned when ip6tables is not found.
+	ErrIp6tablesNotFound = errors.New("Ip6tables not found")
 )
 
 // Chain defines the iptables chain.
@@ -97,31 +100,20 @@ func NewChain(name, bridge string, table Table, hairpinMode bool) (*Chain, error
 
 	switch table {
 	case Nat:
-		preroute := []string{
-			"-m", "addrtype",
-			"--dst-type", "LOCAL",
-			"-j", c.Name}
+		preroute := c.preroutingJump()
 		if !Exists(Nat, "PREROUTING", preroute...) {
 			if err := c.Prerouting(Append, preroute...); err != nil {
 				return nil, fmt.Errorf("Failed to inject docker in PREROUTING chain: %s", err)
 			}
 		}
-		output := []string{
-			"-m", "addrtype",
-			"--dst-type", "LOCAL",
-			"-j", c.Name}
-		if !hairpinMode {
-			output = append(output, "!", "--dst", "127.0.0.0/8")
-		}
+		output := c.outputJump()
 		if !Exists(Nat, "OUTPUT", output...) {
 			if err := c.Output(Append, output...); err != nil {
 				return nil, fmt.Errorf("Failed to inject docker in OUTPUT chain: %s", err)
 			}
 		}
 	case Filter:
-		link := []string{
-			"-o", c.Bridge,
-			"-j", c.Name}
+		link := c.forwardJump()
 		if !Exists(Filter, "FORWARD", link...) {
 			insert := append([]string{string(Insert), "FORWARD"}, link...)
 			if output, err := Raw(insert...); err != nil {
@@ -134,6 +126,50 @@ func NewChain(name, bridge string, table Table, hairpinMode bool) (*Chain, error
 	return c, nil
 }
 
+// SetupRules returns the commands NewChain runs for the chain, when neither
+// the chain nor its jump rules exist yet
+func (c *Chain) SetupRules() [][]string {
+	table := c.Table
+	if string(table) == "" {
+		table = Filter
+	}
+
+	rules := [][]string{{"-t", string(table), "-N", c.Name}}
+	switch table {
+	case Nat:
+		rules = append(rules,
+			append([]string{"-t", string(Nat), string(Append), "PREROUTING"}, c.preroutingJump()...),
+			append([]string{"-t", string(Nat), string(Append), "OUTPUT"}, c.outputJump()...))
+	case Filter:
+		rules = append(rules, append([]string{string(Insert), "FORWARD"}, c.forwardJump()...))
+	}
+	return rules
+}
+
+func (c *Chain) preroutingJump() []string {
+	return []string{
+		"-m", "addrtype",
+		"--dst-type", "LOCAL",
+		"-j", c.Name}
+}
+
+func (c *Chain) outputJump() []string {
+	output := []string{
+		"-m", "addrtype",
+		"--dst-type", "LOCAL",
+		"-j", c.Name}
+	if !c.HairpinMode {
+		output = append(output, "!", "--dst", "127.0.0.0/8")
+	}
+	return output
+}
+
+func (c *Chain) forwardJump() []string {
+	return []string{
+		"-o", c.Bridge,
+		"-j", c.Name}
+}
+
 // RemoveExistingChain removes existing chain from the table.
 func RemoveExistingChain(name string, table Table) error {
 	c := &Chain{
@@ -148,6 +184,19 @@ func RemoveExistingChain(name string, table Table) error {
 
 // Forward adds forwarding rule to 'filter' table and corresponding nat rule to 'nat' table.
 func (c *Chain) Forward(action Action, ip net.IP, port int, proto, destAddr string, destPort int) error {
+	for _, args := range c.ForwardRules(action, ip, port, proto, destAddr, destPort) {
+		if output, err := Raw(args...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return ChainError{Chain: "FORWARD", Output: output}
+		}
+	}
+
+	return nil
+}
+
+// ForwardRules returns the commands Forward runs to forward the port
+func (c *Chain) ForwardRules(action Action, ip net.IP, port int, proto, destAddr string, destPort int) [][]string {
 	daddr := ip.String()
 	if ip.IsUnspecified() {
 		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
@@ -155,45 +204,32 @@ func (c *Chain) Forward(action Action, ip net.IP, port int, proto, destAddr stri
 		// value" by both iptables and ip6tables.
 		daddr = "0/0"
 	}
-	args := []string{"-t", string(Nat), string(action), c.Name,
+	dnat := []string{"-t", string(Nat), string(action), c.Name,
 		"-p", proto,
 		"-d", daddr,
 		"--dport", strconv.Itoa(port),
 		"-j", "DNAT",
 		"--to-destination", net.JoinHostPort(destAddr, strconv.Itoa(destPort))}
 	if !c.HairpinMode {
-		args = append(args, "!", "-i", c.Bridge)
-	}
-	if output, err := Raw(args...); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return ChainError{Chain: "FORWARD", Output: output}
+		dnat = append(dnat, "!", "-i", c.Bridge)
 	}
 
-	if output, err := Raw("-t", string(Filter), string(action), c.Name,
-		"!", "-i", c.Bridge,
-		"-o", c.Bridge,
-		"-p", proto,
-		"-d", destAddr,
-		"--dport", strconv.Itoa(destPort),
-		"-j", "ACCEPT"); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return ChainError{Chain: "FORWARD", Output: output}
-	}
-
-	if output, err := Raw("-t", string(Nat), string(action), "POSTROUTING",
-		"-p", proto,
-		"-s", destAddr,
-		"-d", destAddr,
-		"--dport", strconv.Itoa(destPort),
-		"-j", "MASQUERADE"); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return ChainError{Chain: "FORWARD", Output: output}
+	return [][]string{
+		dnat,
+		{"-t", string(Filter), string(action), c.Name,
+			"!", "-i", c.Bridge,
+			"-o", c.Bridge,
+			"-p", proto,
+			"-d", destAddr,
+			"--dport", strconv.Itoa(destPort),
+			"-j", "ACCEPT"},
+		{"-t", string(Nat), string(action), "POSTROUTING",
+			"-p", proto,
+			"-s", destAddr,
+			"-d", destAddr,
+			"--dport", strconv.Itoa(destPort),
+			"-j", "MASQUERADE"},
 	}
-
-	return nil
 }
 
 // Link adds reciprocal ACCEPT rule for two supplied IP addresses.
@@ -263,6 +299,10 @@ func (c *Chain) Remove() error {
 		c.Prerouting(Delete)
 		c.Output(Delete)
 	}
+	// The jump of the traffic of the bridge, if the chain has one
+	if c.Table == Filter && c.Bridge != "" {
+		Raw(append([]string{string(Delete), "FORWARD"}, c.forwardJump()...)...)
+	}
 	Raw("-t", string(c.Table), "-F", c.Name)
 	Raw("-t", string(c.Table), "-X", c.Name)
 	return nil
@@ -292,6 +332,53 @@ func Exists(table Table, chain string, rule ...string) bool {
 	return strings.Contains(string(existingRules), ruleString)
 }
 
+// Exists6 checks if an ip6tables rule exists
+func Exists6(table Table, chain string, rule ...string) bool {
+	if string(table) == "" {
+		table = Filter
+	}
+
+	_, err := Raw6(append([]string{"-t", string(table), "-C", chain}, rule...)...)
+	return err == nil
+}
+
+// ListRules returns the rules of the chain of the table, each as the
+// arguments following the chain name in the output of `iptables -S`
+func ListRules(table Table, chain string) ([][]string, error) {
+	return listRules(Raw, table, chain)
+}
+
+// ListRules6 returns the rules of the chain of the table, as ListRules does
+// for ip6tables
+func ListRules6(table Table, chain string) ([][]string, error) {
+	return listRules(Raw6, table, chain)
+}
+
+func listRules(raw func(...string) ([]byte, error), table Table, chain string) ([][]string, error) {
+	if string(table) == "" {
+		table = Filter
+	}
+	output, err := raw("-t", string(table), "-S", chain)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(string(output), chain), nil
+}
+
+// parseRules returns the arguments of the rules of the chain appended in the
+// output of `iptables -S`
+func parseRules(output, chain string) [][]string {
+	var rules [][]string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != string(Append) || fields[1] != chain {
+			continue
+		}
+		rules = append(rules, fields[2:])
+	}
+	return rules
+}
+
 // Raw calls 'iptables' system command, passing supplied arguments.
 func Raw(args ...string) ([]byte, error) {
 	if firewalldRunning {
@@ -302,9 +389,42 @@ func Raw(args ...string) ([]byte, error) {
 
 	}
 
+	return RawLocal(args...)
+}
+
+// RawLocal calls 'iptables' system command without going through firewalld,
+// so that the rules are programmed in the network namespace of the calling
+// thread rather than in the one of the host.
+func RawLocal(args ...string) ([]byte, error) {
 	if err := initCheck(); err != nil {
 		return nil, err
 	}
+	return execute("iptables", iptablesPath, args...)
+}
+
+// Raw6 calls 'ip6tables' system command, passing supplied arguments.
+func Raw6(args ...string) ([]byte, error) {
+	if firewalldRunning {
+		output, err := Passthrough(IP6Tables, args...)
+		if err == nil || !strings.Contains(err.Error(), "was not provided by any .service files") {
+			return output, err
+		}
+	}
+
+	if err := initCheck(); err != nil {
+		return nil, err
+	}
+	if ip6tablesPath == "" {
+		path, err := exec.LookPath("ip6tables")
+		if err != nil {
+			return nil, ErrIp6tablesNotFound
+		}
+		ip6tablesPath = path
+	}
+	return execute("ip6tables", ip6tablesPath, args...)
+}
+
+func execute(name, path string, args ...string) ([]byte, error) {
 	if supportsXlock {
 		args = append([]string{"--wait"}, args...)
 	} else {
@@ -312,11 +432,11 @@ func Raw(args ...string) ([]byte, error) {
 		defer bestEffortLock.Unlock()
 	}
 
-	logrus.Debugf("%s, %v", iptablesPath, args)
+	logrus.Debugf("%s, %v", path, args)
 
-	output, err := exec.Command(iptablesPath, args...).CombinedOutput()
+	output, err := exec.Command(path, args...).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("iptables failed: iptables %v: %s (%s)", strings.Join(args, " "), output, err)
+		return nil, fmt.Errorf("%s failed: %s %v: %s (%s)", name, name, strings.Join(args, " "), output, err)
 	}
 
 	// ignore iptables' message about xtables lock
@@ -228,3 +228,16 @@ func TestCleanup(t *testing.T) {
 		t.Fatalf("Removing chain failed. %s found in iptables-save", chainName)
 	}
 }
+
+func TestParseRules(t *testing.T) {
+	output := `-P FORWARD ACCEPT
+-N DOCKER
+-A FORWARD -o docker0 -j DOCKER
+-A DOCKER -d 172.17.0.2/32 ! -i docker0 -o docker0 -p tcp -m tcp --dport 80 -j ACCEPT
+-A FORWARD -i docker0 ! -o docker0 -j ACCEPT
+`
+	rules := parseRules(output, "FORWARD")
+	if len(rules) != 2 || strings.Join(rules[0], " ") != "-o docker0 -j DOCKER" || strings.Join(rules[1], " ") != "-i docker0 ! -o docker0 -j ACCEPT" {
+		t.Fatalf("Unexpected rules %q", rules)
+	}
+}
@@ -0,0 +1,57 @@
+package libnetwork
+
+// labelIndex indexes the networks of the controller by the key and the
+// value of their labels, so that the lookups do not walk every network. It
+// is kept along with the networks table, under the controller lock. The
+// labels of a network are set on its creation and never change, they are
+// read without the network lock.
+type labelIndex map[string]map[string]networkTable
+
+// setNetwork adds the network to the networks of the controller and to the
+// label index. Called with the controller lock held.
+func (c *controller) setNetwork(n *network) {
+	c.networks[n.id] = n
+	if c.networkLabels == nil {
+		c.networkLabels = labelIndex{}
+	}
+	for k, v := range n.labels {
+		values, ok := c.networkLabels[k]
+		if !ok {
+			values = map[string]networkTable{}
+			c.networkLabels[k] = values
+		}
+		if values[v] == nil {
+			values[v] = networkTable{}
+		}
+		values[v][n.id] = n
+	}
+}
+
+// unsetNetwork removes the network from the networks of the controller and
+// from the label index. Called with the controller lock held.
+func (c *controller) unsetNetwork(n *network) {
+	delete(c.networks, n.id)
+	for k, v := range n.labels {
+		nws := c.networkLabels[k][v]
+		delete(nws, n.id)
+		if len(nws) != 0 {
+			continue
+		}
+		delete(c.networkLabels[k], v)
+		if len(c.networkLabels[k]) == 0 {
+			delete(c.networkLabels, k)
+		}
+	}
+}
+
+func (c *controller) NetworksByLabel(key, value string) []Network {
+	c.Lock()
+	defer c.Unlock()
+
+	nws := c.networkLabels[key][value]
+	list := make([]Network, 0, len(nws))
+	for _, n := range nws {
+		list = append(list, n)
+	}
+	return list
+}
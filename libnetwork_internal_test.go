@@ -1,10 +1,26 @@
 package libnetwork
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
 )
 
 func TestDriverRegistration(t *testing.T) {
@@ -30,3 +46,2459 @@ func SetTestDataStore(c NetworkController, custom datastore.DataStore) {
 	con := c.(*controller)
 	con.store = custom
 }
+
+type localDriver struct {
+	networks map[types.UUID]map[string]interface{}
+}
+
+func (d *localDriver) Config(options map[string]interface{}) error {
+	return nil
+}
+
+func (d *localDriver) CreateNetwork(ctx context.Context, nid types.UUID, options map[string]interface{}) error {
+	d.networks[nid] = options
+	return nil
+}
+
+func (d *localDriver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
+	delete(d.networks, nid)
+	return nil
+}
+
+func (d *localDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *localDriver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	return nil
+}
+
+func (d *localDriver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (d *localDriver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *localDriver) Leave(ctx context.Context, nid, eid types.UUID) error {
+	return nil
+}
+
+func (d *localDriver) Type() string {
+	return "local"
+}
+
+func TestGlobalScopeNetwork(t *testing.T) {
+	ms := datastore.NewMockStore()
+
+	var (
+		ctrlrs  []*controller
+		drivers []*localDriver
+	)
+	for i := 0; i < 2; i++ {
+		c, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetTestDataStore(c, datastore.NewCustomDataStore(ms))
+		d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+		if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+			t.Fatal(err)
+		}
+		ctrlrs = append(ctrlrs, c.(*controller))
+		drivers = append(drivers, d)
+	}
+
+	generic := map[string]interface{}{netlabel.GenericData: map[string]string{"BridgeName": "br-global"}}
+	n1, err := ctrlrs[0].NewNetwork(context.Background(), "local", "net1", NetworkOptionGeneric(generic), NetworkOptionGlobalScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drivers[0].networks) != 1 {
+		t.Fatal("Network was not created on the defining host")
+	}
+
+	// The other host learns about the network from the store
+	nws, err := ctrlrs[1].getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrlrs[1].processNetworkUpdate(nws, nil)
+	n2, err := ctrlrs[1].NetworkByID(n1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drivers[1].networks) != 0 {
+		t.Fatal("Network was created on the other host before first use")
+	}
+
+	ep, err := n2.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, ok := drivers[1].networks[types.UUID(n1.ID())]
+	if !ok {
+		t.Fatal("Network was not created on first use")
+	}
+	if !reflect.DeepEqual(opts, drivers[0].networks[types.UUID(n1.ID())]) {
+		t.Fatalf("Hosts created different networks: %v and %v", opts, drivers[0].networks[types.UUID(n1.ID())])
+	}
+
+	// Endpoints stay local
+	if ok, _ := ms.Exists(ep.(*endpoint).Key().String()); ok {
+		t.Fatal("Endpoint of a global scope network was stored")
+	}
+
+	if err := ep.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := ms.Exists(n1.(*network).Key().String()); ok {
+		t.Fatal("Network was not removed from the store")
+	}
+}
+
+func TestReadOnlyController(t *testing.T) {
+	if _, err := New(config.OptionReadOnly()); err == nil {
+		t.Fatal("Expected a read-only controller without a datastore to fail")
+	}
+
+	ms := datastore.NewMockStore()
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTestDataStore(w, datastore.NewCustomDataStore(ms))
+	wd := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := w.(*controller).RegisterDriver("local", wd, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n1, err := w.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, err := n1.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &controller{
+		cfg:       &config.Config{Daemon: config.DaemonCfg{ReadOnly: true}},
+		networks:  networkTable{},
+		sandboxes: sandboxTable{},
+		drivers:   driverTable{},
+		store:     datastore.ReadOnly(datastore.NewCustomDataStore(ms)),
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.processNetworkUpdate(nws, nil)
+	n2, err := c.NetworkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &endpoint{id: types.UUID(ep1.ID()), network: n2.(*network)}
+	if err := c.store.GetObject(ep.Key().String(), ep); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.newEndpointFromStore("", ep); err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n2.EndpointByName("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.networks) != 0 {
+		t.Fatal("Read-only controller created the network in the driver")
+	}
+
+	if _, err := c.NewNetwork(context.Background(), "local", "net2"); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly creating a network, got %v", err)
+	}
+	if _, err := n2.CreateEndpoint(context.Background(), "ep2"); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly creating an endpoint, got %v", err)
+	}
+	if err := ep2.Join(context.Background(), "container1"); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly joining an endpoint, got %v", err)
+	}
+	if err := ep2.Delete(context.Background()); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly deleting an endpoint, got %v", err)
+	}
+	if err := n2.Delete(context.Background()); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly deleting a network, got %v", err)
+	}
+	if _, err := c.VerifyStore(context.Background(), true); err != (ErrReadOnly{}) {
+		t.Fatalf("Expected ErrReadOnly repairing the store, got %v", err)
+	}
+	if _, err := c.VerifyStore(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{n1.(*network).Key().String(), ep1.(*endpoint).Key().String()} {
+		if ok, _ := ms.Exists(key); !ok {
+			t.Fatalf("Key %s was removed by the read-only controller", key)
+		}
+	}
+}
+
+func TestReadOnlyDriversInit(t *testing.T) {
+	// The commands run by the drivers are recorded by stand-ins found first
+	// in the PATH
+	dir, err := ioutil.TempDir("", "readonly-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "commands")
+	for _, cmd := range []string{"modprobe", "iptables", "ip6tables"} {
+		script := fmt.Sprintf("#!/bin/sh\necho %s \"$@\" >> %s\n", cmd, log)
+		if err := ioutil.WriteFile(filepath.Join(dir, cmd), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	c := &controller{
+		cfg:       &config.Config{Daemon: config.DaemonCfg{ReadOnly: true}},
+		networks:  networkTable{},
+		sandboxes: sandboxTable{},
+		drivers:   driverTable{},
+		unloaded:  driverTable{},
+	}
+	if err := initDrivers(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.drivers["bridge"]; !ok {
+		t.Fatal("Bridge driver was not registered")
+	}
+	if b, err := ioutil.ReadFile(log); err == nil {
+		t.Fatalf("Read-only controller ran commands on the host:\n%s", b)
+	}
+}
+
+func TestNetworkDNS(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	n1, err := c.NewNetwork(context.Background(), "local", "net1",
+		NetworkOptionDNSSearch("one.example.com", "example.com"), NetworkOptionDNSOptions("ndots:2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := c.NewNetwork(context.Background(), "local", "net2",
+		NetworkOptionDNSSearch("two.example.com", "example.com"), NetworkOptionDNSOptions("ndots:3", "rotate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "libnetwork-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The resolv.conf does not depend on the order the networks are joined in
+	joinOrders := [][]Network{{n1, n2}, {n2, n1}}
+	var contents []string
+	for i, nws := range joinOrders {
+		cid := fmt.Sprintf("dns_container%d", i)
+		path := filepath.Join(tmp, cid, "resolv.conf")
+		for _, n := range nws {
+			ep, err := n.CreateEndpoint(context.Background(), cid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ep.Delete(context.Background())
+			if err := ep.Join(context.Background(), cid, JoinOptionResolvConfPath(path), JoinOptionDNSOptions("timeout:1")); err != nil {
+				t.Fatal(err)
+			}
+			defer ep.Leave(context.Background(), cid)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+
+	for _, content := range contents {
+		if !strings.Contains(content, "search one.example.com example.com two.example.com\n") ||
+			!strings.Contains(content, "options timeout:1 ndots:2 rotate\n") {
+			t.Fatalf("Unexpected resolv.conf:\n%s", content)
+		}
+	}
+	if contents[0] != contents[1] {
+		t.Fatalf("Join order changed the resolv.conf:\n%s\n%s", contents[0], contents[1])
+	}
+
+	// The search domains and options of a network go with its endpoint
+	ep, err := n2.EndpointByName("dns_container0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave(context.Background(), "dns_container0"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmp, "dns_container0", "resolv.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "search one.example.com example.com\n") ||
+		!strings.Contains(string(b), "options timeout:1 ndots:2\n") {
+		t.Fatalf("Unexpected resolv.conf after leaving a network:\n%s", b)
+	}
+
+	// The DNS configuration of the network is stored with it
+	var restored network
+	b, err = json.Marshal(n2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.dnsSearch, n2.(*network).dnsSearch) || !reflect.DeepEqual(restored.dnsOptions, n2.(*network).dnsOptions) {
+		t.Fatalf("Failed to restore the DNS configuration of the network: %v %v", restored.dnsSearch, restored.dnsOptions)
+	}
+}
+
+type planningDriver struct {
+	localDriver
+}
+
+func (d *planningDriver) PlanNetwork(nid types.UUID, options map[string]interface{}) (*driverapi.Plan, error) {
+	return &driverapi.Plan{Devices: []string{"br-planned"}}, nil
+}
+
+func (d *planningDriver) PlanEndpoint(nid, eid types.UUID, options map[string]interface{}) (*driverapi.Plan, error) {
+	if _, ok := d.networks[nid]; !ok {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+	return &driverapi.Plan{Rules: []string{"-t nat -A DOCKER"}}, nil
+}
+
+func TestDryRun(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &planningDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}
+	if err := c.(*controller).RegisterDriver("planning", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	ld := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", ld, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	var result DryRunResult
+	n, err := c.NewNetwork(context.Background(), "planning", "net1", NetworkOptionDryRun(&result))
+	if err != nil || n != nil {
+		t.Fatalf("Unexpected dry run outcome: %v, %v", n, err)
+	}
+	if result.Driver != "planning" || result.Plan == nil || result.Plan.Devices[0] != "br-planned" {
+		t.Fatalf("Unexpected dry run result: %+v", result)
+	}
+	if len(d.networks) != 0 || len(c.Networks()) != 0 {
+		t.Fatal("Network was created by the dry run")
+	}
+
+	if _, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionDryRun(&DryRunResult{})); err == nil {
+		t.Fatal("Dry run succeeded on a driver which does not support it")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Unexpected error type for an unsupported dry run: %v", err)
+	}
+
+	n, err = c.NewNetwork(context.Background(), "planning", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = DryRunResult{}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1", CreateOptionDryRun(&result))
+	if err != nil || ep != nil {
+		t.Fatalf("Unexpected dry run outcome: %v, %v", ep, err)
+	}
+	if result.Plan == nil || len(result.Plan.Rules) != 1 {
+		t.Fatalf("Unexpected dry run result: %+v", result)
+	}
+	if len(n.Endpoints()) != 0 || n.(*network).EndpointCnt() != 0 {
+		t.Fatal("Endpoint was created by the dry run")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	for _, withStore := range []bool{false, true} {
+		c, err := New(config.OptionAuditRetention(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withStore {
+			SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+		}
+		d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+		if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := WithActor(context.Background(), "operator")
+		n, err := c.NewNetwork(ctx, "local", "net1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep, err := n.CreateEndpoint(context.Background(), "ep1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n.CreateEndpoint(context.Background(), "ep1"); err == nil {
+			t.Fatal("Endpoint with a duplicate name was created")
+		}
+
+		records, err := n.AuditLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("Unexpected audit log: %+v", records)
+		}
+		if r := records[0]; r.Operation != AuditNetworkCreate || r.Actor != "operator" || r.Target != "net1" || r.Params["type"] != "local" {
+			t.Fatalf("Unexpected network creation record: %+v", r)
+		}
+		if r := records[1]; r.Operation != AuditEndpointCreate || r.Target != "ep1" || r.Params["id"] != ep.ID() || r.Error != "" {
+			t.Fatalf("Unexpected endpoint creation record: %+v", r)
+		}
+		if r := records[2]; r.Operation != AuditEndpointCreate || r.Error == "" {
+			t.Fatalf("Failed endpoint creation was not recorded: %+v", r)
+		}
+
+		// The oldest records are dropped beyond the retention
+		if err := ep.Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+		records, err = n.AuditLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 || records[0].Operation != AuditEndpointCreate || records[2].Operation != AuditEndpointDelete {
+			t.Fatalf("Audit log was not trimmed: %+v", records)
+		}
+
+		// The log outlives the network, its deletion recorded
+		if err := n.Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+		records, err = n.AuditLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := records[len(records)-1]; r.Operation != AuditNetworkDelete || r.Error != "" {
+			t.Fatalf("Network deletion was not recorded: %+v", records)
+		}
+
+		// A failed creation is recorded under the id the network was given
+		if _, err := c.NewNetwork(ctx, "local", "net2", NetworkOptionMaxEndpoints(-1)); err == nil {
+			t.Fatal("Network with a negative maximum of endpoints was created")
+		}
+		logs, err := c.AuditLogs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 2 || len(logs[n.ID()]) != 3 {
+			t.Fatalf("Unexpected audit logs: %+v", logs)
+		}
+		for nid, records := range logs {
+			if nid == n.ID() {
+				continue
+			}
+			if len(records) != 1 || records[0].Operation != AuditNetworkCreate || records[0].Target != "net2" || records[0].Params["id"] != nid || records[0].Error == "" {
+				t.Fatalf("Failed network creation was not recorded: %+v", records)
+			}
+		}
+	}
+}
+
+// tenantAuthorizer lets the actors operate on the networks of their tenant
+type tenantAuthorizer struct {
+	requests []AuthzRequest
+}
+
+func (a *tenantAuthorizer) Authorize(ctx context.Context, req AuthzRequest) error {
+	a.requests = append(a.requests, req)
+	if req.Tenant != req.Actor {
+		return fmt.Errorf("%s is not a member of tenant %q", req.Actor, req.Tenant)
+	}
+	return nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &localDriver{networks: make(map[types.UUID]map[string]interface{})}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	a := &tenantAuthorizer{}
+	c.SetAuthorizer(a)
+
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+	if _, err := c.NewNetwork(bob, "local", "net1", NetworkOptionTenant("alice")); err == nil {
+		t.Fatal("Network created in the tenant of another actor")
+	} else if _, ok := err.(*NotAuthorizedError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	n, err := c.NewNetwork(alice, "local", "net1", NetworkOptionTenant("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := n.CreateEndpoint(bob, "ep1"); err == nil {
+		t.Fatal("Endpoint created in the network of another tenant")
+	}
+	ep, err := n.CreateEndpoint(alice, "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := a.requests[len(a.requests)-1]
+	if req.Operation != AuditEndpointCreate || req.Network != "net1" || req.Endpoint != "ep1" || req.NetworkType != "local" {
+		t.Fatalf("Unexpected request %+v", req)
+	}
+	if err := ep.Delete(bob); err == nil {
+		t.Fatal("Endpoint deleted by another tenant")
+	}
+	// The denied operations are audited
+	records, err := n.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := records[len(records)-1]; last.Operation != AuditEndpointDelete || last.Actor != "bob" || last.Error == "" {
+		t.Fatalf("Denied deletion not audited: %+v", last)
+	}
+
+	if err := n.AddServiceRecord(context.Background(), "web", net.ParseIP("10.0.0.9")); err == nil {
+		t.Fatal("Service record added without an actor")
+	}
+	if err := n.AddServiceRecord(alice, "db", net.ParseIP("10.0.0.10")); err != nil {
+		t.Fatal(err)
+	}
+	if req := a.requests[len(a.requests)-1]; req.Operation != AuthzNetworkServices || req.Actor != "alice" {
+		t.Fatalf("Unexpected request %+v", req)
+	}
+
+	// Only the repair of the datastore is authorized
+	cnt := len(a.requests)
+	if _, err := c.VerifyStore(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.requests) != cnt {
+		t.Fatalf("Store verification was authorized: %+v", a.requests[cnt:])
+	}
+	if _, err := c.VerifyStore(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.requests) != cnt+1 || a.requests[cnt].Operation != AuthzStoreRepair {
+		t.Fatalf("Store repair was not authorized: %+v", a.requests[cnt:])
+	}
+	c.SetAuthorizer(nil)
+	if err := n.AddServiceRecord(context.Background(), "web", net.ParseIP("10.0.0.9")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Delete(bob); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPodSandboxRelease(t *testing.T) {
+	ep1, ep2 := &endpoint{name: "ep1"}, &endpoint{name: "ep2"}
+	marks := map[*endpoint]uint32{}
+	if m := lowestFreeMark(marks); m != 1 {
+		t.Fatalf("Unexpected first mark: %d", m)
+	}
+	marks[ep1], marks[ep2] = 1, 2
+	delete(marks, ep1)
+	if m := lowestFreeMark(marks); m != 1 {
+		t.Fatalf("Released mark was not handed out again: %d", m)
+	}
+
+	key := podSandboxKey("pod1")
+	sb, err := sandbox.NewSandbox(key, true)
+	if err != nil {
+		t.Skipf("Cannot create a sandbox: %v", err)
+	}
+	c := &controller{sandboxes: sandboxTable{}}
+	sData := &sandboxData{sbox: sb, refCnt: 2, pod: true, marks: marks}
+	c.sandboxes[key] = sData
+
+	c.sandboxRelease(key, sData)
+	if _, ok := c.sandboxes[key]; !ok {
+		t.Fatal("Pod sandbox was destroyed while still referenced")
+	}
+	c.sandboxRelease(key, sData)
+	if _, ok := c.sandboxes[key]; ok {
+		t.Fatal("Pod sandbox was not destroyed with its last reference")
+	}
+	sandbox.GC()
+	if _, err := os.Stat(key); err == nil {
+		t.Fatal("Namespace of the pod was not removed")
+	}
+}
+
+func TestReloadResolvConf(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "libnetwork-dns-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	joinOptions := map[string][]EndpointOption{
+		"reloaded":  nil,
+		"searching": {JoinOptionDNSSearch("example.com")},
+		"pinned":    {JoinOptionDNS("10.1.1.1")},
+		"edited":    nil,
+	}
+	paths := make(map[string]string)
+	for cid, opts := range joinOptions {
+		paths[cid] = filepath.Join(tmp, cid, "resolv.conf")
+		ep, err := n.CreateEndpoint(context.Background(), cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Delete(context.Background())
+		if err := ep.Join(context.Background(), cid, append(opts, JoinOptionResolvConfPath(paths[cid]))...); err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Leave(context.Background(), cid)
+	}
+	if err := ioutil.WriteFile(paths["edited"], []byte("nameserver 10.9.9.9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.(*controller).reloadResolvConf([]byte("nameserver 10.2.2.2\nsearch vpn.example.com\n"))
+
+	for cid, expected := range map[string]string{
+		"reloaded":  "nameserver 10.2.2.2\n",
+		"searching": "nameserver 10.2.2.2\n",
+		"pinned":    "nameserver 10.1.1.1\n",
+		"edited":    "nameserver 10.9.9.9\n",
+	} {
+		b, err := ioutil.ReadFile(paths[cid])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), expected) {
+			t.Fatalf("Unexpected resolv.conf of container %s:\n%s", cid, b)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []HookEvent
+	record := func(ctx context.Context, ev HookEvent) error {
+		events = append(events, ev)
+		return nil
+	}
+	for _, p := range []HookPoint{HookPreCreateNetwork, HookPostCreateNetwork, HookPreCreateEndpoint, HookPostCreateEndpoint} {
+		c.RegisterHook(p, record)
+	}
+	errDenied := errors.New("denied by policy")
+	c.RegisterHook(HookPreCreateEndpoint, func(ctx context.Context, ev HookEvent) error {
+		if ev.Name == "forbidden" {
+			return errDenied
+		}
+		return nil
+	})
+	c.RegisterHook(HookPostCreateEndpoint, func(ctx context.Context, ev HookEvent) error {
+		return errors.New("post hook errors are only logged")
+	})
+
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "forbidden"); err != errDenied {
+		t.Fatalf("Expected the pre hook to abort the endpoint creation, got %v", err)
+	}
+	if _, err := n.EndpointByName("forbidden"); err == nil {
+		t.Fatal("Endpoint denied by a pre hook was created")
+	}
+
+	expected := []HookPoint{HookPreCreateNetwork, HookPostCreateNetwork, HookPreCreateEndpoint, HookPostCreateEndpoint, HookPreCreateEndpoint}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected hook events: %+v", events)
+	}
+	for i, p := range expected {
+		if events[i].Point != p {
+			t.Fatalf("Expected event %d at %s, got %s", i, p, events[i].Point)
+		}
+	}
+	if events[0].Network != nil || events[1].Network == nil || events[1].Network.ID() != n.ID() {
+		t.Fatalf("Unexpected network in the creation events: %+v", events[:2])
+	}
+	if ev := events[3]; ev.Endpoint == nil || ev.Endpoint.ID() != ep.ID() || ev.NetworkType != "local" || ev.Err != nil {
+		t.Fatalf("Unexpected endpoint creation event: %+v", ev)
+	}
+
+	// Both the pre and the post hooks of a dry run are told about it
+	events = nil
+	if _, err := n.CreateEndpoint(context.Background(), "ep2", CreateOptionDryRun(&DryRunResult{})); err == nil {
+		t.Fatal("Dry run succeeded on a driver which does not support it")
+	}
+	if len(events) != 2 || !events[0].DryRun || !events[1].DryRun || events[1].Point != HookPostCreateEndpoint || events[1].Err == nil {
+		t.Fatalf("Unexpected dry run hook events: %+v", events)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "forbidden", CreateOptionDryRun(&DryRunResult{})); err != errDenied {
+		t.Fatalf("Expected the pre hook to abort the dry run, got %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Post hooks ran for a dry run aborted by a pre hook: %+v", events)
+	}
+}
+
+func TestNetworkEventHooks(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []HookEvent
+	c.RegisterHook(HookNetworkEvent, func(ctx context.Context, ev HookEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notifier driverapi.NetworkEventNotifier = c.(*controller)
+	notifier.NotifyNetworkEvent(types.UUID(n.ID()), "gateway-state", map[string]string{"state": "master"})
+	notifier.NotifyNetworkEvent("unknown", "gateway-state", nil)
+
+	if len(events) != 1 {
+		t.Fatalf("Unexpected network events: %+v", events)
+	}
+	if ev := events[0]; ev.Name != "net1" || ev.NetworkType != "local" || ev.Event != "gateway-state" || ev.Attributes["state"] != "master" {
+		t.Fatalf("Unexpected network event: %+v", ev)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw := n.(*network)
+	nw.Lock()
+	nw.svcRecords["ep1"] = []net.IP{net.ParseIP("172.20.0.2")}
+	nw.Unlock()
+
+	// Snapshots are taken while the state changes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			other, err := c.NewNetwork(context.Background(), "local", fmt.Sprintf("other%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := other.Delete(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := c.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		// Nor do they deadlock with the store checks
+		if _, err := c.VerifyStore(context.Background(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	b, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Time.IsZero() || len(snap.Networks) != 1 || snap.Sandboxes == nil {
+		t.Fatalf("Unexpected snapshot: %s", b)
+	}
+	ns := snap.Networks[0]
+	restored := &network{}
+	if err := restored.UnmarshalJSON(ns.Network); err != nil {
+		t.Fatal(err)
+	}
+	if restored.name != "net1" || len(ns.Endpoints) != 1 {
+		t.Fatalf("Unexpected network snapshot: %s", b)
+	}
+	restoredEp := &endpoint{}
+	if err := restoredEp.UnmarshalJSON(ns.Endpoints[0]); err != nil {
+		t.Fatal(err)
+	}
+	if string(restoredEp.id) != ep.ID() {
+		t.Fatalf("Unexpected endpoint snapshot: %s", ns.Endpoints[0])
+	}
+	if ips := ns.ServiceRecords["ep1"]; len(ips) != 1 || ips[0] != "172.20.0.2" {
+		t.Fatalf("Unexpected service records: %v", ns.ServiceRecords)
+	}
+}
+
+func TestPublishedPortConflicts(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	n1, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := c.NewNetwork(context.Background(), "local", "net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n1.CreateEndpoint(context.Background(), "ep1", CreateOptionPortMapping([]types.PortBinding{
+		{Proto: types.TCP, Port: 80, HostPort: 8080},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conflicting := []types.PortBinding{
+		{Proto: types.TCP, Port: 80, HostIP: net.ParseIP("10.0.0.1"), HostPort: 8080},
+		{Proto: types.TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}, HostPort: 8080},
+		{Proto: types.TCP, Port: 80, HostIP: net.IPv6unspecified, HostPort: 8080},
+	}
+	for _, b := range conflicting {
+		if _, err := n2.CreateEndpoint(context.Background(), "ep2", CreateOptionPortMapping([]types.PortBinding{b})); err == nil {
+			t.Fatalf("Expected binding %v to conflict", b)
+		} else if _, ok := err.(types.ForbiddenError); !ok {
+			t.Fatalf("Unexpected error type for binding %v: %v", b, err)
+		}
+		if _, err := n2.CreateEndpoint(context.Background(), "ep2", CreateOptionPortMapping([]types.PortBinding{b}), CreateOptionDryRun(&DryRunResult{})); err == nil {
+			t.Fatalf("Expected the dry run of binding %v to conflict", b)
+		} else if _, ok := err.(types.ForbiddenError); !ok {
+			t.Fatalf("Unexpected error type for the dry run of binding %v: %v", b, err)
+		}
+	}
+
+	distinct := []types.PortBinding{
+		{Proto: types.UDP, Port: 80, HostPort: 8080},
+		{Proto: types.TCP, Port: 80, HostPort: 8081},
+		{Proto: types.TCP, Port: 80, HostIPv6Only: true, HostPort: 8080},
+		{Proto: types.TCP, Port: 80, HostPort: 0},
+	}
+	for i, b := range distinct {
+		ep, err := n2.CreateEndpoint(context.Background(), fmt.Sprintf("ep%d", i+2), CreateOptionPortMapping([]types.PortBinding{b}))
+		if err != nil {
+			t.Fatalf("Binding %v failed: %v", b, err)
+		}
+		if err := ep.Delete(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ports are released with the endpoint
+	if err := ep1.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n2.CreateEndpoint(context.Background(), "ep2", CreateOptionPortMapping(conflicting[:1])); err != nil {
+		t.Fatalf("Binding after the release failed: %v", err)
+	}
+}
+
+// addrDriver is a local driver giving an IPv4 address to the endpoints
+type addrDriver struct {
+	localDriver
+}
+
+func (d *addrDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	_, addr, _ := net.ParseCIDR("192.168.100.2/24")
+	return epInfo.AddInterface(1, nil, *addr, net.IPNet{})
+}
+
+// optionsDriver records the options its endpoints are created with
+type optionsDriver struct {
+	addrDriver
+	options map[string]interface{}
+}
+
+func (d *optionsDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	d.options = options
+	return d.addrDriver.CreateEndpoint(ctx, nid, eid, epInfo, options)
+}
+
+type recordingPublisher struct {
+	published   []PublishedPort
+	unpublished []PublishedPort
+	err         error
+}
+
+func (p *recordingPublisher) PublishPort(ctx context.Context, pp PublishedPort) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, pp)
+	return nil
+}
+
+func (p *recordingPublisher) UnpublishPort(ctx context.Context, pp PublishedPort) error {
+	p.unpublished = append(p.unpublished, pp)
+	return nil
+}
+
+func TestPortPublisher(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &optionsDriver{addrDriver: addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := &recordingPublisher{}
+	c.SetPortPublisher(pub, true)
+	bindings := []types.PortBinding{{Proto: types.TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, HostPort: 8080}}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1", CreateOptionPortMapping(bindings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.options[netlabel.PortMap]; ok {
+		t.Fatal("Driver was passed the port bindings published externally")
+	}
+	if len(pub.published) != 2 {
+		t.Fatalf("Expected 2 published ports, got %v", pub.published)
+	}
+	p := pub.published[1]
+	if p.Endpoint != "ep1" || p.Network != "net1" || !p.HostIP.Equal(net.ParseIP("10.0.0.2")) || p.HostPort != 8080 ||
+		!p.ContainerIP.Equal(net.ParseIP("192.168.100.0")) || p.ContainerPort != 80 || p.Proto != types.TCP {
+		t.Fatalf("Unexpected published port %+v", p)
+	}
+
+	if err := ep.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pub.unpublished, pub.published) {
+		t.Fatalf("Expected the ports to be unpublished, got %v", pub.unpublished)
+	}
+
+	// A port which cannot be published fails the endpoint creation
+	pub.err = errors.New("load balancer unreachable")
+	if _, err := n.CreateEndpoint(context.Background(), "ep2", CreateOptionPortMapping(bindings)); err != pub.err {
+		t.Fatalf("Expected the publisher error, got %v", err)
+	}
+	if _, err := n.EndpointByName("ep2"); err == nil {
+		t.Fatal("Endpoint was created although its ports were not published")
+	}
+
+	// The drivers map the ports locally unless they are only published
+	// externally
+	c.SetPortPublisher(NopPortPublisher{}, false)
+	if _, err := n.CreateEndpoint(context.Background(), "ep3", CreateOptionPortMapping(bindings)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.options[netlabel.PortMap]; !ok {
+		t.Fatal("Driver was not passed the port bindings")
+	}
+}
+
+// connectivityDriver records the external connectivity of its endpoints
+type connectivityDriver struct {
+	optionsDriver
+	enabled []bool
+}
+
+func (d *connectivityDriver) SetExternalConnectivity(nid, eid types.UUID, enable bool) error {
+	d.enabled = append(d.enabled, enable)
+	return nil
+}
+
+func TestDeferPortBinding(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &connectivityDriver{optionsDriver: optionsDriver{addrDriver: addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := &recordingPublisher{}
+	c.SetPortPublisher(pub, false)
+	bindings := []types.PortBinding{{Proto: types.TCP, Port: 80, HostPort: 8080}}
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1", CreateOptionPortMapping(bindings), CreateOptionDeferPortBinding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deferred, _ := d.options[netlabel.DeferPortBinding].(bool); !deferred {
+		t.Fatal("Driver was not asked to defer the port binding")
+	}
+	if len(pub.published) != 0 {
+		t.Fatalf("Deferred ports were published: %v", pub.published)
+	}
+
+	// The deferred host port is not reserved
+	ep2, err := n.CreateEndpoint(context.Background(), "ep2", CreateOptionPortMapping(bindings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ep1.ActivatePorts(context.Background()); err == nil {
+		t.Fatal("Ports overlapping the ports of another endpoint were activated")
+	}
+	if err := ep2.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pub.published, pub.unpublished = nil, nil
+
+	if err := ep1.ActivatePorts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.enabled, []bool{true}) {
+		t.Fatalf("Driver was not asked to bind the ports: %v", d.enabled)
+	}
+	if len(pub.published) != 1 || pub.published[0].HostPort != 8080 {
+		t.Fatalf("Activated ports were not published: %v", pub.published)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep3", CreateOptionPortMapping(bindings)); err == nil {
+		t.Fatal("Endpoint overlapping the activated ports was created")
+	}
+
+	// Activating the ports twice binds them once
+	if err := ep1.ActivatePorts(context.Background()); err != nil || len(d.enabled) != 1 {
+		t.Fatalf("Ports were activated again: %v %v", err, d.enabled)
+	}
+
+	ep1.(*endpoint).deactivatePorts()
+	if !reflect.DeepEqual(d.enabled, []bool{true, false}) || len(pub.unpublished) != 1 {
+		t.Fatalf("Ports were not unbound: %v %v", d.enabled, pub.unpublished)
+	}
+
+	// The drivers which cannot bind the ports later cannot defer them
+	c.(*controller).RegisterDriver("plain", &optionsDriver{addrDriver: addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}, driverapi.Capability{Scope: driverapi.LocalScope})
+	n2, err := c.NewNetwork(context.Background(), "plain", "net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n2.CreateEndpoint(context.Background(), "ep4", CreateOptionPortMapping(bindings), CreateOptionDeferPortBinding()); err == nil {
+		t.Fatal("Ports were deferred on a driver which cannot bind them later")
+	}
+}
+
+type busyDriver struct {
+	localDriver
+}
+
+func (d *busyDriver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	return types.ForbiddenErrorf("endpoint %s is busy", eid)
+}
+
+func TestForceDeleteEndpoint(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &busyDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A container joined on another host has not left the endpoint
+	ep.(*endpoint).container = &containerInfo{id: "container1"}
+	if err := ep.Delete(context.Background()); err == nil {
+		t.Fatal("Endpoint with a container was deleted")
+	} else if _, ok := err.(*ActiveContainerError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	err = ep.Delete(context.Background(), DeleteOptionForce())
+	ide, ok := err.(*IncompleteDeleteError)
+	if !ok {
+		t.Fatalf("Expected the driver failure to be reported, got %v", err)
+	}
+	if len(ide.Skipped) != 1 || !strings.Contains(ide.Skipped[0], "busy") {
+		t.Fatalf("Unexpected skipped cleanups %v", ide.Skipped)
+	}
+	if _, err := n.EndpointByID(ep.ID()); err == nil {
+		t.Fatal("Endpoint was not deleted")
+	}
+	if err := n.Delete(context.Background()); err != nil {
+		t.Fatalf("Network of the deleted endpoint could not be deleted: %v", err)
+	}
+}
+
+// externalDriver adopts an interface attached to its networks outside of it
+type externalDriver struct {
+	localDriver
+}
+
+func (d *externalDriver) ExternalEndpoints(nid types.UUID) ([]driverapi.ExternalEndpoint, error) {
+	ip, addr, _ := net.ParseCIDR("192.168.100.2/24")
+	addr.IP = ip
+	return []driverapi.ExternalEndpoint{{ID: "external-veth0", Name: "veth0", Addresses: []*net.IPNet{addr}}}, nil
+}
+
+func TestExternalEndpoints(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &externalDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := n.EndpointByID("external-veth0")
+	if err != nil {
+		t.Fatalf("Adopted interface was not recorded: %v", err)
+	}
+	ep := e.(*endpoint)
+	if !ep.external || ep.Name() != "veth0" || ep.iFaces[0].addr.String() != "192.168.100.2/24" {
+		t.Fatalf("Unexpected external endpoint %s %v", ep.Name(), ep.iFaces[0].addr)
+	}
+	if cnt := n.(*network).EndpointCnt(); cnt != 0 {
+		t.Fatalf("External endpoint was counted in the network: %d", cnt)
+	}
+
+	b, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded endpoint
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.external {
+		t.Fatal("External mark was not kept in the store")
+	}
+
+	if err := ep.Join(context.Background(), "container1"); err == nil {
+		t.Fatal("External endpoint was joined")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := ep.Delete(context.Background()); err == nil {
+		t.Fatal("External endpoint was deleted")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if err := n.Delete(context.Background()); err != nil {
+		t.Fatalf("Network with an external endpoint could not be deleted: %v", err)
+	}
+	if len(n.Endpoints()) != 0 {
+		t.Fatal("External endpoint was not removed along with its network")
+	}
+}
+
+func TestConfigOnlyNetwork(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	template := map[string]interface{}{
+		netlabel.EnableIPv6:  true,
+		netlabel.GenericData: map[string]interface{}{"Subnet": "10.1.0.0/16", "BridgeName": "cfg"},
+	}
+	cfg, err := c.NewNetwork(context.Background(), "local", "cfg", NetworkOptionConfigOnly(), NetworkOptionGeneric(template), NetworkOptionDNSSearch("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ConfigOnly() || len(d.networks) != 0 {
+		t.Fatal("Configuration network was created in the driver")
+	}
+	if _, err := cfg.CreateEndpoint(context.Background(), "ep1"); err == nil {
+		t.Fatal("Endpoint was created on a configuration network")
+	}
+
+	n1, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionConfigFrom("cfg"),
+		NetworkOptionGeneric(map[string]interface{}{netlabel.GenericData: map[string]interface{}{"BridgeName": "br1"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := d.networks[types.UUID(n1.ID())]
+	data, _ := opts[netlabel.GenericData].(map[string]interface{})
+	if opts[netlabel.EnableIPv6] != true || data["Subnet"] != "10.1.0.0/16" || data["BridgeName"] != "br1" {
+		t.Fatalf("Network was not instantiated from its configuration: %v", opts)
+	}
+	if n1.ConfigFrom() != "cfg" || !reflect.DeepEqual(n1.(*network).dnsSearch, []string{"example.com"}) {
+		t.Fatalf("Unexpected network configuration %q %v", n1.ConfigFrom(), n1.(*network).dnsSearch)
+	}
+
+	if _, err := c.NewNetwork(context.Background(), "local", "net2", NetworkOptionConfigFrom("net1")); err == nil {
+		t.Fatal("Network was created from a network which is not a configuration network")
+	}
+	if _, err := c.NewNetwork(context.Background(), "local", "net3", NetworkOptionConfigFrom("none")); err == nil {
+		t.Fatal("Network was created from a missing configuration network")
+	}
+
+	if err := cfg.Delete(context.Background()); err == nil {
+		t.Fatal("Configuration network in use was deleted")
+	}
+	if err := n1.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	for _, withStore := range []bool{false, true} {
+		c, err := New(config.OptionTenantQuota("t1", config.Quota{Networks: 2, EndpointsPerNetwork: 2, Addresses: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withStore {
+			SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+		}
+		d := &addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}
+		if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+			t.Fatal(err)
+		}
+
+		n1, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionTenant("t1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n2, err := c.NewNetwork(context.Background(), "local", "net2", NetworkOptionTenant("t1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.NewNetwork(context.Background(), "local", "net3", NetworkOptionTenant("t1"))
+		if _, ok := err.(*QuotaExceededError); !ok {
+			t.Fatalf("Expected the network quota to be exceeded, got %v", err)
+		}
+		// Networks without tenant are not accounted
+		if _, err := c.NewNetwork(context.Background(), "local", "net4"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := n1.CreateEndpoint(context.Background(), "ep1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n1.CreateEndpoint(context.Background(), "ep2"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = n1.CreateEndpoint(context.Background(), "ep3")
+		if _, ok := err.(*QuotaExceededError); !ok {
+			t.Fatalf("Expected the endpoints per network quota to be exceeded, got %v", err)
+		}
+		if _, err := n1.EndpointByName("ep3"); err == nil {
+			t.Fatal("Endpoint beyond the quota was kept")
+		}
+
+		ep4, err := n2.CreateEndpoint(context.Background(), "ep4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = n2.CreateEndpoint(context.Background(), "ep5")
+		if _, ok := err.(*QuotaExceededError); !ok {
+			t.Fatalf("Expected the address quota to be exceeded, got %v", err)
+		}
+		if nws, addrs, err := c.QuotaUsage("t1"); err != nil || nws != 2 || addrs != 3 {
+			t.Fatalf("Unexpected usage %d networks %d addresses: %v", nws, addrs, err)
+		}
+
+		if err := ep4.Delete(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := n2.Delete(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if nws, addrs, err := c.QuotaUsage("t1"); err != nil || nws != 1 || addrs != 2 {
+			t.Fatalf("Unexpected usage after the deletions %d networks %d addresses: %v", nws, addrs, err)
+		}
+		if _, err := c.NewNetwork(context.Background(), "local", "net3", NetworkOptionTenant("t1")); err != nil {
+			t.Fatalf("Network creation failed after the release: %v", err)
+		}
+	}
+}
+
+// secondaryDriver gives its endpoints a secondary address
+type secondaryDriver struct {
+	addrDriver
+}
+
+func (d *secondaryDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	if err := d.addrDriver.CreateEndpoint(ctx, nid, eid, epInfo, options); err != nil {
+		return err
+	}
+	_, addr, _ := net.ParseCIDR("192.168.100.3/24")
+	return epInfo.(driverapi.SecondaryAddressAdder).AddSecondaryAddress(1, *addr)
+}
+
+func TestTenantQuotaSecondary(t *testing.T) {
+	c, err := New(config.OptionTenantQuota("t1", config.Quota{Addresses: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &secondaryDriver{addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionTenant("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, addrs, err := c.QuotaUsage("t1"); err != nil || addrs != 2 {
+		t.Fatalf("Unexpected usage %d addresses: %v", addrs, err)
+	}
+	_, err = n.CreateEndpoint(context.Background(), "ep2")
+	if _, ok := err.(*QuotaExceededError); !ok {
+		t.Fatalf("Expected the secondary address to exceed the address quota, got %v", err)
+	}
+}
+
+func TestMaxEndpoints(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NewNetwork(context.Background(), "local", "bad", NetworkOptionMaxEndpoints(-1)); err == nil {
+		t.Fatal("Expected the negative cap to be refused")
+	}
+
+	n, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionMaxEndpoints(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep2"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.CreateEndpoint(context.Background(), "ep3")
+	if _, ok := err.(*EndpointLimitError); !ok {
+		t.Fatalf("Expected the endpoint cap to be reached, got %v", err)
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected a forbidden error, got %T", err)
+	}
+	if cnt := n.(*network).EndpointCnt(); cnt != 2 || len(n.Endpoints()) != 2 {
+		t.Fatalf("Endpoint beyond the cap was counted or kept: %d", cnt)
+	}
+
+	// The cap is kept with the network
+	sn := &network{ctrlr: c.(*controller)}
+	if err := sn.SetValue(n.(*network).Value()); err != nil {
+		t.Fatal(err)
+	}
+	if sn.maxEndpoints != 2 {
+		t.Fatalf("Unexpected cap %d read back from the store", sn.maxEndpoints)
+	}
+
+	if err := ep1.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep3"); err != nil {
+		t.Fatalf("Endpoint creation failed after a deletion: %v", err)
+	}
+
+	// A failed store update gives the count back
+	gn, err := c.NewNetwork(context.Background(), "local", "net2", NetworkOptionGlobalScope(), NetworkOptionMaxEndpoints(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gn.CreateEndpoint(ctx, "ep1"); err == nil {
+		t.Fatal("Expected the endpoint creation to fail with the store update")
+	}
+	if cnt := gn.(*network).EndpointCnt(); cnt != 0 {
+		t.Fatalf("Endpoint count %d leaked by the failed creation", cnt)
+	}
+	if _, err := gn.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatalf("Endpoint creation failed after a failed one: %v", err)
+	}
+}
+
+type restoreDriver struct {
+	addrDriver
+	restored []driverapi.RestoredEndpoint
+}
+
+func (d *restoreDriver) RestoreEndpoints(ctx context.Context, endpoints []driverapi.RestoredEndpoint) error {
+	d.restored = append(d.restored, endpoints...)
+	return nil
+}
+
+func TestRestoreEndpoints(t *testing.T) {
+	ms := datastore.NewMockStore()
+
+	c1, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTestDataStore(c1, datastore.NewCustomDataStore(ms))
+	d1 := &restoreDriver{addrDriver: addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}
+	if err := c1.(*controller).RegisterDriver("local", d1, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d1.restored) != 0 {
+		t.Fatalf("Unexpected endpoints restored to the driver: %v", d1.restored)
+	}
+
+	n, err := c1.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted daemon hands the persisted endpoints over to the driver
+	c2, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTestDataStore(c2, datastore.NewCustomDataStore(ms))
+	d2 := &restoreDriver{addrDriver: addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}}
+	if err := c2.(*controller).RegisterDriver("local", d2, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d2.restored) != 1 {
+		t.Fatalf("Expected one endpoint restored to the driver, got %v", d2.restored)
+	}
+	rec := d2.restored[0]
+	if string(rec.NetworkID) != n.ID() || string(rec.EndpointID) != ep.ID() {
+		t.Fatalf("Unexpected endpoint restored: %v", rec)
+	}
+	if len(rec.Interfaces) != 1 || rec.Interfaces[0].Address.String() != "192.168.100.0/24" {
+		t.Fatalf("Unexpected interfaces restored: %v", rec.Interfaces)
+	}
+}
+
+func TestEndpointSecondaryAddresses(t *testing.T) {
+	ep := &endpoint{id: "ep1"}
+	if err := ep.AddInterface(1, nil, net.IPNet{IP: net.ParseIP("192.168.100.2"), Mask: net.CIDRMask(24, 32)}, net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []string{"192.168.100.20/24", "2001:db8::20/64"} {
+		addr, _ := types.ParseCIDR(a)
+		if err := ep.AddSecondaryAddress(1, *addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ep.AddSecondaryAddress(2, net.IPNet{IP: net.ParseIP("192.168.100.21"), Mask: net.CIDRMask(24, 32)}); err == nil {
+		t.Fatal("Secondary address of an unknown interface was recorded")
+	}
+
+	b, err := json.Marshal(ep.iFaces[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := &endpointInterface{}
+	if err := json.Unmarshal(b, iface); err != nil {
+		t.Fatal(err)
+	}
+	secondary := iface.SecondaryAddresses()
+	if len(secondary) != 2 || secondary[0].String() != "192.168.100.20/24" || secondary[1].String() != "2001:db8::20/64" {
+		t.Fatalf("Unexpected secondary addresses after unmarshal: %v", secondary)
+	}
+
+	ips := iface.recordAddresses()
+	if len(ips) != 3 || ips[0].String() != "192.168.100.2" {
+		t.Fatalf("Unexpected record addresses: %v", ips)
+	}
+}
+
+type deferredAddrDriver struct {
+	localDriver
+}
+
+func (d *deferredAddrDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	return epInfo.AddInterface(1, nil, net.IPNet{}, net.IPNet{})
+}
+
+func TestDriverAssignedAddress(t *testing.T) {
+	c, err := New(config.OptionTenantQuota("t1", config.Quota{Addresses: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &deferredAddrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}
+	if err := c.(*controller).RegisterDriver("dhcp", d, driverapi.Capability{Scope: driverapi.LocalScope, DriverAssignsAddress: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("static", &addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork(context.Background(), "dhcp", "net1", NetworkOptionTenant("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e1, err := n.CreateEndpoint(context.Background(), "ep1b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw, ep := n.(*network), e.(*endpoint)
+	if len(nw.svcRecords) != 0 {
+		t.Fatalf("Records published for an endpoint without address: %v", nw.svcRecords)
+	}
+	if _, addrs, err := c.QuotaUsage("t1"); err != nil || addrs != 0 {
+		t.Fatalf("Expected no address accounted before join, got %d: %v", addrs, err)
+	}
+
+	// As the driver does on join
+	addr, _ := types.ParseCIDR("10.1.0.5/24")
+	if err := ep.SetInterfaceAddress(1, *addr, net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e1.(*endpoint).SetInterfaceAddress(1, *addr, net.IPNet{}); err == nil {
+		t.Fatal("Address assigned to an endpoint was recorded for another one")
+	}
+	if err := ep.chargeQuota(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, addrs, err := c.QuotaUsage("t1"); err != nil || addrs != 1 {
+		t.Fatalf("Expected the assigned address to be accounted, got %d: %v", addrs, err)
+	}
+	addr1, _ := types.ParseCIDR("10.1.0.6/24")
+	if err := e1.(*endpoint).SetInterfaceAddress(1, *addr1, net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e1.(*endpoint).chargeQuota(context.Background()).(*QuotaExceededError); !ok {
+		t.Fatal("Expected the address quota to be exceeded")
+	}
+	e1.(*endpoint).releaseDriverAssignedAddresses()
+	nw.updateSvcRecord(ep, true)
+	if ips := nw.svcRecords["ep1"]; len(ips) != 1 || ips[0].String() != "10.1.0.5" {
+		t.Fatalf("Unexpected records of the endpoint: %v", ips)
+	}
+	b, err := json.Marshal(ep.iFaces[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := &endpointInterface{}
+	if err := json.Unmarshal(b, iface); err != nil {
+		t.Fatal(err)
+	}
+	if !iface.driverAssigned || iface.addr.String() != "10.1.0.5/24" {
+		t.Fatalf("Driver assigned address was not persisted: %s", b)
+	}
+
+	// And on leave
+	nw.updateSvcRecord(ep, false)
+	ep.releaseDriverAssignedAddresses()
+	if len(nw.svcRecords) != 0 || len(ep.iFaces[0].addr.IP) != 0 {
+		t.Fatalf("Driver assigned address was not released: %v, %v", nw.svcRecords, ep.iFaces[0].addr)
+	}
+	if err := ep.chargeQuota(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, addrs, err := c.QuotaUsage("t1"); err != nil || addrs != 0 {
+		t.Fatalf("Expected the released address to be given back, got %d: %v", addrs, err)
+	}
+	if err := e1.(*endpoint).SetInterfaceAddress(1, *addr, net.IPNet{}); err != nil {
+		t.Fatalf("Released address could not be assigned to another endpoint: %v", err)
+	}
+
+	n2, err := c.NewNetwork(context.Background(), "static", "net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := n2.CreateEndpoint(context.Background(), "ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e2.(*endpoint).SetInterfaceAddress(1, *addr, net.IPNet{}); err == nil {
+		t.Fatal("Address was set through a driver which does not assign them")
+	}
+}
+
+func TestServiceRegistration(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw := n.(*network)
+
+	if _, err := n.CreateEndpoint(context.Background(), "bad", CreateOptionServiceRegistration("later")); err == nil {
+		t.Fatal("Endpoint with an invalid service registration was created")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	e, err := n.CreateEndpoint(context.Background(), "ep1", CreateOptionServiceRegistration(RegisterOnActivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep2", CreateOptionServiceRegistration(RegisterNever)); err != nil {
+		t.Fatal(err)
+	}
+	ep3, err := n.CreateEndpoint(context.Background(), "ep3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nw.svcRecords["ep3"]; !ok || len(nw.svcRecords) != 2 {
+		t.Fatalf("Unexpected records: %v", nw.svcRecords)
+	}
+	if err := ep3.Activate(context.Background()); err == nil {
+		t.Fatal("Endpoint registered on creation was activated")
+	}
+
+	// The endpoint is activated once its container joined and is ready
+	ep := e.(*endpoint)
+	if err := ep.Activate(context.Background()); err == nil {
+		t.Fatal("Endpoint without a container was activated")
+	}
+	ep.container = &containerInfo{id: "c1"}
+	if _, ok := nw.svcRecords["ep1"]; ok {
+		t.Fatal("Records published for a joined endpoint not activated")
+	}
+	if err := ep.Activate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nw.svcRecords["ep1"]; !ok {
+		t.Fatal("Records not published for the activated endpoint")
+	}
+	if _, ok := nw.svcRecords["ep2"]; ok {
+		t.Fatal("Records published for an endpoint never registered")
+	}
+
+	// The activation is kept in the store
+	var loaded endpoint
+	if err := json.Unmarshal(ep.Value(), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.serviceActive || loaded.serviceRegistration() != RegisterOnActivate {
+		t.Fatalf("Unexpected endpoint read back: %t %s", loaded.serviceActive, loaded.serviceRegistration())
+	}
+
+	// The deactivation is authorized as an operation of its own, for the
+	// actor of the caller
+	a := &tenantAuthorizer{}
+	c.SetAuthorizer(a)
+	if err := ep.Deactivate(WithActor(context.Background(), "bob")); err == nil {
+		t.Fatal("Endpoint deactivated by another tenant")
+	}
+	if req := a.requests[0]; req.Operation != AuthzEndpointDeactivate || req.Actor != "bob" || req.Endpoint != "ep1" {
+		t.Fatalf("Unexpected request %+v", req)
+	}
+	if err := ep.Deactivate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetAuthorizer(nil)
+	if _, ok := nw.svcRecords["ep1"]; ok {
+		t.Fatal("Records kept for the deactivated endpoint")
+	}
+
+	// The leave of the container unregisters the endpoint, which must be
+	// activated again by the next container
+	if err := ep.Activate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ep.container = nil
+	ep.unregisterOnLeave()
+	if _, ok := nw.svcRecords["ep1"]; ok || ep.serviceActive {
+		t.Fatal("Records kept for the endpoint left by its container")
+	}
+
+	// The join, activation and leave done on another host are followed
+	remote := &endpoint{id: ep.id, network: nw, container: &containerInfo{id: "c2"}, serviceActive: true}
+	remote.SetIndex(ep.Index() + 1)
+	c.(*controller).processEndpointUpdate(remote)
+	if _, ok := nw.svcRecords["ep1"]; !ok {
+		t.Fatal("Records not published for the endpoint activated on another host")
+	}
+	remote = &endpoint{id: ep.id, network: nw}
+	remote.SetIndex(ep.Index() + 1)
+	c.(*controller).processEndpointUpdate(remote)
+	if _, ok := nw.svcRecords["ep1"]; ok {
+		t.Fatal("Records kept for the endpoint left on another host")
+	}
+}
+
+func TestServiceRecords(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "svcrecords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostsPath := filepath.Join(dir, "hosts")
+	if err := etchosts.Build(hostsPath, "", "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	cEp := ep.(*endpoint)
+	cEp.Lock()
+	cEp.container = &containerInfo{id: "c1"}
+	cEp.container.config.hostsPath = hostsPath
+	cEp.Unlock()
+
+	checkHosts := func(present, absent []string) {
+		b, err := ioutil.ReadFile(hostsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range present {
+			if !strings.Contains(string(b), r+"\n") {
+				t.Fatalf("Missing %q in the hosts file:\n%s", r, b)
+			}
+		}
+		for _, r := range absent {
+			if strings.Contains(string(b), r+"\n") {
+				t.Fatalf("Unexpected %q in the hosts file:\n%s", r, b)
+			}
+		}
+	}
+
+	web1, web2, db := net.ParseIP("10.1.0.1"), net.ParseIP("10.1.0.2"), net.ParseIP("10.2.0.1")
+	if err := n.SyncServiceRecords(context.Background(), map[string][]net.IP{"web": {web1, web2}, "db": {db}}); err != nil {
+		t.Fatal(err)
+	}
+	checkHosts([]string{"10.1.0.1\tweb", "10.1.0.2\tweb", "10.1.0.1\tweb.net1", "10.2.0.1\tdb"}, nil)
+
+	// A backend leaving only removes its own records
+	if err := n.DeleteServiceRecord(context.Background(), "web", web1); err != nil {
+		t.Fatal(err)
+	}
+	checkHosts([]string{"10.1.0.2\tweb", "10.2.0.1\tdb"}, []string{"10.1.0.1\tweb", "10.1.0.1\tweb.net1"})
+
+	web3 := net.ParseIP("10.1.0.3")
+	if err := n.AddServiceRecord(context.Background(), "web", web3); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.AddServiceRecord(context.Background(), "web", web3); err != nil {
+		t.Fatal(err)
+	}
+	checkHosts([]string{"10.1.0.2\tweb", "10.1.0.3\tweb"}, nil)
+
+	// The records missing from a sync are removed, the others are kept
+	if err := n.SyncServiceRecords(context.Background(), map[string][]net.IP{"web": {web2, web3}}); err != nil {
+		t.Fatal(err)
+	}
+	checkHosts([]string{"10.1.0.2\tweb", "10.1.0.3\tweb"}, []string{"10.2.0.1\tdb"})
+
+	recs := n.(*network).getSvcRecords()
+	if len(recs) != 4 {
+		t.Fatalf("Unexpected service records %v", recs)
+	}
+
+	// The records are kept with the network
+	b, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &network{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if ips := restored.services["web"]; len(restored.services) != 1 || len(ips) != 2 || !containsIP(ips, web2) || !containsIP(ips, web3) {
+		t.Fatalf("Unexpected service records restored: %v", restored.services)
+	}
+
+	// The concurrent changes all make it to the hosts file
+	var (
+		wg      sync.WaitGroup
+		entries []string
+	)
+	for i := 0; i < 20; i++ {
+		ip := net.IPv4(10, 3, 0, byte(i+1))
+		entries = append(entries, ip.String()+"\tcache")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.AddServiceRecord(context.Background(), "cache", ip); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	checkHosts(entries, nil)
+
+	for _, name := range []string{"", "web app"} {
+		if err := n.AddServiceRecord(context.Background(), name, web1); err == nil {
+			t.Fatalf("Invalid service name %q was accepted", name)
+		}
+	}
+	if err := n.SyncServiceRecords(context.Background(), map[string][]net.IP{"web": {nil}}); err == nil {
+		t.Fatal("Invalid service address was accepted")
+	}
+}
+
+func TestNetworkZone(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.AddServiceRecord(context.Background(), "db", net.ParseIP("10.1.0.9")); err != nil {
+		t.Fatal(err)
+	}
+
+	z := n.Zone("example.com.")
+	if z.Origin != "net1.example.com." || len(z.Records) != 2 || z.Records[0].Name != "db" || z.Records[1].Name != "ep1" {
+		t.Fatalf("Unexpected zone %+v", z)
+	}
+	if n.Zone("example.com").Serial != z.Serial {
+		t.Fatal("Serial increased without a change of the records")
+	}
+	if err := n.DeleteServiceRecord(context.Background(), "db", net.ParseIP("10.1.0.9")); err != nil {
+		t.Fatal(err)
+	}
+	if z2 := n.Zone("example.com"); z2.Serial != z.Serial+1 || len(z2.Records) != 1 {
+		t.Fatalf("Unexpected zone after the change %+v", z2)
+	}
+}
+
+func TestServiceVIPPool(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &addrDriver{localDriver{networks: make(map[types.UUID]map[string]interface{})}}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n1, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n1.AllocateServiceVIP(context.Background(), "web"); err == nil {
+		t.Fatal("VIP allocated on a network without VIP pool")
+	}
+
+	_, pool, _ := net.ParseCIDR("10.250.0.0/30")
+	n2, err := c.NewNetwork(context.Background(), "local", "net2", NetworkOptionServiceVIPPool(pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vip, err := n2.AllocateServiceVIP(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pool.Contains(vip) {
+		t.Fatalf("VIP %s out of the pool", vip)
+	}
+	if again, err := n2.AllocateServiceVIP(context.Background(), "web"); err != nil || !again.Equal(vip) {
+		t.Fatalf("Service got another VIP %s: %v", again, err)
+	}
+	if ips := n2.(*network).services["web"]; len(ips) != 1 || !ips[0].Equal(vip) {
+		t.Fatalf("Service does not resolve to its VIP: %v", ips)
+	}
+	// The allocator only leaves the network address of the pool out
+	for _, name := range []string{"db", "cache"} {
+		if _, err := n2.AllocateServiceVIP(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := n2.AllocateServiceVIP(context.Background(), "queue"); err == nil {
+		t.Fatal("VIP allocated from an exhausted pool")
+	}
+
+	usage, err := c.AddressUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage.Networks) != 1 || usage.Networks[0].VIPPool == nil || usage.Networks[0].VIPPool.Free != 0 || len(usage.Networks[0].VIPPool.TopConsumers) != 3 || usage.Total != 0 {
+		t.Fatalf("Unexpected address usage %+v", usage)
+	}
+
+	if err := n2.ReleaseServiceVIP(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n2.(*network).services["web"]; ok {
+		t.Fatal("Service record of the released VIP was kept")
+	}
+	if again, err := n2.AllocateServiceVIP(context.Background(), "queue"); err != nil || !again.Equal(vip) {
+		t.Fatalf("Released VIP %s was not allocated again: %s %v", vip, again, err)
+	}
+	if err := n2.ReleaseServiceVIP(context.Background(), "web"); err == nil {
+		t.Fatal("VIP released twice")
+	}
+
+	// The VIPs are kept with the network, and allocated again once it is
+	// restored
+	b, err := json.Marshal(n2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &network{ctrlr: c.(*controller)}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.vips) != 3 || !restored.vips["queue"].Equal(vip) {
+		t.Fatalf("Unexpected VIPs restored: %v", restored.vips)
+	}
+	if _, err := restored.AllocateServiceVIP(context.Background(), "web"); err == nil {
+		t.Fatal("VIP allocated from the exhausted pool of the restored network")
+	}
+	if err := restored.ReleaseServiceVIP(context.Background(), "db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.AllocateServiceVIP(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := &net.IPNet{IP: net.ParseIP("10.251.0.0").To4(), Mask: net.CIDRMask(24, 24)}
+	if _, err := c.NewNetwork(context.Background(), "local", "net3", NetworkOptionServiceVIPPool(invalid)); err == nil {
+		t.Fatal("Network created with an invalid VIP pool")
+	}
+}
+
+func TestRestoreSandboxes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nc, err := New(config.OptionSandboxStateDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nc.(*controller)
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings := []types.PortBinding{{Proto: types.TCP, Port: 80, HostPort: 8080}}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1", CreateOptionPortMapping(bindings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The port registry starts empty with the new daemon
+	c.ports.release(types.UUID(ep.ID()))
+
+	// The namespace the container was left with by the previous daemon
+	key := sandbox.GenerateKey("restored1")
+	if _, err := sandbox.NewSandbox(key, true); err != nil {
+		t.Skipf("Cannot create a sandbox: %v", err)
+	}
+	gone := sandbox.GenerateKey("gone1")
+	for _, st := range []*sandboxState{
+		{Key: key, Endpoints: []*sandboxEndpointState{{
+			NetworkID:   types.UUID(n.ID()),
+			EndpointID:  types.UUID(ep.ID()),
+			ContainerID: "restored1",
+			HostName:    "web",
+			Gateway:     net.ParseIP("172.20.0.1"),
+		}}},
+		{Key: gone},
+	} {
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(sandboxStatePath(dir, st.Key), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.restoreSandboxes()
+	if _, err := os.Stat(sandboxStatePath(dir, gone)); !os.IsNotExist(err) {
+		t.Fatal("State of a sandbox whose namespace is gone was kept")
+	}
+	c.Lock()
+	sData, ok := c.sandboxes[key]
+	c.Unlock()
+	if !ok {
+		t.Fatal("Sandbox was not restored")
+	}
+	if sData.refCnt != 1 || len(sData.endpoints) != 1 || len(sData.pending) != 0 {
+		t.Fatalf("Endpoint was not attached back: %d references, %d pending", sData.refCnt, len(sData.pending))
+	}
+	rep := ep.(*endpoint)
+	if rep.container == nil || rep.container.id != "restored1" || rep.container.data.SandboxKey != key ||
+		rep.container.config.hostName != "web" || !rep.Gateway().Equal(net.ParseIP("172.20.0.1")) {
+		t.Fatalf("Unexpected restored join of endpoint: %+v", rep.container)
+	}
+	if err := c.ports.check(bindings); err == nil {
+		t.Fatal("Ports of the restored endpoint were not recorded again")
+	}
+
+	// The restored sandbox is left and destroyed like any other
+	if err := ep.Leave(context.Background(), "restored1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LeaveAll(context.Background(), "restored1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sandboxStatePath(dir, key)); !os.IsNotExist(err) {
+		t.Fatal("State of the destroyed sandbox was kept")
+	}
+	sandbox.GC()
+	if _, err := os.Stat(key); err == nil {
+		t.Fatal("Namespace of the restored sandbox was not removed")
+	}
+}
+
+func TestEndpointGeneration(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ee := ep.(*endpoint)
+	if err := c.(*controller).updateEndpointToStore(context.Background(), ee); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &endpoint{id: ee.id, network: n.(*network)}
+	if err := c.(*controller).store.GetObject(ee.Key().String(), loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.generation != 2 || loaded.writer != storeWriter() {
+		t.Fatalf("Unexpected generation %d written by %q", loaded.generation, loaded.writer)
+	}
+	if ee.generation != 2 {
+		t.Fatalf("Expected generation 2, got %d", ee.generation)
+	}
+
+	later := &endpoint{generation: 3, writer: "other/1"}
+	same := &endpoint{generation: 2, writer: storeWriter()}
+	concurrent := &endpoint{generation: 2, writer: "other/1"}
+	older := &endpoint{generation: 1, writer: storeWriter()}
+	legacy := &endpoint{}
+	if !ee.followsGeneration(later) || !ee.followsGeneration(same) {
+		t.Fatal("Expected the later generations to follow")
+	}
+	for _, l := range []*endpoint{concurrent, older, legacy} {
+		if ee.followsGeneration(l) {
+			t.Fatalf("Expected generation %d written by %q to be detected", l.generation, l.writer)
+		}
+	}
+
+	// A regression is reported, the highest generation seen is kept
+	older.id = ee.id
+	older.network = n.(*network)
+	older.SetIndex(ee.Index() + 1)
+	if c.(*controller).processEndpointUpdate(older) {
+		t.Fatal("Expected the existing endpoint to be updated")
+	}
+	if ee.generation != 2 || ee.Index() != older.Index() {
+		t.Fatalf("Unexpected generation %d at index %d", ee.generation, ee.Index())
+	}
+
+	// A failed write does not take a generation
+	if err := c.(*controller).updateEndpointToStore(context.Background(), ee); err == nil {
+		t.Fatal("Expected the write at a stale index to fail")
+	}
+	if ee.generation != 2 || ee.writer != storeWriter() {
+		t.Fatalf("Unexpected generation %d written by %q", ee.generation, ee.writer)
+	}
+}
+
+// unloadingDriver records its unload
+type unloadingDriver struct {
+	localDriver
+	unloaded bool
+}
+
+func (d *unloadingDriver) Unload() {
+	d.unloaded = true
+}
+
+func TestUnregisterDriver(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &unloadingDriver{localDriver: localDriver{networks: make(map[types.UUID]map[string]interface{})}}
+	if err := c.(*controller).RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.UnregisterDriver(context.Background(), "unknown"); err == nil {
+		t.Fatal("Unknown driver was unregistered")
+	}
+	if err := c.UnregisterDriver(context.Background(), "local"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.unloaded {
+		t.Fatal("Driver was not unloaded")
+	}
+
+	// The operations on the quiesced network fail until a driver is back
+	_, err = n.CreateEndpoint(context.Background(), "ep2")
+	if _, ok := err.(types.NoServiceError); !ok {
+		t.Fatalf("Unexpected error creating an endpoint on a quiesced network: %v", err)
+	}
+	if _, err := c.NewNetwork(context.Background(), "local", "net2"); err == nil {
+		t.Fatal("Network was created without a driver")
+	}
+
+	d2 := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(*controller).RegisterDriver("local", d2, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d2.networks[types.UUID(n.ID())]; !ok {
+		t.Fatal("Network was not created again in the registered driver")
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadDriver(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "null", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := n.(*network).driver
+
+	if err := c.ReloadDriver(context.Background(), "null"); err != nil {
+		t.Fatal(err)
+	}
+	if d := n.(*network).driver; d == old || d.Type() != "null" {
+		t.Fatalf("Network was not handed to a new instance of the driver: %T", d)
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver does not restore endpoints, it would lose this one
+	err = c.ReloadDriver(context.Background(), "null")
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error reloading a driver which does not restore its endpoints: %v", err)
+	}
+	if _, ok := n.(*network).driver.(*unloadedDriver); ok {
+		t.Fatal("Driver was unregistered by the refused reload")
+	}
+}
+
+func TestReloadDriverFailure(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &localDriver{networks: make(map[types.UUID]map[string]interface{})}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(context.Background(), "local", "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver fails to initialize again on the first reload
+	initErr := fmt.Errorf("driver failed to initialize")
+	d := &localDriver{networks: make(map[types.UUID]map[string]interface{})}
+	saved := builtinDrivers
+	defer func() { builtinDrivers = saved }()
+	builtinDrivers = append([]builtinDriver{{networkType: "local", init: func(dc driverapi.DriverCallback) error {
+		if initErr != nil {
+			return initErr
+		}
+		return dc.RegisterDriver("local", d, driverapi.Capability{Scope: driverapi.LocalScope})
+	}}}, saved...)
+
+	if err := c.ReloadDriver(context.Background(), "local"); err != initErr {
+		t.Fatalf("Unexpected error reloading a driver failing to initialize: %v", err)
+	}
+	if _, ok := n.(*network).driver.(*unloadedDriver); !ok {
+		t.Fatalf("Network was not quiesced by the failed reload: %T", n.(*network).driver)
+	}
+	if _, ok := c.(*controller).driverCapability("local"); !ok {
+		t.Fatal("Network type was lost by the failed reload")
+	}
+
+	// The type is reloaded from there
+	initErr = nil
+	if err := c.ReloadDriver(context.Background(), "local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.networks[types.UUID(n.ID())]; !ok {
+		t.Fatal("Network was not created again in the reloaded driver")
+	}
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// clusterDriver is a driver gossiping with a cluster of two members
+type clusterDriver struct {
+	localDriver
+	members []driverapi.ClusterMember
+}
+
+func (d *clusterDriver) ClusterMembers() ([]driverapi.ClusterMember, error) {
+	return d.members, nil
+}
+
+func (d *clusterDriver) RemoveClusterMember(name string) error {
+	for i, m := range d.members {
+		if m.Name == name {
+			d.members = append(d.members[:i], d.members[i+1:]...)
+			return nil
+		}
+	}
+	return types.NotFoundErrorf("cluster member %s not found", name)
+}
+
+func (d *clusterDriver) SetNodeAvailability(name, availability string) error {
+	for i, m := range d.members {
+		if m.Name == name {
+			d.members[i].Availability = availability
+			return nil
+		}
+	}
+	return types.NotFoundErrorf("cluster member %s not found", name)
+}
+
+func TestClusterMembers(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &clusterDriver{
+		localDriver: localDriver{networks: make(map[types.UUID]map[string]interface{})},
+		members: []driverapi.ClusterMember{
+			{Name: "host1", Status: "alive", Availability: driverapi.NodeActive, Local: true},
+			{Name: "host2", Status: "failed", Availability: driverapi.NodeActive},
+		},
+	}
+	if err := c.(*controller).RegisterDriver("cluster", d, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ClusterMembers("null"); err == nil {
+		t.Fatal("Expected the driver without cluster to be refused")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Unexpected error type %T: %v", err, err)
+	}
+
+	a := &tenantAuthorizer{}
+	c.SetAuthorizer(a)
+	if err := c.SetNodeAvailability(context.Background(), "cluster", "host1", driverapi.NodeDrain); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.requests) != 1 || a.requests[0].Operation != AuthzClusterManage || a.requests[0].Params["member"] != "host1" {
+		t.Fatalf("Unexpected authorization requests %+v", a.requests)
+	}
+	if err := c.RemoveClusterMember(context.Background(), "cluster", "host2"); err != nil {
+		t.Fatal(err)
+	}
+	members, err := c.ClusterMembers("cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Name != "host1" || members[0].Availability != driverapi.NodeDrain {
+		t.Fatalf("Unexpected members %+v", members)
+	}
+}
+
+func TestNetworksByLabel(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("local", &localDriver{networks: make(map[types.UUID]map[string]interface{})}, driverapi.Capability{Scope: driverapi.LocalScope}); err != nil {
+		t.Fatal(err)
+	}
+	n1, err := c.NewNetwork(context.Background(), "local", "net1", NetworkOptionLabels(map[string]string{"tier": "db", "env": "prod"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), "local", "net2", NetworkOptionLabels(map[string]string{"tier": "web"})); err != nil {
+		t.Fatal(err)
+	}
+	n3, err := c.NewNetwork(context.Background(), "local", "net3", NetworkOptionLabels(map[string]string{"tier": "db"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(nws []Network) map[string]bool {
+		m := make(map[string]bool, len(nws))
+		for _, n := range nws {
+			m[n.Name()] = true
+		}
+		return m
+	}
+	if found := names(c.NetworksByLabel("tier", "db")); len(found) != 2 || !found["net1"] || !found["net3"] {
+		t.Fatalf("Unexpected networks labeled tier=db: %v", found)
+	}
+	if found := c.NetworksByLabel("tier", "cache"); len(found) != 0 {
+		t.Fatalf("Unexpected networks labeled tier=cache: %v", found)
+	}
+
+	// The index follows the deletions
+	if err := n1.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if found := names(c.NetworksByLabel("tier", "db")); len(found) != 1 || !found["net3"] {
+		t.Fatalf("Unexpected networks labeled tier=db after a deletion: %v", found)
+	}
+	if found := c.NetworksByLabel("env", "prod"); len(found) != 0 {
+		t.Fatalf("Deleted network still indexed: %v", found)
+	}
+	if err := n3.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if idx := c.(*controller).networkLabels; len(idx) != 1 || len(idx["tier"]) != 1 {
+		t.Fatalf("Index not pruned of the deleted networks: %v", idx)
+	}
+}
@@ -2,6 +2,7 @@ package libnetwork_test
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,15 +10,18 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netlabel"
@@ -49,7 +53,7 @@ func TestMain(m *testing.M) {
 	genericOption := make(map[string]interface{})
 	genericOption[netlabel.GenericData] = option
 
-	err := controller.ConfigureNetworkDriver(bridgeNetType, genericOption)
+	err := controller.ConfigureNetworkDriver(context.Background(), bridgeNetType, genericOption)
 	if err != nil {
 		//m.Fatal(err)
 		os.Exit(1)
@@ -72,7 +76,7 @@ func createController() error {
 }
 
 func createTestNetwork(networkType, networkName string, netOption options.Generic) (libnetwork.Network, error) {
-	network, err := controller.NewNetwork(networkType, networkName,
+	network, err := controller.NewNetwork(context.Background(), networkType, networkName,
 		libnetwork.NetworkOptionGeneric(netOption))
 	if err != nil {
 		return nil, err
@@ -101,12 +105,12 @@ func TestNull(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep, err := network.CreateEndpoint("testep")
+	ep, err := network.CreateEndpoint(context.Background(), "testep")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep.Join("null_container",
+	err = ep.Join(context.Background(), "null_container",
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -114,17 +118,17 @@ func TestNull(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep.Leave("null_container")
+	err = ep.Leave(context.Background(), "null_container")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := ep.Delete(); err != nil {
+	if err := ep.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
 	// host type is special network. Cannot be removed.
-	err = network.Delete()
+	err = network.Delete(context.Background())
 	if err == nil {
 		t.Fatal(err)
 	}
@@ -139,12 +143,12 @@ func TestHost(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep1, err := network.CreateEndpoint("testep1")
+	ep1, err := network.CreateEndpoint(context.Background(), "testep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep1.Join("host_container1",
+	err = ep1.Join(context.Background(), "host_container1",
 		libnetwork.JoinOptionHostname("test1"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"),
@@ -153,12 +157,12 @@ func TestHost(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep2, err := network.CreateEndpoint("testep2")
+	ep2, err := network.CreateEndpoint(context.Background(), "testep2")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep2.Join("host_container2",
+	err = ep2.Join(context.Background(), "host_container2",
 		libnetwork.JoinOptionHostname("test2"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"),
@@ -167,31 +171,31 @@ func TestHost(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep1.Leave("host_container1")
+	err = ep1.Leave(context.Background(), "host_container1")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep2.Leave("host_container2")
+	err = ep2.Leave(context.Background(), "host_container2")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := ep1.Delete(); err != nil {
+	if err := ep1.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := ep2.Delete(); err != nil {
+	if err := ep2.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
 	// Try to create another host endpoint and join/leave that.
-	ep3, err := network.CreateEndpoint("testep3")
+	ep3, err := network.CreateEndpoint(context.Background(), "testep3")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep3.Join("host_container3",
+	err = ep3.Join(context.Background(), "host_container3",
 		libnetwork.JoinOptionHostname("test3"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"),
@@ -200,17 +204,17 @@ func TestHost(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep3.Leave("host_container3")
+	err = ep3.Leave(context.Background(), "host_container3")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := ep3.Delete(); err != nil {
+	if err := ep3.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
 	// host type is special network. Cannot be removed.
-	err = network.Delete()
+	err = network.Delete(context.Background())
 	if err == nil {
 		t.Fatal(err)
 	}
@@ -263,7 +267,7 @@ func TestBridge(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep, err := network.CreateEndpoint("testep", libnetwork.CreateOptionPortMapping(getPortMapping()))
+	ep, err := network.CreateEndpoint(context.Background(), "testep", libnetwork.CreateOptionPortMapping(getPortMapping()))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -284,11 +288,11 @@ func TestBridge(t *testing.T) {
 		t.Fatalf("Incomplete data for port mapping in endpoint operational data: %d", len(pm))
 	}
 
-	if err := ep.Delete(); err != nil {
+	if err := ep.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := network.Delete(); err != nil {
+	if err := network.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -309,7 +313,7 @@ func TestUnknownDriver(t *testing.T) {
 }
 
 func TestNilRemoteDriver(t *testing.T) {
-	_, err := controller.NewNetwork("framerelay", "dummy",
+	_, err := controller.NewNetwork(context.Background(), "framerelay", "dummy",
 		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
@@ -326,12 +330,12 @@ func TestDuplicateNetwork(t *testing.T) {
 	}
 
 	// Creating a default bridge name network (can't be removed)
-	_, err := controller.NewNetwork(bridgeNetType, "testdup")
+	_, err := controller.NewNetwork(context.Background(), bridgeNetType, "testdup")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = controller.NewNetwork(bridgeNetType, "testdup")
+	_, err = controller.NewNetwork(context.Background(), bridgeNetType, "testdup")
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
 	}
@@ -368,7 +372,7 @@ func TestNetworkName(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -395,7 +399,7 @@ func TestNetworkType(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -422,7 +426,7 @@ func TestNetworkID(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -449,12 +453,12 @@ func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ep, err := network.CreateEndpoint("testep")
+	ep, err := network.CreateEndpoint(context.Background(), "testep")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = network.Delete()
+	err = network.Delete(context.Background())
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
 	}
@@ -464,11 +468,11 @@ func TestDeleteNetworkWithActiveEndpoints(t *testing.T) {
 	}
 
 	// Done testing. Now cleanup.
-	if err := ep.Delete(); err != nil {
+	if err := ep.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := network.Delete(); err != nil {
+	if err := network.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -490,12 +494,12 @@ func TestUnknownNetwork(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = network.Delete()
+	err = network.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = network.Delete()
+	err = network.Delete(context.Background())
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
 	}
@@ -529,7 +533,7 @@ func TestUnknownEndpoint(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = network.CreateEndpoint("")
+	_, err = network.CreateEndpoint(context.Background(), "")
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
 	}
@@ -537,18 +541,18 @@ func TestUnknownEndpoint(t *testing.T) {
 		t.Fatalf("Expected to fail with ErrInvalidName error. Actual error: %v", err)
 	}
 
-	ep, err := network.CreateEndpoint("testep")
+	ep, err := network.CreateEndpoint(context.Background(), "testep")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep.Delete()
+	err = ep.Delete(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Done testing. Now cleanup
-	if err := network.Delete(); err != nil {
+	if err := network.Delete(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -571,27 +575,27 @@ func TestNetworkEndpointsWalkers(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net1.Delete(); err != nil {
+		if err := net1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep11, err := net1.CreateEndpoint("ep11")
+	ep11, err := net1.CreateEndpoint(context.Background(), "ep11")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep11.Delete(); err != nil {
+		if err := ep11.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep12, err := net1.CreateEndpoint("ep12")
+	ep12, err := net1.CreateEndpoint(context.Background(), "ep12")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep12.Delete(); err != nil {
+		if err := ep12.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -644,7 +648,7 @@ func TestNetworkEndpointsWalkers(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net2.Delete(); err != nil {
+		if err := net2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -701,26 +705,26 @@ func TestDuplicateEndpoint(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep, err := n.CreateEndpoint("ep1")
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep.Delete(); err != nil {
+		if err := ep.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep2, err := n.CreateEndpoint("ep1")
+	ep2, err := n.CreateEndpoint(context.Background(), "ep1")
 	defer func() {
 		// Cleanup ep2 as well, else network cleanup might fail for failure cases
 		if ep2 != nil {
-			if err := ep2.Delete(); err != nil {
+			if err := ep2.Delete(context.Background()); err != nil {
 				t.Fatal(err)
 			}
 		}
@@ -752,7 +756,7 @@ func TestControllerQuery(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net1.Delete(); err != nil {
+		if err := net1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -769,7 +773,7 @@ func TestControllerQuery(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net2.Delete(); err != nil {
+		if err := net2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -856,27 +860,27 @@ func TestNetworkQuery(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net1.Delete(); err != nil {
+		if err := net1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep11, err := net1.CreateEndpoint("ep11")
+	ep11, err := net1.CreateEndpoint(context.Background(), "ep11")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep11.Delete(); err != nil {
+		if err := ep11.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep12, err := net1.CreateEndpoint("ep12")
+	ep12, err := net1.CreateEndpoint(context.Background(), "ep12")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep12.Delete(); err != nil {
+		if err := ep12.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -977,17 +981,17 @@ func TestEndpointJoin(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n1.Delete(); err != nil {
+		if err := n1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n1.CreateEndpoint("ep1")
+	ep1, err := n1.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep1.Delete(); err != nil {
+		if err := ep1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -1009,9 +1013,9 @@ func TestEndpointJoin(t *testing.T) {
 		t.Fatalf("Expected an empty sandbox key for an empty endpoint. Instead found a non-empty sandbox key: %s", info.SandboxKey())
 	}
 
-	defer controller.LeaveAll(containerID)
+	defer controller.LeaveAll(context.Background(), containerID)
 
-	err = ep1.Join(containerID,
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -1020,7 +1024,7 @@ func TestEndpointJoin(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep1.Leave(containerID)
+		err = ep1.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
@@ -1054,28 +1058,28 @@ func TestEndpointJoin(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n2.Delete(); err != nil {
+		if err := n2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep2, err := n2.CreateEndpoint("ep2")
+	ep2, err := n2.CreateEndpoint(context.Background(), "ep2")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep2.Delete(); err != nil {
+		if err := ep2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep2.Join(containerID)
+	err = ep2.Join(context.Background(), containerID)
 	if err != nil {
 		t.Fatal(err)
 	}
 	runtime.LockOSThread()
 	defer func() {
-		err = ep2.Leave(containerID)
+		err = ep2.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
@@ -1105,22 +1109,22 @@ func TestEndpointJoinInvalidContainerId(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep, err := n.CreateEndpoint("ep1")
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep.Delete(); err != nil {
+		if err := ep.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Join("")
+	err = ep.Join(context.Background(), "")
 	if err == nil {
 		t.Fatal("Expected to fail join with empty container id string")
 	}
@@ -1145,25 +1149,25 @@ func TestEndpointDeleteWithActiveContainer(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep, err := n.CreateEndpoint("ep1")
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep.Delete()
+		err = ep.Delete(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	defer controller.LeaveAll(containerID)
+	defer controller.LeaveAll(context.Background(), containerID)
 
-	err = ep.Join(containerID,
+	err = ep.Join(context.Background(), containerID,
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -1172,14 +1176,14 @@ func TestEndpointDeleteWithActiveContainer(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep.Leave(containerID)
+		err = ep.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Delete()
+	err = ep.Delete(context.Background())
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
 	}
@@ -1204,24 +1208,24 @@ func TestEndpointMultipleJoins(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep, err := n.CreateEndpoint("ep1")
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep.Delete(); err != nil {
+		if err := ep.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	defer controller.LeaveAll(containerID)
+	defer controller.LeaveAll(context.Background(), containerID)
 
-	err = ep.Join(containerID,
+	err = ep.Join(context.Background(), containerID,
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -1230,14 +1234,14 @@ func TestEndpointMultipleJoins(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep.Leave(containerID)
+		err = ep.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Join("container2")
+	err = ep.Join(context.Background(), "container2")
 	if err == nil {
 		t.Fatal("Expected to fail multiple joins for the same endpoint")
 	}
@@ -1262,50 +1266,50 @@ func TestLeaveAll(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n.CreateEndpoint("ep1")
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep1.Delete(); err != nil {
+		if err := ep1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep2, err := n.CreateEndpoint("ep2")
+	ep2, err := n.CreateEndpoint(context.Background(), "ep2")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep2.Delete(); err != nil {
+		if err := ep2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep1.Join("leaveall")
+	err = ep1.Join(context.Background(), "leaveall")
 	if err != nil {
 		t.Fatalf("Failed to join ep1: %v", err)
 	}
 	runtime.LockOSThread()
 
-	err = ep2.Join("leaveall")
+	err = ep2.Join(context.Background(), "leaveall")
 	if err != nil {
 		t.Fatalf("Failed to join ep2: %v", err)
 	}
 	runtime.LockOSThread()
 
-	err = ep1.Leave("leaveall")
+	err = ep1.Leave(context.Background(), "leaveall")
 	if err != nil {
 		t.Fatalf("Failed to leave ep1: %v", err)
 	}
 	runtime.LockOSThread()
 
-	err = controller.LeaveAll("leaveall")
+	err = controller.LeaveAll(context.Background(), "leaveall")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1327,22 +1331,22 @@ func TestEndpointInvalidLeave(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep, err := n.CreateEndpoint("ep1")
+	ep, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep.Delete(); err != nil {
+		if err := ep.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Leave(containerID)
+	err = ep.Leave(context.Background(), containerID)
 	if err == nil {
 		t.Fatal("Expected to fail leave from an endpoint which has no active join")
 	}
@@ -1353,9 +1357,9 @@ func TestEndpointInvalidLeave(t *testing.T) {
 		}
 	}
 
-	defer controller.LeaveAll(containerID)
+	defer controller.LeaveAll(context.Background(), containerID)
 
-	err = ep.Join(containerID,
+	err = ep.Join(context.Background(), containerID,
 		libnetwork.JoinOptionHostname("test"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -1364,14 +1368,14 @@ func TestEndpointInvalidLeave(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep.Leave(containerID)
+		err = ep.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Leave("")
+	err = ep.Leave(context.Background(), "")
 	if err == nil {
 		t.Fatal("Expected to fail leave with empty container id")
 	}
@@ -1380,7 +1384,7 @@ func TestEndpointInvalidLeave(t *testing.T) {
 		t.Fatalf("Failed for unexpected reason: %v", err)
 	}
 
-	err = ep.Leave("container2")
+	err = ep.Leave(context.Background(), "container2")
 	if err == nil {
 		t.Fatal("Expected to fail leave with wrong container id")
 	}
@@ -1405,23 +1409,23 @@ func TestEndpointUpdateParent(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n.CreateEndpoint("ep1")
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep1.Delete(); err != nil {
+		if err := ep1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	defer controller.LeaveAll(containerID)
-	err = ep1.Join(containerID,
+	defer controller.LeaveAll(context.Background(), containerID)
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionHostname("test1"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionExtraHost("web", "192.168.0.1"))
@@ -1430,25 +1434,25 @@ func TestEndpointUpdateParent(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep1.Leave(containerID)
+		err = ep1.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep2, err := n.CreateEndpoint("ep2")
+	ep2, err := n.CreateEndpoint(context.Background(), "ep2")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep2.Delete(); err != nil {
+		if err := ep2.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	defer controller.LeaveAll("container2")
-	err = ep2.Join("container2",
+	defer controller.LeaveAll(context.Background(), "container2")
+	err = ep2.Join(context.Background(), "container2",
 		libnetwork.JoinOptionHostname("test2"),
 		libnetwork.JoinOptionDomainname("docker.io"),
 		libnetwork.JoinOptionHostsPath("/var/lib/docker/test_network/container2/hosts"),
@@ -1458,7 +1462,7 @@ func TestEndpointUpdateParent(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep2.Leave("container2")
+	err = ep2.Leave(context.Background(), "container2")
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
@@ -1504,17 +1508,17 @@ func TestEnableIPv6(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n.CreateEndpoint("ep1")
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep1.Delete(); err != nil {
+		if err := ep1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -1526,15 +1530,15 @@ func TestEnableIPv6(t *testing.T) {
 	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
 	defer os.Remove(resolvConfPath)
 
-	defer controller.LeaveAll(containerID)
-	err = ep1.Join(containerID,
+	defer controller.LeaveAll(context.Background(), containerID)
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionResolvConfPath(resolvConfPath))
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep1.Leave(containerID)
+		err = ep1.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
@@ -1589,17 +1593,17 @@ func TestResolvConf(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n.CreateEndpoint("ep1")
+	ep1, err := n.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep1.Delete(); err != nil {
+		if err := ep1.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -1611,15 +1615,15 @@ func TestResolvConf(t *testing.T) {
 	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
 	defer os.Remove(resolvConfPath)
 
-	defer controller.LeaveAll(containerID)
-	err = ep1.Join(containerID,
+	defer controller.LeaveAll(context.Background(), containerID)
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionResolvConfPath(resolvConfPath))
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		err = ep1.Leave(containerID)
+		err = ep1.Leave(context.Background(), containerID)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
@@ -1645,7 +1649,7 @@ func TestResolvConf(t *testing.T) {
 		t.Fatalf("Expected %s, Got %s", string(expectedResolvConf1), string(content))
 	}
 
-	err = ep1.Leave(containerID)
+	err = ep1.Leave(context.Background(), containerID)
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
@@ -1655,7 +1659,7 @@ func TestResolvConf(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep1.Join(containerID,
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionResolvConfPath(resolvConfPath))
 	runtime.LockOSThread()
 	if err != nil {
@@ -1675,13 +1679,13 @@ func TestResolvConf(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = ep1.Leave(containerID)
+	err = ep1.Leave(context.Background(), containerID)
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep1.Join(containerID,
+	err = ep1.Join(context.Background(), containerID,
 		libnetwork.JoinOptionResolvConfPath(resolvConfPath))
 	runtime.LockOSThread()
 	if err != nil {
@@ -1737,7 +1741,7 @@ func TestInvalidRemoteDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = controller.NewNetwork("invalid-network-driver", "dummy",
+	_, err = controller.NewNetwork(context.Background(), "invalid-network-driver", "dummy",
 		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
 	if err == nil {
 		t.Fatal("Expected to fail. But instead succeeded")
@@ -1786,7 +1790,7 @@ func TestValidRemoteDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	n, err := controller.NewNetwork("valid-network-driver", "dummy",
+	n, err := controller.NewNetwork(context.Background(), "valid-network-driver", "dummy",
 		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
 	if err != nil {
 		// Only fail if we could not find the plugin driver
@@ -1796,7 +1800,7 @@ func TestValidRemoteDriver(t *testing.T) {
 		return
 	}
 	defer func() {
-		if err := n.Delete(); err != nil {
+		if err := n.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -1847,7 +1851,7 @@ func createGlobalInstance(t *testing.T) {
 		t.Fatal("new network")
 	}
 
-	_, err = net.CreateEndpoint("ep1")
+	_, err = net.CreateEndpoint(context.Background(), "ep1")
 	if err != nil {
 		t.Fatal("createendpoint")
 	}
@@ -1863,7 +1867,7 @@ func debugf(format string, a ...interface{}) (int, error) {
 
 func parallelJoin(t *testing.T, ep libnetwork.Endpoint, thrNumber int) {
 	debugf("J%d.", thrNumber)
-	err := ep.Join("racing_container")
+	err := ep.Join(context.Background(), "racing_container")
 	runtime.LockOSThread()
 	if err != nil {
 		if _, ok := err.(libnetwork.ErrNoContainer); !ok {
@@ -1878,7 +1882,7 @@ func parallelJoin(t *testing.T, ep libnetwork.Endpoint, thrNumber int) {
 
 func parallelLeave(t *testing.T, ep libnetwork.Endpoint, thrNumber int) {
 	debugf("L%d.", thrNumber)
-	err := ep.Leave("racing_container")
+	err := ep.Leave(context.Background(), "racing_container")
 	runtime.LockOSThread()
 	if err != nil {
 		if _, ok := err.(libnetwork.ErrNoContainer); !ok {
@@ -1966,12 +1970,12 @@ func runParallelTests(t *testing.T, thrNumber int) {
 		}
 
 		testns.Close()
-		err = ep.Delete()
+		err = ep.Delete(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if err := net.Delete(); err != nil {
+		if err := net.Delete(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1988,3 +1992,65 @@ func TestParallel2(t *testing.T) {
 func TestParallel3(t *testing.T) {
 	runParallelTests(t, 3)
 }
+
+func TestDiscoveredRemoteDriver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "libnetwork-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Socket published by a managed plugin container
+	sockDir := filepath.Join(tmp, "0123abcd")
+	if err := os.MkdirAll(sockDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(sockDir, "discovered-network-driver.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	created := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+		created <- struct{}{}
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.DeleteNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+	})
+	go http.Serve(l, mux)
+
+	c, err := libnetwork.New(config.OptionPluginSocketDirs(tmp), config.OptionPluginUnitDirs(tmp),
+		config.OptionPluginTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork(context.Background(), "discovered-network-driver", "dummy",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-created:
+	default:
+		t.Fatal("Network was not created by the discovered plugin")
+	}
+	if err := n.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.NewNetwork(context.Background(), "missing-network-driver", "dummy2",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+}
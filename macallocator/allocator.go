@@ -0,0 +1,209 @@
+// Package macallocator allocates MAC addresses from operator defined ranges.
+// The allocations are kept in the datastore the allocator is created with,
+// so that the drivers of different hosts sharing a global store do not hand
+// out the same address.
+package macallocator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/idm"
+	"github.com/docker/libnetwork/types"
+)
+
+var (
+	// ErrNoAvailableMAC is returned when all the ranges are exhausted
+	ErrNoAvailableMAC = errors.New("no available MAC addresses")
+	// ErrMACInUse is returned when the requested address is already allocated
+	ErrMACInUse = errors.New("MAC address is already in use")
+)
+
+// Range is a range of MAC addresses sharing the same OUI
+type Range struct {
+	Start net.HardwareAddr
+	End   net.HardwareAddr
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%s-%s", r.Start, r.End)
+}
+
+// Validate checks the range bounds are unicast addresses of the same OUI
+func (r Range) Validate() error {
+	if len(r.Start) != 6 || len(r.End) != 6 {
+		return types.BadRequestErrorf("MAC range %s must be made of 48 bit addresses", r)
+	}
+	if r.Start[0]&0x01 != 0 {
+		return types.BadRequestErrorf("MAC range %s is not unicast", r)
+	}
+	if r.Start[0] != r.End[0] || r.Start[1] != r.End[1] || r.Start[2] != r.End[2] {
+		return types.BadRequestErrorf("MAC range %s spans more than one OUI", r)
+	}
+	if macToUint(r.End) <= macToUint(r.Start) {
+		return types.BadRequestErrorf("MAC range %s end must be greater than its start", r)
+	}
+	return nil
+}
+
+func (r Range) contains(mac net.HardwareAddr) bool {
+	v := macToUint(mac)
+	return len(mac) == 6 && v >= macToUint(r.Start) && v <= macToUint(r.End)
+}
+
+func (r Range) overlaps(o Range) bool {
+	return macToUint(r.Start) <= macToUint(o.End) && macToUint(o.Start) <= macToUint(r.End)
+}
+
+// ParseRange parses a range in the "start-end" form, or a three bytes OUI
+// standing for all of its addresses, like "02:42:ac".
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if parts := strings.SplitN(s, "-", 2); len(parts) == 2 {
+		start, err := net.ParseMAC(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return Range{}, types.BadRequestErrorf("invalid MAC range %s: %v", s, err)
+		}
+		end, err := net.ParseMAC(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return Range{}, types.BadRequestErrorf("invalid MAC range %s: %v", s, err)
+		}
+		r := Range{Start: start, End: end}
+		return r, r.Validate()
+	}
+
+	oui, err := net.ParseMAC(s + ":00:00:00")
+	if err != nil || len(oui) != 6 {
+		return Range{}, types.BadRequestErrorf("invalid MAC range %s", s)
+	}
+	end := make(net.HardwareAddr, 6)
+	copy(end, oui)
+	end[3], end[4], end[5] = 0xff, 0xff, 0xff
+	r := Range{Start: oui, End: end}
+	return r, r.Validate()
+}
+
+// ParseRanges parses a comma separated list of ranges
+func ParseRanges(s string) ([]Range, error) {
+	var ranges []Range
+	for _, f := range strings.Split(s, ",") {
+		if strings.TrimSpace(f) == "" {
+			continue
+		}
+		r, err := ParseRange(f)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+type pool struct {
+	r     Range
+	start uint64
+	ids   *idm.Idm
+}
+
+// Allocator hands out the addresses of its ranges, in the order the ranges
+// were configured
+type Allocator struct {
+	pools []*pool
+}
+
+// New returns an allocator for the given ranges, which must not overlap.
+// The allocations are persisted in ds, if not nil.
+func New(ds datastore.DataStore, ranges []Range) (*Allocator, error) {
+	if len(ranges) == 0 {
+		return nil, types.BadRequestErrorf("no MAC range specified")
+	}
+
+	a := &Allocator{}
+	for i, r := range ranges {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		for _, o := range ranges[:i] {
+			if r.overlaps(o) {
+				return nil, types.BadRequestErrorf("MAC range %s overlaps with %s", r, o)
+			}
+		}
+
+		start := macToUint(r.Start)
+		// The offsets within a range fit 24 bits
+		ids, err := idm.New(ds, "mac-"+hexMAC(r.Start)+"-"+hexMAC(r.End), 0, uint32(macToUint(r.End)-start))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MAC range %s: %v", r, err)
+		}
+		a.pools = append(a.pools, &pool{r: r, start: start, ids: ids})
+	}
+
+	return a, nil
+}
+
+// Request reserves the passed address, or the first available one if mac
+// is nil. Addresses outside of the allocator ranges are returned as they
+// are, as they are not managed by the allocator.
+func (a *Allocator) Request(mac net.HardwareAddr) (net.HardwareAddr, error) {
+	if mac != nil {
+		p := a.poolOf(mac)
+		if p == nil {
+			return mac, nil
+		}
+		if err := p.ids.GetSpecificID(uint32(macToUint(mac) - p.start)); err != nil {
+			return nil, ErrMACInUse
+		}
+		return mac, nil
+	}
+
+	for _, p := range a.pools {
+		id, err := p.ids.GetID()
+		if err != nil {
+			continue
+		}
+		return uintToMAC(p.start + uint64(id)), nil
+	}
+
+	return nil, ErrNoAvailableMAC
+}
+
+// Release returns the address to its range. Addresses outside of the
+// allocator ranges are ignored.
+func (a *Allocator) Release(mac net.HardwareAddr) {
+	if p := a.poolOf(mac); p != nil {
+		p.ids.Release(uint32(macToUint(mac) - p.start))
+	}
+}
+
+func (a *Allocator) poolOf(mac net.HardwareAddr) *pool {
+	for _, p := range a.pools {
+		if p.r.contains(mac) {
+			return p
+		}
+	}
+	return nil
+}
+
+func macToUint(mac net.HardwareAddr) uint64 {
+	var v uint64
+	for _, b := range mac {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func uintToMAC(v uint64) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	for i := 5; i >= 0; i-- {
+		mac[i] = byte(v)
+		v >>= 8
+	}
+	return mac
+}
+
+func hexMAC(mac net.HardwareAddr) string {
+	return strings.Replace(mac.String(), ":", "", -1)
+}
@@ -0,0 +1,117 @@
+package macallocator
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func mustParseMAC(t *testing.T, s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mac
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("02:42:ac:00:00:10-02:42:ac:00:00:20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Start.String() != "02:42:ac:00:00:10" || r.End.String() != "02:42:ac:00:00:20" {
+		t.Fatalf("Unexpected range %s", r)
+	}
+
+	r, err = ParseRange("02:42:ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Start.String() != "02:42:ac:00:00:00" || r.End.String() != "02:42:ac:ff:ff:ff" {
+		t.Fatalf("Unexpected range %s", r)
+	}
+
+	for _, s := range []string{
+		"",
+		"02:42",
+		"zz:42:ac",
+		"03:42:ac",
+		"02:42:ac:00:00:20-02:42:ac:00:00:10",
+		"02:42:ac:00:00:10-02:42:ad:00:00:10",
+		"02:42:ac:00:00:10-02:42:ac:00:00:10",
+	} {
+		_, err := ParseRange(s)
+		if err == nil {
+			t.Fatalf("Expected failure parsing %q", s)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type parsing %q: %v", s, err)
+		}
+	}
+
+	ranges, err := ParseRanges("02:42:ac:00:00:00-02:42:ac:00:00:01, 02:42:ad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("Expected 2 ranges, got %v", ranges)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil, nil); err == nil {
+		t.Fatal("Expected failure without ranges")
+	}
+
+	r1, _ := ParseRange("02:42:ac:00:00:00-02:42:ac:00:00:10")
+	r2, _ := ParseRange("02:42:ac:00:00:10-02:42:ac:00:00:20")
+	if _, err := New(nil, []Range{r1, r2}); err == nil {
+		t.Fatal("Expected failure for overlapping ranges")
+	}
+}
+
+func TestRequestRelease(t *testing.T) {
+	r1, _ := ParseRange("02:42:ac:00:00:fe-02:42:ac:00:00:ff")
+	r2, _ := ParseRange("06:00:00:00:00:00-06:00:00:00:00:01")
+	a, err := New(nil, []Range{r1, r2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var macs []string
+	for i := 0; i < 4; i++ {
+		mac, err := a.Request(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		macs = append(macs, mac.String())
+	}
+	expected := []string{"02:42:ac:00:00:fe", "02:42:ac:00:00:ff", "06:00:00:00:00:00", "06:00:00:00:00:01"}
+	for i := range expected {
+		if macs[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, macs)
+		}
+	}
+
+	if _, err := a.Request(nil); err != ErrNoAvailableMAC {
+		t.Fatalf("Expected ErrNoAvailableMAC, got %v", err)
+	}
+
+	inUse := mustParseMAC(t, "02:42:ac:00:00:ff")
+	if _, err := a.Request(inUse); err != ErrMACInUse {
+		t.Fatalf("Expected ErrMACInUse, got %v", err)
+	}
+
+	a.Release(inUse)
+	if mac, err := a.Request(inUse); err != nil || mac.String() != inUse.String() {
+		t.Fatalf("Failed to request the released address: %v, %v", mac, err)
+	}
+
+	// Unmanaged addresses are passed through
+	other := mustParseMAC(t, "02:42:ac:11:00:02")
+	if mac, err := a.Request(other); err != nil || mac.String() != other.String() {
+		t.Fatalf("Unexpected result for an unmanaged address: %v, %v", mac, err)
+	}
+	a.Release(other)
+}
@@ -21,20 +21,135 @@ const (
 	// ExposedPorts constant represents exposedports of a Container
 	ExposedPorts = Prefix + ".endpoint.exposedports"
 
+	// Mirror constant represents the traffic mirror config of an endpoint
+	Mirror = Prefix + ".endpoint.mirror"
+
+	// VethName constant represents the name of the host side veth interface of an endpoint
+	VethName = Prefix + ".endpoint.veth_name"
+
+	// MTU constant represents the MTU of the interfaces of an endpoint
+	MTU = Prefix + ".endpoint.mtu"
+
+	// SNATAddress constant represents the source address the outbound traffic of an endpoint is translated to
+	SNATAddress = Prefix + ".endpoint.snat_address"
+
+	// SecondaryAddresses constant represents the addresses of an endpoint besides its primary ones
+	SecondaryAddresses = Prefix + ".endpoint.secondary_addresses"
+
+	// ConnectionLimit constant represents the limit of concurrent connections to each published port of an endpoint
+	ConnectionLimit = Prefix + ".endpoint.connection_limit"
+
+	// SYNRateLimit constant represents the limit of new connections per second to each published port of an endpoint
+	SYNRateLimit = Prefix + ".endpoint.syn_rate_limit"
+
+	// PerfProfile constant represents the performance profile the interfaces of an endpoint are tuned for
+	PerfProfile = Prefix + ".endpoint.perf_profile"
+
+	// PerfCPUs constant represents the CPU mask the queues of the interfaces of an endpoint are steered to
+	PerfCPUs = Prefix + ".endpoint.perf_cpus"
+
+	// EndpointDSCP constant represents the DSCP value the outbound traffic of an endpoint is marked with
+	EndpointDSCP = Prefix + ".endpoint.dscp"
+
+	// DeferPortBinding constant represents binding the published ports of an endpoint only once a sandbox joins it
+	DeferPortBinding = Prefix + ".endpoint.defer_port_binding"
+
+	// ServiceRegistration constant represents when an endpoint is added to the service records of its network
+	ServiceRegistration = Prefix + ".endpoint.service_registration"
+
 	//EnableIPv6 constant represents enabling IPV6 at network level
 	EnableIPv6 = Prefix + ".enable_ipv6"
 
+	// BridgeSTP constant represents enabling the spanning tree protocol on the bridge of a network
+	BridgeSTP = Prefix + ".bridge.stp"
+
+	// BridgeForwardDelay constant represents the time the ports of the bridge of a network spend
+	// listening and learning before forwarding, with the spanning tree protocol
+	BridgeForwardDelay = Prefix + ".bridge.forward_delay"
+
+	// BridgeAgeingTime constant represents the time the bridge of a network keeps the addresses it learnt
+	BridgeAgeingTime = Prefix + ".bridge.ageing_time"
+
+	// BridgeMulticastSnooping constant represents enabling the multicast snooping on the bridge of a network
+	BridgeMulticastSnooping = Prefix + ".bridge.multicast_snooping"
+
+	// DSCP constant represents the DSCP value the traffic a network forwards out of its bridge is marked with
+	DSCP = Prefix + ".dscp"
+
 	// KVProvider constant represents the KV provider backend
 	KVProvider = DriverPrefix + ".kv_provider"
 
 	// KVProviderURL constant represents the KV provider URL
 	KVProviderURL = DriverPrefix + ".kv_provider_url"
 
+	// BridgeMacRanges constant represents the MAC ranges the bridge driver
+	// allocates the endpoint addresses from
+	BridgeMacRanges = DriverPrefix + ".bridge.mac_ranges"
+
+	// OverlayMacRanges constant represents the MAC ranges the overlay driver
+	// allocates the endpoint addresses from
+	OverlayMacRanges = DriverPrefix + ".overlay.mac_ranges"
+
 	// OverlayBindInterface constant represents overlay driver bind interface
 	OverlayBindInterface = DriverPrefix + ".overlay.bind_interface"
 
 	// OverlayNeighborIP constant represents overlay driver neighbor IP
 	OverlayNeighborIP = DriverPrefix + ".overlay.neighbor_ip"
+
+	// OverlayBindAddress constant represents overlay driver bind address
+	OverlayBindAddress = DriverPrefix + ".overlay.bind_address"
+
+	// OverlayAdvertiseAddress constant represents the address the overlay
+	// driver advertises to its peers
+	OverlayAdvertiseAddress = DriverPrefix + ".overlay.advertise_address"
+
+	// OverlayAddressFamily constant represents the address family ("ipv4" or
+	// "ipv6") of the address picked from the overlay driver bind interface
+	OverlayAddressFamily = DriverPrefix + ".overlay.address_family"
+
+	// OverlayUnderlayAddresses constant represents the comma separated local
+	// addresses the overlay driver spreads the encapsulated traffic over
+	OverlayUnderlayAddresses = DriverPrefix + ".overlay.underlay_addresses"
+
+	// OverlayBroadcastRate constant represents the number of endpoint events
+	// per second each overlay network broadcasts to the cluster at most
+	OverlayBroadcastRate = DriverPrefix + ".overlay.broadcast_rate"
+
+	// OverlayBroadcastBurst constant represents the number of endpoint
+	// events an overlay network can broadcast at once within its rate
+	OverlayBroadcastBurst = DriverPrefix + ".overlay.broadcast_burst"
+
+	// OverlayVxlanPort constant represents the UDP port the vxlan device of
+	// an overlay network sends and receives the encapsulated traffic on
+	OverlayVxlanPort = DriverPrefix + ".overlay.vxlan_port"
+
+	// OverlayVxlanTOS constant represents the type of service of the packets
+	// the vxlan device of an overlay network sends, 1 to inherit it
+	OverlayVxlanTOS = DriverPrefix + ".overlay.vxlan_tos"
+
+	// OverlayVxlanTTL constant represents the time to live of the packets
+	// the vxlan device of an overlay network sends
+	OverlayVxlanTTL = DriverPrefix + ".overlay.vxlan_ttl"
+
+	// OverlayVxlanLearning constant represents whether the vxlan device of an
+	// overlay network learns the location of the remote MAC addresses
+	OverlayVxlanLearning = DriverPrefix + ".overlay.vxlan_learning"
+
+	// OverlayChecksumOffload constant represents whether the vxlan device of
+	// an overlay network offloads the checksum of the packets it sends
+	OverlayChecksumOffload = DriverPrefix + ".overlay.checksum_offload"
+
+	// OverlayNodeSubnetSize constant represents the prefix length of the
+	// slices of the overlay subnet each node allocates its addresses from
+	OverlayNodeSubnetSize = DriverPrefix + ".overlay.node_subnet_size"
+
+	// OverlayInternal constant represents whether the containers of an
+	// overlay network are kept from sending traffic outside of it
+	OverlayInternal = DriverPrefix + ".overlay.internal"
+
+	// OverlayNeighborTableSize constant represents the number of peers each
+	// overlay network keeps in its neighbor and fdb tables
+	OverlayNeighborTableSize = DriverPrefix + ".overlay.neighbor_table_size"
 )
 
 // Key extracts the key portion of the label
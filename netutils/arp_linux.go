@@ -0,0 +1,151 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var (
+	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	// allNodesMAC is the ethernet address of the all nodes multicast
+	// group ff02::1
+	allNodesMAC = net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}
+)
+
+// SendGratuitousARP broadcasts on the interface an unsolicited ARP request
+// announcing that the IPv4 address ip is at the hardware address of the
+// interface, so that the neighbors and the switches refresh their tables.
+func SendGratuitousARP(iface string, ip net.IP) error {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+
+	if err := sendFrame(ifi, syscall.ETH_P_ARP, broadcastMAC, gratuitousARP(ifi.HardwareAddr, ip4)); err != nil {
+		return fmt.Errorf("could not send gratuitous arp on %s: %v", iface, err)
+	}
+	return nil
+}
+
+// SendUnsolicitedNA multicasts to all the nodes on the link of the interface
+// an unsolicited neighbor advertisement, the IPv6 counterpart of the
+// gratuitous ARP, announcing that ip is at the hardware address of the
+// interface and overriding the cached one.
+func SendUnsolicitedNA(iface string, ip net.IP) error {
+	if ip.To4() != nil || ip.To16() == nil {
+		return fmt.Errorf("%s is not an IPv6 address", ip)
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+
+	if err := sendFrame(ifi, syscall.ETH_P_IPV6, allNodesMAC, unsolicitedNA(ifi.HardwareAddr, ip.To16())); err != nil {
+		return fmt.Errorf("could not send unsolicited neighbor advertisement on %s: %v", iface, err)
+	}
+	return nil
+}
+
+// sendFrame sends the ethernet frame of the protocol to dst on the interface
+func sendFrame(ifi *net.Interface, proto uint16, dst net.HardwareAddr, frame []byte) error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(proto)))
+	if err != nil {
+		return fmt.Errorf("could not open packet socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(proto),
+		Ifindex:  ifi.Index,
+		Halen:    uint8(len(dst)),
+	}
+	copy(addr.Addr[:], dst)
+
+	return syscall.Sendto(fd, frame, 0, addr)
+}
+
+// gratuitousARP returns the ethernet frame of an ARP request for ip sent by
+// mac, with ip as the sender address
+func gratuitousARP(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 42)
+	// Ethernet header
+	copy(b[0:6], broadcastMAC)
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], syscall.ETH_P_ARP)
+	// ARP payload: ethernet and IPv4, request
+	binary.BigEndian.PutUint16(b[14:16], 1)
+	binary.BigEndian.PutUint16(b[16:18], syscall.ETH_P_IP)
+	b[18] = 6
+	b[19] = 4
+	binary.BigEndian.PutUint16(b[20:22], 1)
+	copy(b[22:28], mac)
+	copy(b[28:32], ip)
+	// The target hardware address is left zeroed
+	copy(b[38:42], ip)
+	return b
+}
+
+// unsolicitedNA returns the ethernet frame of a neighbor advertisement of ip
+// at mac, sent from ip to the all nodes group with the override flag set
+func unsolicitedNA(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 86)
+	// Ethernet header
+	copy(b[0:6], allNodesMAC)
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], syscall.ETH_P_IPV6)
+	// IPv6 header: 32 bytes of ICMPv6, with the hop limit neighbor
+	// discovery requires
+	b[14] = 0x60
+	binary.BigEndian.PutUint16(b[18:20], 32)
+	b[20] = syscall.IPPROTO_ICMPV6
+	b[21] = 255
+	copy(b[22:38], ip)
+	copy(b[38:54], net.IPv6linklocalallnodes)
+	// ICMPv6 neighbor advertisement with the override flag, followed by
+	// the target link-layer address option
+	b[54] = 136
+	b[58] = 0x20
+	copy(b[62:78], ip)
+	b[78] = 2
+	b[79] = 1
+	copy(b[80:86], mac)
+	binary.BigEndian.PutUint16(b[56:58], icmpv6Checksum(b[22:38], b[38:54], b[54:86]))
+	return b
+}
+
+// icmpv6Checksum returns the checksum of the ICMPv6 message from src to dst,
+// computed over the IPv6 pseudo header and the message
+func icmpv6Checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(msg))
+	sum += syscall.IPPROTO_ICMPV6
+	add(msg)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// htons converts the short to network byte order, as the packet socket
+// calls expect the protocol
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
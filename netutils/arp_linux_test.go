@@ -0,0 +1,61 @@
+package netutils
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestGratuitousARP(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	ip := net.ParseIP("172.17.0.2").To4()
+
+	frame := gratuitousARP(mac, ip)
+	if len(frame) != 42 {
+		t.Fatalf("Expected a 42 bytes frame, got %d", len(frame))
+	}
+	if !bytes.Equal(frame[0:6], broadcastMAC) || !bytes.Equal(frame[6:12], mac) {
+		t.Fatalf("Unexpected ethernet addresses in %x", frame[0:12])
+	}
+	if !bytes.Equal(frame[12:14], []byte{0x08, 0x06}) {
+		t.Fatalf("Unexpected ethernet type %x", frame[12:14])
+	}
+	if !bytes.Equal(frame[20:22], []byte{0, 1}) {
+		t.Fatalf("Expected an arp request, got operation %x", frame[20:22])
+	}
+	if !bytes.Equal(frame[22:28], mac) || !bytes.Equal(frame[28:32], ip) || !bytes.Equal(frame[38:42], ip) {
+		t.Fatalf("Unexpected arp addresses in %x", frame[22:42])
+	}
+}
+
+func TestUnsolicitedNA(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	ip := net.ParseIP("2001:db8::2")
+
+	frame := unsolicitedNA(mac, ip)
+	if len(frame) != 86 {
+		t.Fatalf("Expected a 86 bytes frame, got %d", len(frame))
+	}
+	if !bytes.Equal(frame[0:6], allNodesMAC) || !bytes.Equal(frame[6:12], mac) {
+		t.Fatalf("Unexpected ethernet addresses in %x", frame[0:12])
+	}
+	if !bytes.Equal(frame[12:14], []byte{0x86, 0xdd}) {
+		t.Fatalf("Unexpected ethernet type %x", frame[12:14])
+	}
+	if frame[20] != 58 || frame[21] != 255 {
+		t.Fatalf("Unexpected next header %d or hop limit %d", frame[20], frame[21])
+	}
+	if !bytes.Equal(frame[22:38], ip) || !net.IP(frame[38:54]).Equal(net.IPv6linklocalallnodes) {
+		t.Fatalf("Unexpected IPv6 addresses in %x", frame[22:54])
+	}
+	if frame[54] != 136 || frame[58] != 0x20 {
+		t.Fatalf("Expected an overriding neighbor advertisement, got type %d flags %x", frame[54], frame[58])
+	}
+	if !bytes.Equal(frame[62:78], ip) || !bytes.Equal(frame[80:86], mac) {
+		t.Fatalf("Unexpected advertised addresses in %x", frame[62:86])
+	}
+	// The checksum of a message including its checksum is zero
+	if sum := icmpv6Checksum(frame[22:38], frame[38:54], frame[54:86]); sum != 0 {
+		t.Fatalf("Invalid checksum, verification yields %x", sum)
+	}
+}
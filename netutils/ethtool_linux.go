@@ -0,0 +1,70 @@
+package netutils
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// The ethtool commands of linux/ethtool.h getting and setting the offloads
+const (
+	EthtoolGTxCsum = 0x16
+	EthtoolSTxCsum = 0x17
+	EthtoolSTSO    = 0x1f
+	EthtoolSGRO    = 0x2c
+)
+
+const (
+	siocEthtool = 0x8946
+	// ifreqSize is the size of the struct ifreq of the ioctls on 64 bits,
+	// the largest one
+	ifreqSize = 40
+)
+
+// ethtoolValue is the struct ethtool_value of the ethtool ioctl
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+// ethtoolIfreq is the struct ifreq of the ethtool ioctl, padded to the size
+// of the kernel one, which the kernel copies in and out whole
+type ethtoolIfreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [ifreqSize - syscall.IFNAMSIZ - unsafe.Sizeof(unsafe.Pointer(nil))]byte
+}
+
+// ethtool runs the ethtool command on the interface with the value and
+// returns the value the kernel wrote back
+func ethtool(name string, cmd, data uint32) (uint32, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return 0, fmt.Errorf("invalid interface name %q", name)
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(fd)
+
+	value := ethtoolValue{cmd: cmd, data: data}
+	req := ethtoolIfreq{data: unsafe.Pointer(&value)}
+	copy(req.name[:], name)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return 0, errno
+	}
+	return value.data, nil
+}
+
+// EthtoolGet returns the value of the interface the ethtool get command
+// reads, the equivalent of `ethtool -k <name>` for an offload
+func EthtoolGet(name string, cmd uint32) (uint32, error) {
+	return ethtool(name, cmd, 0)
+}
+
+// EthtoolSet sets the value of the interface with the ethtool set command,
+// the equivalent of `ethtool -K <name> gro on` for an offload
+func EthtoolSet(name string, cmd, value uint32) error {
+	_, err := ethtool(name, cmd, value)
+	return err
+}
@@ -0,0 +1,21 @@
+package netutils
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestEthtoolIfreqSize(t *testing.T) {
+	if s := unsafe.Sizeof(ethtoolIfreq{}); s != ifreqSize {
+		t.Fatalf("Expected the ifreq to be %d bytes, got %d", ifreqSize, s)
+	}
+}
+
+func TestEthtoolGet(t *testing.T) {
+	if _, err := EthtoolGet("lo", EthtoolGTxCsum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EthtoolGet("averyveryverylongname", EthtoolGTxCsum); err == nil {
+		t.Fatal("Expected a failure for an invalid interface name")
+	}
+}
@@ -0,0 +1,70 @@
+// Package netwatch notifies its subscribers of the changes made to host
+// network devices, so that the drivers can find out about the devices they
+// manage being altered or removed behind their back.
+package netwatch
+
+import (
+	"fmt"
+	"net"
+)
+
+// EventType is the kind of change reported for a device
+type EventType int
+
+const (
+	// LinkDeleted is reported when the device is removed
+	LinkDeleted EventType = iota
+	// LinkDown is reported when the device is brought down
+	LinkDown
+	// LinkRenamed is reported when the device name changes
+	LinkRenamed
+	// AddrDeleted is reported when an address is removed from the device
+	AddrDeleted
+	// AddrAdded is reported when an address is added to the device
+	AddrAdded
+	// LinkAdded is reported to the watches by name when a device gets the
+	// watched name, by creation or rename
+	LinkAdded
+)
+
+func (t EventType) String() string {
+	switch t {
+	case LinkDeleted:
+		return "deleted"
+	case LinkDown:
+		return "down"
+	case LinkRenamed:
+		return "renamed"
+	case AddrDeleted:
+		return "address deleted"
+	case AddrAdded:
+		return "address added"
+	case LinkAdded:
+		return "added"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Event describes a change of a watched device
+type Event struct {
+	Type EventType
+	// Index is the kernel index of the device
+	Index int
+	// Name is the current name of the device
+	Name string
+	// Addr is the removed or added address for AddrDeleted and AddrAdded
+	// events
+	Addr *net.IPNet
+}
+
+func (e Event) String() string {
+	if e.Addr != nil {
+		return fmt.Sprintf("%s %s: %s", e.Name, e.Type, e.Addr)
+	}
+	return fmt.Sprintf("%s %s", e.Name, e.Type)
+}
+
+// Handler is called with the events of a watched device. Handlers are
+// invoked from the watcher goroutine and must not block.
+type Handler func(Event)
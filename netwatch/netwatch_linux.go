@@ -0,0 +1,306 @@
+package netwatch
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink/nl"
+)
+
+type subscription struct {
+	name    string
+	handler Handler
+	// byName is set on the watches which follow the name rather than the
+	// device, index is the device they are attached to, 0 while no device
+	// has the name
+	byName bool
+	index  int
+}
+
+// Watcher dispatches the link and address events received from the kernel
+// to the handlers watching the affected devices.
+type Watcher struct {
+	sock *nl.NetlinkSocket
+	subs map[int]map[int]*subscription // key: device index, subscription id
+	// named are the watches by name of the names no device has, by name
+	// and subscription id
+	named  map[string]map[int]*subscription
+	nextID int
+	closed bool
+	sync.Mutex
+}
+
+// New subscribes to the kernel link and address notifications.
+func New() (*Watcher, error) {
+	sock, err := nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK,
+		syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to netlink events: %v", err)
+	}
+
+	w := &Watcher{sock: sock, subs: make(map[int]map[int]*subscription), named: make(map[string]map[int]*subscription)}
+	go w.loop()
+
+	return w, nil
+}
+
+// Watch calls h with the events of the device currently named name. The
+// device is tracked by index, so renames are reported as such. The
+// returned function cancels the watch. Watches of a deleted device are
+// cancelled after the deletion is reported.
+func (w *Watcher) Watch(name string, h Handler) (func(), error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %v", name, err)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return nil, fmt.Errorf("watcher is closed")
+	}
+
+	id := w.nextID
+	w.nextID++
+	s := &subscription{name: name, handler: h}
+	w.attach(id, s, iface.Index)
+
+	return func() { w.unsubscribe(id, s) }, nil
+}
+
+// WatchName calls h with the events of the device named name, if any. The
+// watch follows the name rather than the device: it outlives the deletion
+// of the device, or its rename to another name, and attaches to the next
+// device getting the name, which is reported as LinkAdded. The device
+// needs not exist yet. The returned function cancels the watch.
+func (w *Watcher) WatchName(name string, h Handler) (func(), error) {
+	index := 0
+	if iface, err := net.InterfaceByName(name); err == nil {
+		index = iface.Index
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return nil, fmt.Errorf("watcher is closed")
+	}
+
+	id := w.nextID
+	w.nextID++
+	s := &subscription{name: name, handler: h, byName: true}
+	if index != 0 {
+		w.attach(id, s, index)
+	} else {
+		w.detach(id, s)
+	}
+
+	return func() { w.unsubscribe(id, s) }, nil
+}
+
+// attach subscribes s to the events of the device with the given index
+func (w *Watcher) attach(id int, s *subscription, index int) {
+	if _, ok := w.subs[index]; !ok {
+		w.subs[index] = make(map[int]*subscription)
+	}
+	w.subs[index][id] = s
+	s.index = index
+}
+
+// detach keeps the watch by name s until a device gets its name
+func (w *Watcher) detach(id int, s *subscription) {
+	if w.named == nil {
+		w.named = make(map[string]map[int]*subscription)
+	}
+	if _, ok := w.named[s.name]; !ok {
+		w.named[s.name] = make(map[int]*subscription)
+	}
+	w.named[s.name][id] = s
+	s.index = 0
+}
+
+// remove drops the subscription from the watches of the device it is
+// attached to, or of its name
+func (w *Watcher) remove(id int, s *subscription) {
+	if s.index == 0 {
+		if subs, ok := w.named[s.name]; ok {
+			delete(subs, id)
+			if len(subs) == 0 {
+				delete(w.named, s.name)
+			}
+		}
+		return
+	}
+	if subs, ok := w.subs[s.index]; ok {
+		delete(subs, id)
+		if len(subs) == 0 {
+			delete(w.subs, s.index)
+		}
+	}
+}
+
+func (w *Watcher) unsubscribe(id int, s *subscription) {
+	w.Lock()
+	defer w.Unlock()
+	w.remove(id, s)
+}
+
+// Close stops the watcher. No handler is called after Close returns.
+func (w *Watcher) Close() {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.subs = make(map[int]map[int]*subscription)
+	w.named = make(map[string]map[int]*subscription)
+	w.sock.Close()
+}
+
+func (w *Watcher) isClosed() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.closed
+}
+
+func (w *Watcher) loop() {
+	for {
+		msgs, err := w.sock.Recieve()
+		if err != nil {
+			if w.isClosed() {
+				return
+			}
+			logrus.Errorf("Failed to receive from netlink: %v", err)
+			continue
+		}
+
+		for _, msg := range msgs {
+			if ev, ok := parseMessage(msg); ok {
+				w.dispatch(ev)
+			}
+		}
+	}
+}
+
+func (w *Watcher) dispatch(ev Event) {
+	type call struct {
+		h  Handler
+		ev Event
+	}
+	var calls []call
+
+	w.Lock()
+	subs := w.subs[ev.Index]
+	for id, s := range subs {
+		if ev.Type == LinkRenamed && s.name == ev.Name {
+			// Other link changes are reported with the same name
+			continue
+		}
+		calls = append(calls, call{s.handler, ev})
+		switch {
+		case ev.Type == LinkDeleted:
+			delete(subs, id)
+			if s.byName {
+				w.detach(id, s)
+			}
+		case ev.Type == LinkRenamed && s.byName:
+			// The name is watched, not the device which left it
+			delete(subs, id)
+			w.detach(id, s)
+		case ev.Type == LinkRenamed:
+			s.name = ev.Name
+		}
+	}
+	if subs != nil && len(subs) == 0 {
+		delete(w.subs, ev.Index)
+	}
+
+	// The link updates report the devices created or renamed, which the
+	// watches of their name attach to
+	if ev.Type == LinkDown || ev.Type == LinkRenamed {
+		for id, s := range w.named[ev.Name] {
+			w.remove(id, s)
+			w.attach(id, s, ev.Index)
+			calls = append(calls, call{s.handler, Event{Type: LinkAdded, Index: ev.Index, Name: ev.Name}})
+		}
+	}
+	w.Unlock()
+
+	for _, c := range calls {
+		c.h(c.ev)
+	}
+}
+
+// parseMessage converts a link or address notification into an event.
+// Link updates which are not a rename are reported as LinkDown if the
+// device is down, and dropped otherwise. Renames are filtered by dispatch
+// against the name known for the device.
+func parseMessage(msg syscall.NetlinkMessage) (Event, bool) {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(msg.Data) < syscall.SizeofIfInfomsg {
+			return Event{}, false
+		}
+		info := nl.DeserializeIfInfomsg(msg.Data)
+		ev := Event{Index: int(info.Index)}
+
+		attrs, err := nl.ParseRouteAttr(msg.Data[info.Len():])
+		if err != nil {
+			return Event{}, false
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFNAME {
+				ev.Name = string(attr.Value[:len(attr.Value)-1])
+			}
+		}
+
+		if msg.Header.Type == syscall.RTM_DELLINK {
+			ev.Type = LinkDeleted
+			return ev, true
+		}
+		if info.Flags&syscall.IFF_UP == 0 {
+			ev.Type = LinkDown
+			return ev, true
+		}
+		ev.Type = LinkRenamed
+		return ev, true
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(msg.Data) < syscall.SizeofIfAddrmsg {
+			return Event{}, false
+		}
+		info := nl.DeserializeIfAddrmsg(msg.Data)
+		ev := Event{Type: AddrDeleted, Index: int(info.Index)}
+		if msg.Header.Type == syscall.RTM_NEWADDR {
+			ev.Type = AddrAdded
+		}
+
+		attrs, err := nl.ParseRouteAttr(msg.Data[info.Len():])
+		if err != nil {
+			return Event{}, false
+		}
+		bits := 32
+		if info.Family == syscall.AF_INET6 {
+			bits = 128
+		}
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_LOCAL:
+				ev.Addr = &net.IPNet{IP: net.IP(attr.Value), Mask: net.CIDRMask(int(info.Prefixlen), bits)}
+			case syscall.IFA_ADDRESS:
+				if ev.Addr == nil {
+					ev.Addr = &net.IPNet{IP: net.IP(attr.Value), Mask: net.CIDRMask(int(info.Prefixlen), bits)}
+				}
+			}
+		}
+		return ev, ev.Addr != nil
+	}
+
+	return Event{}, false
+}
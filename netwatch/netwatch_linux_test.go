@@ -0,0 +1,115 @@
+package netwatch
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+func linkMessage(typ uint16, index int32, flags uint32, name string) syscall.NetlinkMessage {
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = index
+	msg.Flags = flags
+	data := append(msg.Serialize(), nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)).Serialize()...)
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+func TestParseLinkMessage(t *testing.T) {
+	ev, ok := parseMessage(linkMessage(syscall.RTM_DELLINK, 5, 0, "br0"))
+	if !ok || ev.Type != LinkDeleted || ev.Index != 5 || ev.Name != "br0" {
+		t.Fatalf("Unexpected event for link deletion: %v %v", ok, ev)
+	}
+
+	ev, ok = parseMessage(linkMessage(syscall.RTM_NEWLINK, 5, 0, "br0"))
+	if !ok || ev.Type != LinkDown {
+		t.Fatalf("Unexpected event for link down: %v %v", ok, ev)
+	}
+
+	ev, ok = parseMessage(linkMessage(syscall.RTM_NEWLINK, 5, syscall.IFF_UP, "br1"))
+	if !ok || ev.Type != LinkRenamed || ev.Name != "br1" {
+		t.Fatalf("Unexpected event for link update: %v %v", ok, ev)
+	}
+
+	if _, ok := parseMessage(syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}}); ok {
+		t.Fatal("Expected truncated message to be dropped")
+	}
+}
+
+func TestParseAddrMessage(t *testing.T) {
+	msg := nl.NewIfAddrmsg(syscall.AF_INET)
+	msg.Index = 7
+	msg.Prefixlen = 16
+	data := append(msg.Serialize(), nl.NewRtAttr(syscall.IFA_LOCAL, net.ParseIP("172.17.42.1").To4()).Serialize()...)
+
+	ev, ok := parseMessage(syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_DELADDR}, Data: data})
+	if !ok || ev.Type != AddrDeleted || ev.Index != 7 {
+		t.Fatalf("Unexpected event for address deletion: %v %v", ok, ev)
+	}
+	if ev.Addr.String() != "172.17.42.1/16" {
+		t.Fatalf("Unexpected address %s", ev.Addr)
+	}
+
+	ev, ok = parseMessage(syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}, Data: data})
+	if !ok || ev.Type != AddrAdded || ev.Addr.String() != "172.17.42.1/16" {
+		t.Fatalf("Unexpected event for address addition: %v %v", ok, ev)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	w := &Watcher{subs: make(map[int]map[int]*subscription)}
+	var got []Event
+	w.subs[3] = map[int]*subscription{0: {name: "br0", handler: func(ev Event) { got = append(got, ev) }}}
+
+	// Updates which keep the name are not renames
+	w.dispatch(Event{Type: LinkRenamed, Index: 3, Name: "br0"})
+	w.dispatch(Event{Type: LinkRenamed, Index: 3, Name: "br1"})
+	w.dispatch(Event{Type: LinkRenamed, Index: 3, Name: "br1"})
+	w.dispatch(Event{Type: LinkDown, Index: 4, Name: "eth0"})
+	w.dispatch(Event{Type: LinkDeleted, Index: 3, Name: "br1"})
+	w.dispatch(Event{Type: LinkDown, Index: 3, Name: "br1"})
+
+	if len(got) != 2 || got[0].Type != LinkRenamed || got[1].Type != LinkDeleted {
+		t.Fatalf("Unexpected events: %v", got)
+	}
+	if _, ok := w.subs[3]; ok {
+		t.Fatal("Subscriptions of the deleted link were not removed")
+	}
+}
+
+func TestDispatchByName(t *testing.T) {
+	w := &Watcher{subs: make(map[int]map[int]*subscription)}
+	var got []Event
+	s := &subscription{name: "ppp0", handler: func(ev Event) { got = append(got, ev) }, byName: true}
+	w.detach(0, s)
+
+	// The watch attaches to the device created with the name, and outlives
+	// its deletion and its rename
+	w.dispatch(Event{Type: LinkDown, Index: 4, Name: "eth0"})
+	w.dispatch(Event{Type: LinkDown, Index: 5, Name: "ppp0"})
+	w.dispatch(Event{Type: AddrAdded, Index: 5, Name: "ppp0"})
+	w.dispatch(Event{Type: LinkDeleted, Index: 5, Name: "ppp0"})
+	w.dispatch(Event{Type: AddrAdded, Index: 5, Name: "ppp0"})
+	w.dispatch(Event{Type: LinkRenamed, Index: 6, Name: "ppp0"})
+	w.dispatch(Event{Type: LinkRenamed, Index: 6, Name: "ppp1"})
+	w.dispatch(Event{Type: AddrAdded, Index: 6, Name: "ppp1"})
+
+	expected := []EventType{LinkAdded, AddrAdded, LinkDeleted, LinkAdded, LinkRenamed}
+	if len(got) != len(expected) {
+		t.Fatalf("Unexpected events: %v", got)
+	}
+	for i, typ := range expected {
+		if got[i].Type != typ {
+			t.Fatalf("Expected event %d to be %s, got %v", i, typ, got[i])
+		}
+	}
+	if got[3].Index != 6 || len(w.subs) != 0 || len(w.named["ppp0"]) != 1 {
+		t.Fatalf("Watch by name was not kept: %v %v %v", got[3], w.subs, w.named)
+	}
+
+	w.unsubscribe(0, s)
+	if len(w.named) != 0 {
+		t.Fatal("Watch by name was not cancelled")
+	}
+}
@@ -0,0 +1,26 @@
+// +build !linux
+
+package netwatch
+
+import "errors"
+
+// Watcher is not supported on this platform
+type Watcher struct{}
+
+// New returns an error on platforms without netlink
+func New() (*Watcher, error) {
+	return nil, errors.New("netlink events are not supported on this platform")
+}
+
+// Watch is not supported on this platform
+func (w *Watcher) Watch(name string, h Handler) (func(), error) {
+	return nil, errors.New("netlink events are not supported on this platform")
+}
+
+// WatchName is not supported on this platform
+func (w *Watcher) WatchName(name string, h Handler) (func(), error) {
+	return nil, errors.New("netlink events are not supported on this platform")
+}
+
+// Close is a no-op on this platform
+func (w *Watcher) Close() {}
@@ -1,16 +1,20 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"net"
+	"strings"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/dnszone"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
@@ -31,10 +35,10 @@ type Network interface {
 	// Create a new endpoint to this network symbolically identified by the
 	// specified unique name. The options parameter carry driver specific options.
 	// Labels support will be added in the near future.
-	CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error)
+	CreateEndpoint(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error)
 
 	// Delete the network.
-	Delete() error
+	Delete(ctx context.Context) error
 
 	// Endpoints returns the list of Endpoint(s) in this network.
 	Endpoints() []Endpoint
@@ -47,13 +51,57 @@ type Network interface {
 
 	// EndpointByID returns the Endpoint which has the passed id. If not found, the error ErrNoSuchEndpoint is returned.
 	EndpointByID(id string) (Endpoint, error)
+
+	// AuditLog returns the operations which changed the state of the network, oldest first.
+	AuditLog() ([]AuditRecord, error)
+
+	// ProgrammedState returns the devices, routes and rules the driver programmed on the host
+	// for the network.
+	ProgrammedState() (*driverapi.ProgrammedState, error)
+
+	// VerifyState returns the differences between the programmed state of the network and the
+	// kernel state, none if they match.
+	VerifyState() ([]driverapi.Drift, error)
+
+	// AddServiceRecord makes the service name resolve to the address as well in the containers
+	// of the network, without rewriting the records of the other names.
+	AddServiceRecord(ctx context.Context, name string, ip net.IP) error
+
+	// DeleteServiceRecord removes the address from the ones the service name resolves to.
+	DeleteServiceRecord(ctx context.Context, name string, ip net.IP) error
+
+	// SyncServiceRecords replaces all the service records of the network at once, updating the
+	// containers with the records which changed only.
+	SyncServiceRecords(ctx context.Context, records map[string][]net.IP) error
+
+	// ConfigOnly tells whether the network only holds a configuration other networks are
+	// created from.
+	ConfigOnly() bool
+
+	// ConfigFrom returns the name of the configuration network the network was created from,
+	// if any.
+	ConfigFrom() string
+
+	// Labels returns the labels the network was created with.
+	Labels() map[string]string
+
+	// AllocateServiceVIP allocates a virtual IP to the service from the service VIP pool of the
+	// network and makes the service name resolve to it. A service keeps its VIP until released.
+	AllocateServiceVIP(ctx context.Context, name string) (net.IP, error)
+
+	// ReleaseServiceVIP releases the VIP of the service and removes its record.
+	ReleaseServiceVIP(ctx context.Context, name string) error
+
+	// Zone returns the service records of the network as the DNS zone <network>.<domain>, to
+	// be written as a zone file or transferred to a secondary DNS server.
+	Zone(domain string) *dnszone.Zone
 }
 
 // EndpointWalker is a client provided function which will be used to walk the Endpoints.
 // When the function returns true, the walk will stop.
 type EndpointWalker func(ep Endpoint) bool
 
-type svcMap map[string]net.IP
+type svcMap map[string][]net.IP
 
 type network struct {
 	ctrlr       *controller
@@ -67,8 +115,47 @@ type network struct {
 	generic     options.Generic
 	dbIndex     uint64
 	svcRecords  svcMap
+	// services are the service records set with the service record API,
+	// apart from the ones of the endpoints
+	services    svcMap
 	dbExists    bool
 	stopWatchCh chan struct{}
+	// globalScope keeps the configuration of a network of a local scope
+	// driver in the datastore, so that it is defined on all the hosts
+	globalScope bool
+	// materialized is set once the network is created in the driver
+	materialized bool
+	// dnsSearch and dnsOptions are merged into the resolv.conf of the
+	// containers attached to the network
+	dnsSearch  []string
+	dnsOptions []string
+	// tenant is the tenant whose quota the network is accounted to
+	tenant string
+	// maxEndpoints caps the endpoints of the network, zero for no cap
+	maxEndpoints int
+	// configOnly is set on the networks holding a configuration only,
+	// configFrom is the name of the one the network was created from
+	configOnly bool
+	configFrom string
+	// labels are the labels of the network, kept with it for its users
+	labels map[string]string
+	// zoneSerial is the serial of the DNS zone of the network, increased
+	// when zoneDigest, the digest of its records, changes
+	zoneSerial uint32
+	zoneDigest string
+	// vipPool is the subnet the service VIPs are allocated from by vipIPAM,
+	// vips are the VIPs allocated by service name
+	vipPool *net.IPNet
+	vipIPAM *ipam.Allocator
+	vips    map[string]net.IP
+	// assignedIPAM records the addresses the driver assigned to the
+	// endpoints on join, as owned by them
+	assignedIPAM *ipam.Allocator
+	// dryRun is set when the creation is only validated
+	dryRun *DryRunResult
+	// hostsMu serializes the changes of the records with the updates of
+	// the hosts files of the containers they are written to
+	hostsMu sync.Mutex
 	sync.Mutex
 }
 
@@ -97,14 +184,25 @@ func (n *network) Type() string {
 	return n.driver.Type()
 }
 
-func (n *network) Key() []string {
+func (n *network) Labels() map[string]string {
 	n.Lock()
 	defer n.Unlock()
-	return []string{datastore.NetworkKeyPrefix, string(n.id)}
+
+	labels := make(map[string]string, len(n.labels))
+	for k, v := range n.labels {
+		labels[k] = v
+	}
+	return labels
 }
 
-func (n *network) KeyPrefix() []string {
-	return []string{datastore.NetworkKeyPrefix}
+func (n *network) Key() datastore.KeyPath {
+	n.Lock()
+	defer n.Unlock()
+	return datastore.NewKeyPath(datastore.NetworkKeyPrefix, string(n.id))
+}
+
+func (n *network) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.NetworkKeyPrefix)
 }
 
 func (n *network) Value() []byte {
@@ -152,6 +250,27 @@ func (n *network) IncEndpointCnt() {
 	n.Unlock()
 }
 
+// admitEndpoint counts one more endpoint in the network, unless the network
+// already has as many endpoints as it is capped to
+func (n *network) admitEndpoint() error {
+	n.Lock()
+	defer n.Unlock()
+	if err := n.checkEndpointLimit(); err != nil {
+		return err
+	}
+	n.endpointCnt++
+	return nil
+}
+
+// checkEndpointLimit fails if the network has as many endpoints as it is
+// capped to. It is called with the network locked.
+func (n *network) checkEndpointLimit() error {
+	if n.maxEndpoints > 0 && n.endpointCnt >= uint64(n.maxEndpoints) {
+		return &EndpointLimitError{name: n.name, id: string(n.id), Limit: n.maxEndpoints}
+	}
+	return nil
+}
+
 func (n *network) DecEndpointCnt() {
 	n.Lock()
 	n.endpointCnt--
@@ -167,6 +286,47 @@ func (n *network) MarshalJSON() ([]byte, error) {
 	netMap["endpointCnt"] = n.endpointCnt
 	netMap["enableIPv6"] = n.enableIPv6
 	netMap["generic"] = n.generic
+	netMap["globalScope"] = n.globalScope
+	if len(n.dnsSearch) > 0 {
+		netMap["dnsSearch"] = n.dnsSearch
+	}
+	if len(n.dnsOptions) > 0 {
+		netMap["dnsOptions"] = n.dnsOptions
+	}
+	if n.tenant != "" {
+		netMap["tenant"] = n.tenant
+	}
+	if n.configOnly {
+		netMap["configOnly"] = true
+	}
+	if n.configFrom != "" {
+		netMap["configFrom"] = n.configFrom
+	}
+	if n.maxEndpoints != 0 {
+		netMap["maxEndpoints"] = n.maxEndpoints
+	}
+	if n.vipPool != nil {
+		netMap["vipPool"] = n.vipPool.String()
+	}
+	if len(n.vips) > 0 {
+		vips := make(map[string]string, len(n.vips))
+		for name, ip := range n.vips {
+			vips[name] = ip.String()
+		}
+		netMap["vips"] = vips
+	}
+	if len(n.labels) > 0 {
+		netMap["labels"] = n.labels
+	}
+	if len(n.services) > 0 {
+		services := make(map[string][]string, len(n.services))
+		for name, ips := range n.services {
+			for _, ip := range ips {
+				services[name] = append(services[name], ip.String())
+			}
+		}
+		netMap["services"] = services
+	}
 	return json.Marshal(netMap)
 }
 
@@ -184,6 +344,52 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if netMap["generic"] != nil {
 		n.generic = netMap["generic"].(map[string]interface{})
 	}
+	if v, ok := netMap["globalScope"]; ok {
+		n.globalScope = v.(bool)
+	}
+	if v, ok := netMap["dnsSearch"]; ok {
+		n.dnsSearch = stringList(v.([]interface{}))
+	}
+	if v, ok := netMap["dnsOptions"]; ok {
+		n.dnsOptions = stringList(v.([]interface{}))
+	}
+	if v, ok := netMap["tenant"]; ok {
+		n.tenant = v.(string)
+	}
+	if v, ok := netMap["configOnly"]; ok {
+		n.configOnly = v.(bool)
+	}
+	if v, ok := netMap["configFrom"]; ok {
+		n.configFrom = v.(string)
+	}
+	if v, ok := netMap["maxEndpoints"]; ok {
+		n.maxEndpoints = int(v.(float64))
+	}
+	if v, ok := netMap["vipPool"]; ok {
+		if _, n.vipPool, err = net.ParseCIDR(v.(string)); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["vips"]; ok {
+		n.vips = make(map[string]net.IP)
+		for name, ip := range v.(map[string]interface{}) {
+			n.vips[name] = net.ParseIP(ip.(string))
+		}
+	}
+	if v, ok := netMap["labels"]; ok {
+		n.labels = make(map[string]string)
+		for k, l := range v.(map[string]interface{}) {
+			n.labels[k] = l.(string)
+		}
+	}
+	if v, ok := netMap["services"]; ok {
+		n.services = svcMap{}
+		for name, ips := range v.(map[string]interface{}) {
+			for _, ip := range ips.([]interface{}) {
+				n.services[name] = append(n.services[name], net.ParseIP(ip.(string)))
+			}
+		}
+	}
 	return nil
 }
 
@@ -203,6 +409,55 @@ func NetworkOptionGeneric(generic map[string]interface{}) NetworkOption {
 	}
 }
 
+// NetworkOptionGlobalScope function returns an option setter to define a
+// network of a local scope driver, like bridge, at global scope. The network
+// configuration is kept in the datastore and every host creates the same
+// network when it is first used there, while the endpoints and the driver
+// state stay local to each host. The driver options must be in their string
+// form, as the other hosts read them back from the store.
+func NetworkOptionGlobalScope() NetworkOption {
+	return func(n *network) {
+		n.globalScope = true
+	}
+}
+
+// NetworkOptionDNSSearch function returns an option setter for the DNS
+// search domains of the containers attached to the network
+func NetworkOptionDNSSearch(domains ...string) NetworkOption {
+	return func(n *network) {
+		n.dnsSearch = append(n.dnsSearch, domains...)
+	}
+}
+
+// NetworkOptionDNSOptions function returns an option setter for the resolver
+// options, like "ndots:2", of the containers attached to the network
+func NetworkOptionDNSOptions(options ...string) NetworkOption {
+	return func(n *network) {
+		n.dnsOptions = append(n.dnsOptions, options...)
+	}
+}
+
+// NetworkOptionMaxEndpoints function returns an option setter capping the
+// number of endpoints of the network, whatever room its address pools have
+// left. The endpoint creations beyond the cap fail with EndpointLimitError.
+// Zero sets no cap.
+func NetworkOptionMaxEndpoints(max int) NetworkOption {
+	return func(n *network) {
+		n.maxEndpoints = max
+	}
+}
+
+// NetworkOptionLabels function returns an option setter for the labels of
+// the network, which are kept with it and returned by Labels
+func NetworkOptionLabels(labels map[string]string) NetworkOption {
+	return func(n *network) {
+		n.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			n.labels[k] = v
+		}
+	}
+}
+
 func (n *network) processOptions(options ...NetworkOption) {
 	for _, opt := range options {
 		if opt != nil {
@@ -211,13 +466,36 @@ func (n *network) processOptions(options ...NetworkOption) {
 	}
 }
 
-func (n *network) Delete() error {
+func (n *network) Delete(ctx context.Context) error {
+	n.Lock()
+	ctrlr := n.ctrlr
+	ev := HookEvent{Point: HookPreDeleteNetwork, NetworkType: n.networkType, Name: n.name, Network: n}
+	n.Unlock()
+
+	err := n.authorize(ctx, AuditNetworkDelete, nil)
+	if err == nil {
+		err = ctrlr.runPreHooks(ctx, ev)
+	}
+	if err == nil {
+		err = n.delete(ctx)
+	}
+	ctrlr.audit(ctx, n, AuditNetworkDelete, ev.Name, nil, err)
+	ev.Point = HookPostDeleteNetwork
+	ctrlr.runPostHooks(ctx, ev, err)
+	return err
+}
+
+func (n *network) delete(ctx context.Context) error {
 	var err error
 
 	n.Lock()
 	ctrlr := n.ctrlr
 	n.Unlock()
 
+	if ctrlr.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
 	ctrlr.Lock()
 	_, ok := ctrlr.networks[n.id]
 	ctrlr.Unlock()
@@ -231,36 +509,53 @@ func (n *network) Delete() error {
 		return &ActiveEndpointsError{name: n.name, id: string(n.id)}
 	}
 
+	if n.ConfigOnly() {
+		if users := ctrlr.configUsers(n.Name()); len(users) != 0 {
+			return types.ForbiddenErrorf("configuration network %s is in use by networks %s", n.Name(), strings.Join(users, ", "))
+		}
+	}
+
 	// deleteNetworkFromStore performs an atomic delete operation and the network.endpointCnt field will help
 	// prevent any possible race between endpoint join and network delete
-	if err = ctrlr.deleteNetworkFromStore(n); err != nil {
+	if err = ctrlr.deleteNetworkFromStore(ctx, n); err != nil {
 		if err == datastore.ErrKeyModified {
 			return types.InternalErrorf("operation in progress. delete failed for network %s. Please try again.")
 		}
 		return err
 	}
 
-	if err = n.deleteNetwork(); err != nil {
+	if err = n.deleteNetwork(ctx); err != nil {
 		return err
 	}
+	n.deleteExternalEndpoints(ctx)
+
+	n.Lock()
+	tenant := n.tenant
+	n.Unlock()
+	ctrlr.releaseQuota(tenant, 1, 0)
 
 	return nil
 }
 
-func (n *network) deleteNetwork() error {
+func (n *network) deleteNetwork(ctx context.Context) error {
 	n.Lock()
-	id := n.id
 	d := n.driver
+	materialized := n.materialized
 	n.ctrlr.Lock()
-	delete(n.ctrlr.networks, id)
+	n.ctrlr.unsetNetwork(n)
 	n.ctrlr.Unlock()
 	n.Unlock()
 
-	if err := d.DeleteNetwork(n.id); err != nil {
+	if !materialized {
+		n.stopWatch()
+		return nil
+	}
+
+	if err := d.DeleteNetwork(ctx, n.id); err != nil {
 		// Forbidden Errors should be honored
 		if _, ok := err.(types.ForbiddenError); ok {
 			n.ctrlr.Lock()
-			n.ctrlr.networks[n.id] = n
+			n.ctrlr.setNetwork(n)
 			n.ctrlr.Unlock()
 			return err
 		}
@@ -270,8 +565,22 @@ func (n *network) deleteNetwork() error {
 	return nil
 }
 
-func (n *network) addEndpoint(ep *endpoint) error {
+func (n *network) addEndpoint(ctx context.Context, ep *endpoint) error {
 	var err error
+
+	// A read-only controller only keeps track of the endpoints in the store
+	if n.ctrlr.isReadOnly() {
+		n.Lock()
+		n.endpoints[ep.id] = ep
+		n.Unlock()
+		n.updateSvcRecord(ep, true)
+		return nil
+	}
+
+	if err = n.materialize(ctx); err != nil {
+		return err
+	}
+
 	n.Lock()
 	n.endpoints[ep.id] = ep
 	d := n.driver
@@ -285,7 +594,7 @@ func (n *network) addEndpoint(ep *endpoint) error {
 		}
 	}()
 
-	err = d.CreateEndpoint(n.id, ep.id, ep, ep.generic)
+	err = d.CreateEndpoint(ctx, n.id, ep.id, ep, ep.driverOptions(n.ctrlr))
 	if err != nil {
 		return err
 	}
@@ -294,12 +603,59 @@ func (n *network) addEndpoint(ep *endpoint) error {
 	return nil
 }
 
-func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+func (n *network) CreateEndpoint(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error) {
+	n.Lock()
+	ctrlr := n.ctrlr
+	ev := HookEvent{Point: HookPostCreateEndpoint, NetworkType: n.networkType, Name: name, Network: n}
+	n.Unlock()
+
+	req := n.authzRequest(AuditEndpointCreate, nil)
+	req.Endpoint = name
+	if err := ctrlr.authorize(ctx, req); err != nil {
+		ctrlr.audit(ctx, n, AuditEndpointCreate, name, nil, err)
+		return nil, err
+	}
+
+	ep, err := n.createEndpoint(ctx, name, options...)
+	err, aborted := abortedByHook(err)
+	if ep != nil && ep.dryRun != nil {
+		if !aborted {
+			ev.DryRun = true
+			ctrlr.runPostHooks(ctx, ev, err)
+		}
+		return nil, err
+	}
+	var params map[string]string
+	if ep != nil {
+		params = map[string]string{"id": string(ep.id)}
+	}
+	ctrlr.audit(ctx, n, AuditEndpointCreate, name, params, err)
+	if err != nil {
+		if !aborted {
+			ctrlr.runPostHooks(ctx, ev, err)
+		}
+		return nil, err
+	}
+	ev.Endpoint = ep
+	ctrlr.runPostHooks(ctx, ev, nil)
+	return ep, nil
+}
+
+// createEndpoint returns the endpoint on success or on a dry run
+func (n *network) createEndpoint(ctx context.Context, name string, options ...EndpointOption) (*endpoint, error) {
 	var err error
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
 	}
 
+	if n.ctrlr.isReadOnly() {
+		return nil, ErrReadOnly{}
+	}
+
+	if n.ConfigOnly() {
+		return nil, types.ForbiddenErrorf("network %s only holds a configuration, it has no endpoints", n.Name())
+	}
+
 	if _, err = n.EndpointByName(name); err == nil {
 		return nil, types.ForbiddenErrorf("service endpoint with name %s already exists", name)
 	}
@@ -310,35 +666,100 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 	ep.id = types.UUID(stringid.GenerateRandomID())
 	ep.network = n
 	ep.processOptions(options...)
+	if r := ep.serviceRegistration(); !r.valid() {
+		return nil, types.BadRequestErrorf("invalid service registration %q", r)
+	}
 
 	n.Lock()
 	ctrlr := n.ctrlr
+	ev := HookEvent{Point: HookPreCreateEndpoint, NetworkType: n.networkType, Name: name, Network: n, DryRun: ep.dryRun != nil}
 	n.Unlock()
 
-	n.IncEndpointCnt()
-	if err = ctrlr.updateNetworkToStore(n); err != nil {
+	// The pre hooks are told whether the creation is only validated
+	if err = ctrlr.runPreHooks(ctx, ev); err != nil {
+		if ep.dryRun == nil {
+			ep = nil
+		}
+		return ep, &hookAbortError{err}
+	}
+
+	// The host ports are checked against the other endpoints of the host
+	// before the driver programs them
+	if ep.dryRun != nil {
+		n.Lock()
+		err = n.checkEndpointLimit()
+		n.Unlock()
+		if err != nil {
+			return ep, err
+		}
+		if err = ctrlr.ports.check(ep.portBindings()); err != nil {
+			return ep, err
+		}
+		return ep, n.planEndpoint(ep)
+	}
+
+	// The cleanups must not be abandoned with the request
+	if err = n.admitEndpoint(); err != nil {
 		return nil, err
 	}
+	// The count is given back when the store update fails as well
 	defer func() {
 		if err != nil {
 			n.DecEndpointCnt()
-			if err = ctrlr.updateNetworkToStore(n); err != nil {
+			if err = ctrlr.updateNetworkToStore(context.Background(), n); err != nil {
 				log.Warnf("endpoint count cleanup failed when updating network for %s : %v", name, err)
 			}
 		}
 	}()
-	if err = n.addEndpoint(ep); err != nil {
+	if err = ctrlr.updateNetworkToStore(ctx, n); err != nil {
+		return nil, err
+	}
+	if err = ctrlr.checkEndpointQuota(n); err != nil {
+		return nil, err
+	}
+	// The deferred ports are reserved once bound
+	var bindings []types.PortBinding
+	n.Lock()
+	d := n.driver
+	n.Unlock()
+	if !ep.portsDeferred() {
+		bindings = ep.portBindings()
+	} else if _, ok := d.(driverapi.ExternalConnectivitySetter); !ok {
+		err = types.NotImplementedErrorf("%s driver does not support deferring the port binding", d.Type())
+		return nil, err
+	}
+	if err = ctrlr.ports.reserve(ep.id, name, bindings); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ctrlr.ports.release(ep.id)
+		}
+	}()
+	if err = n.addEndpoint(ctx, ep); err != nil {
 		return nil, err
 	}
+	if _, external := ctrlr.pub.get(); !external {
+		ep.publishPorts(ctrlr)
+	}
 	defer func() {
 		if err != nil {
-			if e := ep.Delete(); ep != nil {
+			// The endpoint count is restored by the cleanup above
+			if e := ep.deleteEndpoint(context.Background()); e != nil {
 				log.Warnf("cleaning up endpoint failed %s : %v", name, e)
 			}
 		}
 	}()
 
-	if err = ctrlr.updateEndpointToStore(ep); err != nil {
+	if err = ep.chargeQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = ctrlr.updateEndpointToStore(ctx, ep); err != nil {
+		return nil, err
+	}
+
+	if err = ep.publishExternal(ctx, ctrlr); err != nil {
 		return nil, err
 	}
 
@@ -399,34 +820,96 @@ func (n *network) EndpointByID(id string) (Endpoint, error) {
 	return nil, ErrNoSuchEndpoint(id)
 }
 
+// isGlobalScoped tells whether the network configuration is kept in the
+// datastore
 func (n *network) isGlobalScoped() (bool, error) {
 	n.Lock()
 	c := n.ctrlr
+	global := n.globalScope
 	n.Unlock()
+	if global {
+		return true, nil
+	}
 	return c.isDriverGlobalScoped(n.networkType)
 }
 
+// isEndpointGlobalScoped tells whether the network endpoints are kept in the
+// datastore. Endpoints of networks defined at global scope through a local
+// scope driver are local to each host.
+func (n *network) isEndpointGlobalScoped() (bool, error) {
+	n.Lock()
+	c := n.ctrlr
+	n.Unlock()
+	return c.isDriverGlobalScoped(n.networkType)
+}
+
+// materialize creates the network in the driver, unless it was already
+func (n *network) materialize(ctx context.Context) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.materialized {
+		return nil
+	}
+	if err := n.driver.CreateNetwork(ctx, n.id, n.generic); err != nil {
+		return err
+	}
+	n.materialized = true
+
+	return nil
+}
+
+// normalizeGeneric replaces the network options with their datastore
+// representation, which is what the other hosts pass to their driver.
+func (n *network) normalizeGeneric() error {
+	b, err := json.Marshal(n.generic)
+	if err != nil {
+		return err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return err
+	}
+	n.generic = generic
+	return nil
+}
+
 func (n *network) updateSvcRecord(ep *endpoint, isAdd bool) {
+	if isAdd && !ep.serviceRegistered() {
+		return
+	}
+
+	n.hostsMu.Lock()
+	defer n.hostsMu.Unlock()
+
 	n.Lock()
 	var recs []etchosts.Record
-	for _, iface := range ep.InterfaceList() {
-		if isAdd {
-			n.svcRecords[ep.Name()] = iface.Address().IP
-			n.svcRecords[ep.Name()+"."+n.name] = iface.Address().IP
-		} else {
+	ep.Lock()
+	ifaces := ep.iFaces
+	ep.Unlock()
+	for _, iface := range ifaces {
+		ips := iface.recordAddresses()
+		if !isAdd {
 			delete(n.svcRecords, ep.Name())
 			delete(n.svcRecords, ep.Name()+"."+n.name)
+		} else if len(ips) != 0 {
+			// The interfaces whose addresses the driver reports on
+			// join have none until then
+			n.svcRecords[ep.Name()] = ips
+			n.svcRecords[ep.Name()+"."+n.name] = ips
 		}
 
-		recs = append(recs, etchosts.Record{
-			Hosts: ep.Name(),
-			IP:    iface.Address().IP.String(),
-		})
+		for _, ip := range ips {
+			recs = append(recs, etchosts.Record{
+				Hosts: ep.Name(),
+				IP:    ip.String(),
+			})
 
-		recs = append(recs, etchosts.Record{
-			Hosts: ep.Name() + "." + n.name,
-			IP:    iface.Address().IP.String(),
-		})
+			recs = append(recs, etchosts.Record{
+				Hosts: ep.Name() + "." + n.name,
+				IP:    ip.String(),
+			})
+		}
 	}
 	n.Unlock()
 
@@ -435,6 +918,18 @@ func (n *network) updateSvcRecord(ep *endpoint, isAdd bool) {
 		return
 	}
 
+	for _, cEp := range n.containerEndpoints() {
+		if isAdd {
+			cEp.addHostEntries(recs)
+		} else {
+			cEp.deleteHostEntries(recs)
+		}
+	}
+}
+
+// containerEndpoints returns the endpoints of the network joined by a
+// container
+func (n *network) containerEndpoints() []*endpoint {
 	var epList []*endpoint
 	n.WalkEndpoints(func(e Endpoint) bool {
 		cEp := e.(*endpoint)
@@ -445,14 +940,7 @@ func (n *network) updateSvcRecord(ep *endpoint, isAdd bool) {
 		cEp.Unlock()
 		return false
 	})
-
-	for _, cEp := range epList {
-		if isAdd {
-			cEp.addHostEntries(recs)
-		} else {
-			cEp.deleteHostEntries(recs)
-		}
-	}
+	return epList
 }
 
 func (n *network) getSvcRecords() []etchosts.Record {
@@ -460,11 +948,16 @@ func (n *network) getSvcRecords() []etchosts.Record {
 	defer n.Unlock()
 
 	var recs []etchosts.Record
-	for h, ip := range n.svcRecords {
-		recs = append(recs, etchosts.Record{
-			Hosts: h,
-			IP:    ip.String(),
-		})
+	for h, ips := range n.svcRecords {
+		for _, ip := range ips {
+			recs = append(recs, etchosts.Record{
+				Hosts: h,
+				IP:    ip.String(),
+			})
+		}
+	}
+	for name, ips := range n.services {
+		recs = append(recs, serviceHostRecords(name, n.name, ips)...)
 	}
 
 	return recs
@@ -0,0 +1,156 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkOptionConfigOnly function returns an option setter for a network
+// which only holds a configuration: its driver options, IPv6 setting, DNS
+// options and endpoint cap are the template the networks created with NetworkOptionConfigFrom
+// are instantiated from. A configuration network is never created in its
+// driver and has no endpoints. With NetworkOptionGlobalScope, it is kept in
+// the datastore for every host to instantiate it.
+func NetworkOptionConfigOnly() NetworkOption {
+	return func(n *network) {
+		n.configOnly = true
+	}
+}
+
+// NetworkOptionConfigFrom function returns an option setter for a network
+// instantiated from the configuration network of the given name. The options
+// of the configuration network are copied when the network is created,
+// the driver options passed along override them key by key, which lets for
+// instance every bridge network created from the same configuration have its
+// own bridge.
+func NetworkOptionConfigFrom(name string) NetworkOption {
+	return func(n *network) {
+		n.configFrom = name
+	}
+}
+
+func (n *network) ConfigOnly() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.configOnly
+}
+
+func (n *network) ConfigFrom() string {
+	n.Lock()
+	defer n.Unlock()
+	return n.configFrom
+}
+
+// inheritConfig instantiates the configuration network the network is
+// created from, whose options the network gets unless it sets them
+func (c *controller) inheritConfig(n *network) error {
+	if n.configFrom == "" {
+		return nil
+	}
+	if n.configOnly {
+		return types.BadRequestErrorf("configuration network %s cannot be created from another one", n.name)
+	}
+
+	var cfg *network
+	c.Lock()
+	for _, nw := range c.networks {
+		if nw.name == n.configFrom {
+			cfg = nw
+			break
+		}
+	}
+	c.Unlock()
+	if cfg == nil {
+		return types.NotFoundErrorf("configuration network %s not found", n.configFrom)
+	}
+
+	cfg.Lock()
+	defer cfg.Unlock()
+	if !cfg.configOnly {
+		return types.BadRequestErrorf("network %s is not a configuration network", n.configFrom)
+	}
+	if cfg.networkType != n.networkType {
+		return types.BadRequestErrorf("configuration network %s is of type %s, not %s", n.configFrom, cfg.networkType, n.networkType)
+	}
+
+	n.generic = mergeGeneric(cfg.generic, n.generic)
+	if _, ok := n.generic[netlabel.EnableIPv6]; ok {
+		n.enableIPv6, _ = n.generic[netlabel.EnableIPv6].(bool)
+	} else {
+		n.enableIPv6 = cfg.enableIPv6
+	}
+	if len(n.dnsSearch) == 0 {
+		n.dnsSearch = append([]string(nil), cfg.dnsSearch...)
+	}
+	if len(n.dnsOptions) == 0 {
+		n.dnsOptions = append([]string(nil), cfg.dnsOptions...)
+	}
+	if n.maxEndpoints == 0 {
+		n.maxEndpoints = cfg.maxEndpoints
+	}
+	return nil
+}
+
+// mergeGeneric returns the options of the configuration overridden by the
+// ones of the network. The driver specific options are merged as well when
+// both are maps, the options of the network win otherwise.
+func mergeGeneric(cfg, own options.Generic) options.Generic {
+	merged := options.Generic{}
+	for k, v := range cfg {
+		merged[k] = v
+	}
+	for k, v := range own {
+		if k == netlabel.GenericData {
+			if m, ok := mergeDriverData(merged[k], v); ok {
+				merged[k] = m
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+func mergeDriverData(cfg, own interface{}) (map[string]interface{}, bool) {
+	cm, ok := genericMap(cfg)
+	if !ok {
+		return nil, false
+	}
+	om, ok := genericMap(own)
+	if !ok {
+		return nil, false
+	}
+	merged := make(map[string]interface{}, len(cm)+len(om))
+	for k, v := range cm {
+		merged[k] = v
+	}
+	for k, v := range om {
+		merged[k] = v
+	}
+	return merged, true
+}
+
+func genericMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case options.Generic:
+		return m, true
+	}
+	return nil, false
+}
+
+// configUsers returns the names of the networks created from the
+// configuration network
+func (c *controller) configUsers(name string) []string {
+	c.Lock()
+	defer c.Unlock()
+	var users []string
+	for _, n := range c.networks {
+		if n.configFrom == name {
+			users = append(users, n.name)
+		}
+	}
+	return users
+}
@@ -0,0 +1,42 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// stateReporter returns the driver of the network as a state reporter, nil
+// while the network is not created in the driver, as nothing is programmed
+// for it then
+func (n *network) stateReporter() (driverapi.StateReporter, types.UUID, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	materialized := n.materialized
+	n.Unlock()
+
+	sr, ok := d.(driverapi.StateReporter)
+	if !ok {
+		return nil, id, types.NotImplementedErrorf("%s driver does not report its programmed state", d.Type())
+	}
+	if !materialized {
+		return nil, id, nil
+	}
+	return sr, id, nil
+}
+
+func (n *network) ProgrammedState() (*driverapi.ProgrammedState, error) {
+	sr, id, err := n.stateReporter()
+	if err != nil || sr == nil {
+		return &driverapi.ProgrammedState{}, err
+	}
+	return sr.ProgrammedState(id)
+}
+
+func (n *network) VerifyState() ([]driverapi.Drift, error) {
+	sr, id, err := n.stateReporter()
+	if err != nil || sr == nil {
+		return nil, err
+	}
+	return sr.VerifyState(id)
+}
@@ -0,0 +1,152 @@
+// Package plugindiscovery finds the remote driver plugins which are not
+// registered in the docker plugin directory: the plugins listening on a
+// socket of their own, the ones published by managed plugin containers and
+// the ones started on demand by systemd socket activation.
+package plugindiscovery
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/config"
+)
+
+var (
+	// ErrNotFound is returned when no source knows of the plugin
+	ErrNotFound = errors.New("plugin not found")
+
+	// DefaultSocketDirs are searched for plugin sockets if none is configured
+	DefaultSocketDirs = []string{"/run/docker/plugins"}
+	// DefaultUnitDirs are searched for systemd socket units if none is configured
+	DefaultUnitDirs = []string{"/etc/systemd/system", "/lib/systemd/system"}
+)
+
+// Discoverer looks plugins up by name in the configured directories
+type Discoverer struct {
+	socketDirs []string
+	unitDirs   []string
+}
+
+// New returns a discoverer for the given configuration
+func New(cfg config.PluginCfg) *Discoverer {
+	d := &Discoverer{socketDirs: cfg.SocketDirs, unitDirs: cfg.UnitDirs}
+	if len(d.socketDirs) == 0 {
+		d.socketDirs = DefaultSocketDirs
+	}
+	if len(d.unitDirs) == 0 {
+		d.unitDirs = DefaultUnitDirs
+	}
+	return d
+}
+
+// Lookup returns the address of the named plugin. The sockets found in the
+// socket directories take precedence over the ones of the managed plugin
+// containers, published in a subdirectory, which take precedence over the
+// systemd socket units.
+func (d *Discoverer) Lookup(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/.") {
+		return "", fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	for _, dir := range d.socketDirs {
+		if isSocket(filepath.Join(dir, name+".sock")) {
+			return "unix://" + filepath.Join(dir, name+".sock"), nil
+		}
+	}
+
+	for _, dir := range d.socketDirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "*", name+".sock"))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if isSocket(m) {
+				return "unix://" + m, nil
+			}
+		}
+	}
+
+	for _, dir := range d.unitDirs {
+		unit := filepath.Join(dir, name+".socket")
+		if _, err := os.Stat(unit); err != nil {
+			continue
+		}
+		addr, err := parseSocketUnit(unit)
+		if err != nil {
+			return "", err
+		}
+		return addr, nil
+	}
+
+	return "", ErrNotFound
+}
+
+func isSocket(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// parseSocketUnit returns the address of the first ListenStream directive
+// of the [Socket] section of a systemd socket unit
+func parseSocketUnit(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var section string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		if section != "[Socket]" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "ListenStream" {
+			continue
+		}
+		return listenStreamAddr(strings.TrimSpace(kv[1]))
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no ListenStream address in socket unit %s", path)
+}
+
+// listenStreamAddr converts a ListenStream value, a socket path, a port or
+// an address and port, into a plugin address
+func listenStreamAddr(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "/"):
+		return "unix://" + v, nil
+	case strings.HasPrefix(v, "@"):
+		return "", fmt.Errorf("abstract socket %s is not supported", v)
+	}
+
+	if port, err := strconv.Atoi(v); err == nil {
+		if port <= 0 || port > 65535 {
+			return "", fmt.Errorf("invalid port %s", v)
+		}
+		return "tcp://" + net.JoinHostPort("127.0.0.1", v), nil
+	}
+
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		return "", fmt.Errorf("invalid ListenStream address %s: %v", v, err)
+	}
+	return "tcp://" + v, nil
+}
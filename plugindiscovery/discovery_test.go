@@ -0,0 +1,93 @@
+package plugindiscovery
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/config"
+)
+
+func listen(t *testing.T, path string) net.Listener {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func writeUnit(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".socket"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "plugindiscovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sockDir := filepath.Join(tmp, "plugins")
+	unitDir := filepath.Join(tmp, "units")
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := New(config.PluginCfg{SocketDirs: []string{sockDir}, UnitDirs: []string{unitDir}})
+
+	// Managed plugin container socket
+	l1 := listen(t, filepath.Join(sockDir, "0123abcd", "net1.sock"))
+	defer l1.Close()
+	// Plain files are not plugin sockets
+	if err := ioutil.WriteFile(filepath.Join(sockDir, "net1.sock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := d.Lookup("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "unix://"+filepath.Join(sockDir, "0123abcd", "net1.sock") {
+		t.Fatalf("Unexpected address %s", addr)
+	}
+
+	// Top level sockets take precedence
+	os.Remove(filepath.Join(sockDir, "net1.sock"))
+	l2 := listen(t, filepath.Join(sockDir, "net1.sock"))
+	defer l2.Close()
+	if addr, err = d.Lookup("net1"); err != nil || addr != "unix://"+filepath.Join(sockDir, "net1.sock") {
+		t.Fatalf("Unexpected lookup result %s, %v", addr, err)
+	}
+
+	// Systemd socket units
+	writeUnit(t, unitDir, "net2", "[Unit]\nDescription=net2\n\n[Socket]\n# comment\nListenStream=/run/net2.sock\n")
+	writeUnit(t, unitDir, "net3", "[Socket]\nListenStream=9090\n")
+	writeUnit(t, unitDir, "net4", "[Socket]\nListenStream=10.0.0.1:9090\n")
+	writeUnit(t, unitDir, "net5", "[Unit]\nListenStream=/run/net5.sock\n")
+	writeUnit(t, unitDir, "net6", "[Socket]\nListenStream=@net6\n")
+
+	for name, expected := range map[string]string{
+		"net2": "unix:///run/net2.sock",
+		"net3": "tcp://127.0.0.1:9090",
+		"net4": "tcp://10.0.0.1:9090",
+	} {
+		if addr, err := d.Lookup(name); err != nil || addr != expected {
+			t.Fatalf("Unexpected lookup result for %s: %s, %v", name, addr, err)
+		}
+	}
+
+	for _, name := range []string{"net5", "net6", "", "../net1", "net.1"} {
+		if _, err := d.Lookup(name); err == nil || err == ErrNotFound {
+			t.Fatalf("Expected failure looking %q up, got %v", name, err)
+		}
+	}
+
+	if _, err := d.Lookup("net7"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package libnetwork
+
+import (
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/sandbox"
+)
+
+// podSandboxKey returns the key of the network namespace shared by the
+// containers of a pod
+func podSandboxKey(id string) string {
+	return sandbox.GenerateKey("pod-" + id)
+}
+
+// lowestFreeMark returns the lowest firewall mark no endpoint of the pod
+// holds. Mark 0 stands for unmarked traffic and is never handed out.
+func lowestFreeMark(marks map[*endpoint]uint32) uint32 {
+	used := make(map[uint32]bool, len(marks))
+	for _, m := range marks {
+		used[m] = true
+	}
+	mark := uint32(1)
+	for used[mark] {
+		mark++
+	}
+	return mark
+}
+
+// markRules returns the mangle table rules marking the traffic received and
+// sent on the interface, without the action
+func markRules(iface string, mark uint32) [][]string {
+	m := strconv.FormatUint(uint64(mark), 10)
+	return [][]string{
+		{"PREROUTING", "-i", iface, "-j", "MARK", "--set-mark", m},
+		{"POSTROUTING", "-o", iface, "-j", "MARK", "--set-mark", m},
+	}
+}
+
+// programMarks adds or removes the rules marking the traffic of the
+// interfaces in the sandbox. A failed addition removes the rules it added.
+func programMarks(sb sandbox.Sandbox, ifaces []string, mark uint32, add bool) error {
+	action := "-D"
+	if add {
+		action = "-A"
+	}
+
+	var (
+		err  error
+		done [][]string
+	)
+	if e := sb.InvokeFunc(func() {
+		for _, iface := range ifaces {
+			for _, r := range markRules(iface, mark) {
+				if _, err = iptables.RawLocal(append([]string{"-t", "mangle", action}, r...)...); err != nil {
+					if add {
+						break
+					}
+					logrus.Debugf("Removing mark rule %v failed: %v", r, err)
+					continue
+				}
+				done = append(done, r)
+			}
+			if add && err != nil {
+				break
+			}
+		}
+		if add && err != nil {
+			for _, r := range done {
+				iptables.RawLocal(append([]string{"-t", "mangle", "-D"}, r...)...)
+			}
+		}
+	}); e != nil {
+		return e
+	}
+
+	if add {
+		return err
+	}
+	return nil
+}
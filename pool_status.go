@@ -0,0 +1,126 @@
+package libnetwork
+
+import (
+	"math"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipam"
+)
+
+// NetworkPoolStatus reports the usage of the address pools of a network.
+// The pool consumers are reported by endpoint name.
+type NetworkPoolStatus struct {
+	Network string
+	ID      string
+	Pools   []*ipam.PoolStatus
+	// VIPPool is the usage of the service VIP pool of the network, if any,
+	// whose consumers are the services. It is not part of the totals.
+	VIPPool *ipam.PoolStatus `json:",omitempty"`
+}
+
+// AddressUsage aggregates the usage of the address pools of the networks
+// whose driver reports it.
+type AddressUsage struct {
+	Networks []*NetworkPoolStatus
+	// Total, Used and Free are summed across all the reported pools
+	Total uint64
+	Used  uint64
+	Free  uint64
+}
+
+// AddressUsage collects the address pool status of the networks whose driver
+// manages the endpoint addresses. Networks whose status cannot be retrieved
+// are logged and left out of the report.
+func (c *controller) AddressUsage() (*AddressUsage, error) {
+	usage := &AddressUsage{}
+
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		nps, err := n.poolStatus()
+		if err != nil {
+			log.Warnf("Failed to retrieve the address pool status of network %s: %v", n.Name(), err)
+			continue
+		}
+		if nps == nil {
+			continue
+		}
+		for _, ps := range nps.Pools {
+			usage.Total = addSaturating(usage.Total, ps.Total)
+			usage.Used = addSaturating(usage.Used, ps.Used)
+			usage.Free = addSaturating(usage.Free, ps.Free)
+		}
+		usage.Networks = append(usage.Networks, nps)
+	}
+
+	sort.Sort(byNetworkName(usage.Networks))
+
+	return usage, nil
+}
+
+// poolStatus returns the pool status reported by the network driver and the
+// status of its service VIP pool, or nil if it has neither
+func (n *network) poolStatus() (*NetworkPoolStatus, error) {
+	vip, err := n.vipPoolStatus()
+	if err != nil {
+		return nil, err
+	}
+	nps, err := n.driverPoolStatus()
+	if err != nil || vip == nil {
+		return nps, err
+	}
+	if nps == nil {
+		nps = &NetworkPoolStatus{Network: n.Name(), ID: n.ID()}
+	}
+	nps.VIPPool = vip
+	return nps, nil
+}
+
+// driverPoolStatus returns the pool status reported by the network driver,
+// or nil if the driver does not report it.
+func (n *network) driverPoolStatus() (*NetworkPoolStatus, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	name := n.name
+	materialized := n.materialized
+	names := make(map[string]string, len(n.endpoints))
+	for eid, ep := range n.endpoints {
+		names[string(eid)] = ep.Name()
+	}
+	n.Unlock()
+
+	reporter, ok := d.(driverapi.PoolStatusReporter)
+	if !ok || !materialized {
+		return nil, nil
+	}
+
+	pools, err := reporter.PoolStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ps := range pools {
+		for i, cs := range ps.TopConsumers {
+			if name, ok := names[cs.Name]; ok {
+				ps.TopConsumers[i].Name = name
+			}
+		}
+	}
+
+	return &NetworkPoolStatus{Network: name, ID: string(id), Pools: pools}, nil
+}
+
+func addSaturating(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+type byNetworkName []*NetworkPoolStatus
+
+func (b byNetworkName) Len() int           { return len(b) }
+func (b byNetworkName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNetworkName) Less(i, j int) bool { return b[i].Network < b[j].Network }
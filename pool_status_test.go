@@ -0,0 +1,81 @@
+package libnetwork
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/types"
+)
+
+type poolDriver struct {
+	localDriver
+	pools map[types.UUID][]*ipam.PoolStatus
+}
+
+func (d *poolDriver) PoolStatus(nid types.UUID) ([]*ipam.PoolStatus, error) {
+	ps, ok := d.pools[nid]
+	if !ok {
+		return nil, fmt.Errorf("network %s not found", nid)
+	}
+	return ps, nil
+}
+
+func TestAddressUsage(t *testing.T) {
+	_, sub1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, sub2, _ := net.ParseCIDR("10.0.1.0/24")
+	d := &poolDriver{pools: map[types.UUID][]*ipam.PoolStatus{
+		"n1": {{Subnet: sub1, Total: 254, Used: 4, Free: 250, FreeRanges: 1, LargestFreeRange: 250,
+			TopConsumers: []ipam.Consumer{{Name: "ep1", Addresses: 1}}}},
+		"n2": {{Subnet: sub2, Total: math.MaxUint64, Used: 1, Free: math.MaxUint64 - 1}},
+	}}
+	ld := &localDriver{}
+
+	ctrlr := createEmptyCtrlr()
+	ctrlr.networks = networkTable{}
+	for _, n := range []*network{
+		{id: "n1", name: "net1", driver: d, materialized: true},
+		{id: "n2", name: "net0", driver: d, materialized: true},
+		// Not created in the driver yet
+		{id: "n3", name: "net3", driver: d},
+		// Driver does not report
+		{id: "n4", name: "net4", driver: ld, materialized: true},
+		// Status cannot be retrieved
+		{id: "n5", name: "net5", driver: d, materialized: true},
+	} {
+		n.endpoints = endpointTable{}
+		ctrlr.networks[n.id] = n
+	}
+	ctrlr.networks["n1"].endpoints["ep1"] = &endpoint{id: "ep1", name: "web"}
+
+	usage, err := ctrlr.AddressUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(usage.Networks) != 2 || usage.Networks[0].Network != "net0" || usage.Networks[1].Network != "net1" {
+		t.Fatalf("Unexpected networks: %v", usage.Networks)
+	}
+	if usage.Total != math.MaxUint64 || usage.Used != 5 || usage.Free != math.MaxUint64 {
+		t.Fatalf("Unexpected totals: %d total, %d used, %d free", usage.Total, usage.Used, usage.Free)
+	}
+	tc := usage.Networks[1].Pools[0].TopConsumers
+	if len(tc) != 1 || tc[0].Name != "web" {
+		t.Fatalf("Consumers were not reported by endpoint name: %v", tc)
+	}
+}
+
+func TestServiceVIPPoolOverlap(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("10.0.0.0/16")
+	d := &poolDriver{pools: map[types.UUID][]*ipam.PoolStatus{"n1": {{Subnet: sub}}}}
+	n := &network{id: "n1", name: "net1", driver: d, materialized: true, endpoints: endpointTable{}}
+
+	for pool, overlaps := range map[string]bool{"10.0.200.0/24": true, "10.0.0.0/8": true, "10.1.0.0/24": false} {
+		_, n.vipPool, _ = net.ParseCIDR(pool)
+		if err := n.checkVIPPoolOverlap(); (err != nil) != overlaps {
+			t.Fatalf("Unexpected overlap check of VIP pool %s: %v", pool, err)
+		}
+	}
+}
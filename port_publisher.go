@@ -0,0 +1,221 @@
+package libnetwork
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// PublishedPort describes a host port an endpoint publishes, for a port
+// publisher to forward it from an external load balancer or to open it in
+// a cloud security group
+type PublishedPort struct {
+	NetworkID  string
+	Network    string
+	EndpointID string
+	Endpoint   string
+	Proto      types.Protocol
+	// HostIP is the host address the port is published on, an unspecified
+	// address standing for all of them
+	HostIP net.IP
+	// HostPort is 0 if it is left to the publisher to pick, when the host
+	// port is not requested and the driver does not program the mapping
+	HostPort      uint16
+	ContainerIP   net.IP
+	ContainerPort uint16
+}
+
+// PortPublisher is notified of every host port the endpoints created on
+// this host publish and unpublish. An error publishing the ports of a new
+// endpoint fails its creation; the errors unpublishing ports are logged.
+type PortPublisher interface {
+	PublishPort(ctx context.Context, p PublishedPort) error
+	UnpublishPort(ctx context.Context, p PublishedPort) error
+}
+
+// NopPortPublisher is the port publisher of a controller which has none, it
+// does nothing
+type NopPortPublisher struct{}
+
+// PublishPort does nothing
+func (NopPortPublisher) PublishPort(ctx context.Context, p PublishedPort) error {
+	return nil
+}
+
+// UnpublishPort does nothing
+func (NopPortPublisher) UnpublishPort(ctx context.Context, p PublishedPort) error {
+	return nil
+}
+
+// portPublication keeps the port publisher of the controller and the ports
+// published through it, in memory like the port registry
+type portPublication struct {
+	publisher PortPublisher
+	// external is set when the ports are only published by the publisher,
+	// the drivers are then not passed the port bindings
+	external  bool
+	published map[types.UUID][]PublishedPort
+	sync.Mutex
+}
+
+func (c *controller) SetPortPublisher(p PortPublisher, external bool) {
+	c.pub.Lock()
+	defer c.pub.Unlock()
+	c.pub.publisher = p
+	c.pub.external = external
+}
+
+func (pp *portPublication) get() (PortPublisher, bool) {
+	pp.Lock()
+	defer pp.Unlock()
+	if pp.publisher == nil {
+		return NopPortPublisher{}, false
+	}
+	return pp.publisher, pp.external
+}
+
+// driverOptions returns the endpoint options passed to the driver, without
+// the port bindings if the ports are only published externally
+func (ep *endpoint) driverOptions(c *controller) map[string]interface{} {
+	ep.Lock()
+	defer ep.Unlock()
+	if _, external := c.pub.get(); !external {
+		return ep.generic
+	}
+	if _, ok := ep.generic[netlabel.PortMap]; !ok {
+		return ep.generic
+	}
+	opts := make(map[string]interface{}, len(ep.generic))
+	for k, v := range ep.generic {
+		if k != netlabel.PortMap {
+			opts[k] = v
+		}
+	}
+	return opts
+}
+
+// publishedPortList returns the ports the endpoint publishes: the bindings
+// programmed by the driver, or the requested ones if the driver is not
+// passed them or does not report them
+func (ep *endpoint) publishedPortList(external bool) []PublishedPort {
+	bindings := ep.portBindings()
+	if !external {
+		if info, err := ep.DriverInfo(); err == nil {
+			if pm, ok := info[netlabel.PortMap].([]types.PortBinding); ok {
+				bindings = pm
+			}
+		}
+	}
+
+	ep.Lock()
+	n := ep.network
+	eid, name := ep.id, ep.name
+	var containerIP net.IP
+	if len(ep.iFaces) != 0 {
+		containerIP = ep.iFaces[0].addr.IP
+	}
+	ep.Unlock()
+
+	n.Lock()
+	nid, nwName := n.id, n.name
+	n.Unlock()
+
+	var ports []PublishedPort
+	for _, b := range bindings {
+		hostIPs := b.HostIPs
+		if len(hostIPs) == 0 {
+			hostIP := b.HostIP
+			if len(hostIP) == 0 {
+				hostIP = net.IPv4zero
+				if b.HostIPv6Only {
+					hostIP = net.IPv6unspecified
+				}
+			}
+			hostIPs = []net.IP{hostIP}
+		}
+		for _, ip := range hostIPs {
+			ports = append(ports, PublishedPort{
+				NetworkID:     string(nid),
+				Network:       nwName,
+				EndpointID:    string(eid),
+				Endpoint:      name,
+				Proto:         b.Proto,
+				HostIP:        ip,
+				HostPort:      b.HostPort,
+				ContainerIP:   containerIP,
+				ContainerPort: b.Port,
+			})
+		}
+	}
+	return ports
+}
+
+// publishExternal notifies the port publisher of the ports the endpoint
+// publishes. The ports already published are unpublished on error.
+func (ep *endpoint) publishExternal(ctx context.Context, c *controller) error {
+	// An endpoint outside of a controller has no port publisher
+	if c == nil {
+		return nil
+	}
+
+	// The deferred ports are published once bound
+	ep.Lock()
+	unbound := !ep.portsActive
+	ep.Unlock()
+	if unbound && ep.portsDeferred() {
+		return nil
+	}
+
+	publisher, external := c.pub.get()
+	ports := ep.publishedPortList(external)
+	if len(ports) == 0 {
+		return nil
+	}
+
+	for i, p := range ports {
+		if err := publisher.PublishPort(ctx, p); err != nil {
+			for _, pp := range ports[:i] {
+				if e := publisher.UnpublishPort(context.Background(), pp); e != nil {
+					log.Warnf("Failed to unpublish port %d/%s of endpoint %s: %v", pp.HostPort, pp.Proto, pp.Endpoint, e)
+				}
+			}
+			return err
+		}
+	}
+
+	c.pub.Lock()
+	if c.pub.published == nil {
+		c.pub.published = make(map[types.UUID][]PublishedPort)
+	}
+	c.pub.published[types.UUID(ports[0].EndpointID)] = ports
+	c.pub.Unlock()
+	return nil
+}
+
+// unpublishExternal notifies the port publisher that the ports published by
+// the endpoint are withdrawn. The errors are logged.
+func (ep *endpoint) unpublishExternal(ctx context.Context, c *controller) {
+	if c == nil {
+		return
+	}
+
+	ep.Lock()
+	eid := ep.id
+	ep.Unlock()
+
+	c.pub.Lock()
+	ports := c.pub.published[eid]
+	delete(c.pub.published, eid)
+	c.pub.Unlock()
+
+	publisher, _ := c.pub.get()
+	for _, p := range ports {
+		if err := publisher.UnpublishPort(ctx, p); err != nil {
+			log.Warnf("Failed to unpublish port %d/%s of endpoint %s: %v", p.HostPort, p.Proto, p.Endpoint, err)
+		}
+	}
+}
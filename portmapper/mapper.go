@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +17,10 @@ type mapping struct {
 	userlandProxy userlandProxy
 	host          net.Addr
 	container     net.Addr
+	ipv6Only      bool
+	// socket is set for the mappings whose bound socket is handed over
+	// to the process serving the port, rather than forwarded
+	socket bool
 }
 
 var newProxy = newProxyCommand
@@ -27,6 +32,14 @@ var (
 	ErrPortMappedForIP = errors.New("port is already mapped to ip")
 	// ErrPortNotMapped refers to an unmapped port
 	ErrPortNotMapped = errors.New("port is not mapped")
+	// ErrIPv6OnlyHostIP refers to an IPv6 only mapping requested on an IPv4 host address
+	ErrIPv6OnlyHostIP = errors.New("IPv6 only mapping requires an IPv6 host address")
+	// ErrIPv6OnlyNoProxy refers to an IPv6 only mapping requested without the userland proxy
+	ErrIPv6OnlyNoProxy = errors.New("IPv6 only mapping requires the userland proxy")
+	// ErrNoHostIP refers to a multiple address mapping requested without addresses
+	ErrNoHostIP = errors.New("no host address to map the port on")
+	// ErrPortNotSocket refers to a port which is forwarded rather than mapped to a socket
+	ErrPortNotSocket = errors.New("port is not mapped to a socket")
 )
 
 // PortMapper manages the network address translation
@@ -60,6 +73,129 @@ func (pm *PortMapper) SetIptablesChain(c *iptables.Chain) {
 
 // Map maps the specified container transport address to the host's network address and transport port
 func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
+	return pm.mapInternal(container, hostIP, hostPort, useProxy, false)
+}
+
+// MapIPv6Only maps the specified container transport address to the host's
+// network address and transport port, only accepting traffic addressed to the
+// host over IPv6. An unspecified host address stands for all the IPv6
+// addresses of the host. The mapping is served by the userland proxy, as
+// there are no IPv6 NAT rules.
+func (pm *PortMapper) MapIPv6Only(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
+	if !useProxy {
+		return nil, ErrIPv6OnlyNoProxy
+	}
+	if len(hostIP) == 0 {
+		hostIP = net.IPv6unspecified
+	}
+	if hostIP.To4() != nil {
+		return nil, ErrIPv6OnlyHostIP
+	}
+	return pm.mapInternal(container, hostIP, hostPort, useProxy, true)
+}
+
+// MapMultiple maps the specified container transport address on each of the
+// passed host addresses. Every address gets its own NAT rule and port
+// reservation, so a port being in use on one address does not prevent its
+// use on the others. When hostPort is zero, the port allocated on the first
+// address is requested on the following ones, so that the container port is
+// published under the same port everywhere. On failure, the mappings already
+// done are removed.
+func (pm *PortMapper) MapMultiple(container net.Addr, hostIPs []net.IP, hostPort int, useProxy bool) (hosts []net.Addr, err error) {
+	if len(hostIPs) == 0 {
+		return nil, ErrNoHostIP
+	}
+
+	defer func() {
+		if err != nil {
+			for _, host := range hosts {
+				if uErr := pm.Unmap(host); uErr != nil {
+					logrus.Warnf("Failed to unmap %v on cleanup: %v", host, uErr)
+				}
+			}
+			hosts = nil
+		}
+	}()
+
+	for _, hostIP := range hostIPs {
+		host, err := pm.mapInternal(container, hostIP, hostPort, useProxy, false)
+		if err != nil {
+			return hosts, fmt.Errorf("failed to map port on %s: %v", hostIP, err)
+		}
+		hosts = append(hosts, host)
+		_, hostPort = getIPAndPort(host)
+	}
+
+	return hosts, nil
+}
+
+// MapSocket reserves the host transport address and binds a socket on it,
+// without forwarding the traffic anywhere: the socket is meant to be handed
+// over to the process serving the port, see SocketFile. An IPv6 only socket
+// is bound if ipv6Only is set.
+func (pm *PortMapper) MapSocket(proto string, hostIP net.IP, hostPort int, ipv6Only bool) (host net.Addr, err error) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if proto != "tcp" && proto != "udp" {
+		return nil, ErrUnknownBackendAddressType
+	}
+
+	allocatedHostPort, err := pm.Allocator.RequestPort(hostIP, proto, hostPort)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
+		}
+	}()
+
+	m := &mapping{
+		proto:         proto,
+		userlandProxy: newDummyProxy(listenProto(proto, ipv6Only), hostIP, allocatedHostPort),
+		ipv6Only:      ipv6Only,
+		socket:        true,
+	}
+	if proto == "tcp" {
+		m.host = &net.TCPAddr{IP: hostIP, Port: allocatedHostPort}
+	} else {
+		m.host = &net.UDPAddr{IP: hostIP, Port: allocatedHostPort}
+	}
+
+	key := getKey(m.host)
+	if _, exists := pm.currentMappings[key]; exists {
+		return nil, ErrPortMappedForIP
+	}
+
+	if err := m.userlandProxy.Start(); err != nil {
+		return nil, err
+	}
+
+	pm.currentMappings[key] = m
+	return m.host, nil
+}
+
+// SocketFile returns a duplicate of the socket bound for the host transport
+// address by MapSocket. The caller owns the returned file, closing it does not
+// release the mapping.
+func (pm *PortMapper) SocketFile(host net.Addr) (*os.File, error) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	m, exists := pm.currentMappings[getKey(host)]
+	if !exists {
+		return nil, ErrPortNotMapped
+	}
+	p, ok := m.userlandProxy.(*dummyProxy)
+	if !m.socket || !ok {
+		return nil, ErrPortNotSocket
+	}
+
+	return p.file()
+}
+
+func (pm *PortMapper) mapInternal(container net.Addr, hostIP net.IP, hostPort int, useProxy, ipv6Only bool) (host net.Addr, err error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
@@ -83,9 +219,9 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, usePr
 		}
 
 		if useProxy {
-			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
+			m.userlandProxy = newProxy(listenProto(proto, ipv6Only), hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
 		} else {
-			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+			m.userlandProxy = newDummyProxy(listenProto(proto, ipv6Only), hostIP, allocatedHostPort)
 		}
 	case *net.UDPAddr:
 		proto = "udp"
@@ -100,13 +236,14 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, usePr
 		}
 
 		if useProxy {
-			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
+			m.userlandProxy = newProxy(listenProto(proto, ipv6Only), hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
 		} else {
-			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+			m.userlandProxy = newDummyProxy(listenProto(proto, ipv6Only), hostIP, allocatedHostPort)
 		}
 	default:
 		return nil, ErrUnknownBackendAddressType
 	}
+	m.ipv6Only = ipv6Only
 
 	// release the allocated port on any further error during return.
 	defer func() {
@@ -121,14 +258,14 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, usePr
 	}
 
 	containerIP, containerPort := getIPAndPort(m.container)
-	if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if err := pm.forwardMapping(iptables.Append, m, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
 		return nil, err
 	}
 
 	cleanup := func() error {
 		// need to undo the iptables rules before we return
 		m.userlandProxy.Stop()
-		pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
+		pm.forwardMapping(iptables.Delete, m, hostIP, allocatedHostPort, containerIP.String(), containerPort)
 		if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
 			return err
 		}
@@ -166,7 +303,7 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 
 	containerIP, containerPort := getIPAndPort(data.container)
 	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+	if err := pm.forwardMapping(iptables.Delete, data, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
 		logrus.Errorf("Error on iptables delete: %s", err)
 	}
 
@@ -199,6 +336,24 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 	return nil, 0
 }
 
+// listenProto returns the protocol the host side of a mapping listens on
+func listenProto(proto string, ipv6Only bool) string {
+	if ipv6Only {
+		return proto + "6"
+	}
+	return proto
+}
+
+// forwardMapping programs the NAT rules for the mapping. IPv6 only mappings
+// are served by the proxy alone, as the rules are IPv4 only, and the traffic
+// of the socket mappings is not forwarded.
+func (pm *PortMapper) forwardMapping(action iptables.Action, m *mapping, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
+	if m.ipv6Only || m.socket {
+		return nil
+	}
+	return pm.forward(action, m.proto, sourceIP, sourcePort, containerIP, containerPort)
+}
+
 func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
 	if pm.chain == nil {
 		return nil
@@ -78,6 +78,59 @@ func TestMapTCPPorts(t *testing.T) {
 	}
 }
 
+func TestMapMultiple(t *testing.T) {
+	pm := New()
+	hostIP1 := net.ParseIP("192.168.0.1")
+	hostIP2 := net.ParseIP("192.168.0.2")
+	hostIP3 := net.ParseIP("192.168.0.3")
+	srcAddr := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.1")}
+
+	// The port is only in use on the second address
+	if _, err := pm.Map(&net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.2")}, hostIP2, 8080, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pm.MapMultiple(srcAddr, []net.IP{hostIP1, hostIP2}, 8080, true); err == nil {
+		t.Fatal("Port is in use on one address - mapping should have failed")
+	}
+	// The mapping on the first address was rolled back
+	if _, err := pm.Map(srcAddr, hostIP1, 8080, true); err != nil {
+		t.Fatalf("Failed to allocate port after rollback: %v", err)
+	}
+	if err := pm.Unmap(&net.TCPAddr{IP: hostIP1, Port: 8080}); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := pm.MapMultiple(srcAddr, []net.IP{hostIP1, hostIP3}, 8080, true)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %v", err)
+	}
+	if len(hosts) != 2 || hosts[0].String() != "192.168.0.1:8080" || hosts[1].String() != "192.168.0.3:8080" {
+		t.Fatalf("Unexpected mappings: %v", hosts)
+	}
+
+	// A dynamically allocated port is the same on all addresses
+	dyn, err := pm.MapMultiple(&net.TCPAddr{Port: 1081, IP: net.ParseIP("172.16.0.1")}, []net.IP{hostIP1, hostIP3}, 0, true)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %v", err)
+	}
+	_, port1 := getIPAndPort(dyn[0])
+	_, port2 := getIPAndPort(dyn[1])
+	if port1 == 0 || port1 != port2 {
+		t.Fatalf("Expected the same port on all addresses: %v", dyn)
+	}
+
+	for _, h := range append(hosts, dyn...) {
+		if err := pm.Unmap(h); err != nil {
+			t.Fatalf("Failed to release port %v: %v", h, err)
+		}
+	}
+
+	if _, err := pm.MapMultiple(srcAddr, nil, 8080, true); err != ErrNoHostIP {
+		t.Fatalf("Unexpected error for empty address list: %v", err)
+	}
+}
+
 func TestGetUDPKey(t *testing.T) {
 	addr := &net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 53}
 
@@ -269,3 +322,83 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+func TestMapIPv6Only(t *testing.T) {
+	pm := New()
+	dstAddr := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+
+	if _, err := pm.MapIPv6Only(dstAddr, net.ParseIP("192.168.1.1"), 0, true); err != ErrIPv6OnlyHostIP {
+		t.Fatalf("Expected %v, got %v", ErrIPv6OnlyHostIP, err)
+	}
+	if _, err := pm.MapIPv6Only(dstAddr, nil, 0, false); err != ErrIPv6OnlyNoProxy {
+		t.Fatalf("Expected %v, got %v", ErrIPv6OnlyNoProxy, err)
+	}
+
+	host, err := pm.MapIPv6Only(dstAddr, nil, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := host.(*net.TCPAddr).IP; !ip.Equal(net.IPv6unspecified) {
+		t.Fatalf("Expected host address %v, got %v", net.IPv6unspecified, ip)
+	}
+	if !pm.currentMappings[getKey(host)].ipv6Only {
+		t.Fatal("Expected mapping to be IPv6 only")
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapSocket(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("127.0.0.1")
+
+	host, err := pm.MapSocket("tcp", hostIP, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.SocketFile(&net.TCPAddr{IP: hostIP, Port: 1}); err != ErrPortNotMapped {
+		t.Fatalf("Expected %v, got %v", ErrPortNotMapped, err)
+	}
+
+	f, err := pm.SocketFile(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The connections are accepted on the handed over socket
+	c, err := net.Dial("tcp", host.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.SocketFile(host); err != ErrPortNotMapped {
+		t.Fatalf("Expected %v, got %v", ErrPortNotMapped, err)
+	}
+
+	dstAddr := &net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53}
+	host, err = pm.Map(dstAddr, hostIP, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host)
+	if _, err := pm.SocketFile(host); err != ErrPortNotSocket {
+		t.Fatalf("Expected %v, got %v", ErrPortNotSocket, err)
+	}
+}
@@ -38,9 +38,17 @@ type proxyCommand struct {
 // execProxy is the reexec function that is registered to start the userland proxies
 func execProxy() {
 	f := os.NewFile(3, "signal-parent")
-	host, container := parseHostContainerAddrs()
+	host, container, ipv6Only := parseHostContainerAddrs()
 
-	p, err := proxy.NewProxy(host, container)
+	var (
+		p   proxy.Proxy
+		err error
+	)
+	if ipv6Only {
+		p, err = newIPv6OnlyProxy(host, container)
+	} else {
+		p, err = proxy.NewProxy(host, container)
+	}
 	if err != nil {
 		fmt.Fprintf(f, "1\n%s", err)
 		f.Close()
@@ -56,7 +64,7 @@ func execProxy() {
 
 // parseHostContainerAddrs parses the flags passed on reexec to create the TCP or UDP
 // net.Addrs to map the host and container ports
-func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
+func parseHostContainerAddrs() (host net.Addr, container net.Addr, ipv6Only bool) {
 	var (
 		proto         = flag.String("proto", "tcp", "proxy protocol")
 		hostIP        = flag.String("host-ip", "", "host ip")
@@ -67,18 +75,19 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 
 	flag.Parse()
 
+	// The "6" suffixed protocols denote an IPv6 only host side listener
 	switch *proto {
-	case "tcp":
+	case "tcp", "tcp6":
 		host = &net.TCPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
 		container = &net.TCPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
-	case "udp":
+	case "udp", "udp6":
 		host = &net.UDPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
 		container = &net.UDPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
 	default:
 		log.Fatalf("unsupported protocol %s", *proto)
 	}
 
-	return host, container
+	return host, container, *proto == "tcp6" || *proto == "udp6"
 }
 
 func handleStopSignals(p proxy.Proxy) {
@@ -167,16 +176,17 @@ func (p *proxyCommand) Stop() error {
 type dummyProxy struct {
 	listener io.Closer
 	addr     net.Addr
+	network  string
 }
 
 func newDummyProxy(proto string, hostIP net.IP, hostPort int) userlandProxy {
 	switch proto {
-	case "tcp":
+	case "tcp", "tcp6":
 		addr := &net.TCPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}
-	case "udp":
+		return &dummyProxy{addr: addr, network: proto}
+	case "udp", "udp6":
 		addr := &net.UDPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}
+		return &dummyProxy{addr: addr, network: proto}
 	}
 	return nil
 }
@@ -184,13 +194,13 @@ func newDummyProxy(proto string, hostIP net.IP, hostPort int) userlandProxy {
 func (p *dummyProxy) Start() error {
 	switch addr := p.addr.(type) {
 	case *net.TCPAddr:
-		l, err := net.ListenTCP("tcp", addr)
+		l, err := net.ListenTCP(p.network, addr)
 		if err != nil {
 			return err
 		}
 		p.listener = l
 	case *net.UDPAddr:
-		l, err := net.ListenUDP("udp", addr)
+		l, err := net.ListenUDP(p.network, addr)
 		if err != nil {
 			return err
 		}
@@ -201,6 +211,17 @@ func (p *dummyProxy) Start() error {
 	return nil
 }
 
+// file returns a duplicate of the bound socket
+func (p *dummyProxy) file() (*os.File, error) {
+	switch l := p.listener.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UDPConn:
+		return l.File()
+	}
+	return nil, fmt.Errorf("no socket bound on %s", p.addr)
+}
+
 func (p *dummyProxy) Stop() error {
 	if p.listener != nil {
 		return p.listener.Close()
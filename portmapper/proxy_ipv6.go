@@ -0,0 +1,161 @@
+package portmapper
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/proxy"
+)
+
+const udpConnTrackTimeout = 90 * time.Second
+
+// The proxy package listens on the tcp and udp networks, which yields dual
+// stack sockets for the IPv6 unspecified address. The IPv6 only proxies below
+// listen on the tcp6 and udp6 networks instead, so that IPv4 traffic is not
+// accepted on the host port.
+
+type ipv6OnlyTCPProxy struct {
+	listener     *net.TCPListener
+	frontendAddr *net.TCPAddr
+	backendAddr  *net.TCPAddr
+}
+
+type ipv6OnlyUDPProxy struct {
+	listener     *net.UDPConn
+	frontendAddr *net.UDPAddr
+	backendAddr  *net.UDPAddr
+	conns        map[string]*net.UDPConn
+	sync.Mutex
+}
+
+func newIPv6OnlyProxy(frontendAddr, backendAddr net.Addr) (proxy.Proxy, error) {
+	switch fa := frontendAddr.(type) {
+	case *net.TCPAddr:
+		l, err := net.ListenTCP("tcp6", fa)
+		if err != nil {
+			return nil, err
+		}
+		return &ipv6OnlyTCPProxy{
+			listener:     l,
+			frontendAddr: l.Addr().(*net.TCPAddr),
+			backendAddr:  backendAddr.(*net.TCPAddr),
+		}, nil
+	case *net.UDPAddr:
+		l, err := net.ListenUDP("udp6", fa)
+		if err != nil {
+			return nil, err
+		}
+		return &ipv6OnlyUDPProxy{
+			listener:     l,
+			frontendAddr: l.LocalAddr().(*net.UDPAddr),
+			backendAddr:  backendAddr.(*net.UDPAddr),
+			conns:        make(map[string]*net.UDPConn),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported address type %T", frontendAddr)
+	}
+}
+
+func (p *ipv6OnlyTCPProxy) Run() {
+	for {
+		client, err := p.listener.AcceptTCP()
+		if err != nil {
+			logrus.Debugf("Stopping proxy on tcp6/%v for tcp/%v (%v)", p.frontendAddr, p.backendAddr, err)
+			return
+		}
+		go p.forward(client)
+	}
+}
+
+func (p *ipv6OnlyTCPProxy) forward(client *net.TCPConn) {
+	backend, err := net.DialTCP("tcp", nil, p.backendAddr)
+	if err != nil {
+		logrus.Warnf("Can't forward traffic to backend tcp/%v: %v", p.backendAddr, err)
+		client.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	broker := func(to, from *net.TCPConn) {
+		io.Copy(to, from)
+		to.CloseWrite()
+		done <- struct{}{}
+	}
+	go broker(client, backend)
+	go broker(backend, client)
+
+	<-done
+	<-done
+	client.Close()
+	backend.Close()
+}
+
+func (p *ipv6OnlyTCPProxy) Close()                 { p.listener.Close() }
+func (p *ipv6OnlyTCPProxy) FrontendAddr() net.Addr { return p.frontendAddr }
+func (p *ipv6OnlyTCPProxy) BackendAddr() net.Addr  { return p.backendAddr }
+
+func (p *ipv6OnlyUDPProxy) Run() {
+	buf := make([]byte, 65507)
+	for {
+		n, from, err := p.listener.ReadFromUDP(buf)
+		if err != nil {
+			logrus.Debugf("Stopping proxy on udp6/%v for udp/%v (%v)", p.frontendAddr, p.backendAddr, err)
+			return
+		}
+
+		key := from.String()
+		p.Lock()
+		conn, ok := p.conns[key]
+		if !ok {
+			if conn, err = net.DialUDP("udp", nil, p.backendAddr); err != nil {
+				p.Unlock()
+				logrus.Warnf("Can't proxy a datagram to udp/%v: %v", p.backendAddr, err)
+				continue
+			}
+			p.conns[key] = conn
+			go p.replyLoop(conn, from, key)
+		}
+		p.Unlock()
+
+		if _, err := conn.Write(buf[:n]); err != nil {
+			logrus.Warnf("Can't proxy a datagram to udp/%v: %v", p.backendAddr, err)
+		}
+	}
+}
+
+func (p *ipv6OnlyUDPProxy) replyLoop(conn *net.UDPConn, client *net.UDPAddr, key string) {
+	defer func() {
+		p.Lock()
+		delete(p.conns, key)
+		p.Unlock()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 65507)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpConnTrackTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := p.listener.WriteToUDP(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+func (p *ipv6OnlyUDPProxy) Close() {
+	p.listener.Close()
+	p.Lock()
+	defer p.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func (p *ipv6OnlyUDPProxy) FrontendAddr() net.Addr { return p.frontendAddr }
+func (p *ipv6OnlyUDPProxy) BackendAddr() net.Addr  { return p.backendAddr }
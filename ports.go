@@ -0,0 +1,288 @@
+package libnetwork
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// publishedPort is a host transport address an endpoint publishes a port on.
+// An unspecified address stands for all the addresses of its family, and
+// for the IPv4 ones as well when it is the IPv6 one and not ipv6Only.
+type publishedPort struct {
+	proto    types.Protocol
+	ip       net.IP
+	port     uint16
+	ipv6Only bool
+}
+
+// wildcard returns the address families the port is published on all the
+// addresses of
+func (p publishedPort) wildcard() (v4, v6 bool) {
+	if !p.ip.IsUnspecified() {
+		return false, false
+	}
+	if p.ip.To4() != nil {
+		return true, false
+	}
+	return !p.ipv6Only, true
+}
+
+func (p publishedPort) covers(ip net.IP) bool {
+	v4, v6 := p.wildcard()
+	if ip.To4() != nil {
+		return v4
+	}
+	return v6
+}
+
+func (p publishedPort) overlaps(o publishedPort) bool {
+	if p.proto != o.proto || p.port != o.port {
+		return false
+	}
+	pv4, pv6 := p.wildcard()
+	ov4, ov6 := o.wildcard()
+	switch {
+	case !pv4 && !pv6 && !ov4 && !ov6:
+		return p.ip.Equal(o.ip)
+	case !pv4 && !pv6:
+		return o.covers(p.ip)
+	case !ov4 && !ov6:
+		return p.covers(o.ip)
+	}
+	return pv4 && ov4 || pv6 && ov6
+}
+
+func (p publishedPort) String() string {
+	return net.JoinHostPort(p.ip.String(), strconv.Itoa(int(p.port))) + "/" + p.proto.String()
+}
+
+// publishedPorts returns the host addresses the bindings publish a known
+// host port on. The bindings on a dynamic host port, or on the address of
+// a host interface, are only known once the driver programmed them. A
+// binding without host address is taken as published on all the IPv4
+// addresses, the default binding address.
+func publishedPorts(bindings []types.PortBinding) []publishedPort {
+	var ps []publishedPort
+	for _, b := range bindings {
+		if b.HostPort == 0 || b.HostIface != "" {
+			continue
+		}
+		if len(b.HostIPs) != 0 {
+			for _, ip := range b.HostIPs {
+				ps = append(ps, publishedPort{proto: b.Proto, ip: ip, port: b.HostPort})
+			}
+			continue
+		}
+		ip := b.HostIP
+		if len(ip) == 0 {
+			ip = net.IPv4zero
+			if b.HostIPv6Only {
+				ip = net.IPv6unspecified
+			}
+		}
+		ps = append(ps, publishedPort{proto: b.Proto, ip: ip, port: b.HostPort, ipv6Only: b.HostIPv6Only})
+	}
+	return ps
+}
+
+type portOwner struct {
+	name  string
+	ports []publishedPort
+}
+
+// portRegistry keeps the host ports published by the endpoints created on
+// this host, whatever their network and driver, so that a binding
+// overlapping another one is refused before any driver programs it. The
+// registry is kept in memory like the local endpoints it is built from, and
+// rebuilt on start from the endpoints attached back to restored sandboxes.
+type portRegistry struct {
+	owners map[types.UUID]*portOwner
+	sync.Mutex
+}
+
+// conflict returns an error if one of the ports overlaps the ports of
+// another endpoint. Called with the lock held.
+func (r *portRegistry) conflict(eid types.UUID, ps []publishedPort) error {
+	for _, p := range ps {
+		for id, o := range r.owners {
+			if id == eid {
+				continue
+			}
+			for _, op := range o.ports {
+				if p.overlaps(op) {
+					return types.ForbiddenErrorf("host port %s conflicts with %s published by endpoint %s", p, op, o.name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// check returns an error if the bindings overlap the published ports
+func (r *portRegistry) check(bindings []types.PortBinding) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.conflict("", publishedPorts(bindings))
+}
+
+// reserve records the ports the bindings of the endpoint publish, unless
+// they overlap the published ports
+func (r *portRegistry) reserve(eid types.UUID, name string, bindings []types.PortBinding) error {
+	ps := publishedPorts(bindings)
+
+	r.Lock()
+	defer r.Unlock()
+	if err := r.conflict(eid, ps); err != nil {
+		return err
+	}
+	if r.owners == nil {
+		r.owners = make(map[types.UUID]*portOwner)
+	}
+	r.owners[eid] = &portOwner{name: name, ports: ps}
+	return nil
+}
+
+// update replaces the ports recorded for the endpoint with the operational
+// bindings programmed by the driver
+func (r *portRegistry) update(eid types.UUID, name string, bindings []types.PortBinding) {
+	ps := publishedPorts(bindings)
+
+	r.Lock()
+	defer r.Unlock()
+	if r.owners == nil {
+		r.owners = make(map[types.UUID]*portOwner)
+	}
+	r.owners[eid] = &portOwner{name: name, ports: ps}
+}
+
+func (r *portRegistry) release(eid types.UUID) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.owners, eid)
+}
+
+// portBindings returns the port bindings requested for the endpoint
+func (ep *endpoint) portBindings() []types.PortBinding {
+	ep.Lock()
+	defer ep.Unlock()
+	bindings, _ := ep.generic[netlabel.PortMap].([]types.PortBinding)
+	return bindings
+}
+
+// publishPorts records the port bindings the driver programmed for the
+// endpoint. The requested ones stay recorded if the driver does not report
+// them.
+func (ep *endpoint) publishPorts(c *controller) {
+	info, err := ep.DriverInfo()
+	if err != nil {
+		return
+	}
+	if bindings, ok := info[netlabel.PortMap].([]types.PortBinding); ok {
+		ep.Lock()
+		eid, name := ep.id, ep.name
+		ep.Unlock()
+		c.ports.update(eid, name, bindings)
+	}
+}
+
+// restorePorts records the ports an endpoint attached back to a restored
+// sandbox published before the restart, which its driver still binds
+func (c *controller) restorePorts(ep *endpoint) {
+	ep.Lock()
+	eid, name := ep.id, ep.name
+	ep.Unlock()
+
+	if err := c.ports.reserve(eid, name, ep.portBindings()); err != nil {
+		log.Warnf("Ports of restored endpoint %s overlap published ones: %v", name, err)
+		c.ports.update(eid, name, ep.portBindings())
+	}
+	if _, external := c.pub.get(); !external {
+		ep.publishPorts(c)
+	}
+}
+
+// portsDeferred tells whether the endpoint binds its ports only once joined
+// or activated
+func (ep *endpoint) portsDeferred() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	deferred, _ := ep.generic[netlabel.DeferPortBinding].(bool)
+	return deferred
+}
+
+func (ep *endpoint) ActivatePorts(ctx context.Context) error {
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	if !ep.portsDeferred() {
+		return types.ForbiddenErrorf("the ports of endpoint %s are not deferred", ep.Name())
+	}
+	if err := ep.authorize(ctx, AuthzEndpointActivatePorts, nil); err != nil {
+		return err
+	}
+
+	ep.joinLeaveStart()
+	defer ep.joinLeaveEnd()
+	return ep.activatePorts()
+}
+
+// activatePorts reserves the deferred ports of the endpoint and has the
+// driver bind them and the port publisher publish them, unless they are
+// already bound
+func (ep *endpoint) activatePorts() error {
+	if !ep.portsDeferred() {
+		return nil
+	}
+
+	ep.Lock()
+	if ep.portsActive {
+		ep.Unlock()
+		return nil
+	}
+	ep.portsActive = true
+	eid, name := ep.id, ep.name
+	ep.Unlock()
+
+	c := ep.controller()
+	err := c.ports.reserve(eid, name, ep.portBindings())
+	if err == nil {
+		if err = ep.setExternalConnectivity(true); err != nil {
+			c.ports.update(eid, name, nil)
+		}
+	}
+	if err != nil {
+		ep.Lock()
+		ep.portsActive = false
+		ep.Unlock()
+		return err
+	}
+
+	if _, external := c.pub.get(); !external {
+		ep.publishPorts(c)
+	}
+	return nil
+}
+
+// deactivatePorts unbinds the deferred ports of the endpoint, when the
+// container leaves it. The errors are logged.
+func (ep *endpoint) deactivatePorts() {
+	ep.Lock()
+	if !ep.portsActive {
+		ep.Unlock()
+		return
+	}
+	ep.portsActive = false
+	eid, name := ep.id, ep.name
+	ep.Unlock()
+
+	if err := ep.setExternalConnectivity(false); err != nil {
+		log.Warnf("Failed to unbind the ports of endpoint %s: %v", name, err)
+	}
+	ep.controller().ports.update(eid, name, nil)
+}
@@ -0,0 +1,255 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+)
+
+// tenantUsage is the count of the resources of a tenant checked against its
+// quota, as kept in the datastore
+type tenantUsage struct {
+	tenant    string
+	Networks  int
+	Addresses int
+	dbIndex   uint64
+	dbExists  bool
+}
+
+func (u *tenantUsage) Key() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.QuotaKeyPrefix, u.tenant)
+}
+
+func (u *tenantUsage) KeyPrefix() datastore.KeyPath {
+	return datastore.NewKeyPath(datastore.QuotaKeyPrefix)
+}
+
+func (u *tenantUsage) Value() []byte {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (u *tenantUsage) SetValue(value []byte) error {
+	return json.Unmarshal(value, u)
+}
+
+func (u *tenantUsage) Index() uint64 {
+	return u.dbIndex
+}
+
+func (u *tenantUsage) SetIndex(index uint64) {
+	u.dbIndex = index
+	u.dbExists = true
+}
+
+func (u *tenantUsage) Exists() bool {
+	return u.dbExists
+}
+
+// charge adds the deltas to the usage, failing if an increase goes beyond
+// the quota. Decreases are never refused.
+func (u *tenantUsage) charge(quota config.Quota, networks, addresses int) error {
+	if networks > 0 && quota.Networks > 0 && u.Networks+networks > quota.Networks {
+		return &QuotaExceededError{Tenant: u.tenant, Resource: "networks", Limit: quota.Networks}
+	}
+	if addresses > 0 && quota.Addresses > 0 && u.Addresses+addresses > quota.Addresses {
+		return &QuotaExceededError{Tenant: u.tenant, Resource: "addresses", Limit: quota.Addresses}
+	}
+	u.Networks += networks
+	u.Addresses += addresses
+	if u.Networks < 0 {
+		u.Networks = 0
+	}
+	if u.Addresses < 0 {
+		u.Addresses = 0
+	}
+	return nil
+}
+
+func (c *controller) tenantQuota(tenant string) config.Quota {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg == nil {
+		return config.Quota{}
+	}
+	return c.cfg.Quotas.TenantQuota(tenant)
+}
+
+// chargeQuota updates the usage of the tenant by the deltas, checking the
+// increases against its quota. The usage is kept in the datastore if there
+// is one, so that it is shared by the hosts, and in memory otherwise. The
+// networks without tenant are not accounted.
+func (c *controller) chargeQuota(ctx context.Context, tenant string, networks, addresses int) error {
+	if tenant == "" || networks == 0 && addresses == 0 {
+		return nil
+	}
+	quota := c.tenantQuota(tenant)
+
+	c.Lock()
+	cs := c.store
+	if cs == nil {
+		defer c.Unlock()
+		if c.quotaUsage == nil {
+			c.quotaUsage = make(map[string]*tenantUsage)
+		}
+		u, ok := c.quotaUsage[tenant]
+		if !ok {
+			u = &tenantUsage{tenant: tenant}
+		}
+		if err := u.charge(quota, networks, addresses); err != nil {
+			return err
+		}
+		c.quotaUsage[tenant] = u
+		return nil
+	}
+	c.Unlock()
+
+	// The usage is updated atomically, racing with the other hosts
+	var err error
+	for i := 0; i < maxAuditAttempts; i++ {
+		u := &tenantUsage{tenant: tenant}
+		if err = cs.GetObject(u.Key().String(), u); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		if err = u.charge(quota, networks, addresses); err != nil {
+			return err
+		}
+		if err = datastore.WithContext(ctx, cs).PutObjectAtomic(u); err != datastore.ErrKeyModified {
+			break
+		}
+	}
+	return err
+}
+
+// releaseQuota returns resources of the tenant. A failure is only logged,
+// the usage then stays higher than it is.
+func (c *controller) releaseQuota(tenant string, networks, addresses int) {
+	if err := c.chargeQuota(context.Background(), tenant, -networks, -addresses); err != nil {
+		log.Warnf("failed to release the quota usage of tenant %s: %v", tenant, err)
+	}
+}
+
+// QuotaUsage returns the number of networks and addresses accounted to the
+// tenant
+func (c *controller) QuotaUsage(tenant string) (networks, addresses int, err error) {
+	c.Lock()
+	cs := c.store
+	if cs == nil {
+		defer c.Unlock()
+		if u, ok := c.quotaUsage[tenant]; ok {
+			return u.Networks, u.Addresses, nil
+		}
+		return 0, 0, nil
+	}
+	c.Unlock()
+
+	u := &tenantUsage{tenant: tenant}
+	if err := cs.GetObject(u.Key().String(), u); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	return u.Networks, u.Addresses, nil
+}
+
+// NetworkOptionTenant function returns an option setter for the tenant the
+// network is accounted to, whose quota bounds its creation and the number
+// of its endpoints and of their addresses
+func NetworkOptionTenant(tenant string) NetworkOption {
+	return func(n *network) {
+		n.tenant = tenant
+	}
+}
+
+// addressCount returns the number of addresses held by the endpoint, its
+// secondary addresses included
+func (ep *endpoint) addressCount() int {
+	ep.Lock()
+	defer ep.Unlock()
+
+	cnt := 0
+	for _, iface := range ep.iFaces {
+		if len(iface.addr.IP) != 0 {
+			cnt++
+		}
+		if len(iface.addrv6.IP) != 0 {
+			cnt++
+		}
+		cnt += len(iface.secondary)
+	}
+	return cnt
+}
+
+// checkEndpointQuota fails if the network has more endpoints, counting the
+// one being created, than the quota of its tenant allows
+func (c *controller) checkEndpointQuota(n *network) error {
+	n.Lock()
+	tenant := n.tenant
+	cnt := n.endpointCnt
+	n.Unlock()
+
+	if tenant == "" {
+		return nil
+	}
+	quota := c.tenantQuota(tenant)
+	if quota.EndpointsPerNetwork > 0 && cnt > uint64(quota.EndpointsPerNetwork) {
+		return &QuotaExceededError{Tenant: tenant, Resource: "endpoints per network", Limit: quota.EndpointsPerNetwork}
+	}
+	return nil
+}
+
+// chargeQuota accounts the addresses of the endpoint to the tenant of its
+// network. Only the difference with the addresses already accounted is
+// charged, so that it is called again once the addresses change, as when
+// the driver assigns them on join, and gives back the ones released.
+func (ep *endpoint) chargeQuota(ctx context.Context) error {
+	ep.Lock()
+	n := ep.network
+	ep.Unlock()
+
+	n.Lock()
+	tenant := n.tenant
+	ctrlr := n.ctrlr
+	n.Unlock()
+
+	if tenant == "" {
+		return nil
+	}
+	cnt := ep.addressCount()
+	ep.Lock()
+	delta := cnt - ep.quotaAddresses
+	ep.Unlock()
+	if delta < 0 {
+		ctrlr.releaseQuota(tenant, 0, -delta)
+	} else if err := ctrlr.chargeQuota(ctx, tenant, 0, delta); err != nil {
+		return err
+	}
+	ep.Lock()
+	ep.quotaAddresses = cnt
+	ep.Unlock()
+	return nil
+}
+
+func (ep *endpoint) releaseQuota() {
+	ep.Lock()
+	n := ep.network
+	cnt := ep.quotaAddresses
+	ep.quotaAddresses = 0
+	ep.Unlock()
+
+	n.Lock()
+	tenant := n.tenant
+	ctrlr := n.ctrlr
+	n.Unlock()
+
+	if cnt != 0 {
+		ctrlr.releaseQuota(tenant, 0, cnt)
+	}
+}
@@ -0,0 +1,131 @@
+package libnetwork
+
+import (
+	"context"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// ServiceRegistration is when an endpoint is added to the service records
+// of its network, which the hosts files and the DNS zones are built from
+type ServiceRegistration string
+
+// The service registration modes
+const (
+	// RegisterOnCreate registers the endpoint as soon as it is created,
+	// the default
+	RegisterOnCreate ServiceRegistration = "create"
+	// RegisterOnJoin registers the endpoint while a container is joined to
+	// it
+	RegisterOnJoin ServiceRegistration = "join"
+	// RegisterOnActivate registers the joined endpoint once it is activated
+	// with Endpoint.Activate, when its container is ready for traffic, until
+	// it is deactivated or the container leaves it
+	RegisterOnActivate ServiceRegistration = "activate"
+	// RegisterNever keeps the endpoint out of the service records
+	RegisterNever ServiceRegistration = "never"
+)
+
+func (r ServiceRegistration) valid() bool {
+	switch r {
+	case RegisterOnCreate, RegisterOnJoin, RegisterOnActivate, RegisterNever:
+		return true
+	}
+	return false
+}
+
+// serviceRegistration returns the registration mode of the endpoint
+func (ep *endpoint) serviceRegistration() ServiceRegistration {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.registrationLocked()
+}
+
+func (ep *endpoint) registrationLocked() ServiceRegistration {
+	if s, ok := ep.generic[netlabel.ServiceRegistration].(string); ok {
+		return ServiceRegistration(s)
+	}
+	return RegisterOnCreate
+}
+
+// serviceRegistered tells whether the endpoint belongs in the service
+// records of its network, given its registration mode
+func (ep *endpoint) serviceRegistered() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.registeredLocked()
+}
+
+func (ep *endpoint) registeredLocked() bool {
+	switch ep.registrationLocked() {
+	case RegisterOnJoin:
+		return ep.container != nil
+	case RegisterOnActivate:
+		return ep.container != nil && ep.serviceActive
+	case RegisterNever:
+		return false
+	}
+	return true
+}
+
+func (ep *endpoint) Activate(ctx context.Context) error {
+	return ep.setServiceActive(ctx, AuthzEndpointActivate, true)
+}
+
+func (ep *endpoint) Deactivate(ctx context.Context) error {
+	return ep.setServiceActive(ctx, AuthzEndpointDeactivate, false)
+}
+
+func (ep *endpoint) setServiceActive(ctx context.Context, op string, active bool) error {
+	if ep.isReadOnly() {
+		return ErrReadOnly{}
+	}
+	if r := ep.serviceRegistration(); r != RegisterOnActivate {
+		return types.ForbiddenErrorf("endpoint %s is registered on %s, not on activation", ep.Name(), r)
+	}
+	if err := ep.authorize(ctx, op, nil); err != nil {
+		return err
+	}
+
+	ep.joinLeaveStart()
+	defer ep.joinLeaveEnd()
+
+	ep.Lock()
+	if ep.container == nil {
+		ep.Unlock()
+		return types.ForbiddenErrorf("endpoint %s is not joined to a container", ep.name)
+	}
+	if ep.serviceActive == active {
+		ep.Unlock()
+		return nil
+	}
+	ep.serviceActive = active
+	n := ep.network
+	ep.Unlock()
+
+	if err := n.ctrlr.updateEndpointToStore(ctx, ep); err != nil {
+		ep.Lock()
+		ep.serviceActive = !active
+		ep.Unlock()
+		return err
+	}
+
+	n.updateSvcRecord(ep, active)
+	return nil
+}
+
+// unregisterOnLeave removes the records of an endpoint registered while its
+// container is joined, once the container left
+func (ep *endpoint) unregisterOnLeave() {
+	ep.Lock()
+	r := ep.registrationLocked()
+	active := ep.serviceActive
+	ep.serviceActive = false
+	n := ep.network
+	ep.Unlock()
+
+	if r == RegisterOnJoin || (r == RegisterOnActivate && active) {
+		n.updateSvcRecord(ep, false)
+	}
+}
@@ -98,6 +98,24 @@ nameserver 4.30.20.100`: {"foo.example.com", "example.com"},
 	}
 }
 
+func TestGetOptions(t *testing.T) {
+	for resolv, result := range map[string][]string{
+		`options ndots:2`:                {"ndots:2"},
+		`options ndots:2 # ignored`:      {"ndots:2"},
+		` 	 options 	 ndots:2 	 rotate `: {"ndots:2", "rotate"},
+		``:                               {},
+		`# ignored`:                      {},
+		`nameserver 1.2.3.4
+options ndots:1
+options ndots:2 timeout:1`: {"ndots:2", "timeout:1"},
+	} {
+		test := GetOptions([]byte(resolv))
+		if !strSlicesEqual(test, result) {
+			t.Fatalf("Wrong options {%s} should be %v. Input: %s", test, result, resolv)
+		}
+	}
+}
+
 func strSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -238,3 +256,25 @@ func TestFilterResolvDns(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildWithOptions(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	err = BuildWithOptions(file.Name(), []string{"ns1"}, []string{"search1"}, []string{"ndots:2", "rotate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "nameserver ns1\nsearch search1\noptions ndots:2 rotate\n"; string(content) != expected {
+		t.Fatalf("Expected '%s' got '%s'", expected, content)
+	}
+}
@@ -0,0 +1,143 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// restoreEndpoints hands the endpoints of the networks of the driver over
+// to a driver which does not keep its state across restarts, like a plugin,
+// for it to reconcile its state with them. A failure is only logged, the
+// driver stays registered.
+func (c *controller) restoreEndpoints(networkType string, d driverapi.Driver, scope driverapi.Scope) {
+	r, ok := d.(driverapi.Restorer)
+	if !ok {
+		return
+	}
+
+	records, err := c.endpointRecords(networkType, scope)
+	if err != nil {
+		log.Warnf("Failed to read the endpoints to restore to driver %s: %v", networkType, err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	timeout := config.DefaultPluginTimeout
+	c.Lock()
+	if c.cfg != nil {
+		timeout = c.cfg.Plugins.ActivationTimeout(networkType)
+	}
+	c.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := r.RestoreEndpoints(ctx, records); err != nil {
+		log.Warnf("Failed to restore %d endpoints to driver %s: %v", len(records), networkType, err)
+	}
+}
+
+// endpointRecords returns the records of the endpoints of the networks of
+// the type. The ones of the global scope networks are read from the store,
+// as the networks are only loaded from it once their driver is registered.
+func (c *controller) endpointRecords(networkType string, scope driverapi.Scope) ([]driverapi.RestoredEndpoint, error) {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+	if cs == nil || scope != driverapi.GlobalScope {
+		return c.localEndpointRecords(networkType), nil
+	}
+
+	nws, err := c.getNetworksFromStore()
+	if err == datastore.ErrKeyNotFound {
+		return c.localEndpointRecords(networkType), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []driverapi.RestoredEndpoint
+	for _, kve := range nws {
+		n := &network{}
+		if err := json.Unmarshal(kve.Value, n); err != nil {
+			log.Warnf("Skipping undecodable network record %s: %v", kve.Key, err)
+			continue
+		}
+		if n.networkType != networkType {
+			continue
+		}
+
+		eps, err := cs.KVStore().List(datastore.NewKeyPath(datastore.EndpointKeyPrefix, string(n.id)).String())
+		if err == datastore.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, epe := range eps {
+			ep := &endpoint{}
+			if err := json.Unmarshal(epe.Value, ep); err != nil {
+				log.Warnf("Skipping undecodable endpoint record %s: %v", epe.Key, err)
+				continue
+			}
+			records = append(records, ep.restoreRecord(n.id))
+		}
+	}
+
+	return records, nil
+}
+
+// localEndpointRecords returns the records of the endpoints known to the
+// controller, for the networks which are not in the store
+func (c *controller) localEndpointRecords(networkType string) []driverapi.RestoredEndpoint {
+	var records []driverapi.RestoredEndpoint
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		n.Lock()
+		if n.networkType != networkType {
+			n.Unlock()
+			continue
+		}
+		eps := make([]*endpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			records = append(records, ep.restoreRecord(n.id))
+		}
+	}
+	return records
+}
+
+func (ep *endpoint) restoreRecord(nid types.UUID) driverapi.RestoredEndpoint {
+	ep.Lock()
+	defer ep.Unlock()
+
+	rec := driverapi.RestoredEndpoint{
+		NetworkID:  nid,
+		EndpointID: ep.id,
+		Interfaces: make([]driverapi.RestoredInterface, 0, len(ep.iFaces)),
+		Options:    ep.generic,
+	}
+	for _, iface := range ep.iFaces {
+		rec.Interfaces = append(rec.Interfaces, driverapi.RestoredInterface{
+			ID:          iface.id,
+			MacAddress:  iface.mac,
+			Address:     iface.addr,
+			AddressIPv6: iface.addrv6,
+		})
+	}
+	if ep.container != nil {
+		rec.ContainerID = ep.container.id
+	}
+	return rec
+}
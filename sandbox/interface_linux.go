@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"syscall"
 
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
@@ -19,9 +20,13 @@ type nwIface struct {
 	dstMaster   string
 	address     *net.IPNet
 	addressIPv6 *net.IPNet
+	secondary   []*net.IPNet
 	routes      []*net.IPNet
 	bridge      bool
-	ns          *networkNamespace
+	// named is set when the destination name is the name of the interface
+	// rather than a prefix
+	named bool
+	ns    *networkNamespace
 	sync.Mutex
 }
 
@@ -74,6 +79,18 @@ func (i *nwIface) AddressIPv6() *net.IPNet {
 	return types.GetIPNetCopy(i.addressIPv6)
 }
 
+func (i *nwIface) SecondaryAddresses() []*net.IPNet {
+	i.Lock()
+	defer i.Unlock()
+
+	addrs := make([]*net.IPNet, len(i.secondary))
+	for index, addr := range i.secondary {
+		addrs[index] = types.GetIPNetCopy(addr)
+	}
+
+	return addrs
+}
+
 func (i *nwIface) Routes() []*net.IPNet {
 	i.Lock()
 	defer i.Unlock()
@@ -153,6 +170,62 @@ func (i *nwIface) Remove() error {
 	})
 }
 
+func (i *nwIface) SetAddress(addr *net.IPNet) error {
+	i.Lock()
+	n := i.ns
+	oldAddr := i.address
+	i.Unlock()
+
+	n.Lock()
+	path := n.path
+	n.Unlock()
+
+	return nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		iface, err := netlink.LinkByName(i.DstName())
+		if err != nil {
+			return err
+		}
+
+		if oldAddr != nil {
+			if err := netlink.AddrDel(iface, &netlink.Addr{IPNet: oldAddr}); err != nil {
+				return fmt.Errorf("failed to remove address %s from %q: %v", oldAddr, i.DstName(), err)
+			}
+		}
+
+		if err := netlink.AddrAdd(iface, &netlink.Addr{IPNet: addr}); err != nil {
+			if oldAddr != nil {
+				netlink.AddrAdd(iface, &netlink.Addr{IPNet: oldAddr})
+			}
+			return fmt.Errorf("failed to add address %s to %q: %v", addr, i.DstName(), err)
+		}
+
+		i.Lock()
+		i.address = types.GetIPNetCopy(addr)
+		i.Unlock()
+
+		// The secondary addresses of the subnet of the old address went
+		// away with it
+		for _, addr := range i.SecondaryAddresses() {
+			if err := netlink.AddrAdd(iface, &netlink.Addr{IPNet: addr}); err != nil && err != syscall.EEXIST {
+				return fmt.Errorf("failed to add secondary address %s to %q: %v", addr, i.DstName(), err)
+			}
+		}
+
+		for _, route := range i.Routes() {
+			err := netlink.RouteAdd(&netlink.Route{
+				Scope:     netlink.SCOPE_LINK,
+				LinkIndex: iface.Attrs().Index,
+				Dst:       route,
+			})
+			if err != nil && err != syscall.EEXIST {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (n *networkNamespace) findDst(srcName string, isBridge bool) string {
 	n.Lock()
 	defer n.Unlock()
@@ -181,8 +254,10 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 	}
 
 	n.Lock()
-	i.dstName = fmt.Sprintf("%s%d", i.dstName, n.nextIfIndex)
-	n.nextIfIndex++
+	if !i.named {
+		i.dstName = fmt.Sprintf("%s%d", i.dstName, n.nextIfIndex)
+		n.nextIfIndex++
+	}
 	path := n.path
 	n.Unlock()
 
@@ -256,6 +331,7 @@ func configureInterface(iface netlink.Link, i *nwIface) error {
 		{setInterfaceName, fmt.Sprintf("error renaming interface %q to %q", ifaceName, i.DstName())},
 		{setInterfaceIP, fmt.Sprintf("error setting interface %q IP to %q", ifaceName, i.Address())},
 		{setInterfaceIPv6, fmt.Sprintf("error setting interface %q IPv6 to %q", ifaceName, i.AddressIPv6())},
+		{setInterfaceSecondaryIPs, fmt.Sprintf("error setting interface %q secondary addresses to %q", ifaceName, i.SecondaryAddresses())},
 		{setInterfaceRoutes, fmt.Sprintf("error setting interface %q routes to %q", ifaceName, i.Routes())},
 		{setInterfaceMaster, fmt.Sprintf("error setting interface %q master to %q", ifaceName, i.DstMaster())},
 	}
@@ -294,6 +370,15 @@ func setInterfaceIPv6(iface netlink.Link, i *nwIface) error {
 	return netlink.AddrAdd(iface, ipAddr)
 }
 
+func setInterfaceSecondaryIPs(iface netlink.Link, i *nwIface) error {
+	for _, addr := range i.SecondaryAddresses() {
+		if err := netlink.AddrAdd(iface, &netlink.Addr{IPNet: addr, Label: ""}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func setInterfaceName(iface netlink.Link, i *nwIface) error {
 	return netlink.LinkSetName(iface, i.DstName())
 }
@@ -147,6 +147,69 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return &networkNamespace{path: key}, nil
 }
 
+// GetSandboxForExternalKey returns a sandbox instance for a network namespace
+// which has already been created and mounted at key by an external entity.
+// The namespace is neither created nor bind mounted by this call.
+func GetSandboxForExternalKey(key string) (Sandbox, error) {
+	f, err := os.OpenFile(key, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed get network namespace %q: %v", key, err)
+	}
+	f.Close()
+
+	return &networkNamespace{path: key}, nil
+}
+
+// RestoreSandbox returns a sandbox instance for a network namespace set up
+// before a restart, which already holds the interfaces, gateways and static
+// routes passed. Nothing is changed in the namespace, the sandbox only
+// tracks them again, for instance to remove the interfaces.
+func RestoreSandbox(key string, ifaces []RestoredInterface, gw, gw6 net.IP, routes []*types.StaticRoute) (Sandbox, error) {
+	f, err := os.OpenFile(key, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed get network namespace %q: %v", key, err)
+	}
+	f.Close()
+
+	n := &networkNamespace{path: key, gw: gw, gwv6: gw6, staticRoutes: routes}
+	for _, ri := range ifaces {
+		i := &nwIface{
+			srcName:     ri.SrcName,
+			dstName:     ri.DstName,
+			master:      ri.Master,
+			bridge:      ri.Bridge,
+			address:     ri.Address,
+			addressIPv6: ri.AddressIPv6,
+			secondary:   ri.Secondary,
+			routes:      ri.Routes,
+			ns:          n,
+		}
+		n.iFaces = append(n.iFaces, i)
+		// The next interface gets the index following the highest one
+		if idx := ifaceIndex(ri.DstName); idx >= n.nextIfIndex {
+			n.nextIfIndex = idx + 1
+		}
+	}
+
+	return n, nil
+}
+
+// ifaceIndex returns the index suffixing the interface name, -1 if none
+func ifaceIndex(name string) int {
+	end := len(name)
+	for end > 0 && name[end-1] >= '0' && name[end-1] <= '9' {
+		end--
+	}
+	if end == len(name) {
+		return -1
+	}
+	idx := 0
+	for _, c := range name[end:] {
+		idx = idx*10 + int(c-'0')
+	}
+	return idx
+}
+
 func (n *networkNamespace) InterfaceOptions() IfaceOptionSetter {
 	return n
 }
@@ -236,12 +299,61 @@ func loopbackUp() error {
 }
 
 func (n *networkNamespace) InvokeFunc(f func()) error {
-	return nsInvoke(n.nsPath(), func(nsFD int) error { return nil }, func(callerFD int) error {
+	return n.Invoke(func() error {
 		f()
 		return nil
 	})
 }
 
+func (n *networkNamespace) Invoke(fn func() error) error {
+	path := n.nsPath()
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		restored, err := invokeInNamespace(path, fn)
+		if restored {
+			runtime.UnlockOSThread()
+		}
+		// A goroutine exiting with its thread locked terminates the thread
+		errCh <- err
+	}()
+	return <-errCh
+}
+
+// invokeInNamespace runs fn with the locked thread switched to the namespace
+// at path, and reports whether the thread is back in its original namespace
+func invokeInNamespace(path string, fn func() error) (restored bool, err error) {
+	origns, err := netns.Get()
+	if err != nil {
+		return true, err
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return true, fmt.Errorf("failed get network namespace %q: %v", path, err)
+	}
+	defer f.Close()
+
+	if err := netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		return true, err
+	}
+	defer func() {
+		if e := netns.Set(origns); e != nil {
+			log.Errorf("Failed to restore the network namespace of the thread invoked in %s: %v", path, e)
+			return
+		}
+		restored = true
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in function invoked in network namespace %s: %v", path, r)
+		}
+	}()
+	return false, fn()
+}
+
 func nsInvoke(path string, prefunc func(nsFD int) error, postfunc func(callerFD int) error) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
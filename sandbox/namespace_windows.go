@@ -1,5 +1,11 @@
 package sandbox
 
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
@@ -17,6 +23,18 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return nil, nil
 }
 
+// GetSandboxForExternalKey returns a sandbox instance for a network namespace
+// which has already been created by an external entity
+func GetSandboxForExternalKey(key string) (Sandbox, error) {
+	return nil, nil
+}
+
+// RestoreSandbox returns a sandbox instance for a network namespace set up
+// before a restart
+func RestoreSandbox(key string, ifaces []RestoredInterface, gw, gw6 net.IP, routes []*types.StaticRoute) (Sandbox, error) {
+	return nil, nil
+}
+
 // GC triggers garbage collection of namespace path right away
 // and waits for it.
 func GC() {
@@ -54,8 +54,21 @@ func (n *networkNamespace) AddressIPv6(addr *net.IPNet) IfaceOption {
 	}
 }
 
+func (n *networkNamespace) SecondaryAddresses(addrs []*net.IPNet) IfaceOption {
+	return func(i *nwIface) {
+		i.secondary = addrs
+	}
+}
+
 func (n *networkNamespace) Routes(routes []*net.IPNet) IfaceOption {
 	return func(i *nwIface) {
 		i.routes = routes
 	}
 }
+
+func (n *networkNamespace) Name(name string) IfaceOption {
+	return func(i *nwIface) {
+		i.dstName = name
+		i.named = true
+	}
+}
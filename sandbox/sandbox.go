@@ -52,6 +52,15 @@ type Sandbox interface {
 	//Invoke
 	InvokeFunc(func()) error
 
+	// Invoke runs the function in the network namespace of the sandbox and
+	// returns its error, for the embedders to set up the sandbox with their
+	// own netlink or socket calls. The function runs on a thread of its own,
+	// which is never reused in the namespace: the thread is discarded if it
+	// cannot switch back to its original namespace. A panic of the function
+	// is returned as an error. The goroutines the function starts do not run
+	// in the namespace.
+	Invoke(func() error) error
+
 	// Returns an interface with methods to get sandbox state.
 	Info() Info
 
@@ -81,6 +90,10 @@ type IfaceOptionSetter interface {
 	// Address returns an option setter to set IPv6 address.
 	AddressIPv6(*net.IPNet) IfaceOption
 
+	// SecondaryAddresses returns an option setter to set the IPv4 and IPv6
+	// addresses of the interface besides its primary ones.
+	SecondaryAddresses([]*net.IPNet) IfaceOption
+
 	// Master returns an option setter to set the master interface if any for this
 	// interface. The master interface name should refer to the srcname of a
 	// previously added interface of type bridge.
@@ -88,6 +101,10 @@ type IfaceOptionSetter interface {
 
 	// Address returns an option setter to set interface routes.
 	Routes([]*net.IPNet) IfaceOption
+
+	// Name returns an option setter to set the name of the interface in
+	// the sandbox, instead of the destination prefix followed by an index.
+	Name(string) IfaceOption
 }
 
 // Info represents all possible information that
@@ -134,6 +151,9 @@ type Interface interface {
 	// IPv6 address for the interface.
 	AddressIPv6() *net.IPNet
 
+	// Secondary IPv4 and IPv6 addresses for the interface.
+	SecondaryAddresses() []*net.IPNet
+
 	// IP routes for the interface.
 	Routes() []*net.IPNet
 
@@ -143,7 +163,40 @@ type Interface interface {
 	// Master returns the srcname of the master interface for this interface.
 	Master() string
 
+	// SetAddress replaces the IPv4 address of the interface. The interface
+	// routes and secondary addresses are programmed again as they go away
+	// with the old address.
+	SetAddress(addr *net.IPNet) error
+
 	// Remove an interface from the sandbox by renaming to original name
 	// and moving it out of the sandbox.
 	Remove() error
 }
+
+// RestoredInterface describes an interface a network namespace was left
+// with, for RestoreSandbox to track it again
+type RestoredInterface struct {
+	SrcName     string
+	DstName     string
+	Master      string `json:",omitempty"`
+	Bridge      bool   `json:",omitempty"`
+	Address     *net.IPNet
+	AddressIPv6 *net.IPNet   `json:",omitempty"`
+	Secondary   []*net.IPNet `json:",omitempty"`
+	Routes      []*net.IPNet `json:",omitempty"`
+}
+
+// NewRestoredInterface returns the description of the interface of a
+// sandbox, to restore it with
+func NewRestoredInterface(i Interface) RestoredInterface {
+	return RestoredInterface{
+		SrcName:     i.SrcName(),
+		DstName:     i.DstName(),
+		Master:      i.Master(),
+		Bridge:      i.Bridge(),
+		Address:     i.Address(),
+		AddressIPv6: i.AddressIPv6(),
+		Secondary:   i.SecondaryAddresses(),
+		Routes:      i.Routes(),
+	}
+}
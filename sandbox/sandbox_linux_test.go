@@ -152,3 +152,134 @@ func verifyCleanup(t *testing.T, s Sandbox, wait bool) {
 		}
 	}
 }
+
+func TestInvoke(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+	defer func() {
+		s.Destroy()
+		GC()
+	}()
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origns.Close()
+
+	if err := s.Invoke(func() error {
+		return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "invoke0"}})
+	}); err != nil {
+		t.Fatalf("Failed to add a link in the sandbox: %v", err)
+	}
+	if err := s.Invoke(func() error {
+		_, err := netlink.LinkByName("invoke0")
+		return err
+	}); err != nil {
+		t.Fatalf("Link added in the sandbox was not found there: %v", err)
+	}
+	if _, err := netlink.LinkByName("invoke0"); err == nil {
+		t.Fatal("Link added in the sandbox was found in the caller namespace")
+	}
+
+	if err := s.Invoke(func() error {
+		return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "invoke0"}})
+	}); err == nil {
+		t.Fatal("Error of the invoked function was not returned")
+	}
+	if err := s.Invoke(func() error {
+		panic("invoked")
+	}); err == nil {
+		t.Fatal("Panic of the invoked function was not returned")
+	}
+
+	ns, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	if !ns.Equal(origns) {
+		t.Fatal("Invoke changed the namespace of the caller")
+	}
+}
+
+func TestRestoreSandbox(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer func() {
+		s.Destroy()
+		GC()
+	}()
+
+	gw := net.ParseIP("172.20.0.1")
+	ifaces := []RestoredInterface{
+		{SrcName: "veth1", DstName: "eth0"},
+		{SrcName: "veth2", DstName: "eth2"},
+	}
+	r, err := RestoreSandbox(key, ifaces, gw, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Info().Interfaces()) != 2 || r.Info().Interfaces()[1].DstName() != "eth2" || !r.Info().Gateway().Equal(gw) {
+		t.Fatalf("Restored sandbox does not track its interfaces and gateway")
+	}
+	if n := r.(*networkNamespace).nextIfIndex; n != 3 {
+		t.Fatalf("Expected the next interface index to be 3, got %d", n)
+	}
+
+	if _, err := RestoreSandbox(key+"-missing", nil, nil, nil, nil); err == nil {
+		t.Fatal("Sandbox without a namespace was restored")
+	}
+}
+
+func TestAddNamedInterface(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+	defer s.Destroy()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: vethName1, TxQLen: 0},
+		PeerName:  vethName2}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.LinkDel(veth)
+
+	if err := s.AddInterface(vethName2, sboxIfaceName, s.InterfaceOptions().Name("eth7")); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+
+	ifaces := s.Info().Interfaces()
+	if len(ifaces) != 1 || ifaces[0].DstName() != "eth7" {
+		t.Fatalf("Expected the interface to be named eth7, got %v", ifaces)
+	}
+}
@@ -2,7 +2,12 @@
 
 package sandbox
 
-import "errors"
+import (
+	"errors"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
 
 var (
 	// ErrNotImplemented is for platforms which don't implement sandbox
@@ -15,6 +20,18 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return nil, ErrNotImplemented
 }
 
+// GetSandboxForExternalKey returns a sandbox instance for a network namespace
+// which has already been created by an external entity
+func GetSandboxForExternalKey(key string) (Sandbox, error) {
+	return nil, ErrNotImplemented
+}
+
+// RestoreSandbox returns a sandbox instance for a network namespace set up
+// before a restart
+func RestoreSandbox(key string, ifaces []RestoredInterface, gw, gw6 net.IP, routes []*types.StaticRoute) (Sandbox, error) {
+	return nil, ErrNotImplemented
+}
+
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
@@ -0,0 +1,366 @@
+package libnetwork
+
+import (
+	"container/heap"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
+)
+
+// sandboxState is the composition of a sandbox kept on disk, for the
+// sandbox to be restored after the daemon restarts without having left it
+type sandboxState struct {
+	Key          string
+	External     bool                        `json:",omitempty"`
+	Pod          bool                        `json:",omitempty"`
+	Isolated     bool                        `json:",omitempty"`
+	Interfaces   []sandbox.RestoredInterface `json:",omitempty"`
+	Gateway      net.IP                      `json:",omitempty"`
+	GatewayIPv6  net.IP                      `json:",omitempty"`
+	StaticRoutes []*types.StaticRoute        `json:",omitempty"`
+	Endpoints    []*sandboxEndpointState     `json:",omitempty"`
+}
+
+// sandboxEndpointState is the part of an endpoint joined to a sandbox which
+// is not in the store: the container configuration and the join results
+type sandboxEndpointState struct {
+	NetworkID      types.UUID
+	EndpointID     types.UUID
+	ContainerID    string
+	Pod            string               `json:",omitempty"`
+	Priority       int                  `json:",omitempty"`
+	FwMark         uint32               `json:",omitempty"`
+	HostName       string               `json:",omitempty"`
+	DomainName     string               `json:",omitempty"`
+	HostsPath      string               `json:",omitempty"`
+	ResolvConfPath string               `json:",omitempty"`
+	DNS            []string             `json:",omitempty"`
+	DNSSearch      []string             `json:",omitempty"`
+	DNSOptions     []string             `json:",omitempty"`
+	Gateway        net.IP               `json:",omitempty"`
+	GatewayIPv6    net.IP               `json:",omitempty"`
+	StaticRoutes   []*types.StaticRoute `json:",omitempty"`
+}
+
+// sandboxStateDir returns the directory the sandbox states are kept in,
+// empty if they are not kept
+func (c *controller) sandboxStateDir() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg == nil || c.cfg.Daemon.ReadOnly {
+		return ""
+	}
+	return c.cfg.Daemon.SandboxStateDir
+}
+
+func sandboxStatePath(dir, key string) string {
+	return filepath.Join(dir, url.QueryEscape(key)+".json")
+}
+
+// endpointState returns the state of the endpoint joined to the sandbox
+func endpointState(ep *endpoint) *sandboxEndpointState {
+	ep.Lock()
+	defer ep.Unlock()
+
+	st := &sandboxEndpointState{
+		NetworkID:  ep.network.id,
+		EndpointID: ep.id,
+	}
+	if ci := ep.container; ci != nil {
+		st.ContainerID = ci.id
+		st.Pod = ci.config.pod
+		st.Priority = ci.config.prio
+		st.FwMark = ci.data.FwMark
+		st.HostName = ci.config.hostName
+		st.DomainName = ci.config.domainName
+		st.HostsPath = ci.config.hostsPath
+		st.ResolvConfPath = ci.config.resolvConfPath
+		st.DNS = ci.config.dnsList
+		st.DNSSearch = ci.config.dnsSearchList
+		st.DNSOptions = ci.config.dnsOptionsList
+	}
+	if ji := ep.joinInfo; ji != nil {
+		st.Gateway = ji.gw
+		st.GatewayIPv6 = ji.gw6
+		st.StaticRoutes = ji.StaticRoutes
+	}
+	return st
+}
+
+// state returns the composition of the sandbox at key. The endpoints still
+// to re-attach after a restore are kept as they were.
+func (s *sandboxData) state(key string) *sandboxState {
+	s.Lock()
+	st := &sandboxState{
+		Key:      key,
+		External: s.external,
+		Pod:      s.pod,
+		Isolated: s.isolated,
+	}
+	eps := make([]*endpoint, len(s.endpoints))
+	copy(eps, s.endpoints)
+	st.Endpoints = append(st.Endpoints, s.pending...)
+	sb := s.sbox
+	s.Unlock()
+
+	for _, ep := range eps {
+		st.Endpoints = append(st.Endpoints, endpointState(ep))
+	}
+
+	info := sb.Info()
+	for _, i := range info.Interfaces() {
+		st.Interfaces = append(st.Interfaces, sandbox.NewRestoredInterface(i))
+	}
+	st.Gateway = info.Gateway()
+	st.GatewayIPv6 = info.GatewayIPv6()
+	st.StaticRoutes = info.StaticRoutes()
+	return st
+}
+
+// saveSandboxState writes the composition of the sandbox at key to the
+// sandbox state directory, or removes it if the controller no longer
+// tracks the sandbox. A failure is only logged.
+func (c *controller) saveSandboxState(key string) {
+	dir := c.sandboxStateDir()
+	if dir == "" {
+		return
+	}
+
+	c.Lock()
+	sData, ok := c.sandboxes[key]
+	c.Unlock()
+	if !ok {
+		c.removeSandboxState(key)
+		return
+	}
+
+	b, err := json.Marshal(sData.state(key))
+	if err != nil {
+		log.Warnf("Failed to encode the state of sandbox %s: %v", key, err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Warnf("Failed to create the sandbox state directory %s: %v", dir, err)
+		return
+	}
+	// Replace the state at once, a crash leaves the previous one
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		log.Warnf("Failed to save the state of sandbox %s: %v", key, err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), sandboxStatePath(dir, key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warnf("Failed to save the state of sandbox %s: %v", key, err)
+	}
+}
+
+// removeSandboxState removes the state of the sandbox at key, which was
+// destroyed or released
+func (c *controller) removeSandboxState(key string) {
+	dir := c.sandboxStateDir()
+	if dir == "" {
+		return
+	}
+	if err := os.Remove(sandboxStatePath(dir, key)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove the state of sandbox %s: %v", key, err)
+	}
+}
+
+// restoreSandboxes tracks again the sandboxes whose state was kept on disk
+// and whose network namespace is still there, as left by a daemon which did
+// not shut down. The states of the namespaces which are gone are removed.
+// The endpoints of a restored sandbox are attached back as they are found.
+func (c *controller) restoreSandboxes() {
+	dir := c.sandboxStateDir()
+	if dir == "" {
+		return
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read the sandbox state directory %s: %v", dir, err)
+		}
+		return
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Warnf("Failed to read the sandbox state %s: %v", path, err)
+			continue
+		}
+		st := &sandboxState{}
+		if err := json.Unmarshal(b, st); err != nil || st.Key == "" {
+			log.Warnf("Removing the undecodable sandbox state %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		if err := c.restoreSandbox(st); err != nil {
+			log.Warnf("Dropping the state of sandbox %s: %v", st.Key, err)
+			os.Remove(path)
+		}
+	}
+
+	c.attachRestoredEndpoints()
+}
+
+// restoreSandbox tracks again the sandbox of the state, without its
+// endpoints
+func (c *controller) restoreSandbox(st *sandboxState) error {
+	sb, err := sandbox.RestoreSandbox(st.Key, st.Interfaces, st.Gateway, st.GatewayIPv6, st.StaticRoutes)
+	if err != nil {
+		return err
+	}
+
+	sData := &sandboxData{
+		sbox:      sb,
+		endpoints: epHeap{},
+		external:  st.External,
+		isolated:  st.Isolated,
+		pod:       st.Pod,
+		marks:     make(map[*endpoint]uint32),
+		pending:   st.Endpoints,
+	}
+	heap.Init(&sData.endpoints)
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sandboxes[st.Key]; ok {
+		return types.ForbiddenErrorf("sandbox %s is already tracked", st.Key)
+	}
+	c.sandboxes[st.Key] = sData
+	return nil
+}
+
+// attachRestoredEndpoints attaches the endpoints the controller knows to the
+// restored sandboxes they were joined to
+func (c *controller) attachRestoredEndpoints() {
+	for _, nw := range c.Networks() {
+		for _, ep := range nw.Endpoints() {
+			c.attachRestoredEndpoint(ep.(*endpoint))
+		}
+	}
+}
+
+// attachRestoredEndpoint attaches the endpoint back to the restored sandbox
+// it was joined to, if any, as if it joined it again, with the container
+// configuration it joined with. Nothing is programmed, the namespace
+// already holds its interfaces and routes.
+func (c *controller) attachRestoredEndpoint(ep *endpoint) {
+	ep.Lock()
+	id := ep.id
+	joined := ep.container != nil && ep.container.data.SandboxKey != ""
+	ep.Unlock()
+	if joined {
+		return
+	}
+
+	var (
+		key   string
+		sData *sandboxData
+		st    *sandboxEndpointState
+	)
+	c.Lock()
+	for k, s := range c.sandboxes {
+		s.Lock()
+		for i, p := range s.pending {
+			if p.EndpointID == id {
+				key, sData, st = k, s, p
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+		s.Unlock()
+		if st != nil {
+			break
+		}
+	}
+	c.Unlock()
+	if st == nil {
+		return
+	}
+
+	ci := &containerInfo{
+		id: st.ContainerID,
+		config: containerConfig{
+			hostsPathConfig: hostsPathConfig{
+				hostName:      st.HostName,
+				domainName:    st.DomainName,
+				hostsPath:     st.HostsPath,
+				extraHosts:    []extraHost{},
+				parentUpdates: []parentUpdate{},
+			},
+			resolvConfPathConfig: resolvConfPathConfig{
+				resolvConfPath: st.ResolvConfPath,
+				dnsList:        st.DNS,
+				dnsSearchList:  st.DNSSearch,
+				dnsOptionsList: st.DNSOptions,
+			},
+			pod:  st.Pod,
+			prio: st.Priority,
+		},
+		data: ContainerData{SandboxKey: key, FwMark: st.FwMark},
+	}
+	if sData.external {
+		ci.config.sandboxKey = key
+	}
+
+	ep.Lock()
+	ep.container = ci
+	ep.joinInfo = &endpointJoinInfo{
+		gw:             st.Gateway,
+		gw6:            st.GatewayIPv6,
+		hostsPath:      st.HostsPath,
+		resolvConfPath: st.ResolvConfPath,
+		StaticRoutes:   st.StaticRoutes,
+	}
+	ep.Unlock()
+
+	sData.Lock()
+	sData.refCnt++
+	if sData.pod && st.FwMark != 0 {
+		sData.marks[ep] = st.FwMark
+	}
+	heap.Push(&sData.endpoints, ep)
+	sData.Unlock()
+
+	// The ports published at creation are recorded again, as the registry
+	// is rebuilt from the restored endpoints. The deferred ports of a
+	// joined endpoint are bound again.
+	if !ep.portsDeferred() {
+		c.restorePorts(ep)
+	} else if err := ep.activatePorts(); err != nil {
+		log.Warnf("Failed to bind the ports of endpoint %s: %v", ep.Name(), err)
+	}
+
+	ep.reserveDriverAssignedAddresses()
+
+	// The neighbors and the switches may have aged out the addresses while
+	// the daemon was down
+	if err := c.announceEndpoint(key, ep); err != nil {
+		log.Warnf("Failed to announce restored endpoint %s: %v", ep.Name(), err)
+	}
+
+	log.Debugf("Endpoint %s attached back to restored sandbox %s", ep.Name(), key)
+}
@@ -2,11 +2,16 @@ package libnetwork
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
 )
 
 type epHeap []*endpoint
@@ -15,6 +20,18 @@ type sandboxData struct {
 	sbox      sandbox.Sandbox
 	refCnt    int
 	endpoints epHeap
+	external  bool
+	// isolated is set while the external connectivity of the sandbox
+	// is disabled
+	isolated bool
+	// pod is set for the namespace shared by the containers of a pod,
+	// which is destroyed when its last endpoint leaves. Each endpoint
+	// of a pod has its traffic marked with its own firewall mark.
+	pod   bool
+	marks map[*endpoint]uint32
+	// pending are the endpoints of a sandbox restored after a restart
+	// which are not attached back to it yet
+	pending []*sandboxEndpointState
 	sync.Mutex
 }
 
@@ -53,7 +70,7 @@ func (s *sandboxData) updateGateway(ep *endpoint) error {
 	sb.UnsetGateway()
 	sb.UnsetGatewayIPv6()
 
-	if ep == nil {
+	if ep == nil || s.isIsolated() {
 		return nil
 	}
 
@@ -76,10 +93,14 @@ func (s *sandboxData) addEndpoint(ep *endpoint) error {
 	ep.Lock()
 	joinInfo := ep.joinInfo
 	ifaces := ep.iFaces
+	var ifName string
+	if ep.container != nil {
+		ifName = ep.container.config.ifName
+	}
 	ep.Unlock()
 
 	sb := s.sandbox()
-	for _, i := range ifaces {
+	for n, i := range ifaces {
 		var ifaceOptions []sandbox.IfaceOption
 
 		ifaceOptions = append(ifaceOptions, sb.InterfaceOptions().Address(&i.addr),
@@ -88,12 +109,25 @@ func (s *sandboxData) addEndpoint(ep *endpoint) error {
 			ifaceOptions = append(ifaceOptions,
 				sb.InterfaceOptions().AddressIPv6(&i.addrv6))
 		}
+		if len(i.secondary) != 0 {
+			ifaceOptions = append(ifaceOptions,
+				sb.InterfaceOptions().SecondaryAddresses(i.secondary))
+		}
+		if n == 0 && ifName != "" {
+			ifaceOptions = append(ifaceOptions, sb.InterfaceOptions().Name(ifName))
+		}
 
 		if err := sb.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
 			return fmt.Errorf("failed to add interface %s to sandbox: %v", i.srcName, err)
 		}
 	}
 
+	if s.pod {
+		if err := s.markEndpoint(ep); err != nil {
+			return err
+		}
+	}
+
 	if joinInfo != nil {
 		// Set up non-interface routes.
 		for _, r := range ep.joinInfo.StaticRoutes {
@@ -123,6 +157,9 @@ func (s *sandboxData) rmEndpoint(ep *endpoint) {
 	ep.Unlock()
 
 	sb := s.sandbox()
+	if s.pod {
+		s.unmarkEndpoint(ep)
+	}
 	for _, i := range sb.Info().Interfaces() {
 		// Only remove the interfaces owned by this endpoint from the sandbox.
 		if ep.hasInterface(i.SrcName()) {
@@ -166,6 +203,221 @@ func (s *sandboxData) rmEndpoint(ep *endpoint) {
 	}
 }
 
+// updateEndpointAddress moves the sandbox interfaces of ep to addr and
+// programs again the routes which went away with the old address.
+func (s *sandboxData) updateEndpointAddress(ep *endpoint, addr *net.IPNet) error {
+	ep.Lock()
+	joinInfo := ep.joinInfo
+	ep.Unlock()
+
+	sb := s.sandbox()
+	for _, i := range sb.Info().Interfaces() {
+		if ep.hasInterface(i.SrcName()) {
+			if err := i.SetAddress(addr); err != nil {
+				return fmt.Errorf("failed to change address of interface %s: %v", i.SrcName(), err)
+			}
+		}
+	}
+
+	if joinInfo != nil {
+		for _, r := range joinInfo.StaticRoutes {
+			if err := sb.AddStaticRoute(r); err != nil {
+				logrus.Debugf("Re-adding route %s failed: %v", r.Destination, err)
+			}
+		}
+	}
+
+	s.Lock()
+	highEp := s.endpoints[0]
+	s.Unlock()
+
+	return s.updateGateway(highEp)
+}
+
+// setExternalConnectivity removes the default routes of the sandbox and
+// the external access the drivers provide to its endpoints, or restores
+// them. Nothing is changed if any endpoint fails the transition.
+func (s *sandboxData) setExternalConnectivity(enable bool) (err error) {
+	s.Lock()
+	if s.isolated == !enable {
+		s.Unlock()
+		return nil
+	}
+	eps := make([]*endpoint, len(s.endpoints))
+	copy(eps, s.endpoints)
+	s.Unlock()
+
+	var done []*endpoint
+	defer func() {
+		if err != nil {
+			for _, ep := range done {
+				if rbErr := ep.setExternalConnectivity(!enable); rbErr != nil {
+					logrus.Warnf("Failed to restore external connectivity of endpoint %s: %v", ep.Name(), rbErr)
+				}
+			}
+		}
+	}()
+
+	for _, ep := range eps {
+		if err = ep.setExternalConnectivity(enable); err != nil {
+			return fmt.Errorf("failed to set external connectivity of endpoint %s: %v", ep.Name(), err)
+		}
+		done = append(done, ep)
+	}
+
+	s.Lock()
+	s.isolated = !enable
+	var highEp *endpoint
+	if len(s.endpoints) > 0 {
+		highEp = s.endpoints[0]
+	}
+	s.Unlock()
+
+	if err = s.updateGateway(highEp); err != nil {
+		s.Lock()
+		s.isolated = enable
+		s.Unlock()
+		if rbErr := s.updateGateway(highEp); rbErr != nil {
+			logrus.Warnf("Failed to restore the default routes of sandbox %s: %v", s.sandbox().Key(), rbErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// markEndpoint hands out the lowest free firewall mark of the pod to the
+// endpoint and marks the traffic of its interfaces with it
+func (s *sandboxData) markEndpoint(ep *endpoint) error {
+	s.Lock()
+	mark := lowestFreeMark(s.marks)
+	s.marks[ep] = mark
+	s.Unlock()
+
+	if err := programMarks(s.sandbox(), s.endpointIfaces(ep), mark, true); err != nil {
+		s.Lock()
+		delete(s.marks, ep)
+		s.Unlock()
+		return fmt.Errorf("failed to mark the traffic of endpoint %s: %v", ep.Name(), err)
+	}
+
+	ep.Lock()
+	if ep.container != nil {
+		ep.container.data.FwMark = mark
+	}
+	ep.Unlock()
+
+	return nil
+}
+
+func (s *sandboxData) unmarkEndpoint(ep *endpoint) {
+	s.Lock()
+	mark, ok := s.marks[ep]
+	delete(s.marks, ep)
+	s.Unlock()
+
+	if ok {
+		programMarks(s.sandbox(), s.endpointIfaces(ep), mark, false)
+	}
+}
+
+// endpointIfaces returns the names of the interfaces of the endpoint in
+// the sandbox
+func (s *sandboxData) endpointIfaces(ep *endpoint) []string {
+	var names []string
+	for _, i := range s.sandbox().Info().Interfaces() {
+		if ep.hasInterface(i.SrcName()) {
+			names = append(names, i.DstName())
+		}
+	}
+	return names
+}
+
+// announceEndpoint sends a gratuitous ARP for the IPv4 address and an
+// unsolicited neighbor advertisement for the IPv6 address of each interface
+// of the endpoint in the sandbox, so that the neighbors and the switches
+// learn where the endpoint now is. A sandbox the controller does not track,
+// like the one of a container restored after a restart, is opened from its
+// key. The interfaces are found by their addresses.
+func (c *controller) announceEndpoint(key string, ep *endpoint) error {
+	c.Lock()
+	sData, ok := c.sandboxes[key]
+	c.Unlock()
+
+	var (
+		sb  sandbox.Sandbox
+		err error
+	)
+	if ok {
+		sb = sData.sandbox()
+	} else if sb, err = sandbox.GetSandboxForExternalKey(key); err != nil {
+		return err
+	}
+
+	var addrs []net.IP
+	ep.Lock()
+	for _, i := range ep.iFaces {
+		if i.addr.IP != nil {
+			addrs = append(addrs, i.addr.IP)
+		}
+		if i.addrv6.IP != nil {
+			addrs = append(addrs, i.addrv6.IP)
+		}
+		for _, addr := range i.secondary {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	ep.Unlock()
+
+	if e := sb.InvokeFunc(func() {
+		for _, ip := range addrs {
+			var name string
+			if name, err = ifaceWithAddr(ip); err != nil {
+				return
+			}
+			if ip.To4() != nil {
+				err = netutils.SendGratuitousARP(name, ip)
+			} else {
+				err = netutils.SendUnsolicitedNA(name, ip)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// ifaceWithAddr returns the name of the interface assigned ip in the
+// current namespace
+func ifaceWithAddr(ip net.IP) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ifi.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface with address %s", ip)
+}
+
+func (s *sandboxData) isIsolated() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.isolated
+}
+
 func (s *sandboxData) sandbox() sandbox.Sandbox {
 	s.Lock()
 	defer s.Unlock()
@@ -174,19 +426,50 @@ func (s *sandboxData) sandbox() sandbox.Sandbox {
 }
 
 func (c *controller) sandboxAdd(key string, create bool, ep *endpoint) (sandbox.Sandbox, error) {
+	// The reference is taken with the controller lock held, so that a pod
+	// sandbox is not destroyed by its last endpoint leaving meanwhile
 	c.Lock()
 	sData, ok := c.sandboxes[key]
+	if ok {
+		sData.Lock()
+		sData.refCnt++
+		sData.Unlock()
+	}
 	c.Unlock()
 
 	if !ok {
-		sb, err := sandbox.NewSandbox(key, create)
+		var (
+			sb       sandbox.Sandbox
+			err      error
+			external bool
+			pod      bool
+		)
+
+		ep.Lock()
+		if ep.container != nil && ep.container.config.sandboxKey != "" {
+			external = true
+		}
+		if ep.container != nil && ep.container.config.pod != "" {
+			pod = true
+		}
+		ep.Unlock()
+
+		if external {
+			sb, err = sandbox.GetSandboxForExternalKey(key)
+		} else {
+			sb, err = sandbox.NewSandbox(key, create)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create new sandbox: %v", err)
 		}
 
 		sData = &sandboxData{
 			sbox:      sb,
+			refCnt:    1,
 			endpoints: epHeap{},
+			external:  external,
+			pod:       pod,
+			marks:     make(map[*endpoint]uint32),
 		}
 
 		heap.Init(&sData.endpoints)
@@ -195,7 +478,14 @@ func (c *controller) sandboxAdd(key string, create bool, ep *endpoint) (sandbox.
 		c.Unlock()
 	}
 
+	// Joining would give the sandbox external connectivity back
+	if sData.isIsolated() {
+		c.sandboxRelease(key, sData)
+		return nil, types.ForbiddenErrorf("external connectivity of sandbox %s is disabled", key)
+	}
+
 	if err := sData.addEndpoint(ep); err != nil {
+		c.sandboxRelease(key, sData)
 		return nil, err
 	}
 
@@ -208,6 +498,47 @@ func (c *controller) sandboxRm(key string, ep *endpoint) {
 	c.Unlock()
 
 	sData.rmEndpoint(ep)
+	c.sandboxRelease(key, sData)
+	c.saveSandboxState(key)
+}
+
+// sandboxRelease drops a reference to the sandbox. The namespace of a pod
+// is destroyed with its last reference; the ones of the containers are
+// destroyed by LeaveAll. An external sandbox is forgotten with its last
+// reference, its namespace is left to whoever created it.
+func (c *controller) sandboxRelease(key string, sData *sandboxData) {
+	c.Lock()
+	sData.Lock()
+	sData.refCnt--
+	last := (sData.pod || sData.external) && sData.refCnt == 0
+	pod := sData.pod
+	sData.Unlock()
+	if last && c.sandboxes[key] == sData {
+		delete(c.sandboxes, key)
+	}
+	c.Unlock()
+
+	if last && pod {
+		if err := sData.sandbox().Destroy(); err != nil {
+			logrus.Warnf("Failed to destroy the sandbox of pod %s: %v", key, err)
+		}
+	}
+}
+
+func (c *controller) sandboxUpdateAddress(key string, ep *endpoint, addr *net.IPNet) error {
+	c.Lock()
+	sData, ok := c.sandboxes[key]
+	c.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("sandbox %s not found", key)
+	}
+
+	if err := sData.updateEndpointAddress(ep, addr); err != nil {
+		return err
+	}
+	c.saveSandboxState(key)
+	return nil
 }
 
 func (c *controller) sandboxGet(key string) sandbox.Sandbox {
@@ -222,11 +553,66 @@ func (c *controller) sandboxGet(key string) sandbox.Sandbox {
 	return sData.sandbox()
 }
 
-func (c *controller) LeaveAll(id string) error {
+// containerSandbox returns the sandbox of the container and its key. The
+// sandbox of a container is keyed by the id of the container, unless the
+// container joined through an external key, like the namespace path passed
+// by a CNI runtime.
+func (c *controller) containerSandbox(id string) (string, *sandboxData, bool) {
+	key := sandbox.GenerateKey(id)
+
 	c.Lock()
-	sData, ok := c.sandboxes[sandbox.GenerateKey(id)]
-	c.Unlock()
+	defer c.Unlock()
+
+	if sData, ok := c.sandboxes[key]; ok {
+		return key, sData, true
+	}
+	for k, sData := range c.sandboxes {
+		sData.Lock()
+		external := sData.external
+		eps := make([]*endpoint, len(sData.endpoints))
+		copy(eps, sData.endpoints)
+		sData.Unlock()
+		if !external {
+			continue
+		}
+		for _, ep := range eps {
+			if ci := ep.ContainerInfo(); ci != nil && ci.ID() == id {
+				return k, sData, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func (c *controller) SetExternalConnectivity(ctx context.Context, id string, enable bool) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
+	key, sData, ok := c.containerSandbox(id)
 
+	if !ok {
+		return types.NotFoundErrorf("could not find sandbox for container id %s", id)
+	}
+
+	req := AuthzRequest{Operation: AuthzSandboxConnectivity, Params: map[string]string{"container": id, "enable": strconv.FormatBool(enable)}}
+	if err := c.authorize(ctx, req); err != nil {
+		return err
+	}
+
+	if err := sData.setExternalConnectivity(enable); err != nil {
+		return err
+	}
+	c.saveSandboxState(key)
+	return nil
+}
+
+func (c *controller) LeaveAll(ctx context.Context, id string) error {
+	if c.isReadOnly() {
+		return ErrReadOnly{}
+	}
+
+	key, sData, ok := c.containerSandbox(id)
 	if !ok {
 		return fmt.Errorf("could not find sandbox for container id %s", id)
 	}
@@ -239,13 +625,21 @@ func (c *controller) LeaveAll(id string) error {
 	sData.Unlock()
 
 	for _, ep := range eps {
-		if err := ep.Leave(id); err != nil {
+		if err := ep.Leave(ctx, id); err != nil {
 			logrus.Warnf("Failed leaving endpoint id %s: %v\n", ep.ID(), err)
 		}
 	}
 
-	sData.sandbox().Destroy()
-	delete(c.sandboxes, sandbox.GenerateKey(id))
+	// The namespace of an external sandbox is owned by whoever created it
+	if !sData.external {
+		sData.sandbox().Destroy()
+	}
+	c.Lock()
+	if c.sandboxes[key] == sData {
+		delete(c.sandboxes, key)
+	}
+	c.Unlock()
+	c.removeSandboxState(key)
 
 	return nil
 }
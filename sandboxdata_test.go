@@ -1,9 +1,12 @@
 package libnetwork
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"github.com/docker/libnetwork/sandbox"
+	"github.com/docker/libnetwork/types"
 )
 
 func createEmptyCtrlr() *controller {
@@ -28,7 +31,7 @@ func TestSandboxAddEmpty(t *testing.T) {
 
 	ctrlr.sandboxRm(sandbox.GenerateKey("sandbox1"), ep)
 
-	ctrlr.LeaveAll("sandbox1")
+	ctrlr.LeaveAll(context.Background(), "sandbox1")
 	if len(ctrlr.sandboxes) != 0 {
 		t.Fatalf("controller sandboxes is not empty. len = %d", len(ctrlr.sandboxes))
 	}
@@ -88,7 +91,7 @@ func TestSandboxAddMultiPrio(t *testing.T) {
 	ctrlr.sandboxRm(sKey, ep3)
 	ctrlr.sandboxRm(sKey, ep1)
 
-	if err := ctrlr.LeaveAll("sandbox1"); err != nil {
+	if err := ctrlr.LeaveAll(context.Background(), "sandbox1"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -129,7 +132,7 @@ func TestSandboxAddSamePrio(t *testing.T) {
 
 	ctrlr.sandboxRm(sKey, ep2)
 
-	if err := ctrlr.LeaveAll("sandbox1"); err != nil {
+	if err := ctrlr.LeaveAll(context.Background(), "sandbox1"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -139,3 +142,75 @@ func TestSandboxAddSamePrio(t *testing.T) {
 
 	sandbox.GC()
 }
+
+type connDriver struct {
+	localDriver
+	disabled map[types.UUID]bool
+	fail     types.UUID
+}
+
+func (d *connDriver) SetExternalConnectivity(nid, eid types.UUID, enable bool) error {
+	if eid == d.fail {
+		return fmt.Errorf("failed to set external connectivity of %s", eid)
+	}
+	d.disabled[eid] = !enable
+	return nil
+}
+
+func TestSandboxSetExternalConnectivity(t *testing.T) {
+	ctrlr := createEmptyCtrlr()
+	d := &connDriver{disabled: make(map[types.UUID]bool)}
+	n := &network{id: "n1", driver: d}
+
+	sKey := sandbox.GenerateKey("sandbox1")
+	var eps []*endpoint
+	for i := 0; i < 2; i++ {
+		ep := createEmptyEndpoint()
+		ep.id = types.UUID(fmt.Sprintf("ep%d", i))
+		ep.network = n
+		if _, err := ctrlr.sandboxAdd(sKey, true, ep); err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+
+	// A failing endpoint leaves the sandbox untouched
+	d.fail = "ep1"
+	if err := ctrlr.SetExternalConnectivity(context.Background(), "sandbox1", false); err == nil {
+		t.Fatal("Expected failure disabling external connectivity")
+	}
+	if d.disabled["ep0"] || ctrlr.sandboxes[sKey].isIsolated() {
+		t.Fatal("External connectivity was not restored after failure")
+	}
+
+	d.fail = ""
+	if err := ctrlr.SetExternalConnectivity(context.Background(), "sandbox1", false); err != nil {
+		t.Fatal(err)
+	}
+	if !d.disabled["ep0"] || !d.disabled["ep1"] || !ctrlr.sandboxes[sKey].isIsolated() {
+		t.Fatal("External connectivity was not disabled")
+	}
+
+	ep := createEmptyEndpoint()
+	ep.network = n
+	if _, err := ctrlr.sandboxAdd(sKey, true, ep); err == nil {
+		t.Fatal("Expected join to fail while external connectivity is disabled")
+	}
+
+	if err := ctrlr.SetExternalConnectivity(context.Background(), "sandbox1", true); err != nil {
+		t.Fatal(err)
+	}
+	if d.disabled["ep0"] || d.disabled["ep1"] || ctrlr.sandboxes[sKey].isIsolated() {
+		t.Fatal("External connectivity was not enabled")
+	}
+
+	if err := ctrlr.SetExternalConnectivity(context.Background(), "sandbox2", false); err == nil {
+		t.Fatal("Expected failure for unknown sandbox")
+	}
+
+	for _, ep := range eps {
+		ctrlr.sandboxRm(sKey, ep)
+	}
+	ctrlr.LeaveAll(context.Background(), "sandbox1")
+	sandbox.GC()
+}
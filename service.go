@@ -0,0 +1,212 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/types"
+)
+
+func validateServiceName(name string) error {
+	if name == "" || strings.IndexAny(name, " \t\r\n") != -1 {
+		return types.BadRequestErrorf("invalid service name %q", name)
+	}
+	return nil
+}
+
+func validateServiceRecord(name string, ip net.IP) error {
+	if err := validateServiceName(name); err != nil {
+		return err
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return types.BadRequestErrorf("invalid address %v for service %s", ip, name)
+	}
+	return nil
+}
+
+// serviceHostRecords returns the hosts file records of the addresses of the
+// service, which resolves as name and as name.network like the endpoints
+func serviceHostRecords(name, network string, ips []net.IP) []etchosts.Record {
+	var recs []etchosts.Record
+	for _, ip := range ips {
+		recs = append(recs,
+			etchosts.Record{Hosts: name, IP: ip.String()},
+			etchosts.Record{Hosts: name + "." + network, IP: ip.String()})
+	}
+	return recs
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *network) AddServiceRecord(ctx context.Context, name string, ip net.IP) error {
+	if err := validateServiceRecord(name, ip); err != nil {
+		return err
+	}
+	if err := n.authorize(ctx, AuthzNetworkServices, map[string]string{"service": name}); err != nil {
+		return err
+	}
+	return n.addServiceRecord(ctx, name, ip)
+}
+
+// addServiceRecord records the address of the service, keeping it in the
+// store along with the network, and adds it to the hosts files of the
+// containers of the network
+func (n *network) addServiceRecord(ctx context.Context, name string, ip net.IP) error {
+	n.hostsMu.Lock()
+	defer n.hostsMu.Unlock()
+
+	n.Lock()
+	prev := n.services[name]
+	if containsIP(prev, ip) {
+		n.Unlock()
+		return nil
+	}
+	if n.services == nil {
+		n.services = svcMap{}
+	}
+	n.services[name] = append(append([]net.IP{}, prev...), ip)
+	recs := serviceHostRecords(name, n.name, []net.IP{ip})
+	n.Unlock()
+
+	if err := n.ctrlr.updateNetworkToStore(ctx, n); err != nil {
+		n.restoreServiceRecords(name, prev)
+		return fmt.Errorf("failed to store record %s of service %s: %v", ip, name, err)
+	}
+
+	n.updateServiceHosts(recs, nil)
+	return nil
+}
+
+func (n *network) DeleteServiceRecord(ctx context.Context, name string, ip net.IP) error {
+	if err := validateServiceRecord(name, ip); err != nil {
+		return err
+	}
+	if err := n.authorize(ctx, AuthzNetworkServices, map[string]string{"service": name}); err != nil {
+		return err
+	}
+	return n.deleteServiceRecord(ctx, name, ip)
+}
+
+func (n *network) deleteServiceRecord(ctx context.Context, name string, ip net.IP) error {
+	n.hostsMu.Lock()
+	defer n.hostsMu.Unlock()
+
+	n.Lock()
+	prev := n.services[name]
+	var ips []net.IP
+	for _, i := range prev {
+		if !i.Equal(ip) {
+			ips = append(ips, i)
+		}
+	}
+	if len(ips) == len(prev) {
+		n.Unlock()
+		return nil
+	}
+	if len(ips) == 0 {
+		delete(n.services, name)
+	} else {
+		n.services[name] = ips
+	}
+	recs := serviceHostRecords(name, n.name, []net.IP{ip})
+	n.Unlock()
+
+	if err := n.ctrlr.updateNetworkToStore(ctx, n); err != nil {
+		n.restoreServiceRecords(name, prev)
+		return fmt.Errorf("failed to remove record %s of service %s from the store: %v", ip, name, err)
+	}
+
+	n.updateServiceHosts(nil, recs)
+	return nil
+}
+
+// restoreServiceRecords sets the addresses of the service back to the ones
+// it had before a change which could not be stored
+func (n *network) restoreServiceRecords(name string, ips []net.IP) {
+	n.Lock()
+	defer n.Unlock()
+	if len(ips) == 0 {
+		delete(n.services, name)
+		return
+	}
+	if n.services == nil {
+		n.services = svcMap{}
+	}
+	n.services[name] = ips
+}
+
+func (n *network) SyncServiceRecords(ctx context.Context, records map[string][]net.IP) error {
+	services := make(svcMap, len(records))
+	for name, ips := range records {
+		for _, ip := range ips {
+			if err := validateServiceRecord(name, ip); err != nil {
+				return err
+			}
+			if !containsIP(services[name], ip) {
+				services[name] = append(services[name], ip)
+			}
+		}
+	}
+
+	if err := n.authorize(ctx, AuthzNetworkServices, nil); err != nil {
+		return err
+	}
+
+	n.hostsMu.Lock()
+	defer n.hostsMu.Unlock()
+
+	var add, del []etchosts.Record
+	n.Lock()
+	for name, ips := range n.services {
+		for _, ip := range ips {
+			if !containsIP(services[name], ip) {
+				del = append(del, serviceHostRecords(name, n.name, []net.IP{ip})...)
+			}
+		}
+	}
+	for name, ips := range services {
+		for _, ip := range ips {
+			if !containsIP(n.services[name], ip) {
+				add = append(add, serviceHostRecords(name, n.name, []net.IP{ip})...)
+			}
+		}
+	}
+	prev := n.services
+	n.services = services
+	n.Unlock()
+
+	if err := n.ctrlr.updateNetworkToStore(ctx, n); err != nil {
+		n.Lock()
+		n.services = prev
+		n.Unlock()
+		return fmt.Errorf("failed to store the service records: %v", err)
+	}
+
+	n.updateServiceHosts(add, del)
+	return nil
+}
+
+// updateServiceHosts applies the changes of the service records to the
+// hosts files of the containers of the network. It is called with hostsMu
+// held, for the files to be written in the order of the changes.
+func (n *network) updateServiceHosts(add, del []etchosts.Record) {
+	if len(add) == 0 && len(del) == 0 {
+		return
+	}
+	for _, ep := range n.containerEndpoints() {
+		if len(del) != 0 {
+			ep.deleteHostRecords(del)
+		}
+		ep.addHostEntries(add)
+	}
+}
@@ -0,0 +1,189 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+// vipAddressSpace is the address space of the service VIP pool of a network
+const vipAddressSpace = ipam.AddressSpace("vip")
+
+// NetworkOptionServiceVIPPool function returns an option setter for the
+// subnet the virtual IPs of the services of the network are allocated from
+// with AllocateServiceVIP, apart from the addresses of the endpoints
+func NetworkOptionServiceVIPPool(subnet *net.IPNet) NetworkOption {
+	return func(n *network) {
+		n.vipPool = types.GetIPNetCopy(subnet)
+	}
+}
+
+// vipAllocator returns the allocator of the service VIP pool of the
+// network, created along with the pool on first use. It is called with the
+// network lock held.
+func (n *network) vipAllocator(ctx context.Context) (*ipam.Allocator, error) {
+	if n.vipPool == nil {
+		return nil, types.ForbiddenErrorf("network %s has no service VIP pool", n.name)
+	}
+	if n.vipIPAM != nil {
+		return n.vipIPAM, nil
+	}
+
+	// The allocator is rebuilt from the VIPs kept with the network
+	a, err := ipam.NewAllocator(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.AddSubnet(ctx, vipAddressSpace, &ipam.SubnetInfo{Subnet: n.vipPool}); err != nil {
+		return nil, types.BadRequestErrorf("invalid service VIP pool %s: %v", n.vipPool, err)
+	}
+	for name, ip := range n.vips {
+		if err := a.ReserveExternal(ctx, vipAddressSpace, ip, name); err != nil {
+			return nil, fmt.Errorf("failed to restore VIP %s of service %s: %v", ip, name, err)
+		}
+	}
+	n.vipIPAM = a
+	return a, nil
+}
+
+// validateVIPPool fails if the service VIP pool of the network is not a
+// valid subnet
+func (n *network) validateVIPPool(ctx context.Context) error {
+	n.Lock()
+	defer n.Unlock()
+	if n.vipPool == nil {
+		return nil
+	}
+	_, err := n.vipAllocator(ctx)
+	return err
+}
+
+// checkVIPPoolOverlap fails if the service VIP pool of the network overlaps
+// one of the pools the driver reports it allocates the endpoint addresses
+// from
+func (n *network) checkVIPPoolOverlap() error {
+	n.Lock()
+	pool := n.vipPool
+	n.Unlock()
+	if pool == nil {
+		return nil
+	}
+
+	nps, err := n.driverPoolStatus()
+	if err != nil || nps == nil {
+		return err
+	}
+	for _, ps := range nps.Pools {
+		if ps.Subnet != nil && netutils.NetworkOverlaps(pool, ps.Subnet) {
+			return types.BadRequestErrorf("service VIP pool %s overlaps the endpoint pool %s of network %s", pool, ps.Subnet, n.Name())
+		}
+	}
+	return nil
+}
+
+func (n *network) AllocateServiceVIP(ctx context.Context, name string) (net.IP, error) {
+	if err := validateServiceName(name); err != nil {
+		return nil, err
+	}
+	if err := n.authorize(ctx, AuthzNetworkServices, map[string]string{"service": name}); err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	if ip, ok := n.vips[name]; ok {
+		n.Unlock()
+		return types.GetIPCopy(ip), nil
+	}
+	a, err := n.vipAllocator(ctx)
+	if err != nil {
+		n.Unlock()
+		return nil, err
+	}
+	req := &ipam.AddressRequest{Subnet: *n.vipPool, Endpoint: name}
+	var resp *ipam.AddressResponse
+	if n.vipPool.IP.To4() != nil {
+		resp, err = a.Request(ctx, vipAddressSpace, req)
+	} else {
+		resp, err = a.RequestV6(ctx, vipAddressSpace, req)
+	}
+	if err != nil {
+		n.Unlock()
+		return nil, types.ForbiddenErrorf("could not allocate a VIP for service %s from %s: %v", name, n.vipPool, err)
+	}
+	if n.vips == nil {
+		n.vips = map[string]net.IP{}
+	}
+	n.vips[name] = resp.Address
+	n.Unlock()
+
+	if err := n.ctrlr.updateNetworkToStore(ctx, n); err != nil {
+		n.releaseVIP(name)
+		return nil, fmt.Errorf("failed to store VIP %s of service %s: %v", resp.Address, name, err)
+	}
+
+	if err := n.addServiceRecord(ctx, name, resp.Address); err != nil {
+		n.releaseVIP(name)
+		if e := n.ctrlr.updateNetworkToStore(context.Background(), n); e != nil {
+			log.Warnf("Failed to remove VIP %s of service %s from the store: %v", resp.Address, name, e)
+		}
+		return nil, err
+	}
+	return types.GetIPCopy(resp.Address), nil
+}
+
+func (n *network) ReleaseServiceVIP(ctx context.Context, name string) error {
+	if err := n.authorize(ctx, AuthzNetworkServices, map[string]string{"service": name}); err != nil {
+		return err
+	}
+
+	n.Lock()
+	ip, ok := n.vips[name]
+	n.Unlock()
+	if !ok {
+		return types.NotFoundErrorf("service %s has no VIP on network %s", name, n.Name())
+	}
+
+	if err := n.deleteServiceRecord(ctx, name, ip); err != nil {
+		return err
+	}
+	n.releaseVIP(name)
+	if err := n.ctrlr.updateNetworkToStore(context.Background(), n); err != nil {
+		log.Warnf("Failed to remove VIP %s of service %s from the store: %v", ip, name, err)
+	}
+	return nil
+}
+
+func (n *network) releaseVIP(name string) {
+	n.Lock()
+	defer n.Unlock()
+	ip, ok := n.vips[name]
+	if !ok {
+		return
+	}
+	delete(n.vips, name)
+	// The allocator of the VIPs restored with the network is rebuilt
+	// without the released one
+	if n.vipIPAM != nil {
+		n.vipIPAM.Release(context.Background(), vipAddressSpace, ip)
+	}
+}
+
+// vipPoolStatus returns the usage of the service VIP pool of the network,
+// nil if it has none
+func (n *network) vipPoolStatus() (*ipam.PoolStatus, error) {
+	n.Lock()
+	defer n.Unlock()
+	if n.vipPool == nil {
+		return nil, nil
+	}
+	a, err := n.vipAllocator(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return a.PoolStatus(vipAddressSpace, n.vipPool)
+}
@@ -0,0 +1,173 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipam"
+)
+
+// Snapshot is the state of the controller at a point in time, for backups
+// and support bundles. The networks and endpoints are in their stored form.
+type Snapshot struct {
+	Time      time.Time          `json:"time"`
+	Networks  []*NetworkSnapshot `json:"networks"`
+	Sandboxes []*SandboxSnapshot `json:"sandboxes"`
+}
+
+// NetworkSnapshot is the state of a network and of its endpoints
+type NetworkSnapshot struct {
+	Network   json.RawMessage   `json:"network"`
+	Endpoints []json.RawMessage `json:"endpoints"`
+	// Pools is the status of the address pools, when the driver reports it
+	Pools []*ipam.PoolStatus `json:"pools,omitempty"`
+	// ServiceRecords are the addresses the endpoint names resolve to
+	ServiceRecords map[string][]string `json:"serviceRecords,omitempty"`
+	// Services are the addresses the service names resolve to
+	Services map[string][]string `json:"services,omitempty"`
+}
+
+// SandboxSnapshot is the state of a sandbox and the endpoints it joined
+type SandboxSnapshot struct {
+	Key       string   `json:"key"`
+	Endpoints []string `json:"endpoints"`
+	External  bool     `json:"external,omitempty"`
+	Pod       bool     `json:"pod,omitempty"`
+	Isolated  bool     `json:"isolated,omitempty"`
+}
+
+// Snapshot returns the JSON document of the state of the controller. Each
+// network is locked while its state is collected, so that no operation on
+// it is seen half done. The locks are taken one at a time, never nested in
+// the controller lock, for the snapshot not to deadlock with the operations
+// taking them in another order.
+func (c *controller) Snapshot() ([]byte, error) {
+	c.Lock()
+	networks := make([]*network, 0, len(c.networks))
+	for _, n := range c.networks {
+		networks = append(networks, n)
+	}
+	sandboxes := make(sandboxTable, len(c.sandboxes))
+	for key, sData := range c.sandboxes {
+		sandboxes[key] = sData
+	}
+	c.Unlock()
+	sort.Sort(byNetworkID(networks))
+
+	snap := &Snapshot{Time: time.Now().UTC()}
+	for _, n := range networks {
+		n.Lock()
+		ns, err := n.snapshot()
+		n.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		snap.Networks = append(snap.Networks, ns)
+	}
+	snap.Sandboxes = sandboxSnapshots(sandboxes)
+
+	return json.Marshal(snap)
+}
+
+// snapshot returns the state of the network, which must be locked
+func (n *network) snapshot() (*NetworkSnapshot, error) {
+	b, err := n.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	ns := &NetworkSnapshot{Network: b, Endpoints: []json.RawMessage{}}
+
+	endpoints := make([]*endpoint, 0, len(n.endpoints))
+	for _, ep := range n.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	sort.Sort(byEndpointID(endpoints))
+	names := make(map[string]string, len(endpoints))
+	for _, ep := range endpoints {
+		b, err := ep.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		ns.Endpoints = append(ns.Endpoints, b)
+		names[string(ep.id)] = ep.Name()
+	}
+
+	if len(n.svcRecords) != 0 {
+		ns.ServiceRecords = make(map[string][]string, len(n.svcRecords))
+		for name, ips := range n.svcRecords {
+			for _, ip := range ips {
+				ns.ServiceRecords[name] = append(ns.ServiceRecords[name], ip.String())
+			}
+		}
+	}
+
+	if len(n.services) != 0 {
+		ns.Services = make(map[string][]string, len(n.services))
+		for name, ips := range n.services {
+			for _, ip := range ips {
+				ns.Services[name] = append(ns.Services[name], ip.String())
+			}
+		}
+	}
+
+	if reporter, ok := n.driver.(driverapi.PoolStatusReporter); ok && n.materialized {
+		pools, err := reporter.PoolStatus(n.id)
+		if err != nil {
+			log.Warnf("Failed to retrieve the address pool status of network %s: %v", n.name, err)
+		}
+		for _, ps := range pools {
+			for i, cs := range ps.TopConsumers {
+				if name, ok := names[cs.Name]; ok {
+					ps.TopConsumers[i].Name = name
+				}
+			}
+		}
+		ns.Pools = pools
+	}
+
+	return ns, nil
+}
+
+// sandboxSnapshots returns the state of the sandboxes
+func sandboxSnapshots(sandboxes sandboxTable) []*SandboxSnapshot {
+	snaps := []*SandboxSnapshot{}
+	for key, sData := range sandboxes {
+		sData.Lock()
+		ss := &SandboxSnapshot{
+			Key:       key,
+			Endpoints: []string{},
+			External:  sData.external,
+			Pod:       sData.pod,
+			Isolated:  sData.isolated,
+		}
+		for _, ep := range sData.endpoints {
+			ss.Endpoints = append(ss.Endpoints, string(ep.id))
+		}
+		sData.Unlock()
+		sort.Strings(ss.Endpoints)
+		snaps = append(snaps, ss)
+	}
+	sort.Sort(bySandboxKey(snaps))
+	return snaps
+}
+
+type byNetworkID []*network
+
+func (b byNetworkID) Len() int           { return len(b) }
+func (b byNetworkID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNetworkID) Less(i, j int) bool { return b[i].id < b[j].id }
+
+type byEndpointID []*endpoint
+
+func (b byEndpointID) Len() int           { return len(b) }
+func (b byEndpointID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byEndpointID) Less(i, j int) bool { return b[i].id < b[j].id }
+
+type bySandboxKey []*SandboxSnapshot
+
+func (b bySandboxKey) Len() int           { return len(b) }
+func (b bySandboxKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bySandboxKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
@@ -0,0 +1,29 @@
+package libnetwork
+
+import (
+	"os"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// boundSocket is a host socket the driver bound for a port binding of the
+// endpoint, to be passed to the container runtime
+type boundSocket struct {
+	binding types.PortBinding
+	file    *os.File
+}
+
+// closeBoundSockets closes the sockets handed over by the driver, which
+// the receiver holds its own descriptors of once they are passed
+func (ep *endpoint) closeBoundSockets() {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.joinInfo == nil {
+		return
+	}
+	for _, s := range ep.joinInfo.sockets {
+		s.file.Close()
+	}
+	ep.joinInfo.sockets = nil
+}
@@ -0,0 +1,47 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// passBoundSockets sends the sockets handed over by the driver to the
+// receiver listening on the unix socket at path. The receiver gets one
+// message per socket, carrying the JSON encoded port binding and the socket
+// descriptor as SCM_RIGHTS ancillary data.
+func (ep *endpoint) passBoundSockets(path string) error {
+	ep.Lock()
+	sockets := ep.joinInfo.sockets
+	name := ep.name
+	ep.Unlock()
+
+	if len(sockets) == 0 {
+		return nil
+	}
+	if path == "" {
+		log.Warnf("No socket receiver for endpoint %s, the %d host sockets bound for it are not passed", name, len(sockets))
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to the socket receiver %s: %v", path, err)
+	}
+	defer conn.Close()
+
+	for _, s := range sockets {
+		b, err := json.Marshal(s.binding)
+		if err != nil {
+			return err
+		}
+		if _, _, err := conn.WriteMsgUnix(b, syscall.UnixRights(int(s.file.Fd())), nil); err != nil {
+			return fmt.Errorf("failed to pass the socket bound for port %d to %s: %v", s.binding.Port, path, err)
+		}
+	}
+
+	return nil
+}
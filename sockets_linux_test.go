@@ -0,0 +1,114 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestPassBoundSockets(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "libnetwork-sockets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "receiver.sock")
+	l, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	f, err := tl.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binding := types.PortBinding{Proto: types.TCP, Port: 80, HostIP: net.ParseIP("127.0.0.1"), HostPort: uint16(tl.Addr().(*net.TCPAddr).Port), PassSocket: true}
+	ep := &endpoint{name: "ep1", joinInfo: &endpointJoinInfo{}}
+	if err := ep.AddBoundSocket(binding, f); err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ep.passBoundSockets(path)
+	}()
+
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(b, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Failed to pass the sockets: %v", err)
+	}
+	ep.closeBoundSockets()
+
+	var received types.PortBinding
+	if err := json.Unmarshal(b[:n], &received); err != nil {
+		t.Fatal(err)
+	}
+	if !received.Equal(&binding) {
+		t.Fatalf("Unexpected binding passed with the socket: %v", received)
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Unexpected control messages %v: %v", msgs, err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("Unexpected rights %v: %v", fds, err)
+	}
+
+	// The received socket is the bound one
+	rl, err := net.FileListener(os.NewFile(uintptr(fds[0]), "received"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	if rl.Addr().String() != tl.Addr().String() {
+		t.Fatalf("Received socket is bound to %s, expected %s", rl.Addr(), tl.Addr())
+	}
+}
+
+func TestPassBoundSocketsNoReceiver(t *testing.T) {
+	ep := &endpoint{name: "ep1", joinInfo: &endpointJoinInfo{}}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := ep.AddBoundSocket(types.PortBinding{Proto: types.TCP, Port: 80, PassSocket: true}, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.passBoundSockets(""); err != nil {
+		t.Fatalf("Expected the sockets to be dropped without a receiver, got %v", err)
+	}
+	ep.closeBoundSockets()
+
+	if _, err := w.Write([]byte{0}); err == nil {
+		t.Fatalf("Expected the handed over file to be closed")
+	}
+}
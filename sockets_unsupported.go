@@ -0,0 +1,13 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+// passBoundSockets is not supported on this platform
+func (ep *endpoint) passBoundSockets(path string) error {
+	if path == "" {
+		return nil
+	}
+	return types.NotImplementedErrorf("passing sockets to the container runtime is not supported on this platform")
+}
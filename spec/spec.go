@@ -0,0 +1,325 @@
+// Package spec loads declarative network definitions and reconciles the
+// networks of a libnetwork controller toward them.
+//
+// A spec file lists the networks which must exist, together with their
+// driver, driver options and address pools. The file is read as TOML if its
+// name ends in ".toml" and as JSON otherwise:
+//
+//	{
+//		"Networks": [
+//			{
+//				"Name": "backend",
+//				"Driver": "bridge",
+//				"EnableIPv6": false,
+//				"IPAM": {"Subnet": "172.30.0.1/16", "IPRange": "172.30.1.0/24"},
+//				"Options": {"BridgeName": "br-backend", "EnableICC": "false"}
+//			}
+//		]
+//	}
+//
+// Networks are only ever deleted or recreated by the reconciler if they were
+// created by it. It marks the networks it creates with the SpecLabel label,
+// holding their definition, so that it still knows them after a restart.
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// SpecLabel is the network label marking the networks created by a
+// reconciler, its value is the definition they were created from.
+const SpecLabel = netlabel.Prefix + ".spec"
+
+// Spec is the desired set of networks.
+type Spec struct {
+	Networks []Network
+}
+
+// Network describes a network which must exist.
+type Network struct {
+	Name       string
+	Driver     string
+	EnableIPv6 bool
+	IPAM       *IPAM `json:",omitempty"`
+	// Options are passed to the driver as its generic network data
+	Options map[string]string `json:",omitempty"`
+}
+
+// IPAM describes the address pools of a network. Drivers allocate the
+// addresses of their endpoints themselves, so the pools are translated into
+// the driver specific options. Only the bridge driver is supported.
+type IPAM struct {
+	// Subnet is the network address, with the gateway as host part
+	Subnet string `json:",omitempty"`
+	// IPRange restricts the endpoint addresses to a sub range of Subnet
+	IPRange string `json:",omitempty"`
+	// Gateway overrides the default gateway handed to the endpoints
+	Gateway string `json:",omitempty"`
+	// SubnetV6 restricts the endpoint IPv6 addresses to this network
+	SubnetV6 string `json:",omitempty"`
+}
+
+// bridgeIPAMKeys maps the IPAM fields onto the bridge driver options
+var bridgeIPAMKeys = []struct {
+	key  string
+	cidr bool
+	get  func(*IPAM) string
+}{
+	{"AddressIPv4", true, func(i *IPAM) string { return i.Subnet }},
+	{"FixedCIDR", true, func(i *IPAM) string { return i.IPRange }},
+	{"DefaultGatewayIPv4", false, func(i *IPAM) string { return i.Gateway }},
+	{"FixedCIDRv6", true, func(i *IPAM) string { return i.SubnetV6 }},
+}
+
+// Load reads the spec from the passed file.
+func Load(path string) (*Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spec{}
+	if strings.HasSuffix(path, ".toml") {
+		_, err = toml.Decode(string(b), s)
+	} else {
+		err = json.Unmarshal(b, s)
+	}
+	if err != nil {
+		return nil, types.BadRequestErrorf("failed to decode network spec %s: %v", path, err)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Validate checks the networks are named uniquely and fully specified.
+func (s *Spec) Validate() error {
+	names := make(map[string]bool)
+	for i := range s.Networks {
+		n := &s.Networks[i]
+		if !config.IsValidName(n.Name) {
+			return types.BadRequestErrorf("invalid network name %q in spec", n.Name)
+		}
+		if names[n.Name] {
+			return types.BadRequestErrorf("network %s is defined more than once in spec", n.Name)
+		}
+		names[n.Name] = true
+		if n.Driver == "" {
+			return types.BadRequestErrorf("network %s has no driver", n.Name)
+		}
+		if _, err := n.driverOptions(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// driverOptions returns the generic driver data of the network, with the
+// address pools translated into the driver options.
+func (n *Network) driverOptions() (map[string]interface{}, error) {
+	opts := make(map[string]interface{}, len(n.Options))
+	for k, v := range n.Options {
+		opts[k] = v
+	}
+
+	if n.IPAM == nil {
+		return opts, nil
+	}
+
+	if n.Driver != "bridge" {
+		return nil, types.BadRequestErrorf("address pools of network %s are not supported by the %s driver, use its options instead", n.Name, n.Driver)
+	}
+
+	for _, m := range bridgeIPAMKeys {
+		v := m.get(n.IPAM)
+		if v == "" {
+			continue
+		}
+		if m.cidr {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				return nil, types.BadRequestErrorf("invalid address pool %s for network %s: %v", v, n.Name, err)
+			}
+		} else if net.ParseIP(v) == nil {
+			return nil, types.BadRequestErrorf("invalid gateway %s for network %s", v, n.Name)
+		}
+		if _, ok := opts[m.key]; ok {
+			return nil, types.BadRequestErrorf("%s of network %s is set both in the address pools and the options", m.key, n.Name)
+		}
+		opts[m.key] = v
+	}
+
+	return opts, nil
+}
+
+func (n *Network) equal(o *Network) bool {
+	return n.Driver == o.Driver && n.EnableIPv6 == o.EnableIPv6 &&
+		reflect.DeepEqual(n.IPAM, o.IPAM) && reflect.DeepEqual(n.Options, o.Options)
+}
+
+// Reconciler drives the networks of a controller toward a spec.
+type Reconciler struct {
+	c libnetwork.NetworkController
+	// applied holds the definition of the networks created by the
+	// reconciler as they were last applied
+	applied map[string]Network
+	sync.Mutex
+}
+
+// NewReconciler returns a reconciler for the passed controller.
+func NewReconciler(c libnetwork.NetworkController) *Reconciler {
+	return &Reconciler{c: c, applied: make(map[string]Network)}
+}
+
+// Reconcile creates the networks of the spec which do not exist, recreates
+// the managed networks whose definition changed and deletes the managed
+// networks which are no longer in the spec. A network with endpoints is
+// never deleted; the error is reported and the remaining networks are still
+// reconciled. Networks which already exist when first seen in the spec, and
+// were not created by a reconciler, are left as they are.
+func (r *Reconciler) Reconcile(s *Spec) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.loadApplied()
+
+	var errs []string
+	wanted := make(map[string]bool)
+
+	for _, sn := range s.Networks {
+		wanted[sn.Name] = true
+		if err := r.reconcileNetwork(sn); err != nil {
+			log.Warnf("Failed to reconcile network %s: %v", sn.Name, err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for name := range r.applied {
+		if wanted[name] {
+			continue
+		}
+		if err := r.deleteNetwork(name); err != nil {
+			log.Warnf("Failed to remove network %s: %v", name, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		delete(r.applied, name)
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("network spec partially applied: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcileNetwork(sn Network) error {
+	n, err := r.c.NetworkByName(sn.Name)
+	if err != nil {
+		if _, ok := err.(libnetwork.ErrNoSuchNetwork); !ok {
+			return err
+		}
+		return r.createNetwork(sn)
+	}
+
+	prev, managed := r.applied[sn.Name]
+	if !managed {
+		if n.Type() != sn.Driver {
+			return types.ForbiddenErrorf("network %s exists with driver %s instead of %s", sn.Name, n.Type(), sn.Driver)
+		}
+		log.Debugf("Network %s of the spec exists and was not created from it, leaving it as it is", sn.Name)
+		return nil
+	}
+
+	if prev.equal(&sn) {
+		return nil
+	}
+
+	log.Infof("Definition of network %s changed, recreating it", sn.Name)
+	if err := r.deleteNetwork(sn.Name); err != nil {
+		return err
+	}
+	delete(r.applied, sn.Name)
+
+	return r.createNetwork(sn)
+}
+
+func (r *Reconciler) createNetwork(sn Network) error {
+	opts, err := sn.driverOptions()
+	if err != nil {
+		return err
+	}
+
+	definition, err := json.Marshal(sn)
+	if err != nil {
+		return err
+	}
+
+	generic := map[string]interface{}{
+		netlabel.GenericData: opts,
+		netlabel.EnableIPv6:  sn.EnableIPv6,
+	}
+	if _, err := r.c.NewNetwork(context.Background(), sn.Driver, sn.Name, libnetwork.NetworkOptionGeneric(generic),
+		libnetwork.NetworkOptionLabels(map[string]string{SpecLabel: string(definition)})); err != nil {
+		return err
+	}
+
+	log.Infof("Created network %s", sn.Name)
+	r.applied[sn.Name] = sn
+	return nil
+}
+
+// loadApplied picks up the networks created by a reconciler before, as a
+// previous run of the daemon, from their label
+func (r *Reconciler) loadApplied() {
+	for _, n := range r.c.Networks() {
+		if _, ok := r.applied[n.Name()]; ok {
+			continue
+		}
+		definition, ok := n.Labels()[SpecLabel]
+		if !ok {
+			continue
+		}
+		var sn Network
+		if err := json.Unmarshal([]byte(definition), &sn); err != nil {
+			log.Warnf("Ignoring the invalid spec definition of network %s: %v", n.Name(), err)
+			continue
+		}
+		r.applied[n.Name()] = sn
+	}
+}
+
+func (r *Reconciler) deleteNetwork(name string) error {
+	n, err := r.c.NetworkByName(name)
+	if err != nil {
+		if _, ok := err.(libnetwork.ErrNoSuchNetwork); ok {
+			return nil
+		}
+		return err
+	}
+
+	if eps := n.Endpoints(); len(eps) != 0 {
+		return types.ForbiddenErrorf("network %s still has %d endpoints", name, len(eps))
+	}
+
+	return n.Delete(context.Background())
+}
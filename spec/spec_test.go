@@ -0,0 +1,254 @@
+package spec
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+type fakeDriver struct {
+	networks map[types.UUID]map[string]interface{}
+}
+
+func (f *fakeDriver) Config(options map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeDriver) CreateNetwork(ctx context.Context, nid types.UUID, options map[string]interface{}) error {
+	f.networks[nid] = options
+	return nil
+}
+
+func (f *fakeDriver) DeleteNetwork(ctx context.Context, nid types.UUID) error {
+	delete(f.networks, nid)
+	return nil
+}
+
+func (f *fakeDriver) CreateEndpoint(ctx context.Context, nid, eid types.UUID, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeDriver) DeleteEndpoint(ctx context.Context, nid, eid types.UUID) error {
+	return nil
+}
+
+func (f *fakeDriver) EndpointOperInfo(nid, eid types.UUID) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (f *fakeDriver) Join(ctx context.Context, nid, eid types.UUID, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeDriver) Leave(ctx context.Context, nid, eid types.UUID) error {
+	return nil
+}
+
+func (f *fakeDriver) Type() string {
+	return "fake"
+}
+
+func writeSpec(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Load(writeSpec(t, dir, "spec.json", `{"Networks": [{"Name": "net1", "Driver": "bridge",
+		"IPAM": {"Subnet": "172.30.0.1/16"}, "Options": {"BridgeName": "br1"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Networks) != 1 || s.Networks[0].IPAM.Subnet != "172.30.0.1/16" {
+		t.Fatalf("Unexpected spec: %+v", s)
+	}
+
+	s, err = Load(writeSpec(t, dir, "spec.toml", `
+[[Networks]]
+  Name = "net1"
+  Driver = "bridge"
+  [Networks.Options]
+    BridgeName = "br1"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Networks) != 1 || s.Networks[0].Options["BridgeName"] != "br1" {
+		t.Fatalf("Unexpected spec: %+v", s)
+	}
+
+	if _, err := Load(writeSpec(t, dir, "bad.json", `{"Networks": [`)); err == nil {
+		t.Fatal("Expected failure for malformed spec")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []*Spec{
+		{Networks: []Network{{Name: "", Driver: "bridge"}}},
+		{Networks: []Network{{Name: "net1"}}},
+		{Networks: []Network{{Name: "net1", Driver: "bridge"}, {Name: "net1", Driver: "bridge"}}},
+		{Networks: []Network{{Name: "net1", Driver: "overlay", IPAM: &IPAM{Subnet: "10.0.0.0/24"}}}},
+		{Networks: []Network{{Name: "net1", Driver: "bridge", IPAM: &IPAM{Subnet: "10.0.0.0"}}}},
+		{Networks: []Network{{Name: "net1", Driver: "bridge", IPAM: &IPAM{Gateway: "10.0.0.0/24"}}}},
+		{Networks: []Network{{Name: "net1", Driver: "bridge", IPAM: &IPAM{Subnet: "10.0.0.1/24"},
+			Options: map[string]string{"AddressIPv4": "10.0.0.1/24"}}}},
+	}
+	for _, s := range invalid {
+		err := s.Validate()
+		if err == nil {
+			t.Fatalf("Expected failure for %+v", s)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for %+v: %v", s, err)
+		}
+	}
+
+	n := Network{Name: "net1", Driver: "bridge", IPAM: &IPAM{Subnet: "10.0.0.1/24", IPRange: "10.0.0.128/25"}}
+	opts, err := n.driverOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts["AddressIPv4"] != "10.0.0.1/24" || opts["FixedCIDR"] != "10.0.0.128/25" {
+		t.Fatalf("Unexpected driver options: %v", opts)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &fakeDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(driverapi.DriverCallback).RegisterDriver("fake", fd, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A network which exists before any spec is applied is never removed
+	if _, err := c.NewNetwork(context.Background(), "fake", "unmanaged"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReconciler(c)
+	s := &Spec{Networks: []Network{
+		{Name: "net1", Driver: "fake"},
+		{Name: "net2", Driver: "fake", Options: map[string]string{"opt": "1"}},
+	}}
+	if err := r.Reconcile(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(fd.networks) != 3 {
+		t.Fatalf("Expected 3 networks, found %d", len(fd.networks))
+	}
+
+	n2, err := c.NetworkByName("net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reapplying the same spec is a no-op
+	if err := r.Reconcile(s); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.NetworkByName("net2"); n.ID() != n2.ID() {
+		t.Fatal("Unchanged network was recreated")
+	}
+
+	// Changed definitions are recreated and dropped ones removed
+	s = &Spec{Networks: []Network{{Name: "net2", Driver: "fake", Options: map[string]string{"opt": "2"}}}}
+	if err := r.Reconcile(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NetworkByName("net1"); err == nil {
+		t.Fatal("Dropped network net1 was not removed")
+	}
+	n, err := c.NetworkByName("net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() == n2.ID() {
+		t.Fatal("Changed network was not recreated")
+	}
+	if opts := fd.networks[types.UUID(n.ID())]; opts == nil {
+		t.Fatal("Recreated network is unknown to the driver")
+	}
+	if _, err := c.NetworkByName("unmanaged"); err != nil {
+		t.Fatal("Unmanaged network was removed")
+	}
+
+	// Networks with endpoints are left in place
+	if _, err := n.CreateEndpoint(context.Background(), "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reconcile(&Spec{}); err == nil {
+		t.Fatal("Expected failure removing a network with endpoints")
+	}
+	if _, err := c.NetworkByName("net2"); err != nil {
+		t.Fatal("Network with endpoints was removed")
+	}
+}
+
+func TestReconcileOwnership(t *testing.T) {
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &fakeDriver{networks: make(map[types.UUID]map[string]interface{})}
+	if err := c.(driverapi.DriverCallback).RegisterDriver("fake", fd, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A network of the spec which exists before is not taken over
+	if _, err := c.NewNetwork(context.Background(), "fake", "existing"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Spec{Networks: []Network{
+		{Name: "existing", Driver: "fake"},
+		{Name: "net1", Driver: "fake", Options: map[string]string{"opt": "1"}},
+	}}
+	if err := NewReconciler(c).Reconcile(s); err != nil {
+		t.Fatal(err)
+	}
+	n1, err := c.NetworkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n1.Labels()[SpecLabel]; !ok {
+		t.Fatal("Network created from the spec is not marked")
+	}
+
+	// A new reconciler, as after a restart, knows the networks created
+	// from the spec by their mark: unchanged ones are kept, dropped ones
+	// removed, and the other ones never touched
+	r := NewReconciler(c)
+	if err := r.Reconcile(s); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.NetworkByName("net1"); n.ID() != n1.ID() {
+		t.Fatal("Unchanged network was recreated")
+	}
+	if err := r.Reconcile(&Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NetworkByName("net1"); err == nil {
+		t.Fatal("Dropped network net1 was not removed")
+	}
+	if _, err := c.NetworkByName("existing"); err != nil {
+		t.Fatal("Network not created from the spec was removed")
+	}
+}
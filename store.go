@@ -1,8 +1,11 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libkv/store"
@@ -26,6 +29,13 @@ func (c *controller) initDataStore() error {
 	if err != nil {
 		return err
 	}
+	if cfg.Daemon.ReadOnly {
+		store = datastore.ReadOnly(store)
+	} else if cfg.Datastore.Exclusive {
+		if err := c.acquireStoreOwnership(store, cfg.Datastore.Takeover); err != nil {
+			return err
+		}
+	}
 	c.Lock()
 	c.store = store
 	c.Unlock()
@@ -39,11 +49,65 @@ func (c *controller) initDataStore() error {
 	return c.watchNetworks()
 }
 
+// ownershipTimeout is how long the controller waits for the ownership of
+// the store, which a crashed owner may hold until its lock expires
+var ownershipTimeout = datastore.DefaultOwnershipTTL + 5*time.Second
+
+// acquireStoreOwnership locks the store for this controller so that no other
+// daemon writes to it meanwhile, which would corrupt it
+func (c *controller) acquireStoreOwnership(ds datastore.DataStore, takeover bool) error {
+	owner := storeWriter()
+	o, err := datastore.AcquireOwnership(ds, owner, takeover, ownershipTimeout)
+	if err != nil {
+		return err
+	}
+	log.Infof("Datastore owned by %s", owner)
+
+	c.Lock()
+	c.ownership = o
+	c.Unlock()
+
+	go func() {
+		<-o.Lost()
+		c.Lock()
+		released := c.ownership != o
+		c.Unlock()
+		if !released {
+			log.Errorf("Ownership of the datastore lost, another daemon may be writing to it")
+		}
+	}()
+	return nil
+}
+
+// releaseStoreOwnership gives the ownership of the store up, for the next
+// daemon not to wait for its lock to expire
+func (c *controller) releaseStoreOwnership() {
+	c.Lock()
+	o := c.ownership
+	c.ownership = nil
+	c.Unlock()
+
+	if o == nil {
+		return
+	}
+	if err := o.Release(); err != nil {
+		log.Warnf("Failed to release the ownership of the datastore: %v", err)
+		return
+	}
+	log.Infof("Datastore ownership released")
+}
+
+// storeWriter identifies this daemon as the writer of the store objects
+func storeWriter() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
 func (c *controller) getNetworksFromStore() ([]*store.KVPair, error) {
 	c.Lock()
 	cs := c.store
 	c.Unlock()
-	return cs.KVStore().List(datastore.Key(datastore.NetworkKeyPrefix))
+	return cs.KVStore().List(datastore.NewKeyPath(datastore.NetworkKeyPrefix).String())
 }
 
 func (c *controller) newNetworkFromStore(n *network) error {
@@ -52,10 +116,10 @@ func (c *controller) newNetworkFromStore(n *network) error {
 	n.endpoints = endpointTable{}
 	n.Unlock()
 
-	return c.addNetwork(n)
+	return c.addNetwork(context.Background(), n)
 }
 
-func (c *controller) updateNetworkToStore(n *network) error {
+func (c *controller) updateNetworkToStore(ctx context.Context, n *network) error {
 	global, err := n.isGlobalScoped()
 	if err != nil || !global {
 		return err
@@ -68,10 +132,10 @@ func (c *controller) updateNetworkToStore(n *network) error {
 		return nil
 	}
 
-	return cs.PutObjectAtomic(n)
+	return datastore.WithContext(ctx, cs).PutObjectAtomic(n)
 }
 
-func (c *controller) deleteNetworkFromStore(n *network) error {
+func (c *controller) deleteNetworkFromStore(ctx context.Context, n *network) error {
 	global, err := n.isGlobalScoped()
 	if err != nil || !global {
 		return err
@@ -84,7 +148,7 @@ func (c *controller) deleteNetworkFromStore(n *network) error {
 		return nil
 	}
 
-	if err := cs.DeleteObjectAtomic(n); err != nil {
+	if err := datastore.WithContext(ctx, cs).DeleteObjectAtomic(n); err != nil {
 		return err
 	}
 
@@ -93,7 +157,7 @@ func (c *controller) deleteNetworkFromStore(n *network) error {
 
 func (c *controller) getNetworkFromStore(nid types.UUID) (*network, error) {
 	n := network{id: nid}
-	if err := c.store.GetObject(datastore.Key(n.Key()...), &n); err != nil {
+	if err := c.store.GetObject(n.Key().String(), &n); err != nil {
 		return nil, err
 	}
 	return &n, nil
@@ -108,18 +172,30 @@ func (c *controller) newEndpointFromStore(key string, ep *endpoint) error {
 	_, err := n.EndpointByID(string(id))
 	if err != nil {
 		if _, ok := err.(ErrNoSuchEndpoint); ok {
-			return n.addEndpoint(ep)
+			// The driver adopts the external endpoints by itself
+			if ep.isExternal() {
+				n.Lock()
+				n.endpoints[id] = ep
+				n.Unlock()
+				return nil
+			}
+			if err := n.addEndpoint(context.Background(), ep); err != nil {
+				return err
+			}
+			// The endpoint may be joined to a sandbox restored on start
+			c.attachRestoredEndpoint(ep)
+			return nil
 		}
 	}
 	return err
 }
 
-func (c *controller) updateEndpointToStore(ep *endpoint) error {
+func (c *controller) updateEndpointToStore(ctx context.Context, ep *endpoint) error {
 	ep.Lock()
 	n := ep.network
 	name := ep.name
 	ep.Unlock()
-	global, err := n.isGlobalScoped()
+	global, err := n.isEndpointGlobalScoped()
 	if err != nil || !global {
 		return err
 	}
@@ -131,22 +207,37 @@ func (c *controller) updateEndpointToStore(ep *endpoint) error {
 		return nil
 	}
 
-	return cs.PutObjectAtomic(ep)
+	ep.Lock()
+	generation, writer := ep.generation, ep.writer
+	ep.generation++
+	ep.writer = storeWriter()
+	ep.Unlock()
+
+	if err := datastore.WithContext(ctx, cs).PutObjectAtomic(ep); err != nil {
+		// The generation is only taken once written
+		ep.Lock()
+		if ep.generation == generation+1 {
+			ep.generation, ep.writer = generation, writer
+		}
+		ep.Unlock()
+		return err
+	}
+	return nil
 }
 
 func (c *controller) getEndpointFromStore(eid types.UUID) (*endpoint, error) {
 	ep := endpoint{id: eid}
-	if err := c.store.GetObject(datastore.Key(ep.Key()...), &ep); err != nil {
+	if err := c.store.GetObject(ep.Key().String(), &ep); err != nil {
 		return nil, err
 	}
 	return &ep, nil
 }
 
-func (c *controller) deleteEndpointFromStore(ep *endpoint) error {
+func (c *controller) deleteEndpointFromStore(ctx context.Context, ep *endpoint) error {
 	ep.Lock()
 	n := ep.network
 	ep.Unlock()
-	global, err := n.isGlobalScoped()
+	global, err := n.isEndpointGlobalScoped()
 	if err != nil || !global {
 		return err
 	}
@@ -159,7 +250,7 @@ func (c *controller) deleteEndpointFromStore(ep *endpoint) error {
 		return nil
 	}
 
-	if err := cs.DeleteObjectAtomic(ep); err != nil {
+	if err := datastore.WithContext(ctx, cs).DeleteObjectAtomic(ep); err != nil {
 		return err
 	}
 
@@ -175,7 +266,7 @@ func (c *controller) watchNetworks() error {
 	cs := c.store
 	c.Unlock()
 
-	nwPairs, err := cs.KVStore().WatchTree(datastore.Key(datastore.NetworkKeyPrefix), nil)
+	nwPairs, err := cs.KVStore().WatchTree(datastore.NewKeyPath(datastore.NetworkKeyPrefix).String(), nil)
 	if err != nil {
 		return err
 	}
@@ -204,10 +295,10 @@ func (c *controller) watchNetworks() error {
 						continue
 					}
 					tmp := network{}
-					if err := c.store.GetObject(datastore.Key(existing.Key()...), &tmp); err != datastore.ErrKeyNotFound {
+					if err := c.store.GetObject(existing.Key().String(), &tmp); err != datastore.ErrKeyNotFound {
 						continue
 					}
-					if err := existing.deleteNetwork(); err != nil {
+					if err := existing.deleteNetwork(context.Background()); err != nil {
 						log.Debugf("Delete failed %s: %s", existing.name, err)
 					}
 				}
@@ -229,7 +320,7 @@ func (n *network) watchEndpoints() error {
 	stopCh := n.stopWatchCh
 	n.Unlock()
 
-	epPairs, err := cs.KVStore().WatchTree(datastore.Key(tmp.KeyPrefix()...), stopCh)
+	epPairs, err := cs.KVStore().WatchTree(tmp.KeyPrefix().String(), stopCh)
 	if err != nil {
 		return err
 	}
@@ -244,7 +335,7 @@ func (n *network) watchEndpoints() error {
 				lview := n.endpoints
 				n.Unlock()
 				for k, v := range lview {
-					global, _ := v.network.isGlobalScoped()
+					global, _ := v.network.isEndpointGlobalScoped()
 					if global {
 						tmpview[k] = v
 					}
@@ -275,10 +366,10 @@ func (n *network) watchEndpoints() error {
 						continue
 					}
 					tmp := endpoint{}
-					if err := cs.GetObject(datastore.Key(existing.Key()...), &tmp); err != datastore.ErrKeyNotFound {
+					if err := cs.GetObject(existing.Key().String(), &tmp); err != datastore.ErrKeyNotFound {
 						continue
 					}
-					if err := existing.deleteEndpoint(); err != nil {
+					if err := existing.deleteEndpoint(context.Background()); err != nil {
 						log.Debugf("Delete failed %s: %s", existing.name, err)
 					}
 				}
@@ -352,8 +443,17 @@ func (c *controller) processEndpointUpdate(ep *endpoint) bool {
 	}
 
 	ee := existing.(*endpoint)
+	var registered, update bool
 	ee.Lock()
 	if ee.dbIndex != ep.Index() {
+		if !ee.followsGeneration(ep) {
+			log.Warnf("Endpoint %s loaded from the store at generation %d, written by %q, after generation %d written by %q: another daemon may be writing to the datastore",
+				ee.name, ep.generation, ep.writer, ee.generation, ee.writer)
+		}
+		if ep.generation > ee.generation {
+			ee.generation, ee.writer = ep.generation, ep.writer
+		}
+		wasRegistered := ee.registeredLocked()
 		// Can't use SetIndex() because ee is locked.
 		ee.dbIndex = ep.Index()
 		ee.dbExists = true
@@ -364,8 +464,34 @@ func (c *controller) processEndpointUpdate(ep *endpoint) bool {
 			// we still care only about the container id, but this is a short-cut to communicate join or leave operation
 			ee.container = ep.container
 		}
+		// The endpoints registered on join or activation follow the join,
+		// leave and activation done on the other hosts
+		ee.serviceActive = ep.serviceActive
+		registered = ee.registeredLocked()
+		switch ee.registrationLocked() {
+		case RegisterOnJoin, RegisterOnActivate:
+			update = registered != wasRegistered
+		}
 	}
 	ee.Unlock()
 
+	if update {
+		n.updateSvcRecord(ee, registered)
+	}
+
+	return false
+}
+
+// followsGeneration tells whether the endpoint loaded from the store, at a
+// different index, is a later write than the one known by ep, locked. Its
+// generation cannot go back, nor stay the same with a different writer,
+// unless two daemons write the endpoint.
+func (ep *endpoint) followsGeneration(loaded *endpoint) bool {
+	switch {
+	case loaded.generation > ep.generation:
+		return true
+	case loaded.generation == ep.generation:
+		return loaded.writer == ep.writer
+	}
 	return false
 }
@@ -0,0 +1,360 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// StoreInconsistency describes a dangling or conflicting reference found
+// while verifying the networking state.
+type StoreInconsistency struct {
+	// Key of the offending record, or the sandbox key for sandbox references
+	Key string
+	// Description of the inconsistency
+	Description string
+	// Repaired is set if the inconsistency was fixed during the verification
+	Repaired bool
+}
+
+func (si StoreInconsistency) String() string {
+	if si.Repaired {
+		return fmt.Sprintf("%s: %s (repaired)", si.Key, si.Description)
+	}
+	return fmt.Sprintf("%s: %s", si.Key, si.Description)
+}
+
+// storeChecker accumulates the state read from the datastore during a
+// verification pass.
+type storeChecker struct {
+	c        *controller
+	cs       datastore.DataStore
+	repair   bool
+	networks map[types.UUID]*network
+	netPairs map[types.UUID]*store.KVPair
+	epCnt    map[types.UUID]uint64
+	epAddrs  map[types.UUID]map[string]types.UUID
+	found    []StoreInconsistency
+}
+
+func (sc *storeChecker) add(key string, repaired bool, format string, args ...interface{}) {
+	si := StoreInconsistency{Key: key, Description: fmt.Sprintf(format, args...), Repaired: repaired}
+	log.Warnf("datastore inconsistency: %s", si)
+	sc.found = append(sc.found, si)
+}
+
+// VerifyStore cross-checks the networks, the endpoints and the addresses
+// assigned to them in the datastore together with the sandbox references
+// and the driver address allocations of the networks of this controller.
+// Dangling endpoints (whose network is gone), stale network endpoint counts,
+// sandbox references to deleted endpoints and addresses allocated to no
+// endpoint are repaired if repair is set. Endpoints of the same network
+// holding the same address are only reported, as there is no way to tell
+// which one owns the address, as are the endpoint addresses the driver has
+// no allocation for. The repair is meant to run while no endpoint is being
+// created, as the address of an endpoint is allocated before it is known.
+func (c *controller) VerifyStore(ctx context.Context, repair bool) ([]StoreInconsistency, error) {
+	if repair && c.isReadOnly() {
+		return nil, ErrReadOnly{}
+	}
+	// Only the repair changes the state
+	if repair {
+		if err := c.authorize(ctx, AuthzRequest{Operation: AuthzStoreRepair}); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	sc := &storeChecker{
+		c:        c,
+		cs:       cs,
+		repair:   repair,
+		networks: make(map[types.UUID]*network),
+		netPairs: make(map[types.UUID]*store.KVPair),
+		epCnt:    make(map[types.UUID]uint64),
+		epAddrs:  make(map[types.UUID]map[string]types.UUID),
+	}
+
+	if cs != nil {
+		if err := sc.readNetworks(); err != nil {
+			return nil, err
+		}
+		if err := sc.checkEndpoints(); err != nil {
+			return nil, err
+		}
+		sc.checkEndpointCounts()
+	}
+
+	sc.checkSandboxes()
+	sc.checkAddresses()
+
+	return sc.found, nil
+}
+
+func (sc *storeChecker) list(prefix string) ([]*store.KVPair, error) {
+	kvs, err := sc.cs.KVStore().List(prefix)
+	if err == datastore.ErrKeyNotFound {
+		return nil, nil
+	}
+	return kvs, err
+}
+
+func (sc *storeChecker) readNetworks() error {
+	kvs, err := sc.list(datastore.NewKeyPath(datastore.NetworkKeyPrefix).String())
+	if err != nil {
+		return fmt.Errorf("failed to list networks from datastore: %v", err)
+	}
+
+	for _, kve := range kvs {
+		n := &network{}
+		if err := json.Unmarshal(kve.Value, n); err != nil {
+			sc.add(kve.Key, false, "undecodable network record: %v", err)
+			continue
+		}
+		n.SetIndex(kve.LastIndex)
+		sc.networks[n.id] = n
+		sc.netPairs[n.id] = kve
+	}
+
+	return nil
+}
+
+// endpointPairs returns all the endpoint records. Depending on the
+// store backend, listing the endpoint prefix returns either the records
+// or the per network directories holding them.
+func (sc *storeChecker) endpointPairs() ([]*store.KVPair, error) {
+	kvs, err := sc.list(datastore.NewKeyPath(datastore.EndpointKeyPrefix).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints from datastore: %v", err)
+	}
+
+	var eps []*store.KVPair
+	for _, kve := range kvs {
+		key, err := datastore.ParseKeyPath(kve.Key)
+		if err != nil || len(key) != 2 {
+			eps = append(eps, kve)
+			continue
+		}
+		children, err := sc.list(key.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list endpoints of network %s from datastore: %v", key[1], err)
+		}
+		eps = append(eps, children...)
+	}
+
+	return eps, nil
+}
+
+func (sc *storeChecker) checkEndpoints() error {
+	kvs, err := sc.endpointPairs()
+	if err != nil {
+		return err
+	}
+
+	for _, kve := range kvs {
+		key, err := datastore.ParseKeyPath(kve.Key)
+		if err != nil {
+			sc.add(kve.Key, false, "invalid endpoint key")
+			continue
+		}
+		nid, err := (&endpoint{}).networkIDFromKey(key)
+		if err != nil {
+			sc.add(kve.Key, false, "%v", err)
+			continue
+		}
+
+		ep := &endpoint{}
+		if err := json.Unmarshal(kve.Value, ep); err != nil {
+			sc.add(kve.Key, false, "undecodable endpoint record: %v", err)
+			continue
+		}
+
+		if _, ok := sc.networks[nid]; !ok {
+			sc.add(kve.Key, sc.repair && sc.deletePair(kve), "endpoint %s refers to missing network %s", ep.name, nid)
+			continue
+		}
+		// The external endpoints are not counted in their network
+		if ep.external {
+			continue
+		}
+		sc.epCnt[nid]++
+
+		addrs, ok := sc.epAddrs[nid]
+		if !ok {
+			addrs = make(map[string]types.UUID)
+			sc.epAddrs[nid] = addrs
+		}
+		for _, iface := range ep.iFaces {
+			for _, ip := range []net.IP{iface.addr.IP, iface.addrv6.IP} {
+				if len(ip) == 0 {
+					continue
+				}
+				if owner, ok := addrs[ip.String()]; ok {
+					sc.add(kve.Key, false, "endpoint %s address %s is also assigned to endpoint %s", ep.id, ip, owner)
+					continue
+				}
+				addrs[ip.String()] = ep.id
+			}
+		}
+	}
+
+	return nil
+}
+
+func (sc *storeChecker) deletePair(kve *store.KVPair) bool {
+	if _, err := sc.cs.KVStore().AtomicDelete(kve.Key, kve); err != nil {
+		log.Warnf("failed to delete %s from datastore: %v", kve.Key, err)
+		return false
+	}
+	return true
+}
+
+func (sc *storeChecker) checkEndpointCounts() {
+	for nid, n := range sc.networks {
+		cnt := sc.epCnt[nid]
+		if n.endpointCnt == cnt {
+			continue
+		}
+
+		stored := n.endpointCnt
+		repaired := false
+		if sc.repair {
+			n.endpointCnt = cnt
+			if err := sc.cs.PutObjectAtomic(n); err != nil {
+				log.Warnf("failed to update endpoint count of network %s: %v", n.name, err)
+			} else {
+				repaired = true
+			}
+		}
+		sc.add(sc.netPairs[nid].Key, repaired, "network %s records %d endpoints, %d found", n.name, stored, cnt)
+	}
+}
+
+func (sc *storeChecker) checkSandboxes() {
+	type sboxRef struct {
+		key string
+		ep  *endpoint
+	}
+
+	var stale []sboxRef
+
+	// The network locks are not nested in the controller lock, which the
+	// network deletion takes with the network locked
+	sc.c.Lock()
+	sandboxes := make(sandboxTable, len(sc.c.sandboxes))
+	for key, sData := range sc.c.sandboxes {
+		sandboxes[key] = sData
+	}
+	sc.c.Unlock()
+
+	for key, sData := range sandboxes {
+		sData.Lock()
+		for _, ep := range sData.endpoints {
+			ep.Lock()
+			n := ep.network
+			ep.Unlock()
+			if n == nil {
+				stale = append(stale, sboxRef{key, ep})
+				continue
+			}
+			n.Lock()
+			_, ok := n.endpoints[ep.id]
+			n.Unlock()
+			if !ok {
+				stale = append(stale, sboxRef{key, ep})
+			}
+		}
+		sData.Unlock()
+	}
+
+	for _, r := range stale {
+		if sc.repair {
+			sc.c.sandboxRm(r.key, r.ep)
+		}
+		sc.add(r.key, sc.repair, "sandbox references deleted endpoint %s (%s)", r.ep.name, r.ep.id)
+	}
+}
+
+// checkAddresses cross-checks the addresses the drivers allocated on the
+// networks with the ones their endpoints hold. Leaked addresses are given
+// back to the driver.
+func (sc *storeChecker) checkAddresses() {
+	sc.c.Lock()
+	networks := make([]*network, 0, len(sc.c.networks))
+	for _, n := range sc.c.networks {
+		networks = append(networks, n)
+	}
+	sc.c.Unlock()
+
+	for _, n := range networks {
+		n.Lock()
+		auditor, ok := n.driver.(driverapi.AddressAuditor)
+		materialized := n.materialized
+		eps := make([]*endpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+		if !ok || !materialized {
+			continue
+		}
+
+		allocated, err := auditor.AllocatedAddresses(n.id)
+		if err != nil {
+			log.Warnf("failed to retrieve the addresses allocated on network %s: %v", n.name, err)
+			continue
+		}
+
+		held := make(map[string]types.UUID)
+		for _, ep := range eps {
+			// The driver does not allocate the external endpoint addresses
+			if ep.isExternal() {
+				continue
+			}
+			key := ep.Key().String()
+			ep.Lock()
+			for _, iface := range ep.iFaces {
+				addrs := append([]*net.IPNet{&iface.addr, &iface.addrv6}, iface.secondary...)
+				for _, addr := range addrs {
+					if len(addr.IP) == 0 {
+						continue
+					}
+					held[addr.IP.String()] = ep.id
+					if _, ok := allocated[addr.IP.String()]; !ok && !iface.driverAssigned {
+						sc.add(key, false, "endpoint %s address %s is not allocated by the driver", ep.name, addr.IP)
+					}
+				}
+			}
+			ep.Unlock()
+		}
+
+		for ip, owner := range allocated {
+			if _, ok := held[ip]; ok {
+				continue
+			}
+			if owner != "" {
+				sc.add(n.Key().String(), false, "address %s of network %s is allocated to endpoint %s, which the controller does not know", ip, n.name, owner)
+				continue
+			}
+			repaired := false
+			if sc.repair {
+				if err := auditor.ReleaseAddress(n.id, net.ParseIP(ip)); err != nil {
+					log.Warnf("failed to release address %s of network %s: %v", ip, n.name, err)
+				} else {
+					repaired = true
+				}
+			}
+			sc.add(n.Key().String(), repaired, "address %s of network %s is allocated to no endpoint", ip, n.name)
+		}
+	}
+}
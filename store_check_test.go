@@ -0,0 +1,164 @@
+package libnetwork
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestVerifyStore(t *testing.T) {
+	ms := datastore.NewMockStore()
+	cs := datastore.NewCustomDataStore(ms)
+	c := &controller{store: cs, networks: networkTable{}, sandboxes: sandboxTable{}}
+
+	n := &network{name: "net1", id: "n1", networkType: "bridge", endpointCnt: 3}
+	if err := cs.PutObjectAtomic(n); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := net.IPNet{IP: net.ParseIP("172.20.0.2"), Mask: net.CIDRMask(16, 32)}
+	eps := []*endpoint{
+		{name: "ep1", id: "e1", network: n, iFaces: []*endpointInterface{{addr: addr}}},
+		{name: "ep2", id: "e2", network: n, iFaces: []*endpointInterface{{addr: addr}}},
+		{name: "ep3", id: "e3", network: &network{id: "gone"}},
+	}
+	for _, ep := range eps {
+		if err := cs.PutObjectAtomic(ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := c.VerifyStore(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Orphan endpoint, duplicate address and endpoint count
+	if len(found) != 3 {
+		t.Fatalf("Expected 3 inconsistencies, found %d: %v", len(found), found)
+	}
+	for _, si := range found {
+		if si.Repaired {
+			t.Fatalf("Unexpected repair without request: %v", si)
+		}
+	}
+
+	found, err = c.VerifyStore(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repaired := 0
+	for _, si := range found {
+		if si.Repaired {
+			repaired++
+		}
+	}
+	if repaired != 2 {
+		t.Fatalf("Expected 2 repaired inconsistencies: %v", found)
+	}
+
+	if ok, _ := ms.Exists(eps[2].Key().String()); ok {
+		t.Fatal("Orphan endpoint was not removed from the store")
+	}
+
+	tmp := &network{id: types.UUID("n1")}
+	if err := cs.GetObject(tmp.Key().String(), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if tmp.endpointCnt != 2 {
+		t.Fatalf("Expected endpoint count 2 after repair, got %d", tmp.endpointCnt)
+	}
+
+	// Only the duplicate address is left
+	found, err = c.VerifyStore(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Repaired {
+		t.Fatalf("Unexpected inconsistencies after repair: %v", found)
+	}
+}
+
+// auditDriver reports the addresses it allocated on its networks
+type auditDriver struct {
+	localDriver
+	allocated map[string]types.UUID
+	released  []string
+}
+
+func (d *auditDriver) AllocatedAddresses(nid types.UUID) (map[string]types.UUID, error) {
+	addrs := make(map[string]types.UUID, len(d.allocated))
+	for ip, eid := range d.allocated {
+		addrs[ip] = eid
+	}
+	return addrs, nil
+}
+
+func (d *auditDriver) ReleaseAddress(nid types.UUID, ip net.IP) error {
+	delete(d.allocated, ip.String())
+	d.released = append(d.released, ip.String())
+	return nil
+}
+
+var _ driverapi.AddressAuditor = &auditDriver{}
+
+func TestVerifyStoreAddresses(t *testing.T) {
+	d := &auditDriver{
+		localDriver: localDriver{networks: map[types.UUID]map[string]interface{}{}},
+		allocated: map[string]types.UUID{
+			"172.20.0.2": "e1",
+			"172.20.0.3": "",
+			"172.20.0.4": "e9",
+		},
+	}
+	n := &network{name: "net1", id: "n1", networkType: "audit", driver: d, materialized: true, endpoints: endpointTable{}}
+	mask := net.CIDRMask(16, 32)
+	eps := []*endpoint{
+		{name: "ep1", id: "e1", network: n, iFaces: []*endpointInterface{{addr: net.IPNet{IP: net.ParseIP("172.20.0.2"), Mask: mask}}}},
+		{name: "ep2", id: "e2", network: n, iFaces: []*endpointInterface{{addr: net.IPNet{IP: net.ParseIP("172.20.0.5"), Mask: mask}}}},
+	}
+	for _, ep := range eps {
+		n.endpoints[ep.id] = ep
+	}
+	c := &controller{networks: networkTable{n.id: n}, sandboxes: sandboxTable{}}
+	n.ctrlr = c
+
+	found, err := c.VerifyStore(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Address allocated to no endpoint, address allocated to an unknown
+	// endpoint and endpoint address not allocated
+	if len(found) != 3 {
+		t.Fatalf("Expected 3 inconsistencies, found %d: %v", len(found), found)
+	}
+	if len(d.released) != 0 {
+		t.Fatalf("Unexpected release without repair: %v", d.released)
+	}
+
+	found, err = c.VerifyStore(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repaired := 0
+	for _, si := range found {
+		if si.Repaired {
+			repaired++
+		}
+	}
+	if repaired != 1 || len(d.released) != 1 || d.released[0] != "172.20.0.3" {
+		t.Fatalf("Expected the leaked address to be released: %v, %v", found, d.released)
+	}
+
+	found, err = c.VerifyStore(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Unexpected inconsistencies after repair: %v", found)
+	}
+}
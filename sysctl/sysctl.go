@@ -0,0 +1,180 @@
+// Package sysctl sets the kernel parameters the drivers need, like the IP
+// forwarding, recording the values they had so that they can be restored,
+// and sets them again when they are reverted behind the back of libnetwork,
+// by a reload of the sysctl configuration for instance.
+package sysctl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The parameters set by the drivers, relative to the /proc/sys root
+const (
+	IPv4Forward           = "net/ipv4/ip_forward"
+	IPv6ForwardAll        = "net/ipv6/conf/all/forwarding"
+	IPv6ForwardDefault    = "net/ipv6/conf/default/forwarding"
+	BridgeNFCallIPTables  = "net/bridge/bridge-nf-call-iptables"
+	BridgeNFCallIP6Tables = "net/bridge/bridge-nf-call-ip6tables"
+)
+
+const procSys = "/proc/sys"
+
+// Manager sets kernel parameters and keeps them set
+type Manager struct {
+	root string
+	// params are the parameters set, in the order they were first set
+	params []*param
+	sync.Mutex
+}
+
+type param struct {
+	name     string
+	value    string
+	original string
+}
+
+// New returns a manager of the parameters of the tree at root
+func New(root string) *Manager {
+	return &Manager{root: root}
+}
+
+var defaultManager = New(procSys)
+
+// Default returns the manager of the host parameters the drivers set
+func Default() *Manager {
+	return defaultManager
+}
+
+func (m *Manager) path(name string) string {
+	// The dots of a name with slashes belong to it, as in the names of
+	// the VLAN devices
+	if !strings.Contains(name, "/") {
+		name = strings.Replace(name, ".", "/", -1)
+	}
+	return filepath.Join(m.root, filepath.FromSlash(name))
+}
+
+func (m *Manager) read(name string) (string, error) {
+	b, err := ioutil.ReadFile(m.path(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (m *Manager) write(name, value string) error {
+	return ioutil.WriteFile(m.path(name), []byte(value+"\n"), 0644)
+}
+
+func (m *Manager) lookup(name string) *param {
+	for _, p := range m.params {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Get returns the current value of the parameter, named with slashes like
+// "net/ipv4/ip_forward" or with dots like "net.ipv4.ip_forward"
+func (m *Manager) Get(name string) (string, error) {
+	return m.read(name)
+}
+
+// Set sets the parameter to value, recording its value the first time it is
+// set for Restore
+func (m *Manager) Set(name, value string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	cur, err := m.read(name)
+	if err != nil {
+		return err
+	}
+	if cur != value {
+		if err := m.write(name, value); err != nil {
+			return err
+		}
+	}
+
+	if p := m.lookup(name); p != nil {
+		p.value = value
+		return nil
+	}
+	m.params = append(m.params, &param{name: name, value: value, original: cur})
+	return nil
+}
+
+// Check sets again the parameters whose value was changed since they were
+// set, returning their names
+func (m *Manager) Check() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	var reverted []string
+	for _, p := range m.params {
+		cur, err := m.read(p.name)
+		if err != nil {
+			logrus.Debugf("Failed to read %s: %v", p.name, err)
+			continue
+		}
+		if cur == p.value {
+			continue
+		}
+		logrus.Warnf("%s was changed to %s, setting it back to %s", p.name, cur, p.value)
+		if err := m.write(p.name, p.value); err != nil {
+			logrus.Warnf("Failed to set %s to %s: %v", p.name, p.value, err)
+			continue
+		}
+		reverted = append(reverted, p.name)
+	}
+	return reverted
+}
+
+// Watch checks the parameters every interval until the returned function
+// is called
+func (m *Manager) Watch(interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stopCh) }) }
+}
+
+// Restore sets the parameters back to the values they had before they were
+// first set, in the reverse order, and forgets them. It goes on past the
+// failures and returns the first one.
+func (m *Manager) Restore() error {
+	m.Lock()
+	defer m.Unlock()
+
+	var first error
+	for i := len(m.params) - 1; i >= 0; i-- {
+		p := m.params[i]
+		if err := m.write(p.name, p.original); err != nil {
+			logrus.Warnf("Failed to restore %s to %s: %v", p.name, p.original, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	m.params = nil
+	return first
+}
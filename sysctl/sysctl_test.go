@@ -0,0 +1,109 @@
+package sysctl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T) (*Manager, string) {
+	root, err := ioutil.TempDir("", "sysctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{IPv4Forward: "0\n", BridgeNFCallIPTables: "0\n"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(root), root
+}
+
+func checkValue(t *testing.T, m *Manager, name, expected string) {
+	v, err := m.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != expected {
+		t.Fatalf("Expected %s to be %s, got %s", name, expected, v)
+	}
+}
+
+func TestSetRestore(t *testing.T) {
+	m, root := newTestManager(t)
+	defer os.RemoveAll(root)
+
+	if err := m.Set(IPv4Forward, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("net.bridge.bridge-nf-call-iptables", "1"); err != nil {
+		t.Fatal(err)
+	}
+	// The original value is the one before the first set
+	if err := m.Set(IPv4Forward, "2"); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, m, IPv4Forward, "2")
+	checkValue(t, m, BridgeNFCallIPTables, "1")
+
+	if err := m.Set(BridgeNFCallIP6Tables, "1"); err == nil {
+		t.Fatal("Expected the missing parameter to fail")
+	}
+
+	if err := m.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, m, IPv4Forward, "0")
+	checkValue(t, m, BridgeNFCallIPTables, "0")
+
+	// The restored parameters are forgotten
+	if err := ioutil.WriteFile(filepath.Join(root, IPv4Forward), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, m, IPv4Forward, "1")
+}
+
+func TestCheckWatch(t *testing.T) {
+	m, root := newTestManager(t)
+	defer os.RemoveAll(root)
+
+	if err := m.Set(IPv4Forward, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Check(); len(r) != 0 {
+		t.Fatalf("Unexpected reverted parameters: %v", r)
+	}
+
+	// A reload of the sysctl configuration reverts the value
+	if err := ioutil.WriteFile(filepath.Join(root, IPv4Forward), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Check(); len(r) != 1 || r[0] != IPv4Forward {
+		t.Fatalf("Unexpected reverted parameters: %v", r)
+	}
+	checkValue(t, m, IPv4Forward, "1")
+
+	stop := m.Watch(10 * time.Millisecond)
+	defer stop()
+	if err := ioutil.WriteFile(filepath.Join(root, IPv4Forward), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if v, _ := m.Get(IPv4Forward); v == "1" {
+			break
+		}
+		if i == 100 {
+			t.Fatal("The watch did not set the reverted parameter again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
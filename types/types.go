@@ -29,6 +29,54 @@ type PortBinding struct {
 	Port     uint16
 	HostIP   net.IP
 	HostPort uint16
+	// HostIface, when set, selects the host address to listen on from the
+	// addresses of the named interface. It is mutually exclusive with HostIP.
+	HostIface string `json:",omitempty"`
+	// HostIPv6Only restricts the host side listener to IPv6. Together with an
+	// empty or unspecified HostIP it means all the IPv6 addresses of the host.
+	// The traffic is forwarded by the userland proxy, which it requires.
+	HostIPv6Only bool `json:",omitempty"`
+	// HostIPs, when set, publishes the container port on each of the listed
+	// host addresses. It is mutually exclusive with HostIP and HostIface.
+	HostIPs []net.IP `json:",omitempty"`
+	// PassSocket publishes the port by handing the bound host socket over
+	// to the container runtime when a container joins, instead of
+	// forwarding the host traffic to the container address.
+	PassSocket bool `json:",omitempty"`
+}
+
+// Validate checks the host side listening parameters of the binding
+// are consistent
+func (p *PortBinding) Validate() error {
+	if p.HostIface != "" && len(p.HostIP) != 0 && !p.HostIP.IsUnspecified() {
+		return BadRequestErrorf("host ip %s and host interface %s cannot be both specified for port %d", p.HostIP, p.HostIface, p.Port)
+	}
+	if p.HostIPv6Only && len(p.HostIP) != 0 && p.HostIP.To4() != nil {
+		return BadRequestErrorf("host ip %s is not an IPv6 address while IPv6 only binding was requested for port %d", p.HostIP, p.Port)
+	}
+	if len(p.HostIPs) == 0 {
+		return nil
+	}
+	if p.PassSocket {
+		return BadRequestErrorf("host socket passing cannot be requested with a list of host ips for port %d", p.Port)
+	}
+	if len(p.HostIP) != 0 || p.HostIface != "" {
+		return BadRequestErrorf("host ips cannot be specified together with a host ip or interface for port %d", p.Port)
+	}
+	if p.HostIPv6Only {
+		return BadRequestErrorf("IPv6 only binding cannot be requested with a list of host ips for port %d, list IPv6 addresses instead", p.Port)
+	}
+	seen := make(map[string]bool, len(p.HostIPs))
+	for _, ip := range p.HostIPs {
+		if len(ip) == 0 || ip.IsUnspecified() {
+			return BadRequestErrorf("invalid host ip %q in the host ips for port %d", ip, p.Port)
+		}
+		if seen[ip.String()] {
+			return BadRequestErrorf("host ip %s is listed more than once for port %d", ip, p.Port)
+		}
+		seen[ip.String()] = true
+	}
+	return nil
 }
 
 // HostAddr returns the host side transport address
@@ -58,14 +106,29 @@ func (p PortBinding) ContainerAddr() (net.Addr, error) {
 // GetCopy returns a copy of this PortBinding structure instance
 func (p *PortBinding) GetCopy() PortBinding {
 	return PortBinding{
-		Proto:    p.Proto,
-		IP:       GetIPCopy(p.IP),
-		Port:     p.Port,
-		HostIP:   GetIPCopy(p.HostIP),
-		HostPort: p.HostPort,
+		Proto:        p.Proto,
+		IP:           GetIPCopy(p.IP),
+		Port:         p.Port,
+		HostIP:       GetIPCopy(p.HostIP),
+		HostPort:     p.HostPort,
+		HostIface:    p.HostIface,
+		HostIPv6Only: p.HostIPv6Only,
+		HostIPs:      getIPListCopy(p.HostIPs),
+		PassSocket:   p.PassSocket,
 	}
 }
 
+func getIPListCopy(ips []net.IP) []net.IP {
+	if ips == nil {
+		return nil
+	}
+	c := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		c = append(c, GetIPCopy(ip))
+	}
+	return c
+}
+
 // Equal checks if this instance of PortBinding is equal to the passed one
 func (p *PortBinding) Equal(o *PortBinding) bool {
 	if p == o {
@@ -80,29 +143,72 @@ func (p *PortBinding) Equal(o *PortBinding) bool {
 		return false
 	}
 
-	if p.IP != nil {
-		if !p.IP.Equal(o.IP) {
-			return false
-		}
-	} else {
-		if o.IP != nil {
-			return false
-		}
+	if p.HostIface != o.HostIface || p.HostIPv6Only != o.HostIPv6Only || p.PassSocket != o.PassSocket {
+		return false
 	}
 
-	if p.HostIP != nil {
-		if !p.HostIP.Equal(o.HostIP) {
-			return false
-		}
-	} else {
-		if o.HostIP != nil {
+	if len(p.HostIPs) != len(o.HostIPs) {
+		return false
+	}
+	for i := range p.HostIPs {
+		if !p.HostIPs[i].Equal(o.HostIPs[i]) {
 			return false
 		}
 	}
 
+	// Missing and empty addresses are the same, as copies turn the former
+	// into the latter
+	if !p.IP.Equal(o.IP) || !p.HostIP.Equal(o.HostIP) {
+		return false
+	}
+
 	return true
 }
 
+// MaxERSPANSessionID is the highest ERSPAN session id
+const MaxERSPANSessionID = 1023
+
+// MirrorConfig describes where the traffic of an endpoint is mirrored to.
+// The traffic is either copied to a local interface or sent to a remote
+// collector inside an ERSPAN tunnel.
+type MirrorConfig struct {
+	// Interface is the host interface receiving the mirrored traffic
+	Interface string `json:",omitempty"`
+	// Remote is the address of the collector receiving the ERSPAN tunnel
+	Remote net.IP `json:",omitempty"`
+	// Local is the optional source address of the ERSPAN tunnel
+	Local net.IP `json:",omitempty"`
+	// SessionID is the ERSPAN session id carried in the tunnel header
+	SessionID uint16 `json:",omitempty"`
+}
+
+// Validate checks the mirror target is fully and uniquely specified
+func (m *MirrorConfig) Validate() error {
+	if (m.Interface == "") == (len(m.Remote) == 0) {
+		return BadRequestErrorf("traffic mirror needs either a target interface or a remote collector")
+	}
+	if m.Interface != "" && (len(m.Local) != 0 || m.SessionID != 0) {
+		return BadRequestErrorf("tunnel parameters cannot be set when mirroring to interface %s", m.Interface)
+	}
+	if len(m.Local) != 0 && (m.Local.To4() == nil) != (m.Remote.To4() == nil) {
+		return BadRequestErrorf("local address %s and remote collector %s are of different families", m.Local, m.Remote)
+	}
+	if m.SessionID > MaxERSPANSessionID {
+		return BadRequestErrorf("invalid ERSPAN session id %d, must not exceed %d", m.SessionID, MaxERSPANSessionID)
+	}
+	return nil
+}
+
+// GetCopy returns a copy of this MirrorConfig structure instance
+func (m *MirrorConfig) GetCopy() MirrorConfig {
+	return MirrorConfig{
+		Interface: m.Interface,
+		Remote:    GetIPCopy(m.Remote),
+		Local:     GetIPCopy(m.Local),
+		SessionID: m.SessionID,
+	}
+}
+
 // ErrInvalidProtocolBinding is returned when the port binding protocol is not valid.
 type ErrInvalidProtocolBinding string
 
@@ -184,6 +290,16 @@ func CompareIPNet(a, b *net.IPNet) bool {
 	return a.IP.Equal(b.IP) && bytes.Equal(a.Mask, b.Mask)
 }
 
+// ParseCIDR returns the *net.IPNet represented by the passed CIDR notation,
+// retaining the host part of the address
+func ParseCIDR(cidr string) (n *net.IPNet, e error) {
+	var i net.IP
+	if i, n, e = net.ParseCIDR(cidr); e == nil {
+		n.IP = i
+	}
+	return
+}
+
 const (
 	// NEXTHOP indicates a StaticRoute with an IP next hop.
 	NEXTHOP = iota
@@ -2,6 +2,7 @@ package types
 
 import (
 	"flag"
+	"net"
 	"testing"
 )
 
@@ -109,3 +110,105 @@ func TestErrorConstructors(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPortBindingValidate(t *testing.T) {
+	valid := []PortBinding{
+		{Proto: TCP, Port: 80},
+		{Proto: TCP, Port: 80, HostIface: "eth0"},
+		{Proto: TCP, Port: 80, HostIface: "eth0", HostIP: net.IPv4zero},
+		{Proto: TCP, Port: 80, HostIPv6Only: true},
+		{Proto: TCP, Port: 80, HostIPv6Only: true, HostIP: net.ParseIP("2001:db8::1")},
+		{Proto: TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")}},
+		{Proto: TCP, Port: 80, HostIP: net.ParseIP("10.0.0.1"), PassSocket: true},
+	}
+	for _, b := range valid {
+		if err := b.Validate(); err != nil {
+			t.Fatalf("Unexpected failure for %v: %v", b, err)
+		}
+	}
+
+	invalid := []PortBinding{
+		{Proto: TCP, Port: 80, HostIface: "eth0", HostIP: net.ParseIP("10.0.0.1")},
+		{Proto: TCP, Port: 80, HostIPv6Only: true, HostIP: net.ParseIP("10.0.0.1")},
+		{Proto: TCP, Port: 80, HostIP: net.ParseIP("10.0.0.1"), HostIPs: []net.IP{net.ParseIP("10.0.0.2")}},
+		{Proto: TCP, Port: 80, HostIface: "eth0", HostIPs: []net.IP{net.ParseIP("10.0.0.2")}},
+		{Proto: TCP, Port: 80, HostIPv6Only: true, HostIPs: []net.IP{net.ParseIP("2001:db8::1")}},
+		{Proto: TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1"), net.IPv4zero}},
+		{Proto: TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1")}},
+		{Proto: TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1")}, PassSocket: true},
+	}
+	for _, b := range invalid {
+		err := b.Validate()
+		if err == nil {
+			t.Fatalf("Expected failure for %v", b)
+		}
+		if _, ok := err.(BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for %v: %v", b, err)
+		}
+	}
+
+	b := PortBinding{Proto: TCP, IP: net.ParseIP("172.17.0.2"), Port: 80, HostIP: net.IPv6unspecified, HostIface: "eth0", HostIPv6Only: true}
+	c := b.GetCopy()
+	if !b.Equal(&c) {
+		t.Fatalf("Copy %v differs from %v", c, b)
+	}
+	c.HostIPv6Only = false
+	if b.Equal(&c) {
+		t.Fatalf("Expected %v to differ from %v", c, b)
+	}
+
+	b = PortBinding{Proto: UDP, Port: 53, PassSocket: true}
+	c = b.GetCopy()
+	if !b.Equal(&c) {
+		t.Fatalf("Copy %v differs from %v", c, b)
+	}
+	c.PassSocket = false
+	if b.Equal(&c) {
+		t.Fatalf("Expected %v to differ from %v", c, b)
+	}
+
+	b = PortBinding{Proto: TCP, Port: 80, HostIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}
+	c = b.GetCopy()
+	if !b.Equal(&c) {
+		t.Fatalf("Copy %v differs from %v", c, b)
+	}
+	c.HostIPs[1] = net.ParseIP("10.0.0.3")
+	if b.Equal(&c) || b.HostIPs[1].Equal(c.HostIPs[1]) {
+		t.Fatalf("Expected %v to differ from %v", c, b)
+	}
+}
+
+func TestMirrorConfigValidate(t *testing.T) {
+	valid := []MirrorConfig{
+		{Interface: "eth1"},
+		{Remote: net.ParseIP("10.0.0.2")},
+		{Remote: net.ParseIP("10.0.0.2"), Local: net.ParseIP("10.0.0.1"), SessionID: 12},
+		{Remote: net.ParseIP("2001:db8::2"), Local: net.ParseIP("2001:db8::1")},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Unexpected failure for %v: %v", m, err)
+		}
+		c := m.GetCopy()
+		if err := c.Validate(); err != nil {
+			t.Fatalf("Unexpected failure for copy of %v: %v", m, err)
+		}
+	}
+
+	invalid := []MirrorConfig{
+		{},
+		{Interface: "eth1", Remote: net.ParseIP("10.0.0.2")},
+		{Interface: "eth1", SessionID: 1},
+		{Remote: net.ParseIP("10.0.0.2"), Local: net.ParseIP("2001:db8::1")},
+		{Remote: net.ParseIP("10.0.0.2"), SessionID: MaxERSPANSessionID + 1},
+	}
+	for _, m := range invalid {
+		err := m.Validate()
+		if err == nil {
+			t.Fatalf("Expected failure for %v", m)
+		}
+		if _, ok := err.(BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for %v: %v", m, err)
+		}
+	}
+}
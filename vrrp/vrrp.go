@@ -0,0 +1,432 @@
+// Package vrrp implements a minimal VRRP version 3 speaker (RFC 5798) for
+// IPv4, so that the hosts sharing the gateway address of a network elect
+// the one holding it and hand it over when that host goes away.
+package vrrp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// ProtocolNumber is the IP protocol number of VRRP
+	ProtocolNumber = 112
+	// DefaultPriority is the priority of the routers which do not own the
+	// virtual addresses
+	DefaultPriority = 100
+	// OwnerPriority is the priority of the router owning the virtual
+	// addresses, which takes over as soon as it starts
+	OwnerPriority = 255
+	// DefaultAdvertInterval is the interval between the advertisements of
+	// the master
+	DefaultAdvertInterval = time.Second
+
+	version           = 3
+	typeAdvertisement = 1
+	headerLen         = 8
+	// maxAdvertInterval is the longest interval the 12 bits field of the
+	// advertisement carries, in centiseconds
+	maxAdvertInterval = 4095 * 10 * time.Millisecond
+	// ttl is the only TTL the advertisements are sent and accepted with
+	ttl = 255
+)
+
+// Group is the multicast group the advertisements are sent to
+var Group = net.IPv4(224, 0, 0, 18)
+
+// State is the state of the speaker for its virtual router
+type State int
+
+const (
+	// Init is the state of a speaker which is not running
+	Init State = iota
+	// Backup is the state of a speaker waiting for the master to go away
+	Backup
+	// Master is the state of the speaker holding the virtual addresses
+	Master
+)
+
+func (s State) String() string {
+	switch s {
+	case Init:
+		return "init"
+	case Backup:
+		return "backup"
+	case Master:
+		return "master"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// Config is the virtual router a speaker takes part in
+type Config struct {
+	// Interface is the name of the device the advertisements are spoken on
+	// and the virtual addresses are added to
+	Interface string
+	// RouterID identifies the virtual router among the others of the link
+	RouterID uint8
+	// Priority of the speaker in the election of the master, between 1 and
+	// OwnerPriority
+	Priority uint8
+	// Addresses are the virtual IPv4 addresses held by the master
+	Addresses []net.IP
+	// AdvertInterval is the interval between the advertisements of the
+	// master, DefaultAdvertInterval when zero
+	AdvertInterval time.Duration
+	// NoPreempt keeps a backup of higher priority from taking over from
+	// the master
+	NoPreempt bool
+}
+
+// Validate checks the configuration of the virtual router
+func (c *Config) Validate() error {
+	if c.Interface == "" {
+		return errors.New("vrrp interface is missing")
+	}
+	if c.RouterID == 0 {
+		return errors.New("vrrp router id must be between 1 and 255")
+	}
+	if c.Priority == 0 {
+		return errors.New("vrrp priority must be between 1 and 255")
+	}
+	if len(c.Addresses) == 0 {
+		return errors.New("vrrp virtual addresses are missing")
+	}
+	for _, ip := range c.Addresses {
+		if ip.To4() == nil {
+			return fmt.Errorf("vrrp virtual address %s is not an IPv4 address", ip)
+		}
+	}
+	if c.AdvertInterval < 0 || c.AdvertInterval > maxAdvertInterval {
+		return fmt.Errorf("vrrp advertisement interval %s is out of range", c.AdvertInterval)
+	}
+	return nil
+}
+
+func (c *Config) advertInterval() time.Duration {
+	if c.AdvertInterval == 0 {
+		return DefaultAdvertInterval
+	}
+	return c.AdvertInterval
+}
+
+// Handler is called with the state transitions of the speaker. It is
+// invoked from the speaker goroutine, a slow handler delays the
+// advertisements.
+type Handler func(from, to State)
+
+// advertisement is the VRRP message the master multicasts
+type advertisement struct {
+	RouterID  uint8
+	Priority  uint8
+	Interval  time.Duration
+	Addresses []net.IP
+}
+
+// marshal encodes the advertisement sent from src to the group
+func (a *advertisement) marshal(src net.IP) []byte {
+	b := make([]byte, headerLen+4*len(a.Addresses))
+	b[0] = version<<4 | typeAdvertisement
+	b[1] = a.RouterID
+	b[2] = a.Priority
+	b[3] = uint8(len(a.Addresses))
+	binary.BigEndian.PutUint16(b[4:], uint16(a.Interval/(10*time.Millisecond))&0x0fff)
+	for i, ip := range a.Addresses {
+		copy(b[headerLen+4*i:], ip.To4())
+	}
+	binary.BigEndian.PutUint16(b[6:], checksum(src, Group, b))
+	return b
+}
+
+// parseAdvertisement decodes the advertisement sent from src to the group
+func parseAdvertisement(b []byte, src net.IP) (*advertisement, error) {
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("vrrp message of %d bytes is too short", len(b))
+	}
+	if b[0]>>4 != version || b[0]&0x0f != typeAdvertisement {
+		return nil, fmt.Errorf("unsupported vrrp version %d or type %d", b[0]>>4, b[0]&0x0f)
+	}
+	count := int(b[3])
+	if len(b) < headerLen+4*count {
+		return nil, fmt.Errorf("vrrp message of %d bytes is too short for %d addresses", len(b), count)
+	}
+	b = b[:headerLen+4*count]
+	if checksum(src, Group, b) != 0 {
+		return nil, errors.New("invalid vrrp checksum")
+	}
+
+	a := &advertisement{
+		RouterID: b[1],
+		Priority: b[2],
+		Interval: time.Duration(binary.BigEndian.Uint16(b[4:])&0x0fff) * 10 * time.Millisecond,
+	}
+	for i := 0; i < count; i++ {
+		a.Addresses = append(a.Addresses, net.IP(append([]byte(nil), b[headerLen+4*i:headerLen+4*i+4]...)))
+	}
+	return a, nil
+}
+
+// checksum computes the internet checksum of the message over the IPv4
+// pseudo header, as VRRP version 3 does
+func checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To4())
+	add(dst.To4())
+	sum += ProtocolNumber + uint32(len(msg))
+	add(msg)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// transport sends and receives the advertisements of the link
+type transport interface {
+	// send multicasts the message to the group
+	send(msg []byte) error
+	// receive returns the next message and its source, until the transport
+	// is closed
+	receive() ([]byte, net.IP, error)
+	// localAddr is the source of the messages sent
+	localAddr() net.IP
+	close() error
+}
+
+// addresser adds and removes the virtual addresses on the interface
+type addresser interface {
+	acquire(ips []net.IP) error
+	release(ips []net.IP) error
+	close() error
+}
+
+type received struct {
+	adv *advertisement
+	src net.IP
+}
+
+// Speaker takes part in the election of the master of a virtual router
+type Speaker struct {
+	config  Config
+	conn    transport
+	addrs   addresser
+	handler Handler
+	state   State
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	// masterInterval is the advertisement interval of the current master,
+	// as learnt from its advertisements
+	masterInterval time.Duration
+	sync.Mutex
+}
+
+func newSpeaker(config Config, conn transport, addrs addresser, h Handler) *Speaker {
+	return &Speaker{
+		config:         config,
+		conn:           conn,
+		addrs:          addrs,
+		handler:        h,
+		stopCh:         make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		masterInterval: config.advertInterval(),
+	}
+}
+
+// State returns the current state of the speaker
+func (s *Speaker) State() State {
+	s.Lock()
+	defer s.Unlock()
+	return s.state
+}
+
+// Start runs the speaker until it is stopped
+func (s *Speaker) Start() {
+	recvCh := make(chan received)
+	go s.receive(recvCh)
+	go s.run(recvCh)
+}
+
+// Stop makes a master resign, with a priority 0 advertisement for the
+// backups to take over at once, and releases the virtual addresses
+func (s *Speaker) Stop() {
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+func (s *Speaker) receive(recvCh chan<- received) {
+	for {
+		msg, src, err := s.conn.receive()
+		if err != nil {
+			select {
+			case <-s.stopCh:
+			default:
+				logrus.Warnf("vrrp speaker of router %d on %s stopped receiving: %v", s.config.RouterID, s.config.Interface, err)
+			}
+			return
+		}
+		adv, err := parseAdvertisement(msg, src)
+		if err != nil {
+			logrus.Debugf("Dropping vrrp message from %s: %v", src, err)
+			continue
+		}
+		if adv.RouterID != s.config.RouterID {
+			continue
+		}
+		select {
+		case recvCh <- received{adv: adv, src: src}:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// skew delays the takeover of the backups of lower priority
+func (s *Speaker) skew() time.Duration {
+	return time.Duration(256-int(s.config.Priority)) * s.masterInterval / 256
+}
+
+func (s *Speaker) masterDownInterval() time.Duration {
+	return 3*s.masterInterval + s.skew()
+}
+
+func (s *Speaker) run(recvCh <-chan received) {
+	defer close(s.doneCh)
+
+	var timer *time.Timer
+	if s.config.Priority == OwnerPriority {
+		s.becomeMaster()
+		timer = time.NewTimer(s.config.advertInterval())
+	} else {
+		s.setState(Backup)
+		timer = time.NewTimer(s.masterDownInterval())
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			timer.Stop()
+			if s.State() == Master {
+				s.advertise(0)
+				s.releaseAddresses()
+			}
+			s.conn.close()
+			s.addrs.close()
+			s.setState(Init)
+			return
+		case <-timer.C:
+			if s.State() == Backup {
+				s.becomeMaster()
+			} else {
+				s.advertise(s.config.Priority)
+			}
+			timer.Reset(s.config.advertInterval())
+		case r := <-recvCh:
+			if d, ok := s.handleAdvertisement(r.adv, r.src); ok {
+				timer.Stop()
+				select {
+				case <-timer.C:
+				default:
+				}
+				timer.Reset(d)
+			}
+		}
+	}
+}
+
+// handleAdvertisement applies the advertisement to the state of the
+// speaker, and returns the delay to reset the timer to, if it changes
+func (s *Speaker) handleAdvertisement(adv *advertisement, src net.IP) (time.Duration, bool) {
+	if s.State() == Master {
+		if adv.Priority == 0 {
+			// Another speaker resigned, assert the mastership at once
+			s.advertise(s.config.Priority)
+			return s.config.advertInterval(), true
+		}
+		if adv.Priority > s.config.Priority ||
+			(adv.Priority == s.config.Priority && ipGreater(src, s.conn.localAddr())) {
+			s.masterInterval = adv.Interval
+			s.releaseAddresses()
+			s.setState(Backup)
+			return s.masterDownInterval(), true
+		}
+		return 0, false
+	}
+
+	if adv.Priority == 0 {
+		// The master resigned
+		return s.skew(), true
+	}
+	if s.config.NoPreempt || adv.Priority >= s.config.Priority {
+		s.masterInterval = adv.Interval
+		return s.masterDownInterval(), true
+	}
+	// A master of lower priority is preempted on timeout
+	return 0, false
+}
+
+func (s *Speaker) becomeMaster() {
+	s.advertise(s.config.Priority)
+	if err := s.addrs.acquire(s.config.Addresses); err != nil {
+		logrus.Warnf("vrrp router %d failed to take over %v on %s: %v", s.config.RouterID, s.config.Addresses, s.config.Interface, err)
+	}
+	s.setState(Master)
+}
+
+func (s *Speaker) releaseAddresses() {
+	if err := s.addrs.release(s.config.Addresses); err != nil {
+		logrus.Warnf("vrrp router %d failed to release %v on %s: %v", s.config.RouterID, s.config.Addresses, s.config.Interface, err)
+	}
+}
+
+func (s *Speaker) advertise(priority uint8) {
+	adv := &advertisement{
+		RouterID:  s.config.RouterID,
+		Priority:  priority,
+		Interval:  s.config.advertInterval(),
+		Addresses: s.config.Addresses,
+	}
+	if err := s.conn.send(adv.marshal(s.conn.localAddr())); err != nil {
+		logrus.Warnf("vrrp router %d failed to advertise on %s: %v", s.config.RouterID, s.config.Interface, err)
+	}
+}
+
+func (s *Speaker) setState(to State) {
+	s.Lock()
+	from := s.state
+	s.state = to
+	s.Unlock()
+
+	if from == to {
+		return
+	}
+	logrus.Infof("vrrp router %d on %s: %s -> %s", s.config.RouterID, s.config.Interface, from, to)
+	if s.handler != nil {
+		s.handler(from, to)
+	}
+}
+
+// ipGreater tells whether a is the greater address, which wins the
+// elections between speakers of equal priority
+func ipGreater(a, b net.IP) bool {
+	a4, b4 := a.To4(), b.To4()
+	for i := range a4 {
+		if a4[i] != b4[i] {
+			return a4[i] > b4[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// pollInterval bounds how long a receive blocks, so that a closed socket is
+// noticed: closing the descriptor does not wake up a blocked recvfrom
+var pollInterval = syscall.Timeval{Usec: 500000}
+
+// rawTransport speaks VRRP on a raw IPv4 socket bound to the interface
+type rawTransport struct {
+	fd     int
+	src    net.IP
+	closed bool
+	sync.Mutex
+}
+
+func newRawTransport(ifi *net.Interface, vips []net.IP) (*rawTransport, error) {
+	src, err := sourceAddr(ifi, vips)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, ProtocolNumber)
+	if err != nil {
+		return nil, fmt.Errorf("could not open vrrp socket: %v", err)
+	}
+
+	if err := setupSocket(fd, ifi); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not set up vrrp socket on %s: %v", ifi.Name, err)
+	}
+
+	return &rawTransport{fd: fd, src: src}, nil
+}
+
+// setupSocket binds the socket to the interface, joins the group on it and
+// sets the TTL the advertisements are sent with
+func setupSocket(fd int, ifi *net.Interface) error {
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifi.Name); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 0); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptIPMreqn(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &syscall.IPMreqn{Ifindex: int32(ifi.Index)}); err != nil {
+		return err
+	}
+	mreq := &syscall.IPMreqn{Ifindex: int32(ifi.Index)}
+	copy(mreq.Multiaddr[:], Group.To4())
+	if err := syscall.SetsockoptIPMreqn(fd, syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq); err != nil {
+		return err
+	}
+	return syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &pollInterval)
+}
+
+// sourceAddr returns the first IPv4 address of the interface which is not
+// one of the virtual addresses, as the advertisements are sent from it
+func sourceAddr(ifi *net.Interface, vips []net.IP) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || containsIP(vips, ipNet.IP) {
+			continue
+		}
+		return ipNet.IP.To4(), nil
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address to send vrrp advertisements from", ifi.Name)
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *rawTransport) isClosed() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.closed
+}
+
+func (t *rawTransport) send(msg []byte) error {
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], Group.To4())
+	return syscall.Sendto(t.fd, msg, 0, sa)
+}
+
+// receive returns the payload of the next packet sent with the expected
+// TTL. The raw socket hands over the packets with their IP header.
+func (t *rawTransport) receive() ([]byte, net.IP, error) {
+	buf := make([]byte, 1500)
+	for {
+		if t.isClosed() {
+			return nil, nil, fmt.Errorf("vrrp socket is closed")
+		}
+		n, _, err := syscall.Recvfrom(t.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return nil, nil, err
+		}
+		if n < 20 {
+			continue
+		}
+		ihl := int(buf[0]&0x0f) * 4
+		if ihl < 20 || n < ihl {
+			continue
+		}
+		if buf[8] != ttl {
+			logrus.Debugf("Dropping vrrp packet with ttl %d", buf[8])
+			continue
+		}
+		src := net.IP(append([]byte(nil), buf[12:16]...))
+		return append([]byte(nil), buf[ihl:n]...), src, nil
+	}
+}
+
+func (t *rawTransport) localAddr() net.IP {
+	return t.src
+}
+
+func (t *rawTransport) close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	return syscall.Close(t.fd)
+}
+
+// linkAddresser holds the virtual addresses as host addresses of the
+// interface, and announces them with gratuitous ARPs when taking over. The
+// speaker goroutine may run on any thread, the interface is looked up in
+// the namespace the speaker was created in.
+type linkAddresser struct {
+	name string
+	ns   netns.NsHandle
+}
+
+func newLinkAddresser(name string) (*linkAddresser, error) {
+	ns, err := netns.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the network namespace of %s: %v", name, err)
+	}
+	return &linkAddresser{name: name, ns: ns}, nil
+}
+
+// invoke runs fn in the namespace of the interface
+func (a *linkAddresser) invoke(fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origns.Close()
+
+	if err := netns.Set(a.ns); err != nil {
+		return err
+	}
+	defer netns.Set(origns)
+
+	return fn()
+}
+
+func (a *linkAddresser) acquire(ips []net.IP) error {
+	return a.invoke(func() error {
+		link, err := netlink.LinkByName(a.name)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}}
+			if err := netlink.AddrAdd(link, addr); err != nil && err != syscall.EEXIST {
+				return err
+			}
+			if err := netutils.SendGratuitousARP(a.name, ip); err != nil {
+				logrus.Warnf("Failed to announce %s on %s: %v", ip, a.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (a *linkAddresser) release(ips []net.IP) error {
+	return a.invoke(func() error {
+		link, err := netlink.LinkByName(a.name)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}}
+			if err := netlink.AddrDel(link, addr); err != nil && err != syscall.EADDRNOTAVAIL {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (a *linkAddresser) close() error {
+	return a.ns.Close()
+}
+
+// New returns a speaker of the virtual router on the interface of the
+// configuration. It starts as a backup, or as the master for the owner
+// priority, once started.
+func New(config Config, h Handler) (*Speaker, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	ifi, err := net.InterfaceByName(config.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %v", config.Interface, err)
+	}
+
+	addrs, err := newLinkAddresser(config.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newRawTransport(ifi, config.Addresses)
+	if err != nil {
+		addrs.close()
+		return nil, err
+	}
+
+	return newSpeaker(config, conn, addrs, h), nil
+}
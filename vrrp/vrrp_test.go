@@ -0,0 +1,247 @@
+package vrrp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdvertisement(t *testing.T) {
+	src := net.ParseIP("10.0.0.2")
+	adv := &advertisement{
+		RouterID:  7,
+		Priority:  150,
+		Interval:  250 * time.Millisecond,
+		Addresses: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.254")},
+	}
+	b := adv.marshal(src)
+	if len(b) != headerLen+8 {
+		t.Fatalf("Unexpected advertisement length %d", len(b))
+	}
+
+	got, err := parseAdvertisement(b, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RouterID != adv.RouterID || got.Priority != adv.Priority || got.Interval != adv.Interval ||
+		len(got.Addresses) != 2 || !got.Addresses[1].Equal(adv.Addresses[1]) {
+		t.Fatalf("Unexpected advertisement %+v", got)
+	}
+
+	// The checksum covers the source address
+	if _, err := parseAdvertisement(b, net.ParseIP("10.0.0.3")); err == nil {
+		t.Fatal("Advertisement from another source was accepted")
+	}
+	b[2]++
+	if _, err := parseAdvertisement(b, src); err == nil {
+		t.Fatal("Corrupt advertisement was accepted")
+	}
+	if _, err := parseAdvertisement(b[:headerLen+2], src); err == nil {
+		t.Fatal("Truncated advertisement was accepted")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Interface: "br0", RouterID: 1, Priority: DefaultPriority, Addresses: []net.IP{net.ParseIP("10.0.0.1")}}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Config{
+		{RouterID: 1, Priority: 1, Addresses: valid.Addresses},
+		{Interface: "br0", Priority: 1, Addresses: valid.Addresses},
+		{Interface: "br0", RouterID: 1, Addresses: valid.Addresses},
+		{Interface: "br0", RouterID: 1, Priority: 1},
+		{Interface: "br0", RouterID: 1, Priority: 1, Addresses: []net.IP{net.ParseIP("fe90::1")}},
+		{Interface: "br0", RouterID: 1, Priority: 1, Addresses: valid.Addresses, AdvertInterval: time.Minute},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Invalid configuration %+v was accepted", c)
+		}
+	}
+}
+
+// hub relays the messages sent by each transport to the others
+type hub struct {
+	ports []*hubPort
+	sync.Mutex
+}
+
+type hubPort struct {
+	hub    *hub
+	src    net.IP
+	inCh   chan []byte
+	srcCh  chan net.IP
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+func (h *hub) port(src string) *hubPort {
+	h.Lock()
+	defer h.Unlock()
+	p := &hubPort{hub: h, src: net.ParseIP(src), inCh: make(chan []byte, 64), srcCh: make(chan net.IP, 64), doneCh: make(chan struct{})}
+	h.ports = append(h.ports, p)
+	return p
+}
+
+func (p *hubPort) send(msg []byte) error {
+	p.hub.Lock()
+	defer p.hub.Unlock()
+	for _, o := range p.hub.ports {
+		if o == p {
+			continue
+		}
+		select {
+		case <-o.doneCh:
+		default:
+			o.inCh <- msg
+			o.srcCh <- p.src
+		}
+	}
+	return nil
+}
+
+func (p *hubPort) receive() ([]byte, net.IP, error) {
+	select {
+	case msg := <-p.inCh:
+		return msg, <-p.srcCh, nil
+	case <-p.doneCh:
+		return nil, nil, errors.New("closed")
+	}
+}
+
+func (p *hubPort) localAddr() net.IP {
+	return p.src
+}
+
+func (p *hubPort) close() error {
+	p.hub.Lock()
+	defer p.hub.Unlock()
+	p.once.Do(func() { close(p.doneCh) })
+	return nil
+}
+
+type fakeAddresser struct {
+	held bool
+	sync.Mutex
+}
+
+func (a *fakeAddresser) acquire(ips []net.IP) error {
+	a.Lock()
+	defer a.Unlock()
+	a.held = true
+	return nil
+}
+
+func (a *fakeAddresser) release(ips []net.IP) error {
+	a.Lock()
+	defer a.Unlock()
+	a.held = false
+	return nil
+}
+
+func (a *fakeAddresser) close() error {
+	return nil
+}
+
+func (a *fakeAddresser) isHeld() bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.held
+}
+
+func waitState(t *testing.T, s *Speaker, want State) {
+	deadline := time.Now().Add(2 * time.Second)
+	for s.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Speaker %s did not become %s, it is %s", s.conn.localAddr(), want, s.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElection(t *testing.T) {
+	var (
+		h           hub
+		transitions = make(chan State, 16)
+		vip         = []net.IP{net.ParseIP("10.0.0.1")}
+	)
+	config := func(priority uint8) Config {
+		return Config{Interface: "br0", RouterID: 5, Priority: priority, Addresses: vip, AdvertInterval: 20 * time.Millisecond}
+	}
+
+	lowAddrs := &fakeAddresser{}
+	low := newSpeaker(config(DefaultPriority), h.port("10.0.0.2"), lowAddrs, func(from, to State) {
+		transitions <- to
+	})
+	highAddrs := &fakeAddresser{}
+	high := newSpeaker(config(200), h.port("10.0.0.3"), highAddrs, nil)
+
+	high.Start()
+	waitState(t, high, Master)
+	low.Start()
+	time.Sleep(100 * time.Millisecond)
+	if low.State() != Backup || lowAddrs.isHeld() || !highAddrs.isHeld() {
+		t.Fatalf("Unexpected election outcome: low %s holding %v, high %s holding %v",
+			low.State(), lowAddrs.isHeld(), high.State(), highAddrs.isHeld())
+	}
+
+	// The resigning master hands over at once
+	high.Stop()
+	if high.State() != Init || highAddrs.isHeld() {
+		t.Fatal("Stopped master still holds the virtual addresses")
+	}
+	waitState(t, low, Master)
+	if !lowAddrs.isHeld() {
+		t.Fatal("New master does not hold the virtual addresses")
+	}
+
+	// A speaker of higher priority preempts the master
+	high = newSpeaker(config(200), h.port("10.0.0.4"), highAddrs, nil)
+	high.Start()
+	waitState(t, high, Master)
+	waitState(t, low, Backup)
+	if lowAddrs.isHeld() {
+		t.Fatal("Preempted master still holds the virtual addresses")
+	}
+
+	high.Stop()
+	low.Stop()
+
+	var seen []State
+	for len(transitions) > 0 {
+		seen = append(seen, <-transitions)
+	}
+	expected := []State{Backup, Master, Backup, Init}
+	if len(seen) != len(expected) {
+		t.Fatalf("Unexpected transitions %v", seen)
+	}
+	for i := range seen {
+		if seen[i] != expected[i] {
+			t.Fatalf("Unexpected transitions %v", seen)
+		}
+	}
+}
+
+func TestNoPreempt(t *testing.T) {
+	var (
+		h   hub
+		vip = []net.IP{net.ParseIP("10.0.0.1")}
+	)
+	first := newSpeaker(Config{Interface: "br0", RouterID: 1, Priority: 50, Addresses: vip, AdvertInterval: 20 * time.Millisecond},
+		h.port("10.0.0.2"), &fakeAddresser{}, nil)
+	first.Start()
+	defer first.Stop()
+	waitState(t, first, Master)
+
+	second := newSpeaker(Config{Interface: "br0", RouterID: 1, Priority: 200, Addresses: vip, AdvertInterval: 20 * time.Millisecond, NoPreempt: true},
+		h.port("10.0.0.3"), &fakeAddresser{}, nil)
+	second.Start()
+	defer second.Stop()
+
+	time.Sleep(200 * time.Millisecond)
+	if first.State() != Master || second.State() != Backup {
+		t.Fatalf("Master was preempted: first %s, second %s", first.State(), second.State())
+	}
+}
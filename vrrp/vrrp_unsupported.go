@@ -0,0 +1,10 @@
+// +build !linux
+
+package vrrp
+
+import "errors"
+
+// New returns an error on platforms without raw IP sockets support
+func New(config Config, h Handler) (*Speaker, error) {
+	return nil, errors.New("vrrp is not supported on this platform")
+}
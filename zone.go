@@ -0,0 +1,70 @@
+package libnetwork
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/dnszone"
+)
+
+func (n *network) Zone(domain string) *dnszone.Zone {
+	n.Lock()
+	defer n.Unlock()
+
+	// The records named after the network are the zone origin
+	var recs []dnszone.Record
+	suffix := "." + n.name
+	for _, m := range []svcMap{n.svcRecords, n.services} {
+		for name, ips := range m {
+			if strings.HasSuffix(name, suffix) {
+				continue
+			}
+			for _, ip := range ips {
+				recs = append(recs, dnszone.Record{Name: name, IP: ip})
+			}
+		}
+	}
+
+	z := dnszone.NewZone(n.name+"."+strings.TrimSuffix(domain, "."), 0, recs)
+
+	// The secondaries transfer the zone again once its serial increases.
+	// The serial starts from the time of the first export, for a restarted
+	// daemon to keep increasing it.
+	h := sha256.New()
+	for _, r := range z.Records {
+		h.Write([]byte(r.Name + " " + r.IP.String() + "\n"))
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != n.zoneDigest {
+		if n.zoneSerial == 0 {
+			n.zoneSerial = uint32(time.Now().Unix())
+		} else {
+			n.zoneSerial++
+		}
+		n.zoneDigest = digest
+	}
+	z.Serial = n.zoneSerial
+
+	return z
+}
+
+func (c *controller) ServeZoneTransfer(l net.Listener, domain string) error {
+	suffix := "." + strings.ToLower(strings.TrimSuffix(domain, ".")) + "."
+	return dnszone.ServeAXFR(l, func(origin string) *dnszone.Zone {
+		if !strings.HasSuffix(origin, suffix) {
+			return nil
+		}
+		name := strings.TrimSuffix(origin, suffix)
+		var zone *dnszone.Zone
+		c.WalkNetworks(func(nw Network) bool {
+			if strings.ToLower(nw.Name()) == name && !nw.ConfigOnly() {
+				zone = nw.Zone(domain)
+				return true
+			}
+			return false
+		})
+		return zone
+	})
+}